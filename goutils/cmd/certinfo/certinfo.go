@@ -1,81 +1,273 @@
-// certinfo - show TLS certificate info for a host or PEM file
+// certinfo - inspect X.509 certificates, CSRs, CRLs and PKCS#12 bundles, and
+// issue certificates from a local development CA
+//
+// Usage:
+//
+//	certinfo [OPTIONS] <host[:port]|file>...
+//	certinfo mkcert [-ca DIR] [-days N] [-client] [-o DIR] NAME...
+//
+// Files may be PEM or DER and contain certificates, CSRs, CRLs, private keys
+// or a PKCS#12 (.p12/.pfx) bundle.
+//
+// Options:
+//
+//	-pass PW        Password for PKCS#12 bundles (or $CERTINFO_PASS)
+//	-verify         Validate file chains as well as host chains
+//	-roots FILE     Trusted roots (file or directory) instead of the system pool
+//	-host NAME      Hostname to check the leaf against
+//	-starttls PROTO smtp, imap, pop3, ftp or postgres
+//	-v              Show extensions, fingerprints, SCTs and CRL entries
+//	-j              JSON output
+//
+// mkcert options:
+//
+//	-ca DIR         CA directory (default: $CAROOT or ~/.local/share/certinfo)
+//	-days N         Leaf validity in days (default: 398)
+//	-client         Also allow TLS client authentication
+//	-o DIR          Directory for the issued certificate and key (default: .)
+//
+// Examples:
+//
+//	certinfo example.com
+//	certinfo -v chain.pem
+//	certinfo -pass secret bundle.p12
+//	certinfo -verify -roots ca.pem -host api.internal server.crt
+//	certinfo mkcert localhost 127.0.0.1 ::1
 package main
 
 import (
+	"crypto"
 	"crypto/tls"
 	"crypto/x509"
-	"encoding/pem"
+	"encoding/json"
+	"flag"
 	"fmt"
 	"net"
 	"os"
+	"path/filepath"
 	"strings"
 	"time"
+
+	"goutils/internal/x509util"
+)
+
+var (
+	password  = flag.String("pass", os.Getenv("CERTINFO_PASS"), "PKCS#12 password")
+	verify    = flag.Bool("verify", false, "validate certificate chains from files")
+	rootsPath = flag.String("roots", "", "trusted roots file or directory")
+	hostname  = flag.String("host", "", "hostname to verify")
+	starttls  = flag.String("starttls", "", "STARTTLS protocol")
+	verbose   = flag.Bool("v", false, "verbose")
+	asJSON    = flag.Bool("j", false, "JSON output")
 )
 
-func printCert(cert *x509.Certificate, idx int) {
-	now := time.Now()
-	daysLeft := int(cert.NotAfter.Sub(now).Hours() / 24)
-	status := "✓ valid"
-	if now.After(cert.NotAfter) { status = "✗ EXPIRED" }
-	if now.Before(cert.NotBefore) { status = "⚠ not yet valid" }
-	expireStr := ""
-	if daysLeft < 30 { expireStr = fmt.Sprintf(" ⚠ EXPIRING IN %d DAYS", daysLeft) }
+type output struct {
+	Source       string                `json:"source"`
+	Certificates []*x509util.Report    `json:"certificates,omitempty"`
+	Requests     int                   `json:"requests,omitempty"`
+	CRLs         int                   `json:"crls,omitempty"`
+	Keys         []string              `json:"keys,omitempty"`
+	Validation   *x509util.ChainResult `json:"validation,omitempty"`
+}
+
+func main() {
+	if len(os.Args) > 1 && os.Args[1] == "mkcert" {
+		mkcert(os.Args[2:])
+		return
+	}
+	flag.Parse()
+	if flag.NArg() == 0 {
+		fmt.Fprintln(os.Stderr, "usage: certinfo [OPTIONS] <host[:port]|file>...")
+		fmt.Fprintln(os.Stderr, "       certinfo mkcert [-ca DIR] [-days N] [-client] [-o DIR] NAME...")
+		os.Exit(1)
+	}
+
+	var roots *x509.CertPool
+	if *rootsPath != "" {
+		var err error
+		if roots, err = x509util.LoadRoots(*rootsPath); err != nil {
+			fmt.Fprintln(os.Stderr, "certinfo:", err)
+			os.Exit(1)
+		}
+	}
+
+	// Everything is loaded first, so that a key can be matched with its
+	// certificate when they are in separate files, as mkcert writes them.
+	type input struct {
+		target   string
+		b        *x509util.Bundle
+		validate bool
+		name     string
+	}
+	var inputs []input
+	var certs []sourceCert
+	failed := false
+	for _, target := range flag.Args() {
+		b, validate, name, err := load(target)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "certinfo:", err)
+			failed = true
+			continue
+		}
+		inputs = append(inputs, input{target, b, validate, name})
+		for i, c := range b.Certificates {
+			certs = append(certs, sourceCert{target, i + 1, c})
+		}
+	}
 
-	fmt.Printf("─── Certificate #%d ────────────────────────────\n", idx+1)
-	fmt.Printf("Subject      : %s\n", cert.Subject.CommonName)
-	fmt.Printf("Issuer       : %s\n", cert.Issuer.CommonName)
-	fmt.Printf("Status       : %s%s\n", status, expireStr)
-	fmt.Printf("Valid From   : %s\n", cert.NotBefore.Format("2006-01-02 15:04:05 MST"))
-	fmt.Printf("Valid Until  : %s\n", cert.NotAfter.Format("2006-01-02 15:04:05 MST"))
-	fmt.Printf("Days Left    : %d\n", daysLeft)
-	fmt.Printf("Serial       : %s\n", cert.SerialNumber)
-	fmt.Printf("Sig Algo     : %s\n", cert.SignatureAlgorithm)
-	if len(cert.DNSNames) > 0 { fmt.Printf("SANs (DNS)   : %s\n", strings.Join(cert.DNSNames, ", ")) }
-	if len(cert.IPAddresses) > 0 {
-		ips := make([]string, len(cert.IPAddresses))
-		for i, ip := range cert.IPAddresses { ips[i] = ip.String() }
-		fmt.Printf("SANs (IP)    : %s\n", strings.Join(ips, ", "))
-	}
-	fmt.Println()
+	for _, in := range inputs {
+		b := in.b
+		out := &output{Source: in.target, Requests: len(b.Requests), CRLs: len(b.CRLs)}
+		for _, c := range b.Certificates {
+			out.Certificates = append(out.Certificates, x509util.Inspect(c))
+		}
+		for _, k := range b.Keys {
+			out.Keys = append(out.Keys, describeKey(k, in.target, certs))
+		}
+		if in.validate && len(b.Certificates) > 0 {
+			out.Validation = x509util.VerifyChain(b.Certificates, x509util.VerifyOptions{Roots: roots, Hostname: in.name})
+			if !out.Validation.Valid || !out.Validation.HostnameOK {
+				failed = true
+			}
+		}
+		if *asJSON {
+			j, _ := json.MarshalIndent(out, "", "  ")
+			fmt.Println(string(j))
+			continue
+		}
+		printOutput(out, b)
+	}
+	if failed {
+		os.Exit(1)
+	}
 }
 
-func fromHost(host string) {
-	if !strings.Contains(host, ":") { host += ":443" }
-	conn, err := tls.Dial("tcp", host, &tls.Config{InsecureSkipVerify: true})
-	if err != nil { fmt.Fprintln(os.Stderr, "certinfo:", err); os.Exit(1) }
+// load reads a file if target names one, and otherwise connects to it as
+// host[:port]. It also reports whether the chain should be validated and
+// against which hostname.
+func load(target string) (*x509util.Bundle, bool, string, error) {
+	if _, err := os.Stat(target); err == nil {
+		b, err := x509util.ParseFile(target, *password)
+		return b, *verify || *rootsPath != "" || *hostname != "", *hostname, err
+	}
+	host, port, err := net.SplitHostPort(target)
+	if err != nil {
+		host, port = target, x509util.DefaultPort(*starttls)
+	}
+	name := host
+	if *hostname != "" {
+		name = *hostname
+	}
+	conn, err := x509util.Dial(net.JoinHostPort(host, port), *starttls,
+		&tls.Config{InsecureSkipVerify: true, ServerName: name}, 10*time.Second)
+	if err != nil {
+		return nil, false, "", err
+	}
 	defer conn.Close()
-	for i, cert := range conn.ConnectionState().PeerCertificates { printCert(cert, i) }
+	return &x509util.Bundle{Certificates: conn.ConnectionState().PeerCertificates}, true, name, nil
 }
 
-func fromFile(path string) {
-	data, err := os.ReadFile(path); if err != nil { fmt.Fprintln(os.Stderr, err); os.Exit(1) }
-	var idx int
-	for {
-		var block *pem.Block
-		block, data = pem.Decode(data)
-		if block == nil { break }
-		if block.Type != "CERTIFICATE" { continue }
-		cert, err := x509.ParseCertificate(block.Bytes)
-		if err != nil { fmt.Fprintln(os.Stderr, err); continue }
-		printCert(cert, idx); idx++
+func printOutput(out *output, b *x509util.Bundle) {
+	for i, r := range out.Certificates {
+		fmt.Printf("─── Certificate #%d ────────────────────────────\n", i+1)
+		r.Print(os.Stdout, "", *verbose)
+		fmt.Println()
+	}
+	for i, req := range b.Requests {
+		fmt.Printf("─── Certificate Request #%d ────────────────────\n", i+1)
+		x509util.PrintRequest(os.Stdout, req, "")
+		fmt.Println()
+	}
+	for i, crl := range b.CRLs {
+		fmt.Printf("─── CRL #%d ────────────────────────────────────\n", i+1)
+		x509util.PrintCRL(os.Stdout, crl, "", *verbose)
+		fmt.Println()
+	}
+	for i, k := range out.Keys {
+		fmt.Printf("─── Private Key #%d ────────────────────────────\n", i+1)
+		fmt.Printf("Key            : %s\n\n", k)
+	}
+	if out.Validation != nil {
+		fmt.Println("─── Validation ─────────────────────────────────")
+		out.Validation.Print(os.Stdout, "")
+		fmt.Println()
 	}
 }
 
-func main() {
-	if len(os.Args) < 2 {
-		fmt.Fprintln(os.Stderr, "usage: certinfo <host[:port]|cert.pem>")
+// sourceCert is a certificate with the input it came from and its
+// number there.
+type sourceCert struct {
+	source string
+	n      int
+	cert   *x509.Certificate
+}
+
+// describeKey names a private key's type and, if one of certs holds the
+// matching public key, which certificate it belongs to, looking first in
+// source, the input the key came from.
+func describeKey(k crypto.PrivateKey, source string, certs []sourceCert) string {
+	signer, ok := k.(crypto.Signer)
+	if !ok {
+		return fmt.Sprintf("%T", k)
+	}
+	pub := signer.Public()
+	desc := x509util.DescribePublicKey(pub)
+	matches := func(c *x509.Certificate) bool {
+		eq, ok := c.PublicKey.(interface{ Equal(crypto.PublicKey) bool })
+		return ok && eq.Equal(pub)
+	}
+	for _, c := range certs {
+		if c.source == source && matches(c.cert) {
+			return fmt.Sprintf("%s, matches certificate #%d (%s)", desc, c.n, c.cert.Subject.CommonName)
+		}
+	}
+	for _, c := range certs {
+		if c.source != source && matches(c.cert) {
+			return fmt.Sprintf("%s, matches certificate #%d in %s (%s)", desc, c.n, c.source, c.cert.Subject.CommonName)
+		}
+	}
+	return desc + ", matches no certificate"
+}
+
+func mkcert(args []string) {
+	fs := flag.NewFlagSet("mkcert", flag.ExitOnError)
+	caDir := fs.String("ca", x509util.DefaultCADir(), "CA directory")
+	days := fs.Int("days", x509util.MaxLeafDays, "leaf validity in days")
+	client := fs.Bool("client", false, "allow client authentication")
+	outDir := fs.String("o", ".", "output directory")
+	fs.Parse(args)
+
+	ca, created, err := x509util.LoadOrCreateCA(*caDir)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "certinfo mkcert:", err)
+		os.Exit(1)
+	}
+	caPath := filepath.Join(*caDir, x509util.CACertFile)
+	if created {
+		fmt.Printf("Created a new local CA at %s\n", caPath)
+	}
+	if fs.NArg() == 0 {
+		fmt.Printf("CA certificate: %s\n", caPath)
+		return
+	}
+
+	cert, key, err := ca.Issue(fs.Args(), time.Duration(*days)*24*time.Hour, *client)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "certinfo mkcert:", err)
 		os.Exit(1)
 	}
-	target := os.Args[1]
-	if strings.HasSuffix(target, ".pem") || strings.HasSuffix(target, ".crt") || strings.HasSuffix(target, ".cer") {
-		fromFile(target)
-	} else {
-		// Check if it's a valid hostname/IP (not a file)
-		if _, err := os.Stat(target); err == nil { fromFile(target); return }
-		// Validate as host
-		host, _, err := net.SplitHostPort(target)
-		if err != nil { host = target }
-		_ = host
-		fromHost(target)
+	certFile, keyFile := x509util.LeafFileNames(fs.Args())
+	certFile, keyFile = filepath.Join(*outDir, certFile), filepath.Join(*outDir, keyFile)
+	if err := x509util.WriteCertificate(certFile, cert.Raw); err != nil {
+		fmt.Fprintln(os.Stderr, "certinfo mkcert:", err)
+		os.Exit(1)
+	}
+	if err := x509util.WritePrivateKey(keyFile, key); err != nil {
+		fmt.Fprintln(os.Stderr, "certinfo mkcert:", err)
+		os.Exit(1)
 	}
+	fmt.Printf("Certificate for %s\n", strings.Join(fs.Args(), ", "))
+	fmt.Printf("  certificate: %s\n  key:         %s\n  expires:     %s\n",
+		certFile, keyFile, cert.NotAfter.Format("2006-01-02"))
+	fmt.Printf("Trust it with: certinfo -roots %s -verify %s\n", caPath, certFile)
 }
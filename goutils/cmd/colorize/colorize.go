@@ -1,4 +1,9 @@
 // colorize - highlight pattern matches in stdin with ANSI colours
+//
+// Colour is on when standard output is a terminal, unless NO_COLOR is
+// set; --color=always|never overrides that. Besides the names, a colour
+// may be #rrggbb or a 256-colour index, downgraded to what the terminal
+// supports.
 package main
 
 import (
@@ -7,37 +12,43 @@ import (
 	"os"
 	"regexp"
 	"strings"
+
+	"goutils/lib/term"
 )
 
 var colors = map[string]string{
-	"red":     "\033[31m", "green":  "\033[32m", "yellow": "\033[33m",
-	"blue":    "\033[34m", "magenta":"\033[35m", "cyan":   "\033[36m",
-	"white":   "\033[37m", "bold":   "\033[1m",  "reset":  "\033[0m",
+	"red":   term.Red, "green": term.Green, "yellow": term.Yellow,
+	"blue":  term.Blue, "magenta": term.Magenta, "cyan": term.Cyan,
+	"white": term.White, "bold": term.Bold,
 }
 
 func usage() {
-	fmt.Fprintln(os.Stderr, "usage: colorize <pattern> [color] [file...]")
-	fmt.Fprintln(os.Stderr, "  colors: red green yellow blue magenta cyan white bold")
+	fmt.Fprintln(os.Stderr, "usage: colorize [--color=WHEN] <pattern> [color] [file...]")
+	fmt.Fprintln(os.Stderr, "  colors: red green yellow blue magenta cyan white bold, #rrggbb or 0-255")
 	os.Exit(1)
 }
 
 func main() {
-	if len(os.Args) < 2 { usage() }
-	pattern := os.Args[1]
-	color := "red"
-	fileStart := 2
-	if len(os.Args) > 2 {
-		if _, ok := colors[os.Args[2]]; ok { color = os.Args[2]; fileStart = 3 }
+	mode, args, err := term.ColorArgs(os.Args[1:])
+	if err != nil { fmt.Fprintf(os.Stderr, "colorize: %v\n", err); os.Exit(2) }
+	if len(args) < 1 { usage() }
+	pal := mode.Profile(os.Stdout)
+	pattern := args[0]
+	sgr := term.Red
+	fileStart := 1
+	if len(args) > 1 {
+		if c, ok := colors[args[1]]; ok {
+			sgr, fileStart = c, 2
+		} else if c, err := term.ParseColor(args[1]); err == nil && !fileExists(args[1]) {
+			sgr, fileStart = pal.FG(c), 2
+		}
 	}
 	re, err := regexp.Compile(pattern)
 	if err != nil { fmt.Fprintf(os.Stderr, "colorize: invalid pattern: %v\n", err); os.Exit(1) }
 
-	ansi := colors[color]
-	reset := colors["reset"]
-
 	highlight := func(line string) string {
 		return re.ReplaceAllStringFunc(line, func(m string) string {
-			return ansi + m + reset
+			return pal.Paint(m, sgr)
 		})
 	}
 
@@ -46,14 +57,19 @@ func main() {
 		for sc.Scan() { fmt.Println(highlight(sc.Text())) }
 	}
 
-	if fileStart >= len(os.Args) {
+	if fileStart >= len(args) {
 		process(os.Stdin)
 		return
 	}
-	for _, f := range os.Args[fileStart:] {
+	for _, f := range args[fileStart:] {
 		_ = strings.TrimSpace(f)
 		fh, err := os.Open(f)
 		if err != nil { fmt.Fprintln(os.Stderr, err); continue }
 		process(fh); fh.Close()
 	}
 }
+
+func fileExists(name string) bool {
+	_, err := os.Stat(name)
+	return err == nil
+}
@@ -0,0 +1,219 @@
+// confconv - Convert configuration files between formats.
+//
+// Usage:
+//
+//	confconv [OPTIONS] [FILE]
+//	confconv get [OPTIONS] PATH [FILE]
+//	confconv formats
+//
+// Reads FILE (or standard input) and writes it in another format. The
+// formats are JSON, YAML, TOML, INI, Java .properties, .env, XML and
+// HCL-lite. The input format comes from the file extension and the output
+// format from the -o file's extension; otherwise JSON is written.
+//
+// The get subcommand prints the value at a key path such as
+// servers[0].host, the same way for every format: scalars as plain text,
+// anything else in the -t format (JSON by default). A quoted key may
+// contain dots: 'a."b.c".d'. It exits 1 if the path does not exist.
+//
+// Options:
+//
+//	-f FORMAT   Input format
+//	-t FORMAT   Output format
+//	-o FILE     Write to FILE instead of standard output
+//	-indent N   Spaces per nesting level; 0 writes JSON and XML compactly (default: 2)
+//	-sort       Sort object keys
+//
+// Values a format cannot hold are errors: TOML and INI have no null, and
+// INI, .properties and .env hold only strings, so nested values are
+// flattened to dotted (or, for .env, NAME_SUB) keys. In .env input,
+// $NAME and ${NAME} outside single quotes expand to earlier variables.
+//
+// Examples:
+//
+//	confconv config.yaml -t toml
+//	confconv -o settings.json settings.ini
+//	confconv get database.port app.toml
+//	cat app.properties | confconv -f properties -t yaml
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"goutils/internal/cli"
+	"goutils/internal/config"
+)
+
+var (
+	from    = flag.String("f", "", "input `FORMAT`")
+	to      = flag.String("t", "", "output `FORMAT`")
+	outFile = flag.String("o", "", "write to `FILE`")
+	indent  = flag.Int("indent", 2, "spaces per nesting level")
+	sortKey = flag.Bool("sort", false, "sort object keys")
+)
+
+func fatal(format string, args ...interface{}) {
+	fmt.Fprintf(os.Stderr, "confconv: "+format+"\n", args...)
+	os.Exit(1)
+}
+
+func usage() {
+	fmt.Fprintln(os.Stderr, "usage: confconv [OPTIONS] [FILE]")
+	fmt.Fprintln(os.Stderr, "       confconv get [OPTIONS] PATH [FILE]")
+	fmt.Fprintln(os.Stderr, "       confconv formats")
+	flag.PrintDefaults()
+	fmt.Fprintln(os.Stderr, "formats: "+config.Names())
+	os.Exit(2)
+}
+
+func format(name string) *config.Format {
+	f := config.Lookup(name)
+	if f == nil {
+		fatal("unknown format %q (want %s)", name, config.Names())
+	}
+	return f
+}
+
+// sniff guesses the format of unnamed input.
+func sniff(data []byte) *config.Format {
+	t := bytes.TrimSpace(data)
+	switch {
+	case len(t) == 0:
+		return nil
+	case t[0] == '<':
+		return config.Lookup("xml")
+	case t[0] == '{' || t[0] == '[':
+		json := config.Lookup("json")
+		if _, err := json.Decode(data); err == nil {
+			return json
+		}
+	}
+	return nil
+}
+
+// load reads and decodes the input.
+func load(name string) interface{} {
+	var data []byte
+	var err error
+	label := name
+	if name == "" || name == "-" {
+		data, err = io.ReadAll(os.Stdin)
+		label = "stdin"
+	} else {
+		data, err = os.ReadFile(name)
+	}
+	if err != nil {
+		fatal("%v", err)
+	}
+	var f *config.Format
+	switch {
+	case *from != "":
+		f = format(*from)
+	case label != "stdin":
+		f = config.ForFile(name)
+	}
+	if f == nil {
+		f = sniff(data)
+	}
+	if f == nil {
+		fatal("%s: cannot tell the input format; use -f", label)
+	}
+	v, err := f.Decode(data)
+	if err != nil {
+		fatal("%s: %v", label, err)
+	}
+	if *sortKey {
+		config.SortKeys(v)
+	}
+	return v
+}
+
+// output picks the output format.
+func output() *config.Format {
+	if *to != "" {
+		return format(*to)
+	}
+	if *outFile != "" {
+		if f := config.ForFile(*outFile); f != nil {
+			return f
+		}
+	}
+	return config.Lookup("json")
+}
+
+func write(f *config.Format, v interface{}) {
+	var buf bytes.Buffer
+	if err := f.Encode(&buf, v, config.Options{Indent: *indent}); err != nil {
+		fatal("%v", err)
+	}
+	if *outFile == "" {
+		os.Stdout.Write(buf.Bytes())
+		return
+	}
+	if err := os.WriteFile(*outFile, buf.Bytes(), 0o644); err != nil {
+		fatal("%v", err)
+	}
+}
+
+func get(args []string) {
+	operands := cli.Parse(flag.CommandLine, args)
+	if len(operands) < 1 || len(operands) > 2 {
+		usage()
+	}
+	path, err := config.ParsePath(operands[0])
+	if err != nil {
+		fatal("%v", err)
+	}
+	file := ""
+	if len(operands) == 2 {
+		file = operands[1]
+	}
+	v, err := config.Get(load(file), path)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "confconv: %s: %v\n", operands[0], err)
+		os.Exit(1)
+	}
+	if s, ok := config.Text(v); ok && v != nil {
+		if *outFile != "" {
+			if err := os.WriteFile(*outFile, []byte(s+"\n"), 0o644); err != nil {
+				fatal("%v", err)
+			}
+			return
+		}
+		fmt.Println(s)
+		return
+	}
+	write(output(), v)
+}
+
+func main() {
+	flag.Usage = usage
+	args := os.Args[1:]
+	if len(args) > 0 {
+		switch args[0] {
+		case "get":
+			get(args[1:])
+			return
+		case "formats":
+			for _, f := range config.Formats() {
+				fmt.Printf("%-12s %s\n", f.Name, strings.Join(f.Exts, " "))
+			}
+			return
+		}
+	}
+	operands := cli.Parse(flag.CommandLine, args)
+	if len(operands) > 1 {
+		usage()
+	}
+	file := ""
+	if len(operands) == 1 {
+		file = operands[0]
+	}
+	v := load(file)
+	write(output(), v)
+}
@@ -0,0 +1,184 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+
+	"goutils/internal/tabular"
+)
+
+// colType is how a column's cells are written.
+type colType int
+
+const (
+	typeAuto   colType = iota // each cell on its own merits
+	typeString                // always a string
+	typeNumber                // a number where the cell is one
+	typeBool                  // a boolean where the cell is one
+	typeJSON                  // embedded JSON text
+)
+
+func parseType(s string) (colType, error) {
+	switch strings.ToLower(s) {
+	case "auto":
+		return typeAuto, nil
+	case "string", "str", "text":
+		return typeString, nil
+	case "number", "num", "int", "integer", "float":
+		return typeNumber, nil
+	case "bool", "boolean":
+		return typeBool, nil
+	case "json", "raw":
+		return typeJSON, nil
+	}
+	return typeAuto, fmt.Errorf("unknown type %q (want auto, string, number, bool or json)", s)
+}
+
+// typeOf maps an inferred column kind to a colType. Dates have no JSON form
+// and stay strings; a column with no values yet is typed cell by cell.
+func typeOf(k tabular.Kind) colType {
+	switch k {
+	case tabular.KindNull:
+		return typeAuto
+	case tabular.KindBool:
+		return typeBool
+	case tabular.KindInt, tabular.KindFloat:
+		return typeNumber
+	}
+	return typeString
+}
+
+func quote(s string) string {
+	b, _ := json.Marshal(s)
+	return string(b)
+}
+
+// number returns s as a JSON number. Valid JSON number text is kept as
+// written, so large integers and trailing zeros survive.
+func number(s string) (string, bool) {
+	if s == "" {
+		return "", false
+	}
+	if c := s[0]; (c == '-' || (c >= '0' && c <= '9')) && json.Valid([]byte(s)) {
+		return s, true
+	}
+	f, ok := tabular.ParseFloat(s)
+	if !ok || math.IsInf(f, 0) || math.IsNaN(f) {
+		return "", false
+	}
+	b, _ := json.Marshal(f)
+	return string(b), true
+}
+
+// encode renders one cell as JSON text. Empty cells are null; cells that
+// do not fit the column type are strings.
+func encode(s string, t colType) string {
+	v := strings.TrimSpace(s)
+	if v == "" {
+		return "null"
+	}
+	switch t {
+	case typeString:
+		return quote(s)
+	case typeJSON:
+		var buf bytes.Buffer
+		if json.Compact(&buf, []byte(v)) == nil {
+			return buf.String()
+		}
+	case typeNumber:
+		if n, ok := number(v); ok {
+			return n
+		}
+	case typeBool:
+		if b, ok := tabular.ParseBool(v); ok {
+			return strconv.FormatBool(b)
+		}
+	case typeAuto:
+		switch tabular.InferKind(v) {
+		case tabular.KindBool:
+			return encode(s, typeBool)
+		case tabular.KindInt, tabular.KindFloat:
+			return encode(s, typeNumber)
+		}
+	}
+	return quote(s)
+}
+
+// node is an object being rebuilt from dotted column names. Values are
+// either encoded JSON text or *node.
+type node struct {
+	keys []string
+	vals map[string]interface{}
+}
+
+func newNode() *node {
+	return &node{vals: map[string]interface{}{}}
+}
+
+func (n *node) set(key string, v interface{}) {
+	if _, ok := n.vals[key]; !ok {
+		n.keys = append(n.keys, key)
+	}
+	n.vals[key] = v
+}
+
+// insert stores value at path. A path that runs into a plain value (a
+// column "a" next to "a.b") keeps the rest of its name as a literal key.
+func (n *node) insert(path []string, value string) {
+	for len(path) > 1 {
+		child, ok := n.vals[path[0]]
+		if !ok {
+			child = newNode()
+			n.set(path[0], child)
+		}
+		next, isNode := child.(*node)
+		if !isNode {
+			break
+		}
+		n, path = next, path[1:]
+	}
+	n.set(strings.Join(path, *separator), value)
+}
+
+// isArray reports whether the keys are exactly 0, 1, 2, ... in order.
+func (n *node) isArray() bool {
+	for i, k := range n.keys {
+		if k != strconv.Itoa(i) {
+			return false
+		}
+	}
+	return len(n.keys) > 0
+}
+
+func (n *node) write(buf *bytes.Buffer) {
+	array := n.isArray()
+	if array {
+		buf.WriteByte('[')
+	} else {
+		buf.WriteByte('{')
+	}
+	for i, k := range n.keys {
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+		if !array {
+			buf.WriteString(quote(k))
+			buf.WriteByte(':')
+		}
+		switch v := n.vals[k].(type) {
+		case *node:
+			v.write(buf)
+		case string:
+			buf.WriteString(v)
+		}
+	}
+	if array {
+		buf.WriteByte(']')
+	} else {
+		buf.WriteByte('}')
+	}
+}
@@ -1,114 +1,314 @@
-// csv2json - Convert CSV to JSON
-// Usage: csv2json [-d delim] [-no-header] [file]
+// csv2json - Convert CSV to JSON.
+//
+// Usage:
+//
+//	csv2json [OPTIONS] [FILE]
+//
+// Rows become objects keyed by the header, written as a JSON array or, with
+// -l, as JSON Lines. Input is streamed: only the first -infer rows are held
+// to choose column types, after which rows are written as they are read.
+//
+// Column types are inferred from that sample: a column of numbers is
+// written as numbers, true/false/yes/no as booleans, and empty cells as
+// null. Numbers keep the text they were written with. -types forces types
+// per column; -I writes every value as a string.
+//
+// With -nest, dotted column names are folded back into nested objects, and
+// runs of numeric keys (tags.0, tags.1) into arrays, undoing json2csv.
+//
+// Options:
+//
+//	-d SEP        Field delimiter (default: ,)
+//	-t            Tab-separated input
+//	-q CHAR       Quote character (default: ")
+//	-e CHAR       Escape character (default: none; quotes are doubled)
+//	-no-header    The first row is data (implies -a)
+//	-columns LIST Write only these columns, in this order
+//	-types SPEC   Column types, e.g. id:string,price:number,meta:json
+//	              (auto, string, number, bool, json)
+//	-I            No type inference; every value is a string
+//	-infer N      Rows sampled for type inference (default: 1000; 0 = all)
+//	-nest         Rebuild nested objects from dotted column names
+//	-sep STR      Path separator for -nest (default: .)
+//	-l            JSON Lines output: one object per line
+//	-p            Pretty-print output
+//	-a            Output arrays of values (header first) instead of objects
+//
+// Examples:
+//
+//	csv2json users.csv
+//	csv2json -l -types zip:string big.csv | jq .
+//	json2csv orders.jsonl | csv2json -nest -l
 package main
 
 import (
-	"encoding/csv"
+	"bufio"
+	"bytes"
 	"encoding/json"
 	"flag"
 	"fmt"
 	"io"
 	"os"
-	"strconv"
+	"strings"
+
+	"goutils/internal/tabular"
 )
 
 var (
-	delim    = flag.String("d", ",", "Field delimiter")
-	noHeader = flag.Bool("no-header", false, "Treat first row as data, not header")
-	pretty   = flag.Bool("p", false, "Pretty-print output")
-	array    = flag.Bool("a", false, "Output as array of arrays (not objects)")
+	delim     = flag.String("d", ",", "Field delimiter")
+	tabMode   = flag.Bool("t", false, "Tab-separated input")
+	quoteChar = flag.String("q", "\"", "Quote character")
+	escape    = flag.String("e", "", "Escape character")
+	noHeader  = flag.Bool("no-header", false, "Treat first row as data, not header")
+	columns   = flag.String("columns", "", "Columns to write, in order")
+	types     = flag.String("types", "", "Column types (name:type,...)")
+	noInfer   = flag.Bool("I", false, "Disable type inference")
+	inferRows = flag.Int("infer", 1000, "Rows sampled for type inference")
+	nest      = flag.Bool("nest", false, "Rebuild nested objects from dotted names")
+	separator = flag.String("sep", ".", "Path separator for -nest")
+	lines     = flag.Bool("l", false, "JSON Lines output")
+	pretty    = flag.Bool("p", false, "Pretty-print output")
+	array     = flag.Bool("a", false, "Output as array of arrays (not objects)")
 )
 
+func fatal(format string, args ...interface{}) {
+	fmt.Fprintf(os.Stderr, "csv2json: "+format+"\n", args...)
+	os.Exit(1)
+}
+
+func firstRune(s string) rune {
+	if r := []rune(s); len(r) > 0 {
+		return r[0]
+	}
+	return 0
+}
+
+// output writes encoded rows as an array or as lines.
+type output struct {
+	w     *bufio.Writer
+	count int
+}
+
+func (o *output) row(b []byte) {
+	switch {
+	case *lines:
+		o.w.Write(b)
+		o.w.WriteByte('\n')
+	case *pretty:
+		var buf bytes.Buffer
+		json.Indent(&buf, b, "  ", "  ")
+		if o.count == 0 {
+			o.w.WriteString("[\n  ")
+		} else {
+			o.w.WriteString(",\n  ")
+		}
+		o.w.Write(buf.Bytes())
+	default:
+		if o.count == 0 {
+			o.w.WriteByte('[')
+		} else {
+			o.w.WriteByte(',')
+		}
+		o.w.Write(b)
+	}
+	o.count++
+}
+
+func (o *output) close() error {
+	switch {
+	case *lines:
+	case o.count == 0:
+		o.w.WriteString("[]\n")
+	case *pretty:
+		o.w.WriteString("\n]\n")
+	default:
+		o.w.WriteString("]\n")
+	}
+	return o.w.Flush()
+}
+
 func main() {
 	flag.Usage = func() {
-		fmt.Fprintln(os.Stderr, "Usage: csv2json [-d delim] [-no-header] [-p] [-a] [file]")
+		fmt.Fprintln(os.Stderr, "Usage: csv2json [OPTIONS] [FILE]")
 		flag.PrintDefaults()
 	}
 	flag.Parse()
+	if *noHeader {
+		*array = true
+	}
 
-	var r io.Reader = os.Stdin
+	var in io.Reader = os.Stdin
+	opt := tabular.Options{Format: tabular.FormatCSV, NoHeader: *noHeader}
 	if flag.NArg() > 0 {
 		f, err := os.Open(flag.Arg(0))
 		if err != nil {
-			fmt.Fprintln(os.Stderr, "csv2json:", err)
-			os.Exit(1)
+			fatal("%v", err)
 		}
 		defer f.Close()
-		r = f
+		in = f
+		if ff := tabular.FormatForName(flag.Arg(0)); ff != tabular.FormatAuto {
+			opt.Format = ff
+		}
 	}
-
-	csvr := csv.NewReader(r)
-	if len(*delim) > 0 {
-		csvr.Comma = []rune(*delim)[0]
+	if *tabMode {
+		opt.Format = tabular.FormatTSV
+	} else if c := firstRune(*delim); c != ',' {
+		opt.Comma = c
 	}
-	csvr.LazyQuotes = true
-	csvr.TrimLeadingSpace = true
+	opt.Quote = firstRune(*quoteChar)
+	opt.Escape = firstRune(*escape)
 
-	records, err := csvr.ReadAll()
+	r := tabular.NewReader(in, opt)
+	header, err := r.Header()
 	if err != nil {
-		fmt.Fprintln(os.Stderr, "csv2json:", err)
-		os.Exit(1)
+		fatal("%v", err)
 	}
 
-	if len(records) == 0 {
-		fmt.Println("[]")
-		return
+	// Sample rows for type inference before writing anything.
+	var sample [][]string
+	eof := false
+	for *inferRows <= 0 || len(sample) < *inferRows {
+		row, err := r.Read()
+		if err == io.EOF {
+			eof = true
+			break
+		}
+		if err != nil {
+			fatal("%v", err)
+		}
+		sample = append(sample, row)
 	}
+	header, _ = r.Header()
 
-	var result interface{}
-
-	if *array || *noHeader {
-		rows := make([]interface{}, len(records))
-		for i, row := range records {
-			cols := make([]interface{}, len(row))
-			for j, v := range row {
-				cols[j] = coerceValue(v)
+	pick := make([]int, len(header))
+	for i := range pick {
+		pick[i] = i
+	}
+	if *columns != "" {
+		index := map[string]int{}
+		for i, h := range header {
+			if _, dup := index[h]; !dup {
+				index[h] = i
+			}
+		}
+		pick = pick[:0]
+		for _, c := range strings.Split(*columns, ",") {
+			i, ok := index[strings.TrimSpace(c)]
+			if !ok {
+				fatal("no column %q", strings.TrimSpace(c))
 			}
-			rows[i] = cols
+			pick = append(pick, i)
+		}
+	}
+
+	colTypes := make([]colType, len(header))
+	if *noInfer {
+		for i := range colTypes {
+			colTypes[i] = typeString
 		}
-		result = rows
 	} else {
-		headers := records[0]
-		rows := make([]interface{}, len(records)-1)
-		for i, row := range records[1:] {
-			obj := map[string]interface{}{}
-			for j, h := range headers {
-				if j < len(row) {
-					obj[h] = coerceValue(row[j])
-				} else {
-					obj[h] = nil
+		for i, k := range tabular.InferColumns(len(header), sample) {
+			colTypes[i] = typeOf(k)
+		}
+	}
+	if *types != "" {
+		for _, spec := range strings.Split(*types, ",") {
+			name, typ, ok := strings.Cut(spec, ":")
+			if !ok {
+				fatal("bad -types entry %q (want name:type)", spec)
+			}
+			t, err := parseType(strings.TrimSpace(typ))
+			if err != nil {
+				fatal("%v", err)
+			}
+			found := false
+			for i, h := range header {
+				if h == strings.TrimSpace(name) {
+					colTypes[i], found = t, true
 				}
 			}
-			rows[i] = obj
+			if !found {
+				fatal("-types: no column %q", name)
+			}
 		}
-		result = rows
 	}
 
-	var b []byte
-	if *pretty {
-		b, err = json.MarshalIndent(result, "", "  ")
-	} else {
-		b, err = json.Marshal(result)
+	keys := make([]string, len(header))
+	paths := make([][]string, len(header))
+	for i, h := range header {
+		keys[i] = quote(h)
+		paths[i] = strings.Split(h, *separator)
 	}
-	if err != nil {
-		fmt.Fprintln(os.Stderr, "csv2json:", err)
-		os.Exit(1)
+
+	out := &output{w: bufio.NewWriterSize(os.Stdout, 64*1024)}
+	var buf bytes.Buffer
+	cell := func(row []string, i int) string {
+		if i >= len(row) {
+			return "null"
+		}
+		t := typeAuto
+		if i < len(colTypes) {
+			t = colTypes[i]
+		} else if *noInfer {
+			t = typeString
+		}
+		return encode(row[i], t)
+	}
+	write := func(row []string) {
+		buf.Reset()
+		switch {
+		case *array:
+			buf.WriteByte('[')
+			for j, i := range pick {
+				if j > 0 {
+					buf.WriteByte(',')
+				}
+				buf.WriteString(cell(row, i))
+			}
+			buf.WriteByte(']')
+		case *nest:
+			n := newNode()
+			for _, i := range pick {
+				n.insert(paths[i], cell(row, i))
+			}
+			n.write(&buf)
+		default:
+			buf.WriteByte('{')
+			for j, i := range pick {
+				if j > 0 {
+					buf.WriteByte(',')
+				}
+				buf.WriteString(keys[i])
+				buf.WriteByte(':')
+				buf.WriteString(cell(row, i))
+			}
+			buf.WriteByte('}')
+		}
+		out.row(buf.Bytes())
 	}
-	fmt.Println(string(b))
-}
 
-func coerceValue(s string) interface{} {
-	if s == "" {
-		return nil
+	if *array && !*noHeader {
+		names := make([]string, len(pick))
+		for j, i := range pick {
+			names[j] = quote(header[i])
+		}
+		out.row([]byte("[" + strings.Join(names, ",") + "]"))
 	}
-	if n, err := strconv.ParseInt(s, 10, 64); err == nil {
-		return n
+	for _, row := range sample {
+		write(row)
 	}
-	if f, err := strconv.ParseFloat(s, 64); err == nil {
-		return f
+	for !eof {
+		row, err := r.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			out.w.Flush()
+			fatal("%v", err)
+		}
+		write(row)
 	}
-	if b, err := strconv.ParseBool(s); err == nil {
-		return b
+	if err := out.close(); err != nil {
+		fatal("%v", err)
 	}
-	return s
 }
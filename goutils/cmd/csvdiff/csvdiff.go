@@ -1,92 +1,388 @@
-// csvdiff - diff two CSV files by key column, showing added/removed/changed rows
+// csvdiff - Diff two CSV, TSV or JSON Lines files by key column(s).
+//
+// Usage:
+//
+//	csvdiff [OPTIONS] OLD NEW
+//
+// Rows are matched by key (the first column unless -k names others) and
+// reported as added, removed or changed, with the changed fields of each
+// row. By default OLD is indexed in memory and NEW is streamed; with
+// -sorted both files must be sorted by key and are streamed side by side,
+// so files of any size can be compared.
+//
+// Options:
+//
+//	-k COLS     Key column(s), comma-separated or repeated (default: first column)
+//	-c COLS     Compare only these columns
+//	-x COLS     Ignore these columns
+//	-tol N      Treat numbers within N as equal; N% is relative to the larger value
+//	-sorted     Sort-merge mode for inputs sorted by key (numbers numerically)
+//	-o FMT      Output: text|json|csv (default: text)
+//	-s          Text output: summary only
+//	-d SEP      Input delimiter (default: ,)
+//	-t          Tab-separated input
+//	--color=WHEN  Colour text output: auto (default), always or never; auto
+//	              colours a terminal unless NO_COLOR is set
+//	--no-color  Same as --color=never
+//
+// The json and csv outputs are patches that csvpatch applies to OLD to
+// produce NEW. JSON patches are JSON Lines: a header object naming the key
+// and NEW's columns, then one object per difference:
+//
+//	{"op":"header","key":["id"],"columns":["id","name","qty"]}
+//	{"op":"change","key":{"id":"1"},"changes":{"qty":{"old":"10","new":"12"}}}
+//	{"op":"add","key":{"id":"7"},"after":{"id":"6"},"row":{"id":"7","name":"bolt","qty":"3"}}
+//	{"op":"remove","key":{"id":"3"},"row":{"id":"3","name":"nut","qty":"5"}}
+//
+// "after" is the key of the preceding row in NEW (null at the top). CSV
+// patches have NEW's columns behind _op and _after columns, and a first
+// "key" row whose _after names the key columns as a JSON array; change
+// rows carry the whole new row and remove rows only the key.
+//
+// Exit status is 0 if the files match, 1 if they differ and 2 on error.
+//
+// Examples:
+//
+//	csvdiff -k id,region old.csv new.csv
+//	csvdiff -x updated_at -tol 0.005 prices_monday.csv prices_tuesday.csv
+//	csvdiff -sorted -o json big_old.csv big_new.csv > changes.jsonl
 package main
 
 import (
+	"bufio"
 	"encoding/csv"
+	"encoding/json"
+	"flag"
 	"fmt"
 	"os"
+	"sort"
+	"strconv"
 	"strings"
+
+	"goutils/internal/cli"
+	"goutils/internal/tabular"
+	"goutils/lib/term"
 )
 
-const (
-	green = "\033[32m"
-	red   = "\033[31m"
-	yellow= "\033[33m"
-	reset = "\033[0m"
+var keySpec cli.ColumnList
+
+func init() { flag.Var(&keySpec, "k", "key columns (repeatable)") }
+
+var (
+	onlyCols  = flag.String("c", "", "compare only these columns")
+	ignore    = flag.String("x", "", "ignore these columns")
+	tolSpec   = flag.String("tol", "", "numeric tolerance")
+	sorted    = flag.Bool("sorted", false, "inputs are sorted by key")
+	outFmt    = flag.String("o", "text", "output format")
+	summary   = flag.Bool("s", false, "summary only")
+	delim     = flag.String("d", ",", "delimiter")
+	tabMode   = flag.Bool("t", false, "TSV mode")
+	colorMode = term.ColorFlag()
 )
 
-func readCSV(path string) ([]string, []map[string]string, error) {
-	f, err := os.Open(path); if err != nil { return nil, nil, err }
-	defer f.Close()
-	r := csv.NewReader(f); r.LazyQuotes = true
-	recs, err := r.ReadAll(); if err != nil { return nil, nil, err }
-	if len(recs) == 0 { return nil, nil, nil }
-	headers := recs[0]
-	rows := make([]map[string]string, len(recs)-1)
-	for i, rec := range recs[1:] {
-		m := map[string]string{}
-		for j, h := range headers { if j < len(rec) { m[h] = rec[j] } }
-		rows[i] = m
+func fatal(format string, args ...interface{}) {
+	fmt.Fprintf(os.Stderr, "csvdiff: "+format+"\n", args...)
+	os.Exit(2)
+}
+
+func main() {
+	flag.Usage = func() {
+		fmt.Fprintln(os.Stderr, "usage: csvdiff [OPTIONS] OLD NEW")
+		flag.PrintDefaults()
+		os.Exit(2)
+	}
+	files := cli.Parse(flag.CommandLine, os.Args[1:])
+	if len(files) != 2 {
+		flag.Usage()
+	}
+
+	opt := tabular.Options{}
+	if *tabMode {
+		opt.Comma = '\t'
+	} else if r := []rune(*delim); len(r) > 0 && *delim != "," {
+		opt.Comma = r[0]
+	}
+
+	keys := []string(keySpec)
+	if len(keys) == 0 {
+		// Default to the first column of the old file.
+		f, err := os.Open(files[0])
+		if err != nil {
+			fatal("%v", err)
+		}
+		o := opt
+		o.Format = tabular.FormatForName(files[0])
+		header, err := tabular.NewReader(f, o).Header()
+		f.Close()
+		if err != nil {
+			fatal("%s: %v", files[0], err)
+		}
+		if len(header) == 0 {
+			fatal("%s: no columns", files[0])
+		}
+		keys = header[:1]
+	}
+
+	oldSrc, err := openSource(files[0], opt, keys)
+	if err != nil {
+		fatal("%v", err)
+	}
+	defer oldSrc.f.Close()
+	newSrc, err := openSource(files[1], opt, keys)
+	if err != nil {
+		fatal("%v", err)
+	}
+	defer newSrc.f.Close()
+
+	d := &differ{keys: keys, old: oldSrc, new: newSrc}
+	if s := strings.TrimSpace(*tolSpec); s != "" {
+		d.relative = strings.HasSuffix(s, "%")
+		v, err := strconv.ParseFloat(strings.TrimSuffix(s, "%"), 64)
+		if err != nil || v < 0 {
+			fatal("bad tolerance %q", *tolSpec)
+		}
+		if d.relative {
+			v /= 100
+		}
+		d.tol = v
+	}
+	if err := d.setup(cli.SplitList(*onlyCols), cli.SplitList(*ignore)); err != nil {
+		fatal("%v", err)
+	}
+
+	w := bufio.NewWriter(os.Stdout)
+	switch *outFmt {
+	case "text":
+		d.out = &textReporter{w: w, pal: colorMode.Profile(os.Stdout), summaryOnly: *summary}
+	case "json":
+		d.out = &jsonReporter{w: w, keys: keys}
+	case "csv":
+		d.out = &csvReporter{w: csv.NewWriter(w), keys: keys}
+	default:
+		fatal("unknown output format %q (want text, json or csv)", *outFmt)
+	}
+
+	err = d.out.begin(d)
+	if err == nil {
+		if *sorted {
+			err = d.mergeDiff()
+		} else {
+			err = d.hashDiff()
+		}
+	}
+	if err == nil {
+		err = d.out.end(d)
+	}
+	w.Flush()
+	if err != nil {
+		fatal("%v", err)
+	}
+	if d.added+d.removed+d.changed > 0 {
+		os.Exit(1)
 	}
-	return headers, rows, nil
 }
 
-func rowKey(row map[string]string, keys []string) string {
-	parts := make([]string, len(keys))
-	for i, k := range keys { parts[i] = row[k] }
-	return strings.Join(parts, "|")
+type textReporter struct {
+	w           *bufio.Writer
+	pal         term.Profile
+	summaryOnly bool
+	keys        []string
+	new         *source
+	old         *source
 }
 
-func rowEqual(a, b map[string]string, headers []string) bool {
-	for _, h := range headers { if a[h] != b[h] { return false } }
-	return true
+func (t *textReporter) paint(color, s string) string {
+	return t.pal.Paint(s, color)
 }
 
-func usage() {
-	fmt.Fprintln(os.Stderr, `usage: csvdiff [options] <file1.csv> <file2.csv>
-  -k <col>    key column(s) for matching rows (comma-separated, default: first col)
-  --no-color  disable ANSI color output`)
-	os.Exit(1)
+func (t *textReporter) begin(d *differ) error {
+	t.keys, t.old, t.new = d.keys, d.old, d.new
+	var added, removed []string
+	for _, h := range d.new.header {
+		if _, ok := d.old.index[h]; !ok {
+			added = append(added, h)
+		}
+	}
+	for _, h := range d.old.header {
+		if _, ok := d.new.index[h]; !ok {
+			removed = append(removed, h)
+		}
+	}
+	if len(added) > 0 {
+		fmt.Fprintln(t.w, t.paint(term.Green, "Columns added: "+strings.Join(added, ", ")))
+	}
+	if len(removed) > 0 {
+		fmt.Fprintln(t.w, t.paint(term.Red, "Columns removed: "+strings.Join(removed, ", ")))
+	}
+	return nil
 }
 
-func main() {
-	keySpec := ""
-	color := true
-	args := os.Args[1:]
-	rest := []string{}
-	for i := 0; i < len(args); i++ {
-		switch args[i] {
-		case "-k": i++; keySpec = args[i]
-		case "--no-color": color = false
-		default: rest = append(rest, args[i])
+// values lists the non-key cells of a row.
+func (t *textReporter) values(s *source, row []string) string {
+	isKey := map[string]bool{}
+	for _, k := range t.keys {
+		isKey[k] = true
+	}
+	var parts []string
+	for i, h := range s.header {
+		if !isKey[h] && i < len(row) {
+			parts = append(parts, h+"="+quoteCell(row[i]))
 		}
 	}
-	if len(rest) < 2 { usage() }
-	if !color { green[0] = 0; _ = red; _ = yellow; _ = reset }
+	return strings.Join(parts, ", ")
+}
+
+func (t *textReporter) added(key, after, row []string) error {
+	if !t.summaryOnly {
+		fmt.Fprintln(t.w, t.paint(term.Green, "+ "+keyString(t.keys, key)+": "+t.values(t.new, row)))
+	}
+	return nil
+}
 
-	h1, rows1, err := readCSV(rest[0]); if err != nil { fmt.Fprintln(os.Stderr, err); os.Exit(1) }
-	h2, rows2, err2 := readCSV(rest[1]); if err2 != nil { fmt.Fprintln(os.Stderr, err2); os.Exit(1) }
-	_ = h2
+func (t *textReporter) removed(key, row []string) error {
+	if !t.summaryOnly {
+		fmt.Fprintln(t.w, t.paint(term.Red, "- "+keyString(t.keys, key)+": "+t.values(t.old, row)))
+	}
+	return nil
+}
 
-	keys := []string{h1[0]}
-	if keySpec != "" { keys = strings.Split(keySpec, ",") }
+func (t *textReporter) changed(key []string, fields []fieldChange, row []string) error {
+	if t.summaryOnly {
+		return nil
+	}
+	fmt.Fprintln(t.w, t.paint(term.Yellow, "~ "+keyString(t.keys, key)))
+	for _, f := range fields {
+		fmt.Fprintf(t.w, "    %s: %s → %s\n", f.col, t.paint(term.Red, quoteCell(f.old)), t.paint(term.Green, quoteCell(f.new)))
+	}
+	return nil
+}
 
-	idx1 := map[string]map[string]string{}
-	for _, r := range rows1 { idx1[rowKey(r, keys)] = r }
-	idx2 := map[string]map[string]string{}
-	for _, r := range rows2 { idx2[rowKey(r, keys)] = r }
+func (t *textReporter) end(d *differ) error {
+	fmt.Fprintf(t.w, "\nAdded: %d  Removed: %d  Changed: %d  Unchanged: %d\n", d.added, d.removed, d.changed, d.same)
+	if len(d.fieldCounts) > 0 {
+		cols := make([]string, 0, len(d.fieldCounts))
+		for c := range d.fieldCounts {
+			cols = append(cols, c)
+		}
+		sort.Slice(cols, func(i, j int) bool {
+			if d.fieldCounts[cols[i]] != d.fieldCounts[cols[j]] {
+				return d.fieldCounts[cols[i]] > d.fieldCounts[cols[j]]
+			}
+			return cols[i] < cols[j]
+		})
+		parts := make([]string, len(cols))
+		for i, c := range cols {
+			parts[i] = fmt.Sprintf("%s (%d)", c, d.fieldCounts[c])
+		}
+		fmt.Fprintf(t.w, "Changed fields: %s\n", strings.Join(parts, ", "))
+	}
+	return nil
+}
 
-	added, removed, changed := 0, 0, 0
-	for k, r := range idx2 {
-		if _, ok := idx1[k]; !ok {
-			fmt.Printf("%s+ %v%s\n", green, r, reset); added++
+// object writes a JSON object whose members keep the given order.
+func object(names, values []string) string {
+	var sb strings.Builder
+	sb.WriteByte('{')
+	for i, n := range names {
+		if i > 0 {
+			sb.WriteByte(',')
 		}
+		k, _ := json.Marshal(n)
+		v, _ := json.Marshal(values[i])
+		sb.Write(k)
+		sb.WriteByte(':')
+		sb.Write(v)
+	}
+	sb.WriteByte('}')
+	return sb.String()
+}
+
+type jsonReporter struct {
+	w    *bufio.Writer
+	keys []string
+	new  *source
+	old  *source
+}
+
+func (j *jsonReporter) begin(d *differ) error {
+	j.new, j.old = d.new, d.old
+	k, _ := json.Marshal(d.keys)
+	c, _ := json.Marshal(d.new.header)
+	_, err := fmt.Fprintf(j.w, `{"op":"header","key":%s,"columns":%s}`+"\n", k, c)
+	return err
+}
+
+func (j *jsonReporter) added(key, after, row []string) error {
+	a := "null"
+	if after != nil {
+		a = object(j.keys, after)
 	}
-	for k, r := range idx1 {
-		r2, ok := idx2[k]
-		if !ok { fmt.Printf("%s- %v%s\n", red, r, reset); removed++ } else if !rowEqual(r, r2, h1) {
-			fmt.Printf("%s~ %v -> %v%s\n", yellow, r, r2, reset); changed++
+	_, err := fmt.Fprintf(j.w, `{"op":"add","key":%s,"after":%s,"row":%s}`+"\n",
+		object(j.keys, key), a, object(j.new.header, row))
+	return err
+}
+
+func (j *jsonReporter) removed(key, row []string) error {
+	_, err := fmt.Fprintf(j.w, `{"op":"remove","key":%s,"row":%s}`+"\n",
+		object(j.keys, key), object(j.old.header, row))
+	return err
+}
+
+func (j *jsonReporter) changed(key []string, fields []fieldChange, row []string) error {
+	var sb strings.Builder
+	sb.WriteByte('{')
+	for i, f := range fields {
+		if i > 0 {
+			sb.WriteByte(',')
 		}
+		c, _ := json.Marshal(f.col)
+		sb.Write(c)
+		sb.WriteString(":" + object([]string{"old", "new"}, []string{f.old, f.new}))
+	}
+	sb.WriteByte('}')
+	_, err := fmt.Fprintf(j.w, `{"op":"change","key":%s,"changes":%s}`+"\n", object(j.keys, key), sb.String())
+	return err
+}
+
+func (j *jsonReporter) end(d *differ) error { return nil }
+
+type csvReporter struct {
+	w    *csv.Writer
+	keys []string
+	new  *source
+}
+
+func (c *csvReporter) begin(d *differ) error {
+	c.new = d.new
+	if err := c.w.Write(append([]string{"_op", "_after"}, d.new.header...)); err != nil {
+		return err
 	}
-	fmt.Printf("\nAdded: %d  Removed: %d  Changed: %d\n", added, removed, changed)
+	k, _ := json.Marshal(c.keys)
+	return c.w.Write(append([]string{"key", string(k)}, make([]string, len(d.new.header))...))
+}
+
+func (c *csvReporter) added(key, after, row []string) error {
+	a := ""
+	if after != nil {
+		b, _ := json.Marshal(after)
+		a = string(b)
+	}
+	return c.w.Write(append([]string{"add", a}, row[:len(c.new.header)]...))
+}
+
+func (c *csvReporter) removed(key, row []string) error {
+	rec := make([]string, len(c.new.header))
+	for i, k := range c.keys {
+		rec[c.new.index[k]] = key[i]
+	}
+	return c.w.Write(append([]string{"remove", ""}, rec...))
+}
+
+func (c *csvReporter) changed(key []string, fields []fieldChange, row []string) error {
+	return c.w.Write(append([]string{"change", ""}, row[:len(c.new.header)]...))
+}
+
+func (c *csvReporter) end(d *differ) error {
+	c.w.Flush()
+	return c.w.Error()
 }
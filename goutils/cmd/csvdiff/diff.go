@@ -0,0 +1,375 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+
+	"goutils/internal/tabular"
+)
+
+// source streams keyed rows from one input file.
+type source struct {
+	name    string
+	r       *tabular.Reader
+	f       *os.File
+	header  []string
+	index   map[string]int
+	keyIdx  []int
+	line    int
+	prevKey []string // for checking sort order in merge mode
+}
+
+func openSource(path string, opt tabular.Options, keys []string) (*source, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	if opt.Format == tabular.FormatAuto {
+		opt.Format = tabular.FormatForName(path)
+	}
+	s := &source{name: path, f: f, r: tabular.NewReader(f, opt)}
+	header, err := s.r.Header()
+	if err != nil {
+		f.Close()
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	s.setHeader(header)
+	for _, k := range keys {
+		i, ok := s.index[k]
+		if !ok {
+			f.Close()
+			return nil, fmt.Errorf("%s: no key column %q", path, k)
+		}
+		s.keyIdx = append(s.keyIdx, i)
+	}
+	return s, nil
+}
+
+func (s *source) setHeader(header []string) {
+	s.header = header
+	s.index = make(map[string]int, len(header))
+	for i, h := range header {
+		if _, dup := s.index[h]; !dup {
+			s.index[h] = i
+		}
+	}
+}
+
+// next returns the next row padded to the header, and its key, or io.EOF.
+func (s *source) next() ([]string, []string, error) {
+	row, err := s.r.Read()
+	if err != nil {
+		if err != io.EOF {
+			err = fmt.Errorf("%s: %w", s.name, err)
+		}
+		return nil, nil, err
+	}
+	s.line++
+	if header, _ := s.r.Header(); len(header) != len(s.header) {
+		s.setHeader(header)
+	}
+	if len(row) < len(s.header) {
+		row = append(row, make([]string, len(s.header)-len(row))...)
+	}
+	key := make([]string, len(s.keyIdx))
+	for i, k := range s.keyIdx {
+		key[i] = row[k]
+	}
+	return row, key, nil
+}
+
+func (s *source) get(row []string, col string) string {
+	if i, ok := s.index[col]; ok && i < len(row) {
+		return row[i]
+	}
+	return ""
+}
+
+// fieldChange is one differing column of a changed row.
+type fieldChange struct {
+	col      string
+	old, new string
+}
+
+// reporter receives the differences as they are found.
+type reporter interface {
+	begin(d *differ) error
+	added(key, after, row []string) error
+	removed(key, row []string) error
+	changed(key []string, fields []fieldChange, row []string) error
+	end(d *differ) error
+}
+
+type differ struct {
+	keys     []string
+	cols     []string // compared columns, in output order
+	old, new *source
+	tol      float64
+	relative bool // tol is a fraction of the larger magnitude
+	out      reporter
+
+	added, removed, changed, same int
+	fieldCounts                   map[string]int
+}
+
+// setup chooses the compared columns: every non-key column of either file,
+// narrowed to only if given, minus ignore.
+func (d *differ) setup(only, ignore []string) error {
+	skip := map[string]bool{}
+	for _, k := range d.keys {
+		skip[k] = true
+	}
+	for _, c := range ignore {
+		skip[c] = true
+	}
+	var all []string
+	seen := map[string]bool{}
+	for _, h := range append(append([]string(nil), d.new.header...), d.old.header...) {
+		if !seen[h] {
+			seen[h] = true
+			all = append(all, h)
+		}
+	}
+	if len(only) > 0 {
+		for _, c := range only {
+			if !seen[c] {
+				return fmt.Errorf("no column %q in either file", c)
+			}
+		}
+		keep := map[string]bool{}
+		for _, c := range only {
+			keep[c] = true
+		}
+		var narrowed []string
+		for _, c := range all {
+			if keep[c] {
+				narrowed = append(narrowed, c)
+			}
+		}
+		all = narrowed
+	}
+	for _, c := range all {
+		if !skip[c] {
+			d.cols = append(d.cols, c)
+		}
+	}
+	d.fieldCounts = map[string]int{}
+	return nil
+}
+
+// equal compares two cells, allowing the numeric tolerance when both are
+// numbers.
+func (d *differ) equal(a, b string) bool {
+	if a == b {
+		return true
+	}
+	if d.tol == 0 {
+		return false
+	}
+	x, err1 := strconv.ParseFloat(strings.TrimSpace(a), 64)
+	y, err2 := strconv.ParseFloat(strings.TrimSpace(b), 64)
+	if err1 != nil || err2 != nil {
+		return false
+	}
+	limit := d.tol
+	if d.relative {
+		limit = d.tol * math.Max(math.Abs(x), math.Abs(y))
+	}
+	return math.Abs(x-y) <= limit
+}
+
+func (d *differ) compare(key, oldRow, newRow []string) error {
+	var fields []fieldChange
+	for _, c := range d.cols {
+		o, n := d.old.get(oldRow, c), d.new.get(newRow, c)
+		if !d.equal(o, n) {
+			fields = append(fields, fieldChange{c, o, n})
+			d.fieldCounts[c]++
+		}
+	}
+	if len(fields) == 0 {
+		d.same++
+		return nil
+	}
+	d.changed++
+	return d.out.changed(key, fields, newRow)
+}
+
+func joinKey(key []string) string {
+	return strings.Join(key, "\x00")
+}
+
+// hashDiff holds the old file in memory, indexed by key, and streams the
+// new file against it. Removed rows are reported last, in old-file order.
+func (d *differ) hashDiff() error {
+	type oldRow struct {
+		key, row []string
+		matched  bool
+	}
+	var rows []*oldRow
+	index := map[string]*oldRow{}
+	for {
+		row, key, err := d.old.next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		k := joinKey(key)
+		if _, dup := index[k]; dup {
+			return fmt.Errorf("%s:%d: duplicate key %s", d.old.name, d.old.line, keyString(d.keys, key))
+		}
+		r := &oldRow{key: key, row: row}
+		rows = append(rows, r)
+		index[k] = r
+	}
+
+	seen := map[string]bool{}
+	var prev []string
+	for {
+		row, key, err := d.new.next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		k := joinKey(key)
+		if seen[k] {
+			return fmt.Errorf("%s:%d: duplicate key %s", d.new.name, d.new.line, keyString(d.keys, key))
+		}
+		seen[k] = true
+		if r, ok := index[k]; ok {
+			r.matched = true
+			err = d.compare(key, r.row, row)
+		} else {
+			d.added++
+			err = d.out.added(key, prev, row)
+		}
+		if err != nil {
+			return err
+		}
+		prev = key
+	}
+
+	for _, r := range rows {
+		if !r.matched {
+			d.removed++
+			if err := d.out.removed(r.key, r.row); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// compareKeys orders keys column by column, numerically when both values
+// are numbers.
+func compareKeys(a, b []string) int {
+	for i := range a {
+		x, err1 := strconv.ParseFloat(a[i], 64)
+		y, err2 := strconv.ParseFloat(b[i], 64)
+		if err1 == nil && err2 == nil {
+			if x < y {
+				return -1
+			}
+			if x > y {
+				return 1
+			}
+			continue
+		}
+		if c := strings.Compare(a[i], b[i]); c != 0 {
+			return c
+		}
+	}
+	return 0
+}
+
+// advance reads the next row of s in merge mode, checking that keys
+// strictly increase.
+func (d *differ) advance(s *source) ([]string, []string, error) {
+	row, key, err := s.next()
+	if err != nil {
+		return nil, nil, err
+	}
+	if s.prevKey != nil {
+		switch c := compareKeys(s.prevKey, key); {
+		case c == 0:
+			return nil, nil, fmt.Errorf("%s:%d: duplicate key %s", s.name, s.line, keyString(d.keys, key))
+		case c > 0:
+			return nil, nil, fmt.Errorf("%s:%d: not sorted by key (%s follows %s); sort the input or drop -sorted",
+				s.name, s.line, keyString(d.keys, key), keyString(d.keys, s.prevKey))
+		}
+	}
+	s.prevKey = key
+	return row, key, nil
+}
+
+// mergeDiff walks two inputs sorted by key in step, holding one row of
+// each in memory.
+func (d *differ) mergeDiff() error {
+	oldRow, oldKey, oerr := d.advance(d.old)
+	newRow, newKey, nerr := d.advance(d.new)
+	var prev []string
+	for {
+		if oerr != nil && oerr != io.EOF {
+			return oerr
+		}
+		if nerr != nil && nerr != io.EOF {
+			return nerr
+		}
+		if oerr == io.EOF && nerr == io.EOF {
+			return nil
+		}
+		c := 0
+		switch {
+		case oerr == io.EOF:
+			c = 1
+		case nerr == io.EOF:
+			c = -1
+		default:
+			c = compareKeys(oldKey, newKey)
+		}
+		var err error
+		switch {
+		case c < 0:
+			d.removed++
+			err = d.out.removed(oldKey, oldRow)
+			oldRow, oldKey, oerr = d.advance(d.old)
+		case c > 0:
+			d.added++
+			err = d.out.added(newKey, prev, newRow)
+			prev = newKey
+			newRow, newKey, nerr = d.advance(d.new)
+		default:
+			err = d.compare(newKey, oldRow, newRow)
+			prev = newKey
+			oldRow, oldKey, oerr = d.advance(d.old)
+			newRow, newKey, nerr = d.advance(d.new)
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+func keyString(names, key []string) string {
+	parts := make([]string, len(key))
+	for i := range key {
+		parts[i] = names[i] + "=" + quoteCell(key[i])
+	}
+	return strings.Join(parts, ", ")
+}
+
+// quoteCell quotes values that would be hard to read bare.
+func quoteCell(s string) string {
+	if s == "" || strings.TrimSpace(s) != s || strings.ContainsAny(s, ",\"\n\r\t") {
+		return strconv.Quote(s)
+	}
+	return s
+}
@@ -36,7 +36,8 @@ import (
 	"sort"
 	"strconv"
 	"strings"
-	"unicode/utf8"
+
+	"goutils/internal/tabular"
 )
 
 var (
@@ -52,103 +53,6 @@ var (
 	style    = flag.String("style", "unicode", "border style")
 )
 
-var borders = map[string][9]string{
-	"unicode": {"┌", "─", "┬", "├", "─", "┼", "└", "─", "┘"},
-	"ascii":   {"+", "-", "+", "+", "-", "+", "+", "-", "+"},
-	"minimal": {" ", " ", " ", " ", " ", " ", " ", " ", " "},
-}
-
-func truncate(s string, max int) string {
-	if max <= 0 {
-		return s
-	}
-	runes := []rune(s)
-	if len(runes) <= max {
-		return s
-	}
-	if max <= 3 {
-		return string(runes[:max])
-	}
-	return string(runes[:max-3]) + "..."
-}
-
-func colWidth(s string) int {
-	return utf8.RuneCountInString(s)
-}
-
-func pad(s string, width int) string {
-	n := colWidth(s)
-	if n >= width {
-		return s
-	}
-	return s + strings.Repeat(" ", width-n)
-}
-
-func printTable(headers []string, rows [][]string, bstyle string) {
-	b := borders[bstyle]
-	if b == ([9]string{}) {
-		b = borders["unicode"]
-	}
-
-	// Calculate column widths
-	widths := make([]int, len(headers))
-	for i, h := range headers {
-		widths[i] = colWidth(h)
-	}
-	for _, row := range rows {
-		for i, cell := range row {
-			if i < len(widths) {
-				w := colWidth(cell)
-				if w > widths[i] {
-					widths[i] = w
-				}
-			}
-		}
-	}
-
-	w := bufio.NewWriter(os.Stdout)
-	defer w.Flush()
-
-	sep := func(left, mid, right, fill string) {
-		fmt.Fprint(w, left)
-		for i, width := range widths {
-			fmt.Fprint(w, strings.Repeat(fill, width+2))
-			if i < len(widths)-1 {
-				fmt.Fprint(w, mid)
-			}
-		}
-		fmt.Fprintln(w, right)
-	}
-
-	printRow := func(cells []string) {
-		fmt.Fprint(w, "│")
-		for i, width := range widths {
-			cell := ""
-			if i < len(cells) {
-				cell = cells[i]
-			}
-			fmt.Fprintf(w, " %s │", pad(cell, width))
-		}
-		fmt.Fprintln(w)
-	}
-
-	_ = b
-
-	// Top border
-	sep("┌", "┬", "┐", "─")
-	// Header
-	printRow(headers)
-	// Header separator
-	sep("├", "┼", "┤", "─")
-	// Data rows
-	for _, row := range rows {
-		printRow(row)
-	}
-	// Bottom border
-	sep("└", "┴", "┘", "─")
-	fmt.Fprintf(w, "\n%d rows\n", len(rows))
-}
-
 func main() {
 	flag.Parse()
 
@@ -240,7 +144,7 @@ func main() {
 		out := make([]string, len(colIndices))
 		for i, idx := range colIndices {
 			if idx < len(row) {
-				out[i] = truncate(row[idx], *maxWidth)
+				out[i] = tabular.Truncate(row[idx], *maxWidth)
 			}
 		}
 		return out
@@ -304,5 +208,5 @@ func main() {
 		return
 	}
 
-	printTable(filteredHeaders, filteredRows, *style)
+	tabular.WriteTable(os.Stdout, filteredHeaders, filteredRows, tabular.TableOptions{Style: *style})
 }
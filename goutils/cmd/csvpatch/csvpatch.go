@@ -0,0 +1,435 @@
+// csvpatch - Apply a csvdiff patch to a CSV file.
+//
+// Usage:
+//
+//	csvpatch [OPTIONS] OLD PATCH
+//
+// PATCH is the json or csv output of csvdiff OLD NEW; the result, written
+// to standard output (or -o FILE), is NEW: removed rows are dropped,
+// changed fields updated and added rows inserted after the row that
+// preceded them in NEW. Columns follow NEW's header. OLD is streamed; only
+// the patch is held in memory.
+//
+// A patch that does not fit OLD (a changed or removed key that is
+// missing) is an error and nothing is written to -o FILE.
+//
+// Options:
+//
+//	-k COLS   Key column(s) of a CSV patch without a key row (default: first column;
+//	          JSON patches and current CSV patches name their key)
+//	-d SEP    Delimiter of OLD and the output (default: ,)
+//	-t        Tab-separated OLD and output
+//	-o FILE   Write to FILE instead of standard output
+//
+// Examples:
+//
+//	csvdiff -o json old.csv new.csv > changes.jsonl
+//	csvpatch old.csv changes.jsonl > rebuilt.csv
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"goutils/internal/cli"
+	"goutils/internal/tabular"
+)
+
+var keySpec cli.ColumnList
+
+func init() { flag.Var(&keySpec, "k", "key columns (repeatable)") }
+
+var (
+	delim   = flag.String("d", ",", "delimiter")
+	tabMode = flag.Bool("t", false, "TSV mode")
+	outFile = flag.String("o", "", "output file")
+)
+
+func fatal(format string, args ...interface{}) {
+	fmt.Fprintf(os.Stderr, "csvpatch: "+format+"\n", args...)
+	os.Exit(1)
+}
+
+// patch is a parsed csvdiff patch.
+type patch struct {
+	keys    []string
+	columns []string
+	removed map[string]bool
+	// changes maps a key to column updates; a CSV patch updates them all.
+	changes map[string]map[string]string
+	// added rows in patch order, grouped by the key they follow ("" for
+	// the top of the file).
+	after      map[string][][]string
+	afterOrder []string
+	applied    map[string]bool
+}
+
+func joinKey(key []string) string {
+	return "k" + strings.Join(key, "\x00")
+}
+
+func newPatch() *patch {
+	return &patch{
+		removed: map[string]bool{},
+		changes: map[string]map[string]string{},
+		after:   map[string][][]string{},
+		applied: map[string]bool{},
+	}
+}
+
+func (p *patch) add(after string, row []string) {
+	if _, ok := p.after[after]; !ok {
+		p.afterOrder = append(p.afterOrder, after)
+	}
+	p.after[after] = append(p.after[after], row)
+}
+
+// keyOf reads the key columns out of an object.
+func keyOf(keys []string, obj map[string]string) []string {
+	out := make([]string, len(keys))
+	for i, k := range keys {
+		out[i] = obj[k]
+	}
+	return out
+}
+
+func readJSONPatch(data []byte) (*patch, error) {
+	p := newPatch()
+	dec := json.NewDecoder(bytes.NewReader(data))
+	for n := 1; ; n++ {
+		var op struct {
+			Op      string                       `json:"op"`
+			Key     json.RawMessage              `json:"key"`
+			Columns []string                     `json:"columns"`
+			After   map[string]string            `json:"after"`
+			Row     map[string]string            `json:"row"`
+			Changes map[string]map[string]string `json:"changes"`
+		}
+		if err := dec.Decode(&op); err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, fmt.Errorf("patch entry %d: %w", n, err)
+		}
+		if op.Op == "header" {
+			if err := json.Unmarshal(op.Key, &p.keys); err != nil {
+				return nil, fmt.Errorf("patch header: bad key: %w", err)
+			}
+			p.columns = op.Columns
+			continue
+		}
+		if p.keys == nil {
+			return nil, fmt.Errorf("patch entry %d: %s before the header", n, op.Op)
+		}
+		var keyObj map[string]string
+		if err := json.Unmarshal(op.Key, &keyObj); err != nil {
+			return nil, fmt.Errorf("patch entry %d: bad key: %w", n, err)
+		}
+		k := joinKey(keyOf(p.keys, keyObj))
+		switch op.Op {
+		case "remove":
+			p.removed[k] = true
+		case "change":
+			set := map[string]string{}
+			for col, c := range op.Changes {
+				set[col] = c["new"]
+			}
+			p.changes[k] = set
+		case "add":
+			after := ""
+			if op.After != nil {
+				after = joinKey(keyOf(p.keys, op.After))
+			}
+			row := make([]string, len(p.columns))
+			for i, c := range p.columns {
+				row[i] = op.Row[c]
+			}
+			p.add(after, row)
+		default:
+			return nil, fmt.Errorf("patch entry %d: unknown op %q", n, op.Op)
+		}
+	}
+	if p.keys == nil {
+		return nil, fmt.Errorf("patch has no header")
+	}
+	return p, nil
+}
+
+func readCSVPatch(data []byte, keys []string) (*patch, error) {
+	r := csv.NewReader(bytes.NewReader(data))
+	r.FieldsPerRecord = -1
+	header, err := r.Read()
+	if err != nil {
+		return nil, fmt.Errorf("patch: %w", err)
+	}
+	if len(header) < 3 || header[0] != "_op" || header[1] != "_after" {
+		return nil, fmt.Errorf("patch: not a csvdiff patch (no _op, _after columns)")
+	}
+	p := newPatch()
+	p.columns = header[2:]
+	// csvdiff records the key in a "key" row; older patches rely on -k.
+	first, err := r.Read()
+	if err != nil && err != io.EOF {
+		return nil, fmt.Errorf("patch: %w", err)
+	}
+	line := 2
+	if first != nil && first[0] == "key" {
+		var recorded []string
+		if err := json.Unmarshal([]byte(first[1]), &recorded); err != nil || len(recorded) == 0 {
+			return nil, fmt.Errorf("patch line 2: bad key %q", first[1])
+		}
+		if len(keys) > 0 && strings.Join(keys, ",") != strings.Join(recorded, ",") {
+			return nil, fmt.Errorf("patch key is %s, but -k gives %s", strings.Join(recorded, ","), strings.Join(keys, ","))
+		}
+		keys, first, line = recorded, nil, 3
+	}
+	if len(keys) == 0 {
+		keys = p.columns[:1]
+	}
+	p.keys = keys
+	index := map[string]int{}
+	for i, c := range p.columns {
+		index[c] = i
+	}
+	keyIdx := make([]int, len(keys))
+	for i, k := range keys {
+		j, ok := index[k]
+		if !ok {
+			return nil, fmt.Errorf("patch: no key column %q", k)
+		}
+		keyIdx[i] = j
+	}
+	for ; ; line++ {
+		rec := first
+		if rec != nil {
+			first = nil
+		} else if rec, err = r.Read(); err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, fmt.Errorf("patch: %w", err)
+		}
+		row := make([]string, len(p.columns))
+		copy(row, rec[2:])
+		key := make([]string, len(keyIdx))
+		for i, j := range keyIdx {
+			key[i] = row[j]
+		}
+		k := joinKey(key)
+		switch rec[0] {
+		case "remove":
+			p.removed[k] = true
+		case "change":
+			set := map[string]string{}
+			for i, c := range p.columns {
+				set[c] = row[i]
+			}
+			p.changes[k] = set
+		case "add":
+			after := ""
+			if rec[1] != "" {
+				var prev []string
+				if err := json.Unmarshal([]byte(rec[1]), &prev); err != nil {
+					return nil, fmt.Errorf("patch line %d: bad _after %q", line, rec[1])
+				}
+				if len(prev) != len(keys) {
+					return nil, fmt.Errorf("patch line %d: _after %s has %d key values but the key has %d (%s); use -k to name the key columns",
+						line, rec[1], len(prev), len(keys), strings.Join(keys, ","))
+				}
+				after = joinKey(prev)
+			}
+			p.add(after, row)
+		default:
+			return nil, fmt.Errorf("patch line %d: unknown op %q", line, rec[0])
+		}
+	}
+	return p, nil
+}
+
+// emitAdded writes the rows added after key, and recursively those added
+// after them.
+func (p *patch) emitAdded(w *csv.Writer, key string, keyIdx []int) error {
+	rows := p.after[key]
+	delete(p.after, key)
+	for _, row := range rows {
+		if err := w.Write(row); err != nil {
+			return err
+		}
+		k := make([]string, len(keyIdx))
+		for i, j := range keyIdx {
+			k[i] = row[j]
+		}
+		if err := p.emitAdded(w, joinKey(k), keyIdx); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func main() {
+	var files []string
+	args := os.Args[1:]
+	for {
+		flag.CommandLine.Parse(args)
+		args = flag.Args()
+		if len(args) == 0 {
+			break
+		}
+		files = append(files, args[0])
+		args = args[1:]
+	}
+	if len(files) != 2 {
+		fmt.Fprintln(os.Stderr, "usage: csvpatch [OPTIONS] OLD PATCH")
+		flag.PrintDefaults()
+		os.Exit(2)
+	}
+
+	data, err := os.ReadFile(files[1])
+	if err != nil {
+		fatal("%v", err)
+	}
+	var p *patch
+	if t := bytes.TrimSpace(data); len(t) > 0 && t[0] == '{' {
+		p, err = readJSONPatch(data)
+	} else {
+		p, err = readCSVPatch(data, keySpec)
+	}
+	if err != nil {
+		fatal("%s: %v", files[1], err)
+	}
+
+	opt := tabular.Options{Format: tabular.FormatForName(files[0])}
+	comma := ','
+	if *tabMode {
+		comma = '\t'
+	} else if r := []rune(*delim); len(r) > 0 {
+		comma = r[0]
+	}
+	if comma != ',' {
+		opt.Comma = comma
+	}
+	in, err := os.Open(files[0])
+	if err != nil {
+		fatal("%v", err)
+	}
+	defer in.Close()
+	r := tabular.NewReader(in, opt)
+	header, err := r.Header()
+	if err != nil {
+		fatal("%s: %v", files[0], err)
+	}
+	oldIdx := map[string]int{}
+	for i, h := range header {
+		oldIdx[h] = i
+	}
+	var oldKey []int
+	for _, k := range p.keys {
+		i, ok := oldIdx[k]
+		if !ok {
+			fatal("%s: no key column %q", files[0], k)
+		}
+		oldKey = append(oldKey, i)
+	}
+	newIdx := map[string]int{}
+	for i, c := range p.columns {
+		newIdx[c] = i
+	}
+	var newKey []int
+	for _, k := range p.keys {
+		i, ok := newIdx[k]
+		if !ok {
+			fatal("%s: patch columns lack key %q", files[1], k)
+		}
+		newKey = append(newKey, i)
+	}
+
+	var out io.Writer = os.Stdout
+	var buf *bytes.Buffer
+	if *outFile != "" {
+		// Write the file only once the whole patch has applied.
+		buf = &bytes.Buffer{}
+		out = buf
+	}
+	bw := bufio.NewWriter(out)
+	w := csv.NewWriter(bw)
+	w.Comma = comma
+	w.Write(p.columns)
+	if err := p.emitAdded(w, "", newKey); err != nil {
+		fatal("%v", err)
+	}
+
+	for {
+		rec, err := r.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			fatal("%s: %v", files[0], err)
+		}
+		key := make([]string, len(oldKey))
+		for i, j := range oldKey {
+			if j < len(rec) {
+				key[i] = rec[j]
+			}
+		}
+		k := joinKey(key)
+		if p.removed[k] {
+			p.applied[k] = true
+			continue
+		}
+		row := make([]string, len(p.columns))
+		for i, c := range p.columns {
+			if j, ok := oldIdx[c]; ok && j < len(rec) {
+				row[i] = rec[j]
+			}
+		}
+		if set, ok := p.changes[k]; ok {
+			p.applied[k] = true
+			for c, v := range set {
+				if i, ok := newIdx[c]; ok {
+					row[i] = v
+				}
+			}
+		}
+		w.Write(row)
+		if err := p.emitAdded(w, k, newKey); err != nil {
+			fatal("%v", err)
+		}
+	}
+	// Rows added after a row that moved have no anchor; append them.
+	for _, k := range p.afterOrder {
+		if err := p.emitAdded(w, k, newKey); err != nil {
+			fatal("%v", err)
+		}
+	}
+
+	missing := 0
+	for k := range p.removed {
+		if !p.applied[k] {
+			missing++
+		}
+	}
+	for k := range p.changes {
+		if !p.applied[k] {
+			missing++
+		}
+	}
+	if missing > 0 {
+		fatal("patch does not apply: %d changed or removed rows are not in %s", missing, files[0])
+	}
+	w.Flush()
+	if err := w.Error(); err != nil {
+		fatal("%v", err)
+	}
+	bw.Flush()
+	if buf != nil {
+		if err := os.WriteFile(*outFile, buf.Bytes(), 0644); err != nil {
+			fatal("%v", err)
+		}
+	}
+}
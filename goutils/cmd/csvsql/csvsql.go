@@ -0,0 +1,255 @@
+// csvsql - Run SQL queries over CSV, TSV and JSON Lines files.
+//
+// Usage:
+//
+//	csvsql [OPTIONS] QUERY [[NAME=]FILE...]
+//	cat data.csv | csvsql "SELECT ... FROM stdin"
+//
+// Each file becomes a table named after its base name without extension
+// (non-alphanumerics become _), or NAME when given as NAME=FILE. Standard
+// input is the table "stdin". A FROM clause may also name a file directly
+// as a string: FROM 'data/users.csv'. Column types (int, float, bool, date,
+// text) are inferred per column; empty cells are NULL.
+//
+// Supported SQL:
+//
+//	SELECT [DISTINCT] expr [AS alias], ... | * | t.*
+//	FROM t [AS a] [[INNER|LEFT [OUTER]|CROSS] JOIN u [AS b] ON cond]...
+//	WHERE cond  GROUP BY expr, ...  HAVING cond
+//	ORDER BY expr|alias|position [ASC|DESC], ...  LIMIT n [OFFSET m]
+//
+//	operators: = != <> < <= > >= AND OR NOT IS [NOT] NULL [NOT] LIKE
+//	           [NOT] IN (...) [NOT] BETWEEN ... AND ...  + - * / % ||
+//	           CASE [x] WHEN ... THEN ... [ELSE ...] END  CAST(x AS type)
+//	aggregates: count(*), count([DISTINCT] x), sum, total, avg, min, max,
+//	            group_concat(x[, sep])
+//	functions: lower upper length trim ltrim rtrim substr replace instr
+//	           abs round floor ceil coalesce ifnull nullif year month day
+//
+// Options:
+//
+//	-d SEP    Input delimiter (default: ,)
+//	-t        Tab-separated input (shortcut for -d $'\t')
+//	-i FMT    Input format: csv|tsv|jsonl (default: from extension, else sniffed)
+//	-H        Input has no header row; columns are named 1, 2, ... (use "1")
+//	-I        Disable type inference; every column is text
+//	-o FMT    Output format: table|csv|json (default: table)
+//	-j        JSON output (shortcut for -o json)
+//	-w N      Max column width in table output (truncate, default: 30)
+//	--style   Border style: ascii|unicode|minimal (default: unicode)
+//
+// Examples:
+//
+//	csvsql "SELECT name, age FROM users WHERE age >= 30 ORDER BY age DESC" users.csv
+//	csvsql "SELECT dept, count(*) n, avg(salary) FROM staff GROUP BY dept HAVING n > 2" staff.csv
+//	csvsql "SELECT o.id, c.name FROM orders o JOIN customers c ON o.cust = c.id" orders.csv customers.tsv
+//	csvsql -o csv "SELECT DISTINCT country FROM stdin" < visits.jsonl
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"goutils/internal/tabular"
+)
+
+var (
+	delim    = flag.String("d", ",", "delimiter")
+	tabMode  = flag.Bool("t", false, "TSV mode")
+	inFormat = flag.String("i", "", "input format")
+	noHeader = flag.Bool("H", false, "no header")
+	noInfer  = flag.Bool("I", false, "no type inference")
+	outFmt   = flag.String("o", "table", "output format")
+	asJSON   = flag.Bool("j", false, "JSON output")
+	maxWidth = flag.Int("w", 30, "max column width")
+	style    = flag.String("style", "unicode", "border style")
+)
+
+func fatal(format string, args ...interface{}) {
+	fmt.Fprintf(os.Stderr, "csvsql: "+format+"\n", args...)
+	os.Exit(1)
+}
+
+func main() {
+	flag.Usage = func() {
+		fmt.Fprintln(os.Stderr, "usage: csvsql [OPTIONS] QUERY [[NAME=]FILE...]")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if flag.NArg() == 0 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	format, err := tabular.ParseFormat(*inFormat)
+	if err != nil {
+		fatal("%v", err)
+	}
+	opt := tabular.Options{Format: format, NoHeader: *noHeader}
+	if *tabMode {
+		opt.Comma = '\t'
+	} else if r := []rune(*delim); len(r) > 0 && *delim != "," {
+		opt.Comma = r[0]
+	}
+	if *asJSON {
+		*outFmt = "json"
+	}
+	switch *outFmt {
+	case "table", "csv", "json":
+	default:
+		fatal("unknown output format %q (want table, csv or json)", *outFmt)
+	}
+
+	q, err := parse(flag.Arg(0))
+	if err != nil {
+		fatal("%v", err)
+	}
+
+	paths := map[string]string{}
+	var names []string
+	register := func(name, path string) {
+		if _, dup := paths[strings.ToLower(name)]; dup {
+			fatal("table name %q is used twice; name one with NAME=FILE", name)
+		}
+		paths[strings.ToLower(name)] = path
+		names = append(names, name)
+	}
+	for _, arg := range flag.Args()[1:] {
+		if i := strings.IndexByte(arg, '='); i > 0 {
+			if _, err := os.Stat(arg); err != nil {
+				register(arg[:i], arg[i+1:])
+				continue
+			}
+		}
+		if arg == "-" {
+			register("stdin", "-")
+			continue
+		}
+		register(tableName(arg), arg)
+	}
+	if flag.NArg() == 1 {
+		register("stdin", "-")
+	}
+
+	cache := map[string]*table{}
+	load := func(name string) (*table, error) {
+		path, ok := paths[strings.ToLower(name)]
+		if !ok {
+			// A FROM clause may name a file directly.
+			if _, err := os.Stat(name); err != nil {
+				return nil, fmt.Errorf("no table %q (tables: %s)", name, strings.Join(names, ", "))
+			}
+			path = name
+		}
+		if t, ok := cache[path]; ok {
+			return t, nil
+		}
+		t, err := readTable(path, opt)
+		if err != nil {
+			return nil, err
+		}
+		t.name = name
+		cache[path] = t
+		return t, nil
+	}
+
+	res, err := execute(q, load)
+	if err != nil {
+		fatal("%v", err)
+	}
+	if err := write(res); err != nil {
+		fatal("%v", err)
+	}
+}
+
+// tableName derives a table name from a file path: the base name without
+// extension, with anything but letters, digits and _ replaced by _.
+func tableName(path string) string {
+	base := filepath.Base(path)
+	base = strings.TrimSuffix(base, filepath.Ext(base))
+	name := strings.Map(func(r rune) rune {
+		if r == '_' || r >= '0' && r <= '9' || r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' {
+			return r
+		}
+		return '_'
+	}, base)
+	if name == "" || name[0] >= '0' && name[0] <= '9' {
+		name = "_" + name
+	}
+	return name
+}
+
+func readTable(path string, opt tabular.Options) (*table, error) {
+	in := os.Stdin
+	if path != "-" {
+		f, err := os.Open(path)
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+		in = f
+		if opt.Format == tabular.FormatAuto {
+			opt.Format = tabular.FormatForName(path)
+		}
+	}
+	header, raw, err := tabular.NewReader(in, opt).ReadAll()
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	kinds := make([]tabular.Kind, len(header))
+	if !*noInfer {
+		kinds = tabular.InferColumns(len(header), raw)
+	}
+	t := &table{cols: header, rows: make([][]value, len(raw))}
+	for r, rec := range raw {
+		row := make([]value, len(header))
+		for i := range header {
+			if i < len(rec) {
+				row[i] = tabular.Convert(rec[i], kinds[i])
+			}
+		}
+		t.rows[r] = row
+	}
+	return t, nil
+}
+
+func write(res *result) error {
+	if *outFmt == "json" {
+		return tabular.WriteJSON(os.Stdout, res.cols, res.rows)
+	}
+	rows := make([][]string, len(res.rows))
+	for r, row := range res.rows {
+		rows[r] = make([]string, len(row))
+		for i, v := range row {
+			rows[r][i] = tabular.FormatValue(v)
+		}
+	}
+	if *outFmt == "csv" {
+		return tabular.WriteCSV(os.Stdout, res.cols, rows, ',')
+	}
+	// Right-align columns that hold only numbers.
+	right := make([]bool, len(res.cols))
+	for i := range res.cols {
+		numeric := false
+		for _, row := range res.rows {
+			switch row[i].(type) {
+			case nil:
+				continue
+			case int64, float64:
+				numeric = true
+				continue
+			}
+			numeric = false
+			break
+		}
+		right[i] = numeric
+	}
+	return tabular.WriteTable(os.Stdout, res.cols, rows, tabular.TableOptions{
+		Style:      *style,
+		MaxWidth:   *maxWidth,
+		RightAlign: right,
+	})
+}
@@ -0,0 +1,622 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"goutils/internal/tabular"
+)
+
+// value is nil (SQL NULL), bool, int64, float64, string or time.Time.
+type value = interface{}
+
+// env is what an expression is evaluated against: one (possibly joined)
+// row and, inside a group, the finished aggregate values.
+type env struct {
+	row  []value
+	aggs []value
+}
+
+func eval(e expr, en *env) (value, error) {
+	switch e := e.(type) {
+	case *literal:
+		return e.v, nil
+	case *colRef:
+		if e.alias != nil {
+			return eval(e.alias, en)
+		}
+		return en.row[e.idx], nil
+	case *unary:
+		x, err := eval(e.x, en)
+		if err != nil || x == nil {
+			return nil, err
+		}
+		if e.op == "not" {
+			return !truthy(x), nil
+		}
+		switch x := number(x).(type) {
+		case int64:
+			return -x, nil
+		case float64:
+			return -x, nil
+		}
+		return nil, nil
+	case *binary:
+		return evalBinary(e, en)
+	case *funcCall:
+		if aggregates[e.name] {
+			if en.aggs == nil {
+				return nil, fmt.Errorf("%s() is not allowed here", e.name)
+			}
+			return en.aggs[e.slot], nil
+		}
+		args := make([]value, len(e.args))
+		for i, a := range e.args {
+			v, err := eval(a, en)
+			if err != nil {
+				return nil, err
+			}
+			args[i] = v
+		}
+		return callScalar(e.name, args)
+	case *inList:
+		x, err := eval(e.x, en)
+		if err != nil || x == nil {
+			return nil, err
+		}
+		sawNull := false
+		for _, item := range e.list {
+			v, err := eval(item, en)
+			if err != nil {
+				return nil, err
+			}
+			if v == nil {
+				sawNull = true
+				continue
+			}
+			if c, ok := compare(x, v); ok && c == 0 {
+				return !e.not, nil
+			}
+		}
+		if sawNull {
+			return nil, nil
+		}
+		return e.not, nil
+	case *between:
+		x, err := eval(e.x, en)
+		if err != nil {
+			return nil, err
+		}
+		lo, err := eval(e.lo, en)
+		if err != nil {
+			return nil, err
+		}
+		hi, err := eval(e.hi, en)
+		if err != nil {
+			return nil, err
+		}
+		c1, ok1 := compare(x, lo)
+		c2, ok2 := compare(x, hi)
+		if !ok1 || !ok2 {
+			return nil, nil
+		}
+		return (c1 >= 0 && c2 <= 0) != e.not, nil
+	case *isNull:
+		x, err := eval(e.x, en)
+		if err != nil {
+			return nil, err
+		}
+		return (x == nil) != e.not, nil
+	case *likeExpr:
+		x, err := eval(e.x, en)
+		if err != nil {
+			return nil, err
+		}
+		pat, err := eval(e.pattern, en)
+		if err != nil || x == nil || pat == nil {
+			return nil, err
+		}
+		return likeMatch(tabular.FormatValue(pat), tabular.FormatValue(x)) != e.not, nil
+	case *caseExpr:
+		var operand value
+		if e.operand != nil {
+			v, err := eval(e.operand, en)
+			if err != nil {
+				return nil, err
+			}
+			operand = v
+		}
+		for _, w := range e.whens {
+			c, err := eval(w.cond, en)
+			if err != nil {
+				return nil, err
+			}
+			var hit bool
+			if e.operand != nil {
+				cmp, ok := compare(operand, c)
+				hit = ok && cmp == 0
+			} else {
+				hit = truthy(c)
+			}
+			if hit {
+				return eval(w.result, en)
+			}
+		}
+		if e.els != nil {
+			return eval(e.els, en)
+		}
+		return nil, nil
+	case *castExpr:
+		x, err := eval(e.x, en)
+		if err != nil {
+			return nil, err
+		}
+		return cast(x, e.typ), nil
+	}
+	return nil, fmt.Errorf("cannot evaluate %T", e)
+}
+
+func evalBinary(e *binary, en *env) (value, error) {
+	l, err := eval(e.l, en)
+	if err != nil {
+		return nil, err
+	}
+	// AND and OR use three-valued logic and short-circuit.
+	switch e.op {
+	case "and":
+		if l != nil && !truthy(l) {
+			return false, nil
+		}
+		r, err := eval(e.r, en)
+		if err != nil {
+			return nil, err
+		}
+		if r != nil && !truthy(r) {
+			return false, nil
+		}
+		if l == nil || r == nil {
+			return nil, nil
+		}
+		return true, nil
+	case "or":
+		if l != nil && truthy(l) {
+			return true, nil
+		}
+		r, err := eval(e.r, en)
+		if err != nil {
+			return nil, err
+		}
+		if r != nil && truthy(r) {
+			return true, nil
+		}
+		if l == nil || r == nil {
+			return nil, nil
+		}
+		return false, nil
+	}
+	r, err := eval(e.r, en)
+	if err != nil || l == nil || r == nil {
+		return nil, err
+	}
+	switch e.op {
+	case "=", "!=", "<", "<=", ">", ">=":
+		c, ok := compare(l, r)
+		if !ok {
+			return nil, nil
+		}
+		switch e.op {
+		case "=":
+			return c == 0, nil
+		case "!=":
+			return c != 0, nil
+		case "<":
+			return c < 0, nil
+		case "<=":
+			return c <= 0, nil
+		case ">":
+			return c > 0, nil
+		}
+		return c >= 0, nil
+	case "||":
+		return tabular.FormatValue(l) + tabular.FormatValue(r), nil
+	}
+	return arith(e.op, l, r), nil
+}
+
+// truthy interprets a value as a condition: true, or a non-zero number.
+func truthy(v value) bool {
+	switch v := number(v).(type) {
+	case bool:
+		return v
+	case int64:
+		return v != 0
+	case float64:
+		return v != 0
+	}
+	return false
+}
+
+// number converts numeric strings to int64 or float64 and returns other
+// values unchanged, so that arithmetic works on columns that were read
+// without type inference.
+func number(v value) value {
+	s, ok := v.(string)
+	if !ok {
+		return v
+	}
+	s = strings.TrimSpace(s)
+	if n, err := strconv.ParseInt(s, 10, 64); err == nil {
+		return n
+	}
+	if f, err := strconv.ParseFloat(s, 64); err == nil {
+		return f
+	}
+	return v
+}
+
+func toFloat(v value) (float64, bool) {
+	switch v := number(v).(type) {
+	case int64:
+		return float64(v), true
+	case float64:
+		return v, true
+	case bool:
+		if v {
+			return 1, true
+		}
+		return 0, true
+	}
+	return 0, false
+}
+
+func arith(op string, l, r value) value {
+	l, r = number(l), number(r)
+	li, lok := l.(int64)
+	ri, rok := r.(int64)
+	if lok && rok {
+		switch op {
+		case "+":
+			return li + ri
+		case "-":
+			return li - ri
+		case "*":
+			return li * ri
+		case "/":
+			if ri == 0 {
+				return nil
+			}
+			if li%ri == 0 {
+				return li / ri
+			}
+			return float64(li) / float64(ri)
+		case "%":
+			if ri == 0 {
+				return nil
+			}
+			return li % ri
+		}
+	}
+	lf, lok := toFloat(l)
+	rf, rok := toFloat(r)
+	if !lok || !rok {
+		return nil
+	}
+	switch op {
+	case "+":
+		return lf + rf
+	case "-":
+		return lf - rf
+	case "*":
+		return lf * rf
+	case "/":
+		if rf == 0 {
+			return nil
+		}
+		return lf / rf
+	case "%":
+		if rf == 0 {
+			return nil
+		}
+		return math.Mod(lf, rf)
+	}
+	return nil
+}
+
+// compare orders two non-null values. Numbers compare numerically, also
+// against numeric strings; dates compare against date strings; anything
+// else compares as text. ok is false if either value is NULL.
+func compare(a, b value) (int, bool) {
+	if a == nil || b == nil {
+		return 0, false
+	}
+	if at, ok := a.(time.Time); ok {
+		if bt, ok := asTime(b); ok {
+			return compareTime(at, bt), true
+		}
+	}
+	if bt, ok := b.(time.Time); ok {
+		if at, ok := asTime(a); ok {
+			return compareTime(at, bt), true
+		}
+	}
+	_, aStr := a.(string)
+	_, bStr := b.(string)
+	if !aStr || !bStr {
+		an, bn := number(a), number(b)
+		ai, aok := an.(int64)
+		bi, bok := bn.(int64)
+		if aok && bok {
+			switch {
+			case ai < bi:
+				return -1, true
+			case ai > bi:
+				return 1, true
+			}
+			return 0, true
+		}
+		_, aBool := an.(bool)
+		_, bBool := bn.(bool)
+		if aBool == bBool {
+			af, aok := toFloat(an)
+			bf, bok := toFloat(bn)
+			if aok && bok {
+				switch {
+				case af < bf:
+					return -1, true
+				case af > bf:
+					return 1, true
+				}
+				return 0, true
+			}
+		}
+	}
+	return strings.Compare(tabular.FormatValue(a), tabular.FormatValue(b)), true
+}
+
+func asTime(v value) (time.Time, bool) {
+	switch v := v.(type) {
+	case time.Time:
+		return v, true
+	case string:
+		return tabular.ParseDate(strings.TrimSpace(v))
+	}
+	return time.Time{}, false
+}
+
+func compareTime(a, b time.Time) int {
+	switch {
+	case a.Before(b):
+		return -1
+	case a.After(b):
+		return 1
+	}
+	return 0
+}
+
+var likeCache = map[string]*regexp.Regexp{}
+
+// likeMatch implements LIKE: % matches any run, _ one character, and
+// letters match case-insensitively.
+func likeMatch(pattern, s string) bool {
+	re, ok := likeCache[pattern]
+	if !ok {
+		var sb strings.Builder
+		sb.WriteString("(?is)^")
+		for _, r := range pattern {
+			switch r {
+			case '%':
+				sb.WriteString(".*")
+			case '_':
+				sb.WriteString(".")
+			default:
+				sb.WriteString(regexp.QuoteMeta(string(r)))
+			}
+		}
+		sb.WriteString("$")
+		re = regexp.MustCompile(sb.String())
+		likeCache[pattern] = re
+	}
+	return re.MatchString(s)
+}
+
+func cast(v value, typ string) value {
+	if v == nil {
+		return nil
+	}
+	switch typ {
+	case "integer", "int", "bigint":
+		switch n := number(v).(type) {
+		case int64:
+			return n
+		case float64:
+			return int64(n)
+		case bool:
+			if n {
+				return int64(1)
+			}
+			return int64(0)
+		}
+		return nil
+	case "real", "float", "double", "numeric", "decimal":
+		if f, ok := toFloat(v); ok {
+			return f
+		}
+		return nil
+	case "date", "timestamp", "datetime":
+		if t, ok := asTime(v); ok {
+			return t
+		}
+		return nil
+	case "boolean", "bool":
+		if b, ok := v.(string); ok {
+			if b, ok := tabular.ParseBool(strings.TrimSpace(b)); ok {
+				return b
+			}
+		}
+		return truthy(v)
+	}
+	return tabular.FormatValue(v)
+}
+
+// scalarArity gives the minimum and maximum argument counts of the scalar
+// functions; -1 means no maximum.
+var scalarArity = map[string][2]int{
+	"lower": {1, 1}, "upper": {1, 1}, "length": {1, 1}, "trim": {1, 1},
+	"ltrim": {1, 1}, "rtrim": {1, 1}, "substr": {2, 3}, "substring": {2, 3},
+	"replace": {3, 3}, "instr": {2, 2}, "abs": {1, 1}, "round": {1, 2},
+	"floor": {1, 1}, "ceil": {1, 1}, "coalesce": {1, -1}, "ifnull": {2, 2},
+	"nullif": {2, 2}, "year": {1, 1}, "month": {1, 1}, "day": {1, 1},
+}
+
+func checkArity(f *funcCall) error {
+	if aggregates[f.name] {
+		want := 1
+		if f.name == "group_concat" && len(f.args) == 2 {
+			want = 2
+		}
+		if !f.star && len(f.args) != want {
+			return fmt.Errorf("%s() takes one argument", f.name)
+		}
+		return nil
+	}
+	a, ok := scalarArity[f.name]
+	if !ok {
+		return fmt.Errorf("unknown function %s()", f.name)
+	}
+	if n := len(f.args); n < a[0] || (a[1] >= 0 && n > a[1]) {
+		return fmt.Errorf("wrong number of arguments to %s()", f.name)
+	}
+	return nil
+}
+
+func callScalar(name string, args []value) (value, error) {
+	switch name {
+	case "coalesce", "ifnull":
+		for _, a := range args {
+			if a != nil {
+				return a, nil
+			}
+		}
+		return nil, nil
+	case "nullif":
+		if c, ok := compare(args[0], args[1]); ok && c == 0 {
+			return nil, nil
+		}
+		return args[0], nil
+	}
+	for _, a := range args {
+		if a == nil {
+			return nil, nil
+		}
+	}
+	str := func(i int) string { return tabular.FormatValue(args[i]) }
+	switch name {
+	case "lower":
+		return strings.ToLower(str(0)), nil
+	case "upper":
+		return strings.ToUpper(str(0)), nil
+	case "length":
+		return int64(utf8.RuneCountInString(str(0))), nil
+	case "trim":
+		return strings.TrimSpace(str(0)), nil
+	case "ltrim":
+		return strings.TrimLeft(str(0), " \t"), nil
+	case "rtrim":
+		return strings.TrimRight(str(0), " \t"), nil
+	case "replace":
+		return strings.ReplaceAll(str(0), str(1), str(2)), nil
+	case "instr":
+		i := strings.Index(str(0), str(1))
+		if i < 0 {
+			return int64(0), nil
+		}
+		return int64(utf8.RuneCountInString(str(0)[:i]) + 1), nil
+	case "substr", "substring":
+		runes := []rune(str(0))
+		start, ok := toFloat(args[1])
+		if !ok {
+			return nil, nil
+		}
+		// Positions are 1-based; negative positions count from the end.
+		from := int(start) - 1
+		if start < 0 {
+			from = len(runes) + int(start)
+		}
+		to := len(runes)
+		if len(args) == 3 {
+			n, ok := toFloat(args[2])
+			if !ok {
+				return nil, nil
+			}
+			to = from + int(n)
+		}
+		if from < 0 {
+			from = 0
+		}
+		if to > len(runes) {
+			to = len(runes)
+		}
+		if from >= to {
+			return "", nil
+		}
+		return string(runes[from:to]), nil
+	case "abs":
+		switch n := number(args[0]).(type) {
+		case int64:
+			if n < 0 {
+				return -n, nil
+			}
+			return n, nil
+		case float64:
+			return math.Abs(n), nil
+		}
+		return nil, nil
+	case "round", "floor", "ceil":
+		n := number(args[0])
+		if i, ok := n.(int64); ok && name != "round" {
+			return i, nil
+		}
+		f, ok := toFloat(n)
+		if !ok {
+			return nil, nil
+		}
+		switch name {
+		case "floor":
+			return int64(math.Floor(f)), nil
+		case "ceil":
+			return int64(math.Ceil(f)), nil
+		}
+		digits := 0.0
+		if len(args) == 2 {
+			if digits, ok = toFloat(args[1]); !ok {
+				return nil, nil
+			}
+		}
+		if digits <= 0 {
+			if _, isInt := n.(int64); isInt {
+				return n, nil
+			}
+			return math.Round(f), nil
+		}
+		p := math.Pow(10, math.Trunc(digits))
+		return math.Round(f*p) / p, nil
+	case "year", "month", "day":
+		t, ok := asTime(args[0])
+		if !ok {
+			return nil, nil
+		}
+		switch name {
+		case "year":
+			return int64(t.Year()), nil
+		case "month":
+			return int64(t.Month()), nil
+		}
+		return int64(t.Day()), nil
+	}
+	return nil, fmt.Errorf("unknown function %s()", name)
+}
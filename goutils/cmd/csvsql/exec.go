@@ -0,0 +1,753 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"goutils/internal/tabular"
+)
+
+// table is a loaded input file with typed cells.
+type table struct {
+	name string
+	cols []string
+	rows [][]value
+}
+
+// column is one column of the FROM clause's combined row.
+type column struct {
+	table string // alias, or table name when there is none
+	name  string
+}
+
+type scope struct {
+	cols    []column
+	aliases map[string]expr // select-list aliases, once they are visible
+}
+
+// resolve finds the row offset of a column reference. Names match exactly
+// first and then case-insensitively; an unqualified name that is not a
+// column may be a select-list alias.
+func (s *scope) resolve(c *colRef) error {
+	for _, fold := range []bool{false, true} {
+		found := -1
+		for i, col := range s.cols {
+			if c.table != "" && !strings.EqualFold(col.table, c.table) {
+				continue
+			}
+			if col.name == c.name || (fold && strings.EqualFold(col.name, c.name)) {
+				if found >= 0 {
+					return fmt.Errorf("column %q is ambiguous; qualify it with a table name", c.name)
+				}
+				found = i
+			}
+		}
+		if found >= 0 {
+			c.idx = found
+			return nil
+		}
+	}
+	if e, ok := s.aliases[strings.ToLower(c.name)]; ok && c.table == "" {
+		c.alias = e
+		return nil
+	}
+	if c.table != "" {
+		for _, col := range s.cols {
+			if strings.EqualFold(col.table, c.table) {
+				return fmt.Errorf("no column %q in %s", c.name, c.table)
+			}
+		}
+		return fmt.Errorf("no table %q in FROM", c.table)
+	}
+	return fmt.Errorf("no column %q", c.name)
+}
+
+// prepare resolves column references in e and registers its aggregate calls
+// in aggs. Aggregates are rejected where aggOK is false and cannot nest.
+func (s *scope) prepare(e expr, aggs *[]*funcCall, aggOK bool) error {
+	var walk func(e expr, inAgg bool) error
+	walk = func(e expr, inAgg bool) error {
+		switch e := e.(type) {
+		case *colRef:
+			return s.resolve(e)
+		case *unary:
+			return walk(e.x, inAgg)
+		case *binary:
+			if err := walk(e.l, inAgg); err != nil {
+				return err
+			}
+			return walk(e.r, inAgg)
+		case *funcCall:
+			if err := checkArity(e); err != nil {
+				return err
+			}
+			isAgg := aggregates[e.name]
+			if isAgg {
+				if !aggOK {
+					return fmt.Errorf("%s() is not allowed here", e.name)
+				}
+				if inAgg {
+					return fmt.Errorf("aggregate calls cannot be nested")
+				}
+				e.slot = len(*aggs)
+				*aggs = append(*aggs, e)
+			}
+			for _, a := range e.args {
+				if err := walk(a, inAgg || isAgg); err != nil {
+					return err
+				}
+			}
+		case *inList:
+			if err := walk(e.x, inAgg); err != nil {
+				return err
+			}
+			for _, x := range e.list {
+				if err := walk(x, inAgg); err != nil {
+					return err
+				}
+			}
+		case *between:
+			for _, x := range []expr{e.x, e.lo, e.hi} {
+				if err := walk(x, inAgg); err != nil {
+					return err
+				}
+			}
+		case *isNull:
+			return walk(e.x, inAgg)
+		case *likeExpr:
+			if err := walk(e.x, inAgg); err != nil {
+				return err
+			}
+			return walk(e.pattern, inAgg)
+		case *caseExpr:
+			if e.operand != nil {
+				if err := walk(e.operand, inAgg); err != nil {
+					return err
+				}
+			}
+			for _, w := range e.whens {
+				if err := walk(w.cond, inAgg); err != nil {
+					return err
+				}
+				if err := walk(w.result, inAgg); err != nil {
+					return err
+				}
+			}
+			if e.els != nil {
+				return walk(e.els, inAgg)
+			}
+		case *castExpr:
+			return walk(e.x, inAgg)
+		}
+		return nil
+	}
+	return walk(e, false)
+}
+
+// result is the output of a query.
+type result struct {
+	cols []string
+	rows [][]value
+}
+
+// loader returns the table a FROM clause names.
+type loader func(name string) (*table, error)
+
+func execute(q *query, load loader) (*result, error) {
+	sc := &scope{}
+	rows := [][]value{{}}
+	if q.from != nil {
+		var err error
+		if rows, err = scan(q, sc, load); err != nil {
+			return nil, err
+		}
+	}
+
+	// Expand * and table.* into column references.
+	var items []selectItem
+	for _, it := range q.items {
+		if !it.star {
+			items = append(items, it)
+			continue
+		}
+		n := 0
+		for i, col := range sc.cols {
+			if it.starTable == "" || strings.EqualFold(col.table, it.starTable) {
+				items = append(items, selectItem{e: &colRef{table: col.table, name: col.name, idx: i}, text: col.name})
+				n++
+			}
+		}
+		if n == 0 && it.starTable != "" {
+			return nil, fmt.Errorf("no table %q in FROM", it.starTable)
+		}
+	}
+	if len(items) == 0 {
+		return nil, fmt.Errorf("SELECT * needs a FROM clause")
+	}
+
+	var aggs []*funcCall
+	if q.where != nil {
+		if err := sc.prepare(q.where, &aggs, false); err != nil {
+			return nil, fmt.Errorf("WHERE: %w", err)
+		}
+	}
+	for _, it := range items {
+		if err := sc.prepare(it.e, &aggs, true); err != nil {
+			return nil, err
+		}
+	}
+	// Later clauses may refer to select-list aliases.
+	sc.aliases = map[string]expr{}
+	for _, it := range items {
+		if it.alias != "" {
+			sc.aliases[strings.ToLower(it.alias)] = it.e
+		}
+	}
+	for _, g := range q.groupBy {
+		if err := sc.prepare(g, &aggs, false); err != nil {
+			return nil, fmt.Errorf("GROUP BY: %w", err)
+		}
+	}
+	if q.having != nil {
+		if err := sc.prepare(q.having, &aggs, true); err != nil {
+			return nil, fmt.Errorf("HAVING: %w", err)
+		}
+	}
+
+	res := &result{}
+	for _, it := range items {
+		name := it.alias
+		if name == "" {
+			name = it.text
+		}
+		res.cols = append(res.cols, name)
+	}
+
+	// ORDER BY terms name an output column by position or alias, or are
+	// expressions over the input row.
+	type sortKey struct {
+		out  int // output column, or -1
+		e    expr
+		desc bool
+	}
+	var keys []sortKey
+	for _, o := range q.orderBy {
+		k := sortKey{out: -1, e: o.e, desc: o.desc}
+		if lit, ok := o.e.(*literal); ok {
+			if n, ok := lit.v.(int64); ok {
+				if n < 1 || int(n) > len(items) {
+					return nil, fmt.Errorf("ORDER BY position %d is out of range", n)
+				}
+				k.out = int(n) - 1
+			}
+		}
+		if c, ok := o.e.(*colRef); ok && c.table == "" && k.out < 0 {
+			for i, it := range items {
+				if it.alias != "" && strings.EqualFold(it.alias, c.name) {
+					k.out = i
+					break
+				}
+			}
+		}
+		if k.out < 0 {
+			if err := sc.prepare(k.e, &aggs, true); err != nil {
+				return nil, fmt.Errorf("ORDER BY: %w", err)
+			}
+		}
+		keys = append(keys, k)
+	}
+
+	if q.where != nil {
+		kept := rows[:0]
+		for _, row := range rows {
+			v, err := eval(q.where, &env{row: row})
+			if err != nil {
+				return nil, err
+			}
+			if truthy(v) {
+				kept = append(kept, row)
+			}
+		}
+		rows = kept
+	}
+
+	// Each output row carries its sort values until ordering is done.
+	type outRow struct {
+		vals, sortVals []value
+	}
+	var out []outRow
+	emit := func(en *env) error {
+		if q.having != nil {
+			v, err := eval(q.having, en)
+			if err != nil {
+				return err
+			}
+			if !truthy(v) {
+				return nil
+			}
+		}
+		r := outRow{vals: make([]value, len(items))}
+		for i, it := range items {
+			v, err := eval(it.e, en)
+			if err != nil {
+				return err
+			}
+			r.vals[i] = v
+		}
+		for _, k := range keys {
+			if k.out >= 0 {
+				r.sortVals = append(r.sortVals, r.vals[k.out])
+				continue
+			}
+			v, err := eval(k.e, en)
+			if err != nil {
+				return err
+			}
+			r.sortVals = append(r.sortVals, v)
+		}
+		out = append(out, r)
+		return nil
+	}
+
+	if len(q.groupBy) > 0 || len(aggs) > 0 {
+		groups, err := group(q.groupBy, rows)
+		if err != nil {
+			return nil, err
+		}
+		for _, g := range groups {
+			vals, err := aggregate(aggs, g)
+			if err != nil {
+				return nil, err
+			}
+			// Bare columns take their values from the group's first row.
+			row := make([]value, len(sc.cols))
+			if len(g) > 0 {
+				row = g[0]
+			}
+			if err := emit(&env{row: row, aggs: vals}); err != nil {
+				return nil, err
+			}
+		}
+	} else {
+		if q.having != nil {
+			return nil, fmt.Errorf("HAVING needs GROUP BY or an aggregate")
+		}
+		for _, row := range rows {
+			if err := emit(&env{row: row}); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	if q.distinct {
+		seen := map[string]bool{}
+		kept := out[:0]
+		for _, r := range out {
+			k := rowKey(r.vals)
+			if !seen[k] {
+				seen[k] = true
+				kept = append(kept, r)
+			}
+		}
+		out = kept
+	}
+
+	if len(keys) > 0 {
+		sort.SliceStable(out, func(i, j int) bool {
+			for n, k := range keys {
+				c := orderCompare(out[i].sortVals[n], out[j].sortVals[n])
+				if c != 0 {
+					if k.desc {
+						return c > 0
+					}
+					return c < 0
+				}
+			}
+			return false
+		})
+	}
+
+	if q.offset > 0 {
+		if q.offset >= len(out) {
+			out = nil
+		} else {
+			out = out[q.offset:]
+		}
+	}
+	if q.limit >= 0 && q.limit < len(out) {
+		out = out[:q.limit]
+	}
+	for _, r := range out {
+		res.rows = append(res.rows, r.vals)
+	}
+	return res, nil
+}
+
+// orderCompare sorts NULLs first, like SQLite.
+func orderCompare(a, b value) int {
+	switch {
+	case a == nil && b == nil:
+		return 0
+	case a == nil:
+		return -1
+	case b == nil:
+		return 1
+	}
+	c, _ := compare(a, b)
+	return c
+}
+
+// scan builds the joined rows of the FROM clause and fills in sc.
+func scan(q *query, sc *scope, load loader) ([][]value, error) {
+	add := func(ref tableRef) (*table, error) {
+		t, err := load(ref.name)
+		if err != nil {
+			return nil, err
+		}
+		label := ref.alias
+		if label == "" {
+			label = t.name
+		}
+		for _, c := range sc.cols {
+			if strings.EqualFold(c.table, label) {
+				return nil, fmt.Errorf("table %q appears twice in FROM; give one an alias", label)
+			}
+		}
+		for _, name := range t.cols {
+			sc.cols = append(sc.cols, column{table: label, name: name})
+		}
+		return t, nil
+	}
+
+	first, err := add(*q.from)
+	if err != nil {
+		return nil, err
+	}
+	rows := first.rows
+	for _, j := range q.joins {
+		width := len(sc.cols)
+		t, err := add(j.table)
+		if err != nil {
+			return nil, err
+		}
+		var aggs []*funcCall
+		if j.on != nil {
+			if err := sc.prepare(j.on, &aggs, false); err != nil {
+				return nil, fmt.Errorf("ON: %w", err)
+			}
+		}
+		if rows, err = joinRows(rows, t.rows, width, len(t.cols), j); err != nil {
+			return nil, err
+		}
+	}
+	return rows, nil
+}
+
+// joinRows joins left rows of the given width to right rows. Equality
+// conditions between the two sides are answered with a hash table; any
+// other conditions are checked per candidate pair.
+func joinRows(left, right [][]value, width, rightWidth int, j joinClause) ([][]value, error) {
+	var lkeys, rkeys []expr
+	var rest []expr
+	for _, c := range conjuncts(j.on) {
+		if b, ok := c.(*binary); ok && b.op == "=" {
+			ls, rs := side(b.l, width), side(b.r, width)
+			switch {
+			case ls == sideLeft && rs == sideRight:
+				lkeys, rkeys = append(lkeys, b.l), append(rkeys, b.r)
+				continue
+			case ls == sideRight && rs == sideLeft:
+				lkeys, rkeys = append(lkeys, b.r), append(rkeys, b.l)
+				continue
+			}
+		}
+		rest = append(rest, c)
+	}
+
+	combined := make([]value, width+rightWidth)
+	keyOf := func(keys []expr, row []value, offset int) (string, bool, error) {
+		copy(combined[offset:], row)
+		vals := make([]value, len(keys))
+		for i, k := range keys {
+			v, err := eval(k, &env{row: combined})
+			if err != nil || v == nil {
+				return "", false, err
+			}
+			vals[i] = v
+		}
+		return joinKey(vals), true, nil
+	}
+
+	var index map[string][]int
+	if len(lkeys) > 0 {
+		index = map[string][]int{}
+		for i, r := range right {
+			k, ok, err := keyOf(rkeys, r, width)
+			if err != nil {
+				return nil, err
+			}
+			if ok {
+				index[k] = append(index[k], i)
+			}
+		}
+	}
+
+	var out [][]value
+	for _, l := range left {
+		var candidates []int
+		if index != nil {
+			k, ok, err := keyOf(lkeys, l, 0)
+			if err != nil {
+				return nil, err
+			}
+			if ok {
+				candidates = index[k]
+			}
+		} else {
+			candidates = make([]int, len(right))
+			for i := range candidates {
+				candidates[i] = i
+			}
+		}
+		matched := false
+		for _, i := range candidates {
+			row := make([]value, 0, width+rightWidth)
+			row = append(append(row, l...), right[i]...)
+			keep := true
+			for _, c := range rest {
+				v, err := eval(c, &env{row: row})
+				if err != nil {
+					return nil, err
+				}
+				if !truthy(v) {
+					keep = false
+					break
+				}
+			}
+			if keep {
+				out = append(out, row)
+				matched = true
+			}
+		}
+		if !matched && j.kind == "left" {
+			row := make([]value, width+rightWidth)
+			copy(row, l)
+			out = append(out, row)
+		}
+	}
+	return out, nil
+}
+
+func conjuncts(e expr) []expr {
+	if e == nil {
+		return nil
+	}
+	if b, ok := e.(*binary); ok && b.op == "and" {
+		return append(conjuncts(b.l), conjuncts(b.r)...)
+	}
+	return []expr{e}
+}
+
+const (
+	sideNone = iota
+	sideLeft
+	sideRight
+	sideBoth
+)
+
+// side reports which half of a joined row e reads.
+func side(e expr, width int) int {
+	s := sideNone
+	var walk func(e expr)
+	walk = func(e expr) {
+		switch e := e.(type) {
+		case *colRef:
+			if e.idx < width {
+				s |= sideLeft
+			} else {
+				s |= sideRight
+			}
+		case *unary:
+			walk(e.x)
+		case *binary:
+			walk(e.l)
+			walk(e.r)
+		case *funcCall:
+			for _, a := range e.args {
+				walk(a)
+			}
+		case *castExpr:
+			walk(e.x)
+		default:
+			// Anything more involved is evaluated per pair.
+			s = sideBoth
+		}
+	}
+	walk(e)
+	return s
+}
+
+// joinKey encodes values so that those comparing equal encode the same:
+// numbers (and numeric strings) by value, dates by instant, and everything
+// else as text.
+func joinKey(vals []value) string {
+	var sb strings.Builder
+	for _, v := range vals {
+		switch n := number(v).(type) {
+		case int64:
+			sb.WriteString("n" + strconv.FormatInt(n, 10))
+		case float64:
+			if n == float64(int64(n)) {
+				sb.WriteString("n" + strconv.FormatInt(int64(n), 10))
+			} else {
+				sb.WriteString("n" + strconv.FormatFloat(n, 'g', -1, 64))
+			}
+		case time.Time:
+			sb.WriteString("t" + n.UTC().Format(time.RFC3339Nano))
+		default:
+			if t, ok := asTime(n); ok {
+				sb.WriteString("t" + t.UTC().Format(time.RFC3339Nano))
+			} else {
+				sb.WriteString("s" + tabular.FormatValue(n))
+			}
+		}
+		sb.WriteByte(0)
+	}
+	return sb.String()
+}
+
+// rowKey encodes a row for DISTINCT and GROUP BY, keeping NULL apart from
+// the empty string.
+func rowKey(vals []value) string {
+	var sb strings.Builder
+	for _, v := range vals {
+		if v == nil {
+			sb.WriteString("\x01")
+		} else {
+			sb.WriteString(joinKey([]value{v}))
+		}
+		sb.WriteByte(0)
+	}
+	return sb.String()
+}
+
+// group splits rows by the GROUP BY values in order of first appearance.
+// Without GROUP BY everything is one group, which exists even when there
+// are no rows so that count(*) can report 0.
+func group(by []expr, rows [][]value) ([][][]value, error) {
+	if len(by) == 0 {
+		return [][][]value{rows}, nil
+	}
+	index := map[string]int{}
+	var groups [][][]value
+	vals := make([]value, len(by))
+	for _, row := range rows {
+		for i, g := range by {
+			v, err := eval(g, &env{row: row})
+			if err != nil {
+				return nil, err
+			}
+			vals[i] = v
+		}
+		k := rowKey(vals)
+		i, ok := index[k]
+		if !ok {
+			i = len(groups)
+			index[k] = i
+			groups = append(groups, nil)
+		}
+		groups[i] = append(groups[i], row)
+	}
+	return groups, nil
+}
+
+// aggregate computes each aggregate call over the rows of one group.
+func aggregate(aggs []*funcCall, rows [][]value) ([]value, error) {
+	out := make([]value, len(aggs))
+	for n, f := range aggs {
+		if f.star {
+			out[n] = int64(len(rows))
+			continue
+		}
+		var vals []value
+		seen := map[string]bool{}
+		for _, row := range rows {
+			v, err := eval(f.args[0], &env{row: row})
+			if err != nil {
+				return nil, err
+			}
+			if v == nil {
+				continue
+			}
+			if f.distinct {
+				k := rowKey([]value{v})
+				if seen[k] {
+					continue
+				}
+				seen[k] = true
+			}
+			vals = append(vals, v)
+		}
+
+		switch f.name {
+		case "count":
+			out[n] = int64(len(vals))
+		case "sum", "total", "avg":
+			var isum int64
+			var fsum float64
+			count, allInt := 0, true
+			for _, v := range vals {
+				switch x := number(v).(type) {
+				case int64:
+					isum += x
+					fsum += float64(x)
+				case float64:
+					fsum += x
+					allInt = false
+				default:
+					continue
+				}
+				count++
+			}
+			switch {
+			case f.name == "avg" && count > 0:
+				out[n] = fsum / float64(count)
+			case f.name == "total":
+				out[n] = fsum
+			case f.name == "sum" && count > 0 && allInt:
+				out[n] = isum
+			case f.name == "sum" && count > 0:
+				out[n] = fsum
+			}
+		case "min", "max":
+			var best value
+			for _, v := range vals {
+				c, _ := compare(v, best)
+				if best == nil || (f.name == "min" && c < 0) || (f.name == "max" && c > 0) {
+					best = v
+				}
+			}
+			out[n] = best
+		case "group_concat":
+			sep := ","
+			if len(f.args) == 2 && len(rows) > 0 {
+				v, err := eval(f.args[1], &env{row: rows[0]})
+				if err != nil {
+					return nil, err
+				}
+				sep = tabular.FormatValue(v)
+			}
+			if len(vals) > 0 {
+				parts := make([]string, len(vals))
+				for i, v := range vals {
+					parts[i] = tabular.FormatValue(v)
+				}
+				out[n] = strings.Join(parts, sep)
+			}
+		}
+	}
+	return out, nil
+}
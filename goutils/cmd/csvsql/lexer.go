@@ -0,0 +1,130 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+)
+
+type tokKind int
+
+const (
+	tokEOF tokKind = iota
+	tokIdent
+	tokString
+	tokNumber
+	tokOp
+)
+
+type token struct {
+	kind   tokKind
+	s      string
+	quoted bool // a "quoted", `quoted` or [quoted] identifier, never a keyword
+	pos    int
+	end    int
+}
+
+// is reports whether t is the keyword or operator s.
+func (t token) is(s string) bool {
+	switch t.kind {
+	case tokIdent:
+		return !t.quoted && strings.EqualFold(t.s, s)
+	case tokOp:
+		return t.s == s
+	}
+	return false
+}
+
+func lex(src string) ([]token, error) {
+	var toks []token
+	i := 0
+	for i < len(src) {
+		c := src[i]
+		switch {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r':
+			i++
+		case c == '-' && strings.HasPrefix(src[i:], "--"):
+			for i < len(src) && src[i] != '\n' {
+				i++
+			}
+		case c == '\'':
+			s, n, err := quoted(src[i:], '\'')
+			if err != nil {
+				return nil, fmt.Errorf("position %d: %w", i+1, err)
+			}
+			toks = append(toks, token{kind: tokString, s: s, pos: i, end: i + n})
+			i += n
+		case c == '"' || c == '`' || c == '[':
+			closer := c
+			if c == '[' {
+				closer = ']'
+			}
+			s, n, err := quoted(src[i:], closer)
+			if err != nil {
+				return nil, fmt.Errorf("position %d: %w", i+1, err)
+			}
+			toks = append(toks, token{kind: tokIdent, s: s, quoted: true, pos: i, end: i + n})
+			i += n
+		case c >= '0' && c <= '9' || c == '.' && i+1 < len(src) && src[i+1] >= '0' && src[i+1] <= '9':
+			j := i
+			for j < len(src) && (src[j] >= '0' && src[j] <= '9' || src[j] == '.') {
+				j++
+			}
+			if j < len(src) && (src[j] == 'e' || src[j] == 'E') {
+				k := j + 1
+				if k < len(src) && (src[k] == '+' || src[k] == '-') {
+					k++
+				}
+				if k < len(src) && src[k] >= '0' && src[k] <= '9' {
+					for j = k; j < len(src) && src[j] >= '0' && src[j] <= '9'; j++ {
+					}
+				}
+			}
+			toks = append(toks, token{kind: tokNumber, s: src[i:j], pos: i, end: j})
+			i = j
+		case c == '_' || unicode.IsLetter(rune(c)) || c >= 0x80:
+			j := i
+			for j < len(src) && (src[j] == '_' || src[j] == '$' || src[j] >= 0x80 ||
+				unicode.IsLetter(rune(src[j])) || unicode.IsDigit(rune(src[j]))) {
+				j++
+			}
+			toks = append(toks, token{kind: tokIdent, s: src[i:j], pos: i, end: j})
+			i = j
+		default:
+			op := ""
+			for _, o := range []string{"<=", ">=", "<>", "!=", "==", "||"} {
+				if strings.HasPrefix(src[i:], o) {
+					op = o
+					break
+				}
+			}
+			if op == "" {
+				if !strings.ContainsRune("=<>+-*/%(),.;", rune(c)) {
+					return nil, fmt.Errorf("position %d: unexpected character %q", i+1, c)
+				}
+				op = string(c)
+			}
+			toks = append(toks, token{kind: tokOp, s: op, pos: i, end: i + len(op)})
+			i += len(op)
+		}
+	}
+	return append(toks, token{kind: tokEOF, pos: len(src), end: len(src)}), nil
+}
+
+// quoted reads a string delimited by src[0] and closer, where a doubled
+// closer stands for itself. It returns the contents and the bytes consumed.
+func quoted(src string, closer byte) (string, int, error) {
+	var sb strings.Builder
+	for i := 1; i < len(src); i++ {
+		if src[i] == closer {
+			if i+1 < len(src) && src[i+1] == closer {
+				sb.WriteByte(closer)
+				i++
+				continue
+			}
+			return sb.String(), i + 1, nil
+		}
+		sb.WriteByte(src[i])
+	}
+	return "", 0, fmt.Errorf("unterminated %c", src[0])
+}
@@ -0,0 +1,735 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// expr is a node of a parsed expression. Column references are resolved to
+// row offsets and aggregate calls to accumulator slots before execution.
+type expr interface{}
+
+type (
+	literal struct{ v value }
+	colRef  struct {
+		table, name string
+		idx         int
+		alias       expr // set when the name refers to a select-list alias
+	}
+	unary struct {
+		op string
+		x  expr
+	}
+	binary struct {
+		op   string
+		l, r expr
+	}
+	funcCall struct {
+		name     string // lower case
+		args     []expr
+		star     bool // count(*)
+		distinct bool
+		slot     int // accumulator index for aggregates
+	}
+	inList struct {
+		x    expr
+		list []expr
+		not  bool
+	}
+	between struct {
+		x, lo, hi expr
+		not       bool
+	}
+	isNull struct {
+		x   expr
+		not bool
+	}
+	likeExpr struct {
+		x, pattern expr
+		not        bool
+	}
+	caseExpr struct {
+		operand expr
+		whens   []whenClause
+		els     expr
+	}
+	whenClause struct{ cond, result expr }
+	castExpr   struct {
+		x   expr
+		typ string
+	}
+)
+
+type selectItem struct {
+	e         expr
+	alias     string
+	star      bool   // * or table.*
+	starTable string // qualifier of table.*
+	text      string // source text, the default column name
+}
+
+type tableRef struct {
+	name, alias string
+}
+
+type joinClause struct {
+	kind  string // inner, left or cross
+	table tableRef
+	on    expr
+}
+
+type orderItem struct {
+	e    expr
+	desc bool
+}
+
+type query struct {
+	distinct bool
+	items    []selectItem
+	from     *tableRef
+	joins    []joinClause
+	where    expr
+	groupBy  []expr
+	having   expr
+	orderBy  []orderItem
+	limit    int // -1 for none
+	offset   int
+}
+
+// reserved words end an expression where a bare alias could otherwise
+// follow.
+var reserved = map[string]bool{
+	"select": true, "distinct": true, "from": true, "where": true, "group": true,
+	"by": true, "having": true, "order": true, "limit": true, "offset": true,
+	"join": true, "inner": true, "left": true, "outer": true, "cross": true,
+	"on": true, "as": true, "and": true, "or": true, "not": true, "is": true,
+	"null": true, "in": true, "like": true, "between": true, "case": true,
+	"when": true, "then": true, "else": true, "end": true, "asc": true,
+	"desc": true, "union": true, "right": true, "full": true,
+}
+
+var aggregates = map[string]bool{
+	"count": true, "sum": true, "avg": true, "min": true, "max": true,
+	"total": true, "group_concat": true,
+}
+
+type parser struct {
+	src  string
+	toks []token
+	pos  int
+}
+
+func parse(src string) (*query, error) {
+	toks, err := lex(src)
+	if err != nil {
+		return nil, err
+	}
+	p := &parser{src: src, toks: toks}
+	q, err := p.query()
+	if err != nil {
+		return nil, err
+	}
+	p.accept(";")
+	if t := p.peek(); t.kind != tokEOF {
+		return nil, p.errorf(t, "unexpected %s", describe(t))
+	}
+	return q, nil
+}
+
+func (p *parser) peek() token { return p.toks[p.pos] }
+
+func (p *parser) next() token {
+	t := p.toks[p.pos]
+	if t.kind != tokEOF {
+		p.pos++
+	}
+	return t
+}
+
+func (p *parser) accept(s string) bool {
+	if p.peek().is(s) {
+		p.pos++
+		return true
+	}
+	return false
+}
+
+func (p *parser) expect(s string) error {
+	if !p.accept(s) {
+		t := p.peek()
+		return p.errorf(t, "expected %s, found %s", strings.ToUpper(s), describe(t))
+	}
+	return nil
+}
+
+func (p *parser) errorf(t token, format string, args ...interface{}) error {
+	return fmt.Errorf("syntax error at position %d: %s", t.pos+1, fmt.Sprintf(format, args...))
+}
+
+func describe(t token) string {
+	switch t.kind {
+	case tokEOF:
+		return "end of query"
+	case tokString:
+		return fmt.Sprintf("'%s'", t.s)
+	}
+	return fmt.Sprintf("%q", t.s)
+}
+
+// name reads an identifier that is not a reserved word.
+func (p *parser) name(what string) (string, error) {
+	t := p.peek()
+	if t.kind != tokIdent || (!t.quoted && reserved[strings.ToLower(t.s)]) {
+		return "", p.errorf(t, "expected %s, found %s", what, describe(t))
+	}
+	p.pos++
+	return t.s, nil
+}
+
+// alias reads an optional [AS] alias.
+func (p *parser) alias() (string, error) {
+	if p.accept("as") {
+		t := p.peek()
+		if t.kind == tokString {
+			p.pos++
+			return t.s, nil
+		}
+		return p.name("alias")
+	}
+	t := p.peek()
+	if t.kind == tokIdent && (t.quoted || !reserved[strings.ToLower(t.s)]) {
+		p.pos++
+		return t.s, nil
+	}
+	return "", nil
+}
+
+func (p *parser) query() (*query, error) {
+	if err := p.expect("select"); err != nil {
+		return nil, err
+	}
+	q := &query{limit: -1}
+	q.distinct = p.accept("distinct")
+	if !q.distinct {
+		p.accept("all")
+	}
+	for {
+		item, err := p.selectItem()
+		if err != nil {
+			return nil, err
+		}
+		q.items = append(q.items, item)
+		if !p.accept(",") {
+			break
+		}
+	}
+
+	if p.accept("from") {
+		t, err := p.tableRef()
+		if err != nil {
+			return nil, err
+		}
+		q.from = &t
+		for {
+			j, ok, err := p.join()
+			if err != nil {
+				return nil, err
+			}
+			if !ok {
+				break
+			}
+			q.joins = append(q.joins, j)
+		}
+	}
+
+	var err error
+	if p.accept("where") {
+		if q.where, err = p.expr(); err != nil {
+			return nil, err
+		}
+	}
+	if p.accept("group") {
+		if err := p.expect("by"); err != nil {
+			return nil, err
+		}
+		if q.groupBy, err = p.exprList(); err != nil {
+			return nil, err
+		}
+	}
+	if p.accept("having") {
+		if q.having, err = p.expr(); err != nil {
+			return nil, err
+		}
+	}
+	if p.accept("order") {
+		if err := p.expect("by"); err != nil {
+			return nil, err
+		}
+		for {
+			e, err := p.expr()
+			if err != nil {
+				return nil, err
+			}
+			o := orderItem{e: e}
+			if p.accept("desc") {
+				o.desc = true
+			} else {
+				p.accept("asc")
+			}
+			q.orderBy = append(q.orderBy, o)
+			if !p.accept(",") {
+				break
+			}
+		}
+	}
+	if p.accept("limit") {
+		if q.limit, err = p.count("LIMIT"); err != nil {
+			return nil, err
+		}
+		if p.accept("offset") {
+			if q.offset, err = p.count("OFFSET"); err != nil {
+				return nil, err
+			}
+		} else if p.accept(",") {
+			// LIMIT offset, count as in SQLite and MySQL.
+			q.offset = q.limit
+			if q.limit, err = p.count("LIMIT"); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return q, nil
+}
+
+func (p *parser) count(what string) (int, error) {
+	t := p.next()
+	n, err := strconv.Atoi(t.s)
+	if t.kind != tokNumber || err != nil || n < 0 {
+		return 0, p.errorf(t, "%s needs a non-negative integer, found %s", what, describe(t))
+	}
+	return n, nil
+}
+
+func (p *parser) selectItem() (selectItem, error) {
+	start := p.peek()
+	if p.accept("*") {
+		return selectItem{star: true, text: "*"}, nil
+	}
+	// table.*
+	if start.kind == tokIdent && p.toks[p.pos+1].is(".") && p.toks[p.pos+2].is("*") {
+		p.pos += 3
+		return selectItem{star: true, starTable: start.s, text: start.s + ".*"}, nil
+	}
+	e, err := p.expr()
+	if err != nil {
+		return selectItem{}, err
+	}
+	item := selectItem{e: e, text: p.src[start.pos:p.toks[p.pos-1].end]}
+	if c, ok := e.(*colRef); ok {
+		item.text = c.name
+	}
+	if item.alias, err = p.alias(); err != nil {
+		return selectItem{}, err
+	}
+	return item, nil
+}
+
+func (p *parser) tableRef() (tableRef, error) {
+	var t tableRef
+	tok := p.peek()
+	if tok.kind == tokString {
+		// FROM 'path/to/file.csv'
+		p.pos++
+		t.name = tok.s
+	} else {
+		name, err := p.name("table name")
+		if err != nil {
+			return t, err
+		}
+		t.name = name
+	}
+	var err error
+	t.alias, err = p.alias()
+	return t, err
+}
+
+func (p *parser) join() (joinClause, bool, error) {
+	var j joinClause
+	switch {
+	case p.accept(","):
+		j.kind = "cross"
+	case p.accept("cross"):
+		j.kind = "cross"
+	case p.accept("left"):
+		p.accept("outer")
+		j.kind = "left"
+	case p.accept("inner"):
+		j.kind = "inner"
+	case p.peek().is("join"):
+		j.kind = "inner"
+	case p.peek().is("right"), p.peek().is("full"):
+		t := p.peek()
+		return j, false, p.errorf(t, "%s JOIN is not supported; swap the tables and use LEFT JOIN", strings.ToUpper(t.s))
+	default:
+		return j, false, nil
+	}
+	if j.kind != "cross" || !p.toks[p.pos-1].is(",") {
+		if err := p.expect("join"); err != nil {
+			return j, false, err
+		}
+	}
+	var err error
+	if j.table, err = p.tableRef(); err != nil {
+		return j, false, err
+	}
+	if j.kind != "cross" {
+		if err := p.expect("on"); err != nil {
+			return j, false, err
+		}
+		if j.on, err = p.expr(); err != nil {
+			return j, false, err
+		}
+	}
+	return j, true, nil
+}
+
+func (p *parser) exprList() ([]expr, error) {
+	var list []expr
+	for {
+		e, err := p.expr()
+		if err != nil {
+			return nil, err
+		}
+		list = append(list, e)
+		if !p.accept(",") {
+			return list, nil
+		}
+	}
+}
+
+func (p *parser) expr() (expr, error) {
+	l, err := p.and()
+	if err != nil {
+		return nil, err
+	}
+	for p.accept("or") {
+		r, err := p.and()
+		if err != nil {
+			return nil, err
+		}
+		l = &binary{op: "or", l: l, r: r}
+	}
+	return l, nil
+}
+
+func (p *parser) and() (expr, error) {
+	l, err := p.not()
+	if err != nil {
+		return nil, err
+	}
+	for p.accept("and") {
+		r, err := p.not()
+		if err != nil {
+			return nil, err
+		}
+		l = &binary{op: "and", l: l, r: r}
+	}
+	return l, nil
+}
+
+func (p *parser) not() (expr, error) {
+	if p.accept("not") {
+		x, err := p.not()
+		if err != nil {
+			return nil, err
+		}
+		return &unary{op: "not", x: x}, nil
+	}
+	return p.predicate()
+}
+
+func (p *parser) predicate() (expr, error) {
+	l, err := p.concat()
+	if err != nil {
+		return nil, err
+	}
+	t := p.peek()
+	if t.kind == tokOp {
+		switch t.s {
+		case "=", "==", "!=", "<>", "<", "<=", ">", ">=":
+			p.pos++
+			r, err := p.concat()
+			if err != nil {
+				return nil, err
+			}
+			op := t.s
+			switch op {
+			case "==":
+				op = "="
+			case "<>":
+				op = "!="
+			}
+			return &binary{op: op, l: l, r: r}, nil
+		}
+	}
+	if p.accept("is") {
+		not := p.accept("not")
+		if err := p.expect("null"); err != nil {
+			return nil, err
+		}
+		return &isNull{x: l, not: not}, nil
+	}
+	not := p.accept("not")
+	switch {
+	case p.accept("like"):
+		r, err := p.concat()
+		if err != nil {
+			return nil, err
+		}
+		return &likeExpr{x: l, pattern: r, not: not}, nil
+	case p.accept("in"):
+		if err := p.expect("("); err != nil {
+			return nil, err
+		}
+		list, err := p.exprList()
+		if err != nil {
+			return nil, err
+		}
+		if err := p.expect(")"); err != nil {
+			return nil, err
+		}
+		return &inList{x: l, list: list, not: not}, nil
+	case p.accept("between"):
+		lo, err := p.concat()
+		if err != nil {
+			return nil, err
+		}
+		if err := p.expect("and"); err != nil {
+			return nil, err
+		}
+		hi, err := p.concat()
+		if err != nil {
+			return nil, err
+		}
+		return &between{x: l, lo: lo, hi: hi, not: not}, nil
+	}
+	if not {
+		t := p.peek()
+		return nil, p.errorf(t, "expected LIKE, IN or BETWEEN after NOT, found %s", describe(t))
+	}
+	return l, nil
+}
+
+func (p *parser) concat() (expr, error) {
+	l, err := p.additive()
+	if err != nil {
+		return nil, err
+	}
+	for p.accept("||") {
+		r, err := p.additive()
+		if err != nil {
+			return nil, err
+		}
+		l = &binary{op: "||", l: l, r: r}
+	}
+	return l, nil
+}
+
+func (p *parser) additive() (expr, error) {
+	l, err := p.multiplicative()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		t := p.peek()
+		if !t.is("+") && !t.is("-") {
+			return l, nil
+		}
+		p.pos++
+		r, err := p.multiplicative()
+		if err != nil {
+			return nil, err
+		}
+		l = &binary{op: t.s, l: l, r: r}
+	}
+}
+
+func (p *parser) multiplicative() (expr, error) {
+	l, err := p.unary()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		t := p.peek()
+		if !t.is("*") && !t.is("/") && !t.is("%") {
+			return l, nil
+		}
+		p.pos++
+		r, err := p.unary()
+		if err != nil {
+			return nil, err
+		}
+		l = &binary{op: t.s, l: l, r: r}
+	}
+}
+
+func (p *parser) unary() (expr, error) {
+	if p.accept("-") {
+		x, err := p.unary()
+		if err != nil {
+			return nil, err
+		}
+		return &unary{op: "-", x: x}, nil
+	}
+	if p.accept("+") {
+		return p.unary()
+	}
+	return p.primary()
+}
+
+func (p *parser) primary() (expr, error) {
+	t := p.next()
+	switch t.kind {
+	case tokNumber:
+		if n, err := strconv.ParseInt(t.s, 10, 64); err == nil {
+			return &literal{n}, nil
+		}
+		f, err := strconv.ParseFloat(t.s, 64)
+		if err != nil {
+			return nil, p.errorf(t, "bad number %s", t.s)
+		}
+		return &literal{f}, nil
+	case tokString:
+		return &literal{t.s}, nil
+	case tokOp:
+		if t.s == "(" {
+			e, err := p.expr()
+			if err != nil {
+				return nil, err
+			}
+			return e, p.expect(")")
+		}
+	case tokIdent:
+		if !t.quoted {
+			switch strings.ToLower(t.s) {
+			case "null":
+				return &literal{nil}, nil
+			case "true":
+				return &literal{true}, nil
+			case "false":
+				return &literal{false}, nil
+			case "case":
+				return p.caseExpr()
+			case "cast":
+				if p.peek().is("(") {
+					return p.castExpr()
+				}
+			}
+			if reserved[strings.ToLower(t.s)] {
+				break
+			}
+		}
+		if !t.quoted && p.peek().is("(") {
+			return p.call(strings.ToLower(t.s))
+		}
+		if p.accept(".") {
+			col, err := p.name("column name")
+			if err != nil {
+				return nil, err
+			}
+			return &colRef{table: t.s, name: col}, nil
+		}
+		return &colRef{name: t.s}, nil
+	}
+	return nil, p.errorf(t, "unexpected %s", describe(t))
+}
+
+func (p *parser) call(name string) (expr, error) {
+	p.pos++ // (
+	f := &funcCall{name: name}
+	if aggregates[name] {
+		if p.accept("*") {
+			if name != "count" {
+				return nil, p.errorf(p.toks[p.pos-1], "only count accepts *")
+			}
+			f.star = true
+			return f, p.expect(")")
+		}
+		f.distinct = p.accept("distinct")
+	}
+	if p.accept(")") {
+		return f, nil
+	}
+	args, err := p.exprList()
+	if err != nil {
+		return nil, err
+	}
+	f.args = args
+	return f, p.expect(")")
+}
+
+func (p *parser) caseExpr() (expr, error) {
+	c := &caseExpr{}
+	var err error
+	if !p.peek().is("when") {
+		if c.operand, err = p.expr(); err != nil {
+			return nil, err
+		}
+	}
+	for p.accept("when") {
+		var w whenClause
+		if w.cond, err = p.expr(); err != nil {
+			return nil, err
+		}
+		if err := p.expect("then"); err != nil {
+			return nil, err
+		}
+		if w.result, err = p.expr(); err != nil {
+			return nil, err
+		}
+		c.whens = append(c.whens, w)
+	}
+	if len(c.whens) == 0 {
+		t := p.peek()
+		return nil, p.errorf(t, "CASE needs at least one WHEN")
+	}
+	if p.accept("else") {
+		if c.els, err = p.expr(); err != nil {
+			return nil, err
+		}
+	}
+	return c, p.expect("end")
+}
+
+func (p *parser) castExpr() (expr, error) {
+	p.pos++ // (
+	x, err := p.expr()
+	if err != nil {
+		return nil, err
+	}
+	if err := p.expect("as"); err != nil {
+		return nil, err
+	}
+	t := p.next()
+	if t.kind != tokIdent {
+		return nil, p.errorf(t, "expected a type name, found %s", describe(t))
+	}
+	typ := strings.ToLower(t.s)
+	switch typ {
+	case "integer", "int", "bigint", "real", "float", "double", "numeric", "decimal",
+		"text", "varchar", "char", "string", "date", "timestamp", "datetime", "boolean", "bool":
+	default:
+		return nil, p.errorf(t, "unknown type %s", t.s)
+	}
+	// VARCHAR(20) and DECIMAL(10,2) sizes are accepted and ignored.
+	if p.accept("(") {
+		for !p.peek().is(")") && p.peek().kind != tokEOF {
+			p.pos++
+		}
+		if err := p.expect(")"); err != nil {
+			return nil, err
+		}
+	}
+	return &castExpr{x: x, typ: typ}, p.expect(")")
+}
@@ -1,74 +1,385 @@
-// csvstat - compute summary statistics for each numeric column in a CSV
+// csvstat - Profile the columns of a CSV, TSV or JSON Lines file.
+//
+// Usage:
+//
+//	csvstat [OPTIONS] [FILE]
+//	cat data.csv | csvstat
+//
+// Each column's type (int, float, bool, date or string) is inferred from
+// its values. For every column csvstat reports empty (null) cells, unique
+// values, string length range and the most frequent values; numeric
+// columns add min, max, mean, sample standard deviation and quantiles, and
+// date columns their range.
+//
+// The input is read once, in bounded memory per column: past 10000 values
+// quantiles are estimated with the P² algorithm and unique counts with
+// HyperLogLog (about 1% error), and frequent values are found with
+// Space-Saving counters (counts marked ~ are upper bounds). With -exact
+// every value is kept in memory and every figure is exact.
+//
+// Options:
+//
+//	-d SEP    Delimiter (default: ,)
+//	-t        Tab-separated input (shortcut for -d $'\t')
+//	-i FMT    Input format: csv|tsv|jsonl (default: from extension, else sniffed)
+//	-H        No header row; columns are named 1, 2, ...
+//	-exact    Exact quantiles, unique counts and frequencies (holds data in memory)
+//	-p LIST   Quantiles to report, as percentages (default: 50,90,99)
+//	-n N      Most frequent values to list per column (default: 5; 0 for none)
+//	-o FMT    Output format: table|json|markdown (default: table)
+//	-j        JSON output (shortcut for -o json)
+//	--style   Border style: ascii|unicode|minimal (default: unicode)
+//
+// Examples:
+//
+//	csvstat sales.csv
+//	csvstat -exact -p 25,50,75 -o markdown sales.csv
+//	zcat events.jsonl.gz | csvstat -i jsonl -j
 package main
 
 import (
-	"encoding/csv"
+	"encoding/json"
+	"flag"
 	"fmt"
+	"io"
 	"math"
 	"os"
-	"sort"
 	"strconv"
 	"strings"
+
+	"goutils/internal/tabular"
+)
+
+var (
+	delim    = flag.String("d", ",", "delimiter")
+	tabMode  = flag.Bool("t", false, "TSV mode")
+	inFormat = flag.String("i", "", "input format")
+	noHeader = flag.Bool("H", false, "no header")
+	exact    = flag.Bool("exact", false, "exact statistics (in memory)")
+	pcts     = flag.String("p", "50,90,99", "quantiles as percentages")
+	topN     = flag.Int("n", 5, "most frequent values per column")
+	outFmt   = flag.String("o", "table", "output format")
+	asJSON   = flag.Bool("j", false, "JSON output")
+	style    = flag.String("style", "unicode", "border style")
 )
 
-type colStats struct {
-	name    string
-	vals    []float64
-	nonNum  int
-	empty   int
+type options struct {
+	exact     bool
+	quantiles []float64
+	labels    []string // p50, p90, ...
+	top       int
+}
+
+// topCap is the number of Space-Saving counters per column; exact mode
+// counts every value.
+func (o options) topCap() int {
+	if o.exact {
+		return 0
+	}
+	if n := 100 * o.top; n > 1000 {
+		return n
+	}
+	return 1000
 }
 
-func (c *colStats) compute() {
-	if len(c.vals) == 0 { return }
-	sort.Float64s(c.vals)
-	n := float64(len(c.vals))
-	sum := 0.0
-	for _, v := range c.vals { sum += v }
-	mean := sum / n
-	variance := 0.0
-	for _, v := range c.vals { d := v - mean; variance += d * d }
-	stddev := math.Sqrt(variance / n)
-	median := c.vals[len(c.vals)/2]
-	if len(c.vals)%2 == 0 { median = (c.vals[len(c.vals)/2-1] + c.vals[len(c.vals)/2]) / 2 }
-
-	fmt.Printf("%-20s  n=%-6d  min=%-12g  max=%-12g  mean=%-12g  median=%-12g  stddev=%g\n",
-		c.name, len(c.vals), c.vals[0], c.vals[len(c.vals)-1], mean, median, stddev)
+func fatal(format string, args ...interface{}) {
+	fmt.Fprintf(os.Stderr, "csvstat: "+format+"\n", args...)
+	os.Exit(1)
 }
 
 func main() {
-	var file string
-	if len(os.Args) > 1 { file = os.Args[1] }
-
-	var r *os.File
-	if file != "" {
-		var err error; r, err = os.Open(file)
-		if err != nil { fmt.Fprintln(os.Stderr, err); os.Exit(1) }
-		defer r.Close()
-	} else { r = os.Stdin }
-
-	cr := csv.NewReader(r); cr.LazyQuotes = true; cr.TrimLeadingSpace = true
-	recs, err := cr.ReadAll()
-	if err != nil { fmt.Fprintln(os.Stderr, err); os.Exit(1) }
-	if len(recs) < 2 { fmt.Println("Not enough data"); return }
-
-	headers := recs[0]
-	cols := make([]*colStats, len(headers))
-	for i, h := range headers { cols[i] = &colStats{name: h} }
-
-	for _, row := range recs[1:] {
+	flag.Parse()
+	if flag.NArg() > 1 {
+		fatal("one file at a time, please")
+	}
+	if *asJSON {
+		*outFmt = "json"
+	}
+	switch *outFmt {
+	case "table", "json", "markdown", "md":
+	default:
+		fatal("unknown output format %q (want table, json or markdown)", *outFmt)
+	}
+
+	o := options{exact: *exact, top: *topN}
+	for _, f := range strings.Split(*pcts, ",") {
+		f = strings.TrimSpace(f)
+		if f == "" {
+			continue
+		}
+		v, err := strconv.ParseFloat(f, 64)
+		if err != nil || v < 0 || v > 100 {
+			fatal("bad quantile %q (want a percentage between 0 and 100)", f)
+		}
+		o.quantiles = append(o.quantiles, v/100)
+		o.labels = append(o.labels, "p"+f)
+	}
+
+	format, err := tabular.ParseFormat(*inFormat)
+	if err != nil {
+		fatal("%v", err)
+	}
+	opt := tabular.Options{Format: format, NoHeader: *noHeader}
+	if *tabMode {
+		opt.Comma = '\t'
+	} else if r := []rune(*delim); len(r) > 0 && *delim != "," {
+		opt.Comma = r[0]
+	}
+
+	name := "<stdin>"
+	var in io.Reader = os.Stdin
+	if flag.NArg() == 1 && flag.Arg(0) != "-" {
+		name = flag.Arg(0)
+		f, err := os.Open(name)
+		if err != nil {
+			fatal("%v", err)
+		}
+		defer f.Close()
+		in = f
+		if opt.Format == tabular.FormatAuto {
+			opt.Format = tabular.FormatForName(name)
+		}
+	}
+
+	cols, rows, err := scan(tabular.NewReader(in, opt), o)
+	if err != nil {
+		fatal("%s: %v", name, err)
+	}
+
+	switch *outFmt {
+	case "json":
+		err = writeJSON(os.Stdout, name, rows, cols, o)
+	case "markdown", "md":
+		err = writeMarkdown(os.Stdout, name, rows, cols, o)
+	default:
+		err = writeTable(os.Stdout, name, rows, cols, o)
+	}
+	if err != nil {
+		fatal("%v", err)
+	}
+}
+
+// scan reads every row, feeding each cell to its column's profile.
+func scan(r *tabular.Reader, o options) ([]*profile, int64, error) {
+	var cols []*profile
+	var rows int64
+	if _, err := r.Header(); err != nil {
+		return nil, 0, err
+	}
+	for {
+		row, err := r.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, 0, err
+		}
+		// JSON Lines headers grow as new keys appear.
+		if header, _ := r.Header(); len(header) > len(cols) {
+			for _, h := range header[len(cols):] {
+				cols = append(cols, newProfile(h, o))
+			}
+		}
+		rows++
 		for i, cell := range row {
-			if i >= len(cols) { continue }
-			cell = strings.TrimSpace(cell)
-			if cell == "" { cols[i].empty++; continue }
-			v, err := strconv.ParseFloat(cell, 64)
-			if err != nil { cols[i].nonNum++; continue }
-			cols[i].vals = append(cols[i].vals, v)
+			if i < len(cols) {
+				cols[i].add(cell)
+			}
+		}
+	}
+	if header, _ := r.Header(); len(header) > len(cols) {
+		for _, h := range header[len(cols):] {
+			cols = append(cols, newProfile(h, o))
+		}
+	}
+	return cols, rows, nil
+}
+
+// fmtNum prints integers without a fraction and other values rounded to
+// four decimals, switching to exponent form for very large or small ones.
+func fmtNum(x float64) string {
+	switch a := math.Abs(x); {
+	case math.IsNaN(x) || math.IsInf(x, 0):
+		return ""
+	case a >= 1e15 || (a < 1e-4 && a != 0):
+		return strconv.FormatFloat(x, 'g', 6, 64)
+	case x == math.Trunc(x):
+		return strconv.FormatInt(int64(x), 10)
+	}
+	return strconv.FormatFloat(math.Round(x*1e4)/1e4, 'f', -1, 64)
+}
+
+func typeName(p *profile) string {
+	if p.kind == tabular.KindNull {
+		return "empty"
+	}
+	return p.kind.String()
+}
+
+// summary renders one line per column for the table and Markdown formats.
+func summary(rows int64, cols []*profile, o options) ([]string, [][]string, []bool) {
+	header := []string{"#", "column", "type", "nulls", "unique", "min", "max", "mean", "stddev"}
+	header = append(header, o.labels...)
+	header = append(header, "length")
+	right := make([]bool, len(header))
+	for i := range right {
+		right[i] = i != 1 && i != 2 && i != len(header)-1
+	}
+
+	var lines [][]string
+	for i, p := range cols {
+		line := []string{
+			strconv.Itoa(i + 1), p.name, typeName(p),
+			strconv.FormatInt(rows-p.count, 10),
+			strconv.FormatInt(p.uniqueCount(), 10),
+		}
+		stats := make([]string, 4+len(o.quantiles))
+		switch {
+		case p.numeric():
+			stats[0], stats[1] = fmtNum(p.min), fmtNum(p.max)
+			stats[2], stats[3] = fmtNum(p.mean), fmtNum(p.stddev())
+			for j, q := range p.quantiles() {
+				stats[4+j] = fmtNum(q)
+			}
+		case p.kind == tabular.KindDate:
+			stats[0], stats[1] = tabular.FormatValue(p.minDate), tabular.FormatValue(p.maxDate)
+		}
+		line = append(line, stats...)
+		length := ""
+		if p.count > 0 {
+			length = strconv.Itoa(p.minLen)
+			if p.maxLen != p.minLen {
+				length += "-" + strconv.Itoa(p.maxLen)
+			}
+		}
+		lines = append(lines, append(line, length))
+	}
+	return header, lines, right
+}
+
+func topValues(p *profile, o options, quote string) string {
+	var parts []string
+	for _, f := range p.freq.top(o.top) {
+		approx := ""
+		if f.Error > 0 {
+			approx = "~"
+		}
+		parts = append(parts, fmt.Sprintf("%s%s%s (%s%d)", quote, tabular.Truncate(f.Value, 30), quote, approx, f.Count))
+	}
+	return strings.Join(parts, ", ")
+}
+
+func mode(o options) string {
+	if o.exact {
+		return "exact"
+	}
+	return "estimated"
+}
+
+func writeTable(w io.Writer, name string, rows int64, cols []*profile, o options) error {
+	fmt.Fprintf(w, "File: %s  Rows: %d  Columns: %d  Statistics: %s\n\n", name, rows, len(cols), mode(o))
+	header, lines, right := summary(rows, cols, o)
+	if err := tabular.WriteTable(w, header, lines, tabular.TableOptions{
+		Style: *style, MaxWidth: 30, RightAlign: right, NoCount: true,
+	}); err != nil {
+		return err
+	}
+	if o.top <= 0 {
+		return nil
+	}
+	fmt.Fprintln(w, "\nMost frequent values:")
+	width := 0
+	for _, p := range cols {
+		if n := len([]rune(tabular.Truncate(p.name, 30))); n > width {
+			width = n
 		}
 	}
+	for _, p := range cols {
+		n := tabular.Truncate(p.name, 30)
+		fmt.Fprintf(w, "  %s%s  %s\n", n, strings.Repeat(" ", width-len([]rune(n))), topValues(p, o, ""))
+	}
+	return nil
+}
+
+func writeMarkdown(w io.Writer, name string, rows int64, cols []*profile, o options) error {
+	fmt.Fprintf(w, "**File:** %s  \n**Rows:** %d  \n**Columns:** %d  \n**Statistics:** %s\n\n", name, rows, len(cols), mode(o))
+	header, lines, right := summary(rows, cols, o)
+	if err := tabular.WriteMarkdown(w, header, lines, right); err != nil {
+		return err
+	}
+	if o.top <= 0 {
+		return nil
+	}
+	fmt.Fprint(w, "\n### Most frequent values\n\n")
+	for _, p := range cols {
+		fmt.Fprintf(w, "- **%s**: %s\n", p.name, topValues(p, o, "`"))
+	}
+	return nil
+}
+
+type jsonColumn struct {
+	Name      string             `json:"name"`
+	Type      string             `json:"type"`
+	Count     int64              `json:"count"`
+	Nulls     int64              `json:"nulls"`
+	Unique    int64              `json:"unique"`
+	Min       interface{}        `json:"min,omitempty"`
+	Max       interface{}        `json:"max,omitempty"`
+	Sum       *float64           `json:"sum,omitempty"`
+	Mean      *float64           `json:"mean,omitempty"`
+	Stddev    *float64           `json:"stddev,omitempty"`
+	Quantiles map[string]float64 `json:"quantiles,omitempty"`
+	MinLength *int               `json:"min_length,omitempty"`
+	MaxLength *int               `json:"max_length,omitempty"`
+	Top       []freq             `json:"top,omitempty"`
+}
+
+func optFloat(x float64) *float64 {
+	if math.IsNaN(x) || math.IsInf(x, 0) {
+		return nil
+	}
+	return &x
+}
 
-	fmt.Printf("File: %s  Rows: %d\n\n", func() string { if file == "" { return "<stdin>" }; return file }(), len(recs)-1)
-	fmt.Printf("%-20s  %-8s  %-14s %-14s %-14s %-14s %s\n",
-		"Column", "Count", "Min", "Max", "Mean", "Median", "StdDev")
-	fmt.Println(strings.Repeat("─", 100))
-	for _, c := range cols { c.compute() }
+func writeJSON(w io.Writer, name string, rows int64, cols []*profile, o options) error {
+	out := struct {
+		File    string       `json:"file"`
+		Rows    int64        `json:"rows"`
+		Exact   bool         `json:"exact"`
+		Columns []jsonColumn `json:"columns"`
+	}{File: name, Rows: rows, Exact: o.exact, Columns: []jsonColumn{}}
+
+	for _, p := range cols {
+		c := jsonColumn{
+			Name:   p.name,
+			Type:   typeName(p),
+			Count:  p.count,
+			Nulls:  rows - p.count,
+			Unique: p.uniqueCount(),
+		}
+		switch {
+		case p.numeric():
+			c.Min, c.Max = p.min, p.max
+			c.Sum, c.Mean, c.Stddev = optFloat(p.sum), optFloat(p.mean), optFloat(p.stddev())
+			c.Quantiles = map[string]float64{}
+			for j, q := range p.quantiles() {
+				c.Quantiles[o.labels[j]] = q
+			}
+		case p.kind == tabular.KindDate:
+			c.Min, c.Max = tabular.FormatValue(p.minDate), tabular.FormatValue(p.maxDate)
+		}
+		if p.count > 0 {
+			c.MinLength, c.MaxLength = &p.minLen, &p.maxLen
+		}
+		if o.top > 0 {
+			c.Top = p.freq.top(o.top)
+		}
+		out.Columns = append(out.Columns, c)
+	}
+	b, err := json.MarshalIndent(out, "", "  ")
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(w, "%s\n", b)
+	return err
 }
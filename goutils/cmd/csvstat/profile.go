@@ -0,0 +1,171 @@
+package main
+
+import (
+	"math"
+	"sort"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"goutils/internal/tabular"
+)
+
+// profile accumulates the statistics of one column in a single pass.
+// Numeric and distinct values are kept in memory up to spillLimit (without
+// limit in exact mode); past that, quantiles and unique counts switch to
+// estimates in bounded space.
+type profile struct {
+	name  string
+	kind  tabular.Kind
+	count int64 // non-empty cells
+
+	// Numbers: Welford's running mean and sum of squared deviations.
+	nums     int64
+	mean, m2 float64
+	sum      float64
+	min, max float64
+	values   []float64 // until spilled to quant
+	quant    []*p2
+
+	// Dates.
+	dates            int64
+	minDate, maxDate time.Time
+
+	minLen, maxLen int
+
+	distinct map[string]bool // until spilled to unique
+	unique   *hll
+	freq     *topK
+	exact    bool
+	probs    []float64
+}
+
+// spillLimit bounds the values held per column outside exact mode, so
+// small inputs still get exact figures.
+const spillLimit = 10000
+
+func newProfile(name string, o options) *profile {
+	return &profile{
+		name:     name,
+		minLen:   -1,
+		distinct: map[string]bool{},
+		freq:     newTopK(o.topCap()),
+		exact:    o.exact,
+		probs:    o.quantiles,
+	}
+}
+
+func (p *profile) add(cell string) {
+	s := strings.TrimSpace(cell)
+	if s == "" {
+		return
+	}
+	p.count++
+	k := tabular.InferKind(s)
+	p.kind = tabular.Merge(p.kind, k)
+
+	if n := utf8.RuneCountInString(s); p.minLen < 0 || n < p.minLen {
+		p.minLen = n
+	}
+	if n := utf8.RuneCountInString(s); n > p.maxLen {
+		p.maxLen = n
+	}
+	if p.unique != nil {
+		p.unique.add(s)
+	} else {
+		p.distinct[s] = true
+		if !p.exact && len(p.distinct) > spillLimit {
+			p.unique = &hll{}
+			for v := range p.distinct {
+				p.unique.add(v)
+			}
+			p.distinct = nil
+		}
+	}
+	p.freq.add(s)
+
+	switch v := tabular.Convert(s, k).(type) {
+	case int64:
+		p.addNumber(float64(v))
+	case float64:
+		p.addNumber(v)
+	case time.Time:
+		if p.dates == 0 || v.Before(p.minDate) {
+			p.minDate = v
+		}
+		if p.dates == 0 || v.After(p.maxDate) {
+			p.maxDate = v
+		}
+		p.dates++
+	}
+}
+
+func (p *profile) addNumber(x float64) {
+	p.nums++
+	if p.nums == 1 || x < p.min {
+		p.min = x
+	}
+	if p.nums == 1 || x > p.max {
+		p.max = x
+	}
+	p.sum += x
+	d := x - p.mean
+	p.mean += d / float64(p.nums)
+	p.m2 += d * (x - p.mean)
+	if p.quant != nil {
+		for _, q := range p.quant {
+			q.add(x)
+		}
+		return
+	}
+	p.values = append(p.values, x)
+	if !p.exact && len(p.values) > spillLimit {
+		for _, prob := range p.probs {
+			q := newP2(prob)
+			for _, v := range p.values {
+				q.add(v)
+			}
+			p.quant = append(p.quant, q)
+		}
+		p.values = nil
+	}
+}
+
+func (p *profile) numeric() bool {
+	return p.kind == tabular.KindInt || p.kind == tabular.KindFloat
+}
+
+// stddev is the sample standard deviation.
+func (p *profile) stddev() float64 {
+	if p.nums < 2 {
+		return math.NaN()
+	}
+	return math.Sqrt(p.m2 / float64(p.nums-1))
+}
+
+func (p *profile) quantiles() []float64 {
+	out := make([]float64, len(p.probs))
+	if p.quant != nil {
+		for i, q := range p.quant {
+			out[i] = q.value()
+		}
+		return out
+	}
+	sort.Float64s(p.values)
+	for i, prob := range p.probs {
+		out[i] = quantile(p.values, prob)
+	}
+	return out
+}
+
+func (p *profile) uniqueCount() int64 {
+	if p.unique == nil {
+		return int64(len(p.distinct))
+	}
+	n := int64(p.unique.count())
+	// The estimate can overshoot; there cannot be more values than cells.
+	if n > p.count {
+		n = p.count
+	}
+	return n
+}
@@ -1,115 +1,160 @@
 // date - Display or format the current date and time
-// Usage: date [+format] [-d datestring] [-u]
+// Usage: date [-u] [-d datestring | -f file | -r file] [-I[precision] | -R | -rfc-3339 precision] [+format]
 package main
 
 import (
+	"bufio"
 	"flag"
 	"fmt"
 	"os"
 	"strings"
 	"time"
+
+	"goutils/lib/datetime"
 )
 
 var (
 	utc        = flag.Bool("u", false, "Print UTC time")
-	dateString = flag.String("d", "", "Parse and display a date string")
+	dateString = flag.String("d", "", "Parse and display a date string, as GNU date -d does")
+	dateFile   = flag.String("f", "", "Display each date in a file, - for stdin")
+	reference  = flag.String("r", "", "Display the modification time of a file")
+	iso        = flag.String("I", "", "ISO 8601 output: date, hours, minutes, seconds or ns")
+	rfcEmail   = flag.Bool("R", false, "RFC 5322 output, as in email headers")
+	rfc3339    = flag.String("rfc-3339", "", "RFC 3339 output: date, seconds or ns")
 )
 
+var (
+	isoFormats = map[string]string{
+		"date":    "%Y-%m-%d",
+		"hours":   "%Y-%m-%dT%H%:z",
+		"minutes": "%Y-%m-%dT%H:%M%:z",
+		"seconds": "%Y-%m-%dT%H:%M:%S%:z",
+		"ns":      "%Y-%m-%dT%H:%M:%S,%N%:z",
+	}
+	rfc3339Formats = map[string]string{
+		"date":    "%Y-%m-%d",
+		"seconds": "%Y-%m-%d %H:%M:%S%:z",
+		"ns":      "%Y-%m-%d %H:%M:%S.%N%:z",
+	}
+)
+
+func fail(format string, a ...interface{}) {
+	fmt.Fprintf(os.Stderr, "date: "+format+"\n", a...)
+	os.Exit(1)
+}
+
 func main() {
 	flag.Usage = func() {
-		fmt.Fprintln(os.Stderr, "Usage: date [+format] [-d datestring] [-u]")
-		fmt.Fprintln(os.Stderr, "Format specifiers: %Y %m %d %H %M %S %A %B %Z %s %R %T %D %F %e %j %u %w %W %p %I")
+		fmt.Fprintln(os.Stderr, "Usage: date [-u] [-d datestring | -f file | -r file] [-I[precision] | -R | -rfc-3339 precision] [+format]")
+		os.Stderr.WriteString("Format specifiers are those of GNU date, such as %Y %m %d %H %M %S %N %s %z %:z %Z %G %V\n")
 		flag.PrintDefaults()
 	}
+	// -I takes its precision only when attached, which flag cannot
+	// express.
+	for i, a := range os.Args[1:] {
+		if a == "--" {
+			break
+		}
+		if strings.HasPrefix(a, "-I") && !strings.HasPrefix(a, "-I=") {
+			precision := a[2:]
+			if precision == "" {
+				precision = "date"
+			}
+			os.Args[i+1] = "-I=" + precision
+		}
+	}
 	flag.Parse()
 
-	var t time.Time
-	if *dateString != "" {
-		var err error
-		layouts := []string{
-			"2006-01-02 15:04:05",
-			"2006-01-02",
-			"01/02/2006",
-			"Jan 2 2006",
-			"January 2 2006",
-			"Mon Jan 2 15:04:05 2006",
-			time.RFC3339,
-			time.RFC1123,
-			time.RFC822,
+	var formats []string
+	if *iso != "" {
+		f, ok := isoFormats[*iso]
+		if !ok {
+			fail("invalid argument '%s' for '-I'", *iso)
 		}
-		for _, layout := range layouts {
-			t, err = time.Parse(layout, *dateString)
-			if err == nil {
-				break
-			}
+		formats = append(formats, f)
+	}
+	if *rfc3339 != "" {
+		f, ok := rfc3339Formats[*rfc3339]
+		if !ok {
+			fail("invalid argument '%s' for '--rfc-3339'", *rfc3339)
 		}
-		if err != nil {
-			fmt.Fprintln(os.Stderr, "date: cannot parse date:", *dateString)
-			os.Exit(1)
+		formats = append(formats, f)
+	}
+	if *rfcEmail {
+		formats = append(formats, "%a, %d %b %Y %H:%M:%S %z")
+	}
+	if flag.NArg() > 1 {
+		fail("extra operand '%s'", flag.Arg(1))
+	}
+	if flag.NArg() == 1 {
+		if !strings.HasPrefix(flag.Arg(0), "+") {
+			fail("invalid date '%s'", flag.Arg(0))
 		}
-	} else {
-		t = time.Now()
+		formats = append(formats, flag.Arg(0)[1:])
 	}
-
-	if *utc {
-		t = t.UTC()
+	if len(formats) > 1 {
+		fail("multiple output formats specified")
 	}
-
-	format := "+%a %b %e %H:%M:%S %Z %Y"
-	if flag.NArg() > 0 {
-		format = flag.Arg(0)
+	format := "%a %b %e %H:%M:%S %Z %Y"
+	if len(formats) == 1 {
+		format = formats[0]
 	}
 
-	if strings.HasPrefix(format, "+") {
-		format = format[1:]
+	// An empty -d is a valid date, so look at which flags were given.
+	given := map[string]bool{}
+	flag.Visit(func(f *flag.Flag) { given[f.Name] = true })
+	if btoi(given["d"])+btoi(given["f"])+btoi(given["r"]) > 1 {
+		fail("the options to specify dates for printing are mutually exclusive")
 	}
 
-	fmt.Println(formatDate(t, format))
-}
-
-func formatDate(t time.Time, format string) string {
-	weekdays := []string{"Sunday", "Monday", "Tuesday", "Wednesday", "Thursday", "Friday", "Saturday"}
-	months := []string{"January", "February", "March", "April", "May", "June",
-		"July", "August", "September", "October", "November", "December"}
+	loc := datetime.Local()
+	if *utc {
+		loc = time.UTC
+	}
+	now := time.Now().In(loc)
 
-	replacements := map[string]string{
-		"%Y": fmt.Sprintf("%04d", t.Year()),
-		"%y": fmt.Sprintf("%02d", t.Year()%100),
-		"%m": fmt.Sprintf("%02d", t.Month()),
-		"%d": fmt.Sprintf("%02d", t.Day()),
-		"%e": fmt.Sprintf("%2d", t.Day()),
-		"%H": fmt.Sprintf("%02d", t.Hour()),
-		"%I": fmt.Sprintf("%02d", (t.Hour()%12 + 12) % 12),
-		"%M": fmt.Sprintf("%02d", t.Minute()),
-		"%S": fmt.Sprintf("%02d", t.Second()),
-		"%A": weekdays[t.Weekday()],
-		"%a": weekdays[t.Weekday()][:3],
-		"%B": months[t.Month()-1],
-		"%b": months[t.Month()-1][:3],
-		"%Z": t.Format("MST"),
-		"%z": t.Format("-0700"),
-		"%s": fmt.Sprintf("%d", t.Unix()),
-		"%n": "\n",
-		"%t": "\t",
-		"%%": "%",
-		"%R": fmt.Sprintf("%02d:%02d", t.Hour(), t.Minute()),
-		"%T": fmt.Sprintf("%02d:%02d:%02d", t.Hour(), t.Minute(), t.Second()),
-		"%D": fmt.Sprintf("%02d/%02d/%02d", t.Month(), t.Day(), t.Year()%100),
-		"%F": fmt.Sprintf("%04d-%02d-%02d", t.Year(), t.Month(), t.Day()),
-		"%j": fmt.Sprintf("%03d", t.YearDay()),
-		"%u": fmt.Sprintf("%d", int(t.Weekday())+1),
-		"%w": fmt.Sprintf("%d", int(t.Weekday())),
-		"%p": func() string {
-			if t.Hour() < 12 {
-				return "AM"
+	switch {
+	case given["f"]:
+		f := os.Stdin
+		if *dateFile != "-" {
+			var err error
+			if f, err = os.Open(*dateFile); err != nil {
+				fail("%v", err)
 			}
-			return "PM"
-		}(),
+			defer f.Close()
+		}
+		status := 0
+		sc := bufio.NewScanner(f)
+		for sc.Scan() {
+			t, err := datetime.Parse(sc.Text(), now)
+			if err != nil {
+				fmt.Fprintln(os.Stderr, "date:", err)
+				status = 1
+				continue
+			}
+			fmt.Println(datetime.Strftime(format, t.In(loc)))
+		}
+		os.Exit(status)
+	case given["r"]:
+		info, err := os.Stat(*reference)
+		if err != nil {
+			fail("%v", err)
+		}
+		fmt.Println(datetime.Strftime(format, info.ModTime().In(loc)))
+	case given["d"]:
+		t, err := datetime.Parse(*dateString, now)
+		if err != nil {
+			fail("%v", err)
+		}
+		fmt.Println(datetime.Strftime(format, t.In(loc)))
+	default:
+		fmt.Println(datetime.Strftime(format, now))
 	}
+}
 
-	result := format
-	for k, v := range replacements {
-		result = strings.ReplaceAll(result, k, v)
+func btoi(b bool) int {
+	if b {
+		return 1
 	}
-	return result
+	return 0
 }
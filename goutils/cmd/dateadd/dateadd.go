@@ -7,10 +7,12 @@ import (
 	"strconv"
 	"strings"
 	"time"
+
+	"goutils/lib/datetime"
 )
 
 const helpMsg = `usage: dateadd <date> <+/-amount> <unit> [format]
-  date   : YYYY-MM-DD or "now"
+  date   : any date date -d accepts, such as YYYY-MM-DD, "now" or "next friday"
   unit   : seconds|minutes|hours|days|weeks|months|years
   format : output format (default: 2006-01-02)
 examples:
@@ -27,17 +29,8 @@ func main() {
 	outFmt := "2006-01-02"
 	if len(os.Args) > 4 { outFmt = os.Args[4] }
 
-	var base time.Time
-	var err error
-	if dateStr == "now" || dateStr == "today" {
-		base = time.Now()
-	} else {
-		for _, f := range []string{"2006-01-02", "01/02/2006", "2006-01-02 15:04:05", time.RFC3339} {
-			base, err = time.Parse(f, dateStr)
-			if err == nil { break }
-		}
-		if err != nil { fmt.Fprintf(os.Stderr, "dateadd: cannot parse date %q\n", dateStr); os.Exit(1) }
-	}
+	base, err := datetime.Parse(dateStr, time.Now())
+	if err != nil { fmt.Fprintf(os.Stderr, "dateadd: cannot parse date %q\n", dateStr); os.Exit(1) }
 
 	amt, err := strconv.Atoi(amtStr)
 	if err != nil { fmt.Fprintf(os.Stderr, "dateadd: invalid amount %q\n", amtStr); os.Exit(1) }
@@ -4,28 +4,20 @@ package main
 import (
 	"fmt"
 	"os"
-	"strings"
 	"time"
-)
 
-var formats = []string{
-	"2006-01-02", "01/02/2006", "02-01-2006", "January 2, 2006",
-	"Jan 2, 2006", "2 January 2006", "2006-01-02T15:04:05", time.RFC3339,
-}
+	"goutils/lib/datetime"
+)
 
+// parseDate reads dates as date -d does, in UTC so that days are whole.
 func parseDate(s string) (time.Time, error) {
-	s = strings.TrimSpace(s)
-	if s == "today" || s == "now" { return time.Now(), nil }
-	for _, f := range formats {
-		if t, err := time.Parse(f, s); err == nil { return t, nil }
-	}
-	return time.Time{}, fmt.Errorf("cannot parse date %q", s)
+	return datetime.Parse(s, time.Now().UTC())
 }
 
 func main() {
 	if len(os.Args) < 3 {
 		fmt.Fprintln(os.Stderr, "usage: daysbetween <date1> <date2>")
-		fmt.Fprintln(os.Stderr, "  dates: YYYY-MM-DD, today, now, MM/DD/YYYY, etc.")
+		fmt.Fprintln(os.Stderr, "  dates: anything date -d accepts: YYYY-MM-DD, today, MM/DD/YYYY, \"2 weeks ago\", etc.")
 		os.Exit(1)
 	}
 	a, err := parseDate(os.Args[1]); if err != nil { fmt.Fprintln(os.Stderr, err); os.Exit(1) }
@@ -11,7 +11,7 @@
 //	-z ZONE    Timezone (default: UTC, e.g. America/New_York)
 //	-ms        Treat input as milliseconds
 //	-us        Treat input as microseconds
-//	-r         Reverse: parse date string to epoch
+//	-r         Reverse: parse date string to epoch, as date -d reads it
 //	-n         Print current epoch timestamp
 //
 // Examples:
@@ -31,6 +31,8 @@ import (
 	"strconv"
 	"strings"
 	"time"
+
+	"goutils/lib/datetime"
 )
 
 var (
@@ -43,7 +45,7 @@ var (
 )
 
 func loadZone() *time.Location {
-	loc, err := time.LoadLocation(*zone)
+	loc, err := datetime.LoadLocation(*zone)
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "epochconv: unknown timezone %q\n", *zone)
 		os.Exit(1)
@@ -65,29 +67,8 @@ func epochToTime(s string, loc *time.Location) (time.Time, error) {
 	return time.Unix(n, 0).In(loc), nil
 }
 
-var parseFormats = []string{
-	"2006-01-02 15:04:05",
-	"2006-01-02T15:04:05",
-	"2006-01-02T15:04:05Z",
-	"2006-01-02T15:04:05-07:00",
-	"2006-01-02",
-	"01/02/2006",
-	"Jan 2, 2006",
-	"January 2, 2006",
-	time.RFC1123,
-	time.RFC1123Z,
-	time.RFC3339,
-}
-
 func parseDate(s string, loc *time.Location) (time.Time, error) {
-	s = strings.TrimSpace(s)
-	for _, f := range parseFormats {
-		t, err := time.ParseInLocation(f, s, loc)
-		if err == nil {
-			return t, nil
-		}
-	}
-	return time.Time{}, fmt.Errorf("could not parse %q", s)
+	return datetime.Parse(s, time.Now().In(loc))
 }
 
 func main() {
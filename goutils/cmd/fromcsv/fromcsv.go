@@ -1,13 +1,14 @@
-// fromcsv - convert CSV to various formats: JSON, YAML, TSV, Markdown table, SQL INSERT
+// fromcsv - convert CSV (or TSV, JSON Lines) to various formats: JSON, TSV, Markdown table, SQL INSERT
 package main
 
 import (
-	"encoding/csv"
 	"encoding/json"
 	"fmt"
 	"io"
 	"os"
 	"strings"
+
+	"goutils/internal/tabular"
 )
 
 func usage() {
@@ -15,7 +16,7 @@ func usage() {
   -f <format>   output format: json|jsonl|tsv|md|sql|kv (default: json)
   -t <table>    table name for SQL output (default: data)
   -H            first row is NOT a header (auto-generate col1,col2...)
-  -d <delim>    input delimiter (default: ,)`)
+  -d <delim>    input delimiter (default: ,; .tsv and .jsonl files are detected)`)
 	os.Exit(1)
 }
 
@@ -45,19 +46,13 @@ func main() {
 		defer f.Close(); r = f
 	}
 
-	cr := csv.NewReader(r)
-	cr.Comma = delim; cr.LazyQuotes = true; cr.TrimLeadingSpace = true
-	records, err := cr.ReadAll()
+	opt := tabular.Options{Format: tabular.FormatForName(file), NoHeader: noHeader}
+	if delim != ',' { opt.Comma = delim }
+	headers, dataRows, err := tabular.NewReader(r, opt).ReadAll()
 	if err != nil { fmt.Fprintln(os.Stderr, err); os.Exit(1) }
-	if len(records) == 0 { return }
-
-	var headers []string
-	var dataRows [][]string
+	if len(headers) == 0 { return }
 	if noHeader {
-		for i := range records[0] { headers = append(headers, fmt.Sprintf("col%d", i+1)) }
-		dataRows = records
-	} else {
-		headers = records[0]; dataRows = records[1:]
+		for i := range headers { headers[i] = fmt.Sprintf("col%d", i+1) }
 	}
 
 	switch format {
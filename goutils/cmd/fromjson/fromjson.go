@@ -13,7 +13,9 @@
 //	-k        Print all keys (flattened dot-notation)
 //	-v        Validate only (exit 0=valid, 1=invalid)
 //	-d N      Max depth to display (truncate deep objects)
-//	-C        Colorize output (requires terminal)
+//	-C        Colorize output, even when not writing to a terminal
+//	--color=WHEN  auto (default), always or never; auto colours a
+//	          terminal unless NO_COLOR is set
 //
 // Examples:
 //
@@ -32,6 +34,8 @@ import (
 	"os"
 	"sort"
 	"strings"
+
+	"goutils/lib/term"
 )
 
 var (
@@ -42,45 +46,36 @@ var (
 	validateOnly = flag.Bool("v", false, "validate only")
 	maxDepth   = flag.Int("d", 0, "max depth")
 	colorize   = flag.Bool("C", false, "colorize")
+	colorMode  = term.ColorFlag()
 )
 
+// pal colours the output; it is term.NoColor unless colour is on.
+var pal term.Profile
+
 const (
-	colorReset  = "\033[0m"
-	colorKey    = "\033[34m"    // blue
-	colorString = "\033[32m"    // green
-	colorNum    = "\033[33m"    // yellow
-	colorNull   = "\033[90m"    // gray
-	colorBool   = "\033[35m"    // magenta
+	colorKey    = term.Blue
+	colorString = term.Green
+	colorNum    = term.Yellow
+	colorNull   = term.Gray
+	colorBool   = term.Magenta
 )
 
 func sortedMarshal(v interface{}, indent string, depth int) string {
 	switch val := v.(type) {
 	case nil:
-		if *colorize {
-			return colorNull + "null" + colorReset
-		}
-		return "null"
+		return pal.Paint("null", colorNull)
 	case bool:
 		s := "false"
 		if val {
 			s = "true"
 		}
-		if *colorize {
-			return colorBool + s + colorReset
-		}
-		return s
+		return pal.Paint(s, colorBool)
 	case float64:
 		s := fmt.Sprintf("%g", val)
-		if *colorize {
-			return colorNum + s + colorReset
-		}
-		return s
+		return pal.Paint(s, colorNum)
 	case string:
 		b, _ := json.Marshal(val)
-		if *colorize {
-			return colorString + string(b) + colorReset
-		}
-		return string(b)
+		return pal.Paint(string(b), colorString)
 	case []interface{}:
 		if len(val) == 0 {
 			return "[]"
@@ -112,10 +107,7 @@ func sortedMarshal(v interface{}, indent string, depth int) string {
 		var parts []string
 		for _, k := range keys {
 			kb, _ := json.Marshal(k)
-			keyStr := string(kb)
-			if *colorize {
-				keyStr = colorKey + keyStr + colorReset
-			}
+			keyStr := pal.Paint(string(kb), colorKey)
 			parts = append(parts, child+keyStr+": "+sortedMarshal(val[k], child, depth+1))
 		}
 		return "{\n" + strings.Join(parts, ",\n") + "\n" + indent + "}"
@@ -213,7 +205,7 @@ func process(r *os.File) bool {
 	if *compact {
 		b, _ := json.Marshal(data)
 		fmt.Fprintln(w, string(b))
-	} else if *sortKeys || *colorize || *maxDepth > 0 {
+	} else if *sortKeys || pal != term.NoColor || *maxDepth > 0 {
 		fmt.Fprintln(w, sortedMarshal(data, "", 0))
 	} else {
 		b, _ := json.MarshalIndent(data, "", "  ")
@@ -225,6 +217,10 @@ func process(r *os.File) bool {
 func main() {
 	flag.Parse()
 	files := flag.Args()
+	if *colorize {
+		*colorMode = term.ColorAlways
+	}
+	pal = colorMode.Profile(os.Stdout)
 
 	ok := true
 	if len(files) == 0 {
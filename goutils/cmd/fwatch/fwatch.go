@@ -10,7 +10,7 @@
 //	-i DUR    Polling interval (default: 500ms)
 //	-d        Debounce: wait for changes to settle before running (default: 200ms)
 //	-1        Run once then exit (don't keep watching)
-//	-c        Clear screen before each run
+//	-c        Clear screen before each run (when stdout is a terminal)
 //	-r        Recursive watch (directories)
 //	-p PAT    Pattern filter (glob, e.g. "*.go")
 //	-q        Quiet: don't print change notifications
@@ -32,6 +32,8 @@ import (
 	"path/filepath"
 	"strings"
 	"time"
+
+	"goutils/lib/term"
 )
 
 var (
@@ -45,6 +47,14 @@ var (
 	dryRun    = flag.Bool("n", false, "dry run")
 )
 
+// clearScreen clears the terminal for -c; the escape is not written
+// when stdout is a pipe or file.
+func clearScreen() {
+	if *clear && term.IsTerminal(os.Stdout) {
+		fmt.Print(term.ClearScreen)
+	}
+}
+
 type FileState struct {
 	mtime time.Time
 	size  int64
@@ -131,9 +141,7 @@ func main() {
 	state := getState(watchPaths)
 
 	// Run once immediately
-	if *clear {
-		fmt.Print("\033[2J\033[H")
-	}
+	clearScreen()
 	runCmd(command)
 
 	if *once {
@@ -162,9 +170,7 @@ func main() {
 				fmt.Fprintf(os.Stderr, "\n[fwatch] changed: %s\n", pendingChange)
 			}
 			pendingChange = ""
-			if *clear {
-				fmt.Print("\033[2J\033[H")
-			}
+			clearScreen()
 			runCmd(command)
 		}
 	}
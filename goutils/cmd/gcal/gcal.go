@@ -1,4 +1,9 @@
 // gcal - print a graphical calendar for a month or year
+//
+// Usage: gcal [-y|--year] [--color[=WHEN]] [MONTH] [YEAR]
+//
+// Today is shown in reverse video when the output is coloured: by default
+// on a terminal when NO_COLOR is unset.
 package main
 
 import (
@@ -7,8 +12,13 @@ import (
 	"strconv"
 	"strings"
 	"time"
+
+	"goutils/lib/term"
 )
 
+// pal shows today in reverse video when colour is on.
+var pal term.Profile
+
 var monthNames = []string{"", "January", "February", "March", "April", "May", "June",
 	"July", "August", "September", "October", "November", "December"}
 
@@ -27,7 +37,7 @@ func printMonth(year, month int, highlight int) {
 	col := startDay
 	for d := 1; d <= daysInMonth; d++ {
 		if d == highlight {
-			fmt.Printf("\033[7m%2d\033[0m", d)
+			fmt.Print(pal.Paint(fmt.Sprintf("%2d", d), term.Reverse))
 		} else {
 			fmt.Printf("%2d", d)
 		}
@@ -53,7 +63,7 @@ func printYear(year int) {
 			for col, m := range months {
 				s := ""
 				if line < len(m) { s = m[line] }
-				fmt.Printf("%-22s", s)
+				fmt.Print(term.PadRight(s, 22))
 				if col < 2 { fmt.Print("  ") }
 			}
 			fmt.Println()
@@ -73,7 +83,7 @@ func renderMonth(year, month, highlight int) []string {
 	row := strings.Repeat("   ", startDay)
 	col := startDay
 	for d := 1; d <= daysInMonth; d++ {
-		if d == highlight { row += fmt.Sprintf("\033[7m%2d\033[0m", d) } else { row += fmt.Sprintf("%2d", d) }
+		if d == highlight { row += pal.Paint(fmt.Sprintf("%2d", d), term.Reverse) } else { row += fmt.Sprintf("%2d", d) }
 		col++
 		if col == 7 { lines = append(lines, row); row = ""; col = 0 } else { row += " " }
 	}
@@ -87,7 +97,9 @@ func main() {
 	today := now.Day()
 	yearMode := false
 
-	args := os.Args[1:]
+	mode, args, err := term.ColorArgs(os.Args[1:])
+	if err != nil { fmt.Fprintln(os.Stderr, "gcal:", err); os.Exit(2) }
+	pal = mode.Profile(os.Stdout)
 	for i := 0; i < len(args); i++ {
 		switch args[i] {
 		case "-y", "--year": yearMode = true
@@ -1,14 +1,17 @@
 // gencsv - generate test/mock CSV data with realistic fake values
+//
+// Usage: gencsv [-n ROWS] [-s name:kind,...] [-H] [--seed N] [-d SEP | -t] [--quote-all] [--crlf]
 package main
 
 import (
-	"encoding/csv"
 	"fmt"
 	"math/rand"
 	"os"
 	"strconv"
 	"strings"
 	"time"
+
+	"goutils/internal/tabular"
 )
 
 var (
@@ -79,6 +82,7 @@ func main() {
 	schema := ""
 	noHeader := false
 	seed := time.Now().UnixNano()
+	var dialect tabular.Dialect
 	args := os.Args[1:]
 	for i := 0; i < len(args); i++ {
 		switch args[i] {
@@ -86,6 +90,10 @@ func main() {
 		case "-s", "--schema": i++; schema = args[i]
 		case "-H": noHeader = true
 		case "--seed": i++; seed, _ = strconv.ParseInt(args[i], 10, 64)
+		case "-d": i++; if r := []rune(args[i]); len(r) > 0 { dialect.Comma = r[0] }
+		case "-t": dialect.Comma = '\t'
+		case "--quote-all": dialect.Mode = tabular.QuoteAll
+		case "--crlf": dialect.CRLF = true
 		default: if v, err := strconv.Atoi(args[i]); err == nil { n = v }
 		}
 	}
@@ -93,7 +101,7 @@ func main() {
 	var cols []colDef
 	if schema != "" { cols = parseSchema(schema) } else { cols = defaultSchema }
 	rng := rand.New(rand.NewSource(seed))
-	w := csv.NewWriter(os.Stdout)
+	w := tabular.NewCSVWriter(os.Stdout, dialect)
 	if !noHeader {
 		headers := make([]string, len(cols))
 		for i, c := range cols { headers[i] = c.name }
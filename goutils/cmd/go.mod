@@ -1,3 +1,7 @@
 module goutils
 
 go 1.21
+
+require goutils/lib v0.0.0
+
+replace goutils/lib => ../lib
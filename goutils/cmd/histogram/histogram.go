@@ -8,11 +8,15 @@ import (
 	"os"
 	"strconv"
 	"strings"
+
+	"goutils/lib/term"
 )
 
 func main() {
 	bins := 10
+	// Bars are at most 60 columns, narrower if the terminal is.
 	width := 60
+	if term.IsTerminal(os.Stdout) { width = max(10, min(width, term.Width(os.Stdout)-34)) }
 	args := os.Args[1:]
 	for i := 0; i < len(args); i++ {
 		switch args[i] {
@@ -60,7 +64,7 @@ func main() {
 		barLen := 0
 		if maxCount > 0 { barLen = c * width / maxCount }
 		bar := strings.Repeat("█", barLen)
-		fmt.Printf("[%9.4g, %9.4g) │%-*s %d\n", lo, hi, width, bar, c)
+		fmt.Printf("[%9.4g, %9.4g) │%s %d\n", lo, hi, term.PadRight(bar, width), c)
 	}
 	fmt.Printf("\nStdDev: %.4g\n", stddev(vals, mean))
 }
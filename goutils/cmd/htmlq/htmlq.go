@@ -0,0 +1,177 @@
+// htmlq - Select parts of HTML documents with CSS selectors.
+//
+// Usage:
+//
+//	htmlq [OPTIONS] SELECTOR [FILE...]
+//
+// Parses each FILE (or standard input) as a browser would and prints the
+// elements that match SELECTOR, one per line, as HTML. SELECTOR is a CSS
+// selector list: type, class, id and attribute selectors, the
+// descendant, child and sibling combinators, and pseudo-classes such as
+// :nth-child(2n+1 of .item), :not(), :is(), :has() and :contains().
+//
+// Options:
+//
+//	-t              Print the text of each element, white space collapsed
+//	-a NAME         Print the value of attribute NAME of each element
+//	-i              Print the inner HTML of each element
+//	-n              Print the number of matching elements
+//	-b URL          Resolve the URLs printed by -a against URL
+//	-r SELECTOR     Remove elements matching SELECTOR before selecting
+//	-1              Only use the first match in each document
+//
+// Elements without attribute NAME are skipped by -a. It exits 1 if
+// nothing matched and 2 if SELECTOR is malformed.
+//
+// Examples:
+//
+//	htmlq 'div.item > a[href]' -a href -b https://status.example.com/ page.html
+//	htmlq -t 'table.services tr:nth-child(n+2) td:first-child' status.html
+//	htmlq -r 'script, style, nav' main index.html
+//	curl -s https://example.com | htmlq -n 'a:not([href^="https:"])'
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"strings"
+
+	"goutils/internal/cli"
+	"goutils/internal/htmltree"
+)
+
+var (
+	text   = flag.Bool("t", false, "print the text of each element")
+	attr   = flag.String("a", "", "print the value of attribute `NAME`")
+	inner  = flag.Bool("i", false, "print the inner HTML of each element")
+	count  = flag.Bool("n", false, "print the number of matching elements")
+	base   = flag.String("b", "", "resolve URLs printed by -a against `URL`")
+	remove = flag.String("r", "", "remove elements matching `SELECTOR` first")
+	first  = flag.Bool("1", false, "only use the first match in each document")
+
+	sel, removeSel *htmltree.Selector
+	baseURL        *url.URL
+	out            = bufio.NewWriter(os.Stdout)
+)
+
+func usage() {
+	fmt.Fprintln(os.Stderr, "usage: htmlq [OPTIONS] SELECTOR [FILE...]")
+	flag.PrintDefaults()
+	os.Exit(2)
+}
+
+// process prints the matches in one document and returns their number.
+func process(r io.Reader) (int, error) {
+	doc, err := htmltree.Parse(r)
+	if err != nil {
+		return 0, err
+	}
+	if removeSel != nil {
+		for _, n := range removeSel.Select(doc) {
+			n.Remove()
+		}
+	}
+	nodes := sel.Select(doc)
+	if *first && len(nodes) > 1 {
+		nodes = nodes[:1]
+	}
+	if *count {
+		return len(nodes), nil
+	}
+	for _, n := range nodes {
+		switch {
+		case *text:
+			fmt.Fprintln(out, strings.Join(strings.Fields(n.Text()), " "))
+		case *attr != "":
+			v, ok := n.Get(strings.ToLower(*attr))
+			if !ok {
+				continue
+			}
+			if baseURL != nil {
+				if u, err := baseURL.Parse(strings.TrimSpace(v)); err == nil {
+					v = u.String()
+				}
+			}
+			fmt.Fprintln(out, v)
+		case *inner:
+			htmltree.RenderChildren(out, n)
+			fmt.Fprintln(out)
+		default:
+			htmltree.Render(out, n)
+			fmt.Fprintln(out)
+		}
+	}
+	return len(nodes), nil
+}
+
+func main() {
+	flag.Usage = usage
+	args := cli.Parse(flag.CommandLine, os.Args[1:])
+	if len(args) == 0 {
+		usage()
+	}
+	modes := 0
+	for _, on := range []bool{*text, *attr != "", *inner, *count} {
+		if on {
+			modes++
+		}
+	}
+	if modes > 1 {
+		usage()
+	}
+	var err error
+	if sel, err = htmltree.Compile(args[0]); err != nil {
+		fmt.Fprintf(os.Stderr, "htmlq: %v\n", err)
+		os.Exit(2)
+	}
+	if *remove != "" {
+		if removeSel, err = htmltree.Compile(*remove); err != nil {
+			fmt.Fprintf(os.Stderr, "htmlq: %v\n", err)
+			os.Exit(2)
+		}
+	}
+	if *base != "" {
+		if baseURL, err = url.Parse(*base); err != nil {
+			fmt.Fprintf(os.Stderr, "htmlq: %v\n", err)
+			os.Exit(2)
+		}
+	}
+
+	status, total := 0, 0
+	run := func(r io.Reader, name string) {
+		n, err := process(r)
+		total += n
+		if err != nil {
+			out.Flush()
+			fmt.Fprintf(os.Stderr, "htmlq: %s: %v\n", name, err)
+			status = 1
+		}
+	}
+	files := args[1:]
+	if len(files) == 0 {
+		run(os.Stdin, "<stdin>")
+	}
+	for _, f := range files {
+		fh, err := os.Open(f)
+		if err != nil {
+			out.Flush()
+			fmt.Fprintf(os.Stderr, "htmlq: %v\n", err)
+			status = 1
+			continue
+		}
+		run(fh, f)
+		fh.Close()
+	}
+	if *count {
+		fmt.Fprintln(out, total)
+	}
+	out.Flush()
+	if total == 0 && status == 0 {
+		status = 1
+	}
+	os.Exit(status)
+}
@@ -1,13 +1,53 @@
-// htmlstrip - remove HTML tags from input, optionally decoding entities
+// htmlstrip - Remove HTML markup, or convert HTML to readable text or Markdown.
+//
+// Usage:
+//
+//	htmlstrip [OPTIONS] [FILE...]
+//
+// By default each line of input has its tags, scripts and styles removed
+// and is written on its own, which suits logs and other line-oriented
+// text with some markup in it. -t and -m parse each whole document as a
+// browser would instead.
+//
+// Options:
+//
+//	-d, -decode     Decode character references such as &amp;
+//	-c, -collapse   Collapse runs of spaces and trim each line
+//	-t              Write the document as readable text
+//	-m              Write the document as Markdown
+//	-w N            Wrap paragraphs at N columns with -t or -m (default: no wrapping)
+//	-b URL          Resolve relative links and images against URL
+//
+// Readable text keeps paragraphs apart with blank lines, underlines
+// headings, bullets and numbers list items, prefixes quotations with
+// "> " and lines up tables in columns. Links are followed by a number
+// that refers to a list of their targets at the end.
+//
+// Markdown output is CommonMark with GitHub tables and strikethrough:
+// headings, emphasis, code spans and fenced blocks (with the language
+// from a class such as language-go), links, images, lists, quotations
+// and tables.
+//
+// Examples:
+//
+//	htmlstrip -d -c < fragment.txt
+//	htmlstrip -t -w 72 status.html
+//	curl -s https://example.com/docs/ | htmlstrip -m -b https://example.com/docs/ > docs.md
 package main
 
 import (
 	"bufio"
+	"flag"
 	"fmt"
 	"html"
+	"io"
+	"net/url"
 	"os"
 	"regexp"
 	"strings"
+
+	"goutils/internal/cli"
+	"goutils/internal/htmltree"
 )
 
 var (
@@ -16,10 +56,34 @@ var (
 	spaceRe  = regexp.MustCompile(`[ \t]+`)
 )
 
+var (
+	decode   = flag.Bool("d", false, "decode character references")
+	collapse = flag.Bool("c", false, "collapse runs of spaces and trim each line")
+	textMode = flag.Bool("t", false, "write the document as readable text")
+	markdown = flag.Bool("m", false, "write the document as Markdown")
+	width    = flag.Int("w", 0, "wrap paragraphs at `N` columns")
+	base     = flag.String("b", "", "resolve relative links against `URL`")
+
+	out = bufio.NewWriter(os.Stdout)
+)
+
+func init() {
+	flag.BoolVar(decode, "decode", false, "same as -d")
+	flag.BoolVar(collapse, "collapse", false, "same as -c")
+}
+
+func usage() {
+	fmt.Fprintln(os.Stderr, "usage: htmlstrip [OPTIONS] [FILE...]")
+	flag.PrintDefaults()
+	os.Exit(2)
+}
+
 func strip(s string, decode, collapseSpace bool) string {
 	s = scriptRe.ReplaceAllString(s, "")
 	s = tagRe.ReplaceAllString(s, "")
-	if decode { s = html.UnescapeString(s) }
+	if decode {
+		s = html.UnescapeString(s)
+	}
 	if collapseSpace {
 		s = spaceRe.ReplaceAllString(s, " ")
 		s = strings.TrimSpace(s)
@@ -27,16 +91,59 @@ func strip(s string, decode, collapseSpace bool) string {
 	return s
 }
 
+func process(r io.Reader, opt htmltree.TextOptions) error {
+	if !*textMode && !*markdown {
+		sc := bufio.NewScanner(r)
+		sc.Buffer(make([]byte, 1024*1024), 1024*1024)
+		for sc.Scan() {
+			fmt.Fprintln(out, strip(sc.Text(), *decode, *collapse))
+		}
+		return sc.Err()
+	}
+	doc, err := htmltree.Parse(r)
+	if err != nil {
+		return err
+	}
+	return htmltree.WriteText(out, doc, opt)
+}
+
 func main() {
-	decode := false
-	collapse := false
-	for _, arg := range os.Args[1:] {
-		switch arg {
-		case "-d", "--decode": decode = true
-		case "-c", "--collapse": collapse = true
+	flag.Usage = usage
+	files := cli.Parse(flag.CommandLine, os.Args[1:])
+	if *textMode && *markdown || *width < 0 {
+		usage()
+	}
+	opt := htmltree.TextOptions{Markdown: *markdown, Width: *width}
+	if *base != "" {
+		u, err := url.Parse(*base)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "htmlstrip: %v\n", err)
+			os.Exit(2)
+		}
+		opt.Base = u
+	}
+
+	status := 0
+	run := func(r io.Reader, name string) {
+		err := process(r, opt)
+		out.Flush()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "htmlstrip: %s: %v\n", name, err)
+			status = 1
+		}
+	}
+	if len(files) == 0 {
+		run(os.Stdin, "<stdin>")
+	}
+	for _, f := range files {
+		fh, err := os.Open(f)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "htmlstrip: %v\n", err)
+			status = 1
+			continue
 		}
+		run(fh, f)
+		fh.Close()
 	}
-	sc := bufio.NewScanner(os.Stdin)
-	sc.Buffer(make([]byte, 1024*1024), 1024*1024)
-	for sc.Scan() { fmt.Println(strip(sc.Text(), decode, collapse)) }
+	os.Exit(status)
 }
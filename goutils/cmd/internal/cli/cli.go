@@ -0,0 +1,70 @@
+// Package cli holds the command-line plumbing shared by the cmd tools:
+// parsing options that follow the operands, list-valued flags and
+// replacing output files in place.
+package cli
+
+import (
+	"flag"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Parse parses args with fs, letting options follow the operands as well
+// as precede them, and returns the operands.
+func Parse(fs *flag.FlagSet, args []string) []string {
+	var operands []string
+	for {
+		fs.Parse(args)
+		args = fs.Args()
+		if len(args) == 0 {
+			return operands
+		}
+		operands, args = append(operands, args[0]), args[1:]
+	}
+}
+
+// SplitList splits a comma-separated option value, trimming spaces and
+// dropping empty items, so that "a, b" and "a,b" are the same list.
+func SplitList(s string) []string {
+	var out []string
+	for _, f := range strings.Split(s, ",") {
+		if f = strings.TrimSpace(f); f != "" {
+			out = append(out, f)
+		}
+	}
+	return out
+}
+
+// ColumnList is a repeatable flag of comma-separated names, such as -k id -k
+// region or -k id,region.
+type ColumnList []string
+
+func (l *ColumnList) String() string     { return strings.Join(*l, ",") }
+func (l *ColumnList) Set(v string) error { *l = append(*l, SplitList(v)...); return nil }
+
+// WriteFile replaces name with data via a temporary file in the same
+// directory, keeping the original's permissions.
+func WriteFile(name string, data []byte) error {
+	mode := os.FileMode(0o644)
+	if fi, err := os.Stat(name); err == nil {
+		mode = fi.Mode().Perm()
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(name), "."+filepath.Base(name)+".*")
+	if err != nil {
+		return err
+	}
+	if _, err = tmp.Write(data); err == nil {
+		err = tmp.Chmod(mode)
+	}
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), name)
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+	}
+	return err
+}
@@ -0,0 +1,197 @@
+package config
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strings"
+)
+
+func init() {
+	Register(&Format{Name: "env", Exts: []string{".env"}, Decode: decodeEnv, Encode: encodeEnv})
+}
+
+// .env files hold NAME=value lines, optionally prefixed with export.
+// Values are strings: unquoted values end at a " #" comment, single quotes
+// are literal, and double quotes take backslash escapes; quoted values may
+// span lines. Outside single quotes, $NAME and ${NAME} expand to the value
+// of an earlier variable in the file, or to nothing if there is none.
+
+func decodeEnv(data []byte) (interface{}, error) {
+	m := NewMap()
+	lines := strings.Split(strings.TrimPrefix(string(data), "\ufeff"), "\n")
+	for n := 0; n < len(lines); n++ {
+		start := n + 1
+		line := strings.TrimSpace(strings.TrimRight(lines[n], "\r"))
+		if line == "" || line[0] == '#' {
+			continue
+		}
+		if rest, ok := strings.CutPrefix(line, "export "); ok {
+			line = strings.TrimLeft(rest, " \t")
+		}
+		eq := strings.IndexByte(line, '=')
+		if eq < 0 {
+			return nil, errorf(start, "expected NAME=value, found %q", line)
+		}
+		name := strings.TrimSpace(line[:eq])
+		if !validEnvName(name) {
+			return nil, errorf(start, "invalid variable name %q", name)
+		}
+		val := strings.TrimLeft(line[eq+1:], " \t")
+		if val != "" && (val[0] == '"' || val[0] == '\'') {
+			q := val[0]
+			text := val[1:]
+			// Gather lines up to the closing quote.
+			for envQuoteEnd(text, q) < 0 {
+				n++
+				if n >= len(lines) {
+					return nil, errorf(start, "unterminated %c quote", q)
+				}
+				text += "\n" + strings.TrimRight(lines[n], "\r")
+			}
+			end := envQuoteEnd(text, q)
+			if rest := strings.TrimSpace(text[end+1:]); rest != "" && rest[0] != '#' {
+				return nil, errorf(n+1, "unexpected %q after quoted value", rest)
+			}
+			text = text[:end]
+			if q == '"' {
+				text = envExpand(text, m, true)
+			}
+			m.Set(name, text)
+			continue
+		}
+		if i := strings.Index(val, " #"); i >= 0 {
+			val = val[:i]
+		}
+		m.Set(name, envExpand(strings.TrimSpace(val), m, false))
+	}
+	return m, nil
+}
+
+func validEnvName(s string) bool {
+	if s == "" || (s[0] >= '0' && s[0] <= '9') {
+		return false
+	}
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if !(c == '_' || c == '.' || c == '-' || c >= 'A' && c <= 'Z' || c >= 'a' && c <= 'z' || c >= '0' && c <= '9') {
+			return false
+		}
+	}
+	return true
+}
+
+func envQuoteEnd(s string, q byte) int {
+	for i := 0; i < len(s); i++ {
+		if q == '"' && s[i] == '\\' {
+			i++
+			continue
+		}
+		if s[i] == q {
+			return i
+		}
+	}
+	return -1
+}
+
+// envExpand replaces $NAME and ${NAME} in s with the variables already
+// set in m and, for double-quoted values, takes the backslash escapes.
+func envExpand(s string, m *Map, escapes bool) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		switch {
+		case s[i] == '\\' && escapes && i+1 < len(s):
+			i++
+			switch s[i] {
+			case 'n':
+				b.WriteByte('\n')
+			case 't':
+				b.WriteByte('\t')
+			case 'r':
+				b.WriteByte('\r')
+			case '"', '\\', '$', '`':
+				b.WriteByte(s[i])
+			default:
+				b.WriteByte('\\')
+				b.WriteByte(s[i])
+			}
+		case s[i] == '$' && i+1 < len(s) && s[i+1] == '{':
+			end := strings.IndexByte(s[i+2:], '}')
+			if end < 0 {
+				b.WriteString(s[i:])
+				return b.String()
+			}
+			b.WriteString(envLookup(m, s[i+2:i+2+end]))
+			i += 2 + end
+		case s[i] == '$' && i+1 < len(s) && envNameStart(s[i+1]):
+			j := i + 2
+			for j < len(s) && (envNameStart(s[j]) || s[j] >= '0' && s[j] <= '9') {
+				j++
+			}
+			b.WriteString(envLookup(m, s[i+1:j]))
+			i = j - 1
+		default:
+			b.WriteByte(s[i])
+		}
+	}
+	return b.String()
+}
+
+func envNameStart(c byte) bool {
+	return c == '_' || c >= 'A' && c <= 'Z' || c >= 'a' && c <= 'z'
+}
+
+func envLookup(m *Map, name string) string {
+	v, _ := m.Get(name)
+	s, _ := v.(string)
+	return s
+}
+
+// envName turns a flattened key into a variable name: upper case, with
+// characters other than letters, digits and _ replaced by _.
+func envName(k string) string {
+	b := []byte(strings.ToUpper(k))
+	for i, c := range b {
+		if !(c == '_' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9') {
+			b[i] = '_'
+		}
+	}
+	if len(b) > 0 && b[0] >= '0' && b[0] <= '9' {
+		return "_" + string(b)
+	}
+	return string(b)
+}
+
+func envQuote(s string) string {
+	safe := true
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if !(c >= 'A' && c <= 'Z' || c >= 'a' && c <= 'z' || c >= '0' && c <= '9' || strings.IndexByte("_./:@%+,=-", c) >= 0) {
+			safe = false
+			break
+		}
+	}
+	if safe {
+		return s
+	}
+	r := strings.NewReplacer(`\`, `\\`, `"`, `\"`, "$", `\$`, "`", "\\`", "\n", `\n`, "\r", `\r`, "\t", `\t`)
+	return `"` + r.Replace(s) + `"`
+}
+
+// encodeEnv flattens nested keys with _ into variable names such as
+// DB_HOST.
+func encodeEnv(w io.Writer, v interface{}, opt Options) error {
+	bw := bufio.NewWriter(w)
+	var err error
+	Flatten(v, "_", func(k string, v interface{}) {
+		if k == "" && err == nil {
+			err = fmt.Errorf(".env needs an object at the top level, not %s", typeName(v))
+		}
+		s, _ := Text(v)
+		bw.WriteString(envName(k) + "=" + envQuote(s) + "\n")
+	})
+	if err != nil {
+		return err
+	}
+	return bw.Flush()
+}
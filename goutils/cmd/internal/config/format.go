@@ -0,0 +1,176 @@
+package config
+
+import (
+	"fmt"
+	"io"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// Options tunes an encoder.
+type Options struct {
+	// Indent is the number of spaces per nesting level. Formats that can
+	// be written on one line, such as JSON, are compact when it is 0.
+	Indent int
+}
+
+// Format is a pluggable reader and writer for one file format.
+type Format struct {
+	Name string
+	Exts []string // file extensions, with the dot
+	// Decode parses a whole document. Errors are *Error where the
+	// position is known.
+	Decode func(data []byte) (interface{}, error)
+	// Encode writes v. Values the format cannot hold, such as null in
+	// TOML, are errors.
+	Encode func(w io.Writer, v interface{}, opt Options) error
+}
+
+var formats []*Format
+
+// Register adds a format. Later registrations take precedence.
+func Register(f *Format) {
+	formats = append(formats, f)
+}
+
+// Formats returns the registered formats.
+func Formats() []*Format {
+	return formats
+}
+
+// Names lists the registered format names, for usage messages.
+func Names() string {
+	names := make([]string, len(formats))
+	for i, f := range formats {
+		names[i] = f.Name
+	}
+	return strings.Join(names, ", ")
+}
+
+// Lookup finds a format by name or extension (with or without the dot).
+func Lookup(name string) *Format {
+	name = strings.ToLower(name)
+	for i := len(formats) - 1; i >= 0; i-- {
+		if formats[i].Name == name {
+			return formats[i]
+		}
+	}
+	if !strings.HasPrefix(name, ".") {
+		name = "." + name
+	}
+	for i := len(formats) - 1; i >= 0; i-- {
+		f := formats[i]
+		for _, e := range f.Exts {
+			if e == name {
+				return f
+			}
+		}
+	}
+	return nil
+}
+
+// ForFile picks a format from a file name: its extension, or the whole
+// base name for names such as .env.
+func ForFile(name string) *Format {
+	base := strings.ToLower(filepath.Base(name))
+	if base == ".env" || strings.HasPrefix(base, ".env.") {
+		return Lookup("env")
+	}
+	if ext := filepath.Ext(base); ext != "" {
+		return Lookup(ext)
+	}
+	return nil
+}
+
+// ParsePath splits a key path such as servers[0].host or a."b.c" into
+// keys (strings) and array indexes (ints).
+func ParsePath(s string) ([]interface{}, error) {
+	var path []interface{}
+	for i := 0; i < len(s); {
+		switch s[i] {
+		case '.':
+			if i == 0 || i == len(s)-1 || s[i+1] == '.' {
+				return nil, fmt.Errorf("bad path %q: empty key", s)
+			}
+			i++
+		case '[':
+			j := strings.IndexByte(s[i:], ']')
+			if j < 0 {
+				return nil, fmt.Errorf("bad path %q: missing ]", s)
+			}
+			n, err := strconv.Atoi(s[i+1 : i+j])
+			if err != nil || n < 0 {
+				return nil, fmt.Errorf("bad path %q: bad index %q", s, s[i+1:i+j])
+			}
+			path = append(path, n)
+			i += j + 1
+		case '"':
+			j := i + 1
+			for j < len(s) && s[j] != '"' {
+				if s[j] == '\\' {
+					j++
+				}
+				j++
+			}
+			if j >= len(s) {
+				return nil, fmt.Errorf("bad path %q: unterminated quote", s)
+			}
+			k, err := strconv.Unquote(s[i : j+1])
+			if err != nil {
+				return nil, fmt.Errorf("bad path %q: %v", s, err)
+			}
+			path = append(path, k)
+			i = j + 1
+		default:
+			j := i
+			for j < len(s) && s[j] != '.' && s[j] != '[' {
+				j++
+			}
+			path = append(path, s[i:j])
+			i = j
+		}
+	}
+	return path, nil
+}
+
+// Get returns the value at path in v. A numeric key indexes an array, and
+// a key missing from an object is retried joined to the following keys with
+// dots, so a.b.c also finds the flat key "a.b.c" of a .properties file.
+func Get(v interface{}, path []interface{}) (interface{}, error) {
+	for len(path) > 0 {
+		switch x := v.(type) {
+		case *Map:
+			found := false
+			key := ""
+			for n, p := range path {
+				if n > 0 {
+					key += "."
+				}
+				key += fmt.Sprint(p)
+				if e, ok := x.Get(key); ok {
+					v, path, found = e, path[n+1:], true
+					break
+				}
+			}
+			if !found {
+				return nil, fmt.Errorf("no key %q", fmt.Sprint(path[0]))
+			}
+		case []interface{}:
+			i, ok := path[0].(int)
+			if !ok {
+				var err error
+				if i, err = strconv.Atoi(path[0].(string)); err != nil {
+					return nil, fmt.Errorf("%q is not an array index", path[0])
+				}
+			}
+			if i >= len(x) {
+				return nil, fmt.Errorf("index %d out of range (length %d)", i, len(x))
+			}
+			v, path = x[i], path[1:]
+		default:
+			return nil, fmt.Errorf("%v is not an object or array", path[0])
+		}
+	}
+	return v, nil
+}
@@ -0,0 +1,564 @@
+package config
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+)
+
+func init() {
+	Register(&Format{Name: "hcl", Exts: []string{".hcl", ".tf", ".tfvars"}, Decode: decodeHCL, Encode: encodeHCL})
+}
+
+// HCL-lite covers HCL's structure, not its expression language:
+// attributes (name = value), blocks (type "label" ... { body }), literal
+// values, lists, objects and heredocs. Any other expression, such as
+// var.region, is kept as a string. Blocks nest under their type and
+// labels; a block repeated under the same names becomes an array.
+
+type hclParser struct {
+	src  string
+	pos  int
+	line int
+}
+
+func decodeHCL(data []byte) (interface{}, error) {
+	p := &hclParser{src: strings.TrimPrefix(string(data), "\ufeff"), line: 1}
+	m, err := p.body(false)
+	if err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func (p *hclParser) errorf(format string, args ...interface{}) error {
+	return errorf(p.line, format, args...)
+}
+
+// space skips blanks and comments, and newlines too when nl is set. It
+// reports whether it passed a newline.
+func (p *hclParser) space(nl bool) (bool, error) {
+	sawNL := false
+	for p.pos < len(p.src) {
+		c := p.src[p.pos]
+		switch {
+		case c == ' ' || c == '\t' || c == '\r':
+			p.pos++
+		case c == '\n':
+			if !nl {
+				return sawNL, nil
+			}
+			p.pos++
+			p.line++
+			sawNL = true
+		case c == '#' || strings.HasPrefix(p.src[p.pos:], "//"):
+			for p.pos < len(p.src) && p.src[p.pos] != '\n' {
+				p.pos++
+			}
+		case strings.HasPrefix(p.src[p.pos:], "/*"):
+			end := strings.Index(p.src[p.pos+2:], "*/")
+			if end < 0 {
+				return sawNL, p.errorf("unterminated /* comment")
+			}
+			p.line += strings.Count(p.src[p.pos:p.pos+2+end], "\n")
+			p.pos += end + 4
+		default:
+			return sawNL, nil
+		}
+	}
+	return sawNL, nil
+}
+
+func isIdentChar(c byte, first bool) bool {
+	return c == '_' || c >= 'A' && c <= 'Z' || c >= 'a' && c <= 'z' || (!first && (c >= '0' && c <= '9' || c == '-'))
+}
+
+func (p *hclParser) ident() string {
+	start := p.pos
+	for p.pos < len(p.src) && isIdentChar(p.src[p.pos], p.pos == start) {
+		p.pos++
+	}
+	return p.src[start:p.pos]
+}
+
+// name reads an identifier or a quoted string.
+func (p *hclParser) name() (string, error) {
+	if p.pos < len(p.src) && p.src[p.pos] == '"' {
+		return p.str()
+	}
+	id := p.ident()
+	if id == "" {
+		return "", p.errorf("expected a name, found %q", p.peek())
+	}
+	return id, nil
+}
+
+func (p *hclParser) peek() string {
+	s := p.src[p.pos:]
+	if i := strings.IndexByte(s, '\n'); i >= 0 {
+		s = s[:i]
+	}
+	if len(s) > 20 {
+		s = s[:20] + "..."
+	}
+	return s
+}
+
+// body parses attributes and blocks up to the end of input or, inside a
+// block, the closing brace.
+func (p *hclParser) body(inBlock bool) (*Map, error) {
+	m := NewMap()
+	for {
+		if _, err := p.space(true); err != nil {
+			return nil, err
+		}
+		if p.pos >= len(p.src) {
+			if inBlock {
+				return nil, p.errorf("missing } at end of block")
+			}
+			return m, nil
+		}
+		if p.src[p.pos] == '}' {
+			if !inBlock {
+				return nil, p.errorf("unexpected }")
+			}
+			p.pos++
+			return m, nil
+		}
+		line := p.line
+		key, err := p.name()
+		if err != nil {
+			return nil, err
+		}
+		p.space(false)
+		if p.pos < len(p.src) && p.src[p.pos] == '=' {
+			p.pos++
+			p.space(false)
+			v, err := p.value()
+			if err != nil {
+				return nil, err
+			}
+			if _, dup := m.Get(key); dup {
+				return nil, errorf(line, "duplicate attribute %q", key)
+			}
+			m.Set(key, v)
+		} else {
+			// A block: type, labels, then { body }.
+			names := []string{key}
+			for p.pos < len(p.src) && p.src[p.pos] != '{' {
+				label, err := p.name()
+				if err != nil {
+					return nil, err
+				}
+				names = append(names, label)
+				p.space(false)
+			}
+			if p.pos >= len(p.src) {
+				return nil, p.errorf("expected { after block %s", strings.Join(names, " "))
+			}
+			p.pos++
+			b, err := p.body(true)
+			if err != nil {
+				return nil, err
+			}
+			if err := addBlock(m, names, b); err != nil {
+				return nil, errorf(line, "%v", err)
+			}
+		}
+		nl, err := p.space(false)
+		if err != nil {
+			return nil, err
+		}
+		if !nl && p.pos < len(p.src) && p.src[p.pos] != '\n' && p.src[p.pos] != '}' {
+			return nil, p.errorf("expected a new line, found %q", p.peek())
+		}
+	}
+}
+
+// addBlock files block b under its type and labels.
+func addBlock(m *Map, names []string, b *Map) error {
+	for _, n := range names[:len(names)-1] {
+		v, ok := m.Get(n)
+		if !ok {
+			sub := NewMap()
+			m.Set(n, sub)
+			m = sub
+			continue
+		}
+		sub, isMap := v.(*Map)
+		if !isMap {
+			return fmt.Errorf("block %s clashes with attribute %q", strings.Join(names, " "), n)
+		}
+		m = sub
+	}
+	last := names[len(names)-1]
+	old, ok := m.Get(last)
+	switch x := old.(type) {
+	case nil:
+		if ok {
+			return fmt.Errorf("block %s clashes with attribute %q", strings.Join(names, " "), last)
+		}
+		m.Set(last, b)
+	case *Map:
+		m.Set(last, []interface{}{x, b})
+	case []interface{}:
+		m.Set(last, append(x, b))
+	default:
+		return fmt.Errorf("block %s clashes with attribute %q", strings.Join(names, " "), last)
+	}
+	return nil
+}
+
+func (p *hclParser) value() (interface{}, error) {
+	if p.pos >= len(p.src) {
+		return nil, p.errorf("expected a value")
+	}
+	switch c := p.src[p.pos]; {
+	case c == '"':
+		return p.str()
+	case strings.HasPrefix(p.src[p.pos:], "<<"):
+		return p.heredoc()
+	case c == '[':
+		p.pos++
+		a := []interface{}{}
+		for {
+			if _, err := p.space(true); err != nil {
+				return nil, err
+			}
+			if p.pos < len(p.src) && p.src[p.pos] == ']' {
+				p.pos++
+				return a, nil
+			}
+			v, err := p.value()
+			if err != nil {
+				return nil, err
+			}
+			a = append(a, v)
+			if _, err := p.space(true); err != nil {
+				return nil, err
+			}
+			if p.pos < len(p.src) && p.src[p.pos] == ',' {
+				p.pos++
+			} else if p.pos >= len(p.src) || p.src[p.pos] != ']' {
+				return nil, p.errorf("expected , or ] in list, found %q", p.peek())
+			}
+		}
+	case c == '{':
+		p.pos++
+		m := NewMap()
+		for {
+			if _, err := p.space(true); err != nil {
+				return nil, err
+			}
+			if p.pos < len(p.src) && p.src[p.pos] == '}' {
+				p.pos++
+				return m, nil
+			}
+			k, err := p.name()
+			if err != nil {
+				return nil, err
+			}
+			p.space(false)
+			if p.pos >= len(p.src) || (p.src[p.pos] != '=' && p.src[p.pos] != ':') {
+				return nil, p.errorf("expected = after %q", k)
+			}
+			p.pos++
+			p.space(false)
+			v, err := p.value()
+			if err != nil {
+				return nil, err
+			}
+			m.Set(k, v)
+			p.space(false)
+			if p.pos < len(p.src) && p.src[p.pos] == ',' {
+				p.pos++
+			}
+		}
+	}
+	// A number, keyword or other expression, up to the end of the line or
+	// a delimiter.
+	start := p.pos
+	depth := 0
+	for p.pos < len(p.src) {
+		c := p.src[p.pos]
+		if c == '\n' || c == '#' || strings.HasPrefix(p.src[p.pos:], "//") || (depth == 0 && (c == ',' || c == ']' || c == '}')) {
+			break
+		}
+		if c == '(' || c == '[' {
+			depth++
+		} else if c == ')' || c == ']' {
+			depth--
+		}
+		p.pos++
+	}
+	tok := strings.TrimSpace(p.src[start:p.pos])
+	switch tok {
+	case "":
+		return nil, p.errorf("expected a value, found %q", p.peek())
+	case "true":
+		return true, nil
+	case "false":
+		return false, nil
+	case "null":
+		return nil, nil
+	}
+	if i, err := strconv.ParseInt(tok, 10, 64); err == nil {
+		return i, nil
+	}
+	if yamlFloat.MatchString(tok) {
+		f, _ := strconv.ParseFloat(tok, 64)
+		return f, nil
+	}
+	return tok, nil
+}
+
+func (p *hclParser) str() (string, error) {
+	p.pos++
+	var b strings.Builder
+	for {
+		if p.pos >= len(p.src) || p.src[p.pos] == '\n' {
+			return "", p.errorf("unterminated string")
+		}
+		c := p.src[p.pos]
+		p.pos++
+		switch c {
+		case '"':
+			return b.String(), nil
+		case '\\':
+			if p.pos >= len(p.src) {
+				return "", p.errorf("unterminated string")
+			}
+			e := p.src[p.pos]
+			p.pos++
+			switch e {
+			case 'n':
+				b.WriteByte('\n')
+			case 'r':
+				b.WriteByte('\r')
+			case 't':
+				b.WriteByte('\t')
+			case '"', '\\':
+				b.WriteByte(e)
+			case 'u', 'U':
+				n := 4
+				if e == 'U' {
+					n = 8
+				}
+				if p.pos+n > len(p.src) {
+					return "", p.errorf("short \\%c escape", e)
+				}
+				r, err := strconv.ParseUint(p.src[p.pos:p.pos+n], 16, 32)
+				if err != nil {
+					return "", p.errorf("bad \\%c escape", e)
+				}
+				b.WriteRune(rune(r))
+				p.pos += n
+			default:
+				return "", p.errorf("bad escape \\%c", e)
+			}
+		default:
+			b.WriteByte(c)
+		}
+	}
+}
+
+// heredoc parses <<EOF or <<-EOF text; the - form strips the common
+// indentation.
+func (p *hclParser) heredoc() (string, error) {
+	start := p.line
+	p.pos += 2
+	strip := false
+	if p.pos < len(p.src) && p.src[p.pos] == '-' {
+		strip = true
+		p.pos++
+	}
+	marker := p.ident()
+	if marker == "" {
+		return "", p.errorf("expected a heredoc marker after <<")
+	}
+	p.space(false)
+	if p.pos >= len(p.src) || p.src[p.pos] != '\n' {
+		return "", p.errorf("expected a new line after <<%s", marker)
+	}
+	p.pos++
+	p.line++
+	var lines []string
+	for {
+		if p.pos >= len(p.src) {
+			return "", errorf(start, "heredoc %s is not closed", marker)
+		}
+		end := strings.IndexByte(p.src[p.pos:], '\n')
+		if end < 0 {
+			end = len(p.src) - p.pos
+		}
+		l := strings.TrimRight(p.src[p.pos:p.pos+end], "\r")
+		p.pos += end
+		if strings.TrimSpace(l) == marker {
+			break
+		}
+		lines = append(lines, l)
+		if p.pos < len(p.src) {
+			p.pos++
+			p.line++
+		}
+	}
+	if strip {
+		common := -1
+		for _, l := range lines {
+			if strings.TrimSpace(l) == "" {
+				continue
+			}
+			if n := len(l) - len(strings.TrimLeft(l, " \t")); common < 0 || n < common {
+				common = n
+			}
+		}
+		for i, l := range lines {
+			if len(l) >= common && common > 0 {
+				lines[i] = l[common:]
+			}
+		}
+	}
+	if len(lines) == 0 {
+		return "", nil
+	}
+	return strings.Join(lines, "\n") + "\n", nil
+}
+
+// HCL writer.
+
+func hclName(k string) string {
+	if k == "" || !isIdentChar(k[0], true) {
+		return quoteJSON(k)
+	}
+	for i := 1; i < len(k); i++ {
+		if !isIdentChar(k[i], false) {
+			return quoteJSON(k)
+		}
+	}
+	return k
+}
+
+type hclEncoder struct {
+	w    *bufio.Writer
+	step int
+}
+
+func encodeHCL(w io.Writer, v interface{}, opt Options) error {
+	m, ok := v.(*Map)
+	if !ok {
+		return fmt.Errorf("HCL needs an object at the top level, not %s", typeName(v))
+	}
+	e := &hclEncoder{w: bufio.NewWriter(w), step: opt.Indent}
+	if e.step <= 0 {
+		e.step = 2
+	}
+	if err := e.body(m, 0); err != nil {
+		return err
+	}
+	return e.w.Flush()
+}
+
+// body writes attributes first, then objects and arrays of objects as
+// blocks.
+func (e *hclEncoder) body(m *Map, depth int) error {
+	pad := strings.Repeat(" ", e.step*depth)
+	var blocks []string
+	for _, k := range m.keys {
+		v := m.vals[k]
+		if _, ok := v.(*Map); ok || isTableArray(v) {
+			blocks = append(blocks, k)
+			continue
+		}
+		s, err := e.expr(v, depth, false)
+		if err != nil {
+			return err
+		}
+		e.w.WriteString(pad + hclName(k) + " = " + s + "\n")
+	}
+	for i, k := range blocks {
+		if i > 0 || len(blocks) < m.Len() {
+			e.w.WriteString("\n")
+		}
+		bs, ok := m.vals[k].([]interface{})
+		if !ok {
+			bs = []interface{}{m.vals[k]}
+		}
+		for j, b := range bs {
+			if j > 0 {
+				e.w.WriteString("\n")
+			}
+			e.w.WriteString(pad + hclName(k) + " {\n")
+			if err := e.body(b.(*Map), depth+1); err != nil {
+				return err
+			}
+			e.w.WriteString(pad + "}\n")
+		}
+	}
+	return nil
+}
+
+// expr formats an attribute value; objects inside lists are kept on one
+// line.
+func (e *hclEncoder) expr(v interface{}, depth int, oneLine bool) (string, error) {
+	switch x := v.(type) {
+	case nil:
+		return "null", nil
+	case bool:
+		return strconv.FormatBool(x), nil
+	case int64:
+		return strconv.FormatInt(x, 10), nil
+	case float64:
+		if math.IsInf(x, 0) || math.IsNaN(x) {
+			return "", fmt.Errorf("HCL cannot represent %v", x)
+		}
+		return FormatFloat(x), nil
+	case string:
+		if !oneLine && strings.Contains(strings.TrimSuffix(x, "\n"), "\n") && strings.HasSuffix(x, "\n") && !strings.Contains(x, "\nEOT\n") && !strings.ContainsAny(x, "\r") {
+			return "<<EOT\n" + x + "EOT", nil
+		}
+		return quoteJSON(x), nil
+	case Datetime:
+		return quoteJSON(x.String()), nil
+	case []interface{}:
+		parts := make([]string, len(x))
+		for i, el := range x {
+			s, err := e.expr(el, depth, true)
+			if err != nil {
+				return "", err
+			}
+			parts[i] = s
+		}
+		return "[" + strings.Join(parts, ", ") + "]", nil
+	case *Map:
+		if x.Len() == 0 {
+			return "{}", nil
+		}
+		if oneLine {
+			parts := make([]string, 0, x.Len())
+			for _, k := range x.keys {
+				s, err := e.expr(x.vals[k], depth, true)
+				if err != nil {
+					return "", err
+				}
+				parts = append(parts, hclName(k)+" = "+s)
+			}
+			return "{ " + strings.Join(parts, ", ") + " }", nil
+		}
+		pad := strings.Repeat(" ", e.step*(depth+1))
+		var b strings.Builder
+		b.WriteString("{\n")
+		for _, k := range x.keys {
+			s, err := e.expr(x.vals[k], depth+1, false)
+			if err != nil {
+				return "", err
+			}
+			b.WriteString(pad + hclName(k) + " = " + s + "\n")
+		}
+		b.WriteString(strings.Repeat(" ", e.step*depth) + "}")
+		return b.String(), nil
+	}
+	return "", fmt.Errorf("cannot encode %T", v)
+}
@@ -0,0 +1,177 @@
+package config
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strings"
+)
+
+func init() {
+	Register(&Format{Name: "ini", Exts: []string{".ini", ".cfg", ".conf"}, Decode: decodeINI, Encode: encodeINI})
+}
+
+// INI files hold strings only. Keys before the first [section] are top
+// level; a key repeated in a section becomes an array; indented lines
+// continue the previous value. Any other line must be key = value or
+// key: value.
+
+func decodeINI(data []byte) (interface{}, error) {
+	root := NewMap()
+	sec := root
+	var lastKey string
+	var lastMap *Map
+	lines := strings.Split(strings.TrimPrefix(string(data), "\ufeff"), "\n")
+	for n, raw := range lines {
+		raw = strings.TrimRight(raw, "\r")
+		line := strings.TrimSpace(raw)
+		if line == "" || line[0] == '#' || line[0] == ';' {
+			continue
+		}
+		// Continuation of a multi-line value.
+		if (raw[0] == ' ' || raw[0] == '\t') && lastMap != nil {
+			v, _ := lastMap.Get(lastKey)
+			switch x := v.(type) {
+			case string:
+				lastMap.Set(lastKey, x+"\n"+line)
+			case []interface{}:
+				x[len(x)-1] = x[len(x)-1].(string) + "\n" + line
+			}
+			continue
+		}
+		if line[0] == '[' {
+			end := strings.IndexByte(line, ']')
+			if end < 0 {
+				return nil, errorf(n+1, "missing ] in section header")
+			}
+			if rest := strings.TrimSpace(line[end+1:]); rest != "" && rest[0] != '#' && rest[0] != ';' {
+				return nil, errorf(n+1, "unexpected %q after section header", rest)
+			}
+			name := strings.TrimSpace(line[1:end])
+			if v, ok := root.Get(name); ok {
+				m, isMap := v.(*Map)
+				if !isMap {
+					return nil, errorf(n+1, "section [%s] clashes with a top-level key", name)
+				}
+				sec = m
+			} else {
+				sec = NewMap()
+				root.Set(name, sec)
+			}
+			lastMap = nil
+			continue
+		}
+		i := strings.IndexAny(line, "=:")
+		if i < 0 {
+			return nil, errorf(n+1, "expected key = value, found %q", line)
+		}
+		key := strings.TrimSpace(line[:i])
+		if key == "" {
+			return nil, errorf(n+1, "missing key before %q", line[i:i+1])
+		}
+		var v interface{} = iniValue(strings.TrimSpace(line[i+1:]))
+		if old, dup := sec.Get(key); dup {
+			if a, ok := old.([]interface{}); ok {
+				v = append(a, v)
+			} else {
+				v = []interface{}{old, v}
+			}
+		}
+		sec.Set(key, v)
+		lastMap, lastKey = sec, key
+	}
+	return root, nil
+}
+
+// iniValue strips an inline comment and matching quotes from a value.
+func iniValue(s string) string {
+	if len(s) >= 2 && (s[0] == '"' || s[0] == '\'') {
+		if end := strings.IndexByte(s[1:], s[0]); end >= 0 {
+			rest := strings.TrimSpace(s[end+2:])
+			if rest == "" || rest[0] == '#' || rest[0] == ';' {
+				return s[1 : end+1]
+			}
+		}
+	}
+	for i := 1; i < len(s); i++ {
+		if (s[i] == '#' || s[i] == ';') && (s[i-1] == ' ' || s[i-1] == '\t') {
+			return strings.TrimSpace(s[:i])
+		}
+	}
+	return s
+}
+
+func iniQuote(s string) string {
+	if s == "" || s != strings.TrimSpace(s) || strings.ContainsAny(s, "#;\"'") {
+		return `"` + s + `"`
+	}
+	return s
+}
+
+// iniEntries writes the scalars of v as key = value lines, flattening
+// nested objects with dotted keys and arrays as repeated keys.
+func iniEntries(w *bufio.Writer, key string, v interface{}) error {
+	switch x := v.(type) {
+	case *Map:
+		for _, k := range x.keys {
+			if err := iniEntries(w, key+"."+k, x.vals[k]); err != nil {
+				return err
+			}
+		}
+		return nil
+	case []interface{}:
+		for _, e := range x {
+			if _, nested := e.([]interface{}); nested {
+				return fmt.Errorf("INI cannot represent nested arrays (at %s)", strings.TrimPrefix(key, "."))
+			}
+			if err := iniEntries(w, key, e); err != nil {
+				return err
+			}
+		}
+		return nil
+	case nil:
+		return fmt.Errorf("INI cannot represent null (at %s)", strings.TrimPrefix(key, "."))
+	}
+	s, _ := Text(v)
+	if strings.ContainsAny(s, "\r\n") {
+		s = strings.ReplaceAll(strings.ReplaceAll(s, "\r", ""), "\n", "\n    ")
+	} else {
+		s = iniQuote(s)
+	}
+	w.WriteString(strings.TrimPrefix(key, ".") + " = " + s + "\n")
+	return nil
+}
+
+func encodeINI(w io.Writer, v interface{}, opt Options) error {
+	root, ok := v.(*Map)
+	if !ok {
+		return fmt.Errorf("INI needs an object at the top level, not %s", typeName(v))
+	}
+	bw := bufio.NewWriter(w)
+	wrote := false
+	for _, k := range root.keys {
+		if _, isMap := root.vals[k].(*Map); !isMap {
+			if err := iniEntries(bw, "."+k, root.vals[k]); err != nil {
+				return err
+			}
+			wrote = true
+		}
+	}
+	for _, k := range root.keys {
+		sec, isMap := root.vals[k].(*Map)
+		if !isMap {
+			continue
+		}
+		if wrote {
+			bw.WriteString("\n")
+		}
+		bw.WriteString("[" + k + "]\n")
+		for _, sk := range sec.keys {
+			if err := iniEntries(bw, "."+sk, sec.vals[sk]); err != nil {
+				return err
+			}
+		}
+		wrote = true
+	}
+	return bw.Flush()
+}
@@ -0,0 +1,186 @@
+package config
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+)
+
+func init() {
+	Register(&Format{Name: "json", Exts: []string{".json"}, Decode: decodeJSON, Encode: encodeJSON})
+}
+
+func decodeJSON(data []byte) (interface{}, error) {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	v, err := jsonValue(dec)
+	if err == nil {
+		if _, err = dec.Token(); err == io.EOF {
+			return v, nil
+		}
+		if err == nil {
+			err = errors.New("data after the top-level value")
+		}
+	}
+	if err == io.EOF {
+		err = io.ErrUnexpectedEOF
+	}
+	off := dec.InputOffset()
+	var se *json.SyntaxError
+	if errors.As(err, &se) {
+		off = se.Offset
+	}
+	return nil, jsonError(data, off, err)
+}
+
+// jsonError places err at byte offset off of data.
+func jsonError(data []byte, off int64, err error) error {
+	if off > int64(len(data)) {
+		off = int64(len(data))
+	}
+	before := data[:off]
+	line := bytes.Count(before, []byte("\n")) + 1
+	col := int(off) - bytes.LastIndexByte(before, '\n')
+	return &Error{Line: line, Col: col, Msg: err.Error()}
+}
+
+func jsonValue(dec *json.Decoder) (interface{}, error) {
+	t, err := dec.Token()
+	if err != nil {
+		return nil, err
+	}
+	switch t := t.(type) {
+	case json.Delim:
+		switch t {
+		case '{':
+			m := NewMap()
+			for dec.More() {
+				kt, err := dec.Token()
+				if err != nil {
+					return nil, err
+				}
+				v, err := jsonValue(dec)
+				if err != nil {
+					return nil, err
+				}
+				m.Set(kt.(string), v)
+			}
+			_, err := dec.Token()
+			return m, err
+		case '[':
+			a := []interface{}{}
+			for dec.More() {
+				v, err := jsonValue(dec)
+				if err != nil {
+					return nil, err
+				}
+				a = append(a, v)
+			}
+			_, err := dec.Token()
+			return a, err
+		}
+		return nil, fmt.Errorf("unexpected %q", t)
+	case json.Number:
+		if i, err := strconv.ParseInt(string(t), 10, 64); err == nil {
+			return i, nil
+		}
+		f, err := strconv.ParseFloat(string(t), 64)
+		if err != nil && !errors.Is(err, strconv.ErrRange) {
+			return nil, err
+		}
+		return f, nil
+	}
+	return t, nil
+}
+
+func encodeJSON(w io.Writer, v interface{}, opt Options) error {
+	bw := bufio.NewWriter(w)
+	if err := writeJSON(bw, v, opt.Indent, 0); err != nil {
+		return err
+	}
+	bw.WriteByte('\n')
+	return bw.Flush()
+}
+
+// quoteJSON quotes s as a JSON string without escaping HTML characters.
+func quoteJSON(s string) string {
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+	enc.Encode(s)
+	return strings.TrimSuffix(buf.String(), "\n")
+}
+
+func writeJSON(w *bufio.Writer, v interface{}, indent, depth int) error {
+	newline := func(d int) {
+		if indent > 0 {
+			w.WriteByte('\n')
+			w.WriteString(strings.Repeat(" ", indent*d))
+		}
+	}
+	colon := ":"
+	if indent > 0 {
+		colon = ": "
+	}
+	switch x := v.(type) {
+	case nil:
+		w.WriteString("null")
+	case bool:
+		w.WriteString(strconv.FormatBool(x))
+	case int64:
+		w.WriteString(strconv.FormatInt(x, 10))
+	case float64:
+		if math.IsInf(x, 0) || math.IsNaN(x) {
+			return fmt.Errorf("JSON cannot represent %v", x)
+		}
+		w.WriteString(FormatFloat(x))
+	case string:
+		w.WriteString(quoteJSON(x))
+	case Datetime:
+		w.WriteString(quoteJSON(x.String()))
+	case *Map:
+		if x.Len() == 0 {
+			w.WriteString("{}")
+			return nil
+		}
+		w.WriteByte('{')
+		for i, k := range x.keys {
+			if i > 0 {
+				w.WriteByte(',')
+			}
+			newline(depth + 1)
+			w.WriteString(quoteJSON(k) + colon)
+			if err := writeJSON(w, x.vals[k], indent, depth+1); err != nil {
+				return err
+			}
+		}
+		newline(depth)
+		w.WriteByte('}')
+	case []interface{}:
+		if len(x) == 0 {
+			w.WriteString("[]")
+			return nil
+		}
+		w.WriteByte('[')
+		for i, e := range x {
+			if i > 0 {
+				w.WriteByte(',')
+			}
+			newline(depth + 1)
+			if err := writeJSON(w, e, indent, depth+1); err != nil {
+				return err
+			}
+		}
+		newline(depth)
+		w.WriteByte(']')
+	default:
+		return fmt.Errorf("cannot encode %T", v)
+	}
+	return nil
+}
@@ -0,0 +1,161 @@
+package config
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+func init() {
+	Register(&Format{Name: "properties", Exts: []string{".properties"}, Decode: decodeProperties, Encode: encodeProperties})
+}
+
+// Java .properties files are flat: keys keep their dots and values are
+// strings. Nested values are written with dotted keys, array elements
+// numbered from 0.
+
+func decodeProperties(data []byte) (interface{}, error) {
+	m := NewMap()
+	lines := strings.Split(strings.TrimPrefix(string(data), "\ufeff"), "\n")
+	for n := 0; n < len(lines); n++ {
+		start := n + 1
+		line := strings.TrimLeft(strings.TrimRight(lines[n], "\r"), " \t\f")
+		if line == "" || line[0] == '#' || line[0] == '!' {
+			continue
+		}
+		// An odd number of trailing backslashes continues the line.
+		for trailingBackslashes(line)%2 == 1 {
+			line = line[:len(line)-1]
+			if n+1 >= len(lines) {
+				break
+			}
+			n++
+			line += strings.TrimLeft(strings.TrimRight(lines[n], "\r"), " \t\f")
+		}
+		// The key ends at the first unescaped =, : or white space.
+		i := 0
+		for i < len(line) {
+			c := line[i]
+			if c == '\\' {
+				i += 2
+				continue
+			}
+			if c == '=' || c == ':' || c == ' ' || c == '\t' || c == '\f' {
+				break
+			}
+			i++
+		}
+		if i > len(line) {
+			i = len(line)
+		}
+		key, rest := line[:i], strings.TrimLeft(line[i:], " \t\f")
+		if rest != "" && (rest[0] == '=' || rest[0] == ':') {
+			rest = strings.TrimLeft(rest[1:], " \t\f")
+		}
+		k, err := propUnescape(key)
+		if err != nil {
+			return nil, errorf(start, "%v", err)
+		}
+		v, err := propUnescape(rest)
+		if err != nil {
+			return nil, errorf(start, "%v", err)
+		}
+		m.Set(k, v)
+	}
+	return m, nil
+}
+
+func trailingBackslashes(s string) int {
+	return len(s) - len(strings.TrimRight(s, `\`))
+}
+
+func propUnescape(s string) (string, error) {
+	if !strings.Contains(s, `\`) {
+		return s, nil
+	}
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] != '\\' || i+1 >= len(s) {
+			b.WriteByte(s[i])
+			continue
+		}
+		i++
+		switch s[i] {
+		case 't':
+			b.WriteByte('\t')
+		case 'n':
+			b.WriteByte('\n')
+		case 'r':
+			b.WriteByte('\r')
+		case 'f':
+			b.WriteByte('\f')
+		case 'u':
+			if i+5 > len(s) {
+				return "", fmt.Errorf("short \\u escape")
+			}
+			r, err := strconv.ParseUint(s[i+1:i+5], 16, 16)
+			if err != nil {
+				return "", fmt.Errorf("bad \\u escape %q", s[i+1:i+5])
+			}
+			b.WriteRune(rune(r))
+			i += 4
+		default:
+			b.WriteByte(s[i])
+		}
+	}
+	return b.String(), nil
+}
+
+// propEscape escapes a key, or a value when isKey is false.
+func propEscape(s string, isKey bool) string {
+	var b strings.Builder
+	for i, r := range s {
+		switch r {
+		case '\\':
+			b.WriteString(`\\`)
+		case '\n':
+			b.WriteString(`\n`)
+		case '\r':
+			b.WriteString(`\r`)
+		case '\t':
+			b.WriteString(`\t`)
+		case '\f':
+			b.WriteString(`\f`)
+		case '=', ':', '#', '!':
+			if isKey || (i == 0 && (r == '#' || r == '!')) {
+				b.WriteByte('\\')
+			}
+			b.WriteRune(r)
+		case ' ':
+			if isKey || i == 0 {
+				b.WriteByte('\\')
+			}
+			b.WriteRune(r)
+		default:
+			if r < 0x20 || r == 0x7f {
+				fmt.Fprintf(&b, `\u%04X`, r)
+			} else {
+				b.WriteRune(r)
+			}
+		}
+	}
+	return b.String()
+}
+
+func encodeProperties(w io.Writer, v interface{}, opt Options) error {
+	bw := bufio.NewWriter(w)
+	var err error
+	Flatten(v, ".", func(k string, v interface{}) {
+		if k == "" && err == nil {
+			err = fmt.Errorf("properties need an object at the top level, not %s", typeName(v))
+		}
+		s, _ := Text(v)
+		bw.WriteString(propEscape(k, true) + "=" + propEscape(s, false) + "\n")
+	})
+	if err != nil {
+		return err
+	}
+	return bw.Flush()
+}
@@ -0,0 +1,869 @@
+package config
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+func init() {
+	Register(&Format{Name: "toml", Exts: []string{".toml"}, Decode: decodeTOML, Encode: encodeTOML})
+}
+
+// tableKind records how a TOML table came to exist, which decides whether
+// it may be extended later.
+type tableKind int
+
+const (
+	implicitTable tableKind = iota // a parent named in a [header]
+	headerTable                    // defined by its own [header] or [[header]]
+	dottedTable                    // created by a dotted key
+	inlineTable                    // { ... }, closed once written
+)
+
+type tomlParser struct {
+	src  string
+	pos  int
+	line int
+
+	root  *Map
+	cur   *Map
+	kinds map[*Map]tableKind
+	// arrays of tables, by parent table and key
+	aot map[*Map]map[string]bool
+}
+
+func decodeTOML(data []byte) (interface{}, error) {
+	if !utf8.Valid(data) {
+		return nil, &Error{Msg: "not valid UTF-8"}
+	}
+	p := &tomlParser{
+		src:   strings.TrimPrefix(string(data), "\ufeff"),
+		line:  1,
+		root:  NewMap(),
+		kinds: map[*Map]tableKind{},
+		aot:   map[*Map]map[string]bool{},
+	}
+	p.cur = p.root
+	p.kinds[p.root] = headerTable
+	for {
+		p.skipWS()
+		if p.pos >= len(p.src) {
+			return p.root, nil
+		}
+		var err error
+		switch c := p.src[p.pos]; {
+		case c == '#' || c == '\n' || c == '\r':
+		case c == '[':
+			err = p.header()
+		default:
+			err = p.keyValue(p.cur)
+		}
+		if err == nil {
+			err = p.endOfLine()
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+}
+
+func (p *tomlParser) errorf(format string, args ...interface{}) error {
+	return errorf(p.line, format, args...)
+}
+
+func (p *tomlParser) skipWS() {
+	for p.pos < len(p.src) && (p.src[p.pos] == ' ' || p.src[p.pos] == '\t') {
+		p.pos++
+	}
+}
+
+// comment skips a comment up to the end of the line.
+func (p *tomlParser) comment() error {
+	if p.pos >= len(p.src) || p.src[p.pos] != '#' {
+		return nil
+	}
+	for p.pos < len(p.src) && p.src[p.pos] != '\n' {
+		c := p.src[p.pos]
+		if (c < 0x20 && c != '\t' && c != '\r') || c == 0x7f {
+			return p.errorf("control character in comment")
+		}
+		p.pos++
+	}
+	return nil
+}
+
+// newline consumes a line break, if there is one.
+func (p *tomlParser) newline() bool {
+	if strings.HasPrefix(p.src[p.pos:], "\r\n") {
+		p.pos += 2
+	} else if p.pos < len(p.src) && p.src[p.pos] == '\n' {
+		p.pos++
+	} else {
+		return false
+	}
+	p.line++
+	return true
+}
+
+func (p *tomlParser) endOfLine() error {
+	p.skipWS()
+	if err := p.comment(); err != nil {
+		return err
+	}
+	if p.pos < len(p.src) && !p.newline() {
+		return p.errorf("expected end of line, found %q", p.rest())
+	}
+	return nil
+}
+
+// rest returns a little of the input for error messages.
+func (p *tomlParser) rest() string {
+	s := p.src[p.pos:]
+	if i := strings.IndexAny(s, "\r\n"); i >= 0 {
+		s = s[:i]
+	}
+	if len(s) > 20 {
+		s = s[:20] + "..."
+	}
+	return s
+}
+
+func isBareKeyChar(c byte) bool {
+	return c >= 'A' && c <= 'Z' || c >= 'a' && c <= 'z' || c >= '0' && c <= '9' || c == '_' || c == '-'
+}
+
+// key parses a possibly dotted key.
+func (p *tomlParser) key() ([]string, error) {
+	var keys []string
+	for {
+		p.skipWS()
+		if p.pos >= len(p.src) {
+			return nil, p.errorf("expected a key")
+		}
+		switch c := p.src[p.pos]; {
+		case c == '"':
+			if strings.HasPrefix(p.src[p.pos:], `"""`) {
+				return nil, p.errorf("a key cannot be a multi-line string")
+			}
+			s, err := p.basicString()
+			if err != nil {
+				return nil, err
+			}
+			keys = append(keys, s)
+		case c == '\'':
+			if strings.HasPrefix(p.src[p.pos:], `'''`) {
+				return nil, p.errorf("a key cannot be a multi-line string")
+			}
+			s, err := p.literalString()
+			if err != nil {
+				return nil, err
+			}
+			keys = append(keys, s)
+		case isBareKeyChar(c):
+			start := p.pos
+			for p.pos < len(p.src) && isBareKeyChar(p.src[p.pos]) {
+				p.pos++
+			}
+			keys = append(keys, p.src[start:p.pos])
+		default:
+			return nil, p.errorf("expected a key, found %q", p.rest())
+		}
+		p.skipWS()
+		if p.pos >= len(p.src) || p.src[p.pos] != '.' {
+			return keys, nil
+		}
+		p.pos++
+	}
+}
+
+// header parses a [table] or [[array of tables]] line.
+func (p *tomlParser) header() error {
+	array := strings.HasPrefix(p.src[p.pos:], "[[")
+	if array {
+		p.pos += 2
+	} else {
+		p.pos++
+	}
+	keys, err := p.key()
+	if err != nil {
+		return err
+	}
+	if array {
+		if !strings.HasPrefix(p.src[p.pos:], "]]") {
+			return p.errorf("expected ]] after table name")
+		}
+		p.pos += 2
+	} else {
+		if p.pos >= len(p.src) || p.src[p.pos] != ']' {
+			return p.errorf("expected ] after table name")
+		}
+		p.pos++
+	}
+	name := strings.Join(keys, ".")
+
+	t := p.root
+	for _, k := range keys[:len(keys)-1] {
+		if t, err = p.descend(t, k, name); err != nil {
+			return err
+		}
+	}
+	last := keys[len(keys)-1]
+	v, exists := t.Get(last)
+	if array {
+		if !exists {
+			p.aotOf(t)[last] = true
+			v = []interface{}{}
+		} else if !p.aot[t][last] {
+			return p.errorf("cannot define array of tables [[%s]]: key already has a value", name)
+		}
+		nt := NewMap()
+		p.kinds[nt] = headerTable
+		t.Set(last, append(v.([]interface{}), nt))
+		p.cur = nt
+		return nil
+	}
+	if !exists {
+		nt := NewMap()
+		p.kinds[nt] = headerTable
+		t.Set(last, nt)
+		p.cur = nt
+		return nil
+	}
+	nt, ok := v.(*Map)
+	if !ok || p.aot[t][last] {
+		return p.errorf("cannot define table [%s]: key already has a value", name)
+	}
+	if p.kinds[nt] != implicitTable {
+		return p.errorf("table [%s] defined more than once", name)
+	}
+	p.kinds[nt] = headerTable
+	p.cur = nt
+	return nil
+}
+
+func (p *tomlParser) aotOf(t *Map) map[string]bool {
+	if p.aot[t] == nil {
+		p.aot[t] = map[string]bool{}
+	}
+	return p.aot[t]
+}
+
+// descend steps from t into the table named by k on the way to a
+// [header], creating it if needed; for an array of tables that is its
+// last element.
+func (p *tomlParser) descend(t *Map, k, name string) (*Map, error) {
+	v, ok := t.Get(k)
+	if !ok {
+		nt := NewMap()
+		p.kinds[nt] = implicitTable
+		t.Set(k, nt)
+		return nt, nil
+	}
+	switch x := v.(type) {
+	case *Map:
+		if p.kinds[x] == inlineTable {
+			return nil, p.errorf("cannot extend inline table %q with [%s]", k, name)
+		}
+		return x, nil
+	case []interface{}:
+		if p.aot[t][k] {
+			return x[len(x)-1].(*Map), nil
+		}
+		return nil, p.errorf("cannot extend array %q with [%s]", k, name)
+	}
+	return nil, p.errorf("key %q already has a value", k)
+}
+
+// keyValue parses a key = value pair into table t.
+func (p *tomlParser) keyValue(t *Map) error {
+	keys, err := p.key()
+	if err != nil {
+		return err
+	}
+	if p.pos >= len(p.src) || p.src[p.pos] != '=' {
+		return p.errorf("expected = after key %q", strings.Join(keys, "."))
+	}
+	p.pos++
+	p.skipWS()
+	for _, k := range keys[:len(keys)-1] {
+		v, ok := t.Get(k)
+		if !ok {
+			nt := NewMap()
+			p.kinds[nt] = dottedTable
+			t.Set(k, nt)
+			t = nt
+			continue
+		}
+		nt, isMap := v.(*Map)
+		if !isMap || p.kinds[nt] != dottedTable {
+			return p.errorf("cannot add to %q with a dotted key: already defined", k)
+		}
+		t = nt
+	}
+	last := keys[len(keys)-1]
+	if _, dup := t.Get(last); dup {
+		return p.errorf("duplicate key %q", strings.Join(keys, "."))
+	}
+	v, err := p.value()
+	if err != nil {
+		return err
+	}
+	t.Set(last, v)
+	return nil
+}
+
+var (
+	tomlDatetime = regexp.MustCompile(`^(\d{4}-\d{2}-\d{2})?([Tt ]?)(\d{2}:\d{2}:\d{2}(\.\d+)?)?([Zz]|[+-]\d{2}:\d{2})?$`)
+	tomlDec      = regexp.MustCompile(`^[+-]?(0|[1-9](_?[0-9])*)$`)
+	tomlFloat    = regexp.MustCompile(`^[+-]?(0|[1-9](_?[0-9])*)(\.[0-9](_?[0-9])*)?([eE][+-]?[0-9](_?[0-9])*)?$`)
+	tomlHex      = regexp.MustCompile(`^0x[0-9A-Fa-f](_?[0-9A-Fa-f])*$`)
+	tomlOct      = regexp.MustCompile(`^0o[0-7](_?[0-7])*$`)
+	tomlBin      = regexp.MustCompile(`^0b[01](_?[01])*$`)
+)
+
+func (p *tomlParser) value() (interface{}, error) {
+	if p.pos >= len(p.src) {
+		return nil, p.errorf("expected a value")
+	}
+	switch c := p.src[p.pos]; c {
+	case '"':
+		if strings.HasPrefix(p.src[p.pos:], `"""`) {
+			return p.multiString(`"""`)
+		}
+		return p.basicString()
+	case '\'':
+		if strings.HasPrefix(p.src[p.pos:], `'''`) {
+			return p.multiString(`'''`)
+		}
+		return p.literalString()
+	case '[':
+		return p.array()
+	case '{':
+		return p.inline()
+	}
+	start := p.pos
+	for p.pos < len(p.src) {
+		c := p.src[p.pos]
+		// A space separates a date from a time.
+		if c == ' ' && p.pos-start == 10 && p.pos+1 < len(p.src) && p.src[p.pos+1] >= '0' && p.src[p.pos+1] <= '9' && tomlDatetime.MatchString(p.src[start:p.pos]) {
+			p.pos++
+			continue
+		}
+		if !(isBareKeyChar(c) || c == '+' || c == '.' || c == ':') {
+			break
+		}
+		p.pos++
+	}
+	tok := p.src[start:p.pos]
+	if tok == "" {
+		return nil, p.errorf("expected a value, found %q", p.rest())
+	}
+	switch tok {
+	case "true":
+		return true, nil
+	case "false":
+		return false, nil
+	case "inf", "+inf":
+		return math.Inf(1), nil
+	case "-inf":
+		return math.Inf(-1), nil
+	case "nan", "+nan", "-nan":
+		return math.NaN(), nil
+	}
+	clean := strings.ReplaceAll(tok, "_", "")
+	switch {
+	case tomlDec.MatchString(tok):
+		i, err := strconv.ParseInt(clean, 10, 64)
+		if err != nil {
+			return nil, p.errorf("integer %s out of range", tok)
+		}
+		return i, nil
+	case tomlHex.MatchString(tok), tomlOct.MatchString(tok), tomlBin.MatchString(tok):
+		base := map[byte]int{'x': 16, 'o': 8, 'b': 2}[tok[1]]
+		i, err := strconv.ParseInt(clean[2:], base, 64)
+		if err != nil {
+			return nil, p.errorf("integer %s out of range", tok)
+		}
+		return i, nil
+	case tomlFloat.MatchString(tok):
+		f, err := strconv.ParseFloat(clean, 64)
+		if err != nil {
+			return nil, p.errorf("bad float %s", tok)
+		}
+		return f, nil
+	}
+	if d, ok := parseDatetime(tok); ok {
+		return d, nil
+	}
+	return nil, p.errorf("invalid value %q", tok)
+}
+
+// parseDatetime parses the four TOML date and time forms.
+func parseDatetime(s string) (Datetime, bool) {
+	m := tomlDatetime.FindStringSubmatch(s)
+	if m == nil {
+		return Datetime{}, false
+	}
+	date, sep, clock, zone := m[1], m[2], m[3], m[5]
+	var layout string
+	var kind DatetimeKind
+	switch {
+	case date != "" && clock != "" && sep != "":
+		layout, kind = "2006-01-02T15:04:05", LocalDatetime
+		s = date + "T" + clock
+		if zone != "" {
+			layout, kind = layout+"Z07:00", OffsetDatetime
+			s += strings.ToUpper(zone)
+		}
+	case date != "" && clock == "" && sep == "" && zone == "":
+		layout, kind = "2006-01-02", LocalDate
+	case date == "" && clock != "" && sep == "" && zone == "":
+		layout, kind = "15:04:05", LocalTime
+	default:
+		return Datetime{}, false
+	}
+	t, err := time.Parse(layout, s)
+	if err != nil {
+		return Datetime{}, false
+	}
+	return Datetime{Kind: kind, Time: t}, true
+}
+
+// escape decodes the escape sequence at p.pos, just after the backslash.
+func (p *tomlParser) escape(b *strings.Builder) error {
+	if p.pos >= len(p.src) {
+		return p.errorf("unterminated string")
+	}
+	c := p.src[p.pos]
+	p.pos++
+	switch c {
+	case 'b':
+		b.WriteByte('\b')
+	case 't':
+		b.WriteByte('\t')
+	case 'n':
+		b.WriteByte('\n')
+	case 'f':
+		b.WriteByte('\f')
+	case 'r':
+		b.WriteByte('\r')
+	case '"', '\\':
+		b.WriteByte(c)
+	case 'u', 'U':
+		n := 4
+		if c == 'U' {
+			n = 8
+		}
+		if p.pos+n > len(p.src) {
+			return p.errorf("short \\%c escape", c)
+		}
+		r, err := strconv.ParseUint(p.src[p.pos:p.pos+n], 16, 32)
+		if err != nil || !utf8.ValidRune(rune(r)) {
+			return p.errorf("bad \\%c escape %q", c, p.src[p.pos:p.pos+n])
+		}
+		b.WriteRune(rune(r))
+		p.pos += n
+	default:
+		return p.errorf("bad escape \\%c", c)
+	}
+	return nil
+}
+
+func isControl(c byte) bool {
+	return (c < 0x20 && c != '\t') || c == 0x7f
+}
+
+func (p *tomlParser) basicString() (string, error) {
+	p.pos++
+	var b strings.Builder
+	for {
+		if p.pos >= len(p.src) || p.src[p.pos] == '\n' {
+			return "", p.errorf("unterminated string")
+		}
+		c := p.src[p.pos]
+		p.pos++
+		switch {
+		case c == '"':
+			return b.String(), nil
+		case c == '\\':
+			if err := p.escape(&b); err != nil {
+				return "", err
+			}
+		case isControl(c):
+			return "", p.errorf("control character in string")
+		default:
+			b.WriteByte(c)
+		}
+	}
+}
+
+func (p *tomlParser) literalString() (string, error) {
+	p.pos++
+	start := p.pos
+	for p.pos < len(p.src) && p.src[p.pos] != '\'' {
+		if p.src[p.pos] == '\n' {
+			return "", p.errorf("unterminated string")
+		}
+		if isControl(p.src[p.pos]) {
+			return "", p.errorf("control character in string")
+		}
+		p.pos++
+	}
+	if p.pos >= len(p.src) {
+		return "", p.errorf("unterminated string")
+	}
+	p.pos++
+	return p.src[start : p.pos-1], nil
+}
+
+// multiString parses a multi-line string closed by delim.
+func (p *tomlParser) multiString(delim string) (string, error) {
+	start := p.line
+	p.pos += 3
+	p.newline() // a newline straight after the opening quotes is trimmed
+	var b strings.Builder
+	for {
+		if p.pos >= len(p.src) {
+			return "", errorf(start, "unterminated multi-line string")
+		}
+		if strings.HasPrefix(p.src[p.pos:], delim) {
+			// Up to two more quotes belong to the string.
+			n := 3
+			for n < 5 && p.pos+n < len(p.src) && p.src[p.pos+n] == delim[0] {
+				n++
+			}
+			b.WriteString(p.src[p.pos+3 : p.pos+n])
+			p.pos += n
+			return b.String(), nil
+		}
+		if p.newline() {
+			b.WriteByte('\n')
+			continue
+		}
+		c := p.src[p.pos]
+		switch {
+		case c == '\\' && delim == `"""`:
+			// A backslash at the end of a line trims the break and the
+			// white space after it.
+			j := p.pos + 1
+			for j < len(p.src) && (p.src[j] == ' ' || p.src[j] == '\t') {
+				j++
+			}
+			if j < len(p.src) && (p.src[j] == '\n' || p.src[j] == '\r') {
+				p.pos = j
+				for p.pos < len(p.src) {
+					if p.newline() {
+						continue
+					}
+					if p.src[p.pos] == ' ' || p.src[p.pos] == '\t' {
+						p.pos++
+						continue
+					}
+					break
+				}
+				continue
+			}
+			p.pos++
+			if err := p.escape(&b); err != nil {
+				return "", err
+			}
+		case isControl(c) && c != '\r':
+			return "", p.errorf("control character in string")
+		default:
+			b.WriteByte(c)
+			p.pos++
+		}
+	}
+}
+
+// skipArrayWS skips white space, newlines and comments inside an array.
+func (p *tomlParser) skipArrayWS() error {
+	for {
+		p.skipWS()
+		if err := p.comment(); err != nil {
+			return err
+		}
+		if !p.newline() {
+			return nil
+		}
+	}
+}
+
+func (p *tomlParser) array() (interface{}, error) {
+	start := p.line
+	p.pos++
+	a := []interface{}{}
+	for {
+		if err := p.skipArrayWS(); err != nil {
+			return nil, err
+		}
+		if p.pos >= len(p.src) {
+			return nil, errorf(start, "unterminated array")
+		}
+		if p.src[p.pos] == ']' {
+			p.pos++
+			return a, nil
+		}
+		v, err := p.value()
+		if err != nil {
+			return nil, err
+		}
+		a = append(a, v)
+		if err := p.skipArrayWS(); err != nil {
+			return nil, err
+		}
+		if p.pos < len(p.src) && p.src[p.pos] == ',' {
+			p.pos++
+			continue
+		}
+		if p.pos < len(p.src) && p.src[p.pos] == ']' {
+			p.pos++
+			return a, nil
+		}
+		return nil, p.errorf("expected , or ] in array, found %q", p.rest())
+	}
+}
+
+func (p *tomlParser) inline() (interface{}, error) {
+	p.pos++
+	t := NewMap()
+	p.skipWS()
+	if p.pos < len(p.src) && p.src[p.pos] == '}' {
+		p.pos++
+		p.kinds[t] = inlineTable
+		return t, nil
+	}
+	for {
+		if err := p.keyValue(t); err != nil {
+			return nil, err
+		}
+		p.skipWS()
+		if p.pos >= len(p.src) {
+			return nil, p.errorf("unterminated inline table")
+		}
+		switch p.src[p.pos] {
+		case ',':
+			p.pos++
+			continue
+		case '}':
+			p.pos++
+			p.seal(t)
+			return t, nil
+		}
+		return nil, p.errorf("expected , or } in inline table, found %q", p.rest())
+	}
+}
+
+// seal marks an inline table and the tables its dotted keys created as
+// closed.
+func (p *tomlParser) seal(t *Map) {
+	p.kinds[t] = inlineTable
+	for _, k := range t.Keys() {
+		if sub, ok := t.vals[k].(*Map); ok && p.kinds[sub] == dottedTable {
+			p.seal(sub)
+		}
+	}
+}
+
+// TOML writer.
+
+type tomlEncoder struct {
+	w     *bufio.Writer
+	wrote bool
+}
+
+func encodeTOML(w io.Writer, v interface{}, opt Options) error {
+	m, ok := v.(*Map)
+	if !ok {
+		return fmt.Errorf("TOML needs a table at the top level, not %s", typeName(v))
+	}
+	e := &tomlEncoder{w: bufio.NewWriter(w)}
+	if err := e.table(nil, m, false); err != nil {
+		return err
+	}
+	return e.w.Flush()
+}
+
+func typeName(v interface{}) string {
+	switch v.(type) {
+	case nil:
+		return "null"
+	case *Map:
+		return "an object"
+	case []interface{}:
+		return "an array"
+	case string:
+		return "a string"
+	}
+	return "a scalar"
+}
+
+// isTableArray reports whether a is a non-empty array of tables.
+func isTableArray(v interface{}) bool {
+	a, ok := v.([]interface{})
+	if !ok || len(a) == 0 {
+		return false
+	}
+	for _, e := range a {
+		if _, ok := e.(*Map); !ok {
+			return false
+		}
+	}
+	return true
+}
+
+func tomlKey(k string) string {
+	if k == "" {
+		return `""`
+	}
+	for i := 0; i < len(k); i++ {
+		if !isBareKeyChar(k[i]) {
+			return tomlQuote(k)
+		}
+	}
+	return k
+}
+
+func tomlPath(path []string) string {
+	parts := make([]string, len(path))
+	for i, k := range path {
+		parts[i] = tomlKey(k)
+	}
+	return strings.Join(parts, ".")
+}
+
+func tomlQuote(s string) string {
+	var b strings.Builder
+	b.WriteByte('"')
+	for _, r := range s {
+		switch r {
+		case '"':
+			b.WriteString(`\"`)
+		case '\\':
+			b.WriteString(`\\`)
+		case '\n':
+			b.WriteString(`\n`)
+		case '\t':
+			b.WriteString(`\t`)
+		case '\r':
+			b.WriteString(`\r`)
+		default:
+			if r < 0x20 || r == 0x7f {
+				fmt.Fprintf(&b, `\u%04X`, r)
+			} else {
+				b.WriteRune(r)
+			}
+		}
+	}
+	b.WriteByte('"')
+	return b.String()
+}
+
+// inlineValue formats a value on one line.
+func (e *tomlEncoder) inlineValue(path []string, v interface{}) (string, error) {
+	switch x := v.(type) {
+	case nil:
+		return "", fmt.Errorf("TOML cannot represent null (at %s)", tomlPath(path))
+	case bool:
+		return strconv.FormatBool(x), nil
+	case int64:
+		return strconv.FormatInt(x, 10), nil
+	case float64:
+		switch {
+		case math.IsInf(x, 1):
+			return "inf", nil
+		case math.IsInf(x, -1):
+			return "-inf", nil
+		case math.IsNaN(x):
+			return "nan", nil
+		}
+		return FormatFloat(x), nil
+	case string:
+		return tomlQuote(x), nil
+	case Datetime:
+		return x.String(), nil
+	case []interface{}:
+		parts := make([]string, len(x))
+		for i, el := range x {
+			s, err := e.inlineValue(append(path, strconv.Itoa(i)), el)
+			if err != nil {
+				return "", err
+			}
+			parts[i] = s
+		}
+		return "[" + strings.Join(parts, ", ") + "]", nil
+	case *Map:
+		parts := make([]string, 0, x.Len())
+		for _, k := range x.keys {
+			s, err := e.inlineValue(append(path, k), x.vals[k])
+			if err != nil {
+				return "", err
+			}
+			parts = append(parts, tomlKey(k)+" = "+s)
+		}
+		if len(parts) == 0 {
+			return "{}", nil
+		}
+		return "{ " + strings.Join(parts, ", ") + " }", nil
+	}
+	return "", fmt.Errorf("cannot encode %T", v)
+}
+
+// table writes the members of m under the header for path: plain values
+// first, then sub-tables, then arrays of tables.
+func (e *tomlEncoder) table(path []string, m *Map, isArray bool) error {
+	var subs, arrays []string
+	var body []string
+	for _, k := range m.keys {
+		v := m.vals[k]
+		if _, ok := v.(*Map); ok {
+			subs = append(subs, k)
+			continue
+		}
+		if isTableArray(v) {
+			arrays = append(arrays, k)
+			continue
+		}
+		s, err := e.inlineValue(append(path[:len(path):len(path)], k), v)
+		if err != nil {
+			return err
+		}
+		body = append(body, tomlKey(k)+" = "+s)
+	}
+	// A table that holds only sub-tables needs no header of its own.
+	if len(path) > 0 && (isArray || len(body) > 0 || len(subs)+len(arrays) == 0) {
+		if e.wrote {
+			e.w.WriteString("\n")
+		}
+		if isArray {
+			e.w.WriteString("[[" + tomlPath(path) + "]]\n")
+		} else {
+			e.w.WriteString("[" + tomlPath(path) + "]\n")
+		}
+		e.wrote = true
+	}
+	for _, l := range body {
+		e.w.WriteString(l + "\n")
+		e.wrote = true
+	}
+	for _, k := range subs {
+		if err := e.table(append(path[:len(path):len(path)], k), m.vals[k].(*Map), false); err != nil {
+			return err
+		}
+	}
+	for _, k := range arrays {
+		for _, el := range m.vals[k].([]interface{}) {
+			if err := e.table(append(path[:len(path):len(path)], k), el.(*Map), true); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,194 @@
+// Package config reads and writes configuration files in several formats
+// through one value model, so any supported format converts to any other.
+//
+// Decoded documents are built from:
+//
+//	*Map                    objects, keeping key order
+//	[]interface{}           arrays
+//	string, bool, nil
+//	int64, float64          numbers
+//	Datetime                TOML dates and times
+//
+// Each format registers itself with Register; Lookup and ForFile find one
+// by name or file extension.
+package config
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Map is an object whose keys keep the order they were set in.
+type Map struct {
+	keys []string
+	vals map[string]interface{}
+}
+
+// NewMap returns an empty Map.
+func NewMap() *Map {
+	return &Map{vals: map[string]interface{}{}}
+}
+
+// Len returns the number of keys.
+func (m *Map) Len() int { return len(m.keys) }
+
+// Keys returns the keys in order. The slice must not be modified.
+func (m *Map) Keys() []string { return m.keys }
+
+// Get returns the value of key k.
+func (m *Map) Get(k string) (interface{}, bool) {
+	v, ok := m.vals[k]
+	return v, ok
+}
+
+// Set sets key k, appending it if it is new.
+func (m *Map) Set(k string, v interface{}) {
+	if _, ok := m.vals[k]; !ok {
+		m.keys = append(m.keys, k)
+	}
+	m.vals[k] = v
+}
+
+// Delete removes key k.
+func (m *Map) Delete(k string) {
+	if _, ok := m.vals[k]; !ok {
+		return
+	}
+	delete(m.vals, k)
+	for i, key := range m.keys {
+		if key == k {
+			m.keys = append(m.keys[:i], m.keys[i+1:]...)
+			break
+		}
+	}
+}
+
+// SortKeys orders the keys of m, and of every Map inside it, by name.
+func SortKeys(v interface{}) {
+	switch x := v.(type) {
+	case *Map:
+		sort.Strings(x.keys)
+		for _, e := range x.vals {
+			SortKeys(e)
+		}
+	case []interface{}:
+		for _, e := range x {
+			SortKeys(e)
+		}
+	}
+}
+
+// DatetimeKind is one of TOML's four date and time types.
+type DatetimeKind int
+
+const (
+	OffsetDatetime DatetimeKind = iota // 1979-05-27T07:32:00Z
+	LocalDatetime                      // 1979-05-27T07:32:00
+	LocalDate                          // 1979-05-27
+	LocalTime                          // 07:32:00
+)
+
+// Datetime is a date, time or both, with or without a UTC offset.
+type Datetime struct {
+	Kind DatetimeKind
+	Time time.Time
+}
+
+// String formats d in RFC 3339 form.
+func (d Datetime) String() string {
+	switch d.Kind {
+	case LocalDatetime:
+		return d.Time.Format("2006-01-02T15:04:05.999999999")
+	case LocalDate:
+		return d.Time.Format("2006-01-02")
+	case LocalTime:
+		return d.Time.Format("15:04:05.999999999")
+	}
+	return d.Time.Format(time.RFC3339Nano)
+}
+
+// Error is a syntax or structure error in an input document.
+type Error struct {
+	Line int // 1-based; 0 if unknown
+	Col  int // 1-based; 0 if unknown
+	Msg  string
+}
+
+func (e *Error) Error() string {
+	switch {
+	case e.Line == 0:
+		return e.Msg
+	case e.Col == 0:
+		return fmt.Sprintf("line %d: %s", e.Line, e.Msg)
+	}
+	return fmt.Sprintf("line %d, column %d: %s", e.Line, e.Col, e.Msg)
+}
+
+// errorf returns an *Error at line.
+func errorf(line int, format string, args ...interface{}) error {
+	return &Error{Line: line, Msg: fmt.Sprintf(format, args...)}
+}
+
+// FormatFloat formats f so that it reads back as a float: integral values
+// keep a ".0".
+func FormatFloat(f float64) string {
+	s := strconv.FormatFloat(f, 'g', -1, 64)
+	if !math.IsInf(f, 0) && !math.IsNaN(f) && !strings.ContainsAny(s, ".e") {
+		s += ".0"
+	}
+	return s
+}
+
+// Text returns the plain text of a scalar, as a flat format such as .env
+// stores it. Composite values have no plain text.
+func Text(v interface{}) (string, bool) {
+	switch x := v.(type) {
+	case nil:
+		return "", true
+	case string:
+		return x, true
+	case bool:
+		return strconv.FormatBool(x), true
+	case int64:
+		return strconv.FormatInt(x, 10), true
+	case float64:
+		if math.IsInf(x, 0) || math.IsNaN(x) {
+			return strconv.FormatFloat(x, 'g', -1, 64), true
+		}
+		return FormatFloat(x), true
+	case Datetime:
+		return x.String(), true
+	}
+	return "", false
+}
+
+// Flatten calls f for every scalar in v with its path joined by sep;
+// array elements are named by index. Empty objects and arrays are skipped.
+func Flatten(v interface{}, sep string, f func(key string, v interface{})) {
+	flatten("", v, sep, f)
+}
+
+func flatten(prefix string, v interface{}, sep string, f func(string, interface{})) {
+	join := func(k string) string {
+		if prefix == "" {
+			return k
+		}
+		return prefix + sep + k
+	}
+	switch x := v.(type) {
+	case *Map:
+		for _, k := range x.keys {
+			flatten(join(k), x.vals[k], sep, f)
+		}
+	case []interface{}:
+		for i, e := range x {
+			flatten(join(strconv.Itoa(i)), e, sep, f)
+		}
+	default:
+		f(prefix, v)
+	}
+}
@@ -0,0 +1,307 @@
+package config
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"unicode"
+
+	"goutils/internal/xmlutil"
+)
+
+func init() {
+	Register(&Format{Name: "xml", Exts: []string{".xml"}, Decode: decodeXML, Encode: encodeXML})
+}
+
+// XML maps to objects the usual way: the document is an object holding
+// the root element; an element with attributes or children is an object
+// whose attributes are "@name" members, whose text is "#text" and whose
+// repeated children become arrays; any other element is its text.
+// Namespace prefixes are kept in names. Comments and processing
+// instructions are dropped, and internal DTD entities are expanded.
+
+type xmlNode struct {
+	name     string
+	m        *Map
+	text     strings.Builder
+	children bool
+}
+
+func decodeXML(data []byte) (interface{}, error) {
+	dec := xml.NewDecoder(bytes.NewReader(data))
+	dec.Strict = true
+	errAt := func(err error) error {
+		var se *xml.SyntaxError
+		if errors.As(err, &se) {
+			return &Error{Line: se.Line, Msg: se.Msg}
+		}
+		line, col := dec.InputPos()
+		return &Error{Line: line, Col: col, Msg: err.Error()}
+	}
+	var stack []*xmlNode
+	var root interface{}
+	for {
+		tok, err := dec.RawToken()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, errAt(err)
+		}
+		switch t := tok.(type) {
+		case xml.StartElement:
+			if len(stack) == 0 && root != nil {
+				return nil, errAt(fmt.Errorf("more than one root element"))
+			}
+			n := &xmlNode{name: xmlName(t.Name), m: NewMap()}
+			for _, a := range t.Attr {
+				n.m.Set("@"+xmlName(a.Name), a.Value)
+			}
+			if len(stack) > 0 {
+				stack[len(stack)-1].children = true
+			}
+			stack = append(stack, n)
+		case xml.EndElement:
+			if len(stack) == 0 || xmlName(t.Name) != stack[len(stack)-1].name {
+				return nil, errAt(fmt.Errorf("unexpected </%s>", xmlName(t.Name)))
+			}
+			n := stack[len(stack)-1]
+			stack = stack[:len(stack)-1]
+			v := n.value()
+			if len(stack) == 0 {
+				doc := NewMap()
+				doc.Set(n.name, v)
+				root = doc
+				continue
+			}
+			parent := stack[len(stack)-1].m
+			if old, ok := parent.Get(n.name); ok {
+				if a, isArr := old.([]interface{}); isArr {
+					parent.Set(n.name, append(a, v))
+				} else {
+					parent.Set(n.name, []interface{}{old, v})
+				}
+			} else {
+				parent.Set(n.name, v)
+			}
+		case xml.CharData:
+			if len(stack) > 0 {
+				stack[len(stack)-1].text.Write(t)
+			} else if len(bytes.TrimSpace(t)) > 0 {
+				return nil, errAt(fmt.Errorf("text outside the root element"))
+			}
+		case xml.Directive:
+			if strings.HasPrefix(string(t), "DOCTYPE") {
+				ents, err := xmlutil.Entities(string(t))
+				if err != nil {
+					return nil, errAt(err)
+				}
+				dec.Entity = ents
+			}
+		}
+	}
+	if len(stack) > 0 {
+		return nil, errAt(fmt.Errorf("unclosed <%s>", stack[len(stack)-1].name))
+	}
+	if root == nil {
+		return nil, &Error{Msg: "no root element"}
+	}
+	return root, nil
+}
+
+func xmlName(n xml.Name) string {
+	if n.Space != "" {
+		return n.Space + ":" + n.Local
+	}
+	return n.Local
+}
+
+func (n *xmlNode) value() interface{} {
+	text := n.text.String()
+	if n.m.Len() == 0 && !n.children {
+		return strings.TrimSpace(text)
+	}
+	if t := strings.TrimSpace(text); t != "" {
+		n.m.Set("#text", t)
+	}
+	return n.m
+}
+
+// xmlSafeName makes s a valid element or attribute name.
+func xmlSafeName(s string) string {
+	if s == "" {
+		return "_"
+	}
+	var b strings.Builder
+	for i, r := range s {
+		ok := unicode.IsLetter(r) || r == '_' || r == ':' || (i > 0 && (unicode.IsDigit(r) || r == '-' || r == '.'))
+		if !ok {
+			if i == 0 && (unicode.IsDigit(r) || r == '-' || r == '.') {
+				b.WriteByte('_')
+				b.WriteRune(r)
+				continue
+			}
+			r = '_'
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+func xmlEscape(s string, attr bool) string {
+	var b strings.Builder
+	for _, r := range s {
+		switch r {
+		case '&':
+			b.WriteString("&amp;")
+		case '<':
+			b.WriteString("&lt;")
+		case '>':
+			b.WriteString("&gt;")
+		case '"':
+			if attr {
+				b.WriteString("&quot;")
+			} else {
+				b.WriteRune(r)
+			}
+		case '\n', '\t', '\r':
+			if attr || r == '\r' {
+				fmt.Fprintf(&b, "&#x%X;", r)
+			} else {
+				b.WriteRune(r)
+			}
+		default:
+			b.WriteRune(r)
+		}
+	}
+	return b.String()
+}
+
+type xmlEncoder struct {
+	w      *bufio.Writer
+	indent int
+}
+
+func encodeXML(w io.Writer, v interface{}, opt Options) error {
+	e := &xmlEncoder{w: bufio.NewWriter(w), indent: opt.Indent}
+	e.w.WriteString(`<?xml version="1.0" encoding="UTF-8"?>` + "\n")
+	name, body := "root", v
+	if m, ok := v.(*Map); ok && m.Len() == 1 {
+		if _, isArr := m.vals[m.keys[0]].([]interface{}); !isArr {
+			name, body = m.keys[0], m.vals[m.keys[0]]
+		}
+	}
+	if err := e.element(name, body, 0); err != nil {
+		return err
+	}
+	if e.indent == 0 {
+		e.w.WriteString("\n")
+	}
+	return e.w.Flush()
+}
+
+func (e *xmlEncoder) pad(depth int) {
+	if e.indent > 0 {
+		e.w.WriteString(strings.Repeat(" ", e.indent*depth))
+	}
+}
+
+func (e *xmlEncoder) nl() {
+	if e.indent > 0 {
+		e.w.WriteString("\n")
+	}
+}
+
+func (e *xmlEncoder) element(name string, v interface{}, depth int) error {
+	name = xmlSafeName(name)
+	e.pad(depth)
+	switch x := v.(type) {
+	case nil:
+		e.w.WriteString("<" + name + "/>")
+		e.nl()
+		return nil
+	case *Map:
+		e.w.WriteString("<" + name)
+		var children []string
+		text := ""
+		for _, k := range x.keys {
+			val := x.vals[k]
+			switch {
+			case strings.HasPrefix(k, "@"):
+				s, ok := Text(val)
+				if !ok {
+					return fmt.Errorf("attribute %s of <%s> must be a scalar", k, name)
+				}
+				e.w.WriteString(" " + xmlSafeName(k[1:]) + `="` + xmlEscape(s, true) + `"`)
+			case k == "#text":
+				s, ok := Text(val)
+				if !ok {
+					return fmt.Errorf("#text of <%s> must be a scalar", name)
+				}
+				text = s
+			default:
+				children = append(children, k)
+			}
+		}
+		if len(children) == 0 {
+			if text == "" {
+				e.w.WriteString("/>")
+			} else {
+				e.w.WriteString(">" + xmlEscape(text, false) + "</" + name + ">")
+			}
+			e.nl()
+			return nil
+		}
+		e.w.WriteString(">")
+		e.nl()
+		if text != "" {
+			e.pad(depth + 1)
+			e.w.WriteString(xmlEscape(text, false))
+			e.nl()
+		}
+		for _, k := range children {
+			if a, ok := x.vals[k].([]interface{}); ok {
+				for _, el := range a {
+					if err := e.element(k, el, depth+1); err != nil {
+						return err
+					}
+				}
+				continue
+			}
+			if err := e.element(k, x.vals[k], depth+1); err != nil {
+				return err
+			}
+		}
+		e.pad(depth)
+		e.w.WriteString("</" + name + ">")
+		e.nl()
+		return nil
+	case []interface{}:
+		e.w.WriteString("<" + name + ">")
+		e.nl()
+		for _, el := range x {
+			if err := e.element("item", el, depth+1); err != nil {
+				return err
+			}
+		}
+		e.pad(depth)
+		e.w.WriteString("</" + name + ">")
+		e.nl()
+		return nil
+	}
+	s, ok := Text(v)
+	if !ok {
+		return fmt.Errorf("cannot encode %T", v)
+	}
+	if s == "" {
+		e.w.WriteString("<" + name + "/>")
+	} else {
+		e.w.WriteString("<" + name + ">" + xmlEscape(s, false) + "</" + name + ">")
+	}
+	e.nl()
+	return nil
+}
@@ -0,0 +1,1145 @@
+package config
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+func init() {
+	Register(&Format{Name: "yaml", Exts: []string{".yaml", ".yml"}, Decode: decodeYAML, Encode: encodeYAML})
+}
+
+// The YAML reader handles one document of block and flow collections,
+// plain, quoted and block scalars, anchors, aliases and << merge keys,
+// resolving plain scalars with the YAML 1.2 core schema. Complex (?) keys
+// are not supported.
+
+type yamlParser struct {
+	lines   []string
+	n       int // current line
+	anchors map[string]interface{}
+}
+
+func decodeYAML(data []byte) (interface{}, error) {
+	text := strings.TrimPrefix(string(data), "\ufeff")
+	text = strings.ReplaceAll(text, "\r\n", "\n")
+	p := &yamlParser{lines: strings.Split(text, "\n"), anchors: map[string]interface{}{}}
+	p.skipBlank()
+	for p.n < len(p.lines) && strings.HasPrefix(p.lines[p.n], "%") {
+		p.n++
+		p.skipBlank()
+	}
+	if p.n < len(p.lines) && isDocMarker(p.lines[p.n], "---") {
+		p.lines[p.n] = "   " + p.lines[p.n][3:]
+	}
+	v, err := p.parseBlock(0)
+	if err != nil {
+		return nil, err
+	}
+	p.skipBlank()
+	if p.n < len(p.lines) && isDocMarker(p.lines[p.n], "...") {
+		p.n++
+		p.skipBlank()
+	}
+	if p.n < len(p.lines) {
+		if isDocMarker(p.lines[p.n], "---") {
+			return nil, p.errorf("more than one document")
+		}
+		return nil, p.errorf("unexpected %q", strings.TrimSpace(p.lines[p.n]))
+	}
+	return v, nil
+}
+
+func (p *yamlParser) errorf(format string, args ...interface{}) error {
+	return errorf(p.n+1, format, args...)
+}
+
+func isDocMarker(line, m string) bool {
+	return line == m || strings.HasPrefix(line, m+" ") || strings.HasPrefix(line, m+"\t")
+}
+
+func indentOf(line string) int {
+	n := 0
+	for n < len(line) && line[n] == ' ' {
+		n++
+	}
+	return n
+}
+
+// isBlank reports whether a line holds nothing but white space or a comment.
+func isBlank(line string) bool {
+	t := strings.TrimLeft(line, " \t")
+	return t == "" || t[0] == '#'
+}
+
+func (p *yamlParser) skipBlank() {
+	for p.n < len(p.lines) && isBlank(p.lines[p.n]) {
+		p.n++
+	}
+}
+
+// atEnd reports whether the document has no more lines.
+func (p *yamlParser) atEnd() bool {
+	return p.n >= len(p.lines) || isDocMarker(p.lines[p.n], "---") || isDocMarker(p.lines[p.n], "...")
+}
+
+func isSeqEntry(content string) bool {
+	return content == "-" || strings.HasPrefix(content, "- ") || strings.HasPrefix(content, "-\t")
+}
+
+// props splits the anchor and tag off the front of s.
+func props(s string) (anchor, tag, rest string) {
+	rest = strings.TrimLeft(s, " \t")
+	for len(rest) > 0 && (rest[0] == '&' || rest[0] == '!') {
+		end := strings.IndexAny(rest, " \t")
+		if end < 0 {
+			end = len(rest)
+		}
+		if rest[0] == '&' {
+			anchor = rest[1:end]
+		} else {
+			tag = rest[:end]
+		}
+		rest = strings.TrimLeft(rest[end:], " \t")
+	}
+	return anchor, tag, rest
+}
+
+// applyTag converts v as the standard tags ask; other tags are ignored.
+func applyTag(tag string, v interface{}) interface{} {
+	switch tag {
+	case "!!str":
+		if s, ok := Text(v); ok {
+			return s
+		}
+	case "!!int", "!!float", "!!bool", "!!null":
+		if s, ok := v.(string); ok {
+			return resolvePlain(s)
+		}
+	}
+	return v
+}
+
+// splitKey finds a mapping key at the start of content and returns it with
+// the offset just past its colon.
+func (p *yamlParser) splitKey(content string) (key string, after int, ok bool, err error) {
+	if content == "" || isSeqEntry(content) || content[0] == '[' || content[0] == '{' || content[0] == '#' {
+		return "", 0, false, nil
+	}
+	if strings.HasPrefix(content, "? ") || content == "?" {
+		return "", 0, false, p.errorf("complex mapping keys are not supported")
+	}
+	if content[0] == '"' || content[0] == '\'' {
+		end := quoteEnd(content)
+		if end < 0 {
+			return "", 0, false, nil
+		}
+		j := end + 1
+		for j < len(content) && (content[j] == ' ' || content[j] == '\t') {
+			j++
+		}
+		if j < len(content) && content[j] == ':' && (j+1 == len(content) || content[j+1] == ' ' || content[j+1] == '\t') {
+			k, err := unquote(content[:end+1])
+			if err != nil {
+				return "", 0, false, p.errorf("%v", err)
+			}
+			return k, j + 1, true, nil
+		}
+		return "", 0, false, nil
+	}
+	for i := 0; i < len(content); i++ {
+		switch content[i] {
+		case '#':
+			if i > 0 && (content[i-1] == ' ' || content[i-1] == '\t') {
+				return "", 0, false, nil
+			}
+		case ':':
+			if i+1 == len(content) || content[i+1] == ' ' || content[i+1] == '\t' {
+				return strings.TrimRight(content[:i], " \t"), i + 1, true, nil
+			}
+		}
+	}
+	return "", 0, false, nil
+}
+
+// parseBlock parses the node starting at the next non-blank line, if that
+// line is indented at least minIndent.
+func (p *yamlParser) parseBlock(minIndent int) (interface{}, error) {
+	p.skipBlank()
+	if p.atEnd() {
+		return nil, nil
+	}
+	line := p.lines[p.n]
+	ind := indentOf(line)
+	if ind < minIndent {
+		return nil, nil
+	}
+	if line[ind] == '\t' {
+		return nil, p.errorf("tab in indentation")
+	}
+	content := line[ind:]
+	if content[0] == '&' || content[0] == '!' {
+		anchor, tag, rest := props(content)
+		var v interface{}
+		var err error
+		if isBlank(rest) {
+			p.n++
+			v, err = p.parseBlock(minIndent)
+		} else {
+			col := len(line) - len(rest)
+			p.lines[p.n] = strings.Repeat(" ", col) + rest
+			v, err = p.parseBlock(col)
+		}
+		if err != nil {
+			return nil, err
+		}
+		v = applyTag(tag, v)
+		if anchor != "" {
+			p.anchors[anchor] = v
+		}
+		return v, nil
+	}
+	if isSeqEntry(content) {
+		return p.parseSeq(ind)
+	}
+	if _, _, ok, err := p.splitKey(content); err != nil {
+		return nil, err
+	} else if ok {
+		return p.parseMap(ind)
+	}
+	if content[0] == '|' || content[0] == '>' {
+		return p.blockScalar(content, minIndent-1)
+	}
+	return p.parseInline(ind, minIndent)
+}
+
+func (p *yamlParser) parseMap(ind int) (interface{}, error) {
+	m := NewMap()
+	for {
+		p.skipBlank()
+		if p.atEnd() {
+			break
+		}
+		line := p.lines[p.n]
+		li := indentOf(line)
+		if li < ind {
+			break
+		}
+		if li > ind {
+			return nil, p.errorf("bad indentation of a mapping entry")
+		}
+		content := line[ind:]
+		key, after, ok, err := p.splitKey(content)
+		if err != nil {
+			return nil, err
+		}
+		if !ok {
+			if isSeqEntry(content) {
+				return nil, p.errorf("sequence entry where a mapping key was expected")
+			}
+			return nil, p.errorf("expected a mapping key, found %q", strings.TrimSpace(content))
+		}
+		if _, dup := m.Get(key); dup && key != "<<" {
+			return nil, p.errorf("duplicate key %q", key)
+		}
+		v, err := p.parseValue(ind, ind+after)
+		if err != nil {
+			return nil, err
+		}
+		if key == "<<" {
+			if err := p.merge(m, v); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		m.Set(key, v)
+	}
+	return m, nil
+}
+
+// merge applies a << merge key: members of v (a mapping or a sequence of
+// mappings) that m does not already have.
+func (p *yamlParser) merge(m *Map, v interface{}) error {
+	srcs, ok := v.([]interface{})
+	if !ok {
+		srcs = []interface{}{v}
+	}
+	for _, s := range srcs {
+		sm, ok := s.(*Map)
+		if !ok {
+			return errorf(p.n, "<< needs a mapping or a sequence of mappings")
+		}
+		for _, k := range sm.Keys() {
+			if _, has := m.Get(k); !has {
+				v, _ := sm.Get(k)
+				m.Set(k, v)
+			}
+		}
+	}
+	return nil
+}
+
+// parseValue parses the value of a mapping entry at indent ind, whose
+// text starts at column col.
+func (p *yamlParser) parseValue(ind, col int) (interface{}, error) {
+	line := p.lines[p.n]
+	anchor, tag, rest := props(line[col:])
+	var v interface{}
+	var err error
+	switch {
+	case isBlank(rest):
+		p.n++
+		p.skipBlank()
+		if !p.atEnd() {
+			next := p.lines[p.n]
+			ni := indentOf(next)
+			if ni > ind {
+				v, err = p.parseBlock(ind + 1)
+			} else if ni == ind && isSeqEntry(next[ni:]) {
+				v, err = p.parseSeq(ni)
+			}
+		}
+	case rest[0] == '|' || rest[0] == '>':
+		v, err = p.blockScalar(rest, ind)
+	default:
+		v, err = p.parseInline(len(line)-len(rest), ind+1)
+	}
+	if err != nil {
+		return nil, err
+	}
+	v = applyTag(tag, v)
+	if anchor != "" {
+		p.anchors[anchor] = v
+	}
+	return v, nil
+}
+
+func (p *yamlParser) parseSeq(ind int) (interface{}, error) {
+	a := []interface{}{}
+	for {
+		p.skipBlank()
+		if p.atEnd() {
+			break
+		}
+		line := p.lines[p.n]
+		li := indentOf(line)
+		if li < ind {
+			break
+		}
+		if li > ind {
+			return nil, p.errorf("bad indentation of a sequence entry")
+		}
+		content := line[ind:]
+		if !isSeqEntry(content) {
+			break
+		}
+		rest := strings.TrimLeft(content[1:], " \t")
+		var v interface{}
+		var err error
+		if isBlank(rest) {
+			p.n++
+			p.skipBlank()
+			if !p.atEnd() && indentOf(p.lines[p.n]) > ind {
+				v, err = p.parseBlock(ind + 1)
+			}
+		} else {
+			col := len(line) - len(rest)
+			p.lines[p.n] = strings.Repeat(" ", col) + rest
+			v, err = p.parseBlock(col)
+		}
+		if err != nil {
+			return nil, err
+		}
+		a = append(a, v)
+	}
+	return a, nil
+}
+
+// parseInline parses a scalar, alias or flow collection starting at column
+// col; plain scalars continue onto following lines indented at least
+// minIndent.
+func (p *yamlParser) parseInline(col, minIndent int) (interface{}, error) {
+	s := p.lines[p.n][col:]
+	switch s[0] {
+	case '[', '{':
+		return p.flow(col)
+	case '"', '\'':
+		return p.quoted(col)
+	case '*':
+		end := strings.IndexAny(s, " \t")
+		if end < 0 {
+			end = len(s)
+		}
+		v, ok := p.anchors[s[1:end]]
+		if !ok {
+			return nil, p.errorf("unknown alias %q", s[1:end])
+		}
+		if !isBlank(s[end:]) {
+			return nil, p.errorf("unexpected %q after alias", strings.TrimSpace(s[end:]))
+		}
+		p.n++
+		return v, nil
+	case '@', '`', '%':
+		return nil, p.errorf("a plain scalar cannot start with %q", s[0])
+	}
+	text := plainText(s)
+	p.n++
+	blank := 0
+	for p.n < len(p.lines) {
+		l := p.lines[p.n]
+		t := strings.TrimSpace(l)
+		if t == "" {
+			blank++
+			p.n++
+			continue
+		}
+		if indentOf(l) < minIndent || t[0] == '#' || p.atEnd() {
+			break
+		}
+		if _, _, ok, _ := p.splitKey(t); ok || isSeqEntry(t) {
+			break
+		}
+		if blank > 0 {
+			text += strings.Repeat("\n", blank)
+		} else {
+			text += " "
+		}
+		text += plainText(t)
+		blank = 0
+		p.n++
+	}
+	return resolvePlain(text), nil
+}
+
+// plainText cuts a comment off a plain scalar.
+func plainText(s string) string {
+	for i := 1; i < len(s); i++ {
+		if s[i] == '#' && (s[i-1] == ' ' || s[i-1] == '\t') {
+			s = s[:i]
+			break
+		}
+	}
+	return strings.TrimRight(s, " \t")
+}
+
+// quoteEnd returns the index of the quote closing the string at the start
+// of s, or -1.
+func quoteEnd(s string) int {
+	q := s[0]
+	for i := 1; i < len(s); i++ {
+		switch {
+		case q == '"' && s[i] == '\\':
+			i++
+		case s[i] == q:
+			if q == '\'' && i+1 < len(s) && s[i+1] == '\'' {
+				i++
+				continue
+			}
+			return i
+		}
+	}
+	return -1
+}
+
+// unquote decodes a complete single- or double-quoted scalar.
+func unquote(s string) (string, error) {
+	body := s[1 : len(s)-1]
+	if s[0] == '\'' {
+		return strings.ReplaceAll(body, "''", "'"), nil
+	}
+	var b strings.Builder
+	for i := 0; i < len(body); i++ {
+		c := body[i]
+		if c != '\\' {
+			b.WriteByte(c)
+			continue
+		}
+		i++
+		if i >= len(body) {
+			return "", fmt.Errorf("bad escape at end of string")
+		}
+		hex := 0
+		switch body[i] {
+		case '0':
+			b.WriteByte(0)
+		case 'a':
+			b.WriteByte('\a')
+		case 'b':
+			b.WriteByte('\b')
+		case 't', '\t':
+			b.WriteByte('\t')
+		case 'n':
+			b.WriteByte('\n')
+		case 'v':
+			b.WriteByte('\v')
+		case 'f':
+			b.WriteByte('\f')
+		case 'r':
+			b.WriteByte('\r')
+		case 'e':
+			b.WriteByte(0x1b)
+		case ' ', '"', '/', '\\':
+			b.WriteByte(body[i])
+		case 'N':
+			b.WriteString("\u0085")
+		case '_':
+			b.WriteString("\u00a0")
+		case 'L':
+			b.WriteString("\u2028")
+		case 'P':
+			b.WriteString("\u2029")
+		case 'x':
+			hex = 2
+		case 'u':
+			hex = 4
+		case 'U':
+			hex = 8
+		default:
+			return "", fmt.Errorf("bad escape \\%c", body[i])
+		}
+		if hex > 0 {
+			if i+hex >= len(body)+1 || i+1+hex > len(body) {
+				return "", fmt.Errorf("short \\%c escape", body[i])
+			}
+			r, err := strconv.ParseUint(body[i+1:i+1+hex], 16, 32)
+			if err != nil {
+				return "", fmt.Errorf("bad \\%c escape", body[i])
+			}
+			b.WriteRune(rune(r))
+			i += hex
+		}
+	}
+	return b.String(), nil
+}
+
+// quoted parses a quoted scalar starting at column col, folding line
+// breaks inside it.
+func (p *yamlParser) quoted(col int) (interface{}, error) {
+	start := p.n
+	text := p.lines[p.n][col:]
+	q := text[0]
+	for {
+		if end := quoteEnd(text); end >= 0 {
+			s, err := unquote(text[:end+1])
+			if err != nil {
+				return nil, errorf(start+1, "%v", err)
+			}
+			if !isBlank(text[end+1:]) {
+				return nil, p.errorf("unexpected %q after quoted string", strings.TrimSpace(text[end+1:]))
+			}
+			p.n++
+			return s, nil
+		}
+		p.n++
+		if p.n >= len(p.lines) {
+			return nil, errorf(start+1, "unterminated quoted string")
+		}
+		text = strings.TrimRight(text, " \t")
+		if q == '"' && strings.HasSuffix(text, `\`) && (len(text)-len(strings.TrimRight(text, `\`)))%2 == 1 {
+			text = text[:len(text)-1] + strings.TrimLeft(p.lines[p.n], " \t")
+			continue
+		}
+		empties := 0
+		for p.n < len(p.lines)-1 && strings.TrimSpace(p.lines[p.n]) == "" {
+			empties++
+			p.n++
+		}
+		sep := " "
+		if empties > 0 {
+			sep = strings.Repeat("\n", empties)
+		}
+		text += sep + strings.TrimLeft(p.lines[p.n], " \t")
+	}
+}
+
+// blockScalar parses a | or > scalar whose header is the rest of the
+// current line; its content is indented more than parent.
+func (p *yamlParser) blockScalar(header string, parent int) (interface{}, error) {
+	style, chomp, explicit := header[0], byte(0), 0
+	i := 1
+	for ; i < len(header); i++ {
+		c := header[i]
+		if c == '+' || c == '-' {
+			chomp = c
+		} else if c >= '1' && c <= '9' {
+			explicit = int(c - '0')
+		} else {
+			break
+		}
+	}
+	if !isBlank(header[i:]) {
+		return nil, p.errorf("unexpected %q after block scalar header", strings.TrimSpace(header[i:]))
+	}
+	p.n++
+	blockInd := -1
+	if explicit > 0 {
+		blockInd = parent + explicit
+		if parent < 0 {
+			blockInd = explicit - 1
+		}
+	}
+	var lines []string
+	for p.n < len(p.lines) {
+		l := p.lines[p.n]
+		if strings.TrimSpace(l) == "" {
+			if blockInd >= 0 && len(l) > blockInd {
+				lines = append(lines, l[blockInd:])
+			} else {
+				lines = append(lines, "")
+			}
+			p.n++
+			continue
+		}
+		ind := indentOf(l)
+		if blockInd < 0 {
+			if ind <= parent {
+				break
+			}
+			blockInd = ind
+		}
+		if ind < blockInd || (parent < 0 && p.atEnd()) {
+			break
+		}
+		lines = append(lines, l[blockInd:])
+		p.n++
+	}
+	trail := 0
+	for len(lines) > 0 && strings.TrimSpace(lines[len(lines)-1]) == "" {
+		trail++
+		lines = lines[:len(lines)-1]
+	}
+	var body string
+	if style == '|' {
+		body = strings.Join(lines, "\n")
+	} else {
+		body = fold(lines)
+	}
+	if len(lines) == 0 {
+		if chomp == '+' {
+			return strings.Repeat("\n", trail), nil
+		}
+		return "", nil
+	}
+	switch chomp {
+	case '-':
+	case '+':
+		body += strings.Repeat("\n", trail+1)
+	default:
+		body += "\n"
+	}
+	return body, nil
+}
+
+// fold joins the lines of a > scalar: a single line break between two
+// plain lines becomes a space, while more-indented lines and blank lines
+// keep their breaks.
+func fold(lines []string) string {
+	var b strings.Builder
+	prevMore, first, empty := false, true, 0
+	for _, l := range lines {
+		if strings.TrimSpace(l) == "" && !strings.HasPrefix(l, " ") {
+			empty++
+			continue
+		}
+		more := l[0] == ' ' || l[0] == '\t'
+		switch {
+		case first:
+			b.WriteString(strings.Repeat("\n", empty))
+		case empty == 0 && !more && !prevMore:
+			b.WriteByte(' ')
+		case !more && !prevMore:
+			b.WriteString(strings.Repeat("\n", empty))
+		default:
+			b.WriteString(strings.Repeat("\n", empty+1))
+		}
+		b.WriteString(l)
+		first, prevMore, empty = false, more, 0
+	}
+	return b.String()
+}
+
+// flow gathers a flow collection starting at column col, which may span
+// lines, and parses it.
+func (p *yamlParser) flow(col int) (interface{}, error) {
+	start := p.n
+	var buf strings.Builder
+	depth, inQ, prev := 0, byte(0), byte('[')
+	line := p.lines[p.n][col:]
+	for {
+	scan:
+		for i := 0; i < len(line); i++ {
+			c := line[i]
+			if inQ != 0 {
+				buf.WriteByte(c)
+				switch {
+				case inQ == '"' && c == '\\' && i+1 < len(line):
+					i++
+					buf.WriteByte(line[i])
+				case c == inQ && inQ == '\'' && i+1 < len(line) && line[i+1] == '\'':
+					i++
+					buf.WriteByte('\'')
+				case c == inQ:
+					inQ, prev = 0, c
+				}
+				continue
+			}
+			switch c {
+			case '#':
+				if i == 0 || line[i-1] == ' ' || line[i-1] == '\t' {
+					break scan
+				}
+			case '"', '\'':
+				if strings.IndexByte("[{,:", prev) >= 0 {
+					inQ = c
+				}
+			case '[', '{':
+				depth++
+			case ']', '}':
+				depth--
+			}
+			buf.WriteByte(c)
+			if c != ' ' && c != '\t' {
+				prev = c
+			}
+			if depth == 0 {
+				if !isBlank(line[i+1:]) {
+					return nil, p.errorf("unexpected %q after flow collection", strings.TrimSpace(line[i+1:]))
+				}
+				fp := &flowParser{s: buf.String(), p: p}
+				v, err := fp.value()
+				if err == nil {
+					fp.skip()
+					if fp.i < len(fp.s) {
+						err = fmt.Errorf("unexpected %q", fp.s[fp.i:])
+					}
+				}
+				if err != nil {
+					return nil, errorf(start+1, "%v", err)
+				}
+				p.n++
+				return v, nil
+			}
+		}
+		p.n++
+		if p.n >= len(p.lines) {
+			return nil, errorf(start+1, "unterminated flow collection")
+		}
+		buf.WriteByte(' ')
+		line = p.lines[p.n]
+	}
+}
+
+type flowParser struct {
+	s string
+	i int
+	p *yamlParser
+}
+
+func (f *flowParser) skip() {
+	for f.i < len(f.s) && (f.s[f.i] == ' ' || f.s[f.i] == '\t') {
+		f.i++
+	}
+}
+
+func (f *flowParser) value() (interface{}, error) {
+	f.skip()
+	if f.i >= len(f.s) {
+		return nil, fmt.Errorf("unexpected end of flow collection")
+	}
+	switch c := f.s[f.i]; c {
+	case '[':
+		f.i++
+		a := []interface{}{}
+		for {
+			f.skip()
+			if f.i < len(f.s) && f.s[f.i] == ']' {
+				f.i++
+				return a, nil
+			}
+			v, err := f.value()
+			if err != nil {
+				return nil, err
+			}
+			f.skip()
+			if f.i < len(f.s) && f.s[f.i] == ':' {
+				// A single-pair mapping inside a sequence.
+				f.i++
+				pv, err := f.pairValue()
+				if err != nil {
+					return nil, err
+				}
+				m := NewMap()
+				k, _ := Text(v)
+				m.Set(k, pv)
+				v = m
+			}
+			a = append(a, v)
+			if err := f.sep(']'); err != nil {
+				return nil, err
+			}
+		}
+	case '{':
+		f.i++
+		m := NewMap()
+		for {
+			f.skip()
+			if f.i < len(f.s) && f.s[f.i] == '}' {
+				f.i++
+				return m, nil
+			}
+			k, err := f.value()
+			if err != nil {
+				return nil, err
+			}
+			key, ok := Text(k)
+			if !ok {
+				return nil, fmt.Errorf("a mapping key must be a scalar")
+			}
+			var v interface{}
+			f.skip()
+			if f.i < len(f.s) && f.s[f.i] == ':' {
+				f.i++
+				if v, err = f.pairValue(); err != nil {
+					return nil, err
+				}
+			}
+			m.Set(key, v)
+			if err := f.sep('}'); err != nil {
+				return nil, err
+			}
+		}
+	case '"', '\'':
+		end := quoteEnd(f.s[f.i:])
+		if end < 0 {
+			return nil, fmt.Errorf("unterminated quoted string")
+		}
+		s, err := unquote(f.s[f.i : f.i+end+1])
+		f.i += end + 1
+		return s, err
+	case '*':
+		j := f.i + 1
+		for j < len(f.s) && !strings.ContainsRune(" \t,[]{}", rune(f.s[j])) {
+			j++
+		}
+		v, ok := f.p.anchors[f.s[f.i+1:j]]
+		if !ok {
+			return nil, fmt.Errorf("unknown alias %q", f.s[f.i+1:j])
+		}
+		f.i = j
+		return v, nil
+	case '&', '!':
+		j := f.i
+		for j < len(f.s) && f.s[j] != ' ' && f.s[j] != '\t' {
+			j++
+		}
+		prop := f.s[f.i:j]
+		f.i = j
+		v, err := f.value()
+		if err != nil {
+			return nil, err
+		}
+		if c == '&' {
+			f.p.anchors[prop[1:]] = v
+			return v, nil
+		}
+		return applyTag(prop, v), nil
+	case ']', '}', ',':
+		return nil, fmt.Errorf("unexpected %q", c)
+	}
+	j := f.i
+	for ; j < len(f.s); j++ {
+		c := f.s[j]
+		if c == ',' || c == '[' || c == ']' || c == '{' || c == '}' {
+			break
+		}
+		if c == ':' && (j+1 == len(f.s) || strings.IndexByte(" \t,[]{}", f.s[j+1]) >= 0) {
+			break
+		}
+	}
+	text := strings.TrimSpace(f.s[f.i:j])
+	f.i = j
+	return resolvePlain(text), nil
+}
+
+// pairValue parses the value after a colon, which may be empty.
+func (f *flowParser) pairValue() (interface{}, error) {
+	f.skip()
+	if f.i < len(f.s) && (f.s[f.i] == ',' || f.s[f.i] == '}' || f.s[f.i] == ']') {
+		return nil, nil
+	}
+	return f.value()
+}
+
+// sep consumes a comma, or sees the closing bracket.
+func (f *flowParser) sep(close byte) error {
+	f.skip()
+	if f.i >= len(f.s) {
+		return fmt.Errorf("unterminated flow collection")
+	}
+	switch f.s[f.i] {
+	case ',':
+		f.i++
+		return nil
+	case close:
+		return nil
+	}
+	return fmt.Errorf("expected , or %c, found %q", close, f.s[f.i])
+}
+
+var (
+	yamlInt   = regexp.MustCompile(`^[-+]?[0-9]+$`)
+	yamlOct   = regexp.MustCompile(`^0o[0-7]+$`)
+	yamlHex   = regexp.MustCompile(`^0x[0-9a-fA-F]+$`)
+	yamlFloat = regexp.MustCompile(`^[-+]?(\.[0-9]+|[0-9]+(\.[0-9]*)?)([eE][-+]?[0-9]+)?$`)
+	yamlInf   = regexp.MustCompile(`^[-+]?\.(inf|Inf|INF)$`)
+	yamlNaN   = regexp.MustCompile(`^\.(nan|NaN|NAN)$`)
+)
+
+// resolvePlain types a plain scalar by the YAML 1.2 core schema.
+func resolvePlain(s string) interface{} {
+	switch s {
+	case "", "~", "null", "Null", "NULL":
+		return nil
+	case "true", "True", "TRUE":
+		return true
+	case "false", "False", "FALSE":
+		return false
+	}
+	switch {
+	case yamlInt.MatchString(s):
+		if i, err := strconv.ParseInt(strings.TrimPrefix(s, "+"), 10, 64); err == nil {
+			return i
+		}
+		f, _ := strconv.ParseFloat(s, 64)
+		return f
+	case yamlOct.MatchString(s):
+		if i, err := strconv.ParseInt(s[2:], 8, 64); err == nil {
+			return i
+		}
+	case yamlHex.MatchString(s):
+		if i, err := strconv.ParseInt(s[2:], 16, 64); err == nil {
+			return i
+		}
+	case yamlFloat.MatchString(s):
+		f, _ := strconv.ParseFloat(s, 64)
+		return f
+	case yamlInf.MatchString(s):
+		if s[0] == '-' {
+			return math.Inf(-1)
+		}
+		return math.Inf(1)
+	case yamlNaN.MatchString(s):
+		return math.NaN()
+	}
+	return s
+}
+
+var yamlDate = regexp.MustCompile(`^[0-9]{4}-[0-9]{1,2}-[0-9]{1,2}`)
+
+// yamlPlainOK reports whether s can be written as a plain scalar and read
+// back as the same string, by this reader and by YAML 1.1 readers.
+func yamlPlainOK(s string) bool {
+	if s == "" || s != strings.TrimSpace(s) {
+		return false
+	}
+	if _, isStr := resolvePlain(s).(string); !isStr {
+		return false
+	}
+	switch strings.ToLower(s) {
+	case "y", "n", "yes", "no", "on", "off":
+		return false
+	}
+	if strings.ContainsRune("-?:,[]{}#&*!|>'\"%@`", rune(s[0])) || yamlDate.MatchString(s) {
+		return false
+	}
+	if strings.Contains(s, ": ") || strings.Contains(s, " #") || strings.HasSuffix(s, ":") {
+		return false
+	}
+	for _, r := range s {
+		if r < ' ' || r == 0x7f || r == 0x85 || r == 0xfeff || r == 0x2028 || r == 0x2029 || r == utf8.RuneError {
+			return false
+		}
+	}
+	return true
+}
+
+// yamlLiteralOK reports whether a multi-line string can be written as a |
+// block scalar.
+func yamlLiteralOK(s string) bool {
+	if !strings.Contains(strings.TrimRight(s, "\n"), "\n") || s[0] == ' ' || s[0] == '\n' {
+		return false
+	}
+	for _, r := range s {
+		if (r < ' ' && r != '\n' && r != '\t') || r == 0x7f || r == 0x85 || r == 0xfeff || r == 0x2028 || r == 0x2029 {
+			return false
+		}
+	}
+	return true
+}
+
+type yamlEncoder struct {
+	w    *bufio.Writer
+	step int
+}
+
+func encodeYAML(w io.Writer, v interface{}, opt Options) error {
+	e := &yamlEncoder{w: bufio.NewWriter(w), step: opt.Indent}
+	if e.step <= 0 {
+		e.step = 2
+	}
+	if err := e.node(v, 0, ""); err != nil {
+		return err
+	}
+	return e.w.Flush()
+}
+
+func yamlScalar(v interface{}) (string, error) {
+	switch x := v.(type) {
+	case nil:
+		return "null", nil
+	case bool:
+		return strconv.FormatBool(x), nil
+	case int64:
+		return strconv.FormatInt(x, 10), nil
+	case float64:
+		switch {
+		case math.IsInf(x, 1):
+			return ".inf", nil
+		case math.IsInf(x, -1):
+			return "-.inf", nil
+		case math.IsNaN(x):
+			return ".nan", nil
+		}
+		return FormatFloat(x), nil
+	case string:
+		if yamlPlainOK(x) {
+			return x, nil
+		}
+		return quoteJSON(x), nil
+	case Datetime:
+		return x.String(), nil
+	case *Map:
+		if x.Len() == 0 {
+			return "{}", nil
+		}
+	case []interface{}:
+		if len(x) == 0 {
+			return "[]", nil
+		}
+	}
+	return "", fmt.Errorf("cannot encode %T", v)
+}
+
+// node writes v at indent ind. lead, when set, replaces the indentation of
+// the first line (it holds the "- " of an enclosing sequence entry).
+func (e *yamlEncoder) node(v interface{}, ind int, lead string) error {
+	pad := strings.Repeat(" ", ind)
+	first := func() string {
+		if lead != "" {
+			l := lead
+			lead = ""
+			return l
+		}
+		return pad
+	}
+	switch x := v.(type) {
+	case *Map:
+		if x.Len() == 0 {
+			break
+		}
+		for _, k := range x.keys {
+			key, _ := yamlScalar(k)
+			if strings.ContainsAny(k, "\n") {
+				key = quoteJSON(k)
+			}
+			if err := e.entry(first()+key+":", x.vals[k], ind); err != nil {
+				return err
+			}
+		}
+		return nil
+	case []interface{}:
+		if len(x) == 0 {
+			break
+		}
+		for _, item := range x {
+			if err := e.entry(first()+"-", item, ind); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	s, err := yamlScalar(v)
+	if err != nil {
+		return err
+	}
+	if str, ok := v.(string); ok && yamlLiteralOK(str) {
+		return e.literal(first(), str, ind)
+	}
+	e.w.WriteString(first() + s + "\n")
+	return nil
+}
+
+// entry writes a mapping entry or sequence entry whose text so far is
+// prefix ("key:" or "-") and whose value is v.
+func (e *yamlEncoder) entry(prefix string, v interface{}, ind int) error {
+	switch x := v.(type) {
+	case *Map:
+		if x.Len() > 0 {
+			if strings.HasSuffix(prefix, "-") {
+				return e.node(x, ind+2, prefix+" ")
+			}
+			e.w.WriteString(prefix + "\n")
+			return e.node(x, ind+e.step, "")
+		}
+	case []interface{}:
+		if len(x) > 0 {
+			if strings.HasSuffix(prefix, "-") {
+				return e.node(x, ind+2, prefix+" ")
+			}
+			e.w.WriteString(prefix + "\n")
+			return e.node(x, ind+e.step, "")
+		}
+	case string:
+		if yamlLiteralOK(x) {
+			return e.literal(prefix+" ", x, ind)
+		}
+	}
+	s, err := yamlScalar(v)
+	if err != nil {
+		return err
+	}
+	e.w.WriteString(prefix + " " + s + "\n")
+	return nil
+}
+
+// literal writes s as a | block scalar after prefix.
+func (e *yamlEncoder) literal(prefix, s string, ind int) error {
+	trailing := len(s) - len(strings.TrimRight(s, "\n"))
+	header := "|"
+	switch {
+	case trailing == 0:
+		header = "|-"
+	case trailing > 1:
+		header = "|+"
+	}
+	e.w.WriteString(prefix + header + "\n")
+	pad := strings.Repeat(" ", ind+e.step)
+	body := s
+	if trailing > 0 {
+		body = s[:len(s)-1]
+	}
+	for _, l := range strings.Split(body, "\n") {
+		if l == "" {
+			e.w.WriteString("\n")
+		} else {
+			e.w.WriteString(pad + l + "\n")
+		}
+	}
+	return nil
+}
@@ -0,0 +1,115 @@
+package htmltree
+
+import "strings"
+
+// NodeType is the kind of a Node.
+type NodeType int
+
+const (
+	DocumentNode NodeType = iota
+	DoctypeNode
+	ElementNode
+	TextNode
+	CommentNode
+)
+
+// Attr is an attribute of an element. Keys are lower case.
+type Attr struct {
+	Key, Val string
+}
+
+// Node is a node of a parsed document. Data is the tag name of an
+// element (lower case), the text of a text or comment node, or the
+// contents of a doctype.
+type Node struct {
+	Type NodeType
+	Data string
+	Attr []Attr
+
+	Parent, FirstChild, LastChild, PrevSibling, NextSibling *Node
+}
+
+// Get returns the value of the attribute key.
+func (n *Node) Get(key string) (string, bool) {
+	for _, a := range n.Attr {
+		if a.Key == key {
+			return a.Val, true
+		}
+	}
+	return "", false
+}
+
+// Set sets the attribute key to val.
+func (n *Node) Set(key, val string) {
+	for i, a := range n.Attr {
+		if a.Key == key {
+			n.Attr[i].Val = val
+			return
+		}
+	}
+	n.Attr = append(n.Attr, Attr{Key: key, Val: val})
+}
+
+// AppendChild adds c as the last child of n.
+func (n *Node) AppendChild(c *Node) {
+	c.Parent, c.PrevSibling, c.NextSibling = n, n.LastChild, nil
+	if n.LastChild != nil {
+		n.LastChild.NextSibling = c
+	} else {
+		n.FirstChild = c
+	}
+	n.LastChild = c
+}
+
+// Remove detaches n from its parent.
+func (n *Node) Remove() {
+	p := n.Parent
+	if p == nil {
+		return
+	}
+	if n.PrevSibling != nil {
+		n.PrevSibling.NextSibling = n.NextSibling
+	} else {
+		p.FirstChild = n.NextSibling
+	}
+	if n.NextSibling != nil {
+		n.NextSibling.PrevSibling = n.PrevSibling
+	} else {
+		p.LastChild = n.PrevSibling
+	}
+	n.Parent, n.PrevSibling, n.NextSibling = nil, nil, nil
+}
+
+// Text returns the concatenated text of n and its descendants.
+func (n *Node) Text() string {
+	if n.Type == TextNode {
+		return n.Data
+	}
+	var b strings.Builder
+	var walk func(*Node)
+	walk = func(n *Node) {
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			switch c.Type {
+			case TextNode:
+				b.WriteString(c.Data)
+			case ElementNode:
+				walk(c)
+			}
+		}
+	}
+	walk(n)
+	return b.String()
+}
+
+// Walk calls f for n and each of its descendants in document order. If f
+// returns false the children of that node are skipped.
+func (n *Node) Walk(f func(*Node) bool) {
+	if !f(n) {
+		return
+	}
+	for c := n.FirstChild; c != nil; {
+		next := c.NextSibling // f may remove c
+		c.Walk(f)
+		c = next
+	}
+}
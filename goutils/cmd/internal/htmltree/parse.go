@@ -0,0 +1,541 @@
+package htmltree
+
+import (
+	"io"
+	"strings"
+)
+
+func set(names ...string) map[string]bool {
+	m := make(map[string]bool, len(names))
+	for _, n := range names {
+		m[n] = true
+	}
+	return m
+}
+
+var (
+	voidElements = set("area", "base", "br", "col", "embed", "hr", "img", "input",
+		"keygen", "link", "meta", "param", "source", "track", "wbr")
+
+	// headElements go in the head when they come before the body starts.
+	headElements = set("base", "basefont", "bgsound", "link", "meta", "noscript",
+		"script", "style", "template", "title")
+
+	// closesP lists the start tags that end an open p element.
+	closesP = set("address", "article", "aside", "blockquote", "center", "details",
+		"dialog", "dir", "div", "dl", "fieldset", "figcaption", "figure", "footer",
+		"form", "h1", "h2", "h3", "h4", "h5", "h6", "header", "hgroup", "hr", "listing",
+		"main", "menu", "nav", "ol", "p", "plaintext", "pre", "search", "section",
+		"summary", "table", "ul", "xmp", "li", "dd", "dt")
+
+	headings = set("h1", "h2", "h3", "h4", "h5", "h6")
+
+	// impliedEnd lists the elements closed by the end of their parent.
+	impliedEnd = set("dd", "dt", "li", "optgroup", "option", "p", "rb", "rp", "rt", "rtc")
+
+	special = set("address", "applet", "area", "article", "aside", "base", "basefont",
+		"bgsound", "blockquote", "body", "br", "button", "caption", "center", "col",
+		"colgroup", "dd", "details", "dir", "div", "dl", "dt", "embed", "fieldset",
+		"figcaption", "figure", "footer", "form", "frame", "frameset", "h1", "h2",
+		"h3", "h4", "h5", "h6", "head", "header", "hgroup", "hr", "html", "iframe",
+		"img", "input", "keygen", "li", "link", "listing", "main", "marquee", "menu",
+		"meta", "nav", "noembed", "noframes", "noscript", "object", "ol", "p",
+		"param", "plaintext", "pre", "script", "search", "section", "select",
+		"source", "style", "summary", "table", "tbody", "td", "template", "textarea",
+		"tfoot", "th", "thead", "title", "tr", "track", "ul", "wbr", "xmp")
+
+	defaultScope  = set("applet", "caption", "html", "table", "td", "th", "marquee", "object", "template", "foreignobject")
+	listItemScope = set("applet", "caption", "html", "table", "td", "th", "marquee", "object", "template", "foreignobject", "ol", "ul")
+	buttonScope   = set("applet", "caption", "html", "table", "td", "th", "marquee", "object", "template", "foreignobject", "button")
+	tableScope    = set("html", "table", "template")
+	tableContext  = set("html", "table", "template")
+	tableRowCtx   = set("html", "tr", "template")
+	inTable       = set("table", "tbody", "tfoot", "thead", "tr")
+	tableParts    = set("caption", "col", "colgroup", "tbody", "td", "tfoot", "th", "thead", "tr")
+	foreignRoots  = set("svg", "math")
+	preformatted  = set("pre", "listing", "textarea")
+
+	// breakout lists the start tags that end svg and math content.
+	breakout = set("b", "big", "blockquote", "body", "br", "center", "code", "dd",
+		"div", "dl", "dt", "em", "embed", "h1", "h2", "h3", "h4", "h5", "h6", "head",
+		"hr", "i", "img", "li", "listing", "menu", "meta", "nobr", "ol", "p", "pre",
+		"ruby", "s", "small", "span", "strong", "strike", "sub", "sup", "table",
+		"tt", "u", "ul", "var")
+
+	// sectionClosers lists the end tags that close everything up to their
+	// element, if it is in scope.
+	sectionClosers = set("address", "article", "aside", "blockquote", "button", "center",
+		"details", "dialog", "dir", "div", "dl", "fieldset", "figcaption", "figure",
+		"footer", "form", "header", "hgroup", "listing", "main", "menu", "nav", "ol",
+		"pre", "search", "section", "summary", "ul", "select")
+)
+
+// parser builds a tree from tokens.
+type parser struct {
+	z     tokenizer
+	doc   *Node
+	html  *Node
+	head  *Node
+	body  *Node
+	stack []*Node // open elements
+	// skipNewline drops a newline right after <pre>, <listing> or
+	// <textarea>.
+	skipNewline bool
+}
+
+// Parse reads an HTML document from r and returns its tree. The document
+// node has a single html element child with head and body children,
+// whatever the input leaves out.
+func Parse(r io.Reader) (*Node, error) {
+	src, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	return ParseString(string(src)), nil
+}
+
+// ParseString parses an HTML document held in s.
+func ParseString(s string) *Node {
+	s = strings.TrimPrefix(s, "\ufeff")
+	p := &parser{z: tokenizer{src: []byte(s)}, doc: &Node{Type: DocumentNode}}
+	for {
+		t, ok := p.z.next()
+		if !ok {
+			break
+		}
+		p.token(t)
+	}
+	p.ensureBody()
+	return p.doc
+}
+
+func (p *parser) top() *Node {
+	if len(p.stack) == 0 {
+		return p.doc
+	}
+	return p.stack[len(p.stack)-1]
+}
+
+func (p *parser) pop() *Node {
+	n := p.stack[len(p.stack)-1]
+	p.stack = p.stack[:len(p.stack)-1]
+	return n
+}
+
+// popUntil pops elements up to and including the innermost named one.
+func (p *parser) popUntil(names ...string) {
+	for len(p.stack) > 0 {
+		n := p.pop()
+		for _, name := range names {
+			if n.Data == name {
+				return
+			}
+		}
+	}
+}
+
+// inScope reports whether an element named name is open with no element
+// of boundary between it and the current node.
+func (p *parser) inScope(name string, boundary map[string]bool) bool {
+	for i := len(p.stack) - 1; i >= 0; i-- {
+		switch d := p.stack[i].Data; {
+		case d == name:
+			return true
+		case boundary[d]:
+			return false
+		}
+	}
+	return false
+}
+
+func (p *parser) inForeign() bool {
+	for i := len(p.stack) - 1; i >= 0; i-- {
+		switch d := p.stack[i].Data; {
+		case foreignRoots[d]:
+			return true
+		case d == "foreignobject":
+			return false
+		}
+	}
+	return false
+}
+
+// generateImpliedEnd pops elements whose end tags may be left out, other
+// than except.
+func (p *parser) generateImpliedEnd(except string) {
+	for len(p.stack) > 0 {
+		d := p.top().Data
+		if !impliedEnd[d] || d == except {
+			return
+		}
+		p.pop()
+	}
+}
+
+// clearTo pops elements until the current node is one of ctx.
+func (p *parser) clearTo(ctx map[string]bool) {
+	for len(p.stack) > 0 && !ctx[p.top().Data] {
+		p.pop()
+	}
+}
+
+func (p *parser) closeP() {
+	if p.inScope("p", buttonScope) {
+		p.generateImpliedEnd("p")
+		p.popUntil("p")
+	}
+}
+
+func (p *parser) element(name string, attr []Attr) *Node {
+	n := &Node{Type: ElementNode, Data: name, Attr: attr}
+	p.top().AppendChild(n)
+	p.stack = append(p.stack, n)
+	return n
+}
+
+func (p *parser) ensureHTML() {
+	if p.html == nil {
+		p.html = p.element("html", nil)
+	}
+}
+
+func (p *parser) ensureHead() {
+	p.ensureHTML()
+	if p.head == nil {
+		p.head = p.element("head", nil)
+	}
+}
+
+// ensureBody closes the head and opens the body if that has not
+// happened yet.
+func (p *parser) ensureBody() {
+	p.ensureHead()
+	if p.body != nil {
+		return
+	}
+	for len(p.stack) > 1 {
+		p.pop()
+	}
+	p.body = p.element("body", nil)
+}
+
+func (p *parser) text(s string) {
+	if p.skipNewline {
+		p.skipNewline = false
+		s = strings.TrimPrefix(s, "\n")
+	}
+	if s == "" {
+		return
+	}
+	if top := p.top(); p.body == nil && (top == p.doc || top == p.html || top == p.head) {
+		// Leading white space stays in the head, if there is one yet;
+		// other text starts the body.
+		lead := len(s) - len(strings.TrimLeft(s, " \t\n\f\r"))
+		if lead > 0 && p.head != nil {
+			p.appendText(p.head, s[:lead])
+		}
+		if s = s[lead:]; s == "" {
+			return
+		}
+		p.ensureBody()
+	}
+	p.appendText(p.top(), s)
+}
+
+func (p *parser) appendText(parent *Node, s string) {
+	if c := parent.LastChild; c != nil && c.Type == TextNode {
+		c.Data += s
+		return
+	}
+	parent.AppendChild(&Node{Type: TextNode, Data: s})
+}
+
+func (p *parser) token(t token) {
+	switch t.typ {
+	case textToken:
+		p.text(t.data)
+	case commentToken:
+		p.top().AppendChild(&Node{Type: CommentNode, Data: t.data})
+	case doctypeToken:
+		if p.html == nil {
+			p.doc.AppendChild(&Node{Type: DoctypeNode, Data: t.data})
+		}
+	case startTagToken, selfClosingTagToken:
+		p.skipNewline = false
+		p.startTag(t)
+	case endTagToken:
+		p.skipNewline = false
+		p.endTag(t.data)
+	}
+}
+
+func mergeAttr(n *Node, attr []Attr) {
+	for _, a := range attr {
+		if _, ok := n.Get(a.Key); !ok {
+			n.Attr = append(n.Attr, a)
+		}
+	}
+}
+
+func (p *parser) startTag(t token) {
+	name := t.data
+	if p.inForeign() && breakout[name] {
+		for len(p.stack) > 0 && !foreignRoots[p.pop().Data] {
+		}
+	}
+	if p.inForeign() {
+		p.element(name, t.attr)
+		if t.typ == selfClosingTagToken {
+			p.pop()
+		}
+		return
+	}
+	switch {
+	case name == "html":
+		p.ensureHTML()
+		mergeAttr(p.html, t.attr)
+		return
+	case name == "head":
+		if p.head == nil {
+			p.ensureHTML()
+			p.head = p.element("head", t.attr)
+		}
+		return
+	case name == "body":
+		if p.body == nil {
+			p.ensureHead()
+			for len(p.stack) > 1 {
+				p.pop()
+			}
+			p.body = p.element("body", t.attr)
+		} else {
+			mergeAttr(p.body, t.attr)
+		}
+		return
+	case name == "frameset" || name == "frame":
+		return
+	case headElements[name] && p.body == nil:
+		p.ensureHead()
+		switch {
+		case p.top() == p.head:
+		case p.stack[len(p.stack)-1] == p.html:
+			// After </head>, the element still goes in the head.
+			p.stack = append(p.stack, p.head)
+		default:
+			// An element such as <script> inside <noscript>.
+			p.clearTo(set("head"))
+		}
+		p.insert(name, t)
+		return
+	}
+	p.ensureBody()
+
+	switch {
+	case closesP[name]:
+		switch name {
+		case "li":
+			p.closeListItem("li")
+		case "dd", "dt":
+			p.closeListItem("dd", "dt")
+		}
+		p.closeP()
+		if headings[name] && headings[p.top().Data] {
+			p.pop()
+		}
+		if name == "table" && inTable[p.top().Data] {
+			// A table inside a table, outside a cell, closes the first.
+			p.popUntil("table")
+		}
+	case name == "a":
+		for i := len(p.stack) - 1; i >= 0 && !special[p.stack[i].Data]; i-- {
+			if p.stack[i].Data == "a" {
+				p.stack = p.stack[:i]
+				break
+			}
+		}
+	case name == "button":
+		if p.inScope("button", defaultScope) {
+			p.generateImpliedEnd("")
+			p.popUntil("button")
+		}
+	case name == "option":
+		if p.top().Data == "option" {
+			p.pop()
+		}
+	case name == "optgroup":
+		if p.top().Data == "option" {
+			p.pop()
+		}
+		if p.top().Data == "optgroup" {
+			p.pop()
+		}
+	case name == "rb" || name == "rtc":
+		if p.inScope("ruby", defaultScope) {
+			p.generateImpliedEnd("")
+		}
+	case name == "rt" || name == "rp":
+		if p.inScope("ruby", defaultScope) {
+			p.generateImpliedEnd("rtc")
+		}
+	case tableParts[name]:
+		if !p.inScope("table", tableScope) {
+			return // table parts outside a table are dropped
+		}
+		switch name {
+		case "caption", "colgroup", "tbody", "thead", "tfoot":
+			p.clearTo(tableContext)
+		case "col":
+			if p.top().Data != "colgroup" {
+				p.clearTo(tableContext)
+				p.element("colgroup", nil)
+			}
+		case "tr":
+			p.closeCell()
+			if p.top().Data == "tr" || p.top().Data == "caption" || p.top().Data == "colgroup" {
+				p.pop()
+			}
+			p.clearTo(set("html", "table", "tbody", "thead", "tfoot", "template"))
+			if p.top().Data == "table" {
+				p.element("tbody", nil)
+			}
+		case "td", "th":
+			p.closeCell()
+			if p.top().Data == "caption" || p.top().Data == "colgroup" {
+				p.pop()
+			}
+			if !tableRowCtx[p.top().Data] {
+				p.clearTo(set("html", "table", "tbody", "thead", "tfoot", "tr", "template"))
+				if p.top().Data == "table" {
+					p.element("tbody", nil)
+				}
+				if p.top().Data != "tr" {
+					p.element("tr", nil)
+				}
+			}
+		}
+	case name == "image":
+		name = "img"
+	}
+	p.insert(name, t)
+}
+
+// closeListItem closes an open li (or dd/dt) before another starts, as
+// long as no other special element is in the way.
+func (p *parser) closeListItem(names ...string) {
+	for i := len(p.stack) - 1; i >= 0; i-- {
+		d := p.stack[i].Data
+		for _, name := range names {
+			if d == name {
+				p.generateImpliedEnd(d)
+				p.popUntil(d)
+				return
+			}
+		}
+		if special[d] && d != "address" && d != "div" && d != "p" {
+			return
+		}
+	}
+}
+
+// closeCell closes a td or th that is still open in the current table.
+func (p *parser) closeCell() {
+	if p.inScope("td", tableScope) || p.inScope("th", tableScope) {
+		p.generateImpliedEnd("")
+		p.popUntil("td", "th")
+	}
+}
+
+// insert adds the element for a start tag and sets up how its contents
+// are read.
+func (p *parser) insert(name string, t token) {
+	p.element(name, t.attr)
+	switch {
+	case voidElements[name]:
+		p.pop()
+	case foreignRoots[name] && t.typ == selfClosingTagToken:
+		p.pop()
+	case preformatted[name]:
+		p.skipNewline = true
+	}
+	if _, ok := rawText[name]; ok {
+		p.z.raw = name
+	}
+}
+
+func (p *parser) endTag(name string) {
+	switch {
+	case name == "html" || name == "body":
+		return
+	case name == "head":
+		if p.top() == p.head {
+			p.pop()
+		}
+		return
+	case name == "br":
+		p.ensureBody()
+		p.insert("br", token{})
+		return
+	}
+	if p.body == nil && p.head != nil && p.top() == p.head {
+		// A stray end tag in the head is ignored.
+		return
+	}
+	if p.inForeign() {
+		p.anyOtherEnd(name)
+		return
+	}
+	switch {
+	case name == "p":
+		if !p.inScope("p", buttonScope) {
+			p.ensureBody()
+			p.element("p", nil)
+		}
+		p.closeP()
+	case name == "li":
+		if p.inScope("li", listItemScope) {
+			p.generateImpliedEnd("li")
+			p.popUntil("li")
+		}
+	case name == "dd" || name == "dt":
+		if p.inScope(name, defaultScope) {
+			p.generateImpliedEnd(name)
+			p.popUntil(name)
+		}
+	case headings[name]:
+		for h := range headings {
+			if p.inScope(h, defaultScope) {
+				p.generateImpliedEnd("")
+				p.popUntil("h1", "h2", "h3", "h4", "h5", "h6")
+				return
+			}
+		}
+	case name == "table" || tableParts[name]:
+		if p.inScope(name, tableScope) {
+			p.generateImpliedEnd("")
+			p.popUntil(name)
+		}
+	case sectionClosers[name]:
+		if p.inScope(name, defaultScope) {
+			p.generateImpliedEnd("")
+			p.popUntil(name)
+		}
+	default:
+		p.anyOtherEnd(name)
+	}
+}
+
+// anyOtherEnd closes the innermost element named name, unless a special
+// element is open inside it.
+func (p *parser) anyOtherEnd(name string) {
+	for i := len(p.stack) - 1; i >= 0; i-- {
+		d := p.stack[i].Data
+		if d == name {
+			p.generateImpliedEnd(name)
+			p.stack = p.stack[:i]
+			return
+		}
+		if special[d] && !p.inForeign() {
+			return
+		}
+	}
+}
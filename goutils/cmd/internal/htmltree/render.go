@@ -0,0 +1,70 @@
+package htmltree
+
+import (
+	"bufio"
+	"io"
+	"strings"
+)
+
+// rawOutput lists the elements whose text is written without escaping.
+var rawOutput = set("iframe", "noembed", "noframes", "plaintext", "script", "style", "xmp")
+
+var (
+	textEscaper = strings.NewReplacer("&", "&amp;", "\u00a0", "&nbsp;", "<", "&lt;", ">", "&gt;")
+	attrEscaper = strings.NewReplacer("&", "&amp;", "\u00a0", "&nbsp;", `"`, "&quot;")
+)
+
+// Render writes n as HTML, following the HTML5 serialization rules. A
+// document node is written as its children.
+func Render(w io.Writer, n *Node) error {
+	bw := bufio.NewWriter(w)
+	render(bw, n)
+	return bw.Flush()
+}
+
+// RenderChildren writes the children of n, its inner HTML.
+func RenderChildren(w io.Writer, n *Node) error {
+	bw := bufio.NewWriter(w)
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		render(bw, c)
+	}
+	return bw.Flush()
+}
+
+func render(w *bufio.Writer, n *Node) {
+	switch n.Type {
+	case DocumentNode:
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			render(w, c)
+		}
+	case DoctypeNode:
+		w.WriteString("<!DOCTYPE " + n.Data + ">")
+	case CommentNode:
+		w.WriteString("<!--" + n.Data + "-->")
+	case TextNode:
+		if p := n.Parent; p != nil && p.Type == ElementNode && rawOutput[p.Data] {
+			w.WriteString(n.Data)
+		} else {
+			textEscaper.WriteString(w, n.Data)
+		}
+	case ElementNode:
+		w.WriteString("<" + n.Data)
+		for _, a := range n.Attr {
+			w.WriteString(" " + a.Key + `="`)
+			attrEscaper.WriteString(w, a.Val)
+			w.WriteByte('"')
+		}
+		w.WriteByte('>')
+		if voidElements[n.Data] {
+			return
+		}
+		if preformatted[n.Data] && n.FirstChild != nil && n.FirstChild.Type == TextNode && strings.HasPrefix(n.FirstChild.Data, "\n") {
+			// The parser drops one leading newline.
+			w.WriteByte('\n')
+		}
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			render(w, c)
+		}
+		w.WriteString("</" + n.Data + ">")
+	}
+}
@@ -0,0 +1,662 @@
+package htmltree
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// SelectorError is an error compiling a CSS selector.
+type SelectorError struct {
+	Selector string
+	Pos      int // byte offset
+	Msg      string
+}
+
+func (e *SelectorError) Error() string {
+	return fmt.Sprintf("selector %s: at offset %d: %s", e.Selector, e.Pos, e.Msg)
+}
+
+// Selector is a compiled CSS selector list, as used by querySelectorAll.
+// It supports Selectors Level 4 except for namespaces, pseudo-elements
+// and pseudo-classes that depend on user interaction:
+//
+//	E  *  #id  .class
+//	[a]  [a=v]  [a~=v]  [a|=v]  [a^=v]  [a$=v]  [a*=v]  [a=v i]
+//	E F  E > F  E + F  E ~ F  E, F
+//	:root  :scope  :empty  :first-child  :last-child  :only-child
+//	:first-of-type  :last-of-type  :only-of-type
+//	:nth-child(An+B [of S])  :nth-last-child(An+B [of S])
+//	:nth-of-type(An+B)  :nth-last-of-type(An+B)
+//	:not(S)  :is(S)  :where(S)  :has(> S)
+//	:link  :any-link  :checked  :disabled  :enabled
+//	:contains("text")
+//
+// :contains, which matches elements whose text contains the string, is
+// the jQuery extension.
+type Selector struct {
+	src  string
+	list []*complexSel
+}
+
+// complexSel is a chain of compound selectors; combs[i] joins parts[i]
+// and parts[i+1].
+type complexSel struct {
+	parts []compound
+	combs []byte
+}
+
+type compound struct {
+	tag   string // "" matches any element
+	tests []func(n, scope *Node) bool
+}
+
+// Compile parses a selector list.
+func Compile(src string) (s *Selector, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			se, ok := r.(*SelectorError)
+			if !ok {
+				panic(r)
+			}
+			err = se
+		}
+	}()
+	p := &selParser{src: src}
+	list := p.list(false)
+	if p.pos < len(src) {
+		p.fail("unexpected %q", src[p.pos:p.pos+1])
+	}
+	return &Selector{src: src, list: list}, nil
+}
+
+// String returns the source of the selector.
+func (s *Selector) String() string { return s.src }
+
+// Match reports whether the element n matches s. :scope matches the
+// root element.
+func (s *Selector) Match(n *Node) bool {
+	return n.Type == ElementNode && matchList(s.list, n, nil)
+}
+
+// Select returns the elements below root that match s, in document
+// order. :scope matches root, or the root element if root is a document.
+func (s *Selector) Select(root *Node) []*Node {
+	var nodes []*Node
+	scope := root
+	if root.Type != ElementNode {
+		scope = nil
+	}
+	for c := root.FirstChild; c != nil; c = c.NextSibling {
+		c.Walk(func(n *Node) bool {
+			if n.Type == ElementNode && matchList(s.list, n, scope) {
+				nodes = append(nodes, n)
+			}
+			return true
+		})
+	}
+	return nodes
+}
+
+func matchList(list []*complexSel, n, scope *Node) bool {
+	for _, c := range list {
+		if matchComplex(c, len(c.parts)-1, n, scope) {
+			return true
+		}
+	}
+	return false
+}
+
+func matchComplex(c *complexSel, i int, n, scope *Node) bool {
+	if !c.parts[i].match(n, scope) {
+		return false
+	}
+	if i == 0 {
+		return true
+	}
+	switch c.combs[i-1] {
+	case '>':
+		p := parentElement(n)
+		return p != nil && matchComplex(c, i-1, p, scope)
+	case '+':
+		s := prevElement(n)
+		return s != nil && matchComplex(c, i-1, s, scope)
+	case '~':
+		for s := prevElement(n); s != nil; s = prevElement(s) {
+			if matchComplex(c, i-1, s, scope) {
+				return true
+			}
+		}
+	default:
+		for p := parentElement(n); p != nil; p = parentElement(p) {
+			if matchComplex(c, i-1, p, scope) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func (c *compound) match(n, scope *Node) bool {
+	if c.tag != "" && n.Data != c.tag {
+		return false
+	}
+	for _, t := range c.tests {
+		if !t(n, scope) {
+			return false
+		}
+	}
+	return true
+}
+
+func parentElement(n *Node) *Node {
+	if p := n.Parent; p != nil && p.Type == ElementNode {
+		return p
+	}
+	return nil
+}
+
+func prevElement(n *Node) *Node {
+	for s := n.PrevSibling; s != nil; s = s.PrevSibling {
+		if s.Type == ElementNode {
+			return s
+		}
+	}
+	return nil
+}
+
+func nextElement(n *Node) *Node {
+	for s := n.NextSibling; s != nil; s = s.NextSibling {
+		if s.Type == ElementNode {
+			return s
+		}
+	}
+	return nil
+}
+
+// selParser parses selectors; errors are panics recovered by Compile.
+type selParser struct {
+	src string
+	pos int
+}
+
+func (p *selParser) fail(format string, args ...interface{}) {
+	panic(&SelectorError{Selector: p.src, Pos: p.pos, Msg: fmt.Sprintf(format, args...)})
+}
+
+func (p *selParser) peek() byte {
+	if p.pos < len(p.src) {
+		return p.src[p.pos]
+	}
+	return 0
+}
+
+// space skips white space and reports whether there was any.
+func (p *selParser) space() bool {
+	start := p.pos
+	for p.pos < len(p.src) && isSpace(p.src[p.pos]) {
+		p.pos++
+	}
+	return p.pos > start
+}
+
+// list parses a comma-separated list up to the end or a closing
+// parenthesis. Relative selectors, as in :has(), may start with a
+// combinator.
+func (p *selParser) list(relative bool) []*complexSel {
+	var list []*complexSel
+	for {
+		p.space()
+		list = append(list, p.complex(relative))
+		p.space()
+		if p.peek() != ',' {
+			return list
+		}
+		p.pos++
+	}
+}
+
+func (p *selParser) complex(relative bool) *complexSel {
+	c := &complexSel{}
+	if relative {
+		// A relative selector is anchored at the :has() subject.
+		comb := byte(' ')
+		if b := p.peek(); b == '>' || b == '+' || b == '~' {
+			comb = b
+			p.pos++
+			p.space()
+		}
+		c.parts = append(c.parts, compound{tests: []func(n, scope *Node) bool{isScope}})
+		c.combs = append(c.combs, comb)
+	}
+	for {
+		c.parts = append(c.parts, p.compound())
+		ws := p.space()
+		switch b := p.peek(); {
+		case b == '>' || b == '+' || b == '~':
+			p.pos++
+			p.space()
+			c.combs = append(c.combs, b)
+		case b == 0 || b == ',' || b == ')':
+			return c
+		case ws:
+			c.combs = append(c.combs, ' ')
+		default:
+			p.fail("unexpected %q", string(b))
+		}
+	}
+}
+
+func isScope(n, scope *Node) bool {
+	if scope == nil {
+		return n.Parent != nil && n.Parent.Type == DocumentNode
+	}
+	return n == scope
+}
+
+func isNameByte(b byte) bool {
+	return isAlpha(b) || b >= '0' && b <= '9' || b == '-' || b == '_' || b >= 0x80 || b == '\\'
+}
+
+// ident reads an identifier, decoding CSS escapes.
+func (p *selParser) ident() string {
+	var b strings.Builder
+	start := p.pos
+	for p.pos < len(p.src) && isNameByte(p.src[p.pos]) {
+		if p.src[p.pos] != '\\' {
+			b.WriteByte(p.src[p.pos])
+			p.pos++
+			continue
+		}
+		b.WriteString(p.escape())
+	}
+	if p.pos == start {
+		p.fail("expected a name")
+	}
+	return b.String()
+}
+
+// str reads a quoted string or an identifier.
+func (p *selParser) str() string {
+	q := p.peek()
+	if q != '"' && q != '\'' {
+		return p.ident()
+	}
+	p.pos++
+	var b strings.Builder
+	for {
+		if p.pos >= len(p.src) {
+			p.fail("unterminated string")
+		}
+		c := p.src[p.pos]
+		switch {
+		case c == q:
+			p.pos++
+			return b.String()
+		case c == '\\' && p.pos+1 < len(p.src) && p.src[p.pos+1] == '\n':
+			p.pos += 2 // an escaped newline continues the string
+		case c == '\\':
+			b.WriteString(p.escape())
+		default:
+			b.WriteByte(c)
+			p.pos++
+		}
+	}
+}
+
+// escape reads the escape sequence starting with the backslash at p.pos.
+func (p *selParser) escape() string {
+	if p.pos+1 >= len(p.src) {
+		p.fail("escape at end of selector")
+	}
+	end := p.pos + 1
+	for end < len(p.src) && end-p.pos-1 < 6 && strings.IndexByte("0123456789abcdefABCDEF", p.src[end]) >= 0 {
+		end++
+	}
+	if end == p.pos+1 {
+		r, size := utf8.DecodeRuneInString(p.src[end:])
+		p.pos = end + size
+		return string(r)
+	}
+	v, _ := strconv.ParseUint(p.src[p.pos+1:end], 16, 32)
+	if v == 0 || v > utf8.MaxRune {
+		v = utf8.RuneError
+	}
+	p.pos = end
+	if p.pos < len(p.src) && isSpace(p.src[p.pos]) {
+		p.pos++
+	}
+	return string(rune(v))
+}
+
+func (p *selParser) compound() compound {
+	var c compound
+	start := p.pos
+	switch b := p.peek(); {
+	case b == '*':
+		p.pos++
+	case isNameByte(b) && !(b >= '0' && b <= '9'):
+		c.tag = strings.ToLower(p.ident())
+	}
+	for {
+		switch p.peek() {
+		case '#':
+			p.pos++
+			id := p.ident()
+			c.tests = append(c.tests, func(n, _ *Node) bool {
+				v, ok := n.Get("id")
+				return ok && v == id
+			})
+		case '.':
+			p.pos++
+			class := p.ident()
+			c.tests = append(c.tests, func(n, _ *Node) bool {
+				v, _ := n.Get("class")
+				return hasWord(v, class, false)
+			})
+		case '[':
+			p.pos++
+			c.tests = append(c.tests, p.attr())
+		case ':':
+			p.pos++
+			if p.peek() == ':' {
+				p.fail("pseudo-elements are not supported")
+			}
+			c.tests = append(c.tests, p.pseudo())
+		default:
+			if p.pos == start {
+				if p.pos >= len(p.src) {
+					p.fail("expected a selector")
+				}
+				p.fail("unexpected %q", p.src[p.pos:p.pos+1])
+			}
+			return c
+		}
+	}
+}
+
+func hasWord(list, word string, fold bool) bool {
+	for _, f := range strings.Fields(list) {
+		if f == word || fold && strings.EqualFold(f, word) {
+			return true
+		}
+	}
+	return false
+}
+
+// attr parses an attribute selector after its [.
+func (p *selParser) attr() func(n, _ *Node) bool {
+	p.space()
+	name := strings.ToLower(p.ident())
+	p.space()
+	op := ""
+	switch b := p.peek(); {
+	case b == ']':
+		p.pos++
+		return func(n, _ *Node) bool {
+			_, ok := n.Get(name)
+			return ok
+		}
+	case b == '=':
+		op = "="
+		p.pos++
+	case strings.IndexByte("~|^$*", b) >= 0 && p.pos+1 < len(p.src) && p.src[p.pos+1] == '=':
+		op = p.src[p.pos : p.pos+2]
+		p.pos += 2
+	default:
+		p.fail("expected an attribute operator")
+	}
+	p.space()
+	want := p.str()
+	p.space()
+	fold := false
+	if b := p.peek(); b == 'i' || b == 'I' || b == 's' || b == 'S' {
+		fold = b == 'i' || b == 'I'
+		p.pos++
+		p.space()
+	}
+	if p.peek() != ']' {
+		p.fail("expected ]")
+	}
+	p.pos++
+	if fold {
+		want = strings.ToLower(want)
+	}
+	return func(n, _ *Node) bool {
+		v, ok := n.Get(name)
+		if !ok {
+			return false
+		}
+		if fold {
+			v = strings.ToLower(v)
+		}
+		switch op {
+		case "=":
+			return v == want
+		case "~=":
+			return want != "" && !strings.ContainsAny(want, " \t\n\f\r") && hasWord(v, want, false)
+		case "|=":
+			return v == want || strings.HasPrefix(v, want+"-")
+		case "^=":
+			return want != "" && strings.HasPrefix(v, want)
+		case "$=":
+			return want != "" && strings.HasSuffix(v, want)
+		}
+		return want != "" && strings.Contains(v, want)
+	}
+}
+
+// formControls are the elements :enabled and :disabled apply to.
+var formControls = set("button", "input", "select", "textarea", "optgroup", "option", "fieldset")
+
+// pseudo parses a pseudo-class after its colon.
+func (p *selParser) pseudo() func(n, scope *Node) bool {
+	start := p.pos
+	name := strings.ToLower(p.ident())
+	if p.peek() == '(' {
+		p.pos++
+		p.space()
+		f := p.pseudoFunc(name, start)
+		p.space()
+		if p.peek() != ')' {
+			p.fail("expected )")
+		}
+		p.pos++
+		return f
+	}
+	switch name {
+	case "root":
+		return func(n, _ *Node) bool { return n.Parent != nil && n.Parent.Type == DocumentNode }
+	case "scope":
+		return isScope
+	case "empty":
+		return func(n, _ *Node) bool {
+			for c := n.FirstChild; c != nil; c = c.NextSibling {
+				if c.Type == ElementNode || c.Type == TextNode && c.Data != "" {
+					return false
+				}
+			}
+			return true
+		}
+	case "first-child":
+		return func(n, _ *Node) bool { return prevElement(n) == nil }
+	case "last-child":
+		return func(n, _ *Node) bool { return nextElement(n) == nil }
+	case "only-child":
+		return func(n, _ *Node) bool { return prevElement(n) == nil && nextElement(n) == nil }
+	case "first-of-type":
+		return nth(0, 1, false, true, nil)
+	case "last-of-type":
+		return nth(0, 1, true, true, nil)
+	case "only-of-type":
+		first, last := nth(0, 1, false, true, nil), nth(0, 1, true, true, nil)
+		return func(n, scope *Node) bool { return first(n, scope) && last(n, scope) }
+	case "link", "any-link":
+		return func(n, _ *Node) bool {
+			_, ok := n.Get("href")
+			return ok && (n.Data == "a" || n.Data == "area" || n.Data == "link")
+		}
+	case "checked":
+		return func(n, _ *Node) bool {
+			if n.Data == "option" {
+				_, ok := n.Get("selected")
+				return ok
+			}
+			t, _ := n.Get("type")
+			_, ok := n.Get("checked")
+			return ok && n.Data == "input" && (strings.EqualFold(t, "checkbox") || strings.EqualFold(t, "radio"))
+		}
+	case "disabled", "enabled":
+		want := name == "disabled"
+		return func(n, _ *Node) bool {
+			_, ok := n.Get("disabled")
+			return formControls[n.Data] && ok == want
+		}
+	}
+	p.pos = start
+	p.fail("unsupported pseudo-class :%s", name)
+	return nil
+}
+
+func (p *selParser) pseudoFunc(name string, start int) func(n, scope *Node) bool {
+	switch name {
+	case "not", "is", "where", "matches":
+		list := p.list(false)
+		if name == "not" {
+			return func(n, scope *Node) bool { return !matchList(list, n, scope) }
+		}
+		return func(n, scope *Node) bool { return matchList(list, n, scope) }
+	case "has":
+		list := p.list(true)
+		return func(n, _ *Node) bool { return has(list, n) }
+	case "contains":
+		text := p.str()
+		return func(n, _ *Node) bool { return strings.Contains(n.Text(), text) }
+	case "nth-child", "nth-last-child", "nth-of-type", "nth-last-of-type":
+		a, b := p.anb()
+		var of []*complexSel
+		if strings.HasSuffix(name, "-child") && p.space() && strings.HasPrefix(strings.ToLower(p.src[p.pos:]), "of") {
+			p.pos += 2
+			if !p.space() {
+				p.fail("expected white space after of")
+			}
+			of = p.list(false)
+		}
+		return nth(a, b, strings.Contains(name, "last"), strings.HasSuffix(name, "-of-type"), of)
+	}
+	p.pos = start
+	p.fail("unsupported pseudo-class :%s()", name)
+	return nil
+}
+
+// anb parses the An+B notation.
+func (p *selParser) anb() (a, b int) {
+	start := p.pos
+	end := p.pos
+	for end < len(p.src) && strings.IndexByte("0123456789+-nNoOdDeEvV \t", p.src[end]) >= 0 {
+		if isSpace(p.src[end]) && end+2 < len(p.src) && strings.EqualFold(p.src[end+1:end+3], "of") {
+			break
+		}
+		end++
+	}
+	s := strings.ToLower(strings.Join(strings.Fields(p.src[start:end]), ""))
+	p.pos = end
+	bad := func() {
+		p.pos = start
+		p.fail("bad An+B expression %q", strings.TrimSpace(p.src[start:end]))
+	}
+	switch s {
+	case "odd":
+		return 2, 1
+	case "even":
+		return 2, 0
+	case "":
+		bad()
+	}
+	i := strings.IndexByte(s, 'n')
+	if i < 0 {
+		v, err := strconv.Atoi(s)
+		if err != nil {
+			bad()
+		}
+		return 0, v
+	}
+	switch s[:i] {
+	case "", "+":
+		a = 1
+	case "-":
+		a = -1
+	default:
+		v, err := strconv.Atoi(s[:i])
+		if err != nil {
+			bad()
+		}
+		a = v
+	}
+	if rest := s[i+1:]; rest != "" {
+		if rest[0] != '+' && rest[0] != '-' {
+			bad()
+		}
+		v, err := strconv.Atoi(rest)
+		if err != nil {
+			bad()
+		}
+		b = v
+	}
+	return a, b
+}
+
+// nth matches elements whose position among their siblings (counted
+// from the end if last, among those of the same type if ofType, or among
+// those matching of) is An+B for some n >= 0.
+func nth(a, b int, last, ofType bool, of []*complexSel) func(n, scope *Node) bool {
+	return func(n, scope *Node) bool {
+		if of != nil && !matchList(of, n, scope) {
+			return false
+		}
+		step := prevElement
+		if last {
+			step = nextElement
+		}
+		pos := 1
+		for s := step(n); s != nil; s = step(s) {
+			switch {
+			case ofType && s.Data != n.Data:
+			case of != nil && !matchList(of, s, scope):
+			default:
+				pos++
+			}
+		}
+		if a == 0 {
+			return pos == b
+		}
+		return (pos-b)%a == 0 && (pos-b)/a >= 0
+	}
+}
+
+// has reports whether any element matches a relative selector anchored
+// at n.
+func has(list []*complexSel, n *Node) bool {
+	root := n
+	for _, c := range list {
+		for _, comb := range c.combs {
+			if comb == '+' || comb == '~' {
+				if n.Parent != nil {
+					root = n.Parent
+				}
+			}
+		}
+	}
+	found := false
+	root.Walk(func(d *Node) bool {
+		if found {
+			return false
+		}
+		if d != n && d.Type == ElementNode && matchList(list, d, n) {
+			found = true
+		}
+		return true
+	})
+	return found
+}
@@ -0,0 +1,691 @@
+package htmltree
+
+import (
+	"fmt"
+	"io"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// TextOptions controls WriteText.
+type TextOptions struct {
+	// Markdown selects CommonMark output (with GitHub tables and
+	// strikethrough) instead of plain text.
+	Markdown bool
+	// Width wraps paragraphs at that many columns; 0 does not wrap.
+	Width int
+	// Base, if set, resolves relative link and image URLs.
+	Base *url.URL
+}
+
+// WriteText writes n as readable text: paragraphs separated by blank
+// lines, headings underlined, lists bulleted or numbered, quotations
+// prefixed with "> ", tables in aligned columns and the targets of
+// links collected as numbered references at the end. With Markdown set
+// it writes the equivalent Markdown instead, with links inline.
+//
+// Scripts, styles, the head, templates and hidden elements are left
+// out.
+func WriteText(w io.Writer, n *Node, opt TextOptions) error {
+	t := &textWriter{opt: opt, blank: -1, links: new([]string)}
+	t.node(n)
+	t.flush()
+	if links := *t.links; len(links) > 0 {
+		t.requestBlank()
+		t.emit("References:")
+		for i, l := range links {
+			t.emit(fmt.Sprintf("[%d] %s", i+1, l))
+		}
+	}
+	_, err := io.WriteString(w, t.out.String())
+	return err
+}
+
+var (
+	skipElements = set("head", "script", "style", "template", "iframe", "svg", "canvas",
+		"audio", "video", "input", "select", "datalist", "object", "embed", "noembed", "noframes")
+
+	// paraElements are blocks set off by blank lines.
+	paraElements = set("p", "figure", "details", "fieldset", "form", "address", "dl")
+
+	blockElements = set("article", "aside", "body", "caption", "center", "dialog", "dir",
+		"div", "figcaption", "footer", "header", "hgroup", "html", "legend", "main",
+		"menu", "nav", "option", "section", "summary", "tr")
+)
+
+type prefix struct {
+	first, rest string
+	used        bool
+}
+
+type listState struct {
+	ordered bool
+	n       int
+}
+
+// textWriter renders a tree as text. Inline content collects in line
+// until a block boundary, where it is wrapped and written with the
+// prefixes of the enclosing blocks (list markers, quotation marks).
+type textWriter struct {
+	opt      TextOptions
+	out      strings.Builder
+	line     []byte
+	prefixes []*prefix
+	lists    []*listState
+	blank    int       // prefix depth of a pending blank line, or -1
+	lines    int       // lines written
+	links    *[]string // link references, shared with cell writers
+	code     int       // inside code, where Markdown is not escaped
+}
+
+func (t *textWriter) node(n *Node) {
+	switch n.Type {
+	case DocumentNode:
+		t.children(n)
+	case TextNode:
+		t.text(n.Data)
+	case ElementNode:
+		t.element(n)
+	}
+}
+
+func (t *textWriter) children(n *Node) {
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		t.node(c)
+	}
+}
+
+func isHTMLSpace(r rune) bool {
+	return r == ' ' || r == '\t' || r == '\n' || r == '\f' || r == '\r'
+}
+
+// collapse replaces runs of white space with a single space.
+func collapse(s string) string {
+	var b strings.Builder
+	space := false
+	for _, r := range s {
+		if isHTMLSpace(r) {
+			space = true
+			continue
+		}
+		if space {
+			b.WriteByte(' ')
+			space = false
+		}
+		b.WriteRune(r)
+	}
+	if space {
+		b.WriteByte(' ')
+	}
+	return b.String()
+}
+
+func (t *textWriter) text(s string) {
+	s = collapse(s)
+	if t.opt.Markdown && t.code == 0 {
+		s = mdEscape(s)
+	}
+	if n := len(t.line); n == 0 || t.line[n-1] == ' ' || t.line[n-1] == '\n' {
+		s = strings.TrimPrefix(s, " ")
+	}
+	t.line = append(t.line, s...)
+}
+
+var mdEscaper = strings.NewReplacer(`\`, `\\`, "`", "\\`", "*", `\*`, "_", `\_`,
+	"[", `\[`, "]", `\]`, "<", `\<`, ">", `\>`)
+
+var entityLike = regexp.MustCompile(`&([A-Za-z0-9]+|#[0-9]+|#[xX][0-9a-fA-F]+);`)
+
+func mdEscape(s string) string {
+	s = mdEscaper.Replace(s)
+	return entityLike.ReplaceAllString(s, `\$0`)
+}
+
+// blockStart matches the starts of lines that Markdown would read as
+// block markup.
+var blockStart = regexp.MustCompile(`^(#{1,6}(\s|$)|[-+*] |[-=]+\s*$|\d{1,9}[.)](\s|$))`)
+
+func escapeLineStart(s string) string {
+	m := blockStart.FindString(s)
+	if m == "" {
+		return s
+	}
+	if i := strings.IndexAny(m, ".)"); i > 0 && m[0] >= '0' && m[0] <= '9' {
+		return s[:i] + `\` + s[i:]
+	}
+	return `\` + s
+}
+
+func (t *textWriter) requestBlank() {
+	if t.blank < 0 || len(t.prefixes) < t.blank {
+		t.blank = len(t.prefixes)
+	}
+}
+
+func (t *textWriter) prefix(depth int, blank bool) string {
+	var b strings.Builder
+	for _, p := range t.prefixes[:depth] {
+		switch {
+		case blank:
+			b.WriteString(p.rest)
+		case !p.used:
+			b.WriteString(p.first)
+			p.used = true
+		default:
+			b.WriteString(p.rest)
+		}
+	}
+	return b.String()
+}
+
+func (t *textWriter) prefixWidth() int {
+	w := 0
+	for _, p := range t.prefixes {
+		if p.used {
+			w += utf8.RuneCountInString(p.rest)
+		} else {
+			w += utf8.RuneCountInString(p.first)
+		}
+	}
+	return w
+}
+
+// emit writes one line with the current prefixes, after any pending
+// blank line.
+func (t *textWriter) emit(s string) {
+	if t.blank >= 0 && t.lines > 0 {
+		t.writeLine(t.prefix(t.blank, true))
+	}
+	t.blank = -1
+	t.writeLine(t.prefix(len(t.prefixes), false) + s)
+}
+
+func (t *textWriter) writeLine(s string) {
+	t.out.WriteString(strings.TrimRight(s, " "))
+	t.out.WriteByte('\n')
+	t.lines++
+}
+
+// flush writes the inline content collected so far as a paragraph.
+func (t *textWriter) flush() {
+	s := string(t.line)
+	t.line = t.line[:0]
+	if strings.TrimSpace(s) == "" {
+		return
+	}
+	segments := strings.Split(strings.TrimRight(s, " \n"), "\n")
+	for i, seg := range segments {
+		seg = strings.TrimSpace(seg)
+		if seg == "" {
+			continue
+		}
+		if t.opt.Markdown && i < len(segments)-1 {
+			seg += `\` // a hard line break
+		}
+		for _, l := range wrap(seg, t.opt.Width-t.prefixWidth()) {
+			if t.opt.Markdown {
+				l = escapeLineStart(l)
+			}
+			t.emit(l)
+		}
+	}
+}
+
+// wrap breaks s at spaces into lines of at most width runes, where it
+// can.
+func wrap(s string, width int) []string {
+	if width <= 0 || utf8.RuneCountInString(s) <= width {
+		return []string{s}
+	}
+	var lines []string
+	var cur strings.Builder
+	n := 0
+	for _, w := range strings.Split(s, " ") {
+		if w == "" {
+			continue
+		}
+		wn := utf8.RuneCountInString(w)
+		if n > 0 && n+1+wn > width {
+			lines = append(lines, cur.String())
+			cur.Reset()
+			n = 0
+		}
+		if n > 0 {
+			cur.WriteByte(' ')
+			n++
+		}
+		cur.WriteString(w)
+		n += wn
+	}
+	return append(lines, cur.String())
+}
+
+// boundary ends the inline content at the start or end of a block, and
+// asks for a blank line there if para is set.
+func (t *textWriter) boundary(para bool) {
+	t.flush()
+	if para {
+		t.requestBlank()
+	}
+}
+
+func (t *textWriter) push(first, rest string) *prefix {
+	p := &prefix{first: first, rest: rest}
+	t.prefixes = append(t.prefixes, p)
+	return p
+}
+
+func (t *textWriter) pop() {
+	t.prefixes = t.prefixes[:len(t.prefixes)-1]
+}
+
+// surround wraps the inline content written since pos in open and
+// close, outside any white space at its ends, or drops it if empty.
+func (t *textWriter) surround(pos int, open, close string) {
+	s := string(t.line[pos:])
+	inner := strings.TrimSpace(s)
+	t.line = t.line[:pos]
+	if inner == "" {
+		t.line = append(t.line, s...)
+		return
+	}
+	if strings.HasPrefix(s, " ") {
+		t.line = append(t.line, ' ')
+	}
+	t.line = append(t.line, open+inner+close...)
+	if strings.HasSuffix(s, " ") {
+		t.line = append(t.line, ' ')
+	}
+}
+
+func (t *textWriter) resolve(ref string) string {
+	ref = strings.TrimSpace(ref)
+	if t.opt.Base == nil {
+		return ref
+	}
+	u, err := t.opt.Base.Parse(ref)
+	if err != nil {
+		return ref
+	}
+	return u.String()
+}
+
+var mdURLEscaper = strings.NewReplacer(" ", "%20", "(", "%28", ")", "%29")
+
+func (t *textWriter) element(n *Node) {
+	if _, hidden := n.Get("hidden"); hidden || skipElements[n.Data] {
+		return
+	}
+	md := t.opt.Markdown
+	switch name := n.Data; {
+	case name == "br":
+		t.line = append(t.line, '\n')
+	case headings[name]:
+		t.boundary(true)
+		t.children(n)
+		s := strings.TrimSpace(collapse(strings.ReplaceAll(string(t.line), "\n", " ")))
+		t.line = t.line[:0]
+		if s != "" {
+			level := int(name[1] - '0')
+			if md {
+				t.emit(strings.Repeat("#", level) + " " + s)
+			} else {
+				lines := wrap(s, t.opt.Width-t.prefixWidth())
+				width := 0
+				for _, l := range lines {
+					t.emit(l)
+					if n := utf8.RuneCountInString(l); n > width {
+						width = n
+					}
+				}
+				switch level {
+				case 1:
+					t.emit(strings.Repeat("=", width))
+				case 2:
+					t.emit(strings.Repeat("-", width))
+				}
+			}
+		}
+		t.boundary(true)
+	case name == "ul" || name == "ol":
+		nested := len(t.lists) > 0
+		t.boundary(!nested)
+		ls := &listState{ordered: name == "ol", n: 1}
+		if v, err := strconv.Atoi(attr(n, "start")); err == nil {
+			ls.n = v
+		}
+		t.lists = append(t.lists, ls)
+		t.children(n)
+		t.lists = t.lists[:len(t.lists)-1]
+		t.boundary(!nested)
+	case name == "li":
+		t.flush()
+		marker := "* "
+		if md {
+			marker = "- "
+		}
+		if len(t.lists) > 0 {
+			if ls := t.lists[len(t.lists)-1]; ls.ordered {
+				if v, err := strconv.Atoi(attr(n, "value")); err == nil {
+					ls.n = v
+				}
+				marker = strconv.Itoa(ls.n) + ". "
+				ls.n++
+			}
+		}
+		p := t.push(marker, strings.Repeat(" ", len(marker)))
+		t.children(n)
+		t.flush()
+		if !p.used {
+			t.emit("")
+		}
+		t.pop()
+	case name == "blockquote":
+		t.boundary(true)
+		t.push("> ", "> ")
+		t.children(n)
+		t.flush()
+		t.pop()
+		t.boundary(true)
+	case name == "pre" || name == "listing" || name == "xmp" || name == "plaintext":
+		t.boundary(true)
+		t.pre(n)
+		t.boundary(true)
+	case name == "hr":
+		t.boundary(true)
+		if md {
+			t.emit("---")
+		} else {
+			w := t.opt.Width - t.prefixWidth()
+			if w <= 0 {
+				w = 40
+			}
+			t.emit(strings.Repeat("-", w))
+		}
+		t.boundary(true)
+	case name == "table":
+		t.boundary(true)
+		t.table(n)
+		t.boundary(true)
+	case name == "dt":
+		t.flush()
+		pos := len(t.line)
+		t.children(n)
+		if md {
+			t.surround(pos, "**", "**")
+		}
+		t.flush()
+	case name == "dd":
+		t.flush()
+		first := "    "
+		if md {
+			first = ":   "
+		}
+		t.push(first, "    ")
+		t.children(n)
+		t.flush()
+		t.pop()
+	case paraElements[name]:
+		t.boundary(true)
+		t.children(n)
+		t.boundary(true)
+	case blockElements[name]:
+		t.boundary(false)
+		t.children(n)
+		t.boundary(false)
+	case name == "a":
+		t.link(n)
+	case name == "img":
+		alt, src := attr(n, "alt"), attr(n, "src")
+		if md && src != "" {
+			t.text(" ")
+			t.line = append(t.line, "!["+mdEscape(collapse(alt))+"]("+mdURLEscaper.Replace(t.resolve(src))+")"...)
+		} else {
+			t.text(alt)
+		}
+	case name == "q":
+		pos := len(t.line)
+		t.children(n)
+		t.surround(pos, `"`, `"`)
+	case md && (name == "strong" || name == "b"):
+		pos := len(t.line)
+		t.children(n)
+		t.surround(pos, "**", "**")
+	case md && (name == "em" || name == "i" || name == "cite" || name == "var"):
+		pos := len(t.line)
+		t.children(n)
+		t.surround(pos, "*", "*")
+	case md && (name == "del" || name == "s" || name == "strike"):
+		pos := len(t.line)
+		t.children(n)
+		t.surround(pos, "~~", "~~")
+	case md && (name == "code" || name == "kbd" || name == "samp" || name == "tt"):
+		pos := len(t.line)
+		t.code++
+		t.children(n)
+		t.code--
+		s := string(t.line[pos:])
+		fence := strings.Repeat("`", longestRun(s, '`')+1)
+		inner := strings.TrimSpace(s)
+		if strings.HasPrefix(inner, "`") || strings.HasSuffix(inner, "`") {
+			fence = fence + " "
+		}
+		t.surround(pos, fence, reverse(fence))
+	default:
+		t.children(n)
+	}
+}
+
+func attr(n *Node, key string) string {
+	v, _ := n.Get(key)
+	return v
+}
+
+func reverse(s string) string {
+	b := []byte(s)
+	for i, j := 0, len(b)-1; i < j; i, j = i+1, j-1 {
+		b[i], b[j] = b[j], b[i]
+	}
+	return string(b)
+}
+
+func longestRun(s string, c byte) int {
+	best, n := 0, 0
+	for i := 0; i < len(s); i++ {
+		if s[i] == c {
+			n++
+			if n > best {
+				best = n
+			}
+		} else {
+			n = 0
+		}
+	}
+	return best
+}
+
+func (t *textWriter) link(n *Node) {
+	href, ok := n.Get("href")
+	pos := len(t.line)
+	t.children(n)
+	if !ok {
+		return
+	}
+	href = t.resolve(href)
+	inner := strings.TrimSpace(string(t.line[pos:]))
+	if t.opt.Markdown {
+		if inner == "" || inner == mdEscape(href) && !strings.ContainsAny(href, " <>") {
+			t.line = t.line[:pos]
+			t.text(" ")
+			t.line = append(t.line, "<"+href+">"...)
+			return
+		}
+		t.surround(pos, "[", "]("+mdURLEscaper.Replace(href)+")")
+		return
+	}
+	lower := strings.ToLower(href)
+	if href == "" || strings.HasPrefix(href, "#") || strings.HasPrefix(lower, "javascript:") || inner == href {
+		return
+	}
+	*t.links = append(*t.links, href)
+	ref := fmt.Sprintf("[%d]", len(*t.links))
+	if inner == "" {
+		t.text(" ")
+		t.line = append(t.line, ref...)
+		return
+	}
+	t.surround(pos, "", " "+ref)
+}
+
+// pre writes preformatted text as it is, fenced in Markdown.
+func (t *textWriter) pre(n *Node) {
+	s := strings.TrimSuffix(n.Text(), "\n")
+	lines := strings.Split(s, "\n")
+	if !t.opt.Markdown {
+		for _, l := range lines {
+			t.emit(strings.ReplaceAll(l, "\t", "    "))
+		}
+		return
+	}
+	lang := ""
+	for _, e := range []*Node{n, n.FirstChild} {
+		if e == nil || e.Type != ElementNode {
+			continue
+		}
+		for _, c := range strings.Fields(attr(e, "class")) {
+			for _, p := range []string{"language-", "lang-"} {
+				if strings.HasPrefix(c, p) && lang == "" {
+					lang = c[len(p):]
+				}
+			}
+		}
+	}
+	fence := strings.Repeat("`", max(3, longestRun(s, '`')+1))
+	t.emit(fence + lang)
+	for _, l := range lines {
+		t.emit(l)
+	}
+	t.emit(fence)
+}
+
+// inline renders the contents of n as a single line.
+func (t *textWriter) inline(n *Node) string {
+	sub := &textWriter{opt: t.opt, blank: -1, links: t.links}
+	sub.opt.Width = 0
+	sub.children(n)
+	sub.flush()
+	return strings.Join(strings.Fields(sub.out.String()), " ")
+}
+
+// table writes a table as aligned columns, or a GitHub table in
+// Markdown, whose first row is the header.
+func (t *textWriter) table(n *Node) {
+	var rows [][]string
+	header := false
+	var addRow func(tr *Node, head bool)
+	addRow = func(tr *Node, head bool) {
+		var row []string
+		allTH := true
+		for c := tr.FirstChild; c != nil; c = c.NextSibling {
+			if c.Type != ElementNode || c.Data != "td" && c.Data != "th" {
+				continue
+			}
+			allTH = allTH && c.Data == "th"
+			cell := t.inline(c)
+			if t.opt.Markdown {
+				cell = strings.ReplaceAll(cell, "|", `\|`)
+			}
+			row = append(row, cell)
+			if span, err := strconv.Atoi(attr(c, "colspan")); err == nil {
+				for i := 1; i < span && i < 1000; i++ {
+					row = append(row, "")
+				}
+			}
+		}
+		if len(rows) == 0 && row != nil && (head || allTH) {
+			header = true
+		}
+		if row != nil {
+			rows = append(rows, row)
+		}
+	}
+	var caption string
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		if c.Type != ElementNode {
+			continue
+		}
+		switch c.Data {
+		case "caption":
+			caption = t.inline(c)
+		case "tr":
+			addRow(c, false)
+		case "thead", "tbody", "tfoot":
+			for r := c.FirstChild; r != nil; r = r.NextSibling {
+				if r.Type == ElementNode && r.Data == "tr" {
+					addRow(r, c.Data == "thead")
+				}
+			}
+		}
+	}
+	if caption != "" {
+		t.emit(caption)
+	}
+	if len(rows) == 0 {
+		return
+	}
+	cols := 0
+	for _, r := range rows {
+		cols = max(cols, len(r))
+	}
+	widths := make([]int, cols)
+	for _, r := range rows {
+		for i, c := range r {
+			widths[i] = max(widths[i], utf8.RuneCountInString(c))
+		}
+	}
+	if t.opt.Markdown {
+		for i := range widths {
+			widths[i] = max(widths[i], 3)
+		}
+	}
+	pad := func(s string, w int) string {
+		return s + strings.Repeat(" ", w-utf8.RuneCountInString(s))
+	}
+	for i, r := range rows {
+		cells := make([]string, cols)
+		for j := range cells {
+			if j < len(r) {
+				cells[j] = pad(r[j], widths[j])
+			} else {
+				cells[j] = pad("", widths[j])
+			}
+		}
+		rules := make([]string, cols)
+		for j := range rules {
+			rules[j] = strings.Repeat("-", widths[j])
+		}
+		if t.opt.Markdown {
+			if i == 0 && !header {
+				// GitHub tables need a header row.
+				empty := make([]string, cols)
+				for j := range empty {
+					empty[j] = pad("", widths[j])
+				}
+				t.emit("| " + strings.Join(empty, " | ") + " |")
+				t.emit("| " + strings.Join(rules, " | ") + " |")
+			}
+			t.emit("| " + strings.Join(cells, " | ") + " |")
+			if i == 0 && header {
+				t.emit("| " + strings.Join(rules, " | ") + " |")
+			}
+			continue
+		}
+		t.emit(strings.Join(cells, "  "))
+		if i == 0 && header {
+			t.emit(strings.Join(rules, "  "))
+		}
+	}
+}
@@ -0,0 +1,351 @@
+// Package htmltree parses HTML the way browsers do, following the HTML5
+// tokenizer and a simplified tree builder, and provides CSS selectors,
+// serialization and conversion to readable text or Markdown.
+//
+// The tree builder implements implied tags (html, head, body, tbody),
+// implied end tags (p, li, dd, dt, option, table cells), void and raw
+// text elements, and end tag recovery. It does not reparent misnested
+// formatting elements (the adoption agency algorithm) or foster-parent
+// text found directly inside tables.
+package htmltree
+
+import (
+	"bytes"
+	"html"
+	"strings"
+)
+
+type tokenType int
+
+const (
+	textToken tokenType = iota
+	startTagToken
+	endTagToken
+	selfClosingTagToken
+	commentToken
+	doctypeToken
+)
+
+type token struct {
+	typ  tokenType
+	data string // tag name (lower case), text, comment or doctype
+	attr []Attr
+}
+
+// rawText lists the elements whose content is not markup; the value
+// tells whether character references are decoded in it.
+var rawText = map[string]bool{
+	"script":    false,
+	"style":     false,
+	"xmp":       false,
+	"iframe":    false,
+	"noembed":   false,
+	"noframes":  false,
+	"plaintext": false,
+	"title":     true,
+	"textarea":  true,
+}
+
+// tokenizer splits HTML into tokens.
+type tokenizer struct {
+	src []byte
+	pos int
+	raw string // the raw text element being read, set by the parser
+}
+
+func isSpace(c byte) bool {
+	return c == ' ' || c == '\t' || c == '\n' || c == '\f' || c == '\r'
+}
+
+func isAlpha(c byte) bool { return c|0x20 >= 'a' && c|0x20 <= 'z' }
+
+// next returns the next token; ok is false at the end of the input.
+func (z *tokenizer) next() (t token, ok bool) {
+	if z.pos >= len(z.src) {
+		return t, false
+	}
+	if z.raw != "" {
+		return z.rawText(), true
+	}
+	src := z.src[z.pos:]
+	if src[0] == '<' && len(src) > 1 {
+		switch c := src[1]; {
+		case isAlpha(c):
+			return z.tag(false), true
+		case c == '/':
+			if len(src) > 2 && isAlpha(src[2]) {
+				return z.tag(true), true
+			}
+			if len(src) > 2 && src[2] == '>' {
+				z.pos += 3 // </> is dropped
+				return z.next()
+			}
+			return z.bogusComment(2), true
+		case c == '!':
+			return z.markup(), true
+		case c == '?':
+			return z.bogusComment(1), true
+		}
+	}
+	// Text runs to the next < that may start markup.
+	end := 1
+	for end < len(src) {
+		i := bytes.IndexByte(src[end:], '<')
+		if i < 0 {
+			end = len(src)
+			break
+		}
+		end += i
+		if end+1 < len(src) && (isAlpha(src[end+1]) || strings.IndexByte("/!?", src[end+1]) >= 0) {
+			break
+		}
+		end++
+	}
+	z.pos += end
+	return token{typ: textToken, data: html.UnescapeString(normalizeNewlines(string(src[:end])))}, true
+}
+
+func normalizeNewlines(s string) string {
+	if strings.IndexByte(s, '\r') < 0 {
+		return s
+	}
+	return strings.ReplaceAll(strings.ReplaceAll(s, "\r\n", "\n"), "\r", "\n")
+}
+
+// rawText reads the content of a raw text element up to its end tag.
+func (z *tokenizer) rawText() token {
+	src := z.src[z.pos:]
+	end := len(src)
+	if z.raw != "plaintext" {
+		for i := 0; i+2+len(z.raw) <= len(src); i++ {
+			j := bytes.Index(src[i:], []byte("</"))
+			if j < 0 {
+				break
+			}
+			i += j
+			k := i + 2 + len(z.raw)
+			if k <= len(src) && strings.EqualFold(string(src[i+2:k]), z.raw) && (k == len(src) || isSpace(src[k]) || src[k] == '/' || src[k] == '>') {
+				end = i
+				break
+			}
+		}
+	}
+	text := normalizeNewlines(string(src[:end]))
+	if rawText[z.raw] {
+		text = html.UnescapeString(text)
+	}
+	z.pos += end
+	z.raw = ""
+	if end == 0 {
+		t, _ := z.next()
+		return t
+	}
+	return token{typ: textToken, data: text}
+}
+
+// tag reads a start or end tag at z.pos.
+func (z *tokenizer) tag(end bool) token {
+	i := z.pos + 1
+	if end {
+		i++
+	}
+	start := i
+	for i < len(z.src) && !isSpace(z.src[i]) && z.src[i] != '/' && z.src[i] != '>' {
+		i++
+	}
+	t := token{typ: startTagToken, data: strings.ToLower(string(z.src[start:i]))}
+	if end {
+		t.typ = endTagToken
+	}
+	for {
+		for i < len(z.src) && (isSpace(z.src[i]) || z.src[i] == '/') {
+			if z.src[i] == '/' && i+1 < len(z.src) && z.src[i+1] == '>' {
+				if t.typ == startTagToken {
+					t.typ = selfClosingTagToken
+				}
+				i++
+				break
+			}
+			i++
+		}
+		if i >= len(z.src) {
+			// A tag cut off by the end of the input is dropped.
+			z.pos = len(z.src)
+			return token{typ: textToken}
+		}
+		if z.src[i] == '>' {
+			i++
+			break
+		}
+		// An attribute name runs to space, /, > or =; a leading = is part
+		// of it.
+		nameStart := i
+		i++
+		for i < len(z.src) && !isSpace(z.src[i]) && z.src[i] != '/' && z.src[i] != '>' && z.src[i] != '=' {
+			i++
+		}
+		a := Attr{Key: strings.ToLower(string(z.src[nameStart:i]))}
+		j := i
+		for j < len(z.src) && isSpace(z.src[j]) {
+			j++
+		}
+		if j < len(z.src) && z.src[j] == '=' {
+			j++
+			for j < len(z.src) && isSpace(z.src[j]) {
+				j++
+			}
+			var val string
+			switch {
+			case j < len(z.src) && (z.src[j] == '"' || z.src[j] == '\''):
+				q := z.src[j]
+				k := bytes.IndexByte(z.src[j+1:], q)
+				if k < 0 {
+					z.pos = len(z.src)
+					return token{typ: textToken}
+				}
+				val = string(z.src[j+1 : j+1+k])
+				j += k + 2
+			default:
+				k := j
+				for k < len(z.src) && !isSpace(z.src[k]) && z.src[k] != '>' {
+					k++
+				}
+				val = string(z.src[j:k])
+				j = k
+			}
+			a.Val = unescapeAttr(normalizeNewlines(val))
+			i = j
+		}
+		if !end && !hasAttr(t.attr, a.Key) {
+			t.attr = append(t.attr, a)
+		}
+	}
+	z.pos = i
+	return t
+}
+
+func hasAttr(attrs []Attr, key string) bool {
+	for _, a := range attrs {
+		if a.Key == key {
+			return true
+		}
+	}
+	return false
+}
+
+// unescapeAttr decodes character references in an attribute value. A
+// named reference without its semicolon is left alone when a letter,
+// digit or = follows it, as in href="?a=1&copy=2".
+func unescapeAttr(s string) string {
+	if strings.IndexByte(s, '&') < 0 {
+		return s
+	}
+	var b strings.Builder
+	for {
+		i := strings.IndexByte(s, '&')
+		if i < 0 {
+			b.WriteString(s)
+			return b.String()
+		}
+		b.WriteString(s[:i])
+		s = s[i:]
+		j := 1
+		for j < len(s) && (isAlpha(s[j]) || s[j] >= '0' && s[j] <= '9') {
+			j++
+		}
+		switch {
+		case j < len(s) && s[j] == ';':
+			b.WriteString(html.UnescapeString(s[:j+1]))
+			s = s[j+1:]
+		case j > 1 && (j == len(s) || s[j] != '='):
+			u := html.UnescapeString(s[:j])
+			if u == s[:j] || !decodedWhole(u, s[:j]) {
+				b.WriteString(s[:j])
+			} else {
+				b.WriteString(u)
+			}
+			s = s[j:]
+		default:
+			if len(s) > 1 && s[1] == '#' {
+				k := 2
+				for k < len(s) && (s[k] == 'x' || s[k] == 'X' || s[k] >= '0' && s[k] <= '9' || isAlpha(s[k])) {
+					k++
+				}
+				if k < len(s) && s[k] == ';' {
+					k++
+				}
+				b.WriteString(html.UnescapeString(s[:k]))
+				s = s[k:]
+				continue
+			}
+			b.WriteString(s[:j])
+			s = s[j:]
+		}
+	}
+}
+
+// decodedWhole reports whether unescaping ref, a reference without a
+// semicolon, used up all of its name, rather than a shorter legacy name
+// followed by more letters.
+func decodedWhole(u, ref string) bool {
+	for k := 1; k < len(ref); k++ {
+		if strings.HasSuffix(u, ref[k:]) && html.UnescapeString(ref[:k]) != ref[:k] {
+			return false
+		}
+	}
+	return true
+}
+
+// markup reads <!-- comments -->, <!DOCTYPE> and <![CDATA[ sections.
+func (z *tokenizer) markup() token {
+	src := z.src[z.pos:]
+	switch {
+	case bytes.HasPrefix(src, []byte("<!--")):
+		body := src[4:]
+		// <!--> and <!---> are empty comments.
+		for _, empty := range []string{">", "->"} {
+			if bytes.HasPrefix(body, []byte(empty)) {
+				z.pos += 4 + len(empty)
+				return token{typ: commentToken}
+			}
+		}
+		end, close := bytes.Index(body, []byte("-->")), 3
+		if bang := bytes.Index(body, []byte("--!>")); bang >= 0 && (end < 0 || bang < end) {
+			end, close = bang, 4
+		}
+		if end < 0 {
+			z.pos = len(z.src)
+			return token{typ: commentToken, data: string(body)}
+		}
+		z.pos += 4 + end + close
+		return token{typ: commentToken, data: string(body[:end])}
+	case len(src) >= 9 && strings.EqualFold(string(src[2:9]), "doctype"):
+		end := bytes.IndexByte(src, '>')
+		if end < 0 {
+			end = len(src) - 1
+		}
+		z.pos += end + 1
+		return token{typ: doctypeToken, data: strings.TrimSpace(string(src[9:end]))}
+	case bytes.HasPrefix(src, []byte("<![CDATA[")):
+		end := bytes.Index(src, []byte("]]>"))
+		if end < 0 {
+			z.pos = len(z.src)
+			return token{typ: textToken, data: string(src[9:])}
+		}
+		z.pos += end + 3
+		return token{typ: textToken, data: string(src[9:end])}
+	}
+	return z.bogusComment(2)
+}
+
+// bogusComment reads <?...> and other malformed markup as a comment.
+func (z *tokenizer) bogusComment(skip int) token {
+	src := z.src[z.pos:]
+	end := bytes.IndexByte(src, '>')
+	if end < 0 {
+		z.pos = len(z.src)
+		return token{typ: commentToken, data: string(src[skip:])}
+	}
+	z.pos += end + 1
+	return token{typ: commentToken, data: string(src[skip:end])}
+}
@@ -0,0 +1,300 @@
+package jsonpatch
+
+import (
+	"encoding/json"
+	"strconv"
+)
+
+// EditKind classifies one step of an array edit script.
+type EditKind int
+
+const (
+	Keep   EditKind = iota // A and B are the same element (perhaps changed)
+	Delete                 // A is not in b
+	Insert                 // B is not in a
+)
+
+// Edit is one step of an array edit script; A and B index a and b.
+type Edit struct {
+	Kind EditKind
+	A, B int
+}
+
+// lcsLimit bounds the LCS table; larger arrays are compared by position.
+const lcsLimit = 4 << 20
+
+// KeyOf returns the string form of element e's key member, if it has one.
+func KeyOf(e interface{}, key string) (string, bool) {
+	m, ok := e.(map[string]interface{})
+	if !ok {
+		return "", false
+	}
+	v, ok := m[key]
+	if !ok {
+		return "", false
+	}
+	if s, ok := v.(string); ok {
+		return "s" + s, true
+	}
+	b, _ := json.Marshal(v)
+	return "j" + string(b), true
+}
+
+// keyed indexes a by key, failing if any element lacks the key or two
+// share it.
+func keyed(a []interface{}, key string) (map[string]int, bool) {
+	idx := make(map[string]int, len(a))
+	for i, e := range a {
+		k, ok := KeyOf(e, key)
+		if !ok {
+			return nil, false
+		}
+		if _, dup := idx[k]; dup {
+			return nil, false
+		}
+		idx[k] = i
+	}
+	return idx, true
+}
+
+// ByKey reports whether ArrayEdits and Diff can pair a and b by key: every
+// element of both is an object with a distinct value of that member.
+func ByKey(a, b []interface{}, key string) bool {
+	if key == "" {
+		return false
+	}
+	_, aok := keyed(a, key)
+	_, bok := keyed(b, key)
+	return aok && bok
+}
+
+// ArrayEdits matches the elements of a and b. With key, and when every
+// element of both is an object with a distinct key member, elements are
+// paired by that member and the script lists deletions first and then b
+// in order. Otherwise it is a longest-common-subsequence script of equal
+// elements.
+func ArrayEdits(a, b []interface{}, key string) []Edit {
+	if ByKey(a, b, key) {
+		ai, _ := keyed(a, key)
+		bi, _ := keyed(b, key)
+		var edits []Edit
+		for i, e := range a {
+			if k, _ := KeyOf(e, key); !hasKey(bi, k) {
+				edits = append(edits, Edit{Delete, i, -1})
+			}
+		}
+		for j, e := range b {
+			k, _ := KeyOf(e, key)
+			if i, ok := ai[k]; ok {
+				edits = append(edits, Edit{Keep, i, j})
+			} else {
+				edits = append(edits, Edit{Insert, -1, j})
+			}
+		}
+		return edits
+	}
+
+	var edits []Edit
+	// Common ends need no table.
+	pre := 0
+	for pre < len(a) && pre < len(b) && Equal(a[pre], b[pre]) {
+		edits = append(edits, Edit{Keep, pre, pre})
+		pre++
+	}
+	suf := 0
+	for suf < len(a)-pre && suf < len(b)-pre && Equal(a[len(a)-1-suf], b[len(b)-1-suf]) {
+		suf++
+	}
+	n, m := len(a)-pre-suf, len(b)-pre-suf
+	if n*m > lcsLimit {
+		for k := 0; k < n || k < m; k++ {
+			switch {
+			case k < n && k < m:
+				edits = append(edits, Edit{Keep, pre + k, pre + k})
+			case k < n:
+				edits = append(edits, Edit{Delete, pre + k, -1})
+			default:
+				edits = append(edits, Edit{Insert, -1, pre + k})
+			}
+		}
+	} else {
+		// lcs[i][j] is the LCS length of a[pre+i:] and b[pre+j:].
+		lcs := make([][]int32, n+1)
+		for i := range lcs {
+			lcs[i] = make([]int32, m+1)
+		}
+		for i := n - 1; i >= 0; i-- {
+			for j := m - 1; j >= 0; j-- {
+				if Equal(a[pre+i], b[pre+j]) {
+					lcs[i][j] = lcs[i+1][j+1] + 1
+				} else if lcs[i+1][j] >= lcs[i][j+1] {
+					lcs[i][j] = lcs[i+1][j]
+				} else {
+					lcs[i][j] = lcs[i][j+1]
+				}
+			}
+		}
+		i, j := 0, 0
+		for i < n || j < m {
+			switch {
+			case i < n && j < m && Equal(a[pre+i], b[pre+j]):
+				edits = append(edits, Edit{Keep, pre + i, pre + j})
+				i++
+				j++
+			case j < m && (i == n || lcs[i][j+1] > lcs[i+1][j]):
+				edits = append(edits, Edit{Insert, -1, pre + j})
+				j++
+			default:
+				edits = append(edits, Edit{Delete, pre + i, -1})
+				i++
+			}
+		}
+	}
+	for k := suf; k > 0; k-- {
+		edits = append(edits, Edit{Keep, len(a) - k, len(b) - k})
+	}
+	return edits
+}
+
+func hasKey(m map[string]int, k string) bool {
+	_, ok := m[k]
+	return ok
+}
+
+// DiffOptions tunes Diff.
+type DiffOptions struct {
+	// ArrayKey pairs array elements by this object member rather than by
+	// position, so reordered elements become moves.
+	ArrayKey string
+}
+
+// Diff returns a JSON Patch that turns a into b.
+func Diff(a, b interface{}, opt DiffOptions) []Operation {
+	d := &differ{opt: opt}
+	d.diff("", a, b)
+	return d.ops
+}
+
+type differ struct {
+	opt DiffOptions
+	ops []Operation
+}
+
+func (d *differ) emit(op, path string, v interface{}) {
+	d.ops = append(d.ops, Operation{Op: op, Path: path, Value: Clone(v)})
+}
+
+func (d *differ) diff(path string, a, b interface{}) {
+	if Equal(a, b) {
+		return
+	}
+	switch x := a.(type) {
+	case map[string]interface{}:
+		if y, ok := b.(map[string]interface{}); ok {
+			for _, k := range sortedKeys(x) {
+				if _, ok := y[k]; !ok {
+					d.emit("remove", Join(path, k), nil)
+				}
+			}
+			for _, k := range sortedKeys(y) {
+				if xv, ok := x[k]; ok {
+					d.diff(Join(path, k), xv, y[k])
+				} else {
+					d.emit("add", Join(path, k), y[k])
+				}
+			}
+			return
+		}
+	case []interface{}:
+		if y, ok := b.([]interface{}); ok {
+			d.diffArray(path, x, y)
+			return
+		}
+	}
+	d.emit("replace", path, b)
+}
+
+func (d *differ) diffArray(path string, a, b []interface{}) {
+	at := func(i int) string { return path + "/" + strconv.Itoa(i) }
+	if ByKey(a, b, d.opt.ArrayKey) {
+		d.diffKeyed(path, a, b, d.opt.ArrayKey)
+		return
+	}
+	edits := ArrayEdits(a, b, "")
+	idx := 0 // position in the array as patched so far
+	for k := 0; k < len(edits); {
+		if edits[k].Kind == Keep {
+			d.diff(at(idx), a[edits[k].A], b[edits[k].B])
+			idx++
+			k++
+			continue
+		}
+		// A run of deletions and insertions between kept elements: pair
+		// them up as changes, then remove or add the rest.
+		var dels, ins []int
+		for ; k < len(edits) && edits[k].Kind != Keep; k++ {
+			if edits[k].Kind == Delete {
+				dels = append(dels, edits[k].A)
+			} else {
+				ins = append(ins, edits[k].B)
+			}
+		}
+		n := 0
+		for ; n < len(dels) && n < len(ins); n++ {
+			d.diff(at(idx), a[dels[n]], b[ins[n]])
+			idx++
+		}
+		for range dels[n:] {
+			d.emit("remove", at(idx), nil)
+		}
+		for _, j := range ins[n:] {
+			d.emit("add", at(idx), b[j])
+			idx++
+		}
+	}
+}
+
+// diffKeyed turns a into b by key: removals from the end first so indexes
+// stay valid, then b is built up in order with moves and adds.
+func (d *differ) diffKeyed(path string, a, b []interface{}, key string) {
+	at := func(i int) string { return path + "/" + strconv.Itoa(i) }
+	inB := map[string]bool{}
+	for _, e := range b {
+		k, _ := KeyOf(e, key)
+		inB[k] = true
+	}
+	var cur []interface{}
+	for i := len(a) - 1; i >= 0; i-- {
+		if k, _ := KeyOf(a[i], key); !inB[k] {
+			d.emit("remove", at(i), nil)
+		}
+	}
+	for _, e := range a {
+		if k, _ := KeyOf(e, key); inB[k] {
+			cur = append(cur, e)
+		}
+	}
+	for i, e := range b {
+		k, _ := KeyOf(e, key)
+		j := -1
+		for n := i; n < len(cur); n++ {
+			if ck, _ := KeyOf(cur[n], key); ck == k {
+				j = n
+				break
+			}
+		}
+		switch {
+		case j == i:
+		case j > i:
+			d.ops = append(d.ops, Operation{Op: "move", From: at(j), Path: at(i)})
+			moved := cur[j]
+			cur = append(cur[:j], cur[j+1:]...)
+			cur = append(cur[:i], append([]interface{}{moved}, cur[i:]...)...)
+		default:
+			d.emit("add", at(i), e)
+			cur = append(cur[:i], append([]interface{}{e}, cur[i:]...)...)
+			continue
+		}
+		d.diff(at(i), cur[i], e)
+	}
+}
@@ -0,0 +1,64 @@
+package jsonpatch
+
+// MergePatch applies an RFC 7386 merge patch to a copy of target: members
+// of an object patch are merged recursively, null members delete, and any
+// other patch value replaces the target.
+func MergePatch(target, patch interface{}) interface{} {
+	p, ok := patch.(map[string]interface{})
+	if !ok {
+		return Clone(patch)
+	}
+	t, ok := target.(map[string]interface{})
+	if !ok {
+		t = map[string]interface{}{}
+	} else {
+		t = Clone(t).(map[string]interface{})
+	}
+	for k, v := range p {
+		if v == nil {
+			delete(t, k)
+		} else {
+			t[k] = MergePatch(t[k], v)
+		}
+	}
+	return t
+}
+
+// CreateMergePatch returns a merge patch that turns a into b. Arrays are
+// replaced whole. A merge patch cannot set a member to null, so exact is
+// false when b has a null that a lacks.
+func CreateMergePatch(a, b interface{}) (patch interface{}, exact bool) {
+	am, aok := a.(map[string]interface{})
+	bm, bok := b.(map[string]interface{})
+	if !aok || !bok {
+		return Clone(b), true
+	}
+	out := map[string]interface{}{}
+	exact = true
+	for k := range am {
+		if _, ok := bm[k]; !ok {
+			out[k] = nil
+		}
+	}
+	for _, k := range sortedKeys(bm) {
+		bv := bm[k]
+		av, had := am[k]
+		switch {
+		case had && Equal(av, bv):
+		case bv == nil:
+			// null would delete the member instead.
+			exact = false
+		default:
+			_, amap := av.(map[string]interface{})
+			_, bmap := bv.(map[string]interface{})
+			if had && amap && bmap {
+				sub, ok := CreateMergePatch(av, bv)
+				out[k] = sub
+				exact = exact && ok
+			} else {
+				out[k] = Clone(bv)
+			}
+		}
+	}
+	return out, exact
+}
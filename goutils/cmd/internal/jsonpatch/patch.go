@@ -0,0 +1,270 @@
+package jsonpatch
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// Operation is one JSON Patch operation.
+type Operation struct {
+	Op    string
+	Path  string
+	From  string      // move and copy
+	Value interface{} // add, replace and test
+}
+
+// MarshalJSON writes the members the operation uses, in the order of the
+// RFC's examples.
+func (o Operation) MarshalJSON() ([]byte, error) {
+	var buf bytes.Buffer
+	op, _ := json.Marshal(o.Op)
+	path, _ := json.Marshal(o.Path)
+	buf.WriteString(`{"op":` + string(op) + `,`)
+	if o.Op == "move" || o.Op == "copy" {
+		from, _ := json.Marshal(o.From)
+		buf.WriteString(`"from":` + string(from) + `,`)
+	}
+	buf.WriteString(`"path":` + string(path))
+	if o.Op == "add" || o.Op == "replace" || o.Op == "test" {
+		v, err := json.Marshal(o.Value)
+		if err != nil {
+			return nil, err
+		}
+		buf.WriteString(`,"value":` + string(v))
+	}
+	buf.WriteByte('}')
+	return buf.Bytes(), nil
+}
+
+func (o Operation) String() string {
+	if o.From != "" {
+		return fmt.Sprintf("%s %s -> %s", o.Op, o.From, o.Path)
+	}
+	return fmt.Sprintf("%s %s", o.Op, o.Path)
+}
+
+// Decode parses a JSON Patch document: an array of operation objects.
+func Decode(data []byte) ([]Operation, error) {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	var raw []map[string]json.RawMessage
+	if err := dec.Decode(&raw); err != nil {
+		return nil, fmt.Errorf("not a JSON Patch array: %w", err)
+	}
+	ops := make([]Operation, len(raw))
+	for i, m := range raw {
+		str := func(name string, required bool) (string, error) {
+			r, ok := m[name]
+			if !ok {
+				if required {
+					return "", fmt.Errorf("operation %d: missing %q", i, name)
+				}
+				return "", nil
+			}
+			var s string
+			if err := json.Unmarshal(r, &s); err != nil {
+				return "", fmt.Errorf("operation %d: %q must be a string", i, name)
+			}
+			return s, nil
+		}
+		op := &ops[i]
+		var err error
+		if op.Op, err = str("op", true); err != nil {
+			return nil, err
+		}
+		if op.Path, err = str("path", true); err != nil {
+			return nil, err
+		}
+		switch op.Op {
+		case "add", "replace", "test":
+			r, ok := m["value"]
+			if !ok {
+				return nil, fmt.Errorf("operation %d (%s): missing \"value\"", i, op.Op)
+			}
+			d := json.NewDecoder(bytes.NewReader(r))
+			d.UseNumber()
+			if err := d.Decode(&op.Value); err != nil {
+				return nil, fmt.Errorf("operation %d: %w", i, err)
+			}
+		case "move", "copy":
+			if op.From, err = str("from", true); err != nil {
+				return nil, err
+			}
+		case "remove":
+		default:
+			return nil, fmt.Errorf("operation %d: unknown op %q", i, op.Op)
+		}
+	}
+	return ops, nil
+}
+
+// Error reports the operation that stopped a patch.
+type Error struct {
+	Index int
+	Op    Operation
+	Err   error
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("operation %d (%s): %v", e.Index, e.Op, e.Err)
+}
+
+// Apply applies ops in order to a copy of doc. It is atomic: if any
+// operation fails, including a test, doc is untouched and the error is an
+// *Error naming the operation.
+func Apply(doc interface{}, ops []Operation) (interface{}, error) {
+	doc = Clone(doc)
+	for i, op := range ops {
+		var err error
+		if doc, err = applyOne(doc, op); err != nil {
+			return nil, &Error{i, op, err}
+		}
+	}
+	return doc, nil
+}
+
+func applyOne(doc interface{}, op Operation) (interface{}, error) {
+	switch op.Op {
+	case "add":
+		return add(doc, op.Path, Clone(op.Value))
+	case "remove":
+		doc, _, err := remove(doc, op.Path)
+		return doc, err
+	case "replace":
+		if _, err := Get(doc, op.Path); err != nil {
+			return nil, err
+		}
+		if op.Path == "" {
+			return Clone(op.Value), nil
+		}
+		doc, _, err := remove(doc, op.Path)
+		if err != nil {
+			return nil, err
+		}
+		return add(doc, op.Path, Clone(op.Value))
+	case "move":
+		if op.Path == op.From {
+			return doc, nil
+		}
+		if strings.HasPrefix(op.Path, op.From+"/") {
+			return nil, fmt.Errorf("cannot move a value into itself")
+		}
+		doc, v, err := remove(doc, op.From)
+		if err != nil {
+			return nil, err
+		}
+		return add(doc, op.Path, v)
+	case "copy":
+		v, err := Get(doc, op.From)
+		if err != nil {
+			return nil, err
+		}
+		return add(doc, op.Path, Clone(v))
+	case "test":
+		v, err := Get(doc, op.Path)
+		if err != nil {
+			return nil, err
+		}
+		if !Equal(v, op.Value) {
+			got, _ := json.Marshal(v)
+			want, _ := json.Marshal(op.Value)
+			return nil, fmt.Errorf("test failed: value is %s, not %s", got, want)
+		}
+		return doc, nil
+	}
+	return nil, fmt.Errorf("unknown op %q", op.Op)
+}
+
+// update rebuilds the path to the parent of the last token, letting f
+// change the parent.
+func update(v interface{}, toks []string, f func(parent interface{}, last string) (interface{}, error)) (interface{}, error) {
+	if len(toks) == 1 {
+		return f(v, toks[0])
+	}
+	switch x := v.(type) {
+	case map[string]interface{}:
+		child, ok := x[toks[0]]
+		if !ok {
+			return nil, fmt.Errorf("no member %q", toks[0])
+		}
+		nc, err := update(child, toks[1:], f)
+		if err != nil {
+			return nil, err
+		}
+		x[toks[0]] = nc
+		return x, nil
+	case []interface{}:
+		i, err := index(toks[0], len(x), false)
+		if err != nil {
+			return nil, err
+		}
+		nc, err := update(x[i], toks[1:], f)
+		if err != nil {
+			return nil, err
+		}
+		x[i] = nc
+		return x, nil
+	}
+	return nil, fmt.Errorf("%q is not inside an object or array", toks[0])
+}
+
+func add(doc interface{}, path string, value interface{}) (interface{}, error) {
+	toks, err := ParsePointer(path)
+	if err != nil {
+		return nil, err
+	}
+	if len(toks) == 0 {
+		return value, nil
+	}
+	return update(doc, toks, func(parent interface{}, last string) (interface{}, error) {
+		switch x := parent.(type) {
+		case map[string]interface{}:
+			x[last] = value
+			return x, nil
+		case []interface{}:
+			i, err := index(last, len(x), true)
+			if err != nil {
+				return nil, err
+			}
+			x = append(x, nil)
+			copy(x[i+1:], x[i:])
+			x[i] = value
+			return x, nil
+		}
+		return nil, fmt.Errorf("parent of %s is not an object or array", path)
+	})
+}
+
+func remove(doc interface{}, path string) (interface{}, interface{}, error) {
+	toks, err := ParsePointer(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(toks) == 0 {
+		return nil, nil, fmt.Errorf("cannot remove the whole document")
+	}
+	var removed interface{}
+	doc, err = update(doc, toks, func(parent interface{}, last string) (interface{}, error) {
+		switch x := parent.(type) {
+		case map[string]interface{}:
+			v, ok := x[last]
+			if !ok {
+				return nil, fmt.Errorf("no member %q", last)
+			}
+			removed = v
+			delete(x, last)
+			return x, nil
+		case []interface{}:
+			i, err := index(last, len(x), false)
+			if err != nil {
+				return nil, err
+			}
+			removed = x[i]
+			return append(x[:i], x[i+1:]...), nil
+		}
+		return nil, fmt.Errorf("parent of %s is not an object or array", path)
+	})
+	return doc, removed, err
+}
@@ -0,0 +1,166 @@
+// Package jsonpatch implements JSON Pointer (RFC 6901), JSON Patch
+// (RFC 6902) and JSON Merge Patch (RFC 7386) over values decoded by
+// encoding/json with UseNumber, and computes structural differences
+// between two such values.
+package jsonpatch
+
+import (
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// ParsePointer splits a JSON Pointer into unescaped reference tokens.
+func ParsePointer(p string) ([]string, error) {
+	if p == "" {
+		return nil, nil
+	}
+	if p[0] != '/' {
+		return nil, fmt.Errorf("bad JSON Pointer %q: must start with /", p)
+	}
+	toks := strings.Split(p[1:], "/")
+	for i, t := range toks {
+		if strings.Contains(strings.ReplaceAll(strings.ReplaceAll(t, "~0", ""), "~1", ""), "~") {
+			return nil, fmt.Errorf("bad JSON Pointer %q: ~ must be followed by 0 or 1", p)
+		}
+		toks[i] = strings.ReplaceAll(strings.ReplaceAll(t, "~1", "/"), "~0", "~")
+	}
+	return toks, nil
+}
+
+// Escape escapes one reference token.
+func Escape(tok string) string {
+	return strings.ReplaceAll(strings.ReplaceAll(tok, "~", "~0"), "/", "~1")
+}
+
+// Join appends tokens to a pointer.
+func Join(p string, toks ...string) string {
+	for _, t := range toks {
+		p += "/" + Escape(t)
+	}
+	return p
+}
+
+// index parses an array index token; "-" is the end of the array when end
+// is allowed.
+func index(tok string, n int, end bool) (int, error) {
+	if tok == "-" && end {
+		return n, nil
+	}
+	if tok == "" || (len(tok) > 1 && tok[0] == '0') || strings.TrimLeft(tok, "0123456789") != "" {
+		return 0, fmt.Errorf("bad array index %q", tok)
+	}
+	i, err := strconv.Atoi(tok)
+	if err != nil {
+		return 0, fmt.Errorf("bad array index %q", tok)
+	}
+	limit := n - 1
+	if end {
+		limit = n
+	}
+	if i > limit {
+		return 0, fmt.Errorf("index %d out of range (length %d)", i, n)
+	}
+	return i, nil
+}
+
+// Get returns the value at pointer p in doc.
+func Get(doc interface{}, p string) (interface{}, error) {
+	toks, err := ParsePointer(p)
+	if err != nil {
+		return nil, err
+	}
+	v := doc
+	for i, t := range toks {
+		switch x := v.(type) {
+		case map[string]interface{}:
+			var ok bool
+			if v, ok = x[t]; !ok {
+				return nil, fmt.Errorf("%s: no such member", Join("", toks[:i+1]...))
+			}
+		case []interface{}:
+			n, err := index(t, len(x), false)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %v", Join("", toks[:i+1]...), err)
+			}
+			v = x[n]
+		default:
+			return nil, fmt.Errorf("%s: not an object or array", Join("", toks[:i]...))
+		}
+	}
+	return v, nil
+}
+
+// Equal compares two JSON values; numbers are compared by value, so 1
+// equals 1.0.
+func Equal(a, b interface{}) bool {
+	switch x := a.(type) {
+	case json.Number:
+		y, ok := b.(json.Number)
+		if !ok {
+			return false
+		}
+		if x == y {
+			return true
+		}
+		p, ok1 := new(big.Rat).SetString(string(x))
+		q, ok2 := new(big.Rat).SetString(string(y))
+		return ok1 && ok2 && p.Cmp(q) == 0
+	case []interface{}:
+		y, ok := b.([]interface{})
+		if !ok || len(x) != len(y) {
+			return false
+		}
+		for i := range x {
+			if !Equal(x[i], y[i]) {
+				return false
+			}
+		}
+		return true
+	case map[string]interface{}:
+		y, ok := b.(map[string]interface{})
+		if !ok || len(x) != len(y) {
+			return false
+		}
+		for k, xv := range x {
+			yv, ok := y[k]
+			if !ok || !Equal(xv, yv) {
+				return false
+			}
+		}
+		return true
+	}
+	return a == b
+}
+
+// Clone returns a deep copy of v.
+func Clone(v interface{}) interface{} {
+	switch x := v.(type) {
+	case map[string]interface{}:
+		m := make(map[string]interface{}, len(x))
+		for k, e := range x {
+			m[k] = Clone(e)
+		}
+		return m
+	case []interface{}:
+		a := make([]interface{}, len(x))
+		for i, e := range x {
+			a[i] = Clone(e)
+		}
+		return a
+	}
+	return v
+}
+
+// sortedKeys returns the keys of m in order.
+func sortedKeys(m map[string]interface{}) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,259 @@
+// Package jwk converts between Go keys and JSON Web Keys (RFC 7517) for
+// keygen and jwt. It handles RSA, EC (P-256/384/521), OKP (Ed25519) and
+// symmetric "oct" keys, and computes RFC 7638 thumbprints for kid.
+package jwk
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+)
+
+// Key is a JSON Web Key. Only the members for its kty are set.
+type Key struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid,omitempty"`
+	Use string `json:"use,omitempty"`
+	Alg string `json:"alg,omitempty"`
+	Crv string `json:"crv,omitempty"`
+	X   string `json:"x,omitempty"`
+	Y   string `json:"y,omitempty"`
+	N   string `json:"n,omitempty"`
+	E   string `json:"e,omitempty"`
+	D   string `json:"d,omitempty"`
+	P   string `json:"p,omitempty"`
+	Q   string `json:"q,omitempty"`
+	DP  string `json:"dp,omitempty"`
+	DQ  string `json:"dq,omitempty"`
+	QI  string `json:"qi,omitempty"`
+	K   string `json:"k,omitempty"`
+}
+
+// Set is a JWK Set.
+type Set struct {
+	Keys []*Key `json:"keys"`
+}
+
+var b64 = base64.RawURLEncoding
+
+// fixed encodes n big-endian in exactly size bytes, as JWK requires for
+// curve coordinates and private scalars.
+func fixed(n *big.Int, size int) string {
+	return b64.EncodeToString(n.FillBytes(make([]byte, size)))
+}
+
+// New builds the JWK for pub, including the private members when priv is
+// not nil. Use is "sig", alg is the usual signing algorithm for the key and
+// kid is its thumbprint.
+func New(pub crypto.PublicKey, priv crypto.Signer) (*Key, error) {
+	k := &Key{Use: "sig"}
+	switch pub := pub.(type) {
+	case *rsa.PublicKey:
+		k.Kty, k.Alg = "RSA", "RS256"
+		k.N = b64.EncodeToString(pub.N.Bytes())
+		k.E = b64.EncodeToString(big.NewInt(int64(pub.E)).Bytes())
+		if priv, ok := priv.(*rsa.PrivateKey); ok {
+			if len(priv.Primes) != 2 {
+				return nil, errors.New("jwk: multi-prime RSA keys are not supported")
+			}
+			priv.Precompute()
+			k.D = b64.EncodeToString(priv.D.Bytes())
+			k.P = b64.EncodeToString(priv.Primes[0].Bytes())
+			k.Q = b64.EncodeToString(priv.Primes[1].Bytes())
+			k.DP = b64.EncodeToString(priv.Precomputed.Dp.Bytes())
+			k.DQ = b64.EncodeToString(priv.Precomputed.Dq.Bytes())
+			k.QI = b64.EncodeToString(priv.Precomputed.Qinv.Bytes())
+		}
+	case *ecdsa.PublicKey:
+		size := (pub.Curve.Params().BitSize + 7) / 8
+		k.Kty, k.Crv = "EC", pub.Curve.Params().Name
+		switch k.Crv {
+		case "P-256":
+			k.Alg = "ES256"
+		case "P-384":
+			k.Alg = "ES384"
+		case "P-521":
+			k.Alg = "ES512"
+		default:
+			return nil, fmt.Errorf("jwk: unsupported curve %s", k.Crv)
+		}
+		k.X, k.Y = fixed(pub.X, size), fixed(pub.Y, size)
+		if priv, ok := priv.(*ecdsa.PrivateKey); ok {
+			k.D = fixed(priv.D, size)
+		}
+	case ed25519.PublicKey:
+		k.Kty, k.Crv, k.Alg = "OKP", "Ed25519", "EdDSA"
+		k.X = b64.EncodeToString(pub)
+		if priv, ok := priv.(ed25519.PrivateKey); ok {
+			k.D = b64.EncodeToString(priv.Seed())
+		}
+	default:
+		return nil, fmt.Errorf("jwk: unsupported key type %T", pub)
+	}
+	k.Kid = k.Thumbprint()
+	return k, nil
+}
+
+// Thumbprint is the RFC 7638 thumbprint: the SHA-256 of the required
+// public members serialised in lexicographic order without whitespace.
+func (k *Key) Thumbprint() string {
+	var s string
+	switch k.Kty {
+	case "RSA":
+		s = fmt.Sprintf(`{"e":%q,"kty":"RSA","n":%q}`, k.E, k.N)
+	case "EC":
+		s = fmt.Sprintf(`{"crv":%q,"kty":"EC","x":%q,"y":%q}`, k.Crv, k.X, k.Y)
+	case "OKP":
+		s = fmt.Sprintf(`{"crv":%q,"kty":"OKP","x":%q}`, k.Crv, k.X)
+	case "oct":
+		s = fmt.Sprintf(`{"k":%q,"kty":"oct"}`, k.K)
+	}
+	sum := sha256.Sum256([]byte(s))
+	return b64.EncodeToString(sum[:])
+}
+
+func decodeInt(s string) (*big.Int, error) {
+	b, err := b64.DecodeString(s)
+	if err != nil {
+		return nil, err
+	}
+	return new(big.Int).SetBytes(b), nil
+}
+
+func curve(name string) (elliptic.Curve, error) {
+	switch name {
+	case "P-256":
+		return elliptic.P256(), nil
+	case "P-384":
+		return elliptic.P384(), nil
+	case "P-521":
+		return elliptic.P521(), nil
+	}
+	return nil, fmt.Errorf("jwk: unsupported curve %q", name)
+}
+
+// Keys decodes k. priv is nil for public keys; symmetric keys have neither
+// half and are read with Secret instead.
+func (k *Key) Keys() (pub crypto.PublicKey, priv crypto.Signer, err error) {
+	ints := func(vals ...string) ([]*big.Int, error) {
+		out := make([]*big.Int, len(vals))
+		for i, v := range vals {
+			if out[i], err = decodeInt(v); err != nil {
+				return nil, fmt.Errorf("jwk %s: %w", k.Kid, err)
+			}
+		}
+		return out, nil
+	}
+	switch k.Kty {
+	case "RSA":
+		v, err := ints(k.N, k.E)
+		if err != nil {
+			return nil, nil, err
+		}
+		if !v[1].IsInt64() {
+			return nil, nil, errors.New("jwk: RSA exponent too large")
+		}
+		rpub := &rsa.PublicKey{N: v[0], E: int(v[1].Int64())}
+		if k.D == "" {
+			return rpub, nil, nil
+		}
+		p, err := ints(k.D, k.P, k.Q)
+		if err != nil {
+			return nil, nil, err
+		}
+		rpriv := &rsa.PrivateKey{PublicKey: *rpub, D: p[0], Primes: []*big.Int{p[1], p[2]}}
+		if err := rpriv.Validate(); err != nil {
+			return nil, nil, fmt.Errorf("jwk %s: %w", k.Kid, err)
+		}
+		rpriv.Precompute()
+		return &rpriv.PublicKey, rpriv, nil
+	case "EC":
+		c, err := curve(k.Crv)
+		if err != nil {
+			return nil, nil, err
+		}
+		v, err := ints(k.X, k.Y)
+		if err != nil {
+			return nil, nil, err
+		}
+		if !c.IsOnCurve(v[0], v[1]) {
+			return nil, nil, errors.New("jwk: point is not on the curve")
+		}
+		epub := &ecdsa.PublicKey{Curve: c, X: v[0], Y: v[1]}
+		if k.D == "" {
+			return epub, nil, nil
+		}
+		d, err := ints(k.D)
+		if err != nil {
+			return nil, nil, err
+		}
+		epriv := &ecdsa.PrivateKey{PublicKey: *epub, D: d[0]}
+		return &epriv.PublicKey, epriv, nil
+	case "OKP":
+		if k.Crv != "Ed25519" {
+			return nil, nil, fmt.Errorf("jwk: unsupported curve %q", k.Crv)
+		}
+		x, err := b64.DecodeString(k.X)
+		if err != nil || len(x) != ed25519.PublicKeySize {
+			return nil, nil, errors.New("jwk: invalid Ed25519 public key")
+		}
+		if k.D == "" {
+			return ed25519.PublicKey(x), nil, nil
+		}
+		seed, err := b64.DecodeString(k.D)
+		if err != nil || len(seed) != ed25519.SeedSize {
+			return nil, nil, errors.New("jwk: invalid Ed25519 private key")
+		}
+		epriv := ed25519.NewKeyFromSeed(seed)
+		return epriv.Public(), epriv, nil
+	case "oct":
+		return nil, nil, errors.New("jwk: symmetric key has no public key")
+	}
+	return nil, nil, fmt.Errorf("jwk: unsupported key type %q", k.Kty)
+}
+
+// Secret returns the bytes of a symmetric ("oct") key.
+func (k *Key) Secret() ([]byte, error) {
+	if k.Kty != "oct" {
+		return nil, fmt.Errorf("jwk: %s key is not symmetric", k.Kty)
+	}
+	return b64.DecodeString(k.K)
+}
+
+// Parse reads one or more JWKs or JWK Sets from data and returns every key
+// they contain.
+func Parse(data []byte) ([]*Key, error) {
+	var keys []*Key
+	dec := json.NewDecoder(bytes.NewReader(data))
+	for {
+		var raw json.RawMessage
+		if err := dec.Decode(&raw); err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, fmt.Errorf("jwk: %w", err)
+		}
+		var set Set
+		if err := json.Unmarshal(raw, &set); err != nil || set.Keys == nil {
+			var k Key
+			if err := json.Unmarshal(raw, &k); err != nil {
+				return nil, fmt.Errorf("jwk: %w", err)
+			}
+			set.Keys = []*Key{&k}
+		}
+		keys = append(keys, set.Keys...)
+	}
+	if len(keys) == 0 {
+		return nil, errors.New("jwk: no keys found")
+	}
+	return keys, nil
+}
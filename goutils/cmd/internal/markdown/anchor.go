@@ -0,0 +1,55 @@
+package markdown
+
+import (
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// Slug returns the anchor GitHub gives a heading with text s: lower
+// case, with punctuation other than '-' and '_' removed and spaces
+// turned into hyphens.
+func Slug(s string) string {
+	var b strings.Builder
+	for _, r := range strings.ToLower(s) {
+		switch {
+		case r == ' ':
+			b.WriteByte('-')
+		case r == '-' || r == '_' || unicode.IsLetter(r) || unicode.IsDigit(r) || unicode.IsMark(r):
+			b.WriteRune(r)
+		}
+	}
+	return b.String()
+}
+
+// Anchors hands out unique heading anchors within a document: the
+// second "Usage" heading becomes usage-1, the third usage-2, and so on.
+type Anchors map[string]int
+
+// Add returns the unique anchor for the heading text s.
+func (a Anchors) Add(s string) string {
+	slug := Slug(s)
+	id := slug
+	for {
+		if _, taken := a[id]; !taken {
+			break
+		}
+		a[slug]++
+		id = slug + "-" + strconv.Itoa(a[slug])
+	}
+	a[id] = 0
+	return id
+}
+
+// Headings returns the headings of a document in order.
+func Headings(doc *Node) []*Node {
+	var hs []*Node
+	doc.Walk(func(n *Node, entering bool) bool {
+		if entering && n.Kind == Heading {
+			hs = append(hs, n)
+			return false
+		}
+		return entering && n.Kind.IsBlock()
+	})
+	return hs
+}
@@ -0,0 +1,739 @@
+package markdown
+
+import (
+	"regexp"
+	"strings"
+)
+
+const codeIndent = 4
+
+var (
+	reMaybeSpecial       = regexp.MustCompile(`^[#` + "`" + `~*+_=<>0-9|:-]`)
+	reATXHeading         = regexp.MustCompile(`^#{1,6}(?:[ \t]+|$)`)
+	reCodeFence          = regexp.MustCompile("^(?:`{3,}|~{3,})")
+	reClosingFence       = regexp.MustCompile("^(?:`{3,}|~{3,})[ \t]*$")
+	reSetextHeading      = regexp.MustCompile(`^(?:=+|-+)[ \t]*$`)
+	reThematicBreak      = regexp.MustCompile(`^(?:(?:\*[ \t]*){3,}|(?:_[ \t]*){3,}|(?:-[ \t]*){3,})$`)
+	reBulletMarker       = regexp.MustCompile(`^[*+-]`)
+	reOrderedMarker      = regexp.MustCompile(`^(\d{1,9})([.)])`)
+	reClosingATX         = regexp.MustCompile(`(?:^|[ \t]+)#+[ \t]*$`)
+	reTrailingBlankLines = regexp.MustCompile(`(?:\n *)+$`)
+	reTableDelimiter     = regexp.MustCompile(`^\|?[ \t]*:?-+:?[ \t]*(?:\|[ \t]*:?-+:?[ \t]*)*\|?[ \t]*$`)
+
+	reHTMLBlockOpen = []*regexp.Regexp{
+		nil,
+		regexp.MustCompile(`(?i)^<(?:pre|script|style|textarea)(?:\s|>|$)`),
+		regexp.MustCompile(`^<!--`),
+		regexp.MustCompile(`^<[?]`),
+		regexp.MustCompile(`^<![A-Za-z]`),
+		regexp.MustCompile(`^<!\[CDATA\[`),
+		regexp.MustCompile(`(?i)^</?(?:address|article|aside|base|basefont|blockquote|body|caption|center|col|colgroup|dd|details|dialog|dir|div|dl|dt|fieldset|figcaption|figure|footer|form|frame|frameset|h[1-6]|head|header|hr|html|iframe|legend|li|link|main|menu|menuitem|nav|noframes|ol|optgroup|option|p|param|search|section|summary|table|tbody|td|tfoot|th|thead|title|tr|track|ul)(?:\s|/?>|$)`),
+		regexp.MustCompile(`(?i)^(?:` + openTag + `|` + closeTag + `)[ \t]*$`),
+	}
+	reHTMLBlockClose = []*regexp.Regexp{
+		nil,
+		regexp.MustCompile(`(?i)</(?:pre|script|style|textarea)>`),
+		regexp.MustCompile(`-->`),
+		regexp.MustCompile(`\?>`),
+		regexp.MustCompile(`>`),
+		regexp.MustCompile(`\]\]>`),
+	}
+)
+
+// parser holds the state of the block pass.
+type parser struct {
+	doc    *Node
+	tip    *Node // the innermost open block
+	oldtip *Node
+	refs   map[string]ref
+
+	line                 string
+	lineNumber           int
+	offset, column       int
+	nextNonspace         int
+	nextNonspaceColumn   int
+	indent               int
+	indented, blank      bool
+	partiallyConsumedTab bool
+	allClosed            bool
+	lastMatched          *Node
+}
+
+type ref struct {
+	dest, title string
+}
+
+// Parse parses a Markdown document.
+func Parse(src string) *Node {
+	p := &parser{refs: map[string]ref{}}
+	p.doc = &Node{Kind: Document, open: true, Line: 1}
+	p.tip, p.oldtip = p.doc, p.doc
+	src = strings.ReplaceAll(src, "\x00", "\uFFFD")
+	lines := splitLines(src)
+	for _, l := range lines {
+		p.incorporate(l)
+	}
+	for p.tip != nil {
+		p.finalize(p.tip, len(lines))
+	}
+	p.doc.EndLine = len(lines)
+	p.inlines(p.doc)
+	return p.doc
+}
+
+func splitLines(s string) []string {
+	s = strings.ReplaceAll(s, "\r\n", "\n")
+	s = strings.ReplaceAll(s, "\r", "\n")
+	if s == "" {
+		return nil
+	}
+	return strings.Split(strings.TrimSuffix(s, "\n"), "\n")
+}
+
+func isSpaceOrTab(c byte) bool { return c == ' ' || c == '\t' }
+
+func (p *parser) peek(i int) byte {
+	if i < len(p.line) {
+		return p.line[i]
+	}
+	return 0
+}
+
+func (p *parser) findNextNonspace() {
+	i, cols := p.offset, p.column
+	for i < len(p.line) {
+		if c := p.line[i]; c == ' ' {
+			i++
+			cols++
+		} else if c == '\t' {
+			i++
+			cols += 4 - cols%4
+		} else {
+			break
+		}
+	}
+	p.blank = i == len(p.line)
+	p.nextNonspace, p.nextNonspaceColumn = i, cols
+	p.indent = cols - p.column
+	p.indented = p.indent >= codeIndent
+}
+
+func (p *parser) advanceNextNonspace() {
+	p.offset, p.column = p.nextNonspace, p.nextNonspaceColumn
+	p.partiallyConsumedTab = false
+}
+
+// advanceOffset moves over count characters, or count columns if
+// columns is set, in which case a tab may be partly consumed.
+func (p *parser) advanceOffset(count int, columns bool) {
+	for count > 0 && p.offset < len(p.line) {
+		if p.line[p.offset] == '\t' {
+			toTab := 4 - p.column%4
+			if columns {
+				p.partiallyConsumedTab = toTab > count
+				adv := toTab
+				if adv > count {
+					adv = count
+				}
+				p.column += adv
+				if !p.partiallyConsumedTab {
+					p.offset++
+				}
+				count -= adv
+			} else {
+				p.partiallyConsumedTab = false
+				p.column += toTab
+				p.offset++
+				count--
+			}
+		} else {
+			p.partiallyConsumedTab = false
+			p.offset++
+			p.column++
+			count--
+		}
+	}
+}
+
+// addLine adds the rest of the line to the content of the tip.
+func (p *parser) addLine() {
+	if p.partiallyConsumedTab {
+		p.offset++
+		p.tip.content.WriteString(strings.Repeat(" ", 4-p.column%4))
+	}
+	p.tip.content.WriteString(p.line[p.offset:])
+	p.tip.content.WriteByte('\n')
+}
+
+func canContain(parent, child Kind) bool {
+	switch parent {
+	case Document, BlockQuote, Item:
+		return child != Item
+	case List:
+		return child == Item
+	}
+	return false
+}
+
+func acceptsLines(k Kind) bool {
+	return k == Paragraph || k == CodeBlock || k == HTMLBlock || k == Table
+}
+
+func (p *parser) addChild(k Kind, offset int) *Node {
+	for !canContain(p.tip.Kind, k) {
+		p.finalize(p.tip, p.lineNumber-1)
+	}
+	n := &Node{Kind: k, open: true, Line: p.lineNumber}
+	p.tip.AppendChild(n)
+	p.tip = n
+	return n
+}
+
+func (p *parser) closeUnmatched() {
+	if p.allClosed {
+		return
+	}
+	for p.oldtip != p.lastMatched {
+		parent := p.oldtip.Parent
+		p.finalize(p.oldtip, p.lineNumber-1)
+		p.oldtip = parent
+	}
+	p.allClosed = true
+}
+
+// continues reports whether the open block n continues on this line: 0
+// if it does, 1 if not, and 2 if the line has been used up (a closing
+// code fence).
+func (p *parser) continues(n *Node) int {
+	switch n.Kind {
+	case BlockQuote:
+		if !p.indented && p.peek(p.nextNonspace) == '>' {
+			p.advanceNextNonspace()
+			p.advanceOffset(1, false)
+			if isSpaceOrTab(p.peek(p.offset)) {
+				p.advanceOffset(1, true)
+			}
+			return 0
+		}
+		return 1
+	case Item:
+		switch {
+		case p.blank:
+			if n.FirstChild == nil {
+				return 1 // a blank line after an empty item
+			}
+			p.advanceNextNonspace()
+		case p.indent >= n.List.markerOffset+n.List.padding:
+			p.advanceOffset(n.List.markerOffset+n.List.padding, true)
+		default:
+			return 1
+		}
+		return 0
+	case Heading, ThematicBreak:
+		return 1
+	case CodeBlock:
+		if n.Fenced {
+			rest := p.line[p.nextNonspace:]
+			if p.indent <= 3 && p.peek(p.nextNonspace) == n.fenceChar && reClosingFence.MatchString(rest) {
+				if l := len(rest) - len(strings.TrimLeft(rest, string(n.fenceChar))); l >= n.fenceLen {
+					p.finalize(n, p.lineNumber)
+					return 2
+				}
+			}
+			for i := n.fenceOffset; i > 0 && isSpaceOrTab(p.peek(p.offset)); i-- {
+				p.advanceOffset(1, true)
+			}
+			return 0
+		}
+		switch {
+		case p.indent >= codeIndent:
+			p.advanceOffset(codeIndent, true)
+		case p.blank:
+			p.advanceNextNonspace()
+		default:
+			return 1
+		}
+		return 0
+	case HTMLBlock:
+		if p.blank && (n.htmlType == 6 || n.htmlType == 7) {
+			return 1
+		}
+		return 0
+	case Paragraph, Table:
+		if p.blank {
+			return 1
+		}
+		return 0
+	}
+	return 0
+}
+
+func (p *parser) incorporate(line string) {
+	p.oldtip = p.tip
+	p.offset, p.column = 0, 0
+	p.blank, p.partiallyConsumedTab = false, false
+	p.lineNumber++
+	p.line = line
+
+	allMatched := true
+	container := p.doc
+	for container.LastChild != nil && container.LastChild.open {
+		container = container.LastChild
+		p.findNextNonspace()
+		switch p.continues(container) {
+		case 1:
+			allMatched = false
+		case 2:
+			return
+		}
+		if !allMatched {
+			container = container.Parent
+			break
+		}
+	}
+	p.allClosed = container == p.oldtip
+	p.lastMatched = container
+
+	matchedLeaf := container.Kind != Paragraph && container.Kind != Table && acceptsLines(container.Kind)
+	for !matchedLeaf {
+		p.findNextNonspace()
+		if !p.indented && !reMaybeSpecial.MatchString(line[p.nextNonspace:]) {
+			p.advanceNextNonspace()
+			break
+		}
+		res := 0
+		for _, start := range blockStarts {
+			if res = start(p, container); res != 0 {
+				break
+			}
+		}
+		if res == 0 {
+			p.advanceNextNonspace()
+			break
+		}
+		container = p.tip
+		if res == 2 {
+			matchedLeaf = true
+		}
+	}
+
+	if !p.allClosed && !p.blank && p.tip.Kind == Paragraph {
+		p.addLine() // a lazy continuation line
+		return
+	}
+	p.closeUnmatched()
+	switch {
+	case acceptsLines(container.Kind):
+		if container.Kind == Table {
+			p.addRow(container)
+			return
+		}
+		p.addLine()
+		if t := container.htmlType; container.Kind == HTMLBlock && t >= 1 && t <= 5 && reHTMLBlockClose[t].MatchString(line[p.offset:]) {
+			p.finalize(container, p.lineNumber)
+		}
+	case p.offset < len(line) && !p.blank:
+		p.addChild(Paragraph, p.offset)
+		p.advanceNextNonspace()
+		p.addLine()
+	}
+}
+
+var blockStarts = []func(p *parser, container *Node) int{
+	tableStart,
+	blockQuoteStart,
+	atxHeadingStart,
+	fencedCodeStart,
+	htmlBlockStart,
+	setextHeadingStart,
+	thematicBreakStart,
+	listItemStart,
+	indentedCodeStart,
+}
+
+func blockQuoteStart(p *parser, _ *Node) int {
+	if p.indented || p.peek(p.nextNonspace) != '>' {
+		return 0
+	}
+	p.advanceNextNonspace()
+	p.advanceOffset(1, false)
+	if isSpaceOrTab(p.peek(p.offset)) {
+		p.advanceOffset(1, true)
+	}
+	p.closeUnmatched()
+	p.addChild(BlockQuote, p.nextNonspace)
+	return 1
+}
+
+func atxHeadingStart(p *parser, _ *Node) int {
+	if p.indented {
+		return 0
+	}
+	m := reATXHeading.FindString(p.line[p.nextNonspace:])
+	if m == "" {
+		return 0
+	}
+	p.advanceNextNonspace()
+	p.advanceOffset(len(m), false)
+	p.closeUnmatched()
+	h := p.addChild(Heading, p.nextNonspace)
+	h.Level = len(strings.TrimRight(m, " \t"))
+	h.content.WriteString(reClosingATX.ReplaceAllString(p.line[p.offset:], ""))
+	p.advanceOffset(len(p.line)-p.offset, false)
+	return 2
+}
+
+func fencedCodeStart(p *parser, _ *Node) int {
+	if p.indented {
+		return 0
+	}
+	rest := p.line[p.nextNonspace:]
+	m := reCodeFence.FindString(rest)
+	if m == "" || m[0] == '`' && strings.Contains(rest[len(m):], "`") {
+		return 0
+	}
+	p.closeUnmatched()
+	c := p.addChild(CodeBlock, p.nextNonspace)
+	c.Fenced = true
+	c.fenceLen, c.fenceChar, c.fenceOffset = len(m), m[0], p.indent
+	p.advanceNextNonspace()
+	p.advanceOffset(len(m), false)
+	return 2
+}
+
+func htmlBlockStart(p *parser, container *Node) int {
+	if p.indented || p.peek(p.nextNonspace) != '<' {
+		return 0
+	}
+	s := p.line[p.nextNonspace:]
+	for t := 1; t <= 7; t++ {
+		lazy := !p.allClosed && !p.blank && p.tip.Kind == Paragraph
+		if reHTMLBlockOpen[t].MatchString(s) && (t < 7 || container.Kind != Paragraph && !lazy) {
+			p.closeUnmatched()
+			// The indentation is part of the block.
+			b := p.addChild(HTMLBlock, p.offset)
+			b.htmlType = t
+			return 2
+		}
+	}
+	return 0
+}
+
+func setextHeadingStart(p *parser, container *Node) int {
+	if p.indented || container.Kind != Paragraph {
+		return 0
+	}
+	m := reSetextHeading.FindString(p.line[p.nextNonspace:])
+	if m == "" {
+		return 0
+	}
+	p.closeUnmatched()
+	content := p.stripRefs(container)
+	if content == "" {
+		return 0
+	}
+	h := &Node{Kind: Heading, open: true, Line: container.Line}
+	h.Level = 1
+	if m[0] == '-' {
+		h.Level = 2
+	}
+	h.content.WriteString(content)
+	container.InsertAfter(h)
+	container.Unlink()
+	p.tip = h
+	p.advanceOffset(len(p.line)-p.offset, false)
+	return 2
+}
+
+func thematicBreakStart(p *parser, _ *Node) int {
+	if p.indented || !reThematicBreak.MatchString(p.line[p.nextNonspace:]) {
+		return 0
+	}
+	p.closeUnmatched()
+	p.addChild(ThematicBreak, p.nextNonspace)
+	p.advanceOffset(len(p.line)-p.offset, false)
+	return 2
+}
+
+func listItemStart(p *parser, container *Node) int {
+	if p.indented && container.Kind != List {
+		return 0
+	}
+	data := p.parseListMarker(container)
+	if data == nil {
+		return 0
+	}
+	p.closeUnmatched()
+	if p.tip.Kind != List || !listsMatch(p.tip.List, data) {
+		l := p.addChild(List, p.nextNonspace)
+		d := *data
+		d.Tight = true
+		l.List = &d
+	}
+	item := p.addChild(Item, p.nextNonspace)
+	item.List = data
+	return 1
+}
+
+func listsMatch(a, b *ListData) bool {
+	return a.Ordered == b.Ordered && a.Delim == b.Delim && a.Bullet == b.Bullet
+}
+
+func (p *parser) parseListMarker(container *Node) *ListData {
+	if p.indent >= 4 {
+		return nil
+	}
+	rest := p.line[p.nextNonspace:]
+	data := &ListData{markerOffset: p.indent}
+	var marker string
+	if m := reBulletMarker.FindString(rest); m != "" {
+		marker, data.Bullet = m, m[0]
+	} else if m := reOrderedMarker.FindStringSubmatch(rest); m != nil && (container.Kind != Paragraph || m[1] == "1") {
+		marker, data.Ordered, data.Delim = m[0], true, m[2][0]
+		data.Start = atoi(m[1])
+	} else {
+		return nil
+	}
+	if c := p.peek(p.nextNonspace + len(marker)); c != 0 && c != ' ' && c != '\t' {
+		return nil
+	}
+	if container.Kind == Paragraph && strings.TrimLeft(rest[len(marker):], " \t") == "" {
+		return nil // an empty item cannot interrupt a paragraph
+	}
+	p.advanceNextNonspace()
+	p.advanceOffset(len(marker), true)
+	startCol, startOffset := p.column, p.offset
+	for {
+		p.advanceOffset(1, true)
+		if p.column-startCol >= 5 || !isSpaceOrTab(p.peek(p.offset)) {
+			break
+		}
+	}
+	blankItem := p.offset >= len(p.line)
+	spaces := p.column - startCol
+	if spaces >= 5 || spaces < 1 || blankItem {
+		data.padding = len(marker) + 1
+		p.column, p.offset = startCol, startOffset
+		if isSpaceOrTab(p.peek(p.offset)) {
+			p.advanceOffset(1, true)
+		}
+	} else {
+		data.padding = len(marker) + spaces
+	}
+	return data
+}
+
+func atoi(s string) int {
+	n := 0
+	for i := 0; i < len(s); i++ {
+		n = n*10 + int(s[i]-'0')
+	}
+	return n
+}
+
+func indentedCodeStart(p *parser, _ *Node) int {
+	if !p.indented || p.tip.Kind == Paragraph || p.tip.Kind == Table || p.blank {
+		return 0
+	}
+	p.advanceOffset(codeIndent, true)
+	p.closeUnmatched()
+	p.addChild(CodeBlock, p.offset)
+	return 2
+}
+
+// tableStart turns the last line of a paragraph into the header of a
+// table when the line after it is a matching delimiter row.
+func tableStart(p *parser, container *Node) int {
+	if p.indented || container.Kind != Paragraph {
+		return 0
+	}
+	rest := p.line[p.nextNonspace:]
+	if !strings.ContainsAny(rest, "|:") && !strings.Contains(strings.TrimSpace(rest), " ") || !reTableDelimiter.MatchString(rest) {
+		return 0
+	}
+	content := strings.TrimSuffix(container.content.String(), "\n")
+	i := strings.LastIndexByte(content, '\n')
+	header := content[i+1:]
+	aligns := parseAligns(rest)
+	cells := splitCells(header)
+	if len(cells) != len(aligns) || !strings.Contains(header+rest, "|") {
+		return 0
+	}
+	p.closeUnmatched()
+	t := &Node{Kind: Table, open: true, Line: p.lineNumber - 1, Align: aligns}
+	if i < 0 {
+		t.Line = container.Line
+		container.InsertAfter(t)
+		container.Unlink()
+	} else {
+		// The lines before the header stay a paragraph.
+		container.content.Reset()
+		container.content.WriteString(content[:i+1])
+		container.InsertAfter(t)
+		p.finalize(container, p.lineNumber-2)
+	}
+	p.tip = t
+	row := &Node{Kind: TableRow, Header: true, Line: t.Line, EndLine: t.Line}
+	t.AppendChild(row)
+	p.addCells(row, cells, aligns)
+	p.advanceOffset(len(p.line)-p.offset, false)
+	return 2
+}
+
+func parseAligns(delim string) []Align {
+	var aligns []Align
+	for _, c := range splitCells(delim) {
+		c = strings.TrimSpace(c)
+		left, right := strings.HasPrefix(c, ":"), strings.HasSuffix(c, ":")
+		switch {
+		case left && right:
+			aligns = append(aligns, AlignCenter)
+		case left:
+			aligns = append(aligns, AlignLeft)
+		case right:
+			aligns = append(aligns, AlignRight)
+		default:
+			aligns = append(aligns, AlignNone)
+		}
+	}
+	return aligns
+}
+
+// splitCells splits a table row at the pipes that are not escaped,
+// dropping a leading and a trailing one.
+func splitCells(row string) []string {
+	row = strings.TrimSpace(row)
+	row = strings.TrimPrefix(row, "|")
+	if strings.HasSuffix(row, "|") && !strings.HasSuffix(row, `\|`) {
+		row = row[:len(row)-1]
+	}
+	var cells []string
+	start := 0
+	for i := 0; i < len(row); i++ {
+		switch row[i] {
+		case '\\':
+			i++
+		case '|':
+			cells = append(cells, row[start:i])
+			start = i + 1
+		}
+	}
+	return append(cells, row[start:])
+}
+
+func (p *parser) addCells(row *Node, cells []string, aligns []Align) {
+	for i, a := range aligns {
+		c := &Node{Kind: TableCell, Header: row.Header, Align: []Align{a}, Line: row.Line, EndLine: row.Line}
+		if i < len(cells) {
+			c.content.WriteString(strings.ReplaceAll(strings.TrimSpace(cells[i]), `\|`, "|"))
+		}
+		row.AppendChild(c)
+	}
+}
+
+func (p *parser) addRow(t *Node) {
+	if p.offset >= len(p.line) {
+		return // the delimiter row
+	}
+	row := &Node{Kind: TableRow, Line: p.lineNumber, EndLine: p.lineNumber}
+	t.AppendChild(row)
+	p.addCells(row, splitCells(p.line[p.offset:]), t.Align)
+}
+
+// finalize closes block n at line end.
+func (p *parser) finalize(n *Node, end int) {
+	parent := n.Parent
+	n.open = false
+	n.EndLine = end
+	switch n.Kind {
+	case Paragraph:
+		if p.stripRefs(n) == "" {
+			n.Unlink()
+		}
+	case CodeBlock:
+		s := n.content.String()
+		if n.Fenced {
+			first, rest, _ := strings.Cut(s, "\n")
+			n.Info = unescapeString(strings.TrimSpace(first))
+			n.Literal = rest
+		} else {
+			lines := strings.Split(s, "\n")
+			for len(lines) > 0 && strings.Trim(lines[len(lines)-1], " \t") == "" {
+				lines = lines[:len(lines)-1]
+			}
+			n.Literal = strings.Join(lines, "\n") + "\n"
+			n.EndLine = n.Line + len(lines) - 1
+		}
+		n.content.Reset()
+	case HTMLBlock:
+		n.Literal = reTrailingBlankLines.ReplaceAllString(n.content.String(), "")
+		n.content.Reset()
+	case List:
+		n.List.Tight = true
+	items:
+		for item := n.FirstChild; item != nil; item = item.Next {
+			if item.Next != nil && endsWithBlankLine(item) {
+				n.List.Tight = false
+				break
+			}
+			for sub := item.FirstChild; sub != nil; sub = sub.Next {
+				if sub.Next != nil && endsWithBlankLine(sub) {
+					n.List.Tight = false
+					break items
+				}
+			}
+		}
+		if n.LastChild != nil {
+			n.EndLine = n.LastChild.EndLine
+		}
+	case Item:
+		if n.LastChild != nil {
+			n.EndLine = n.LastChild.EndLine
+		} else {
+			n.EndLine = n.Line
+		}
+	}
+	p.tip = parent
+}
+
+// endsWithBlankLine reports whether a blank line separates n from the
+// block after it.
+func endsWithBlankLine(n *Node) bool {
+	return n.Next != nil && n.EndLine != n.Next.Line-1
+}
+
+// stripRefs removes the link reference definitions at the start of a
+// paragraph, records them and returns what is left.
+func (p *parser) stripRefs(n *Node) string {
+	s := n.content.String()
+	for strings.HasPrefix(s, "[") {
+		used := parseReference(s, p.refs)
+		if used == 0 {
+			break
+		}
+		n.Line += strings.Count(s[:used], "\n")
+		s = s[used:]
+	}
+	n.content.Reset()
+	n.content.WriteString(s)
+	if strings.Trim(s, " \t\n") == "" {
+		return ""
+	}
+	return s
+}
+
+// inlines parses the inline content of the blocks below n.
+func (p *parser) inlines(n *Node) {
+	n.Walk(func(c *Node, entering bool) bool {
+		if !entering {
+			return true
+		}
+		switch c.Kind {
+		case Paragraph, Heading, TableCell:
+			parseInlines(c, strings.Trim(c.content.String(), " \t\n"), p.refs)
+			c.content.Reset()
+			return false
+		}
+		return true
+	})
+}
@@ -0,0 +1,260 @@
+package markdown
+
+import (
+	"fmt"
+	"io"
+	"strings"
+)
+
+// HTMLOptions controls RenderHTML.
+type HTMLOptions struct {
+	// HeadingIDs gives each heading an id attribute with its anchor, as
+	// Anchors assigns them.
+	HeadingIDs bool
+}
+
+type htmlRenderer struct {
+	b       strings.Builder
+	opt     HTMLOptions
+	anchors Anchors
+}
+
+// RenderHTML writes the HTML for n and its descendants to w.
+func RenderHTML(w io.Writer, n *Node, opt HTMLOptions) error {
+	r := &htmlRenderer{opt: opt, anchors: Anchors{}}
+	n.Walk(r.node)
+	_, err := io.WriteString(w, r.b.String())
+	return err
+}
+
+// cr starts a new line unless the output is at the start of one.
+func (r *htmlRenderer) cr() {
+	if r.b.Len() > 0 && !strings.HasSuffix(r.b.String(), "\n") {
+		r.b.WriteByte('\n')
+	}
+}
+
+func (r *htmlRenderer) esc(s string) {
+	r.b.WriteString(escapeHTML(s))
+}
+
+var htmlEscaper = strings.NewReplacer(`&`, "&amp;", `<`, "&lt;", `>`, "&gt;", `"`, "&quot;")
+
+func escapeHTML(s string) string { return htmlEscaper.Replace(s) }
+
+func inTightList(n *Node) bool {
+	if n.Parent == nil || n.Parent.Parent == nil {
+		return false
+	}
+	l := n.Parent.Parent
+	return n.Parent.Kind == Item && l.Kind == List && l.List.Tight
+}
+
+var alignNames = [...]string{"", "left", "center", "right"}
+
+func (r *htmlRenderer) node(n *Node, entering bool) bool {
+	switch n.Kind {
+	case Paragraph:
+		if inTightList(n) {
+			return true
+		}
+		if entering {
+			r.cr()
+			r.b.WriteString("<p>")
+		} else {
+			r.b.WriteString("</p>")
+			r.cr()
+		}
+	case Heading:
+		if entering {
+			r.cr()
+			if r.opt.HeadingIDs {
+				fmt.Fprintf(&r.b, `<h%d id="%s">`, n.Level, escapeHTML(r.anchors.Add(n.Text())))
+			} else {
+				fmt.Fprintf(&r.b, "<h%d>", n.Level)
+			}
+		} else {
+			fmt.Fprintf(&r.b, "</h%d>", n.Level)
+			r.cr()
+		}
+	case CodeBlock:
+		r.cr()
+		r.b.WriteString("<pre><code")
+		if f := strings.Fields(n.Info); len(f) > 0 {
+			r.b.WriteString(` class="language-`)
+			r.esc(f[0])
+			r.b.WriteByte('"')
+		}
+		r.b.WriteByte('>')
+		r.esc(n.Literal)
+		r.b.WriteString("</code></pre>")
+		r.cr()
+	case HTMLBlock:
+		r.cr()
+		r.b.WriteString(n.Literal)
+		r.cr()
+	case ThematicBreak:
+		r.cr()
+		r.b.WriteString("<hr />")
+		r.cr()
+	case BlockQuote:
+		r.cr()
+		if entering {
+			r.b.WriteString("<blockquote>")
+		} else {
+			r.b.WriteString("</blockquote>")
+		}
+		r.cr()
+	case List:
+		tag := "ul"
+		if n.List.Ordered {
+			tag = "ol"
+		}
+		r.cr()
+		switch {
+		case !entering:
+			fmt.Fprintf(&r.b, "</%s>", tag)
+		case n.List.Ordered && n.List.Start != 1:
+			fmt.Fprintf(&r.b, `<ol start="%d">`, n.List.Start)
+		default:
+			fmt.Fprintf(&r.b, "<%s>", tag)
+		}
+		r.cr()
+	case Item:
+		if entering {
+			r.cr()
+			r.b.WriteString("<li>")
+		} else {
+			r.b.WriteString("</li>")
+			r.cr()
+		}
+	case Table:
+		r.cr()
+		if entering {
+			r.b.WriteString("<table>\n")
+			return true
+		}
+		if n.FirstChild != n.LastChild {
+			r.b.WriteString("</tbody>\n")
+		}
+		r.b.WriteString("</table>")
+		r.cr()
+	case TableRow:
+		if !entering {
+			r.b.WriteString("</tr>\n")
+			if n.Header {
+				r.b.WriteString("</thead>\n")
+			}
+			return true
+		}
+		switch {
+		case n.Header:
+			r.b.WriteString("<thead>\n")
+		case n.Prev != nil && n.Prev.Header:
+			r.b.WriteString("<tbody>\n")
+		}
+		r.b.WriteString("<tr>\n")
+	case TableCell:
+		tag := "td"
+		if n.Header {
+			tag = "th"
+		}
+		if !entering {
+			fmt.Fprintf(&r.b, "</%s>\n", tag)
+		} else if a := n.Align[0]; a != AlignNone {
+			fmt.Fprintf(&r.b, `<%s align="%s">`, tag, alignNames[a])
+		} else {
+			fmt.Fprintf(&r.b, "<%s>", tag)
+		}
+	case Text:
+		r.esc(n.Literal)
+	case SoftBreak:
+		r.b.WriteByte('\n')
+	case LineBreak:
+		r.b.WriteString("<br />\n")
+	case Code:
+		r.b.WriteString("<code>")
+		r.esc(n.Literal)
+		r.b.WriteString("</code>")
+	case HTMLInline:
+		r.b.WriteString(n.Literal)
+	case Emph:
+		r.tag("em", entering)
+	case Strong:
+		r.tag("strong", entering)
+	case Strikethrough:
+		r.tag("del", entering)
+	case Link:
+		if !entering {
+			r.b.WriteString("</a>")
+			return true
+		}
+		r.b.WriteString(`<a href="`)
+		r.esc(NormalizeURL(n.Dest))
+		r.b.WriteByte('"')
+		if n.Title != "" {
+			r.b.WriteString(` title="`)
+			r.esc(n.Title)
+			r.b.WriteByte('"')
+		}
+		r.b.WriteByte('>')
+	case Image:
+		// The alt text is the plain text of the description.
+		r.b.WriteString(`<img src="`)
+		r.esc(NormalizeURL(n.Dest))
+		r.b.WriteString(`" alt="`)
+		n.Walk(func(c *Node, entering bool) bool {
+			switch {
+			case !entering:
+			case c.Kind == Text || c.Kind == Code || c.Kind == HTMLInline:
+				r.esc(c.Literal)
+			case c.Kind == SoftBreak || c.Kind == LineBreak:
+				r.b.WriteByte('\n')
+			}
+			return true
+		})
+		r.b.WriteByte('"')
+		if n.Title != "" {
+			r.b.WriteString(` title="`)
+			r.esc(n.Title)
+			r.b.WriteByte('"')
+		}
+		r.b.WriteString(" />")
+		return false
+	}
+	return true
+}
+
+func (r *htmlRenderer) tag(name string, entering bool) {
+	if entering {
+		fmt.Fprintf(&r.b, "<%s>", name)
+	} else {
+		fmt.Fprintf(&r.b, "</%s>", name)
+	}
+}
+
+// NormalizeURL percent-encodes the characters of a link destination that
+// may not appear in a URL, leaving existing %XX escapes alone.
+func NormalizeURL(s string) string {
+	const hex = "0123456789ABCDEF"
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case c == '%' && i+2 < len(s) && isHex(s[i+1]) && isHex(s[i+2]):
+			b.WriteString(s[i : i+3])
+			i += 2
+		case c < 0x80 && (c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || strings.IndexByte(";/?:@&=+$,-_.!~*'()#", c) >= 0):
+			b.WriteByte(c)
+		default:
+			b.WriteByte('%')
+			b.WriteByte(hex[c>>4])
+			b.WriteByte(hex[c&15])
+		}
+	}
+	return b.String()
+}
+
+func isHex(c byte) bool {
+	return c >= '0' && c <= '9' || c >= 'a' && c <= 'f' || c >= 'A' && c <= 'F'
+}
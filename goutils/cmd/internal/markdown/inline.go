@@ -0,0 +1,739 @@
+package markdown
+
+import (
+	"html"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+const (
+	tagName       = `[A-Za-z][A-Za-z0-9-]*`
+	attributeName = `[a-zA-Z_:][a-zA-Z0-9:._-]*`
+	attrValue     = "(?:[^\"'=<>`\\x00-\\x20]+|'[^']*'|\"[^\"]*\")"
+	attribute     = `(?:\s+` + attributeName + `(?:\s*=\s*` + attrValue + `)?)`
+	openTag       = `<` + tagName + attribute + `*\s*/?>`
+	closeTag      = `</` + tagName + `\s*>`
+	htmlComment   = `<!-->|<!--->|<!--[\s\S]*?-->`
+	processing    = `<[?][\s\S]*?[?]>`
+	declaration   = `<![A-Za-z][^>]*>`
+	cdata         = `<!\[CDATA\[[\s\S]*?\]\]>`
+)
+
+var (
+	reHTMLTag   = regexp.MustCompile(`^(?:` + openTag + `|` + closeTag + `|` + htmlComment + `|` + processing + `|` + declaration + `|` + cdata + `)`)
+	reEntity    = regexp.MustCompile(`^&(?:#[xX][0-9a-fA-F]{1,6};|#[0-9]{1,7};|[A-Za-z][A-Za-z0-9]{1,31};)`)
+	reAutolink  = regexp.MustCompile(`^<[A-Za-z][A-Za-z0-9.+-]{1,31}:[^<>\x00-\x20]*>`)
+	reEmailLink = regexp.MustCompile("^<[a-zA-Z0-9.!#$%&'*+/=?^_`{|}~-]+@[a-zA-Z0-9](?:[a-zA-Z0-9-]{0,61}[a-zA-Z0-9])?(?:\\.[a-zA-Z0-9](?:[a-zA-Z0-9-]{0,61}[a-zA-Z0-9])?)*>")
+)
+
+// delimiter is an entry of the stack of emphasis delimiter runs.
+type delimiter struct {
+	char              byte
+	num, orig         int
+	node              *Node
+	canOpen, canClose bool
+	prev, next        *delimiter
+}
+
+// bracket is an entry of the stack of link and image openers.
+type bracket struct {
+	node         *Node
+	prev         *bracket
+	prevDelim    *delimiter
+	index        int
+	image        bool
+	active       bool
+	bracketAfter bool
+}
+
+type inlineParser struct {
+	subject  string
+	pos      int
+	block    *Node
+	refs     map[string]ref
+	delims   *delimiter
+	brackets *bracket
+
+	lastPos, lastLine int
+}
+
+// parseInlines parses s as the inline content of block.
+func parseInlines(block *Node, s string, refs map[string]ref) {
+	p := &inlineParser{subject: s, block: block, refs: refs}
+	for p.pos < len(p.subject) {
+		if !p.parseInline() {
+			p.text(p.pos, p.subject[p.pos:p.pos+1])
+			p.pos++
+		}
+	}
+	p.processEmphasis(nil)
+	mergeText(block)
+}
+
+// mergeText joins adjacent Text nodes and drops empty ones.
+func mergeText(n *Node) {
+	for c := n.FirstChild; c != nil; c = c.Next {
+		if c.Kind != Text {
+			if c.FirstChild != nil {
+				mergeText(c)
+			}
+			continue
+		}
+		if c.Next == nil || c.Next.Kind != Text {
+			continue
+		}
+		var b strings.Builder
+		b.WriteString(c.Literal)
+		for c.Next != nil && c.Next.Kind == Text {
+			b.WriteString(c.Next.Literal)
+			c.Next.Unlink()
+		}
+		c.Literal = b.String()
+	}
+	for c := n.FirstChild; c != nil; {
+		next := c.Next
+		if c.Kind == Text && c.Literal == "" {
+			c.Unlink()
+		}
+		c = next
+	}
+}
+
+func (p *inlineParser) peek() byte {
+	if p.pos < len(p.subject) {
+		return p.subject[p.pos]
+	}
+	return 0
+}
+
+// lineAt returns the source line of the subject position pos.
+func (p *inlineParser) lineAt(pos int) int {
+	if pos < p.lastPos {
+		p.lastPos, p.lastLine = 0, 0
+	}
+	p.lastLine += strings.Count(p.subject[p.lastPos:pos], "\n")
+	p.lastPos = pos
+	return p.block.Line + p.lastLine
+}
+
+func (p *inlineParser) add(kind Kind, pos int) *Node {
+	n := &Node{Kind: kind, Line: p.lineAt(pos)}
+	p.block.AppendChild(n)
+	return n
+}
+
+func (p *inlineParser) text(pos int, s string) *Node {
+	n := p.add(Text, pos)
+	n.Literal = s
+	return n
+}
+
+func (p *inlineParser) parseInline() bool {
+	switch p.subject[p.pos] {
+	case '\n':
+		p.parseNewline()
+	case '\\':
+		p.parseBackslash()
+	case '`':
+		p.parseBackticks()
+	case '*', '_', '~':
+		return p.handleDelim(p.subject[p.pos])
+	case '[':
+		p.text(p.pos, "[")
+		p.addBracket(p.block.LastChild, p.pos, false)
+		p.pos++
+	case '!':
+		if p.pos+1 < len(p.subject) && p.subject[p.pos+1] == '[' {
+			p.text(p.pos, "![")
+			p.addBracket(p.block.LastChild, p.pos+1, true)
+			p.pos += 2
+		} else {
+			p.text(p.pos, "!")
+			p.pos++
+		}
+	case ']':
+		p.parseCloseBracket()
+	case '<':
+		return p.parseAutolink() || p.parseHTMLTag()
+	case '&':
+		p.parseEntity()
+	default:
+		p.parseString()
+	}
+	return true
+}
+
+func (p *inlineParser) parseString() {
+	start := p.pos
+	i := strings.IndexAny(p.subject[start:], "\n\\`*_~[]!<&")
+	if i < 0 {
+		p.pos = len(p.subject)
+	} else {
+		p.pos = start + i
+	}
+	if p.pos == start {
+		p.pos++
+	}
+	p.text(start, p.subject[start:p.pos])
+}
+
+func (p *inlineParser) parseNewline() {
+	start := p.pos
+	p.pos++
+	kind := SoftBreak
+	if last := p.block.LastChild; last != nil && last.Kind == Text && strings.HasSuffix(last.Literal, " ") {
+		if strings.HasSuffix(last.Literal, "  ") {
+			kind = LineBreak
+		}
+		last.Literal = strings.TrimRight(last.Literal, " ")
+	}
+	p.add(kind, start)
+	for p.peek() == ' ' || p.peek() == '\t' {
+		p.pos++
+	}
+}
+
+func isASCIIPunct(c byte) bool {
+	return strings.IndexByte("!\"#$%&'()*+,-./:;<=>?@[\\]^_`{|}~", c) >= 0
+}
+
+func (p *inlineParser) parseBackslash() {
+	start := p.pos
+	p.pos++
+	switch c := p.peek(); {
+	case c == '\n':
+		p.pos++
+		p.add(LineBreak, start)
+		for p.peek() == ' ' || p.peek() == '\t' {
+			p.pos++
+		}
+	case isASCIIPunct(c):
+		p.pos++
+		p.text(start, string(c))
+	default:
+		p.text(start, `\`)
+	}
+}
+
+func (p *inlineParser) parseBackticks() {
+	start := p.pos
+	for p.peek() == '`' {
+		p.pos++
+	}
+	ticks := p.pos - start
+	for i := p.pos; i < len(p.subject); {
+		if p.subject[i] != '`' {
+			i++
+			continue
+		}
+		j := i
+		for j < len(p.subject) && p.subject[j] == '`' {
+			j++
+		}
+		if j-i == ticks {
+			s := strings.ReplaceAll(p.subject[p.pos:i], "\n", " ")
+			if len(s) > 1 && s[0] == ' ' && s[len(s)-1] == ' ' && strings.Trim(s, " ") != "" {
+				s = s[1 : len(s)-1]
+			}
+			n := p.add(Code, start)
+			n.Literal = s
+			p.pos = j
+			return
+		}
+		i = j
+	}
+	p.text(start, p.subject[start:p.pos])
+}
+
+func isUnicodeSpace(r rune) bool {
+	return r == '\t' || r == '\n' || r == '\f' || r == '\r' || unicode.Is(unicode.Zs, r)
+}
+
+func isUnicodePunct(r rune) bool {
+	return r < utf8.RuneSelf && isASCIIPunct(byte(r)) || unicode.IsPunct(r) || unicode.IsSymbol(r)
+}
+
+func (p *inlineParser) handleDelim(c byte) bool {
+	start := p.pos
+	for p.peek() == c {
+		p.pos++
+	}
+	n := p.pos - start
+	before, after := '\n', '\n'
+	if start > 0 {
+		before, _ = utf8.DecodeLastRuneInString(p.subject[:start])
+	}
+	if p.pos < len(p.subject) {
+		after, _ = utf8.DecodeRuneInString(p.subject[p.pos:])
+	}
+	beforeSpace, afterSpace := isUnicodeSpace(before), isUnicodeSpace(after)
+	beforePunct, afterPunct := isUnicodePunct(before), isUnicodePunct(after)
+	left := !afterSpace && (!afterPunct || beforeSpace || beforePunct)
+	right := !beforeSpace && (!beforePunct || afterSpace || afterPunct)
+	canOpen, canClose := left, right
+	if c == '_' {
+		canOpen = left && (!right || beforePunct)
+		canClose = right && (!left || afterPunct)
+	}
+	node := p.text(start, p.subject[start:p.pos])
+	if (canOpen || canClose) && (c != '~' || n <= 2) {
+		d := &delimiter{char: c, num: n, orig: n, node: node, canOpen: canOpen, canClose: canClose, prev: p.delims}
+		if d.prev != nil {
+			d.prev.next = d
+		}
+		p.delims = d
+	}
+	return true
+}
+
+func (p *inlineParser) removeDelim(d *delimiter) {
+	if d.prev != nil {
+		d.prev.next = d.next
+	}
+	if d.next != nil {
+		d.next.prev = d.prev
+	} else {
+		p.delims = d.prev
+	}
+}
+
+// processEmphasis matches the delimiter runs above bottom into Emph,
+// Strong and Strikethrough nodes.
+func (p *inlineParser) processEmphasis(bottom *delimiter) {
+	type key struct {
+		char    byte
+		canOpen bool
+		mod     int
+	}
+	openersBottom := map[key]*delimiter{}
+	closer := p.delims
+	for closer != nil && closer.prev != bottom {
+		closer = closer.prev
+	}
+	for closer != nil {
+		if !closer.canClose {
+			closer = closer.next
+			continue
+		}
+		k := key{closer.char, closer.canOpen, closer.orig % 3}
+		ob, ok := openersBottom[k]
+		if !ok {
+			ob = bottom
+		}
+		opener, found := closer.prev, false
+		for opener != nil && opener != bottom && opener != ob {
+			if opener.char == closer.char && opener.canOpen {
+				if closer.char == '~' {
+					found = opener.num == closer.num
+				} else {
+					odd := (closer.canOpen || opener.canClose) && closer.orig%3 != 0 && (opener.orig+closer.orig)%3 == 0
+					found = !odd
+				}
+				if found {
+					break
+				}
+			}
+			opener = opener.prev
+		}
+		old := closer
+		if !found {
+			closer = closer.next
+			openersBottom[k] = old.prev
+			if !old.canOpen {
+				p.removeDelim(old)
+			}
+			continue
+		}
+		use, kind := 1, Emph
+		switch {
+		case closer.char == '~':
+			use, kind = closer.num, Strikethrough
+		case closer.num >= 2 && opener.num >= 2:
+			use, kind = 2, Strong
+		}
+		on, cn := opener.node, closer.node
+		opener.num -= use
+		closer.num -= use
+		on.Literal = on.Literal[:len(on.Literal)-use]
+		cn.Literal = cn.Literal[:len(cn.Literal)-use]
+		e := &Node{Kind: kind, Line: on.Line}
+		for t := on.Next; t != nil && t != cn; {
+			next := t.Next
+			e.AppendChild(t)
+			t = next
+		}
+		on.InsertAfter(e)
+		opener.next, closer.prev = closer, opener
+		if opener.num == 0 {
+			on.Unlink()
+			p.removeDelim(opener)
+		}
+		if closer.num == 0 {
+			next := closer.next
+			cn.Unlink()
+			p.removeDelim(closer)
+			closer = next
+		}
+	}
+	for p.delims != nil && p.delims != bottom {
+		p.removeDelim(p.delims)
+	}
+}
+
+func (p *inlineParser) addBracket(node *Node, index int, image bool) {
+	if p.brackets != nil {
+		p.brackets.bracketAfter = true
+	}
+	p.brackets = &bracket{node: node, prev: p.brackets, prevDelim: p.delims, index: index, image: image, active: true}
+}
+
+func (p *inlineParser) parseCloseBracket() {
+	start := p.pos
+	p.pos++
+	opener := p.brackets
+	if opener == nil {
+		p.text(start, "]")
+		return
+	}
+	if !opener.active {
+		p.text(start, "]")
+		p.brackets = opener.prev
+		return
+	}
+
+	var dest, title string
+	matched := false
+	save := p.pos
+	if p.peek() == '(' {
+		p.pos++
+		p.spnl()
+		if d, ok := p.parseLinkDestination(); ok {
+			dest = d
+			p.spnl()
+			if c := p.subject[p.pos-1]; c == ' ' || c == '\t' || c == '\n' {
+				if t, ok := p.parseLinkTitle(); ok {
+					title = t
+				}
+			}
+			p.spnl()
+			if p.peek() == ')' {
+				p.pos++
+				matched = true
+			}
+		}
+		if !matched {
+			p.pos = save
+		}
+	}
+	if !matched {
+		var label string
+		before := p.pos
+		n := p.parseLinkLabel()
+		if n > 2 {
+			label = p.subject[before : before+n]
+		} else if !opener.bracketAfter {
+			label = p.subject[opener.index : start+1]
+		}
+		if n == 0 {
+			p.pos = save
+		}
+		if label != "" {
+			if r, ok := p.refs[normalizeLabel(label)]; ok {
+				dest, title, matched = r.dest, r.title, true
+			}
+		}
+	}
+	if !matched {
+		p.brackets = opener.prev
+		p.pos = start + 1
+		p.text(start, "]")
+		return
+	}
+
+	kind := Link
+	if opener.image {
+		kind = Image
+	}
+	link := &Node{Kind: kind, Dest: dest, Title: title, Line: opener.node.Line}
+	for t := opener.node.Next; t != nil; {
+		next := t.Next
+		link.AppendChild(t)
+		t = next
+	}
+	p.block.AppendChild(link)
+	p.processEmphasis(opener.prevDelim)
+	p.brackets = opener.prev
+	opener.node.Unlink()
+	if !opener.image {
+		// Links may not contain other links.
+		for b := p.brackets; b != nil; b = b.prev {
+			if !b.image {
+				b.active = false
+			}
+		}
+	}
+}
+
+// spnl skips spaces and tabs with at most one line ending among them.
+func (p *inlineParser) spnl() {
+	for p.peek() == ' ' || p.peek() == '\t' {
+		p.pos++
+	}
+	if p.peek() == '\n' {
+		p.pos++
+		for p.peek() == ' ' || p.peek() == '\t' {
+			p.pos++
+		}
+	}
+}
+
+func (p *inlineParser) parseLinkDestination() (string, bool) {
+	s := p.subject
+	if p.peek() == '<' {
+		for i := p.pos + 1; i < len(s); i++ {
+			switch s[i] {
+			case '\\':
+				i++
+			case '\n', '<':
+				return "", false
+			case '>':
+				d := unescapeString(s[p.pos+1 : i])
+				p.pos = i + 1
+				return d, true
+			}
+		}
+		return "", false
+	}
+	i, depth := p.pos, 0
+loop:
+	for i < len(s) {
+		switch c := s[i]; {
+		case c == '\\' && i+1 < len(s) && isASCIIPunct(s[i+1]):
+			i += 2
+			continue
+		case c == '(':
+			if depth++; depth > 32 {
+				return "", false
+			}
+		case c == ')':
+			if depth == 0 {
+				break loop
+			}
+			depth--
+		case c <= ' ' || c == 0x7f:
+			break loop
+		}
+		i++
+	}
+	if i == p.pos && (i >= len(s) || s[i] != ')') || depth != 0 {
+		return "", false
+	}
+	d := unescapeString(s[p.pos:i])
+	p.pos = i
+	return d, true
+}
+
+func (p *inlineParser) parseLinkTitle() (string, bool) {
+	s := p.subject
+	end := byte(0)
+	switch p.peek() {
+	case '"', '\'':
+		end = p.peek()
+	case '(':
+		end = ')'
+	default:
+		return "", false
+	}
+	for i := p.pos + 1; i < len(s); i++ {
+		switch c := s[i]; {
+		case c == '\\':
+			i++
+		case c == end:
+			t := unescapeString(s[p.pos+1 : i])
+			p.pos = i + 1
+			return t, true
+		case c == '(' && end == ')':
+			return "", false
+		}
+	}
+	return "", false
+}
+
+// parseLinkLabel parses a [label] and returns its length, or 0.
+func (p *inlineParser) parseLinkLabel() int {
+	s := p.subject
+	if p.peek() != '[' {
+		return 0
+	}
+	for i := p.pos + 1; i < len(s) && i-p.pos <= 1000; i++ {
+		switch s[i] {
+		case '\\':
+			i++
+		case '[':
+			return 0
+		case ']':
+			n := i + 1 - p.pos
+			p.pos = i + 1
+			return n
+		}
+	}
+	return 0
+}
+
+// normalizeLabel returns the form of a [label] used to match link
+// references with their definitions.
+func normalizeLabel(s string) string {
+	s = strings.Join(strings.Fields(s[1:len(s)-1]), " ")
+	s = strings.ReplaceAll(strings.ToLower(s), "\u00df", "ss")
+	return strings.ToUpper(s)
+}
+
+func (p *inlineParser) parseAutolink() bool {
+	s := p.subject[p.pos:]
+	dest := reEmailLink.FindString(s)
+	mail := dest != ""
+	if !mail {
+		dest = reAutolink.FindString(s)
+	}
+	if dest == "" {
+		return false
+	}
+	n := p.add(Link, p.pos)
+	p.pos += len(dest)
+	dest = dest[1 : len(dest)-1]
+	n.Dest = dest
+	if mail {
+		n.Dest = "mailto:" + dest
+	}
+	n.AppendChild(&Node{Kind: Text, Literal: dest, Line: n.Line})
+	return true
+}
+
+func (p *inlineParser) parseHTMLTag() bool {
+	m := reHTMLTag.FindString(p.subject[p.pos:])
+	if m == "" {
+		return false
+	}
+	n := p.add(HTMLInline, p.pos)
+	n.Literal = m
+	p.pos += len(m)
+	return true
+}
+
+func (p *inlineParser) parseEntity() {
+	start := p.pos
+	m := reEntity.FindString(p.subject[p.pos:])
+	if m == "" {
+		p.pos++
+		p.text(start, "&")
+		return
+	}
+	p.pos += len(m)
+	p.text(start, decodeEntity(m))
+}
+
+// decodeEntity decodes a character reference; an unknown name is kept.
+func decodeEntity(m string) string {
+	if m[1] != '#' {
+		return html.UnescapeString(m)
+	}
+	var n uint64
+	if m[2] == 'x' || m[2] == 'X' {
+		n, _ = strconv.ParseUint(m[3:len(m)-1], 16, 32)
+	} else {
+		n, _ = strconv.ParseUint(m[2:len(m)-1], 10, 32)
+	}
+	r := rune(n)
+	if n == 0 || n > unicode.MaxRune || r >= 0xd800 && r <= 0xdfff {
+		r = utf8.RuneError
+	}
+	return string(r)
+}
+
+// unescapeString replaces backslash escapes and character references in
+// destinations, titles and info strings.
+func unescapeString(s string) string {
+	if !strings.ContainsAny(s, `\&`) {
+		return s
+	}
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		switch c := s[i]; {
+		case c == '\\' && i+1 < len(s) && isASCIIPunct(s[i+1]):
+			i++
+			b.WriteByte(s[i])
+		case c == '&':
+			if m := reEntity.FindString(s[i:]); m != "" {
+				b.WriteString(decodeEntity(m))
+				i += len(m) - 1
+			} else {
+				b.WriteByte(c)
+			}
+		default:
+			b.WriteByte(c)
+		}
+	}
+	return b.String()
+}
+
+// parseReference parses a link reference definition at the start of s,
+// records it in refs unless the label is already defined, and returns
+// the number of bytes it takes up, or 0 if there is none.
+func parseReference(s string, refs map[string]ref) int {
+	p := &inlineParser{subject: s}
+	n := p.parseLinkLabel()
+	if n == 0 || strings.TrimSpace(s[1:n-1]) == "" || p.peek() != ':' {
+		return 0
+	}
+	label := s[:n]
+	p.pos++
+	p.spnl()
+	dest, ok := p.parseLinkDestination()
+	if !ok {
+		return 0
+	}
+	beforeTitle := p.pos
+	p.spnl()
+	title, hasTitle := "", false
+	if p.pos != beforeTitle {
+		title, hasTitle = p.parseLinkTitle()
+	}
+	if !hasTitle {
+		p.pos = beforeTitle
+	}
+	if !p.atLineEnd() {
+		if !hasTitle {
+			return 0
+		}
+		title = ""
+		p.pos = beforeTitle
+		if !p.atLineEnd() {
+			return 0
+		}
+	}
+	key := normalizeLabel(label)
+	if _, dup := refs[key]; !dup {
+		refs[key] = ref{dest, title}
+	}
+	return p.pos
+}
+
+// atLineEnd skips trailing spaces and the line ending, if the rest of
+// the line is blank.
+func (p *inlineParser) atLineEnd() bool {
+	i := p.pos
+	for i < len(p.subject) && (p.subject[i] == ' ' || p.subject[i] == '\t') {
+		i++
+	}
+	if i < len(p.subject) && p.subject[i] != '\n' {
+		return false
+	}
+	if i < len(p.subject) {
+		i++
+	}
+	p.pos = i
+	return true
+}
@@ -0,0 +1,185 @@
+// Package markdown parses CommonMark (https://spec.commonmark.org/0.31.2/)
+// with the GitHub table and strikethrough extensions, and renders it as
+// HTML.
+//
+// Parse builds a tree of Nodes in two passes, as the specification
+// describes: the block structure line by line, then the inline content
+// of paragraphs, headings and table cells.
+package markdown
+
+import "strings"
+
+// Kind is the kind of a Node.
+type Kind int
+
+const (
+	Document Kind = iota
+	BlockQuote
+	List
+	Item
+	CodeBlock
+	HTMLBlock
+	Paragraph
+	Heading
+	ThematicBreak
+	Table
+	TableRow
+	TableCell
+
+	Text
+	SoftBreak
+	LineBreak
+	Code
+	Emph
+	Strong
+	Strikethrough
+	Link
+	Image
+	HTMLInline
+)
+
+// IsBlock reports whether k is a block kind.
+func (k Kind) IsBlock() bool { return k <= TableCell }
+
+// Align is the alignment of a table column.
+type Align int
+
+const (
+	AlignNone Align = iota
+	AlignLeft
+	AlignCenter
+	AlignRight
+)
+
+// ListData describes a List and each of its Items.
+type ListData struct {
+	Ordered bool
+	Bullet  byte // '-', '+' or '*' for bullet lists
+	Delim   byte // '.' or ')' for ordered lists
+	Start   int  // the number of the first item of an ordered list
+	Tight   bool // items are not separated by blank lines
+
+	markerOffset, padding int
+}
+
+// Node is a node of a parsed document.
+type Node struct {
+	Kind Kind
+
+	Parent, FirstChild, LastChild, Prev, Next *Node
+
+	// Literal is the text of Text, Code, CodeBlock, HTMLBlock and
+	// HTMLInline nodes.
+	Literal string
+	// Level is the level of a Heading, 1 to 6.
+	Level int
+	// Info is the info string of a fenced CodeBlock.
+	Info   string
+	Fenced bool
+	// List describes List and Item nodes.
+	List *ListData
+	// Dest and Title are the destination and title of a Link or Image.
+	Dest, Title string
+	// Align holds the column alignments of a Table, and the alignment of
+	// a TableCell in its first element.
+	Align []Align
+	// Header marks the header TableRow and its cells.
+	Header bool
+	// Line and EndLine are the first and last source lines of a block,
+	// counting from 1. Inline nodes have the line they start on.
+	Line, EndLine int
+
+	// Parser state.
+	open        bool
+	content     strings.Builder
+	fenceChar   byte
+	fenceLen    int
+	fenceOffset int
+	htmlType    int
+}
+
+// AppendChild adds c as the last child of n.
+func (n *Node) AppendChild(c *Node) {
+	c.Unlink()
+	c.Parent = n
+	if n.LastChild != nil {
+		n.LastChild.Next = c
+		c.Prev = n.LastChild
+	} else {
+		n.FirstChild = c
+	}
+	n.LastChild = c
+}
+
+// InsertAfter adds s as the sibling following n.
+func (n *Node) InsertAfter(s *Node) {
+	s.Unlink()
+	s.Next, s.Prev, s.Parent = n.Next, n, n.Parent
+	if n.Next != nil {
+		n.Next.Prev = s
+	} else if n.Parent != nil {
+		n.Parent.LastChild = s
+	}
+	n.Next = s
+}
+
+// Unlink detaches n from its parent and siblings.
+func (n *Node) Unlink() {
+	if n.Prev != nil {
+		n.Prev.Next = n.Next
+	} else if n.Parent != nil {
+		n.Parent.FirstChild = n.Next
+	}
+	if n.Next != nil {
+		n.Next.Prev = n.Prev
+	} else if n.Parent != nil {
+		n.Parent.LastChild = n.Prev
+	}
+	n.Parent, n.Prev, n.Next = nil, nil, nil
+}
+
+// IsLeaf reports whether nodes of kind k never have children.
+func (k Kind) IsLeaf() bool {
+	switch k {
+	case CodeBlock, HTMLBlock, ThematicBreak, Text, SoftBreak, LineBreak, Code, HTMLInline:
+		return true
+	}
+	return false
+}
+
+// Walk calls f for n and each of its descendants in document order,
+// with entering true before a node's children and false after them;
+// leaf nodes are only entered. If f returns false on entering, the
+// children are skipped.
+func (n *Node) Walk(f func(n *Node, entering bool) bool) {
+	if !f(n, true) || n.Kind.IsLeaf() {
+		return
+	}
+	for c := n.FirstChild; c != nil; {
+		next := c.Next
+		c.Walk(f)
+		c = next
+	}
+	f(n, false)
+}
+
+// Text returns the plain text of n: the literal text of its inline
+// descendants, with line breaks as spaces.
+func (n *Node) Text() string {
+	var b strings.Builder
+	n.Walk(func(c *Node, entering bool) bool {
+		if !entering {
+			return true
+		}
+		switch c.Kind {
+		case Text, Code:
+			b.WriteString(c.Literal)
+		case SoftBreak, LineBreak:
+			b.WriteByte(' ')
+		case HTMLInline:
+			return false
+		}
+		return true
+	})
+	return b.String()
+}
@@ -0,0 +1,237 @@
+package tabular
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// QuoteMode says which fields a CSVWriter quotes.
+type QuoteMode int
+
+const (
+	QuoteMinimal    QuoteMode = iota // only fields that need it
+	QuoteAll                         // every field
+	QuoteNonNumeric                  // every field that is not a number
+	QuoteNone                        // never; special characters are escaped instead
+)
+
+// ParseQuoteMode accepts "minimal", "all", "nonnumeric" and "none".
+func ParseQuoteMode(s string) (QuoteMode, error) {
+	switch strings.ToLower(s) {
+	case "", "minimal":
+		return QuoteMinimal, nil
+	case "all":
+		return QuoteAll, nil
+	case "nonnumeric", "non-numeric":
+		return QuoteNonNumeric, nil
+	case "none":
+		return QuoteNone, nil
+	}
+	return QuoteMinimal, fmt.Errorf("unknown quote mode %q (want minimal, all, nonnumeric or none)", s)
+}
+
+// Dialect describes a delimited text format. The zero value is RFC 4180
+// CSV: comma separated, double quotes, quotes escaped by doubling.
+type Dialect struct {
+	Comma  rune // field separator; 0 means ','
+	Quote  rune // quote character; 0 means '"'
+	Escape rune // escapes the next character; 0 means quotes are doubled
+	Mode   QuoteMode
+	CRLF   bool // end records with \r\n
+}
+
+func (d Dialect) withDefaults() Dialect {
+	if d.Comma == 0 {
+		d.Comma = ','
+	}
+	if d.Quote == 0 {
+		d.Quote = '"'
+	}
+	if d.Escape == d.Quote {
+		d.Escape = 0
+	}
+	return d
+}
+
+// standard reports whether encoding/csv can read the dialect.
+func (d Dialect) standard() bool {
+	d = d.withDefaults()
+	return d.Quote == '"' && d.Escape == 0
+}
+
+// CSVWriter writes records in a Dialect.
+type CSVWriter struct {
+	w *bufio.Writer
+	d Dialect
+}
+
+// NewCSVWriter returns a writer for d on w; call Flush when done.
+func NewCSVWriter(w io.Writer, d Dialect) *CSVWriter {
+	return &CSVWriter{w: bufio.NewWriter(w), d: d.withDefaults()}
+}
+
+func (c *CSVWriter) needsQuotes(field string) bool {
+	switch c.d.Mode {
+	case QuoteAll:
+		return true
+	case QuoteNone:
+		return false
+	case QuoteNonNumeric:
+		if _, err := strconv.ParseFloat(field, 64); err != nil && field != "" {
+			return true
+		}
+	}
+	if field == "" {
+		return false
+	}
+	if field[0] == ' ' || field[0] == '\t' {
+		return true
+	}
+	for _, r := range field {
+		if r == c.d.Comma || r == c.d.Quote || r == '\r' || r == '\n' || (c.d.Escape != 0 && r == c.d.Escape) {
+			return true
+		}
+	}
+	return false
+}
+
+// Write writes one record.
+func (c *CSVWriter) Write(record []string) error {
+	for i, field := range record {
+		if i > 0 {
+			c.w.WriteRune(c.d.Comma)
+		}
+		quoted := c.needsQuotes(field)
+		if quoted {
+			c.w.WriteRune(c.d.Quote)
+		}
+		for _, r := range field {
+			switch {
+			case quoted && r == c.d.Quote && c.d.Escape == 0:
+				c.w.WriteRune(r)
+			case c.d.Escape != 0 && (r == c.d.Quote || r == c.d.Escape):
+				c.w.WriteRune(c.d.Escape)
+			case !quoted && c.d.Escape != 0 && (r == c.d.Comma || r == '\n' || r == '\r'):
+				c.w.WriteRune(c.d.Escape)
+			}
+			c.w.WriteRune(r)
+		}
+		if quoted {
+			c.w.WriteRune(c.d.Quote)
+		}
+	}
+	if c.d.CRLF {
+		c.w.WriteString("\r\n")
+	} else {
+		c.w.WriteByte('\n')
+	}
+	return nil
+}
+
+// Flush writes any buffered data.
+func (c *CSVWriter) Flush() error {
+	return c.w.Flush()
+}
+
+// dialectReader parses dialects encoding/csv cannot: other quote
+// characters and backslash-style escapes.
+type dialectReader struct {
+	br   *bufio.Reader
+	d    Dialect
+	line int
+}
+
+var errUnterminated = errors.New("unterminated quoted field")
+
+func (p *dialectReader) Read() ([]string, error) {
+	for {
+		rec, err := p.readRecord()
+		if err != nil {
+			return nil, err
+		}
+		// Blank lines are skipped, as encoding/csv does.
+		if len(rec) == 1 && rec[0] == "" {
+			continue
+		}
+		return rec, nil
+	}
+}
+
+func (p *dialectReader) readRecord() ([]string, error) {
+	var fields []string
+	var sb strings.Builder
+	inQuotes, quoted, any := false, false, false
+	p.line++
+	start := p.line
+	for {
+		r, _, err := p.br.ReadRune()
+		if err == io.EOF {
+			if !any {
+				return nil, io.EOF
+			}
+			if inQuotes {
+				return nil, fmt.Errorf("line %d: %w", start, errUnterminated)
+			}
+			return append(fields, sb.String()), nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		any = true
+		if p.d.Escape != 0 && r == p.d.Escape {
+			next, _, err := p.br.ReadRune()
+			if err != nil {
+				sb.WriteRune(r)
+				continue
+			}
+			if next == '\n' {
+				p.line++
+			}
+			sb.WriteRune(next)
+			continue
+		}
+		if inQuotes {
+			switch {
+			case r == p.d.Quote:
+				if next, _, err := p.br.ReadRune(); err == nil {
+					if next == p.d.Quote && p.d.Escape == 0 {
+						sb.WriteRune(r)
+						continue
+					}
+					p.br.UnreadRune()
+				}
+				inQuotes = false
+			case r == '\n':
+				p.line++
+				sb.WriteRune(r)
+			default:
+				sb.WriteRune(r)
+			}
+			continue
+		}
+		switch {
+		case r == p.d.Comma:
+			fields = append(fields, sb.String())
+			sb.Reset()
+			quoted = false
+		case r == '\r':
+			if next, _, err := p.br.ReadRune(); err == nil {
+				if next == '\n' {
+					return append(fields, sb.String()), nil
+				}
+				p.br.UnreadRune()
+			}
+			sb.WriteRune(r)
+		case r == '\n':
+			return append(fields, sb.String()), nil
+		case r == p.d.Quote && sb.Len() == 0 && !quoted:
+			inQuotes, quoted = true, true
+		default:
+			sb.WriteRune(r)
+		}
+	}
+}
@@ -0,0 +1,192 @@
+package tabular
+
+import (
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Kind is the inferred type of a value or column.
+type Kind int
+
+const (
+	KindNull Kind = iota // empty: no evidence either way
+	KindBool
+	KindInt
+	KindFloat
+	KindDate
+	KindString
+)
+
+func (k Kind) String() string {
+	return [...]string{"null", "bool", "int", "float", "date", "string"}[k]
+}
+
+// dateLayouts are tried in order by ParseDate.
+var dateLayouts = []string{
+	"2006-01-02",
+	time.RFC3339Nano,
+	"2006-01-02T15:04:05",
+	"2006-01-02 15:04:05",
+	"2006-01-02 15:04",
+	"2006/01/02",
+	"01/02/2006",
+	"02-Jan-2006",
+	"Jan 2, 2006",
+	"2 Jan 2006",
+}
+
+// ParseDate recognises ISO 8601 dates and timestamps and a few common
+// human layouts.
+func ParseDate(s string) (time.Time, bool) {
+	if len(s) < 8 || len(s) > 35 {
+		return time.Time{}, false
+	}
+	for _, layout := range dateLayouts {
+		if t, err := time.Parse(layout, s); err == nil {
+			return t, true
+		}
+	}
+	return time.Time{}, false
+}
+
+// ParseBool accepts true/false and yes/no in any case.
+func ParseBool(s string) (value, ok bool) {
+	switch strings.ToLower(s) {
+	case "true", "yes":
+		return true, true
+	case "false", "no":
+		return false, true
+	}
+	return false, false
+}
+
+// ParseInt rejects numbers with leading zeros such as ZIP codes, which are
+// identifiers rather than quantities.
+func ParseInt(s string) (int64, bool) {
+	t := strings.TrimPrefix(strings.TrimPrefix(s, "-"), "+")
+	if len(t) > 1 && t[0] == '0' {
+		return 0, false
+	}
+	n, err := strconv.ParseInt(s, 10, 64)
+	return n, err == nil
+}
+
+// ParseFloat accepts decimal and exponent notation but not the words
+// "inf" or "nan", which are more likely to be text.
+func ParseFloat(s string) (float64, bool) {
+	if s == "" || strings.ContainsAny(s, "iInN") {
+		return 0, false
+	}
+	t := strings.TrimLeft(s, "+-")
+	if len(t) > 1 && t[0] == '0' && t[1] != '.' && t[1] != 'e' && t[1] != 'E' {
+		return 0, false
+	}
+	f, err := strconv.ParseFloat(s, 64)
+	return f, err == nil
+}
+
+// InferKind classifies one cell. Surrounding whitespace is ignored.
+func InferKind(s string) Kind {
+	s = strings.TrimSpace(s)
+	switch {
+	case s == "":
+		return KindNull
+	case isBool(s):
+		return KindBool
+	}
+	if _, ok := ParseInt(s); ok {
+		return KindInt
+	}
+	if _, ok := ParseFloat(s); ok {
+		return KindFloat
+	}
+	if _, ok := ParseDate(s); ok {
+		return KindDate
+	}
+	return KindString
+}
+
+func isBool(s string) bool {
+	_, ok := ParseBool(s)
+	return ok
+}
+
+// Merge widens a column kind to cover another value's kind.
+func Merge(a, b Kind) Kind {
+	switch {
+	case a == b || b == KindNull:
+		return a
+	case a == KindNull:
+		return b
+	case (a == KindInt && b == KindFloat) || (a == KindFloat && b == KindInt):
+		return KindFloat
+	}
+	return KindString
+}
+
+// InferColumns returns the kind of each column of rows.
+func InferColumns(ncols int, rows [][]string) []Kind {
+	kinds := make([]Kind, ncols)
+	for _, row := range rows {
+		for i := 0; i < ncols && i < len(row); i++ {
+			if kinds[i] != KindString {
+				kinds[i] = Merge(kinds[i], InferKind(row[i]))
+			}
+		}
+	}
+	return kinds
+}
+
+// Convert parses s as kind, returning nil for empty cells and the raw
+// string for values that do not fit (which Merge rules out for inferred
+// kinds). The result is a bool, int64, float64, time.Time or string.
+func Convert(s string, kind Kind) interface{} {
+	t := strings.TrimSpace(s)
+	if t == "" {
+		return nil
+	}
+	switch kind {
+	case KindBool:
+		if v, ok := ParseBool(t); ok {
+			return v
+		}
+	case KindInt:
+		if v, ok := ParseInt(t); ok {
+			return v
+		}
+	case KindFloat:
+		if v, ok := ParseFloat(t); ok {
+			return v
+		}
+	case KindDate:
+		if v, ok := ParseDate(t); ok {
+			return v
+		}
+	}
+	return s
+}
+
+// FormatValue renders a converted value as text: floats without exponents
+// or trailing zeros, and dates as ISO 8601 (without a time of day when it
+// is midnight UTC).
+func FormatValue(v interface{}) string {
+	switch v := v.(type) {
+	case nil:
+		return ""
+	case string:
+		return v
+	case bool:
+		return strconv.FormatBool(v)
+	case int64:
+		return strconv.FormatInt(v, 10)
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	case time.Time:
+		if v.Location() == time.UTC && v.Hour() == 0 && v.Minute() == 0 && v.Second() == 0 && v.Nanosecond() == 0 {
+			return v.Format("2006-01-02")
+		}
+		return v.Format(time.RFC3339)
+	}
+	return ""
+}
@@ -0,0 +1,364 @@
+// Package tabular reads and writes row-oriented data for the csv family of
+// commands: CSV, TSV, JSON and JSON Lines input, per-column type inference,
+// and table, Markdown, CSV and JSON output.
+package tabular
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// Format is an input encoding.
+type Format int
+
+const (
+	FormatAuto Format = iota
+	FormatCSV
+	FormatTSV
+	FormatJSONL
+)
+
+// ParseFormat accepts "csv", "tsv", "jsonl" (or "ndjson") and "auto".
+func ParseFormat(s string) (Format, error) {
+	switch strings.ToLower(s) {
+	case "", "auto":
+		return FormatAuto, nil
+	case "csv":
+		return FormatCSV, nil
+	case "tsv", "tab":
+		return FormatTSV, nil
+	case "jsonl", "ndjson", "json":
+		return FormatJSONL, nil
+	}
+	return FormatAuto, fmt.Errorf("unknown format %q (want csv, tsv or jsonl)", s)
+}
+
+// FormatForName guesses the format from a file extension, returning
+// FormatAuto when the extension says nothing.
+func FormatForName(name string) Format {
+	switch strings.ToLower(filepath.Ext(name)) {
+	case ".csv":
+		return FormatCSV
+	case ".tsv", ".tab":
+		return FormatTSV
+	case ".jsonl", ".ndjson", ".json":
+		return FormatJSONL
+	}
+	return FormatAuto
+}
+
+// Options configures a Reader.
+type Options struct {
+	Format   Format
+	Comma    rune // CSV delimiter; 0 means ',' (or tab for TSV)
+	Quote    rune // CSV quote character; 0 means '"'
+	Escape   rune // CSV escape character; 0 means quotes are doubled
+	NoHeader bool // CSV/TSV: the first row is data; columns are named 1, 2, ...
+
+	// Flatten spreads nested JSON objects and arrays over columns named by
+	// their path, such as "address.city" or "tags.0", instead of returning
+	// them as compact JSON.
+	Flatten   bool
+	Separator string // path separator for Flatten; "" means "."
+}
+
+// recordReader is satisfied by encoding/csv and dialectReader.
+type recordReader interface {
+	Read() ([]string, error)
+}
+
+// Reader streams rows of strings from CSV, TSV or JSON input.
+//
+// JSON input may be JSON Lines, any sequence of JSON values, or a single
+// array of them; it is decoded one value at a time. Objects are rows, arrays
+// are rows of positional columns named 1, 2, ..., and other values fill a
+// column named "value". The header is the union of the keys in order of
+// first appearance, so it can grow as rows are read; rows returned before a
+// new key appeared are shorter than the final header. Nested values are
+// returned as compact JSON (or flattened, see Options.Flatten) and null as
+// an empty string.
+type Reader struct {
+	opt     Options
+	br      *bufio.Reader
+	csv     recordReader
+	dec     *json.Decoder
+	inArray bool
+	header  []string
+	index   map[string]int
+	first   []string // a data row read while producing the header
+	records int
+	begun   bool
+}
+
+// NewReader returns a Reader for r. With FormatAuto the input is sniffed:
+// a leading '{' or '[' means JSON, anything else CSV.
+func NewReader(r io.Reader, opt Options) *Reader {
+	return &Reader{opt: opt, br: bufio.NewReaderSize(r, 64*1024)}
+}
+
+func (r *Reader) start() error {
+	if r.begun {
+		return nil
+	}
+	r.begun = true
+	// A UTF-8 byte order mark is not part of the first column name.
+	if b, err := r.br.Peek(3); err == nil && bytes.Equal(b, []byte{0xef, 0xbb, 0xbf}) {
+		r.br.Discard(3)
+	}
+	if r.opt.Format == FormatAuto {
+		r.opt.Format = FormatCSV
+		for {
+			b, err := r.br.Peek(1)
+			if err != nil || !(b[0] == ' ' || b[0] == '\t' || b[0] == '\r' || b[0] == '\n') {
+				if err == nil && b[0] == '{' {
+					r.opt.Format = FormatJSONL
+				}
+				break
+			}
+			r.br.ReadByte()
+		}
+	}
+	if r.opt.Format == FormatJSONL {
+		r.index = map[string]int{}
+		r.dec = json.NewDecoder(r.br)
+		r.dec.UseNumber()
+		if b, err := r.peekNonSpace(); err == nil && b == '[' {
+			r.dec.Token()
+			r.inArray = true
+		}
+		return nil
+	}
+	d := Dialect{Comma: r.opt.Comma, Quote: r.opt.Quote, Escape: r.opt.Escape}
+	if d.Comma == 0 && r.opt.Format == FormatTSV {
+		d.Comma = '\t'
+	}
+	d = d.withDefaults()
+	if d.standard() {
+		cr := csv.NewReader(r.br)
+		cr.Comma = d.Comma
+		cr.LazyQuotes = true
+		cr.TrimLeadingSpace = true
+		cr.FieldsPerRecord = -1
+		r.csv = cr
+	} else {
+		r.csv = &dialectReader{br: r.br, d: d}
+	}
+	rec, err := r.csv.Read()
+	if err == io.EOF {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if r.opt.NoHeader {
+		for i := range rec {
+			r.header = append(r.header, strconv.Itoa(i+1))
+		}
+		r.first = rec
+	} else {
+		r.header = append([]string(nil), rec...)
+	}
+	return nil
+}
+
+// Header returns the column names known so far.
+func (r *Reader) Header() ([]string, error) {
+	if err := r.start(); err != nil {
+		return nil, err
+	}
+	if r.opt.Format == FormatJSONL && r.header == nil && r.first == nil {
+		row, err := r.readJSON()
+		if err != nil && err != io.EOF {
+			return nil, err
+		}
+		r.first = row
+	}
+	return r.header, nil
+}
+
+// Read returns the next row, or io.EOF.
+func (r *Reader) Read() ([]string, error) {
+	if err := r.start(); err != nil {
+		return nil, err
+	}
+	if r.first != nil {
+		row := r.first
+		r.first = nil
+		return row, nil
+	}
+	if r.opt.Format == FormatJSONL {
+		return r.readJSON()
+	}
+	if r.csv == nil {
+		return nil, io.EOF
+	}
+	return r.csv.Read()
+}
+
+func (r *Reader) peekNonSpace() (byte, error) {
+	for {
+		b, err := r.br.Peek(1)
+		if err != nil {
+			return 0, err
+		}
+		if b[0] != ' ' && b[0] != '\t' && b[0] != '\r' && b[0] != '\n' {
+			return b[0], nil
+		}
+		r.br.ReadByte()
+	}
+}
+
+func (r *Reader) readJSON() ([]string, error) {
+	if r.inArray && !r.dec.More() {
+		r.dec.Token() // the closing ']'
+		r.inArray = false
+	}
+	var raw json.RawMessage
+	if err := r.dec.Decode(&raw); err != nil {
+		if err == io.EOF {
+			return nil, err
+		}
+		return nil, fmt.Errorf("record %d: %w", r.records+1, err)
+	}
+	r.records++
+	row, err := r.jsonRow(raw)
+	if err != nil {
+		return nil, fmt.Errorf("record %d: %w", r.records, err)
+	}
+	return row, nil
+}
+
+// jsonRow turns one JSON value into a row, keeping object key order.
+func (r *Reader) jsonRow(raw json.RawMessage) ([]string, error) {
+	row := make([]string, len(r.header))
+	set := func(key string, val json.RawMessage) {
+		i, ok := r.index[key]
+		if !ok {
+			i = len(r.header)
+			r.index[key] = i
+			r.header = append(r.header, key)
+			row = append(row, "")
+		}
+		row[i] = jsonCell(val)
+	}
+	raw = bytes.TrimSpace(raw)
+	switch raw[0] {
+	case '{', '[':
+		err := r.walk(raw, "", func(key string, val json.RawMessage) error {
+			if r.opt.Flatten {
+				return r.walk(val, key, nil, set)
+			}
+			set(key, val)
+			return nil
+		}, nil)
+		return row, err
+	}
+	set("value", raw)
+	return row, nil
+}
+
+// walk calls member for each member of the object or array raw, naming it
+// by its path below prefix. With member nil it descends recursively and
+// calls leaf for every scalar and empty container instead.
+func (r *Reader) walk(raw json.RawMessage, prefix string, member func(string, json.RawMessage) error, leaf func(string, json.RawMessage)) error {
+	raw = bytes.TrimSpace(raw)
+	if len(raw) == 0 || (raw[0] != '{' && raw[0] != '[') {
+		leaf(prefix, raw)
+		return nil
+	}
+	sep := r.opt.Separator
+	if sep == "" {
+		sep = "."
+	}
+	dec := json.NewDecoder(bytes.NewReader(raw))
+	dec.UseNumber()
+	tok, err := dec.Token()
+	if err != nil {
+		return err
+	}
+	object := tok == json.Delim('{')
+	n := 0
+	for ; dec.More(); n++ {
+		var key string
+		if object {
+			tok, err := dec.Token()
+			if err != nil {
+				return err
+			}
+			key = tok.(string)
+		} else {
+			key = strconv.Itoa(n)
+			if prefix == "" {
+				key = strconv.Itoa(n + 1)
+			}
+		}
+		if prefix != "" {
+			key = prefix + sep + key
+		}
+		var val json.RawMessage
+		if err := dec.Decode(&val); err != nil {
+			return err
+		}
+		if member != nil {
+			err = member(key, val)
+		} else {
+			err = r.walk(val, key, nil, leaf)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	if n == 0 && member == nil {
+		leaf(prefix, raw)
+	}
+	return nil
+}
+
+func jsonCell(raw json.RawMessage) string {
+	raw = bytes.TrimSpace(raw)
+	switch {
+	case len(raw) == 0 || string(raw) == "null":
+		return ""
+	case raw[0] == '"':
+		var s string
+		json.Unmarshal(raw, &s)
+		return s
+	case raw[0] == '{' || raw[0] == '[':
+		var buf bytes.Buffer
+		json.Compact(&buf, raw)
+		return buf.String()
+	}
+	return string(raw)
+}
+
+// ReadAll reads the header and every row, padding rows to the final
+// header length.
+func (r *Reader) ReadAll() ([]string, [][]string, error) {
+	if _, err := r.Header(); err != nil {
+		return nil, nil, err
+	}
+	var rows [][]string
+	for {
+		row, err := r.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, nil, err
+		}
+		rows = append(rows, row)
+	}
+	for i, row := range rows {
+		if len(row) < len(r.header) {
+			rows[i] = append(row, make([]string, len(r.header)-len(row))...)
+		}
+	}
+	return r.header, rows, nil
+}
@@ -0,0 +1,201 @@
+package tabular
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"goutils/lib/term"
+)
+
+// border holds the characters of one table style.
+type border struct {
+	h, v       string
+	tl, tm, tr string
+	ml, mm, mr string
+	bl, bm, br string
+}
+
+var borders = map[string]border{
+	"unicode": {"─", "│", "┌", "┬", "┐", "├", "┼", "┤", "└", "┴", "┘"},
+	"ascii":   {"-", "|", "+", "+", "+", "+", "+", "+", "+", "+", "+"},
+	"minimal": {" ", " ", " ", " ", " ", " ", " ", " ", " ", " ", " "},
+}
+
+// Styles lists the table styles WriteTable accepts.
+var Styles = []string{"unicode", "ascii", "minimal"}
+
+// TableOptions controls WriteTable.
+type TableOptions struct {
+	Style      string // unicode (default), ascii or minimal
+	MaxWidth   int    // truncate cells to this many columns; 0 means no limit
+	RightAlign []bool // per column, typically set for numeric columns
+	NoCount    bool   // omit the "N rows" footer
+}
+
+// Truncate shortens s to max display columns, marking the cut with "...".
+func Truncate(s string, max int) string {
+	if max <= 0 {
+		return s
+	}
+	return term.Truncate(s, max, "...")
+}
+
+// WriteTable draws header and rows as a bordered table followed by a row
+// count, as csvlook does.
+func WriteTable(w io.Writer, header []string, rows [][]string, opt TableOptions) error {
+	b, ok := borders[opt.Style]
+	if !ok {
+		b = borders["unicode"]
+	}
+	cell := func(row []string, i int) string {
+		if i < len(row) {
+			return Truncate(row[i], opt.MaxWidth)
+		}
+		return ""
+	}
+	widths := make([]int, len(header))
+	for i := range header {
+		widths[i] = term.StringWidth(cell(header, i))
+		for _, row := range rows {
+			if n := term.StringWidth(cell(row, i)); n > widths[i] {
+				widths[i] = n
+			}
+		}
+	}
+
+	bw := bufio.NewWriter(w)
+	rule := func(left, mid, right string) {
+		bw.WriteString(left)
+		for i, width := range widths {
+			bw.WriteString(strings.Repeat(b.h, width+2))
+			if i < len(widths)-1 {
+				bw.WriteString(mid)
+			}
+		}
+		bw.WriteString(right + "\n")
+	}
+	line := func(row []string, align bool) {
+		bw.WriteString(b.v)
+		for i, width := range widths {
+			s := cell(row, i)
+			gap := strings.Repeat(" ", width-term.StringWidth(s))
+			if align && i < len(opt.RightAlign) && opt.RightAlign[i] {
+				s = gap + s
+			} else {
+				s += gap
+			}
+			bw.WriteString(" " + s + " " + b.v)
+		}
+		bw.WriteString("\n")
+	}
+
+	rule(b.tl, b.tm, b.tr)
+	line(header, false)
+	rule(b.ml, b.mm, b.mr)
+	for _, row := range rows {
+		line(row, true)
+	}
+	rule(b.bl, b.bm, b.br)
+	if !opt.NoCount {
+		fmt.Fprintf(bw, "\n%d rows\n", len(rows))
+	}
+	return bw.Flush()
+}
+
+// WriteMarkdown writes header and rows as a GitHub-flavoured Markdown
+// table. Pipes in cells are escaped and newlines become spaces.
+func WriteMarkdown(w io.Writer, header []string, rows [][]string, rightAlign []bool) error {
+	bw := bufio.NewWriter(w)
+	esc := strings.NewReplacer("|", "\\|", "\r\n", " ", "\n", " ")
+	line := func(row []string) {
+		bw.WriteString("|")
+		for i := range header {
+			s := ""
+			if i < len(row) {
+				s = esc.Replace(row[i])
+			}
+			bw.WriteString(" " + s + " |")
+		}
+		bw.WriteString("\n")
+	}
+	line(header)
+	bw.WriteString("|")
+	for i := range header {
+		if i < len(rightAlign) && rightAlign[i] {
+			bw.WriteString(" ---: |")
+		} else {
+			bw.WriteString(" --- |")
+		}
+	}
+	bw.WriteString("\n")
+	for _, row := range rows {
+		line(row)
+	}
+	return bw.Flush()
+}
+
+// WriteCSV writes header and rows as CSV with the given delimiter.
+func WriteCSV(w io.Writer, header []string, rows [][]string, comma rune) error {
+	cw := csv.NewWriter(w)
+	if comma != 0 {
+		cw.Comma = comma
+	}
+	cw.Write(header)
+	for _, row := range rows {
+		cw.Write(row)
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+// WriteJSON writes rows as an indented JSON array of objects whose keys
+// follow the header order. Values are typed: nil, bool, int64, float64 and
+// time.Time become null, booleans, numbers and ISO 8601 strings.
+func WriteJSON(w io.Writer, header []string, rows [][]interface{}) error {
+	bw := bufio.NewWriter(w)
+	keys := make([]string, len(header))
+	for i, h := range header {
+		k, _ := json.Marshal(h)
+		keys[i] = string(k)
+	}
+	bw.WriteString("[")
+	for r, row := range rows {
+		if r > 0 {
+			bw.WriteString(",")
+		}
+		bw.WriteString("\n  {")
+		for i, k := range keys {
+			if i > 0 {
+				bw.WriteString(",")
+			}
+			var v interface{}
+			if i < len(row) {
+				v = row[i]
+			}
+			bw.WriteString("\n    " + k + ": " + jsonValue(v))
+		}
+		bw.WriteString("\n  }")
+	}
+	if len(rows) > 0 {
+		bw.WriteString("\n")
+	}
+	bw.WriteString("]\n")
+	return bw.Flush()
+}
+
+func jsonValue(v interface{}) string {
+	if t, ok := v.(time.Time); ok {
+		v = FormatValue(t)
+	}
+	b, err := json.Marshal(v)
+	if err != nil {
+		// NaN and infinities have no JSON form.
+		return "null"
+	}
+	return string(b)
+}
@@ -0,0 +1,197 @@
+// Package x509util inspects X.509 material for tlsinfo and certinfo: it
+// loads certificates, CSRs, CRLs, keys and PKCS#12 bundles in PEM or DER,
+// validates chains, checks revocation over OCSP and CRL, dials STARTTLS
+// services and issues certificates from a local test CA.
+package x509util
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// Bundle holds everything that was found in one input.
+type Bundle struct {
+	Certificates []*x509.Certificate
+	Requests     []*x509.CertificateRequest
+	CRLs         []*x509.RevocationList
+	Keys         []crypto.PrivateKey
+}
+
+func (b *Bundle) empty() bool {
+	return len(b.Certificates) == 0 && len(b.Requests) == 0 && len(b.CRLs) == 0 && len(b.Keys) == 0
+}
+
+// ParseFile reads path and parses it with Parse.
+func ParseFile(path, password string) (*Bundle, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	b, err := Parse(data, password)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return b, nil
+}
+
+// Parse detects whether data is PEM or DER and decodes every certificate,
+// certificate request, CRL and private key it contains. DER input may be a
+// certificate (or several concatenated), a CSR, a CRL, a PKCS#8 key or a
+// PKCS#12 bundle. PKCS#12 bundles and encrypted PKCS#8 keys are decrypted
+// with password.
+func Parse(data []byte, password string) (*Bundle, error) {
+	if bytes.Contains(data, []byte("-----BEGIN ")) {
+		return parsePEM(data, password)
+	}
+	return parseDER(data, password)
+}
+
+func parsePEM(data []byte, password string) (*Bundle, error) {
+	b := &Bundle{}
+	for {
+		var block *pem.Block
+		block, data = pem.Decode(data)
+		if block == nil {
+			break
+		}
+		var err error
+		switch block.Type {
+		case "CERTIFICATE", "TRUSTED CERTIFICATE", "X509 CERTIFICATE":
+			var c *x509.Certificate
+			if c, err = x509.ParseCertificate(block.Bytes); err == nil {
+				b.Certificates = append(b.Certificates, c)
+			}
+		case "CERTIFICATE REQUEST", "NEW CERTIFICATE REQUEST":
+			var r *x509.CertificateRequest
+			if r, err = x509.ParseCertificateRequest(block.Bytes); err == nil {
+				b.Requests = append(b.Requests, r)
+			}
+		case "X509 CRL":
+			var l *x509.RevocationList
+			if l, err = x509.ParseRevocationList(block.Bytes); err == nil {
+				b.CRLs = append(b.CRLs, l)
+			}
+		case "PRIVATE KEY", "RSA PRIVATE KEY", "EC PRIVATE KEY":
+			var k crypto.PrivateKey
+			if k, err = ParsePrivateKey(block.Bytes); err == nil {
+				b.Keys = append(b.Keys, k)
+			}
+		case "ENCRYPTED PRIVATE KEY":
+			var k crypto.PrivateKey
+			if k, err = DecryptPKCS8(block.Bytes, password); err == nil {
+				b.Keys = append(b.Keys, k)
+			}
+		case "PKCS12":
+			var sub *Bundle
+			if sub, err = parseDER(block.Bytes, password); err == nil {
+				b.merge(sub)
+			}
+		default:
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", block.Type, err)
+		}
+	}
+	if b.empty() {
+		return nil, errors.New("no PEM blocks found")
+	}
+	return b, nil
+}
+
+func parseDER(data []byte, password string) (*Bundle, error) {
+	b := &Bundle{}
+	if certs, err := x509.ParseCertificates(data); err == nil && len(certs) > 0 {
+		b.Certificates = certs
+		return b, nil
+	}
+	if r, err := x509.ParseCertificateRequest(data); err == nil {
+		b.Requests = append(b.Requests, r)
+		return b, nil
+	}
+	if l, err := x509.ParseRevocationList(data); err == nil {
+		b.CRLs = append(b.CRLs, l)
+		return b, nil
+	}
+	if k, err := ParsePrivateKey(data); err == nil {
+		b.Keys = append(b.Keys, k)
+		return b, nil
+	}
+	certs, keys, err := DecodePKCS12(data, password)
+	if err == nil {
+		b.Certificates, b.Keys = certs, keys
+		return b, nil
+	}
+	if errors.Is(err, ErrIncorrectPassword) {
+		return nil, err
+	}
+	return nil, errors.New("unrecognised DER data (not a certificate, CSR, CRL, key or PKCS#12 bundle)")
+}
+
+func (b *Bundle) merge(o *Bundle) {
+	b.Certificates = append(b.Certificates, o.Certificates...)
+	b.Requests = append(b.Requests, o.Requests...)
+	b.CRLs = append(b.CRLs, o.CRLs...)
+	b.Keys = append(b.Keys, o.Keys...)
+}
+
+// ParsePrivateKey accepts PKCS#8, PKCS#1 RSA and SEC 1 EC private keys.
+func ParsePrivateKey(der []byte) (crypto.PrivateKey, error) {
+	if k, err := x509.ParsePKCS8PrivateKey(der); err == nil {
+		return k, nil
+	}
+	if k, err := x509.ParsePKCS1PrivateKey(der); err == nil {
+		return k, nil
+	}
+	if k, err := x509.ParseECPrivateKey(der); err == nil {
+		return k, nil
+	}
+	return nil, errors.New("unsupported private key format")
+}
+
+// LoadRoots builds a certificate pool from a file or from every file in a
+// directory.
+func LoadRoots(path string) (*x509.CertPool, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	files := []string{path}
+	if info.IsDir() {
+		entries, err := os.ReadDir(path)
+		if err != nil {
+			return nil, err
+		}
+		files = files[:0]
+		for _, e := range entries {
+			if !e.IsDir() {
+				files = append(files, filepath.Join(path, e.Name()))
+			}
+		}
+	}
+	pool := x509.NewCertPool()
+	n := 0
+	for _, f := range files {
+		b, err := ParseFile(f, "")
+		if err != nil {
+			if info.IsDir() {
+				continue
+			}
+			return nil, err
+		}
+		for _, c := range b.Certificates {
+			pool.AddCert(c)
+			n++
+		}
+	}
+	if n == 0 {
+		return nil, fmt.Errorf("%s: no certificates found", path)
+	}
+	return pool, nil
+}
@@ -0,0 +1,214 @@
+package x509util
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"net"
+	"net/mail"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// CA is a local certificate authority used to issue test certificates.
+type CA struct {
+	Cert *x509.Certificate
+	Key  crypto.Signer
+}
+
+// Names of the files a CA directory holds, matching mkcert's layout.
+const (
+	CACertFile = "rootCA.pem"
+	CAKeyFile  = "rootCA-key.pem"
+)
+
+// MaxLeafDays is the longest validity browsers accept for a leaf
+// certificate. mkcert issues leaves for this long by default, and Weaknesses
+// warns about leaves that exceed it.
+const MaxLeafDays = 398
+
+// DefaultCADir is $CAROOT, or a directory under the user's data dir.
+func DefaultCADir() string {
+	if d := os.Getenv("CAROOT"); d != "" {
+		return d
+	}
+	if d := os.Getenv("XDG_DATA_HOME"); d != "" {
+		return filepath.Join(d, "certinfo")
+	}
+	home, _ := os.UserHomeDir()
+	return filepath.Join(home, ".local", "share", "certinfo")
+}
+
+// LoadOrCreateCA loads the CA stored in dir, creating a new one when the
+// directory does not contain one yet. created reports which happened.
+func LoadOrCreateCA(dir string) (ca *CA, created bool, err error) {
+	certPath, keyPath := filepath.Join(dir, CACertFile), filepath.Join(dir, CAKeyFile)
+	if _, err := os.Stat(certPath); err == nil {
+		ca, err := loadCA(certPath, keyPath)
+		return ca, false, err
+	}
+	ca, err = NewCA()
+	if err != nil {
+		return nil, false, err
+	}
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, false, err
+	}
+	if err := WriteCertificate(certPath, ca.Cert.Raw); err != nil {
+		return nil, false, err
+	}
+	if err := WritePrivateKey(keyPath, ca.Key); err != nil {
+		return nil, false, err
+	}
+	return ca, true, nil
+}
+
+func loadCA(certPath, keyPath string) (*CA, error) {
+	cb, err := ParseFile(certPath, "")
+	if err != nil {
+		return nil, err
+	}
+	kb, err := ParseFile(keyPath, "")
+	if err != nil {
+		return nil, err
+	}
+	if len(cb.Certificates) == 0 || len(kb.Keys) == 0 {
+		return nil, errors.New("CA directory is missing the certificate or key")
+	}
+	signer, ok := kb.Keys[0].(crypto.Signer)
+	if !ok {
+		return nil, errors.New("CA key cannot sign")
+	}
+	return &CA{Cert: cb.Certificates[0], Key: signer}, nil
+}
+
+// NewCA creates a self-signed ECDSA P-256 root valid for ten years.
+func NewCA() (*CA, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	user := os.Getenv("USER")
+	host, _ := os.Hostname()
+	tmpl := &x509.Certificate{
+		SerialNumber: randomSerial(),
+		Subject: pkix.Name{
+			Organization:       []string{"certinfo development CA"},
+			OrganizationalUnit: []string{user + "@" + host},
+			CommonName:         "certinfo " + user + "@" + host,
+		},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().AddDate(10, 0, 0),
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+		MaxPathLenZero:        true,
+		SubjectKeyId:          keyID(&key.PublicKey),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		return nil, err
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		return nil, err
+	}
+	return &CA{Cert: cert, Key: key}, nil
+}
+
+// Issue signs a leaf certificate for names, which may be DNS names
+// (including wildcards), IP addresses, e-mail addresses or URIs.
+func (ca *CA) Issue(names []string, validity time.Duration, client bool) (*x509.Certificate, crypto.Signer, error) {
+	if len(names) == 0 {
+		return nil, nil, errors.New("at least one name is required")
+	}
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, nil, err
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: randomSerial(),
+		Subject: pkix.Name{
+			Organization: []string{"certinfo development certificate"},
+			CommonName:   names[0],
+		},
+		NotBefore:      time.Now().Add(-time.Hour),
+		NotAfter:       time.Now().Add(validity),
+		KeyUsage:       x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:    []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		AuthorityKeyId: ca.Cert.SubjectKeyId,
+	}
+	if client {
+		tmpl.ExtKeyUsage = append(tmpl.ExtKeyUsage, x509.ExtKeyUsageClientAuth)
+	}
+	for _, n := range names {
+		if ip := net.ParseIP(n); ip != nil {
+			tmpl.IPAddresses = append(tmpl.IPAddresses, ip)
+		} else if a, err := mail.ParseAddress(n); err == nil && a.Address == n {
+			tmpl.EmailAddresses = append(tmpl.EmailAddresses, n)
+		} else if u, err := url.Parse(n); err == nil && u.Scheme != "" && u.Host != "" {
+			tmpl.URIs = append(tmpl.URIs, u)
+		} else {
+			tmpl.DNSNames = append(tmpl.DNSNames, n)
+		}
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, ca.Cert, &key.PublicKey, ca.Key)
+	if err != nil {
+		return nil, nil, err
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		return nil, nil, err
+	}
+	return cert, key, nil
+}
+
+// LeafFileNames returns mkcert-style output names for a leaf covering names,
+// e.g. "example.com+2.pem" and "example.com+2-key.pem".
+func LeafFileNames(names []string) (certFile, keyFile string) {
+	base := strings.NewReplacer(":", "_", "*", "_wildcard", "/", "_").Replace(names[0])
+	if len(names) > 1 {
+		base += fmt.Sprintf("+%d", len(names)-1)
+	}
+	return base + ".pem", base + "-key.pem"
+}
+
+// WriteCertificate writes DER bytes as a PEM CERTIFICATE block.
+func WriteCertificate(path string, der []byte) error {
+	return os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0o644)
+}
+
+// WritePrivateKey writes key as an unencrypted PKCS#8 PEM file readable
+// only by the owner.
+func WritePrivateKey(path string, key crypto.PrivateKey) error {
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}), 0o600)
+}
+
+func randomSerial() *big.Int {
+	n, _ := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	return n
+}
+
+func keyID(pub crypto.PublicKey) []byte {
+	der, err := x509.MarshalPKIXPublicKey(pub)
+	if err != nil {
+		return nil
+	}
+	sum := sha1.Sum(der)
+	return sum[:]
+}
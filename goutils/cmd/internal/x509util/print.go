@@ -0,0 +1,184 @@
+package x509util
+
+import (
+	"crypto/x509"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+)
+
+const dateFormat = "2006-01-02 15:04:05 MST"
+
+// Print writes r as an aligned "Label : value" listing. Extension details
+// are only included when verbose is set.
+func (r *Report) Print(w io.Writer, indent string, verbose bool) {
+	line := func(label, value string) {
+		if value != "" {
+			fmt.Fprintf(w, "%s%-14s: %s\n", indent, label, value)
+		}
+	}
+	list := func(label string, values []string) {
+		if len(values) > 0 {
+			line(label, strings.Join(values, ", "))
+		}
+	}
+
+	line("Subject", r.SubjectDN)
+	line("Issuer", r.IssuerDN)
+	status := fmt.Sprintf("valid, %d days remaining", r.DaysLeft)
+	switch {
+	case r.Expired:
+		status = "EXPIRED"
+	case r.NotYetValid:
+		status = "NOT YET VALID"
+	}
+	line("Status", status)
+	line("Valid From", r.NotBefore.Format(dateFormat))
+	line("Valid Until", r.NotAfter.Format(dateFormat))
+	line("Serial", r.SerialHex)
+	line("Public Key", r.PublicKey)
+	line("Signature", r.SignatureAlgorithm)
+	list("SANs", r.SANs)
+	if r.IsCA {
+		ca := "CA"
+		if r.MaxPathLen != nil {
+			ca += fmt.Sprintf(", path length %d", *r.MaxPathLen)
+		}
+		if r.SelfSigned {
+			ca += ", self-signed"
+		}
+		line("Basic Constr.", ca)
+	}
+	list("Key Usage", r.KeyUsage)
+	list("Ext Key Usage", r.ExtKeyUsage)
+	if verbose {
+		if nc := r.NameConstraints; nc != nil {
+			crit := ""
+			if nc.Critical {
+				crit = " (critical)"
+			}
+			line("Name Constr.", "present"+crit)
+			list("  permitted", append(append(append(append([]string{}, nc.PermittedDNS...), nc.PermittedIP...), nc.PermittedEmail...), nc.PermittedURI...))
+			list("  excluded", append(append(append(append([]string{}, nc.ExcludedDNS...), nc.ExcludedIP...), nc.ExcludedEmail...), nc.ExcludedURI...))
+		}
+		list("Policies", r.Policies)
+		line("Subject KeyID", r.SubjectKeyID)
+		line("Auth KeyID", r.AuthorityKeyID)
+		list("OCSP", r.OCSPServers)
+		list("CA Issuers", r.IssuingCertURLs)
+		list("CRL", r.CRLDistPoints)
+		for _, s := range r.SCTs {
+			line("SCT", fmt.Sprintf("v%d log %s at %s (%s)", s.Version, s.LogID, s.Timestamp.Format(dateFormat), s.Signature))
+		}
+		for _, e := range r.OtherExtensions {
+			line("Extension", e)
+		}
+		line("SHA-256", r.SHA256)
+		line("SHA-1", r.SHA1)
+	}
+	for _, warn := range r.Warnings {
+		line("WARNING", warn)
+	}
+}
+
+// Print writes the validation outcome.
+func (r *ChainResult) Print(w io.Writer, indent string) {
+	if r.Valid {
+		fmt.Fprintf(w, "%sChain         : OK\n", indent)
+		for i, ch := range r.Chains {
+			fmt.Fprintf(w, "%s  path %d      : %s\n", indent, i+1, strings.Join(ch, " -> "))
+		}
+	} else {
+		fmt.Fprintf(w, "%sChain         : INVALID (%s)\n", indent, r.Error)
+	}
+	if r.Hostname != "" {
+		if r.HostnameOK {
+			fmt.Fprintf(w, "%sHostname      : %s matches\n", indent, r.Hostname)
+		} else {
+			fmt.Fprintf(w, "%sHostname      : MISMATCH (%s)\n", indent, r.HostnameError)
+		}
+	}
+	for _, p := range r.Problems {
+		fmt.Fprintf(w, "%sProblem       : %s\n", indent, p)
+	}
+}
+
+// PrintRequest describes a certificate signing request.
+func PrintRequest(w io.Writer, req *x509.CertificateRequest, indent string) {
+	fmt.Fprintf(w, "%sSubject       : %s\n", indent, req.Subject.String())
+	fmt.Fprintf(w, "%sPublic Key    : %s\n", indent, DescribePublicKey(req.PublicKey))
+	fmt.Fprintf(w, "%sSignature     : %s\n", indent, req.SignatureAlgorithm)
+	sig := "valid"
+	if err := req.CheckSignature(); err != nil {
+		sig = "INVALID (" + err.Error() + ")"
+	}
+	fmt.Fprintf(w, "%sSelf-signature: %s\n", indent, sig)
+	var sans []string
+	sans = append(sans, req.DNSNames...)
+	for _, ip := range req.IPAddresses {
+		sans = append(sans, ip.String())
+	}
+	sans = append(sans, req.EmailAddresses...)
+	for _, u := range req.URIs {
+		sans = append(sans, u.String())
+	}
+	if len(sans) > 0 {
+		fmt.Fprintf(w, "%sSANs          : %s\n", indent, strings.Join(sans, ", "))
+	}
+	for _, ext := range req.Extensions {
+		name := ext.Id.String()
+		if n, ok := knownExtensions[name]; ok {
+			name = n
+		}
+		fmt.Fprintf(w, "%sExtension     : %s\n", indent, name)
+	}
+}
+
+// PrintCRL describes a certificate revocation list.
+func PrintCRL(w io.Writer, crl *x509.RevocationList, indent string, verbose bool) {
+	fmt.Fprintf(w, "%sIssuer        : %s\n", indent, crl.Issuer.String())
+	if crl.Number != nil {
+		fmt.Fprintf(w, "%sCRL Number    : %s\n", indent, crl.Number)
+	}
+	fmt.Fprintf(w, "%sThis Update   : %s\n", indent, crl.ThisUpdate.Format(dateFormat))
+	if !crl.NextUpdate.IsZero() {
+		next := crl.NextUpdate.Format(dateFormat)
+		if time.Now().After(crl.NextUpdate) {
+			next += " (STALE)"
+		}
+		fmt.Fprintf(w, "%sNext Update   : %s\n", indent, next)
+	}
+	fmt.Fprintf(w, "%sSignature     : %s\n", indent, crl.SignatureAlgorithm)
+	fmt.Fprintf(w, "%sRevoked       : %d certificates\n", indent, len(crl.RevokedCertificateEntries))
+	if verbose {
+		for _, e := range crl.RevokedCertificateEntries {
+			fmt.Fprintf(w, "%s  %s  %s  reason %d\n", indent, colonHex(e.SerialNumber.Bytes()),
+				e.RevocationTime.Format(dateFormat), e.ReasonCode)
+		}
+	}
+}
+
+// Print describes an OCSP answer.
+func (s *OCSPStatus) Print(w io.Writer, indent string) {
+	status := strings.ToUpper(s.Status)
+	if s.Status == "revoked" {
+		status += fmt.Sprintf(" at %s (reason %d)", s.RevokedAt.Format(dateFormat), s.Reason)
+	}
+	if !s.SignatureValid {
+		status += ", response signature NOT verified"
+	}
+	fmt.Fprintf(w, "%sOCSP          : %s via %s\n", indent, status, s.Responder)
+}
+
+// Print describes a CRL lookup.
+func (s *CRLStatus) Print(w io.Writer, indent string) {
+	status := "not revoked"
+	if s.Revoked {
+		status = "REVOKED at " + s.RevokedAt.Format(dateFormat)
+	}
+	if !s.SignatureValid {
+		status += ", CRL signature NOT verified"
+	}
+	fmt.Fprintf(w, "%sCRL           : %s (%d entries) via %s\n", indent, status, s.Entries, s.URL)
+}
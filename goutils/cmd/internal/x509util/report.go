@@ -0,0 +1,388 @@
+package x509util
+
+import (
+	"crypto/dsa"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/asn1"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Report is a flattened, printable view of one certificate.
+type Report struct {
+	Subject            string           `json:"subject"`
+	SubjectDN          string           `json:"subject_dn"`
+	Issuer             string           `json:"issuer"`
+	IssuerDN           string           `json:"issuer_dn"`
+	Serial             string           `json:"serial"`
+	SerialHex          string           `json:"serial_hex"`
+	Version            int              `json:"version"`
+	NotBefore          time.Time        `json:"not_before"`
+	NotAfter           time.Time        `json:"not_after"`
+	DaysLeft           int              `json:"days_left"`
+	Expired            bool             `json:"expired"`
+	NotYetValid        bool             `json:"not_yet_valid,omitempty"`
+	SignatureAlgorithm string           `json:"signature_algorithm"`
+	PublicKey          string           `json:"public_key"`
+	IsCA               bool             `json:"is_ca"`
+	MaxPathLen         *int             `json:"max_path_len,omitempty"`
+	SelfSigned         bool             `json:"self_signed,omitempty"`
+	SANs               []string         `json:"sans"`
+	KeyUsage           []string         `json:"key_usage,omitempty"`
+	ExtKeyUsage        []string         `json:"ext_key_usage,omitempty"`
+	NameConstraints    *NameConstraints `json:"name_constraints,omitempty"`
+	Policies           []string         `json:"policies,omitempty"`
+	SubjectKeyID       string           `json:"subject_key_id,omitempty"`
+	AuthorityKeyID     string           `json:"authority_key_id,omitempty"`
+	OCSPServers        []string         `json:"ocsp_servers,omitempty"`
+	IssuingCertURLs    []string         `json:"issuing_cert_urls,omitempty"`
+	CRLDistPoints      []string         `json:"crl_distribution_points,omitempty"`
+	SCTs               []SCT            `json:"scts,omitempty"`
+	OtherExtensions    []string         `json:"other_extensions,omitempty"`
+	SHA256             string           `json:"sha256_fingerprint"`
+	SHA1               string           `json:"sha1_fingerprint"`
+	Warnings           []string         `json:"warnings,omitempty"`
+}
+
+// NameConstraints mirrors the permitted and excluded subtrees of a CA.
+type NameConstraints struct {
+	Critical       bool     `json:"critical"`
+	PermittedDNS   []string `json:"permitted_dns,omitempty"`
+	ExcludedDNS    []string `json:"excluded_dns,omitempty"`
+	PermittedIP    []string `json:"permitted_ip,omitempty"`
+	ExcludedIP     []string `json:"excluded_ip,omitempty"`
+	PermittedEmail []string `json:"permitted_email,omitempty"`
+	ExcludedEmail  []string `json:"excluded_email,omitempty"`
+	PermittedURI   []string `json:"permitted_uri,omitempty"`
+	ExcludedURI    []string `json:"excluded_uri,omitempty"`
+}
+
+// SCT is one embedded Signed Certificate Timestamp (RFC 6962).
+type SCT struct {
+	Version   int       `json:"version"`
+	LogID     string    `json:"log_id"`
+	Timestamp time.Time `json:"timestamp"`
+	Signature string    `json:"signature_algorithm"`
+}
+
+var (
+	oidExtSCT = asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 11129, 2, 4, 2}
+
+	// Extensions that crypto/x509 already decodes into Certificate fields.
+	knownExtensions = map[string]string{
+		"2.5.29.14":         "subjectKeyIdentifier",
+		"2.5.29.15":         "keyUsage",
+		"2.5.29.17":         "subjectAltName",
+		"2.5.29.19":         "basicConstraints",
+		"2.5.29.30":         "nameConstraints",
+		"2.5.29.31":         "cRLDistributionPoints",
+		"2.5.29.32":         "certificatePolicies",
+		"2.5.29.35":         "authorityKeyIdentifier",
+		"2.5.29.37":         "extKeyUsage",
+		"1.3.6.1.5.5.7.1.1": "authorityInfoAccess",
+		oidExtSCT.String():  "signedCertificateTimestamps",
+	}
+
+	extraExtensionNames = map[string]string{
+		"2.5.29.18":               "issuerAltName",
+		"2.5.29.36":               "policyConstraints",
+		"2.5.29.46":               "freshestCRL",
+		"2.5.29.54":               "inhibitAnyPolicy",
+		"1.3.6.1.5.5.7.1.3":       "qcStatements",
+		"1.3.6.1.5.5.7.1.11":      "subjectInfoAccess",
+		"1.3.6.1.5.5.7.1.24":      "tlsFeature (OCSP must-staple)",
+		"1.3.6.1.5.5.7.48.1.5":    "OCSP no check",
+		"1.3.6.1.4.1.11129.2.4.3": "precertificate poison",
+		"1.3.6.1.4.1.311.20.2":    "Microsoft certificate template name",
+		"1.3.6.1.4.1.311.21.7":    "Microsoft certificate template",
+		"2.16.840.1.113730.1.1":   "Netscape cert type",
+	}
+)
+
+var keyUsageNames = []struct {
+	bit  x509.KeyUsage
+	name string
+}{
+	{x509.KeyUsageDigitalSignature, "Digital Signature"},
+	{x509.KeyUsageContentCommitment, "Content Commitment"},
+	{x509.KeyUsageKeyEncipherment, "Key Encipherment"},
+	{x509.KeyUsageDataEncipherment, "Data Encipherment"},
+	{x509.KeyUsageKeyAgreement, "Key Agreement"},
+	{x509.KeyUsageCertSign, "Certificate Sign"},
+	{x509.KeyUsageCRLSign, "CRL Sign"},
+	{x509.KeyUsageEncipherOnly, "Encipher Only"},
+	{x509.KeyUsageDecipherOnly, "Decipher Only"},
+}
+
+var extKeyUsageNames = map[x509.ExtKeyUsage]string{
+	x509.ExtKeyUsageAny:                            "Any",
+	x509.ExtKeyUsageServerAuth:                     "TLS Web Server Authentication",
+	x509.ExtKeyUsageClientAuth:                     "TLS Web Client Authentication",
+	x509.ExtKeyUsageCodeSigning:                    "Code Signing",
+	x509.ExtKeyUsageEmailProtection:                "E-mail Protection",
+	x509.ExtKeyUsageIPSECEndSystem:                 "IPSec End System",
+	x509.ExtKeyUsageIPSECTunnel:                    "IPSec Tunnel",
+	x509.ExtKeyUsageIPSECUser:                      "IPSec User",
+	x509.ExtKeyUsageTimeStamping:                   "Time Stamping",
+	x509.ExtKeyUsageOCSPSigning:                    "OCSP Signing",
+	x509.ExtKeyUsageMicrosoftServerGatedCrypto:     "Microsoft Server Gated Crypto",
+	x509.ExtKeyUsageNetscapeServerGatedCrypto:      "Netscape Server Gated Crypto",
+	x509.ExtKeyUsageMicrosoftCommercialCodeSigning: "Microsoft Commercial Code Signing",
+	x509.ExtKeyUsageMicrosoftKernelCodeSigning:     "Microsoft Kernel Code Signing",
+}
+
+// Inspect builds a Report for cert, including weakness warnings.
+func Inspect(cert *x509.Certificate) *Report {
+	now := time.Now()
+	r := &Report{
+		Subject:            cert.Subject.CommonName,
+		SubjectDN:          cert.Subject.String(),
+		Issuer:             cert.Issuer.CommonName,
+		IssuerDN:           cert.Issuer.String(),
+		Serial:             cert.SerialNumber.String(),
+		SerialHex:          colonHex(cert.SerialNumber.Bytes()),
+		Version:            cert.Version,
+		NotBefore:          cert.NotBefore,
+		NotAfter:           cert.NotAfter,
+		DaysLeft:           int(cert.NotAfter.Sub(now).Hours() / 24),
+		Expired:            now.After(cert.NotAfter),
+		NotYetValid:        now.Before(cert.NotBefore),
+		SignatureAlgorithm: cert.SignatureAlgorithm.String(),
+		PublicKey:          DescribePublicKey(cert.PublicKey),
+		IsCA:               cert.IsCA,
+		SelfSigned:         isSelfSigned(cert),
+		SANs:               SANs(cert),
+		OCSPServers:        cert.OCSPServer,
+		IssuingCertURLs:    cert.IssuingCertificateURL,
+		CRLDistPoints:      cert.CRLDistributionPoints,
+		SubjectKeyID:       colonHex(cert.SubjectKeyId),
+		AuthorityKeyID:     colonHex(cert.AuthorityKeyId),
+	}
+	if cert.BasicConstraintsValid && cert.IsCA && (cert.MaxPathLen > 0 || cert.MaxPathLenZero) {
+		n := cert.MaxPathLen
+		r.MaxPathLen = &n
+	}
+	for _, ku := range keyUsageNames {
+		if cert.KeyUsage&ku.bit != 0 {
+			r.KeyUsage = append(r.KeyUsage, ku.name)
+		}
+	}
+	for _, eku := range cert.ExtKeyUsage {
+		name, ok := extKeyUsageNames[eku]
+		if !ok {
+			name = fmt.Sprintf("EKU(%d)", eku)
+		}
+		r.ExtKeyUsage = append(r.ExtKeyUsage, name)
+	}
+	for _, oid := range cert.UnknownExtKeyUsage {
+		r.ExtKeyUsage = append(r.ExtKeyUsage, oid.String())
+	}
+	for _, p := range cert.PolicyIdentifiers {
+		r.Policies = append(r.Policies, p.String())
+	}
+	r.NameConstraints = nameConstraints(cert)
+	r.SCTs = parseSCTs(cert)
+	for _, ext := range cert.Extensions {
+		id := ext.Id.String()
+		if _, ok := knownExtensions[id]; ok {
+			continue
+		}
+		desc := id
+		if name, ok := extraExtensionNames[id]; ok {
+			desc = name + " (" + id + ")"
+		}
+		if ext.Critical {
+			desc += " [critical]"
+		}
+		r.OtherExtensions = append(r.OtherExtensions, desc)
+	}
+	sum256 := sha256.Sum256(cert.Raw)
+	sum1 := sha1.Sum(cert.Raw)
+	r.SHA256 = colonHex(sum256[:])
+	r.SHA1 = colonHex(sum1[:])
+	r.Warnings = Weaknesses(cert)
+	return r
+}
+
+// SANs lists every subject alternative name in a single slice.
+func SANs(cert *x509.Certificate) []string {
+	var sans []string
+	sans = append(sans, cert.DNSNames...)
+	for _, ip := range cert.IPAddresses {
+		sans = append(sans, ip.String())
+	}
+	sans = append(sans, cert.EmailAddresses...)
+	for _, u := range cert.URIs {
+		sans = append(sans, u.String())
+	}
+	return sans
+}
+
+// DescribePublicKey returns e.g. "RSA 2048 bits" or "ECDSA P-256".
+func DescribePublicKey(pub interface{}) string {
+	switch k := pub.(type) {
+	case *rsa.PublicKey:
+		return fmt.Sprintf("RSA %d bits", k.N.BitLen())
+	case *ecdsa.PublicKey:
+		return "ECDSA " + k.Curve.Params().Name
+	case ed25519.PublicKey:
+		return "Ed25519"
+	case *dsa.PublicKey:
+		return fmt.Sprintf("DSA %d bits", k.P.BitLen())
+	}
+	return fmt.Sprintf("%T", pub)
+}
+
+// Weaknesses flags keys, signatures and validity periods that modern
+// clients reject or that should be replaced.
+func Weaknesses(cert *x509.Certificate) []string {
+	var w []string
+	now := time.Now()
+	switch k := cert.PublicKey.(type) {
+	case *rsa.PublicKey:
+		if n := k.N.BitLen(); n < 2048 {
+			w = append(w, fmt.Sprintf("weak RSA key: %d bits (minimum 2048)", n))
+		}
+		if k.E < 65537 {
+			w = append(w, fmt.Sprintf("small RSA public exponent %d", k.E))
+		}
+	case *ecdsa.PublicKey:
+		if n := k.Curve.Params().BitSize; n < 256 {
+			w = append(w, fmt.Sprintf("weak ECDSA key: %d-bit curve", n))
+		}
+	case *dsa.PublicKey:
+		w = append(w, "DSA keys are deprecated and unsupported by TLS 1.3")
+	}
+
+	// A self-signed root's signature is never checked, so SHA-1 there is
+	// harmless; everywhere else it is a forgery risk.
+	if !isSelfSigned(cert) {
+		switch cert.SignatureAlgorithm {
+		case x509.MD2WithRSA, x509.MD5WithRSA:
+			w = append(w, "broken signature algorithm "+cert.SignatureAlgorithm.String())
+		case x509.SHA1WithRSA, x509.DSAWithSHA1, x509.ECDSAWithSHA1:
+			w = append(w, "weak signature algorithm "+cert.SignatureAlgorithm.String())
+		case x509.UnknownSignatureAlgorithm:
+			w = append(w, "unknown signature algorithm")
+		}
+	}
+
+	if now.After(cert.NotAfter) {
+		w = append(w, "certificate expired on "+cert.NotAfter.Format("2006-01-02"))
+	} else if now.Before(cert.NotBefore) {
+		w = append(w, "certificate not valid until "+cert.NotBefore.Format("2006-01-02"))
+	}
+	if !cert.IsCA {
+		if days := int(cert.NotAfter.Sub(cert.NotBefore).Hours() / 24); days > MaxLeafDays {
+			w = append(w, fmt.Sprintf("validity period of %d days exceeds the %d-day browser limit", days, MaxLeafDays))
+		}
+		if len(cert.DNSNames) == 0 && len(cert.IPAddresses) == 0 && cert.Subject.CommonName != "" {
+			w = append(w, "no subjectAltName: clients ignore the Common Name for hostname checks")
+		}
+	}
+	if cert.Version < 3 {
+		w = append(w, fmt.Sprintf("X.509 version %d certificate", cert.Version))
+	}
+	return w
+}
+
+func isSelfSigned(cert *x509.Certificate) bool {
+	if string(cert.RawSubject) != string(cert.RawIssuer) {
+		return false
+	}
+	return cert.CheckSignatureFrom(cert) == nil
+}
+
+func nameConstraints(cert *x509.Certificate) *NameConstraints {
+	nc := &NameConstraints{
+		Critical:       cert.PermittedDNSDomainsCritical,
+		PermittedDNS:   cert.PermittedDNSDomains,
+		ExcludedDNS:    cert.ExcludedDNSDomains,
+		PermittedEmail: cert.PermittedEmailAddresses,
+		ExcludedEmail:  cert.ExcludedEmailAddresses,
+		PermittedURI:   cert.PermittedURIDomains,
+		ExcludedURI:    cert.ExcludedURIDomains,
+	}
+	for _, n := range cert.PermittedIPRanges {
+		nc.PermittedIP = append(nc.PermittedIP, n.String())
+	}
+	for _, n := range cert.ExcludedIPRanges {
+		nc.ExcludedIP = append(nc.ExcludedIP, n.String())
+	}
+	if len(nc.PermittedDNS)+len(nc.ExcludedDNS)+len(nc.PermittedIP)+len(nc.ExcludedIP)+
+		len(nc.PermittedEmail)+len(nc.ExcludedEmail)+len(nc.PermittedURI)+len(nc.ExcludedURI) == 0 {
+		return nil
+	}
+	return nc
+}
+
+// parseSCTs decodes the TLS-encoded SignedCertificateTimestampList carried
+// in the certificate's SCT extension.
+func parseSCTs(cert *x509.Certificate) []SCT {
+	var raw []byte
+	for _, ext := range cert.Extensions {
+		if ext.Id.Equal(oidExtSCT) {
+			if _, err := asn1.Unmarshal(ext.Value, &raw); err != nil {
+				return nil
+			}
+		}
+	}
+	if len(raw) < 2 {
+		return nil
+	}
+	list := raw[2:]
+	if int(binary.BigEndian.Uint16(raw)) != len(list) {
+		return nil
+	}
+	var scts []SCT
+	for len(list) >= 2 {
+		n := int(binary.BigEndian.Uint16(list))
+		list = list[2:]
+		if n > len(list) || n < 1+32+8+2 {
+			break
+		}
+		s := list[:n]
+		list = list[n:]
+		sct := SCT{
+			Version:   int(s[0]) + 1,
+			LogID:     base64.StdEncoding.EncodeToString(s[1:33]),
+			Timestamp: time.UnixMilli(int64(binary.BigEndian.Uint64(s[33:41]))).UTC(),
+		}
+		extLen := int(binary.BigEndian.Uint16(s[41:43]))
+		if rest := s[43:]; len(rest) >= extLen+2 {
+			sct.Signature = sctSignatureName(rest[extLen], rest[extLen+1])
+		}
+		scts = append(scts, sct)
+	}
+	return scts
+}
+
+func sctSignatureName(hashAlg, sigAlg byte) string {
+	hashes := map[byte]string{1: "MD5", 2: "SHA1", 3: "SHA224", 4: "SHA256", 5: "SHA384", 6: "SHA512"}
+	sigs := map[byte]string{1: "RSA", 2: "DSA", 3: "ECDSA"}
+	return hashes[hashAlg] + "-" + sigs[sigAlg]
+}
+
+func colonHex(b []byte) string {
+	if len(b) == 0 {
+		return ""
+	}
+	h := strings.ToUpper(hex.EncodeToString(b))
+	var sb strings.Builder
+	for i := 0; i < len(h); i += 2 {
+		if i > 0 {
+			sb.WriteByte(':')
+		}
+		sb.WriteString(h[i : i+2])
+	}
+	return sb.String()
+}
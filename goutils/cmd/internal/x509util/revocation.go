@@ -0,0 +1,303 @@
+package x509util
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"time"
+)
+
+// OCSPStatus is the parsed answer from an OCSP responder (RFC 6960).
+type OCSPStatus struct {
+	Responder      string    `json:"responder"`
+	Status         string    `json:"status"` // good, revoked or unknown
+	RevokedAt      time.Time `json:"revoked_at,omitempty"`
+	Reason         int       `json:"reason,omitempty"`
+	ProducedAt     time.Time `json:"produced_at"`
+	ThisUpdate     time.Time `json:"this_update"`
+	NextUpdate     time.Time `json:"next_update,omitempty"`
+	SignatureValid bool      `json:"signature_valid"`
+}
+
+// CRLStatus is the result of looking a certificate up in its issuer's CRL.
+type CRLStatus struct {
+	URL            string    `json:"url"`
+	Revoked        bool      `json:"revoked"`
+	RevokedAt      time.Time `json:"revoked_at,omitempty"`
+	ThisUpdate     time.Time `json:"this_update"`
+	NextUpdate     time.Time `json:"next_update,omitempty"`
+	Entries        int       `json:"entries"`
+	SignatureValid bool      `json:"signature_valid"`
+}
+
+type ocspCertID struct {
+	HashAlgorithm pkix.AlgorithmIdentifier
+	NameHash      []byte
+	IssuerKeyHash []byte
+	SerialNumber  *big.Int
+}
+
+type ocspRequest struct {
+	TBSRequest struct {
+		RequestList []struct {
+			Cert ocspCertID
+		}
+	}
+}
+
+type ocspResponse struct {
+	Status        asn1.Enumerated
+	ResponseBytes struct {
+		ResponseType asn1.ObjectIdentifier
+		Response     []byte
+	} `asn1:"explicit,tag:0,optional"`
+}
+
+type basicOCSPResponse struct {
+	TBSResponseData    responseData
+	SignatureAlgorithm pkix.AlgorithmIdentifier
+	Signature          asn1.BitString
+	Certificates       []asn1.RawValue `asn1:"explicit,tag:0,optional"`
+}
+
+type responseData struct {
+	Raw            asn1.RawContent
+	Version        int `asn1:"optional,default:0,explicit,tag:0"`
+	RawResponderID asn1.RawValue
+	ProducedAt     time.Time `asn1:"generalized"`
+	Responses      []singleResponse
+}
+
+type singleResponse struct {
+	CertID     ocspCertID
+	Good       asn1.Flag        `asn1:"tag:0,optional"`
+	Revoked    revokedInfo      `asn1:"tag:1,optional"`
+	Unknown    asn1.Flag        `asn1:"tag:2,optional"`
+	ThisUpdate time.Time        `asn1:"generalized"`
+	NextUpdate time.Time        `asn1:"generalized,explicit,tag:0,optional"`
+	Extensions []pkix.Extension `asn1:"explicit,tag:1,optional"`
+}
+
+type revokedInfo struct {
+	RevocationTime time.Time       `asn1:"generalized"`
+	Reason         asn1.Enumerated `asn1:"explicit,tag:0,optional"`
+}
+
+var (
+	oidOCSPBasic = asn1.ObjectIdentifier{1, 3, 6, 1, 5, 5, 7, 48, 1, 1}
+
+	signatureAlgorithms = map[string]x509.SignatureAlgorithm{
+		"1.2.840.113549.1.1.5":  x509.SHA1WithRSA,
+		"1.2.840.113549.1.1.11": x509.SHA256WithRSA,
+		"1.2.840.113549.1.1.12": x509.SHA384WithRSA,
+		"1.2.840.113549.1.1.13": x509.SHA512WithRSA,
+		"1.2.840.10045.4.1":     x509.ECDSAWithSHA1,
+		"1.2.840.10045.4.3.2":   x509.ECDSAWithSHA256,
+		"1.2.840.10045.4.3.3":   x509.ECDSAWithSHA384,
+		"1.2.840.10045.4.3.4":   x509.ECDSAWithSHA512,
+		"1.3.101.112":           x509.PureEd25519,
+	}
+
+	ocspStatusNames = []string{"successful", "malformedRequest", "internalError", "tryLater", "", "sigRequired", "unauthorized"}
+)
+
+// CheckOCSP asks the leaf's OCSP responder for its revocation status.
+func CheckOCSP(client *http.Client, leaf, issuer *x509.Certificate) (*OCSPStatus, error) {
+	if len(leaf.OCSPServer) == 0 {
+		return nil, errors.New("certificate has no OCSP responder")
+	}
+	if issuer == nil {
+		return nil, errors.New("issuer certificate is needed for OCSP")
+	}
+	id, err := newCertID(leaf, issuer)
+	if err != nil {
+		return nil, err
+	}
+	var req ocspRequest
+	req.TBSRequest.RequestList = append(req.TBSRequest.RequestList, struct{ Cert ocspCertID }{id})
+	der, err := asn1.Marshal(req)
+	if err != nil {
+		return nil, err
+	}
+
+	url := leaf.OCSPServer[0]
+	httpReq, err := http.NewRequest(http.MethodPost, url, bytes.NewReader(der))
+	if err != nil {
+		return nil, err
+	}
+	httpReq.Header.Set("Content-Type", "application/ocsp-request")
+	httpReq.Header.Set("Accept", "application/ocsp-response")
+	resp, err := client.Do(httpReq)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("OCSP responder %s: %s", url, resp.Status)
+	}
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return nil, err
+	}
+	st, err := parseOCSPResponse(body, leaf, issuer)
+	if err != nil {
+		return nil, err
+	}
+	st.Responder = url
+	return st, nil
+}
+
+func newCertID(leaf, issuer *x509.Certificate) (ocspCertID, error) {
+	var spki struct {
+		Algorithm pkix.AlgorithmIdentifier
+		PublicKey asn1.BitString
+	}
+	if _, err := asn1.Unmarshal(issuer.RawSubjectPublicKeyInfo, &spki); err != nil {
+		return ocspCertID{}, err
+	}
+	nameHash := sha1.Sum(issuer.RawSubject)
+	keyHash := sha1.Sum(spki.PublicKey.RightAlign())
+	return ocspCertID{
+		HashAlgorithm: pkix.AlgorithmIdentifier{Algorithm: oidSHA1, Parameters: asn1.NullRawValue},
+		NameHash:      nameHash[:],
+		IssuerKeyHash: keyHash[:],
+		SerialNumber:  leaf.SerialNumber,
+	}, nil
+}
+
+func parseOCSPResponse(der []byte, leaf, issuer *x509.Certificate) (*OCSPStatus, error) {
+	var resp ocspResponse
+	if _, err := asn1.Unmarshal(der, &resp); err != nil {
+		return nil, fmt.Errorf("OCSP: %w", err)
+	}
+	if resp.Status != 0 {
+		name := "unknown"
+		if int(resp.Status) < len(ocspStatusNames) {
+			name = ocspStatusNames[resp.Status]
+		}
+		return nil, fmt.Errorf("OCSP responder returned %s", name)
+	}
+	if !resp.ResponseBytes.ResponseType.Equal(oidOCSPBasic) {
+		return nil, errors.New("OCSP: unsupported response type")
+	}
+	var basic basicOCSPResponse
+	if _, err := asn1.Unmarshal(resp.ResponseBytes.Response, &basic); err != nil {
+		return nil, fmt.Errorf("OCSP: %w", err)
+	}
+
+	st := &OCSPStatus{ProducedAt: basic.TBSResponseData.ProducedAt}
+	var single *singleResponse
+	for i, r := range basic.TBSResponseData.Responses {
+		if r.CertID.SerialNumber.Cmp(leaf.SerialNumber) == 0 {
+			single = &basic.TBSResponseData.Responses[i]
+			break
+		}
+	}
+	if single == nil {
+		return nil, errors.New("OCSP: response does not cover this certificate")
+	}
+	switch {
+	case bool(single.Good):
+		st.Status = "good"
+	case bool(single.Unknown):
+		st.Status = "unknown"
+	default:
+		st.Status = "revoked"
+		st.RevokedAt = single.Revoked.RevocationTime
+		st.Reason = int(single.Revoked.Reason)
+	}
+	st.ThisUpdate = single.ThisUpdate
+	st.NextUpdate = single.NextUpdate
+
+	// The response is signed either by the issuer itself or by a delegated
+	// responder certificate that the issuer signed for OCSP use.
+	signer := issuer
+	if len(basic.Certificates) > 0 {
+		if c, err := x509.ParseCertificate(basic.Certificates[0].FullBytes); err == nil && !c.Equal(issuer) {
+			if c.CheckSignatureFrom(issuer) == nil && hasEKU(c, x509.ExtKeyUsageOCSPSigning) {
+				signer = c
+			} else {
+				signer = nil
+			}
+		}
+	}
+	if alg, ok := signatureAlgorithms[basic.SignatureAlgorithm.Algorithm.String()]; ok && signer != nil {
+		st.SignatureValid = signer.CheckSignature(alg, basic.TBSResponseData.Raw, basic.Signature.RightAlign()) == nil
+	}
+	return st, nil
+}
+
+func hasEKU(c *x509.Certificate, want x509.ExtKeyUsage) bool {
+	for _, e := range c.ExtKeyUsage {
+		if e == want {
+			return true
+		}
+	}
+	return false
+}
+
+// CheckCRL downloads the first reachable HTTP CRL distribution point of
+// leaf, verifies it against issuer and looks for leaf's serial number.
+func CheckCRL(client *http.Client, leaf, issuer *x509.Certificate) (*CRLStatus, error) {
+	if len(leaf.CRLDistributionPoints) == 0 {
+		return nil, errors.New("certificate has no CRL distribution points")
+	}
+	var lastErr error
+	for _, url := range leaf.CRLDistributionPoints {
+		resp, err := client.Get(url)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		body, err := io.ReadAll(io.LimitReader(resp.Body, 64<<20))
+		resp.Body.Close()
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		if resp.StatusCode != http.StatusOK {
+			lastErr = fmt.Errorf("%s: %s", url, resp.Status)
+			continue
+		}
+		if block, _ := pem.Decode(body); block != nil {
+			body = block.Bytes
+		}
+		crl, err := x509.ParseRevocationList(body)
+		if err != nil {
+			lastErr = fmt.Errorf("%s: %w", url, err)
+			continue
+		}
+		st := LookupCRL(crl, leaf, issuer)
+		st.URL = url
+		return st, nil
+	}
+	return nil, lastErr
+}
+
+// LookupCRL reports whether cert appears in crl.
+func LookupCRL(crl *x509.RevocationList, cert, issuer *x509.Certificate) *CRLStatus {
+	st := &CRLStatus{
+		ThisUpdate: crl.ThisUpdate,
+		NextUpdate: crl.NextUpdate,
+		Entries:    len(crl.RevokedCertificateEntries),
+	}
+	if issuer != nil {
+		st.SignatureValid = crl.CheckSignatureFrom(issuer) == nil
+	}
+	for _, e := range crl.RevokedCertificateEntries {
+		if e.SerialNumber.Cmp(cert.SerialNumber) == 0 {
+			st.Revoked = true
+			st.RevokedAt = e.RevocationTime
+			break
+		}
+	}
+	return st
+}
@@ -0,0 +1,154 @@
+package x509util
+
+import (
+	"bufio"
+	"crypto/tls"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"time"
+)
+
+// StartTLSProtocols lists the protocols Dial can upgrade with STARTTLS.
+var StartTLSProtocols = []string{"smtp", "imap", "pop3", "ftp", "postgres"}
+
+// Dial connects to addr, performs the plaintext STARTTLS exchange for proto
+// (empty for implicit TLS) and completes a TLS handshake with cfg.
+func Dial(addr, proto string, cfg *tls.Config, timeout time.Duration) (*tls.Conn, error) {
+	conn, err := net.DialTimeout("tcp", addr, timeout)
+	if err != nil {
+		return nil, err
+	}
+	if timeout > 0 {
+		conn.SetDeadline(time.Now().Add(timeout))
+	}
+	if err := startTLS(conn, proto); err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("starttls %s: %w", proto, err)
+	}
+	tc := tls.Client(conn, cfg)
+	if err := tc.Handshake(); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	conn.SetDeadline(time.Time{})
+	return tc, nil
+}
+
+// DefaultPort returns the well-known port for a STARTTLS protocol.
+func DefaultPort(proto string) string {
+	switch proto {
+	case "smtp":
+		return "25"
+	case "imap":
+		return "143"
+	case "pop3":
+		return "110"
+	case "ftp":
+		return "21"
+	case "postgres":
+		return "5432"
+	}
+	return "443"
+}
+
+func startTLS(conn net.Conn, proto string) error {
+	r := bufio.NewReader(conn)
+	switch proto {
+	case "":
+		return nil
+	case "smtp":
+		if _, err := readReply(r, "220"); err != nil {
+			return err
+		}
+		fmt.Fprintf(conn, "EHLO tlsinfo\r\n")
+		if _, err := readReply(r, "250"); err != nil {
+			return err
+		}
+		fmt.Fprintf(conn, "STARTTLS\r\n")
+		_, err := readReply(r, "220")
+		return err
+	case "ftp":
+		if _, err := readReply(r, "220"); err != nil {
+			return err
+		}
+		fmt.Fprintf(conn, "AUTH TLS\r\n")
+		_, err := readReply(r, "234")
+		return err
+	case "imap":
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return err
+		}
+		if !strings.HasPrefix(line, "* OK") {
+			return fmt.Errorf("unexpected greeting %q", strings.TrimSpace(line))
+		}
+		fmt.Fprintf(conn, "a001 STARTTLS\r\n")
+		for {
+			line, err := r.ReadString('\n')
+			if err != nil {
+				return err
+			}
+			if strings.HasPrefix(line, "a001 ") {
+				if !strings.HasPrefix(line, "a001 OK") {
+					return fmt.Errorf("server refused: %s", strings.TrimSpace(line))
+				}
+				return nil
+			}
+		}
+	case "pop3":
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return err
+		}
+		if !strings.HasPrefix(line, "+OK") {
+			return fmt.Errorf("unexpected greeting %q", strings.TrimSpace(line))
+		}
+		fmt.Fprintf(conn, "STLS\r\n")
+		if line, err = r.ReadString('\n'); err != nil {
+			return err
+		}
+		if !strings.HasPrefix(line, "+OK") {
+			return fmt.Errorf("server refused: %s", strings.TrimSpace(line))
+		}
+		return nil
+	case "postgres":
+		// SSLRequest: length 8, request code 80877103.
+		if _, err := conn.Write([]byte{0, 0, 0, 8, 0x04, 0xd2, 0x16, 0x2f}); err != nil {
+			return err
+		}
+		b := make([]byte, 1)
+		if _, err := io.ReadFull(conn, b); err != nil {
+			return err
+		}
+		if b[0] != 'S' {
+			return fmt.Errorf("server does not support SSL")
+		}
+		return nil
+	}
+	return fmt.Errorf("unsupported protocol (want one of %s)", strings.Join(StartTLSProtocols, ", "))
+}
+
+// readReply reads an SMTP/FTP style reply, which may span several
+// "code-text" lines ending in "code text", and checks its status code.
+func readReply(r *bufio.Reader, want string) (string, error) {
+	var all strings.Builder
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return "", err
+		}
+		all.WriteString(line)
+		if len(line) < 4 {
+			return "", fmt.Errorf("malformed reply %q", strings.TrimSpace(line))
+		}
+		if line[3] == '-' {
+			continue
+		}
+		if line[:3] != want {
+			return "", fmt.Errorf("server replied %s", strings.TrimSpace(line))
+		}
+		return all.String(), nil
+	}
+}
@@ -0,0 +1,136 @@
+package x509util
+
+import (
+	"crypto/x509"
+	"fmt"
+	"time"
+)
+
+// VerifyOptions controls VerifyChain.
+type VerifyOptions struct {
+	Roots       *x509.CertPool // nil means the system pool
+	Hostname    string         // checked against the leaf's SANs when set
+	CurrentTime time.Time      // zero means now
+}
+
+// ChainResult is the outcome of validating a presented chain.
+type ChainResult struct {
+	Valid         bool       `json:"valid"`
+	Error         string     `json:"error,omitempty"`
+	Hostname      string     `json:"hostname,omitempty"`
+	HostnameOK    bool       `json:"hostname_ok"`
+	HostnameError string     `json:"hostname_error,omitempty"`
+	Chains        [][]string `json:"chains,omitempty"`
+	Problems      []string   `json:"problems,omitempty"`
+
+	verified [][]*x509.Certificate
+}
+
+// VerifiedChains returns the chains built by crypto/x509, leaf first.
+func (r *ChainResult) VerifiedChains() [][]*x509.Certificate { return r.verified }
+
+// VerifyChain validates certs[0] using the remaining certificates as
+// intermediates. Beyond path building it reports hostname mismatches,
+// misordered or superfluous certificates, and weaknesses anywhere in the
+// verified path.
+func VerifyChain(certs []*x509.Certificate, opts VerifyOptions) *ChainResult {
+	res := &ChainResult{Hostname: opts.Hostname}
+	if len(certs) == 0 {
+		res.Error = "no certificates"
+		return res
+	}
+	leaf := certs[0]
+	inter := x509.NewCertPool()
+	for _, c := range certs[1:] {
+		inter.AddCert(c)
+	}
+	chains, err := leaf.Verify(x509.VerifyOptions{
+		Roots:         opts.Roots,
+		Intermediates: inter,
+		CurrentTime:   opts.CurrentTime,
+		KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageAny},
+	})
+	if err != nil {
+		res.Error = err.Error()
+	} else {
+		res.Valid = true
+		res.verified = chains
+		for _, ch := range chains {
+			var names []string
+			for _, c := range ch {
+				names = append(names, displayName(c))
+			}
+			res.Chains = append(res.Chains, names)
+		}
+	}
+
+	if opts.Hostname != "" {
+		if err := leaf.VerifyHostname(opts.Hostname); err != nil {
+			res.HostnameError = err.Error()
+		} else {
+			res.HostnameOK = true
+		}
+	} else {
+		res.HostnameOK = true
+	}
+
+	for i := 0; i+1 < len(certs); i++ {
+		if certs[i].CheckSignatureFrom(certs[i+1]) != nil {
+			res.Problems = append(res.Problems, fmt.Sprintf(
+				"chain order: certificate #%d (%s) is not issued by #%d (%s)",
+				i+1, displayName(certs[i]), i+2, displayName(certs[i+1])))
+		}
+	}
+	if n := len(certs); n > 1 && isSelfSigned(certs[n-1]) {
+		res.Problems = append(res.Problems, "the root certificate is included in the presented chain (unnecessary)")
+	}
+	if len(chains) > 0 {
+		seen := map[*x509.Certificate]bool{}
+		for i, c := range chains[0] {
+			seen[c] = true
+			for _, w := range Weaknesses(c) {
+				res.Problems = append(res.Problems, fmt.Sprintf("#%d %s: %s", i+1, displayName(c), w))
+			}
+		}
+		for i, c := range certs[1:] {
+			used := false
+			for s := range seen {
+				if s.Equal(c) {
+					used = true
+				}
+			}
+			if !used {
+				res.Problems = append(res.Problems, fmt.Sprintf(
+					"certificate #%d (%s) is not part of the verified path", i+2, displayName(c)))
+			}
+		}
+	}
+	return res
+}
+
+// Issuer returns the certificate that signed cert, looking first in the
+// presented chain and then in the verified chains.
+func Issuer(cert *x509.Certificate, pool []*x509.Certificate, res *ChainResult) *x509.Certificate {
+	for _, c := range pool {
+		if cert.CheckSignatureFrom(c) == nil {
+			return c
+		}
+	}
+	if res != nil {
+		for _, ch := range res.verified {
+			for i, c := range ch {
+				if c.Equal(cert) && i+1 < len(ch) {
+					return ch[i+1]
+				}
+			}
+		}
+	}
+	return nil
+}
+
+func displayName(c *x509.Certificate) string {
+	if c.Subject.CommonName != "" {
+		return c.Subject.CommonName
+	}
+	return c.Subject.String()
+}
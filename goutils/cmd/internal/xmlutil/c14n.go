@@ -0,0 +1,257 @@
+package xmlutil
+
+import (
+	"bufio"
+	"encoding/xml"
+	"io"
+	"sort"
+	"strings"
+)
+
+// Canonicalizer writes tokens as Canonical XML 1.0
+// (https://www.w3.org/TR/xml-c14n) or, if exclusive, Exclusive XML
+// Canonicalization (https://www.w3.org/TR/xml-exc-c14n), the forms XML
+// signatures are computed over. Comments are dropped unless comments is
+// set. Tokens must come from a Reader, or Node.Tokens, so that names
+// carry their prefixes.
+//
+// Documents with a DTD are canonicalized without it: default attributes
+// and entity declarations are not applied.
+type Canonicalizer struct {
+	w         *bufio.Writer
+	exclusive bool
+	comments  bool
+	ctx       c14nScope
+	stack     []c14nScope
+	after     bool // the apex element has ended
+}
+
+type c14nScope struct {
+	name     string
+	inScope  map[string]string // namespaces in scope in the input
+	rendered map[string]string // namespaces declared by output ancestors
+	xmlAttr  map[string]string // inherited xml: attributes (context only)
+}
+
+// NewCanonicalizer returns a Canonicalizer writing to w.
+func NewCanonicalizer(w io.Writer, exclusive, comments bool) *Canonicalizer {
+	return &Canonicalizer{w: bufio.NewWriter(w), exclusive: exclusive, comments: comments}
+}
+
+// SetContext prepares for canonicalizing a document subset: the element
+// written next has the namespaces ns in scope and inherits the xml:
+// attributes in xmlAttr (by local name), which inclusive canonicalization
+// copies onto it.
+func (c *Canonicalizer) SetContext(ns, xmlAttr map[string]string) {
+	c.ctx = c14nScope{inScope: ns, xmlAttr: xmlAttr}
+}
+
+// Context returns the arguments to SetContext for canonicalizing n on its
+// own.
+func Context(n *Node) (ns, xmlAttr map[string]string) {
+	if n.Parent == nil {
+		return nil, nil
+	}
+	ns = n.Parent.InScope()
+	xmlAttr = map[string]string{}
+	for e := n.Parent; e != nil; e = e.Parent {
+		for _, a := range e.Attr {
+			if _, ok := xmlAttr[a.Local]; !ok && a.Space == xmlNS {
+				xmlAttr[a.Local] = a.Data
+			}
+		}
+	}
+	return ns, xmlAttr
+}
+
+func (c *Canonicalizer) top() *c14nScope {
+	if len(c.stack) == 0 {
+		return &c.ctx
+	}
+	return &c.stack[len(c.stack)-1]
+}
+
+type c14nAttr struct {
+	space, local string // sort keys
+	qname, value string
+}
+
+// Token writes one token.
+func (c *Canonicalizer) Token(tok xml.Token) error {
+	switch t := tok.(type) {
+	case xml.StartElement:
+		parent := c.top()
+		s := c14nScope{name: qname(t.Name), inScope: parent.inScope, rendered: parent.rendered}
+		var attrs []xml.Attr
+		copied := false
+		for _, a := range t.Attr {
+			prefix, isDecl := a.Name.Local, a.Name.Space == "xmlns"
+			if a.Name.Space == "" && a.Name.Local == "xmlns" {
+				prefix, isDecl = "", true
+			}
+			if !isDecl {
+				attrs = append(attrs, a)
+				continue
+			}
+			if !copied {
+				s.inScope = copyMap(s.inScope)
+				copied = true
+			}
+			s.inScope[prefix] = a.Value
+		}
+		sorted := make([]c14nAttr, len(attrs))
+		for i, a := range attrs {
+			sorted[i] = c14nAttr{space: resolve(s.inScope, a.Name.Space), local: a.Name.Local, qname: qname(a.Name), value: a.Value}
+		}
+		if len(c.stack) == 0 && !c.exclusive {
+			have := map[string]bool{}
+			for _, a := range sorted {
+				if a.space == xmlNS {
+					have[a.local] = true
+				}
+			}
+			for local, v := range c.ctx.xmlAttr {
+				if !have[local] {
+					sorted = append(sorted, c14nAttr{space: xmlNS, local: local, qname: "xml:" + local, value: v})
+				}
+			}
+		}
+		// Pick the declarations to write.
+		var prefixes []string
+		if c.exclusive {
+			used := map[string]bool{t.Name.Space: true}
+			for _, a := range t.Attr {
+				if a.Name.Space != "" && a.Name.Space != "xmlns" {
+					used[a.Name.Space] = true
+				}
+			}
+			for p := range used {
+				prefixes = append(prefixes, p)
+			}
+		} else {
+			prefixes = append(prefixes, "")
+			for p := range s.inScope {
+				if p != "" {
+					prefixes = append(prefixes, p)
+				}
+			}
+		}
+		sort.Strings(prefixes)
+		var b strings.Builder
+		b.WriteString("<" + s.name)
+		renderedCopied := false
+		for _, p := range prefixes {
+			if p == "xml" || s.inScope[p] == s.rendered[p] {
+				continue
+			}
+			if !renderedCopied {
+				s.rendered = copyMap(s.rendered)
+				renderedCopied = true
+			}
+			s.rendered[p] = s.inScope[p]
+			if p == "" {
+				b.WriteString(` xmlns="` + c14nEscape(s.inScope[p], true) + `"`)
+			} else {
+				b.WriteString(" xmlns:" + p + `="` + c14nEscape(s.inScope[p], true) + `"`)
+			}
+		}
+		sort.Slice(sorted, func(i, j int) bool {
+			if sorted[i].space != sorted[j].space {
+				return sorted[i].space < sorted[j].space
+			}
+			return sorted[i].local < sorted[j].local
+		})
+		for _, a := range sorted {
+			b.WriteString(" " + a.qname + `="` + c14nEscape(a.value, true) + `"`)
+		}
+		b.WriteString(">")
+		c.w.WriteString(b.String())
+		c.stack = append(c.stack, s)
+	case xml.EndElement:
+		if len(c.stack) > 0 {
+			c.w.WriteString("</" + c.top().name + ">")
+			c.stack = c.stack[:len(c.stack)-1]
+			c.after = len(c.stack) == 0
+		}
+	case xml.CharData:
+		if len(c.stack) > 0 {
+			c.w.WriteString(c14nEscape(string(t), false))
+		}
+	case xml.Comment:
+		if c.comments {
+			c.item("<!--" + string(t) + "-->")
+		}
+	case xml.ProcInst:
+		if t.Target == "xml" {
+			return nil
+		}
+		if len(t.Inst) == 0 {
+			c.item("<?" + t.Target + "?>")
+		} else {
+			c.item("<?" + t.Target + " " + string(t.Inst) + "?>")
+		}
+	}
+	return nil
+}
+
+// item writes a comment or processing instruction, which outside the root
+// element go on lines of their own.
+func (c *Canonicalizer) item(s string) {
+	switch {
+	case len(c.stack) > 0:
+		c.w.WriteString(s)
+	case c.after:
+		c.w.WriteString("\n" + s)
+	default:
+		c.w.WriteString(s + "\n")
+	}
+}
+
+// Flush flushes the output. Canonical XML has no final newline.
+func (c *Canonicalizer) Flush() error { return c.w.Flush() }
+
+func resolve(ns map[string]string, prefix string) string {
+	switch prefix {
+	case "":
+		return ""
+	case "xml":
+		return xmlNS
+	}
+	return ns[prefix]
+}
+
+func copyMap(m map[string]string) map[string]string {
+	c := make(map[string]string, len(m)+1)
+	for k, v := range m {
+		c[k] = v
+	}
+	return c
+}
+
+func c14nEscape(s string, attr bool) string {
+	if !strings.ContainsAny(s, "&<>\"\r\n\t") {
+		return s
+	}
+	var b strings.Builder
+	for _, r := range s {
+		switch {
+		case r == '&':
+			b.WriteString("&amp;")
+		case r == '<':
+			b.WriteString("&lt;")
+		case r == '>' && !attr:
+			b.WriteString("&gt;")
+		case r == '"' && attr:
+			b.WriteString("&quot;")
+		case r == '\r':
+			b.WriteString("&#xD;")
+		case r == '\t' && attr:
+			b.WriteString("&#x9;")
+		case r == '\n' && attr:
+			b.WriteString("&#xA;")
+		default:
+			b.WriteRune(r)
+		}
+	}
+	return b.String()
+}
@@ -0,0 +1,178 @@
+package xmlutil
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Entities returns the replacement text of the internal general entities
+// declared in a DOCTYPE directive's internal subset, in the form
+// encoding/xml's Decoder.Entity takes. Entity references and character
+// references in the values are expanded. Entities whose replacement text
+// holds markup, and external entities, cannot be expanded as plain text
+// and are errors.
+func Entities(doctype string) (map[string]string, error) {
+	open := strings.IndexByte(doctype, '[')
+	if open < 0 {
+		return nil, nil
+	}
+	s := doctype[open+1:]
+	raw := map[string]string{}
+	var order []string
+	for len(s) > 0 {
+		switch {
+		case strings.HasPrefix(s, "<!--"):
+			end := strings.Index(s, "-->")
+			if end < 0 {
+				return nil, fmt.Errorf("unterminated comment in DOCTYPE")
+			}
+			s = s[end+3:]
+			continue
+		case strings.HasPrefix(s, "<?"):
+			end := strings.Index(s, "?>")
+			if end < 0 {
+				return nil, fmt.Errorf("unterminated processing instruction in DOCTYPE")
+			}
+			s = s[end+2:]
+			continue
+		case strings.HasPrefix(s, "<!ENTITY"):
+			fields, rest, err := declFields(s[len("<!ENTITY"):])
+			if err != nil {
+				return nil, err
+			}
+			s = rest
+			if len(fields) == 0 || fields[0] == "%" {
+				continue // parameter entities only matter to the DTD itself
+			}
+			name := fields[0]
+			if len(fields) < 2 || !isQuoted(fields[1]) {
+				return nil, fmt.Errorf("external entity &%s; is not supported", name)
+			}
+			if _, dup := raw[name]; !dup { // the first declaration wins
+				raw[name] = fields[1][1 : len(fields[1])-1]
+				order = append(order, name)
+			}
+			continue
+		case strings.HasPrefix(s, "<!"):
+			_, rest, err := declFields(s[2:])
+			if err != nil {
+				return nil, err
+			}
+			s = rest
+			continue
+		}
+		s = s[1:]
+	}
+
+	entities := map[string]string{}
+	var expand func(name string, seen map[string]bool) (string, error)
+	expand = func(name string, seen map[string]bool) (string, error) {
+		if v, ok := entities[name]; ok {
+			return v, nil
+		}
+		if seen[name] {
+			return "", fmt.Errorf("entity &%s; refers to itself", name)
+		}
+		seen[name] = true
+		var b strings.Builder
+		v := raw[name]
+		for len(v) > 0 {
+			i := strings.IndexAny(v, "&<")
+			if i < 0 {
+				b.WriteString(v)
+				break
+			}
+			b.WriteString(v[:i])
+			if v[i] == '<' {
+				return "", fmt.Errorf("entity &%s; contains markup, which is not supported", name)
+			}
+			end := strings.IndexByte(v[i:], ';')
+			if end < 0 {
+				return "", fmt.Errorf("unterminated reference in entity &%s;", name)
+			}
+			ref := v[i+1 : i+end]
+			v = v[i+end+1:]
+			switch {
+			case strings.HasPrefix(ref, "#"):
+				r, err := charRef(ref[1:])
+				if err != nil {
+					return "", fmt.Errorf("entity &%s;: %v", name, err)
+				}
+				b.WriteRune(r)
+			case predefined[ref] != "":
+				b.WriteString(predefined[ref])
+			case hasKey(raw, ref):
+				sub, err := expand(ref, seen)
+				if err != nil {
+					return "", err
+				}
+				b.WriteString(sub)
+			default:
+				return "", fmt.Errorf("entity &%s; refers to undeclared &%s;", name, ref)
+			}
+		}
+		entities[name] = b.String()
+		return entities[name], nil
+	}
+	for _, name := range order {
+		if _, err := expand(name, map[string]bool{}); err != nil {
+			return nil, err
+		}
+	}
+	return entities, nil
+}
+
+var predefined = map[string]string{"lt": "<", "gt": ">", "amp": "&", "apos": "'", "quot": `"`}
+
+func hasKey(m map[string]string, k string) bool {
+	_, ok := m[k]
+	return ok
+}
+
+func isQuoted(s string) bool {
+	return len(s) >= 2 && (s[0] == '"' || s[0] == '\'') && s[len(s)-1] == s[0]
+}
+
+// declFields splits a markup declaration into its space-separated fields,
+// keeping quoted literals whole, and returns the input after its '>'.
+func declFields(s string) ([]string, string, error) {
+	var fields []string
+	for i := 0; i < len(s); {
+		switch c := s[i]; {
+		case c == '>':
+			return fields, s[i+1:], nil
+		case c == ' ' || c == '\t' || c == '\r' || c == '\n':
+			i++
+		case c == '"' || c == '\'':
+			end := strings.IndexByte(s[i+1:], c)
+			if end < 0 {
+				return nil, "", fmt.Errorf("unterminated literal in DOCTYPE")
+			}
+			fields = append(fields, s[i:i+end+2])
+			i += end + 2
+		default:
+			j := i
+			for j < len(s) && !strings.ContainsRune(" \t\r\n>\"'", rune(s[j])) {
+				j++
+			}
+			fields = append(fields, s[i:j])
+			i = j
+		}
+	}
+	return nil, "", fmt.Errorf("unterminated declaration in DOCTYPE")
+}
+
+// charRef decodes the number of a character reference, such as "38" or
+// "x26".
+func charRef(num string) (rune, error) {
+	digits, base := num, 10
+	if strings.HasPrefix(num, "x") {
+		digits, base = num[1:], 16
+	}
+	n, err := strconv.ParseUint(digits, base, 32)
+	if err != nil || n == 0 || n > 0x10FFFF {
+		return 0, fmt.Errorf("invalid character reference &#%s;", num)
+	}
+	return rune(n), nil
+}
@@ -0,0 +1,465 @@
+package xmlutil
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// Values are []*Node (a node-set, in document order), string, float64
+// or bool.
+
+type context struct {
+	node      *Node
+	pos, size int
+	ex        *Expr
+}
+
+func (c *context) fail(format string, args ...interface{}) {
+	panic(&XPathError{Expr: c.ex.src, Pos: -1, Msg: fmt.Sprintf(format, args...)})
+}
+
+// Evaluate evaluates e with n as the context node. The result is a
+// []*Node in document order, a string, a float64 or a bool.
+func (e *Expr) Evaluate(n *Node) (v interface{}, err error) {
+	defer catch(&err)
+	return eval(e.root, &context{node: n, pos: 1, size: 1, ex: e}), nil
+}
+
+// catch turns a panic with an *XPathError into an error.
+func catch(err *error) {
+	if r := recover(); r != nil {
+		xe, ok := r.(*XPathError)
+		if !ok {
+			panic(r)
+		}
+		*err = xe
+	}
+}
+
+func eval(e expr, c *context) interface{} {
+	switch x := e.(type) {
+	case string, float64:
+		return x
+	case *negate:
+		return -toNumber(eval(x.e, c))
+	case *call:
+		return x.fn.f(c, x.args)
+	case *filter:
+		nodes := nodeSet(c, eval(x.e, c), "a predicate")
+		for _, pred := range x.preds {
+			nodes = applyPred(nodes, pred, c)
+		}
+		return nodes
+	case *path:
+		var nodes []*Node
+		switch {
+		case x.filter != nil:
+			nodes = nodeSet(c, eval(x.filter, c), "a path")
+		case x.abs:
+			root := c.node
+			for root.Parent != nil {
+				root = root.Parent
+			}
+			nodes = []*Node{root}
+		default:
+			nodes = []*Node{c.node}
+		}
+		return evalSteps(nodes, x.steps, c)
+	case *binary:
+		return evalBinary(x, c)
+	}
+	panic(fmt.Sprintf("xmlutil: unknown expression %T", e))
+}
+
+func nodeSet(c *context, v interface{}, what string) []*Node {
+	nodes, ok := v.([]*Node)
+	if !ok {
+		c.fail("%s needs a node-set, not %s", what, typeName(v))
+	}
+	return nodes
+}
+
+func typeName(v interface{}) string {
+	switch v.(type) {
+	case []*Node:
+		return "a node-set"
+	case string:
+		return "a string"
+	case float64:
+		return "a number"
+	}
+	return "a boolean"
+}
+
+func evalBinary(x *binary, c *context) interface{} {
+	switch x.op {
+	case "or":
+		return toBool(eval(x.l, c)) || toBool(eval(x.r, c))
+	case "and":
+		return toBool(eval(x.l, c)) && toBool(eval(x.r, c))
+	case "|":
+		a := nodeSet(c, eval(x.l, c), "|")
+		b := nodeSet(c, eval(x.r, c), "|")
+		return union(a, b)
+	case "=", "!=", "<", "<=", ">", ">=":
+		return compare(x.op, eval(x.l, c), eval(x.r, c))
+	}
+	a, b := toNumber(eval(x.l, c)), toNumber(eval(x.r, c))
+	switch x.op {
+	case "+":
+		return a + b
+	case "-":
+		return a - b
+	case "*":
+		return a * b
+	case "div":
+		return a / b
+	}
+	return math.Mod(a, b)
+}
+
+func union(a, b []*Node) []*Node {
+	seen := make(map[*Node]bool, len(a))
+	out := append([]*Node(nil), a...)
+	for _, n := range a {
+		seen[n] = true
+	}
+	for _, n := range b {
+		if !seen[n] {
+			out = append(out, n)
+		}
+	}
+	sortNodes(out)
+	return out
+}
+
+// compare applies a comparison with the node-set rules of XPath 1.0: it
+// holds if it holds for some node.
+func compare(op string, a, b interface{}) bool {
+	an, aSet := a.([]*Node)
+	bn, bSet := b.([]*Node)
+	switch {
+	case aSet && bSet:
+		for _, x := range an {
+			sx := x.Value()
+			for _, y := range bn {
+				if compareAtoms(op, sx, y.Value()) {
+					return true
+				}
+			}
+		}
+		return false
+	case aSet:
+		if _, ok := b.(bool); ok {
+			return compareAtoms(op, len(an) > 0, b)
+		}
+		for _, x := range an {
+			if compareAtoms(op, x.Value(), b) {
+				return true
+			}
+		}
+		return false
+	case bSet:
+		if _, ok := a.(bool); ok {
+			return compareAtoms(op, a, len(bn) > 0)
+		}
+		for _, y := range bn {
+			if compareAtoms(op, a, y.Value()) {
+				return true
+			}
+		}
+		return false
+	}
+	return compareAtoms(op, a, b)
+}
+
+func compareAtoms(op string, a, b interface{}) bool {
+	if op == "=" || op == "!=" {
+		var eq bool
+		_, aBool := a.(bool)
+		_, bBool := b.(bool)
+		_, aNum := a.(float64)
+		_, bNum := b.(float64)
+		switch {
+		case aBool || bBool:
+			eq = toBool(a) == toBool(b)
+		case aNum || bNum:
+			eq = toNumber(a) == toNumber(b)
+		default:
+			eq = toString(a) == toString(b)
+		}
+		return eq == (op == "=")
+	}
+	x, y := toNumber(a), toNumber(b)
+	switch op {
+	case "<":
+		return x < y
+	case "<=":
+		return x <= y
+	case ">":
+		return x > y
+	}
+	return x >= y
+}
+
+func toString(v interface{}) string {
+	switch x := v.(type) {
+	case string:
+		return x
+	case float64:
+		return FormatNumber(x)
+	case bool:
+		return strconv.FormatBool(x)
+	case []*Node:
+		if len(x) == 0 {
+			return ""
+		}
+		return x[0].Value()
+	}
+	return ""
+}
+
+func toNumber(v interface{}) float64 {
+	switch x := v.(type) {
+	case float64:
+		return x
+	case bool:
+		if x {
+			return 1
+		}
+		return 0
+	}
+	return parseNumber(toString(v))
+}
+
+func toBool(v interface{}) bool {
+	switch x := v.(type) {
+	case bool:
+		return x
+	case float64:
+		return x != 0 && !math.IsNaN(x)
+	case string:
+		return x != ""
+	case []*Node:
+		return len(x) > 0
+	}
+	return false
+}
+
+// parseNumber converts a string the way number() does: an optional minus
+// and decimal digits with an optional point, surrounded by white space;
+// anything else is NaN.
+func parseNumber(s string) float64 {
+	s = strings.Trim(s, " \t\r\n")
+	t := strings.TrimPrefix(s, "-")
+	digits, point := 0, 0
+	for i := 0; i < len(t); i++ {
+		switch {
+		case t[i] >= '0' && t[i] <= '9':
+			digits++
+		case t[i] == '.':
+			point++
+		default:
+			return math.NaN()
+		}
+	}
+	if digits == 0 || point > 1 {
+		return math.NaN()
+	}
+	f, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		return math.NaN()
+	}
+	return f
+}
+
+// FormatNumber formats f the way XPath's string() does: integers without a
+// decimal point and no exponents.
+func FormatNumber(f float64) string {
+	switch {
+	case math.IsNaN(f):
+		return "NaN"
+	case math.IsInf(f, 1):
+		return "Infinity"
+	case math.IsInf(f, -1):
+		return "-Infinity"
+	case f == 0:
+		return "0"
+	}
+	return strconv.FormatFloat(f, 'f', -1, 64)
+}
+
+// String returns the XPath string-value of a result of Evaluate.
+func String(v interface{}) string { return toString(v) }
+
+func applyPred(nodes []*Node, pred expr, c *context) []*Node {
+	var out []*Node
+	for i, n := range nodes {
+		v := eval(pred, &context{node: n, pos: i + 1, size: len(nodes), ex: c.ex})
+		if f, ok := v.(float64); ok {
+			if f == float64(i+1) {
+				out = append(out, n)
+			}
+		} else if toBool(v) {
+			out = append(out, n)
+		}
+	}
+	return out
+}
+
+func evalSteps(nodes []*Node, steps []*step, c *context) []*Node {
+	for _, s := range steps {
+		var out []*Node
+		var seen map[*Node]bool
+		if len(nodes) > 1 {
+			seen = map[*Node]bool{}
+		}
+		for _, n := range nodes {
+			var sel []*Node
+			walkAxis(n, s.axis, func(m *Node) {
+				if s.test.match(m, s.axis.principal()) {
+					sel = append(sel, m)
+				}
+			})
+			for _, pred := range s.preds {
+				sel = applyPred(sel, pred, c)
+			}
+			for _, m := range sel {
+				if seen == nil {
+					out = append(out, m)
+				} else if !seen[m] {
+					seen[m] = true
+					out = append(out, m)
+				}
+			}
+		}
+		if len(nodes) > 1 || s.axis.reverse() {
+			sortNodes(out)
+		}
+		nodes = out
+	}
+	return nodes
+}
+
+func (t *nodeTest) match(n *Node, principal NodeType) bool {
+	switch t.kind {
+	case testNode:
+		return true
+	case testText:
+		return n.Type == TextNode
+	case testComment:
+		return n.Type == CommentNode
+	case testPI:
+		return n.Type == ProcInstNode && (t.local == "" || t.local == n.Local)
+	}
+	if n.Type != principal {
+		return false
+	}
+	switch t.kind {
+	case testAny:
+		return true
+	case testName:
+		if n.Local != t.local {
+			return false
+		}
+		if n.Type == NamespaceNode {
+			return t.prefix == ""
+		}
+	}
+	switch {
+	case t.bound:
+		return n.Space == t.space
+	case t.prefix == "":
+		return principal == ElementNode || n.Space == ""
+	}
+	uri, ok := n.Lookup(t.prefix)
+	return ok && n.Space == uri
+}
+
+// walkAxis calls f with the nodes on axis a from n, in the axis' order.
+func walkAxis(n *Node, a axis, f func(*Node)) {
+	switch a {
+	case axisChild:
+		for _, c := range n.Children {
+			f(c)
+		}
+	case axisDescendant:
+		descend(n, f)
+	case axisDescendantOrSelf:
+		f(n)
+		descend(n, f)
+	case axisParent:
+		if n.Parent != nil {
+			f(n.Parent)
+		}
+	case axisAncestor:
+		for p := n.Parent; p != nil; p = p.Parent {
+			f(p)
+		}
+	case axisAncestorOrSelf:
+		for p := n; p != nil; p = p.Parent {
+			f(p)
+		}
+	case axisFollowingSibling:
+		if n.Parent != nil && n.Type != AttributeNode && n.Type != NamespaceNode {
+			for _, s := range n.Parent.Children[n.index+1:] {
+				f(s)
+			}
+		}
+	case axisPrecedingSibling:
+		if n.Parent != nil && n.Type != AttributeNode && n.Type != NamespaceNode {
+			for i := n.index - 1; i >= 0; i-- {
+				f(n.Parent.Children[i])
+			}
+		}
+	case axisFollowing:
+		x := n
+		if n.Type == AttributeNode || n.Type == NamespaceNode {
+			x = n.Parent
+			descend(x, f)
+		}
+		for ; x.Parent != nil; x = x.Parent {
+			for _, s := range x.Parent.Children[x.index+1:] {
+				f(s)
+				descend(s, f)
+			}
+		}
+	case axisPreceding:
+		x := n.element()
+		for ; x.Parent != nil; x = x.Parent {
+			for i := x.index - 1; i >= 0; i-- {
+				s := x.Parent.Children[i]
+				descendReverse(s, f)
+				f(s)
+			}
+		}
+	case axisAttribute:
+		for _, a := range n.Attr {
+			f(a)
+		}
+	case axisNamespace:
+		if n.Type == ElementNode {
+			for _, ns := range n.namespaces() {
+				f(ns)
+			}
+		}
+	case axisSelf:
+		f(n)
+	}
+}
+
+func descend(n *Node, f func(*Node)) {
+	for _, c := range n.Children {
+		f(c)
+		descend(c, f)
+	}
+}
+
+func descendReverse(n *Node, f func(*Node)) {
+	for i := len(n.Children) - 1; i >= 0; i-- {
+		descendReverse(n.Children[i], f)
+		f(n.Children[i])
+	}
+}
@@ -0,0 +1,228 @@
+package xmlutil
+
+import (
+	"math"
+	"strings"
+	"unicode/utf8"
+)
+
+// function is an XPath core library function.
+type function struct {
+	min, max int // argument counts; max -1 for any number
+	number   bool
+	f        func(c *context, args []expr) interface{}
+}
+
+var functions map[string]*function
+
+func init() {
+	functions = map[string]*function{
+		// Node-set functions.
+		"last":     {0, 0, true, func(c *context, _ []expr) interface{} { return float64(c.size) }},
+		"position": {0, 0, true, func(c *context, _ []expr) interface{} { return float64(c.pos) }},
+		"count": {1, 1, true, func(c *context, args []expr) interface{} {
+			return float64(len(nodeSet(c, eval(args[0], c), "count()")))
+		}},
+		"id": {1, 1, false, fnID},
+		"local-name": {0, 1, false, func(c *context, args []expr) interface{} {
+			if n := argNode(c, args, "local-name()"); n != nil && n.Type != TextNode && n.Type != CommentNode && n.Type != RootNode {
+				return n.Local
+			}
+			return ""
+		}},
+		"namespace-uri": {0, 1, false, func(c *context, args []expr) interface{} {
+			if n := argNode(c, args, "namespace-uri()"); n != nil {
+				return n.Space
+			}
+			return ""
+		}},
+		"name": {0, 1, false, func(c *context, args []expr) interface{} {
+			if n := argNode(c, args, "name()"); n != nil && n.Type != TextNode && n.Type != CommentNode && n.Type != RootNode {
+				return n.Name()
+			}
+			return ""
+		}},
+
+		// String functions.
+		"string": {0, 1, false, func(c *context, args []expr) interface{} { return argString(c, args) }},
+		"concat": {2, -1, false, func(c *context, args []expr) interface{} {
+			var b strings.Builder
+			for _, a := range args {
+				b.WriteString(toString(eval(a, c)))
+			}
+			return b.String()
+		}},
+		"starts-with": {2, 2, false, func(c *context, args []expr) interface{} {
+			return strings.HasPrefix(toString(eval(args[0], c)), toString(eval(args[1], c)))
+		}},
+		"contains": {2, 2, false, func(c *context, args []expr) interface{} {
+			return strings.Contains(toString(eval(args[0], c)), toString(eval(args[1], c)))
+		}},
+		"substring-before": {2, 2, false, func(c *context, args []expr) interface{} {
+			s, sep := toString(eval(args[0], c)), toString(eval(args[1], c))
+			if i := strings.Index(s, sep); i >= 0 {
+				return s[:i]
+			}
+			return ""
+		}},
+		"substring-after": {2, 2, false, func(c *context, args []expr) interface{} {
+			s, sep := toString(eval(args[0], c)), toString(eval(args[1], c))
+			if i := strings.Index(s, sep); i >= 0 {
+				return s[i+len(sep):]
+			}
+			return ""
+		}},
+		"substring":     {2, 3, false, fnSubstring},
+		"string-length": {0, 1, true, func(c *context, args []expr) interface{} { return float64(utf8.RuneCountInString(argString(c, args))) }},
+		"normalize-space": {0, 1, false, func(c *context, args []expr) interface{} {
+			return strings.Join(strings.Fields(argString(c, args)), " ")
+		}},
+		"translate": {3, 3, false, fnTranslate},
+
+		// Boolean functions.
+		"boolean": {1, 1, false, func(c *context, args []expr) interface{} { return toBool(eval(args[0], c)) }},
+		"not":     {1, 1, false, func(c *context, args []expr) interface{} { return !toBool(eval(args[0], c)) }},
+		"true":    {0, 0, false, func(*context, []expr) interface{} { return true }},
+		"false":   {0, 0, false, func(*context, []expr) interface{} { return false }},
+		"lang":    {1, 1, false, fnLang},
+
+		// Number functions.
+		"number": {0, 1, true, func(c *context, args []expr) interface{} {
+			if len(args) == 0 {
+				return parseNumber(c.node.Value())
+			}
+			return toNumber(eval(args[0], c))
+		}},
+		"sum": {1, 1, true, func(c *context, args []expr) interface{} {
+			sum := 0.0
+			for _, n := range nodeSet(c, eval(args[0], c), "sum()") {
+				sum += parseNumber(n.Value())
+			}
+			return sum
+		}},
+		"floor":   {1, 1, true, func(c *context, args []expr) interface{} { return math.Floor(toNumber(eval(args[0], c))) }},
+		"ceiling": {1, 1, true, func(c *context, args []expr) interface{} { return math.Ceil(toNumber(eval(args[0], c))) }},
+		"round":   {1, 1, true, func(c *context, args []expr) interface{} { return round(toNumber(eval(args[0], c))) }},
+	}
+}
+
+// argNode returns the first node of the optional node-set argument, or the
+// context node.
+func argNode(c *context, args []expr, name string) *Node {
+	if len(args) == 0 {
+		return c.node
+	}
+	nodes := nodeSet(c, eval(args[0], c), name)
+	if len(nodes) == 0 {
+		return nil
+	}
+	return nodes[0]
+}
+
+// argString returns the optional argument as a string, or the context
+// node's string-value.
+func argString(c *context, args []expr) string {
+	if len(args) == 0 {
+		return c.node.Value()
+	}
+	return toString(eval(args[0], c))
+}
+
+// round rounds half up, keeping NaN, infinities and negative zero.
+func round(f float64) float64 {
+	if math.IsNaN(f) || math.IsInf(f, 0) {
+		return f
+	}
+	if f < 0 && f >= -0.5 {
+		return math.Copysign(0, -1)
+	}
+	return math.Floor(f + 0.5)
+}
+
+func fnSubstring(c *context, args []expr) interface{} {
+	s := []rune(toString(eval(args[0], c)))
+	start := round(toNumber(eval(args[1], c)))
+	end := math.Inf(1)
+	if len(args) == 3 {
+		end = start + round(toNumber(eval(args[2], c)))
+	}
+	var b strings.Builder
+	for i, r := range s {
+		if p := float64(i + 1); p >= start && p < end {
+			b.WriteRune(r)
+		}
+	}
+	return b.String()
+}
+
+func fnTranslate(c *context, args []expr) interface{} {
+	s := toString(eval(args[0], c))
+	from := []rune(toString(eval(args[1], c)))
+	to := []rune(toString(eval(args[2], c)))
+	m := map[rune]rune{}
+	for i, r := range from {
+		if _, ok := m[r]; ok {
+			continue
+		}
+		if i < len(to) {
+			m[r] = to[i]
+		} else {
+			m[r] = -1
+		}
+	}
+	var b strings.Builder
+	for _, r := range s {
+		if t, ok := m[r]; ok {
+			if t >= 0 {
+				b.WriteRune(t)
+			}
+			continue
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+// fnID finds elements by an id or xml:id attribute; without a DTD there
+// is no other way to know which attributes are IDs.
+func fnID(c *context, args []expr) interface{} {
+	var ids []string
+	if nodes, ok := eval(args[0], c).([]*Node); ok {
+		for _, n := range nodes {
+			ids = append(ids, strings.Fields(n.Value())...)
+		}
+	} else {
+		ids = strings.Fields(toString(eval(args[0], c)))
+	}
+	want := map[string]bool{}
+	for _, id := range ids {
+		want[id] = true
+	}
+	root := c.node
+	for root.Parent != nil {
+		root = root.Parent
+	}
+	var out []*Node
+	descend(root, func(n *Node) {
+		for _, a := range n.Attr {
+			if a.Local == "id" && (a.Space == "" || a.Space == xmlNS) && want[a.Data] {
+				out = append(out, n)
+				return
+			}
+		}
+	})
+	return out
+}
+
+func fnLang(c *context, args []expr) interface{} {
+	want := strings.ToLower(toString(eval(args[0], c)))
+	for e := c.node.element(); e != nil; e = e.Parent {
+		for _, a := range e.Attr {
+			if a.Space == xmlNS && a.Local == "lang" {
+				lang := strings.ToLower(a.Data)
+				return lang == want || strings.HasPrefix(lang, want+"-")
+			}
+		}
+	}
+	return false
+}
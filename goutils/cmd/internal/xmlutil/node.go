@@ -0,0 +1,313 @@
+package xmlutil
+
+import (
+	"encoding/xml"
+	"io"
+	"sort"
+	"strings"
+)
+
+// NodeType is the kind of a Node, as in the XPath data model.
+type NodeType int
+
+const (
+	RootNode NodeType = iota
+	ElementNode
+	AttributeNode
+	TextNode
+	CommentNode
+	ProcInstNode
+	NamespaceNode
+)
+
+// Node is a node of a parsed document.
+type Node struct {
+	Type     NodeType
+	Prefix   string // as written; for a namespace node, the prefix it binds
+	Local    string // local name; a processing instruction's target
+	Space    string // namespace URI of an element or attribute
+	Data     string // text, comment, instruction or attribute value; a namespace node's URI
+	Parent   *Node
+	Children []*Node
+	Attr     []*Node // attributes, without namespace declarations
+	Decl     []*Node // namespace declarations, as namespace nodes
+
+	order, sub int  // document order
+	index      int  // position in Parent.Children
+	match      bool // selected while streaming
+}
+
+// Name returns the name of n as written, prefix included.
+func (n *Node) Name() string {
+	if n.Prefix != "" && n.Type != NamespaceNode {
+		return n.Prefix + ":" + n.Local
+	}
+	return n.Local
+}
+
+// Value returns the XPath string-value of n: the text it contains for a
+// root or element node, its content otherwise.
+func (n *Node) Value() string {
+	if n.Type != RootNode && n.Type != ElementNode {
+		return n.Data
+	}
+	var b strings.Builder
+	var walk func(*Node)
+	walk = func(n *Node) {
+		for _, c := range n.Children {
+			switch c.Type {
+			case TextNode:
+				b.WriteString(c.Data)
+			case ElementNode:
+				walk(c)
+			}
+		}
+	}
+	walk(n)
+	return b.String()
+}
+
+// before reports whether a comes before b in document order.
+func before(a, b *Node) bool {
+	return a.order < b.order || (a.order == b.order && a.sub < b.sub)
+}
+
+func sortNodes(nodes []*Node) {
+	sort.SliceStable(nodes, func(i, j int) bool { return before(nodes[i], nodes[j]) })
+}
+
+// element returns the element a name is resolved against: n itself, or
+// the parent of an attribute or namespace node.
+func (n *Node) element() *Node {
+	if n.Type == AttributeNode || n.Type == NamespaceNode {
+		return n.Parent
+	}
+	return n
+}
+
+// Lookup returns the namespace URI bound to prefix in the scope of n.
+func (n *Node) Lookup(prefix string) (string, bool) {
+	if prefix == "xml" {
+		return xmlNS, true
+	}
+	for e := n.element(); e != nil; e = e.Parent {
+		for _, d := range e.Decl {
+			if d.Prefix == prefix {
+				return d.Data, true
+			}
+		}
+	}
+	return "", prefix == ""
+}
+
+// InScope returns the namespaces in scope at element n, by prefix. An
+// empty default namespace is left out.
+func (n *Node) InScope() map[string]string {
+	ns := map[string]string{}
+	for e := n.element(); e != nil; e = e.Parent {
+		for _, d := range e.Decl {
+			if _, ok := ns[d.Prefix]; !ok {
+				ns[d.Prefix] = d.Data
+			}
+		}
+	}
+	if ns[""] == "" {
+		delete(ns, "")
+	}
+	return ns
+}
+
+// namespaces returns the namespace nodes of element n, sorted by prefix.
+func (n *Node) namespaces() []*Node {
+	ns := n.InScope()
+	ns["xml"] = xmlNS
+	prefixes := make([]string, 0, len(ns))
+	for p := range ns {
+		prefixes = append(prefixes, p)
+	}
+	sort.Strings(prefixes)
+	nodes := make([]*Node, len(prefixes))
+	for i, p := range prefixes {
+		nodes[i] = &Node{Type: NamespaceNode, Prefix: p, Local: p, Data: ns[p], Parent: n, order: n.order, sub: i + 1}
+	}
+	return nodes
+}
+
+// builder turns tokens from a Reader into nodes.
+type builder struct {
+	r     *Reader
+	cur   *Node
+	order int
+}
+
+func (b *builder) next() int {
+	b.order++
+	return b.order
+}
+
+func appendChild(parent, child *Node) {
+	child.Parent = parent
+	child.index = len(parent.Children)
+	parent.Children = append(parent.Children, child)
+}
+
+// element makes the node for a start tag just read from b.r.
+func (b *builder) element(t xml.StartElement) *Node {
+	n := &Node{Type: ElementNode, Prefix: t.Name.Space, Local: t.Name.Local, order: b.next()}
+	n.Space, _ = b.r.Lookup(t.Name.Space)
+	for _, a := range t.Attr {
+		switch {
+		case a.Name.Space == "xmlns":
+			n.Decl = append(n.Decl, &Node{Type: NamespaceNode, Prefix: a.Name.Local, Local: a.Name.Local, Data: a.Value, Parent: n})
+		case a.Name.Space == "" && a.Name.Local == "xmlns":
+			n.Decl = append(n.Decl, &Node{Type: NamespaceNode, Data: a.Value, Parent: n})
+		default:
+			attr := &Node{Type: AttributeNode, Prefix: a.Name.Space, Local: a.Name.Local, Data: a.Value, Parent: n, order: b.next()}
+			if a.Name.Space != "" {
+				attr.Space, _ = b.r.Lookup(a.Name.Space)
+			}
+			n.Attr = append(n.Attr, attr)
+		}
+	}
+	return n
+}
+
+// add adds a token other than an element's start or end to b.cur.
+func (b *builder) add(tok xml.Token) {
+	switch t := tok.(type) {
+	case xml.CharData:
+		if b.cur.Type == RootNode {
+			return
+		}
+		if k := len(b.cur.Children); k > 0 && b.cur.Children[k-1].Type == TextNode {
+			b.cur.Children[k-1].Data += string(t)
+			return
+		}
+		appendChild(b.cur, &Node{Type: TextNode, Data: string(t), order: b.next()})
+	case xml.Comment:
+		appendChild(b.cur, &Node{Type: CommentNode, Data: string(t), order: b.next()})
+	case xml.ProcInst:
+		if t.Target != "xml" {
+			appendChild(b.cur, &Node{Type: ProcInstNode, Local: t.Target, Data: string(t.Inst), order: b.next()})
+		}
+	}
+}
+
+// Parse reads the whole document from r.
+func Parse(r *Reader) (*Node, error) {
+	root := &Node{Type: RootNode}
+	b := &builder{r: r, cur: root}
+	for {
+		tok, err := r.Token()
+		if err == io.EOF {
+			return root, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		switch t := tok.(type) {
+		case xml.StartElement:
+			n := b.element(t)
+			appendChild(b.cur, n)
+			b.cur = n
+		case xml.EndElement:
+			b.cur = b.cur.Parent
+		default:
+			b.add(tok)
+		}
+	}
+}
+
+// Tokens calls f with the tokens that write n. An element written on its
+// own also declares the namespaces it uses from its ancestors.
+func (n *Node) Tokens(f func(xml.Token) error) error {
+	switch n.Type {
+	case RootNode:
+		for _, c := range n.Children {
+			if err := c.tokens(f, nil); err != nil {
+				return err
+			}
+		}
+		return nil
+	case ElementNode:
+		return n.tokens(f, n.inherited())
+	}
+	return n.tokens(f, nil)
+}
+
+// inherited returns declarations for the prefixes used in n's subtree that
+// n's ancestors declare.
+func (n *Node) inherited() []xml.Attr {
+	if n.Parent == nil {
+		return nil
+	}
+	outer := n.Parent.InScope()
+	used := map[string]bool{}
+	var walk func(*Node)
+	walk = func(e *Node) {
+		used[e.Prefix] = true
+		for _, a := range e.Attr {
+			if a.Prefix != "" {
+				used[a.Prefix] = true
+			}
+		}
+		for _, c := range e.Children {
+			if c.Type == ElementNode {
+				walk(c)
+			}
+		}
+	}
+	walk(n)
+	for _, d := range n.Decl {
+		delete(used, d.Prefix)
+	}
+	var prefixes []string
+	for p := range used {
+		if _, ok := outer[p]; ok && p != "xml" {
+			prefixes = append(prefixes, p)
+		}
+	}
+	sort.Strings(prefixes)
+	var decls []xml.Attr
+	for _, p := range prefixes {
+		decls = append(decls, declAttr(p, outer[p]))
+	}
+	return decls
+}
+
+func declAttr(prefix, uri string) xml.Attr {
+	if prefix == "" {
+		return xml.Attr{Name: xml.Name{Local: "xmlns"}, Value: uri}
+	}
+	return xml.Attr{Name: xml.Name{Space: "xmlns", Local: prefix}, Value: uri}
+}
+
+func (n *Node) tokens(f func(xml.Token) error, extra []xml.Attr) error {
+	switch n.Type {
+	case ElementNode:
+		name := xml.Name{Space: n.Prefix, Local: n.Local}
+		attr := extra
+		for _, d := range n.Decl {
+			attr = append(attr, declAttr(d.Prefix, d.Data))
+		}
+		for _, a := range n.Attr {
+			attr = append(attr, xml.Attr{Name: xml.Name{Space: a.Prefix, Local: a.Local}, Value: a.Data})
+		}
+		if err := f(xml.StartElement{Name: name, Attr: attr}); err != nil {
+			return err
+		}
+		for _, c := range n.Children {
+			if err := c.tokens(f, nil); err != nil {
+				return err
+			}
+		}
+		return f(xml.EndElement{Name: name})
+	case TextNode:
+		return f(xml.CharData(n.Data))
+	case CommentNode:
+		return f(xml.Comment(n.Data))
+	case ProcInstNode:
+		return f(xml.ProcInst{Target: n.Local, Inst: []byte(n.Data)})
+	}
+	return nil
+}
@@ -0,0 +1,278 @@
+// Package xmlutil reads, writes and queries XML documents as token
+// streams: a Reader that checks well-formedness and namespaces, a
+// pretty-printing Writer, Canonical XML (C14N 1.0 and exclusive C14N) and
+// XPath 1.0, which evaluates most location paths one matched element at a
+// time instead of on the whole document.
+package xmlutil
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"unicode/utf8"
+)
+
+const xmlNS = "http://www.w3.org/XML/1998/namespace"
+
+// SyntaxError is a well-formedness error at a position in the input.
+type SyntaxError struct {
+	Line int // 1-based
+	Col  int // 1-based
+	Msg  string
+}
+
+func (e *SyntaxError) Error() string {
+	return fmt.Sprintf("line %d, column %d: %s", e.Line, e.Col, e.Msg)
+}
+
+// Reader returns the tokens of an XML document and checks what
+// encoding/xml's RawToken leaves out: matching end tags, a single root
+// element, duplicate attributes and undeclared namespace prefixes.
+//
+// Names keep the prefix they were written with in Name.Space; Lookup
+// resolves a prefix to its namespace URI.
+type Reader struct {
+	dec       *xml.Decoder
+	scopes    []scope
+	pop       bool // the last token closed scopes[len-1]
+	root      int  // 0 before the root element, 1 inside it, 2 after it
+	tokens    int
+	line, col int // start of the last token
+}
+
+type scope struct {
+	name xml.Name
+	ns   map[string]string // declarations on the element; nil if none
+}
+
+// NewReader returns a Reader for r. Besides UTF-8 it accepts documents
+// declared as ISO-8859-1 or US-ASCII.
+func NewReader(r io.Reader) *Reader {
+	dec := xml.NewDecoder(r)
+	dec.Strict = true
+	dec.CharsetReader = charsetReader
+	return &Reader{dec: dec}
+}
+
+func charsetReader(label string, r io.Reader) (io.Reader, error) {
+	switch strings.ToLower(label) {
+	case "iso-8859-1", "iso8859-1", "latin1", "latin-1", "us-ascii", "ascii":
+		return &latin1Reader{r: bufio.NewReader(r)}, nil
+	}
+	return nil, fmt.Errorf("unsupported encoding %q", label)
+}
+
+// latin1Reader converts ISO-8859-1 to UTF-8.
+type latin1Reader struct {
+	r   io.ByteReader
+	buf []byte
+}
+
+func (l *latin1Reader) Read(p []byte) (int, error) {
+	n := 0
+	for n < len(p) {
+		if len(l.buf) > 0 {
+			c := copy(p[n:], l.buf)
+			l.buf = l.buf[c:]
+			n += c
+			continue
+		}
+		b, err := l.r.ReadByte()
+		if err != nil {
+			if n > 0 {
+				return n, nil
+			}
+			return 0, err
+		}
+		if b < utf8.RuneSelf {
+			p[n] = b
+			n++
+			continue
+		}
+		l.buf = utf8.AppendRune(nil, rune(b))
+	}
+	return n, nil
+}
+
+func (r *Reader) errorf(format string, args ...interface{}) error {
+	return &SyntaxError{Line: r.line, Col: r.col, Msg: fmt.Sprintf(format, args...)}
+}
+
+// Token returns the next token, or io.EOF after the end of a well-formed
+// document. The token is a copy the caller may keep.
+func (r *Reader) Token() (xml.Token, error) {
+	if r.pop {
+		r.scopes = r.scopes[:len(r.scopes)-1]
+		r.pop = false
+	}
+	r.line, r.col = r.dec.InputPos()
+	tok, err := r.dec.RawToken()
+	if err == io.EOF {
+		r.line, r.col = r.dec.InputPos()
+		switch {
+		case len(r.scopes) > 0:
+			return nil, r.errorf("unclosed <%s>", qname(r.scopes[len(r.scopes)-1].name))
+		case r.root == 0:
+			return nil, r.errorf("no root element")
+		}
+		return nil, io.EOF
+	}
+	if err != nil {
+		line, col := r.dec.InputPos()
+		msg := err.Error()
+		var se *xml.SyntaxError
+		if errors.As(err, &se) {
+			msg = se.Msg
+		}
+		return nil, &SyntaxError{Line: line, Col: col, Msg: msg}
+	}
+	tok = xml.CopyToken(tok)
+	r.tokens++
+	switch t := tok.(type) {
+	case xml.StartElement:
+		if r.root == 2 {
+			return nil, r.errorf("more than one root element")
+		}
+		r.root = 1
+		if err := r.push(t); err != nil {
+			return nil, err
+		}
+	case xml.EndElement:
+		if len(r.scopes) == 0 {
+			return nil, r.errorf("unexpected </%s>", qname(t.Name))
+		}
+		if open := r.scopes[len(r.scopes)-1].name; t.Name != open {
+			return nil, r.errorf("</%s> does not close <%s>", qname(t.Name), qname(open))
+		}
+		r.pop = true
+		if len(r.scopes) == 1 {
+			r.root = 2
+		}
+	case xml.CharData:
+		if len(r.scopes) > 0 {
+			break
+		}
+		if r.tokens == 1 && bytes.HasPrefix(t, []byte("\ufeff")) {
+			// A byte order mark does not count as content before an
+			// XML declaration.
+			r.tokens--
+			t = t[len("\ufeff"):]
+			tok = t
+		}
+		if len(bytes.TrimLeft(t, " \t\r\n")) > 0 {
+			return nil, r.errorf("text outside the root element")
+		}
+	case xml.Directive:
+		if r.root != 0 {
+			return nil, r.errorf("<!%s> must come before the root element", firstWord(string(t)))
+		}
+		if firstWord(string(t)) == "DOCTYPE" {
+			// Internal entities are expanded, as encoding/xml knows only
+			// the predefined ones.
+			ents, err := Entities(string(t))
+			if err != nil {
+				return nil, r.errorf("%v", err)
+			}
+			r.dec.Entity = ents
+		}
+	case xml.ProcInst:
+		if strings.EqualFold(t.Target, "xml") && r.tokens > 1 {
+			return nil, r.errorf("XML declaration is not at the start of the document")
+		}
+	}
+	return tok, nil
+}
+
+// push opens the scope of a start tag and checks its names.
+func (r *Reader) push(t xml.StartElement) error {
+	s := scope{name: t.Name}
+	seen := map[xml.Name]bool{}
+	for _, a := range t.Attr {
+		if seen[a.Name] {
+			return r.errorf("duplicate attribute %s", qname(a.Name))
+		}
+		seen[a.Name] = true
+		prefix, isDecl := "", false
+		switch {
+		case a.Name.Space == "xmlns":
+			prefix, isDecl = a.Name.Local, true
+			switch {
+			case a.Value == "":
+				return r.errorf("namespace prefix %q cannot be undeclared", prefix)
+			case prefix == "xmlns" || (prefix == "xml") != (a.Value == xmlNS):
+				return r.errorf("namespace prefix %q cannot be bound to %q", prefix, a.Value)
+			}
+		case a.Name.Space == "" && a.Name.Local == "xmlns":
+			isDecl = true
+		}
+		if isDecl {
+			if s.ns == nil {
+				s.ns = map[string]string{}
+			}
+			s.ns[prefix] = a.Value
+		}
+	}
+	r.scopes = append(r.scopes, s)
+	if _, ok := r.Lookup(t.Name.Space); !ok {
+		return r.errorf("undeclared namespace prefix %q in <%s>", t.Name.Space, qname(t.Name))
+	}
+	type expanded struct{ space, local string }
+	resolved := map[expanded]bool{}
+	for _, a := range t.Attr {
+		if a.Name.Space == "" || a.Name.Space == "xmlns" {
+			continue
+		}
+		uri, ok := r.Lookup(a.Name.Space)
+		if !ok {
+			return r.errorf("undeclared namespace prefix %q in attribute %s", a.Name.Space, qname(a.Name))
+		}
+		if resolved[expanded{uri, a.Name.Local}] {
+			return r.errorf("duplicate attribute {%s}%s", uri, a.Name.Local)
+		}
+		resolved[expanded{uri, a.Name.Local}] = true
+	}
+	return nil
+}
+
+// Lookup returns the namespace URI bound to prefix at the current token;
+// the empty prefix gives the default namespace, which may be "".
+func (r *Reader) Lookup(prefix string) (string, bool) {
+	if prefix == "xml" {
+		return xmlNS, true
+	}
+	for i := len(r.scopes) - 1; i >= 0; i-- {
+		if uri, ok := r.scopes[i].ns[prefix]; ok {
+			return uri, true
+		}
+	}
+	return "", prefix == ""
+}
+
+// Depth returns the number of elements open after the last token.
+func (r *Reader) Depth() int {
+	if r.pop {
+		return len(r.scopes) - 1
+	}
+	return len(r.scopes)
+}
+
+// Pos returns the line and column where the last token started.
+func (r *Reader) Pos() (line, col int) { return r.line, r.col }
+
+func qname(n xml.Name) string {
+	if n.Space != "" {
+		return n.Space + ":" + n.Local
+	}
+	return n.Local
+}
+
+func firstWord(s string) string {
+	if i := strings.IndexAny(s, " \t\r\n"); i >= 0 {
+		return s[:i]
+	}
+	return s
+}
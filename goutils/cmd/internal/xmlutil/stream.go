@@ -0,0 +1,285 @@
+package xmlutil
+
+import (
+	"encoding/xml"
+	"io"
+)
+
+// A location path such as //dependency[scope='test']/artifactId/text() can
+// be evaluated without the whole document. Its steps split into
+//
+//	a prefix of child::NAME and // steps without predicates, matched
+//	against the ancestors of each element as it starts;
+//	a split step child::NAME whose predicates do not depend on position;
+//	the rest, which only looks down from the split element.
+//
+// Each element the prefix and split step select is built with its
+// subtree, the predicates and the rest are evaluated on it, and the
+// subtree is dropped. The last step that can split is used, so the
+// subtrees kept are as small as possible.
+type plan struct {
+	prefix []*step
+	split  *step
+	rest   []*step
+}
+
+func makePlan(root expr) *plan {
+	p, ok := root.(*path)
+	if !ok || p.filter != nil {
+		return nil
+	}
+	var best *plan
+	for k, s := range p.steps {
+		if s.axis == axisChild && s.test.kind <= testPrefix && downwardSteps(p.steps[k+1:]) {
+			ok := true
+			for _, pred := range s.preds {
+				if !downward(pred) || positional(pred) {
+					ok = false
+				}
+			}
+			if ok {
+				best = &plan{prefix: p.steps[:k], split: s, rest: p.steps[k+1:]}
+			}
+		}
+		if len(s.preds) > 0 || !(s.axis == axisChild && s.test.kind <= testPrefix ||
+			s.axis == axisDescendantOrSelf && s.test.kind == testNode) {
+			break
+		}
+	}
+	return best
+}
+
+// downward reports whether e only looks at the context node and below it.
+func downward(e expr) bool {
+	switch x := e.(type) {
+	case *binary:
+		return downward(x.l) && downward(x.r)
+	case *negate:
+		return downward(x.e)
+	case *call:
+		if x.name == "id" || x.name == "lang" {
+			return false
+		}
+		for _, a := range x.args {
+			if !downward(a) {
+				return false
+			}
+		}
+	case *filter:
+		if !downward(x.e) {
+			return false
+		}
+		for _, pred := range x.preds {
+			if !downward(pred) {
+				return false
+			}
+		}
+	case *path:
+		if x.filter == nil && x.abs || x.filter != nil && !downward(x.filter) {
+			return false
+		}
+		return downwardSteps(x.steps)
+	}
+	return true
+}
+
+func downwardSteps(steps []*step) bool {
+	for _, s := range steps {
+		switch s.axis {
+		case axisChild, axisDescendant, axisDescendantOrSelf, axisSelf, axisAttribute, axisNamespace:
+		default:
+			return false
+		}
+		for _, pred := range s.preds {
+			if !downward(pred) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// positional reports whether a predicate depends on the position of the
+// context node: it is a number, or calls position() or last() outside an
+// inner predicate.
+func positional(e expr) bool {
+	switch x := e.(type) {
+	case float64, *negate:
+		return true
+	case *binary:
+		switch x.op {
+		case "+", "-", "*", "div", "mod":
+			return true
+		}
+	case *call:
+		if x.fn.number {
+			return true
+		}
+	}
+	return usesPosition(e)
+}
+
+// usesPosition reports whether e calls position() or last() for its own
+// context.
+func usesPosition(e expr) bool {
+	switch x := e.(type) {
+	case *binary:
+		return usesPosition(x.l) || usesPosition(x.r)
+	case *negate:
+		return usesPosition(x.e)
+	case *call:
+		if x.name == "position" || x.name == "last" {
+			return true
+		}
+		for _, a := range x.args {
+			if usesPosition(a) {
+				return true
+			}
+		}
+	case *filter:
+		return usesPosition(x.e)
+	case *path:
+		return x.filter != nil && usesPosition(x.filter)
+	}
+	return false
+}
+
+// matchPrefix matches the prefix steps against a chain of ancestors, the
+// outermost first.
+func matchPrefix(steps []*step, chain []*Node) bool {
+	if len(steps) == 0 {
+		return len(chain) == 0
+	}
+	s := steps[0]
+	if s.axis == axisDescendantOrSelf {
+		for i := 0; i <= len(chain); i++ {
+			if matchPrefix(steps[1:], chain[i:]) {
+				return true
+			}
+		}
+		return false
+	}
+	return len(chain) > 0 && s.test.match(chain[0], ElementNode) && matchPrefix(steps[1:], chain[1:])
+}
+
+// Stream evaluates e on the document read from r and calls emit with the
+// result. If e can be streamed, emit is called with the nodes selected
+// below each element the plan splits on, for each such element that
+// selects any, and only that element's subtree is in memory; count() and
+// sum() of such a path are totalled the same way. Otherwise the whole
+// document is parsed and emit is called once. Nodes passed to
+// emit stay valid after it returns.
+func (e *Expr) Stream(r *Reader, emit func(v interface{}) error) error {
+	if e.plan == nil {
+		doc, err := Parse(r)
+		if err != nil {
+			return err
+		}
+		v, err := e.Evaluate(doc)
+		if err != nil {
+			return err
+		}
+		return emit(v)
+	}
+	if e.agg != "" {
+		// count() and sum() over a path that streams are totalled as
+		// it goes.
+		total := 0.0
+		inner := &Expr{src: e.src, plan: e.plan}
+		err := inner.Stream(r, func(v interface{}) error {
+			for _, n := range v.([]*Node) {
+				if e.agg == "count" {
+					total++
+				} else {
+					total += parseNumber(n.Value())
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		return emit(total)
+	}
+	doc := &Node{Type: RootNode}
+	b := &builder{r: r}
+	chain := []*Node{}
+	var capture *Node
+	for {
+		tok, err := r.Token()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		switch t := tok.(type) {
+		case xml.StartElement:
+			n := b.element(t)
+			if capture != nil {
+				appendChild(b.cur, n)
+				b.cur = n
+			} else {
+				n.Parent = doc
+				if len(chain) > 0 {
+					n.Parent = chain[len(chain)-1]
+				}
+			}
+			if matchPrefix(e.plan.prefix, chain) && e.plan.split.test.match(n, ElementNode) {
+				n.match = true
+				if capture == nil {
+					capture, b.cur = n, n
+				}
+			}
+			chain = append(chain, n)
+		case xml.EndElement:
+			n := chain[len(chain)-1]
+			chain = chain[:len(chain)-1]
+			if capture == nil {
+				continue
+			}
+			if n != capture {
+				b.cur = n.Parent
+				continue
+			}
+			capture, b.cur = nil, nil
+			nodes, err := e.evalCapture(n)
+			if err != nil {
+				return err
+			}
+			if len(nodes) > 0 {
+				if err := emit(nodes); err != nil {
+					return err
+				}
+			}
+		default:
+			if capture != nil {
+				b.add(tok)
+			}
+		}
+	}
+}
+
+// evalCapture evaluates the split predicates and the rest of the plan on
+// the marked elements of a captured subtree.
+func (e *Expr) evalCapture(top *Node) (nodes []*Node, err error) {
+	defer catch(&err)
+	var marked []*Node
+	var walk func(*Node)
+	walk = func(n *Node) {
+		if n.match {
+			marked = append(marked, n)
+		}
+		for _, c := range n.Children {
+			if c.Type == ElementNode {
+				walk(c)
+			}
+		}
+	}
+	walk(top)
+	c := &context{node: top, pos: 1, size: 1, ex: e}
+	for _, pred := range e.plan.split.preds {
+		marked = applyPred(marked, pred, c)
+	}
+	return evalSteps(marked, e.plan.rest, c), nil
+}
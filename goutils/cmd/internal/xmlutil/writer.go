@@ -0,0 +1,179 @@
+package xmlutil
+
+import (
+	"bufio"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"regexp"
+	"strings"
+)
+
+var encodingDecl = regexp.MustCompile(`encoding\s*=\s*("[^"]*"|'[^']*')`)
+
+// Writer writes tokens as XML, one element per line indented by its
+// depth, or all on one line when the indent is "". Whitespace between
+// elements is replaced; text is kept as it is, and once an element holds
+// text, or has xml:space="preserve", nothing inside it is reindented.
+// Namespace prefixes are written as given, so tokens from a Reader
+// round-trip.
+type Writer struct {
+	w      *bufio.Writer
+	indent string
+	stack  []frame
+	open   bool // a start tag is waiting for its > or />
+	wrote  bool
+}
+
+type frame struct {
+	name     string
+	verbatim bool // holds text, or is inside such an element
+	preserve bool // xml:space="preserve"
+	children bool
+}
+
+// NewWriter returns a Writer that indents with indent.
+func NewWriter(w io.Writer, indent string) *Writer {
+	return &Writer{w: bufio.NewWriter(w), indent: indent}
+}
+
+func (w *Writer) top() *frame {
+	if len(w.stack) == 0 {
+		return nil
+	}
+	return &w.stack[len(w.stack)-1]
+}
+
+// closeTag finishes a pending start tag.
+func (w *Writer) closeTag() {
+	if w.open {
+		w.w.WriteByte('>')
+		w.open = false
+	}
+}
+
+// newline starts a line for a child of the current element, or for an item
+// outside the root element.
+func (w *Writer) newline() {
+	w.closeTag()
+	f := w.top()
+	if f != nil {
+		f.children = true
+		if f.verbatim {
+			return
+		}
+	}
+	if w.wrote && w.indent != "" {
+		w.w.WriteByte('\n')
+		w.w.WriteString(strings.Repeat(w.indent, len(w.stack)))
+	}
+	w.wrote = true
+}
+
+// Token writes one token.
+func (w *Writer) Token(tok xml.Token) error {
+	switch t := tok.(type) {
+	case xml.StartElement:
+		w.newline()
+		f := frame{name: qname(t.Name)}
+		if p := w.top(); p != nil {
+			f.verbatim, f.preserve = p.verbatim, p.preserve
+		}
+		w.w.WriteString("<" + f.name)
+		for _, a := range t.Attr {
+			w.w.WriteString(" " + qname(a.Name) + `="` + escape(a.Value, true) + `"`)
+			if a.Name.Space == "xml" && a.Name.Local == "space" {
+				f.preserve = a.Value == "preserve"
+				f.verbatim = f.preserve
+			}
+		}
+		w.stack = append(w.stack, f)
+		w.open = true
+	case xml.EndElement:
+		f := w.top()
+		if f == nil {
+			return fmt.Errorf("unexpected </%s>", qname(t.Name))
+		}
+		w.stack = w.stack[:len(w.stack)-1]
+		switch {
+		case w.open:
+			w.w.WriteString("/>")
+			w.open = false
+		case f.children && !f.verbatim && w.indent != "":
+			w.w.WriteString("\n" + strings.Repeat(w.indent, len(w.stack)) + "</" + f.name + ">")
+		default:
+			w.w.WriteString("</" + f.name + ">")
+		}
+	case xml.CharData:
+		f := w.top()
+		if f == nil {
+			return nil
+		}
+		if !f.preserve && strings.TrimSpace(string(t)) == "" {
+			if f.verbatim {
+				w.closeTag()
+				w.w.WriteString(escape(string(t), false))
+			}
+			return nil
+		}
+		w.closeTag()
+		if !f.verbatim {
+			f.verbatim = true
+			f.children = false
+		}
+		w.w.WriteString(escape(string(t), false))
+	case xml.Comment:
+		w.newline()
+		w.w.WriteString("<!--" + string(t) + "-->")
+	case xml.ProcInst:
+		w.newline()
+		if t.Target == "xml" {
+			// The output is UTF-8 whatever the input was.
+			t.Inst = encodingDecl.ReplaceAll(t.Inst, []byte(`encoding="UTF-8"`))
+		}
+		if len(t.Inst) == 0 {
+			w.w.WriteString("<?" + t.Target + "?>")
+		} else {
+			w.w.WriteString("<?" + t.Target + " " + string(t.Inst) + "?>")
+		}
+	case xml.Directive:
+		w.newline()
+		w.w.WriteString("<!" + string(t) + ">")
+	}
+	return nil
+}
+
+// Flush ends the output with a newline and flushes it.
+func (w *Writer) Flush() error {
+	w.closeTag()
+	if w.wrote {
+		w.w.WriteByte('\n')
+		w.wrote = false
+	}
+	return w.w.Flush()
+}
+
+// escape escapes text, or an attribute value when attr is set.
+func escape(s string, attr bool) string {
+	if !strings.ContainsAny(s, "&<>\"\r\n\t") {
+		return s
+	}
+	var b strings.Builder
+	for _, r := range s {
+		switch {
+		case r == '&':
+			b.WriteString("&amp;")
+		case r == '<':
+			b.WriteString("&lt;")
+		case r == '>':
+			b.WriteString("&gt;")
+		case r == '"' && attr:
+			b.WriteString("&quot;")
+		case r == '\r', attr && (r == '\n' || r == '\t'):
+			fmt.Fprintf(&b, "&#x%X;", r)
+		default:
+			b.WriteRune(r)
+		}
+	}
+	return b.String()
+}
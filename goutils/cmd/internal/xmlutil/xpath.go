@@ -0,0 +1,629 @@
+package xmlutil
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// XPath 1.0 (https://www.w3.org/TR/xpath-10/) without variables.
+//
+// A name test without a prefix matches elements of that local name in any
+// namespace, so documents with a default namespace, such as Maven POMs and
+// XHTML, need no bindings; binding the empty prefix with Compile makes it
+// match that namespace only. A prefix not bound with Compile matches the
+// namespace the document binds it to where the node is.
+
+// Expr is a compiled XPath expression.
+type Expr struct {
+	src  string
+	root expr
+	plan *plan  // how to stream it; nil if it cannot be
+	agg  string // "count" or "sum" if plan streams the argument of one
+}
+
+// XPathError is an error compiling or evaluating an XPath expression.
+type XPathError struct {
+	Expr string
+	Pos  int // byte offset of a compile error, or -1
+	Msg  string
+}
+
+func (e *XPathError) Error() string {
+	if e.Pos < 0 {
+		return fmt.Sprintf("xpath %s: %s", e.Expr, e.Msg)
+	}
+	return fmt.Sprintf("xpath %s: at offset %d: %s", e.Expr, e.Pos, e.Msg)
+}
+
+// The expression tree.
+type (
+	expr interface{}
+
+	binary struct {
+		op   string
+		l, r expr
+	}
+	negate struct{ e expr }
+	call   struct {
+		name string
+		fn   *function
+		args []expr
+	}
+	// filter is a primary expression with predicates.
+	filter struct {
+		e     expr
+		preds []expr
+	}
+	// path is a location path, or a filter expression followed by steps.
+	path struct {
+		filter expr // nil for a location path
+		abs    bool
+		steps  []*step
+	}
+	step struct {
+		axis  axis
+		test  nodeTest
+		preds []expr
+	}
+)
+
+type axis int
+
+const (
+	axisChild axis = iota
+	axisDescendant
+	axisDescendantOrSelf
+	axisParent
+	axisAncestor
+	axisAncestorOrSelf
+	axisFollowingSibling
+	axisPrecedingSibling
+	axisFollowing
+	axisPreceding
+	axisAttribute
+	axisNamespace
+	axisSelf
+)
+
+var axes = map[string]axis{
+	"child":              axisChild,
+	"descendant":         axisDescendant,
+	"descendant-or-self": axisDescendantOrSelf,
+	"parent":             axisParent,
+	"ancestor":           axisAncestor,
+	"ancestor-or-self":   axisAncestorOrSelf,
+	"following-sibling":  axisFollowingSibling,
+	"preceding-sibling":  axisPrecedingSibling,
+	"following":          axisFollowing,
+	"preceding":          axisPreceding,
+	"attribute":          axisAttribute,
+	"namespace":          axisNamespace,
+	"self":               axisSelf,
+}
+
+// reverse reports whether a lists nodes in reverse document order.
+func (a axis) reverse() bool {
+	switch a {
+	case axisParent, axisAncestor, axisAncestorOrSelf, axisPrecedingSibling, axisPreceding:
+		return true
+	}
+	return false
+}
+
+// principal returns the kind of node a name test on a selects.
+func (a axis) principal() NodeType {
+	switch a {
+	case axisAttribute:
+		return AttributeNode
+	case axisNamespace:
+		return NamespaceNode
+	}
+	return ElementNode
+}
+
+type testKind int
+
+const (
+	testName    testKind = iota // prefix:local or local
+	testAny                     // *
+	testPrefix                  // prefix:*
+	testNode                    // node()
+	testText                    // text()
+	testComment                 // comment()
+	testPI                      // processing-instruction('target')
+)
+
+type nodeTest struct {
+	kind          testKind
+	prefix, local string // local is the target of testPI
+	space         string
+	bound         bool // space is set
+}
+
+// Lexer.
+
+type tokKind int
+
+const (
+	tEOF tokKind = iota
+	tLParen
+	tRParen
+	tLBracket
+	tRBracket
+	tDot
+	tDotDot
+	tAt
+	tComma
+	tColonColon
+	tName     // a name test: *, prefix:*, QName
+	tNodeType // comment, text, processing-instruction or node, before (
+	tOp       // an operator, named or not
+	tFunc     // a function name, before (
+	tAxis     // an axis name, before ::
+	tLiteral
+	tNumber
+	tVar
+)
+
+type token struct {
+	kind tokKind
+	s    string
+	pos  int
+}
+
+func isNameStart(r rune) bool { return r == '_' || unicode.IsLetter(r) }
+
+func isNameChar(r rune) bool {
+	return isNameStart(r) || r == '-' || r == '.' || unicode.IsDigit(r) || unicode.In(r, unicode.Mn, unicode.Mc, unicode.Lm)
+}
+
+type lexer struct {
+	src  string
+	pos  int
+	toks []token
+}
+
+func (l *lexer) errorf(pos int, format string, args ...interface{}) error {
+	return &XPathError{Expr: l.src, Pos: pos, Msg: fmt.Sprintf(format, args...)}
+}
+
+func (l *lexer) peekRune(i int) rune {
+	if i >= len(l.src) {
+		return 0
+	}
+	r, _ := utf8.DecodeRuneInString(l.src[i:])
+	return r
+}
+
+func (l *lexer) ncname(i int) int {
+	for i < len(l.src) {
+		r, n := utf8.DecodeRuneInString(l.src[i:])
+		if !isNameChar(r) {
+			break
+		}
+		i += n
+	}
+	return i
+}
+
+// skipSpace returns the offset of the next non-space byte at or after i.
+func (l *lexer) skipSpace(i int) int {
+	for i < len(l.src) && strings.IndexByte(" \t\r\n", l.src[i]) >= 0 {
+		i++
+	}
+	return i
+}
+
+// operand reports whether the last token ends an operand, which makes *
+// a multiplication and a name an operator (XPath 1.0 section 3.7).
+func (l *lexer) operand() bool {
+	if len(l.toks) == 0 {
+		return false
+	}
+	switch l.toks[len(l.toks)-1].kind {
+	case tAt, tColonColon, tLParen, tLBracket, tComma, tOp:
+		return false
+	}
+	return true
+}
+
+func lex(src string) ([]token, error) {
+	l := &lexer{src: src}
+	for {
+		l.pos = l.skipSpace(l.pos)
+		start := l.pos
+		emit := func(k tokKind, end int) {
+			l.toks = append(l.toks, token{kind: k, s: l.src[start:end], pos: start})
+			l.pos = end
+		}
+		if l.pos >= len(src) {
+			l.toks = append(l.toks, token{kind: tEOF, pos: l.pos})
+			return l.toks, nil
+		}
+		c := src[l.pos]
+		next := byte(0)
+		if l.pos+1 < len(src) {
+			next = src[l.pos+1]
+		}
+		switch {
+		case c == '(':
+			emit(tLParen, l.pos+1)
+		case c == ')':
+			emit(tRParen, l.pos+1)
+		case c == '[':
+			emit(tLBracket, l.pos+1)
+		case c == ']':
+			emit(tRBracket, l.pos+1)
+		case c == ',':
+			emit(tComma, l.pos+1)
+		case c == '@':
+			emit(tAt, l.pos+1)
+		case c == ':' && next == ':':
+			emit(tColonColon, l.pos+2)
+		case c == '.' && next == '.':
+			emit(tDotDot, l.pos+2)
+		case c == '.' && (next < '0' || next > '9'):
+			emit(tDot, l.pos+1)
+		case c == '/' && next == '/', c == '!' && next == '=', (c == '<' || c == '>') && next == '=':
+			emit(tOp, l.pos+2)
+		case strings.IndexByte("/|+-=<>", c) >= 0:
+			emit(tOp, l.pos+1)
+		case c == '*':
+			if l.operand() {
+				emit(tOp, l.pos+1)
+			} else {
+				emit(tName, l.pos+1)
+			}
+		case c == '"' || c == '\'':
+			end := strings.IndexByte(src[l.pos+1:], c)
+			if end < 0 {
+				return nil, l.errorf(start, "unterminated string")
+			}
+			l.toks = append(l.toks, token{kind: tLiteral, s: src[l.pos+1 : l.pos+1+end], pos: start})
+			l.pos += end + 2
+		case c >= '0' && c <= '9' || c == '.':
+			i := l.pos
+			for i < len(src) && src[i] >= '0' && src[i] <= '9' {
+				i++
+			}
+			if i < len(src) && src[i] == '.' {
+				i++
+				for i < len(src) && src[i] >= '0' && src[i] <= '9' {
+					i++
+				}
+			}
+			emit(tNumber, i)
+		case c == '$':
+			if !isNameStart(l.peekRune(l.pos + 1)) {
+				return nil, l.errorf(start, "bad variable reference")
+			}
+			end := l.ncname(l.pos + 1)
+			if end+1 < len(src) && src[end] == ':' && isNameStart(l.peekRune(end+1)) {
+				end = l.ncname(end + 1)
+			}
+			emit(tVar, end)
+		case isNameStart(l.peekRune(l.pos)):
+			end := l.ncname(l.pos)
+			if l.operand() {
+				switch name := src[start:end]; name {
+				case "and", "or", "mod", "div":
+					emit(tOp, end)
+					continue
+				default:
+					return nil, l.errorf(start, "expected an operator, found %q", name)
+				}
+			}
+			// A QName or prefix:*, unless the colon starts an axis ::.
+			if end+1 < len(src) && src[end] == ':' && src[end+1] != ':' {
+				switch {
+				case src[end+1] == '*':
+					emit(tName, end+2)
+					continue
+				case isNameStart(l.peekRune(end + 1)):
+					end = l.ncname(end + 1)
+				}
+			}
+			after := l.skipSpace(end)
+			switch name := src[start:end]; {
+			case strings.HasPrefix(src[after:], "::"):
+				if _, ok := axes[name]; !ok {
+					return nil, l.errorf(start, "unknown axis %q", name)
+				}
+				emit(tAxis, end)
+			case after < len(src) && src[after] == '(':
+				switch name {
+				case "comment", "text", "processing-instruction", "node":
+					emit(tNodeType, end)
+				default:
+					emit(tFunc, end)
+				}
+			default:
+				emit(tName, end)
+			}
+		default:
+			return nil, l.errorf(start, "unexpected %q", l.peekRune(l.pos))
+		}
+	}
+}
+
+// Parser.
+
+type parser struct {
+	src  string
+	toks []token
+	i    int
+	ns   map[string]string
+}
+
+// Compile parses an XPath expression. ns binds namespace prefixes to URIs;
+// the empty prefix, if bound, applies to unprefixed element names.
+func Compile(src string, ns map[string]string) (e *Expr, err error) {
+	toks, err := lex(src)
+	if err != nil {
+		return nil, err
+	}
+	p := &parser{src: src, toks: toks, ns: ns}
+	defer func() {
+		if r := recover(); r != nil {
+			xe, ok := r.(*XPathError)
+			if !ok {
+				panic(r)
+			}
+			err = xe
+		}
+	}()
+	root := p.parseExpr()
+	if t := p.peek(); t.kind != tEOF {
+		p.fail(t, "unexpected %q", t.s)
+	}
+	e = &Expr{src: src, root: root}
+	e.plan = makePlan(root)
+	if c, ok := root.(*call); ok && (c.name == "count" || c.name == "sum") {
+		if e.plan = makePlan(c.args[0]); e.plan != nil {
+			e.agg = c.name
+		}
+	}
+	return e, nil
+}
+
+// String returns the source of e.
+func (e *Expr) String() string { return e.src }
+
+func (p *parser) fail(t token, format string, args ...interface{}) {
+	panic(&XPathError{Expr: p.src, Pos: t.pos, Msg: fmt.Sprintf(format, args...)})
+}
+
+func (p *parser) peek() token { return p.toks[p.i] }
+
+func (p *parser) next() token {
+	t := p.toks[p.i]
+	if t.kind != tEOF {
+		p.i++
+	}
+	return t
+}
+
+func (p *parser) isOp(ops ...string) bool {
+	t := p.peek()
+	if t.kind != tOp {
+		return false
+	}
+	for _, op := range ops {
+		if t.s == op {
+			return true
+		}
+	}
+	return false
+}
+
+func (p *parser) expect(k tokKind, what string) token {
+	t := p.next()
+	if t.kind != k {
+		if t.kind == tEOF {
+			p.fail(t, "expected %s at the end", what)
+		}
+		p.fail(t, "expected %s, found %q", what, t.s)
+	}
+	return t
+}
+
+func (p *parser) parseExpr() expr { return p.parseBinary(0) }
+
+// levels lists the binary operators from the loosest binding.
+var levels = [][]string{
+	{"or"},
+	{"and"},
+	{"=", "!="},
+	{"<", "<=", ">", ">="},
+	{"+", "-"},
+	{"*", "div", "mod"},
+}
+
+func (p *parser) parseBinary(level int) expr {
+	if level == len(levels) {
+		return p.parseUnary()
+	}
+	l := p.parseBinary(level + 1)
+	for p.isOp(levels[level]...) {
+		op := p.next().s
+		l = &binary{op: op, l: l, r: p.parseBinary(level + 1)}
+	}
+	return l
+}
+
+func (p *parser) parseUnary() expr {
+	if p.isOp("-") {
+		p.next()
+		return &negate{p.parseUnary()}
+	}
+	l := p.parsePath()
+	for p.isOp("|") {
+		p.next()
+		l = &binary{op: "|", l: l, r: p.parsePath()}
+	}
+	return l
+}
+
+func (p *parser) parsePath() expr {
+	switch p.peek().kind {
+	case tLiteral, tNumber, tVar, tFunc, tLParen:
+		f := p.parseFilter()
+		if !p.isOp("/", "//") {
+			return f
+		}
+		pe := &path{filter: f}
+		p.parseSteps(pe)
+		return pe
+	}
+	pe := &path{}
+	switch {
+	case p.isOp("/"):
+		p.next()
+		pe.abs = true
+		switch p.peek().kind {
+		case tName, tNodeType, tAxis, tAt, tDot, tDotDot:
+			pe.steps = append(pe.steps, p.parseStep())
+		default:
+			return pe
+		}
+	case p.isOp("//"):
+		p.next()
+		pe.abs = true
+		pe.steps = append(pe.steps, descendantOrSelf(), p.parseStep())
+	default:
+		pe.steps = append(pe.steps, p.parseStep())
+	}
+	p.parseSteps(pe)
+	return pe
+}
+
+func descendantOrSelf() *step {
+	return &step{axis: axisDescendantOrSelf, test: nodeTest{kind: testNode}}
+}
+
+// parseSteps parses the rest of a relative location path.
+func (p *parser) parseSteps(pe *path) {
+	for p.isOp("/", "//") {
+		if p.next().s == "//" {
+			pe.steps = append(pe.steps, descendantOrSelf())
+		}
+		pe.steps = append(pe.steps, p.parseStep())
+	}
+}
+
+func (p *parser) parseStep() *step {
+	t := p.next()
+	s := &step{axis: axisChild}
+	switch t.kind {
+	case tDot:
+		return &step{axis: axisSelf, test: nodeTest{kind: testNode}}
+	case tDotDot:
+		return &step{axis: axisParent, test: nodeTest{kind: testNode}}
+	case tAt:
+		s.axis = axisAttribute
+		t = p.next()
+	case tAxis:
+		s.axis = axes[t.s]
+		p.expect(tColonColon, "::")
+		t = p.next()
+	}
+	switch t.kind {
+	case tName:
+		s.test = p.nameTest(t, s.axis)
+	case tNodeType:
+		p.expect(tLParen, "(")
+		switch t.s {
+		case "node":
+			s.test.kind = testNode
+		case "text":
+			s.test.kind = testText
+		case "comment":
+			s.test.kind = testComment
+		case "processing-instruction":
+			s.test.kind = testPI
+			if p.peek().kind == tLiteral {
+				s.test.local = p.next().s
+			}
+		}
+		p.expect(tRParen, ")")
+	case tEOF:
+		p.fail(t, "expected a step at the end")
+	default:
+		p.fail(t, "expected a step, found %q", t.s)
+	}
+	s.preds = p.parsePreds()
+	return s
+}
+
+func (p *parser) nameTest(t token, a axis) nodeTest {
+	if t.s == "*" {
+		return nodeTest{kind: testAny}
+	}
+	test := nodeTest{kind: testName, local: t.s}
+	if i := strings.IndexByte(t.s, ':'); i >= 0 {
+		test.prefix, test.local = t.s[:i], t.s[i+1:]
+		if test.local == "*" {
+			test.kind, test.local = testPrefix, ""
+		}
+	}
+	switch {
+	case test.prefix == "xml":
+		test.space, test.bound = xmlNS, true
+	case test.prefix != "" || a.principal() == ElementNode:
+		test.space, test.bound = p.ns[test.prefix]
+	}
+	return test
+}
+
+func (p *parser) parsePreds() []expr {
+	var preds []expr
+	for p.peek().kind == tLBracket {
+		p.next()
+		preds = append(preds, p.parseExpr())
+		p.expect(tRBracket, "]")
+	}
+	return preds
+}
+
+func (p *parser) parseFilter() expr {
+	var e expr
+	t := p.next()
+	switch t.kind {
+	case tLiteral:
+		e = t.s
+	case tNumber:
+		f, _ := strconv.ParseFloat(t.s, 64)
+		e = f
+	case tVar:
+		p.fail(t, "undefined variable %s", t.s)
+	case tLParen:
+		e = p.parseExpr()
+		p.expect(tRParen, ")")
+	case tFunc:
+		fn := functions[t.s]
+		if fn == nil {
+			p.fail(t, "unknown function %s()", t.s)
+		}
+		c := &call{name: t.s, fn: fn}
+		p.expect(tLParen, "(")
+		if p.peek().kind != tRParen {
+			c.args = append(c.args, p.parseExpr())
+			for p.peek().kind == tComma {
+				p.next()
+				c.args = append(c.args, p.parseExpr())
+			}
+		}
+		p.expect(tRParen, ")")
+		if len(c.args) < fn.min || fn.max >= 0 && len(c.args) > fn.max {
+			p.fail(t, "wrong number of arguments to %s()", t.s)
+		}
+		e = c
+	}
+	if preds := p.parsePreds(); len(preds) > 0 {
+		return &filter{e: e, preds: preds}
+	}
+	return e
+}
@@ -1,125 +1,223 @@
-// json2csv - Convert JSON array of objects to CSV
-// Usage: json2csv [-d delim] [-no-header] [file]
+// json2csv - Convert JSON or JSON Lines to CSV.
+//
+// Usage:
+//
+//	json2csv [OPTIONS] [FILE]
+//
+// Input is a JSON array, JSON Lines, or any sequence of JSON values, read
+// one value at a time. Objects become rows; nested objects and arrays are
+// flattened into dotted column names (address.city, tags.0), which
+// csv2json -nest folds back. Arrays become rows of positional columns and
+// other values a column named "value". null is an empty cell.
+//
+// The header is the union of all keys in order of first appearance. To
+// know it before the first row is written the input is read twice; standard
+// input is spooled to a temporary file for the second pass. With -columns
+// the header is given and the input is streamed in one pass.
+//
+// Options:
+//
+//	-d SEP        Field delimiter (default: ,)
+//	-t            Tab-separated output
+//	-q CHAR       Quote character (default: ")
+//	-e CHAR       Escape character (default: none; quotes are doubled)
+//	-quote MODE   Quote minimal|all|nonnumeric|none fields (default: minimal)
+//	-crlf         End lines with \r\n
+//	-bom          Start the output with a UTF-8 byte order mark (for Excel)
+//	-no-header    Omit header row
+//	-columns LIST Write these columns, in this order, in a single pass
+//	-no-flatten   Write nested values as JSON text instead of flattening
+//	-sep STR      Path separator for flattened names (default: .)
+//
+// Examples:
+//
+//	json2csv users.json > users.csv
+//	kubectl get pods -o json | jq -c '.items[]' | json2csv -columns metadata.name,status.phase
+//	json2csv -bom -crlf -quote all report.jsonl > report.csv
 package main
 
 import (
-	"encoding/csv"
-	"encoding/json"
 	"flag"
 	"fmt"
 	"io"
 	"os"
-	"sort"
+	"strings"
+
+	"goutils/internal/tabular"
 )
 
 var (
-	delim    = flag.String("d", ",", "Field delimiter")
-	noHeader = flag.Bool("no-header", false, "Omit header row")
+	delim     = flag.String("d", ",", "Field delimiter")
+	tabMode   = flag.Bool("t", false, "Tab-separated output")
+	quoteChar = flag.String("q", "\"", "Quote character")
+	escape    = flag.String("e", "", "Escape character")
+	quoteMode = flag.String("quote", "minimal", "Quoting: minimal|all|nonnumeric|none")
+	crlf      = flag.Bool("crlf", false, "End lines with CRLF")
+	bom       = flag.Bool("bom", false, "Write a UTF-8 byte order mark")
+	noHeader  = flag.Bool("no-header", false, "Omit header row")
+	columns   = flag.String("columns", "", "Columns to write, in order")
+	noFlatten = flag.Bool("no-flatten", false, "Keep nested values as JSON text")
+	separator = flag.String("sep", ".", "Path separator for flattened names")
 )
 
+func fatal(format string, args ...interface{}) {
+	fmt.Fprintf(os.Stderr, "json2csv: "+format+"\n", args...)
+	os.Exit(1)
+}
+
+func firstRune(s string) rune {
+	if r := []rune(s); len(r) > 0 {
+		return r[0]
+	}
+	return 0
+}
+
+func newReader(in io.Reader) *tabular.Reader {
+	return tabular.NewReader(in, tabular.Options{
+		Format:    tabular.FormatJSONL,
+		Flatten:   !*noFlatten,
+		Separator: *separator,
+	})
+}
+
+// scanHeader reads all of in and returns the union of its keys.
+func scanHeader(in io.Reader) ([]string, error) {
+	r := newReader(in)
+	for {
+		if _, err := r.Read(); err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, err
+		}
+	}
+	return r.Header()
+}
+
+// spool copies standard input to a temporary file so it can be read twice.
+func spool(in io.Reader) (*os.File, error) {
+	f, err := os.CreateTemp("", "json2csv-*")
+	if err != nil {
+		return nil, err
+	}
+	os.Remove(f.Name())
+	if _, err := io.Copy(f, in); err != nil {
+		f.Close()
+		return nil, err
+	}
+	return f, nil
+}
+
 func main() {
 	flag.Usage = func() {
-		fmt.Fprintln(os.Stderr, "Usage: json2csv [-d delim] [-no-header] [file]")
+		fmt.Fprintln(os.Stderr, "Usage: json2csv [OPTIONS] [FILE]")
 		flag.PrintDefaults()
 	}
 	flag.Parse()
 
-	var r io.Reader = os.Stdin
+	mode, err := tabular.ParseQuoteMode(*quoteMode)
+	if err != nil {
+		fatal("%v", err)
+	}
+	d := tabular.Dialect{
+		Comma:  firstRune(*delim),
+		Quote:  firstRune(*quoteChar),
+		Escape: firstRune(*escape),
+		Mode:   mode,
+		CRLF:   *crlf,
+	}
+	if *tabMode {
+		d.Comma = '\t'
+	}
+
+	var header []string
+	if *columns != "" {
+		for _, c := range strings.Split(*columns, ",") {
+			header = append(header, strings.TrimSpace(c))
+		}
+	}
+
+	var in io.ReadSeeker
 	if flag.NArg() > 0 {
 		f, err := os.Open(flag.Arg(0))
 		if err != nil {
-			fmt.Fprintln(os.Stderr, "json2csv:", err)
-			os.Exit(1)
+			fatal("%v", err)
+		}
+		defer f.Close()
+		in = f
+	} else if header == nil {
+		f, err := spool(os.Stdin)
+		if err != nil {
+			fatal("%v", err)
 		}
 		defer f.Close()
-		r = f
+		in = f
 	}
 
-	data, err := io.ReadAll(r)
-	if err != nil {
-		fmt.Fprintln(os.Stderr, "json2csv:", err)
-		os.Exit(1)
+	var r *tabular.Reader
+	if header == nil {
+		if _, err := in.Seek(0, io.SeekStart); err != nil {
+			fatal("%v", err)
+		}
+		if header, err = scanHeader(in); err != nil {
+			fatal("%v", err)
+		}
+		if _, err := in.Seek(0, io.SeekStart); err != nil {
+			fatal("%v", err)
+		}
+		r = newReader(in)
+	} else if in != nil {
+		r = newReader(in)
+	} else {
+		r = newReader(os.Stdin)
 	}
 
-	var input interface{}
-	if err := json.Unmarshal(data, &input); err != nil {
-		fmt.Fprintln(os.Stderr, "json2csv: invalid JSON:", err)
-		os.Exit(1)
+	index := map[string]int{}
+	for i, h := range header {
+		if _, dup := index[h]; !dup {
+			index[h] = i
+		}
 	}
 
-	w := csv.NewWriter(os.Stdout)
-	if len(*delim) > 0 {
-		w.Comma = []rune(*delim)[0]
+	if *bom {
+		os.Stdout.WriteString("\ufeff")
 	}
-	defer w.Flush()
-
-	switch v := input.(type) {
-	case []interface{}:
-		if len(v) == 0 {
-			return
+	w := tabular.NewCSVWriter(os.Stdout, d)
+	if !*noHeader && len(header) > 0 {
+		w.Write(header)
+	}
+	// pos maps the reader's columns, which grow as keys appear, to ours.
+	var pos []int
+	out := make([]string, len(header))
+	for {
+		row, err := r.Read()
+		if err == io.EOF {
+			break
 		}
-		// If array of objects
-		if obj, ok := v[0].(map[string]interface{}); ok {
-			// Collect all keys
-			keySet := map[string]bool{}
-			for _, item := range v {
-				if m, ok := item.(map[string]interface{}); ok {
-					for k := range m {
-						keySet[k] = true
-					}
-				}
-			}
-			keys := make([]string, 0, len(keySet))
-			for k := range keySet {
-				keys = append(keys, k)
-			}
-			sort.Strings(keys)
-			_ = obj
-
-			if !*noHeader {
-				w.Write(keys)
-			}
-			for _, item := range v {
-				if m, ok := item.(map[string]interface{}); ok {
-					row := make([]string, len(keys))
-					for i, k := range keys {
-						row[i] = fmt.Sprintf("%v", m[k])
-						if m[k] == nil {
-							row[i] = ""
-						}
-					}
-					w.Write(row)
-				}
-			}
-		} else {
-			// Array of arrays or primitives
-			for _, item := range v {
-				switch row := item.(type) {
-				case []interface{}:
-					strs := make([]string, len(row))
-					for i, cell := range row {
-						strs[i] = fmt.Sprintf("%v", cell)
-					}
-					w.Write(strs)
-				default:
-					w.Write([]string{fmt.Sprintf("%v", item)})
+		if err != nil {
+			w.Flush()
+			fatal("%v", err)
+		}
+		if len(row) > len(pos) {
+			names, _ := r.Header()
+			for _, name := range names[len(pos):] {
+				i, ok := index[name]
+				if !ok {
+					i = -1
 				}
+				pos = append(pos, i)
 			}
 		}
-	case map[string]interface{}:
-		if !*noHeader {
-			w.Write([]string{"key", "value"})
-		}
-		keys := make([]string, 0, len(v))
-		for k := range v {
-			keys = append(keys, k)
+		for i := range out {
+			out[i] = ""
 		}
-		sort.Strings(keys)
-		for _, k := range keys {
-			w.Write([]string{k, fmt.Sprintf("%v", v[k])})
+		for i, v := range row {
+			if j := pos[i]; j >= 0 {
+				out[j] = v
+			}
 		}
-	default:
-		fmt.Fprintln(os.Stderr, "json2csv: expected array or object")
-		os.Exit(1)
+		w.Write(out)
+	}
+	if err := w.Flush(); err != nil {
+		fatal("%v", err)
 	}
 }
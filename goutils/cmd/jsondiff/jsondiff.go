@@ -0,0 +1,272 @@
+// jsondiff - Structural diff of two JSON documents.
+//
+// Usage:
+//
+//	jsondiff [OPTIONS] OLD NEW
+//
+// Prints the differences as a tree (the default), as an RFC 6902 JSON
+// Patch that jsonpatch applies to OLD to produce NEW, or as an RFC 7386
+// Merge Patch. Either file may be - for standard input.
+//
+// Arrays are compared element by element, finding inserted and deleted
+// elements. With -array-key, arrays whose elements are all objects with
+// distinct values of that member are matched by it instead, so reordered
+// elements show as moves and changed ones are diffed field by field.
+//
+// Options:
+//
+//	-o FORMAT       tree, patch or merge (default: tree)
+//	-array-key KEY  Match array elements by this member
+//	-q              Print nothing; only set the exit status
+//	--color=WHEN    Colour the tree: auto (default), always or never; auto
+//	                colours a terminal unless NO_COLOR is set
+//	--no-color      Same as --color=never
+//
+// A merge patch cannot set a member to null; jsondiff warns when the merge
+// patch does not reproduce NEW.
+//
+// Exit status is 0 if the documents are equal, 1 if they differ and 2 on
+// error.
+//
+// Examples:
+//
+//	jsondiff old.json new.json
+//	jsondiff -o patch -array-key id v1.json v2.json > upgrade.patch.json
+//	jsondiff -o merge defaults.json config.json
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+
+	"goutils/internal/cli"
+	"goutils/internal/jsonpatch"
+	"goutils/lib/term"
+)
+
+var (
+	outFmt    = flag.String("o", "tree", "output format: tree, patch or merge")
+	arrayKey  = flag.String("array-key", "", "match array elements by this member")
+	quiet     = flag.Bool("q", false, "print nothing")
+	colorMode = term.ColorFlag()
+)
+
+func fatal(format string, args ...interface{}) {
+	fmt.Fprintf(os.Stderr, "jsondiff: "+format+"\n", args...)
+	os.Exit(2)
+}
+
+func load(name string) interface{} {
+	var r io.Reader = os.Stdin
+	if name != "-" {
+		f, err := os.Open(name)
+		if err != nil {
+			fatal("%v", err)
+		}
+		defer f.Close()
+		r = f
+	}
+	dec := json.NewDecoder(bufio.NewReader(r))
+	dec.UseNumber()
+	var v interface{}
+	if err := dec.Decode(&v); err != nil {
+		fatal("%s: %v", name, err)
+	}
+	return v
+}
+
+// tree prints a diff as an outline of the changed parts of the documents.
+type tree struct {
+	w   *bufio.Writer
+	pal term.Profile
+	key string
+}
+
+func (t *tree) line(mark byte, color string, depth int, s string) {
+	text := string(mark) + " " + strings.Repeat("  ", depth) + s
+	if color != "" {
+		text = t.pal.Paint(text, color)
+	}
+	t.w.WriteString(text + "\n")
+}
+
+// value prints v, one line per line of its indented form.
+func (t *tree) value(mark byte, color string, depth int, label string, v interface{}) {
+	b, _ := json.MarshalIndent(v, "", "  ")
+	for i, l := range strings.Split(string(b), "\n") {
+		if i == 0 {
+			l = label + l
+		}
+		t.line(mark, color, depth, l)
+	}
+}
+
+func compact(v interface{}) string {
+	b, _ := json.Marshal(v)
+	return string(b)
+}
+
+func (t *tree) diff(depth int, label string, a, b interface{}) {
+	if jsonpatch.Equal(a, b) {
+		return
+	}
+	switch x := a.(type) {
+	case map[string]interface{}:
+		y, ok := b.(map[string]interface{})
+		if !ok {
+			break
+		}
+		t.line(' ', "", depth, label+"{")
+		keys := map[string]bool{}
+		for k := range x {
+			keys[k] = true
+		}
+		for k := range y {
+			keys[k] = true
+		}
+		sorted := make([]string, 0, len(keys))
+		for k := range keys {
+			sorted = append(sorted, k)
+		}
+		sort.Strings(sorted)
+		for _, k := range sorted {
+			name := strconv.Quote(k) + ": "
+			xv, inA := x[k]
+			yv, inB := y[k]
+			switch {
+			case !inB:
+				t.value('-', term.Red, depth+1, name, xv)
+			case !inA:
+				t.value('+', term.Green, depth+1, name, yv)
+			default:
+				t.diff(depth+1, name, xv, yv)
+			}
+		}
+		t.line(' ', "", depth, "}")
+		return
+	case []interface{}:
+		y, ok := b.([]interface{})
+		if !ok {
+			break
+		}
+		t.line(' ', "", depth, label+"[")
+		t.array(depth+1, x, y)
+		t.line(' ', "", depth, "]")
+		return
+	}
+	t.line('~', term.Yellow, depth, label+compact(a)+" → "+compact(b))
+}
+
+func (t *tree) array(depth int, a, b []interface{}) {
+	byKey := jsonpatch.ByKey(a, b, t.key)
+	name := func(e interface{}, i int) string {
+		if byKey {
+			k := e.(map[string]interface{})[t.key]
+			return "[" + t.key + "=" + strings.Trim(compact(k), `"`) + "]: "
+		}
+		return "[" + strconv.Itoa(i) + "]: "
+	}
+	edits := jsonpatch.ArrayEdits(a, b, t.key)
+	for k := 0; k < len(edits); {
+		e := edits[k]
+		if e.Kind == jsonpatch.Keep {
+			label := name(b[e.B], e.B)
+			if e.A != e.B && byKey {
+				label = strings.TrimSuffix(label, ": ") + fmt.Sprintf(" (moved from %d): ", e.A)
+			}
+			t.diff(depth, label, a[e.A], b[e.B])
+			k++
+			continue
+		}
+		// Pair up a run of deletions and insertions as changes.
+		var dels, ins []int
+		for ; k < len(edits) && edits[k].Kind != jsonpatch.Keep; k++ {
+			if edits[k].Kind == jsonpatch.Delete {
+				dels = append(dels, edits[k].A)
+			} else {
+				ins = append(ins, edits[k].B)
+			}
+		}
+		n := 0
+		if !byKey {
+			for ; n < len(dels) && n < len(ins); n++ {
+				t.diff(depth, name(b[ins[n]], ins[n]), a[dels[n]], b[ins[n]])
+			}
+		}
+		for _, i := range dels[n:] {
+			t.value('-', term.Red, depth, name(a[i], i), a[i])
+		}
+		for _, j := range ins[n:] {
+			t.value('+', term.Green, depth, name(b[j], j), b[j])
+		}
+	}
+}
+
+func main() {
+	flag.Usage = func() {
+		fmt.Fprintln(os.Stderr, "usage: jsondiff [OPTIONS] OLD NEW")
+		flag.PrintDefaults()
+		os.Exit(2)
+	}
+	files := cli.Parse(flag.CommandLine, os.Args[1:])
+	if len(files) != 2 {
+		flag.Usage()
+	}
+	a, b := load(files[0]), load(files[1])
+	if jsonpatch.Equal(a, b) {
+		if *outFmt == "patch" && !*quiet {
+			fmt.Println("[]")
+		} else if *outFmt == "merge" && !*quiet {
+			fmt.Println("{}")
+		}
+		return
+	}
+	if *quiet {
+		os.Exit(1)
+	}
+
+	w := bufio.NewWriter(os.Stdout)
+	switch *outFmt {
+	case "tree":
+		t := &tree{w: w, pal: colorMode.Profile(os.Stdout), key: *arrayKey}
+		t.diff(0, "", a, b)
+	case "patch":
+		ops := jsonpatch.Diff(a, b, jsonpatch.DiffOptions{ArrayKey: *arrayKey})
+		w.WriteString("[")
+		for i, op := range ops {
+			if i > 0 {
+				w.WriteString(",")
+			}
+			line, err := json.Marshal(op)
+			if err != nil {
+				fatal("%v", err)
+			}
+			w.WriteString("\n  ")
+			w.Write(line)
+		}
+		w.WriteString("\n]\n")
+	case "merge":
+		patch, exact := jsonpatch.CreateMergePatch(a, b)
+		var buf bytes.Buffer
+		enc := json.NewEncoder(&buf)
+		enc.SetIndent("", "  ")
+		enc.SetEscapeHTML(false)
+		enc.Encode(patch)
+		w.Write(buf.Bytes())
+		if !exact {
+			fmt.Fprintln(os.Stderr, "jsondiff: warning: a merge patch cannot set members to null; use -o patch")
+		}
+	default:
+		fatal("unknown output format %q (want tree, patch or merge)", *outFmt)
+	}
+	w.Flush()
+	os.Exit(1)
+}
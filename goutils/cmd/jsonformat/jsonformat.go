@@ -1,4 +1,9 @@
 // jsonformat - pretty-print or minify JSON
+//
+// Usage: jsonformat [-m|--minify] [-iINDENT|--indent=INDENT] [-c|--color[=WHEN]] [FILE...]
+//
+// --color=auto (the default) colours the output when it is a terminal and
+// NO_COLOR is unset; -c and a bare --color always colour it.
 package main
 
 import (
@@ -7,24 +12,28 @@ import (
 	"io"
 	"os"
 	"strings"
+
+	"goutils/lib/term"
 )
 
 func main() {
 	minify := false
 	indent := "  "
-	color := false
+	mode, args, err := term.ColorArgs(os.Args[1:])
+	if err != nil { fmt.Fprintln(os.Stderr, "jsonformat:", err); os.Exit(2) }
 	files := []string{}
 
-	for _, arg := range os.Args[1:] {
+	for _, arg := range args {
 		switch {
 		case arg == "-m" || arg == "--minify": minify = true
-		case arg == "-c" || arg == "--color": color = true
+		case arg == "-c": mode = term.ColorAlways
 		case strings.HasPrefix(arg, "--indent="): indent = arg[len("--indent="):]
 		case strings.HasPrefix(arg, "-i"): indent = arg[2:]
 		default: files = append(files, arg)
 		}
 	}
 
+	pal := mode.Profile(os.Stdout)
 	process := func(r io.Reader, name string) {
 		data, err := io.ReadAll(r)
 		if err != nil { fmt.Fprintln(os.Stderr, err); return }
@@ -38,8 +47,8 @@ func main() {
 		} else {
 			out, _ = json.MarshalIndent(v, "", indent)
 		}
-		if color {
-			fmt.Println(colorJSON(string(out)))
+		if pal != term.NoColor {
+			fmt.Println(colorJSON(string(out), pal))
 		} else {
 			os.Stdout.Write(append(out, '\n'))
 		}
@@ -53,14 +62,14 @@ func main() {
 	}
 }
 
-func colorJSON(s string) string {
-	const (
-		reset  = "\033[0m"
-		key    = "\033[34m"   // blue keys
-		str    = "\033[32m"   // green strings
-		num    = "\033[33m"   // yellow numbers
-		bool_  = "\033[35m"   // magenta booleans
-		null_  = "\033[31m"   // red null
+func colorJSON(s string, pal term.Profile) string {
+	var (
+		reset  = pal.ResetSeq()
+		key    = pal.Seq(term.Blue)    // blue keys
+		str    = pal.Seq(term.Green)   // green strings
+		num    = pal.Seq(term.Yellow)  // yellow numbers
+		bool_  = pal.Seq(term.Magenta) // magenta booleans
+		null_  = pal.Seq(term.Red)     // red null
 	)
 	var b strings.Builder
 	inStr := false
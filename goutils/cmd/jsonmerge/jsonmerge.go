@@ -1,66 +1,296 @@
-// jsonmerge - deep merge multiple JSON files/objects
+// jsonmerge - Deep-merge JSON documents.
+//
+// Usage:
+//
+//	jsonmerge [OPTIONS] FILE1 FILE2 [FILE...]
+//
+// Merges the documents left to right; later files override earlier ones.
+// A FILE of - reads standard input.
+//
+// How two values at the same place combine depends on the strategy:
+//
+//	deep       objects merge member by member; anything else is replaced (default)
+//	replace    the later value replaces the earlier one, objects included
+//	append     as deep, but arrays are concatenated
+//	union      as append, but elements already present are not added again
+//	key=FIELD  as deep, but arrays of objects are matched by FIELD: matching
+//	           elements are merged and the rest appended
+//
+// Options:
+//
+//	-s STRATEGY       Strategy for the whole document (default: deep)
+//	-S PATH=STRATEGY  Strategy for the value at a JSON Pointer PATH and below;
+//	                  a * token matches any member or index (repeatable)
+//	-a                Same as -s append
+//	-n                A null member in a later file deletes the member
+//	-p                Pretty-print the output
+//
+// When several -S paths match, the longest wins.
+//
+// Examples:
+//
+//	jsonmerge defaults.json site.json
+//	jsonmerge -a -S /servers=key=name base.json prod.json
+//	jsonmerge -S /plugins=union -S /env/*/args=replace a.json b.json
 package main
 
 import (
+	"bytes"
 	"encoding/json"
+	"flag"
 	"fmt"
 	"io"
 	"os"
+	"strconv"
 	"strings"
+
+	"goutils/internal/jsonpatch"
+)
+
+// strategy says how to combine two values.
+type strategy struct {
+	kind string // deep, replace, append, union or key
+	key  string // member matched by key
+}
+
+func parseStrategy(s string) (strategy, error) {
+	switch s {
+	case "deep", "replace", "append", "union":
+		return strategy{kind: s}, nil
+	}
+	if k, ok := strings.CutPrefix(s, "key="); ok && k != "" {
+		return strategy{kind: "key", key: k}, nil
+	}
+	return strategy{}, fmt.Errorf("unknown strategy %q (want deep, replace, append, union or key=FIELD)", s)
+}
+
+// rule applies a strategy to the values at a pointer pattern.
+type rule struct {
+	toks []string
+	s    strategy
+}
+
+// ruleList collects -S flags.
+type ruleList []rule
+
+func (r *ruleList) String() string { return "" }
+
+func (r *ruleList) Set(v string) error {
+	path, s, ok := strings.Cut(v, "=")
+	if !ok {
+		return fmt.Errorf("want PATH=STRATEGY")
+	}
+	toks, err := jsonpatch.ParsePointer(path)
+	if err != nil {
+		return err
+	}
+	st, err := parseStrategy(s)
+	if err != nil {
+		return err
+	}
+	*r = append(*r, rule{toks, st})
+	return nil
+}
+
+var (
+	defStrategy = flag.String("s", "deep", "merge `STRATEGY`: deep, replace, append, union or key=FIELD")
+	appendArr   = flag.Bool("a", false, "same as -s append")
+	nullDeletes = flag.Bool("n", false, "a null member deletes the member")
+	pretty      = flag.Bool("p", false, "pretty-print the output")
+	rules       ruleList
 )
 
-func deepMerge(base, override interface{}) interface{} {
-	bMap, bOk := base.(map[string]interface{})
-	oMap, oOk := override.(map[string]interface{})
-	if bOk && oOk {
-		result := map[string]interface{}{}
-		for k, v := range bMap { result[k] = v }
-		for k, v := range oMap {
-			if bv, exists := result[k]; exists {
-				result[k] = deepMerge(bv, v)
-			} else { result[k] = v }
+func init() {
+	flag.Var(&rules, "S", "`PATH=STRATEGY` for the value at a JSON Pointer and below (repeatable)")
+	flag.BoolVar(pretty, "pretty", false, "same as -p")
+}
+
+func fatal(format string, args ...interface{}) {
+	fmt.Fprintf(os.Stderr, "jsonmerge: "+format+"\n", args...)
+	os.Exit(1)
+}
+
+// merger holds the strategies in force.
+type merger struct {
+	def   strategy
+	rules ruleList
+}
+
+// strategyAt returns the strategy of the longest rule matching path or one
+// of its ancestors.
+func (m *merger) strategyAt(path []string) strategy {
+	s, best := m.def, -1
+	for _, r := range m.rules {
+		if len(r.toks) > len(path) || len(r.toks) <= best {
+			continue
+		}
+		match := true
+		for i, t := range r.toks {
+			if t != "*" && t != path[i] {
+				match = false
+				break
+			}
+		}
+		if match {
+			s, best = r.s, len(r.toks)
+		}
+	}
+	return s
+}
+
+func child(path []string, tok string) []string {
+	return append(path[:len(path):len(path)], tok)
+}
+
+func (m *merger) merge(path []string, base, override interface{}) interface{} {
+	s := m.strategyAt(path)
+	if s.kind == "replace" {
+		return override
+	}
+	switch b := base.(type) {
+	case map[string]interface{}:
+		o, ok := override.(map[string]interface{})
+		if !ok {
+			break
+		}
+		result := make(map[string]interface{}, len(b)+len(o))
+		for k, v := range b {
+			result[k] = v
+		}
+		for k, v := range o {
+			if v == nil && *nullDeletes {
+				delete(result, k)
+			} else if bv, exists := result[k]; exists {
+				result[k] = m.merge(child(path, k), bv, v)
+			} else {
+				result[k] = v
+			}
 		}
 		return result
+	case []interface{}:
+		o, ok := override.([]interface{})
+		if !ok {
+			break
+		}
+		switch s.kind {
+		case "append":
+			return append(append([]interface{}{}, b...), o...)
+		case "union":
+			result := append([]interface{}{}, b...)
+			for _, e := range o {
+				if !contains(result, e) {
+					result = append(result, e)
+				}
+			}
+			return result
+		case "key":
+			return m.mergeKeyed(path, s.key, b, o)
+		}
 	}
-	// Arrays: override wins by default
 	return override
 }
 
-func usage() {
-	fmt.Fprintln(os.Stderr, `usage: jsonmerge <file1.json> <file2.json> [file3.json...]
-  Deep-merge JSON objects. Later files override earlier values.
-  -a    arrays: append instead of replace
-  -p    pretty print output (default: compact)`)
-	os.Exit(1)
+func contains(a []interface{}, v interface{}) bool {
+	for _, e := range a {
+		if jsonpatch.Equal(e, v) {
+			return true
+		}
+	}
+	return false
+}
+
+// mergeKeyed merges elements of o into those of b with the same key;
+// elements without a match, or without the key, are appended.
+func (m *merger) mergeKeyed(path []string, key string, b, o []interface{}) []interface{} {
+	result := append([]interface{}{}, b...)
+	idx := map[string]int{}
+	for i, e := range result {
+		if k, ok := jsonpatch.KeyOf(e, key); ok {
+			if _, dup := idx[k]; !dup {
+				idx[k] = i
+			}
+		}
+	}
+	for _, e := range o {
+		k, ok := jsonpatch.KeyOf(e, key)
+		if i, found := idx[k]; ok && found {
+			result[i] = m.merge(child(path, strconv.Itoa(i)), result[i], e)
+			continue
+		}
+		if ok {
+			idx[k] = len(result)
+		}
+		result = append(result, e)
+	}
+	return result
+}
+
+func load(name string) interface{} {
+	var r io.Reader = os.Stdin
+	if name != "-" {
+		f, err := os.Open(name)
+		if err != nil {
+			fatal("%v", err)
+		}
+		defer f.Close()
+		r = f
+	}
+	dec := json.NewDecoder(r)
+	dec.UseNumber()
+	var v interface{}
+	if err := dec.Decode(&v); err != nil {
+		fatal("%s: %v", name, err)
+	}
+	return v
 }
 
 func main() {
-	pretty := false
+	flag.Usage = func() {
+		fmt.Fprintln(os.Stderr, "usage: jsonmerge [OPTIONS] FILE1 FILE2 [FILE...]")
+		flag.PrintDefaults()
+		os.Exit(2)
+	}
 	var files []string
-	for _, arg := range os.Args[1:] {
-		switch arg {
-		case "-p", "--pretty": pretty = true
-		default:
-			if strings.HasPrefix(arg, "-") { usage() }
-			files = append(files, arg)
+	args := os.Args[1:]
+	for {
+		flag.CommandLine.Parse(args)
+		args = flag.Args()
+		if len(args) == 0 {
+			break
 		}
+		files = append(files, args[0])
+		args = args[1:]
+	}
+	if len(files) < 1 {
+		flag.Usage()
+	}
+	if *appendArr {
+		*defStrategy = "append"
 	}
-	if len(files) < 1 { usage() }
+	def, err := parseStrategy(*defStrategy)
+	if err != nil {
+		fatal("%v", err)
+	}
+	m := &merger{def: def, rules: rules}
 
 	var result interface{}
-	for _, f := range files {
-		var r io.Reader
-		if f == "-" { r = os.Stdin } else {
-			fh, err := os.Open(f); if err != nil { fmt.Fprintln(os.Stderr, err); os.Exit(1) }
-			defer fh.Close(); r = fh
+	for i, f := range files {
+		v := load(f)
+		if i == 0 {
+			result = v
+		} else {
+			result = m.merge(nil, result, v)
 		}
-		data, _ := io.ReadAll(r)
-		var v interface{}
-		if err := json.Unmarshal(data, &v); err != nil { fmt.Fprintf(os.Stderr, "jsonmerge: %s: %v\n", f, err); os.Exit(1) }
-		if result == nil { result = v } else { result = deepMerge(result, v) }
 	}
 
-	var out []byte
-	if pretty { out, _ = json.MarshalIndent(result, "", "  ") } else { out, _ = json.Marshal(result) }
-	fmt.Println(string(out))
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+	if *pretty {
+		enc.SetIndent("", "  ")
+	}
+	if err := enc.Encode(result); err != nil {
+		fatal("%v", err)
+	}
+	os.Stdout.Write(buf.Bytes())
 }
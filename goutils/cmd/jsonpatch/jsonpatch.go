@@ -0,0 +1,142 @@
+// jsonpatch - Apply a JSON Patch or JSON Merge Patch to a document.
+//
+// Usage:
+//
+//	jsonpatch [OPTIONS] PATCH [FILE]
+//
+// Reads the document from FILE (or standard input) and writes the patched
+// document to standard output. A patch that is a JSON array is an RFC 6902
+// JSON Patch; an object is an RFC 7386 Merge Patch.
+//
+// A JSON Patch is applied atomically: if any operation fails, including a
+// test operation, nothing is written and the failing operation is reported.
+//
+// Options:
+//
+//	-m        Treat PATCH as a merge patch even if it is an array
+//	-o FILE   Write the result to FILE instead of standard output
+//	-i        Edit FILE in place
+//	-p        Pretty-print the result
+//
+// Output files are written to a temporary file and renamed into place, so
+// readers never see a partly written document.
+//
+// Examples:
+//
+//	jsondiff -o patch old.json new.json > change.json
+//	jsonpatch change.json old.json
+//	jsonpatch -i -p overrides.json config.json
+//	echo '[{"op":"test","path":"/version","value":2}]' | jsonpatch - config.json
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+
+	"goutils/internal/cli"
+	"goutils/internal/jsonpatch"
+)
+
+var (
+	merge   = flag.Bool("m", false, "treat PATCH as a merge patch")
+	outFile = flag.String("o", "", "write the result to `FILE`")
+	inPlace = flag.Bool("i", false, "edit FILE in place")
+	pretty  = flag.Bool("p", false, "pretty-print the result")
+)
+
+func fatal(format string, args ...interface{}) {
+	fmt.Fprintf(os.Stderr, "jsonpatch: "+format+"\n", args...)
+	os.Exit(1)
+}
+
+func read(name string) []byte {
+	var data []byte
+	var err error
+	if name == "-" {
+		data, err = io.ReadAll(os.Stdin)
+	} else {
+		data, err = os.ReadFile(name)
+	}
+	if err != nil {
+		fatal("%v", err)
+	}
+	return data
+}
+
+func decode(name string, data []byte) interface{} {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	var v interface{}
+	if err := dec.Decode(&v); err != nil {
+		fatal("%s: %v", name, err)
+	}
+	if dec.More() {
+		fatal("%s: more than one JSON value", name)
+	}
+	return v
+}
+
+func main() {
+	flag.Usage = func() {
+		fmt.Fprintln(os.Stderr, "usage: jsonpatch [OPTIONS] PATCH [FILE]")
+		flag.PrintDefaults()
+		os.Exit(2)
+	}
+	files := cli.Parse(flag.CommandLine, os.Args[1:])
+	if len(files) < 1 || len(files) > 2 {
+		flag.Usage()
+	}
+	docName := "-"
+	if len(files) == 2 {
+		docName = files[1]
+	}
+	if files[0] == "-" && docName == "-" {
+		fatal("PATCH and FILE cannot both be standard input")
+	}
+	if *inPlace {
+		if docName == "-" {
+			fatal("-i needs a FILE")
+		}
+		if *outFile != "" {
+			fatal("-i and -o are mutually exclusive")
+		}
+		*outFile = docName
+	}
+
+	patchData := read(files[0])
+	doc := decode(docName, read(docName))
+
+	var result interface{}
+	if _, isArray := decode(files[0], patchData).([]interface{}); isArray && !*merge {
+		ops, err := jsonpatch.Decode(patchData)
+		if err != nil {
+			fatal("%s: %v", files[0], err)
+		}
+		if result, err = jsonpatch.Apply(doc, ops); err != nil {
+			fatal("%v", err)
+		}
+	} else {
+		result = jsonpatch.MergePatch(doc, decode(files[0], patchData))
+	}
+
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+	if *pretty {
+		enc.SetIndent("", "  ")
+	}
+	if err := enc.Encode(result); err != nil {
+		fatal("%v", err)
+	}
+	if *outFile == "" {
+		os.Stdout.Write(buf.Bytes())
+		return
+	}
+	if err := cli.WriteFile(*outFile, buf.Bytes()); err != nil {
+		fatal("%v", err)
+	}
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"net"
+	"net/url"
+	"regexp"
+	"strings"
+	"time"
+)
+
+var (
+	uuidRe     = regexp.MustCompile(`^[0-9a-fA-F]{8}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{12}$`)
+	emailRe    = regexp.MustCompile(`^[^\s@"]+@[^\s@]+\.[^\s@]+$`)
+	hostnameRe = regexp.MustCompile(`^(?i)[a-z0-9]([a-z0-9-]{0,61}[a-z0-9])?(\.[a-z0-9]([a-z0-9-]{0,61}[a-z0-9])?)*\.?$`)
+	durationRe = regexp.MustCompile(`^P(\d+W|(\d+Y)?(\d+M)?(\d+D)?(T(\d+H)?(\d+M)?(\d+(\.\d+)?S)?)?)$`)
+	pointerRe  = regexp.MustCompile(`^(/([^~/]|~[01])*)*$`)
+	relPtrRe   = regexp.MustCompile(`^(0|[1-9][0-9]*)(#|(/([^~/]|~[01])*)*)$`)
+)
+
+// formats checks string values against the format keyword. Unknown
+// formats always pass, as the specification requires.
+var formats = map[string]func(string) bool{
+	"date-time": isDateTime,
+	"date": func(s string) bool {
+		_, err := time.Parse("2006-01-02", s)
+		return err == nil
+	},
+	"time": func(s string) bool {
+		return isDateTime("1970-01-01T" + s)
+	},
+	"duration":              func(s string) bool { return durationRe.MatchString(s) && !strings.HasSuffix(s, "T") },
+	"email":                 emailRe.MatchString,
+	"idn-email":             emailRe.MatchString,
+	"hostname":              isHostname,
+	"idn-hostname":          isHostname,
+	"ipv4":                  isIPv4,
+	"ipv6":                  func(s string) bool { return strings.Contains(s, ":") && net.ParseIP(s) != nil },
+	"uri":                   isURI,
+	"iri":                   isURI,
+	"uri-reference":         isURIReference,
+	"iri-reference":         isURIReference,
+	"uuid":                  uuidRe.MatchString,
+	"json-pointer":          pointerRe.MatchString,
+	"relative-json-pointer": relPtrRe.MatchString,
+	"regex": func(s string) bool {
+		_, err := regexp.Compile(s)
+		return err == nil
+	},
+}
+
+func isDateTime(s string) bool {
+	// RFC 3339 allows lower-case t and z.
+	_, err := time.Parse(time.RFC3339Nano, strings.ToUpper(s))
+	return err == nil
+}
+
+func isHostname(s string) bool {
+	return len(s) <= 253 && hostnameRe.MatchString(s)
+}
+
+func isIPv4(s string) bool {
+	parts := strings.Split(s, ".")
+	if len(parts) != 4 {
+		return false
+	}
+	for _, p := range parts {
+		// Leading zeros are ambiguous (octal in some parsers).
+		if len(p) > 1 && p[0] == '0' {
+			return false
+		}
+	}
+	ip := net.ParseIP(s)
+	return ip != nil && ip.To4() != nil
+}
+
+func isURI(s string) bool {
+	u, err := url.Parse(s)
+	return err == nil && u.IsAbs() && !strings.ContainsAny(s, " \\")
+}
+
+func isURIReference(s string) bool {
+	_, err := url.Parse(s)
+	return err == nil && !strings.ContainsAny(s, " \\")
+}
@@ -0,0 +1,185 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"sort"
+)
+
+// node accumulates what the samples at one location looked like.
+type node struct {
+	seen  int            // values seen here
+	types map[string]int // by JSON Schema type name
+
+	objects int // how many of the values were objects
+	props   map[string]*node
+	order   []string // property names in order of first appearance
+
+	items *node
+
+	strings  map[string]int // distinct strings, until there are too many
+	overflow bool
+	formats  map[string]bool // formats every string so far satisfied
+}
+
+// hintFormats are tried, in order, as format hints for string columns.
+var hintFormats = []string{"uuid", "date-time", "date", "email", "ipv4", "ipv6", "uri"}
+
+func newNode() *node {
+	return &node{types: map[string]int{}}
+}
+
+func (n *node) add(v interface{}, enumMax int) {
+	n.seen++
+	t := typeName(v)
+	n.types[t]++
+	switch x := v.(type) {
+	case map[string]interface{}:
+		n.objects++
+		if n.props == nil {
+			n.props = map[string]*node{}
+		}
+		// Map order is random; sort so that first appearance is stable.
+		keys := make([]string, 0, len(x))
+		for k := range x {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			p, ok := n.props[k]
+			if !ok {
+				p = newNode()
+				n.props[k] = p
+				n.order = append(n.order, k)
+			}
+			p.add(x[k], enumMax)
+		}
+	case []interface{}:
+		if n.items == nil {
+			n.items = newNode()
+		}
+		for _, e := range x {
+			n.items.add(e, enumMax)
+		}
+	case string:
+		if n.formats == nil {
+			n.formats = map[string]bool{}
+			for _, f := range hintFormats {
+				n.formats[f] = true
+			}
+		}
+		for f := range n.formats {
+			if !formats[f](x) {
+				delete(n.formats, f)
+			}
+		}
+		if n.overflow {
+			break
+		}
+		if n.strings == nil {
+			n.strings = map[string]int{}
+		}
+		n.strings[x]++
+		if len(n.strings) > enumMax {
+			n.overflow, n.strings = true, nil
+		}
+	}
+}
+
+// field is one member of an ordered JSON object.
+type field struct {
+	key string
+	val interface{}
+}
+
+// object marshals its fields in order.
+type object []field
+
+func (o object) MarshalJSON() ([]byte, error) {
+	var buf bytes.Buffer
+	buf.WriteByte('{')
+	for i, f := range o {
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+		k, _ := json.Marshal(f.key)
+		buf.Write(k)
+		buf.WriteByte(':')
+		v, err := json.Marshal(f.val)
+		if err != nil {
+			return nil, err
+		}
+		buf.Write(v)
+	}
+	buf.WriteByte('}')
+	return buf.Bytes(), nil
+}
+
+// schema renders the accumulated samples as a schema. A property is
+// required when every object had it; a string is given an enum when it
+// took few distinct values, each seen at least twice on average.
+func (n *node) schema() object {
+	var types []string
+	for _, t := range []string{"object", "array", "string", "number", "integer", "boolean", "null"} {
+		if n.types[t] > 0 && !(t == "integer" && n.types["number"] > 0) {
+			types = append(types, t)
+		}
+	}
+	var s object
+	switch len(types) {
+	case 0:
+		return s
+	case 1:
+		s = append(s, field{"type", types[0]})
+	default:
+		s = append(s, field{"type", types})
+	}
+
+	if n.props != nil {
+		props := object{}
+		var required []string
+		for _, k := range n.order {
+			p := n.props[k]
+			props = append(props, field{k, p.schema()})
+			if p.seen == n.objects {
+				required = append(required, k)
+			}
+		}
+		s = append(s, field{"properties", props})
+		if len(required) > 0 {
+			s = append(s, field{"required", required})
+		}
+	}
+	if n.items != nil && n.items.seen > 0 {
+		s = append(s, field{"items", n.items.schema()})
+	}
+	if n.types["string"] > 0 {
+		for _, f := range hintFormats {
+			if n.formats[f] {
+				s = append(s, field{"format", f})
+				break
+			}
+		}
+		if !n.overflow && len(n.strings) > 0 && n.types["string"] >= 2*len(n.strings) && len(types) <= 2 &&
+			(len(types) == 1 || n.types["null"] > 0) {
+			values := make([]interface{}, 0, len(n.strings)+1)
+			for v := range n.strings {
+				values = append(values, v)
+			}
+			sort.Slice(values, func(i, j int) bool { return values[i].(string) < values[j].(string) })
+			if n.types["null"] > 0 {
+				values = append(values, nil)
+			}
+			s = append(s, field{"enum", values})
+		}
+	}
+	return s
+}
+
+// draftURI names the draft in $schema.
+func draftURI(draft int) string {
+	if draft == draft7 {
+		return "http://json-schema.org/draft-07/schema#"
+	}
+	return "https://json-schema.org/draft/2020-12/schema"
+}
@@ -1,89 +1,264 @@
-// jsonschema - infer JSON schema from a JSON document or JSONL sample
+// jsonschema - Infer a JSON Schema from samples, or validate JSON against one.
+//
+// Usage:
+//
+//	jsonschema [infer] [OPTIONS] [FILE...]
+//	jsonschema validate [OPTIONS] SCHEMA [FILE...]
+//
+// infer reads JSON documents or JSON Lines (standard input by default) and
+// merges every value into one schema: properties present in every object
+// become required, strings that took only a few distinct values get an
+// enum, and strings that all look like dates, emails, UUIDs and so on get a
+// format.
+//
+// validate checks each input against SCHEMA, draft 2020-12 or draft-07
+// (chosen by its $schema). Every value of a JSON Lines file (.jsonl,
+// .ndjson, or -l) is validated separately and reported by line. Errors are
+// printed as FILE[:LINE]: POINTER: MESSAGE, where POINTER is the JSON
+// Pointer of the offending value. $ref may name $defs/definitions, anchors
+// and other schema files relative to the referring one; a remote $id is
+// mapped to the file of the same name next to SCHEMA.
+//
+// Infer options:
+//
+//	-enum N      Most distinct strings to offer as an enum (default: 10; 0 = none)
+//	-draft D     $schema to declare: 2020-12 or 07 (default: 2020-12)
+//
+// Validate options:
+//
+//	-l           Treat every input as JSON Lines
+//	-o FORMAT    text or json (one result per value, JSON Lines)
+//	-q           Print nothing; only set the exit status
+//	-no-format   Do not check format
+//	-draft D     Draft for schemas without $schema (default: 2020-12)
+//
+// Exit status for validate: 0 all valid, 1 some invalid, 2 error.
+//
+// Examples:
+//
+//	jsonschema events.jsonl > events.schema.json
+//	jsonschema validate events.schema.json events.jsonl
+//	curl -s $URL | jsonschema validate -o json api.schema.json
 package main
 
 import (
+	"bufio"
+	"bytes"
 	"encoding/json"
+	"flag"
 	"fmt"
 	"io"
 	"os"
-	"sort"
+	"path/filepath"
 	"strings"
 )
 
-type schema struct {
-	Type       string             `json:"type"`
-	Properties map[string]*schema `json:"properties,omitempty"`
-	Items      *schema            `json:"items,omitempty"`
-	Required   []string           `json:"required,omitempty"`
-	Examples   []interface{}      `json:"examples,omitempty"`
+func fatal(format string, args ...interface{}) {
+	fmt.Fprintf(os.Stderr, "jsonschema: "+format+"\n", args...)
+	os.Exit(2)
 }
 
-func infer(v interface{}) *schema {
-	switch t := v.(type) {
-	case map[string]interface{}:
-		s := &schema{Type: "object", Properties: map[string]*schema{}}
-		keys := make([]string, 0, len(t)); for k := range t { keys = append(keys, k) }
-		sort.Strings(keys)
-		for _, k := range keys { s.Properties[k] = infer(t[k]); s.Required = append(s.Required, k) }
-		return s
-	case []interface{}:
-		s := &schema{Type: "array"}
-		if len(t) > 0 { s.Items = infer(t[0]) }
-		return s
-	case string: return &schema{Type: "string"}
-	case float64:
-		if t == float64(int64(t)) { return &schema{Type: "integer"} }
-		return &schema{Type: "number"}
-	case bool: return &schema{Type: "boolean"}
-	case nil: return &schema{Type: "null"}
-	}
-	return &schema{Type: "unknown"}
+func parseDraft(s string) int {
+	switch strings.TrimPrefix(strings.TrimPrefix(s, "draft-"), "draft") {
+	case "7", "07", "6", "06", "4", "04":
+		return draft7
+	case "2020-12", "2019-09", "2020", "2019":
+		return draft2020
+	}
+	fatal("unknown draft %q (want 2020-12 or 07)", s)
+	return 0
 }
 
-func mergeSchema(a, b *schema) *schema {
-	if a == nil { return b }
-	if b == nil { return a }
-	if a.Type != b.Type { return &schema{Type: "any"} }
-	if a.Type == "object" && a.Properties != nil && b.Properties != nil {
-		merged := &schema{Type: "object", Properties: map[string]*schema{}}
-		for k, v := range a.Properties { merged.Properties[k] = v }
-		for k, v := range b.Properties {
-			if existing, ok := merged.Properties[k]; ok { merged.Properties[k] = mergeSchema(existing, v) } else { merged.Properties[k] = v }
-		}
-		return merged
+func open(name string) (io.ReadCloser, error) {
+	if name == "-" {
+		return io.NopCloser(os.Stdin), nil
 	}
-	return a
+	return os.Open(name)
 }
 
 func main() {
-	var r io.Reader = os.Stdin
-	if len(os.Args) > 1 {
-		f, err := os.Open(os.Args[1]); if err != nil { fmt.Fprintln(os.Stderr, err); os.Exit(1) }
-		defer f.Close(); r = f
+	args := os.Args[1:]
+	if len(args) > 0 && args[0] == "validate" {
+		validateMain(args[1:])
+		return
 	}
+	if len(args) > 0 && args[0] == "infer" {
+		args = args[1:]
+	}
+	inferMain(args)
+}
 
-	data, _ := io.ReadAll(r)
-	content := strings.TrimSpace(string(data))
+func inferMain(args []string) {
+	fs := flag.NewFlagSet("jsonschema infer", flag.ExitOnError)
+	enumMax := fs.Int("enum", 10, "most distinct strings to offer as an enum")
+	draft := fs.String("draft", "2020-12", "$schema to declare")
+	fs.Parse(args)
+	d := parseDraft(*draft)
 
-	var result *schema
-	if strings.HasPrefix(content, "{") || strings.HasPrefix(content, "[") {
-		var v interface{}
-		if err := json.Unmarshal(data, &v); err != nil { fmt.Fprintln(os.Stderr, err); os.Exit(1) }
-		result = infer(v)
-	} else {
-		// JSONL
-		for _, line := range strings.Split(content, "\n") {
-			line = strings.TrimSpace(line); if line == "" { continue }
+	files := fs.Args()
+	if len(files) == 0 {
+		files = []string{"-"}
+	}
+	root := newNode()
+	for _, name := range files {
+		f, err := open(name)
+		if err != nil {
+			fatal("%v", err)
+		}
+		dec := json.NewDecoder(bufio.NewReader(f))
+		dec.UseNumber()
+		for n := 1; ; n++ {
 			var v interface{}
-			if err := json.Unmarshal([]byte(line), &v); err != nil { continue }
-			result = mergeSchema(result, infer(v))
+			if err := dec.Decode(&v); err == io.EOF {
+				break
+			} else if err != nil {
+				fatal("%s: value %d: %v", name, n, err)
+			}
+			root.add(v, *enumMax)
+		}
+		f.Close()
+	}
+
+	out := append(object{{"$schema", draftURI(d)}}, root.schema()...)
+	b, _ := json.MarshalIndent(out, "", "  ")
+	fmt.Println(string(b))
+}
+
+// reporter prints validation results.
+type reporter struct {
+	format  string
+	quiet   bool
+	w       *bufio.Writer
+	total   int
+	invalid int
+}
+
+func (r *reporter) report(source string, errs []Error) {
+	r.total++
+	if len(errs) > 0 {
+		r.invalid++
+	}
+	if r.quiet {
+		return
+	}
+	if r.format == "json" {
+		if errs == nil {
+			errs = []Error{}
+		}
+		b, _ := json.Marshal(struct {
+			Source string  `json:"source"`
+			Valid  bool    `json:"valid"`
+			Errors []Error `json:"errors"`
+		}{source, len(errs) == 0, errs})
+		r.w.Write(b)
+		r.w.WriteByte('\n')
+		return
+	}
+	for _, e := range errs {
+		loc := e.InstanceLocation
+		if loc == "" {
+			loc = "(root)"
 		}
+		fmt.Fprintf(r.w, "%s: %s: %s\n", source, loc, e.Message)
+	}
+}
+
+func validateMain(args []string) {
+	fs := flag.NewFlagSet("jsonschema validate", flag.ExitOnError)
+	lines := fs.Bool("l", false, "treat inputs as JSON Lines")
+	format := fs.String("o", "text", "output format: text or json")
+	quiet := fs.Bool("q", false, "print nothing")
+	noFormat := fs.Bool("no-format", false, "do not check format")
+	draft := fs.String("draft", "2020-12", "draft for schemas without $schema")
+	fs.Parse(args)
+	if fs.NArg() < 1 {
+		fmt.Fprintln(os.Stderr, "usage: jsonschema validate [OPTIONS] SCHEMA [FILE...]")
+		fs.PrintDefaults()
+		os.Exit(2)
+	}
+	if *format != "text" && *format != "json" {
+		fatal("unknown output format %q (want text or json)", *format)
+	}
+
+	c := newCompiler(parseDraft(*draft))
+	c.dir = filepath.Dir(fs.Arg(0))
+	schema, err := c.load(fileURI(fs.Arg(0)))
+	if err == nil {
+		err = c.resolve()
+	}
+	if err != nil {
+		fatal("%s: %v", fs.Arg(0), err)
 	}
 
-	// Add $schema
-	out := map[string]interface{}{"$schema": "http://json-schema.org/draft-07/schema#"}
-	b, _ := json.Marshal(result)
-	var s map[string]interface{}; json.Unmarshal(b, &s)
-	for k, v := range s { out[k] = v }
-	b, _ = json.MarshalIndent(out, "", "  "); fmt.Println(string(b))
+	files := fs.Args()[1:]
+	if len(files) == 0 {
+		files = []string{"-"}
+	}
+	rep := &reporter{format: *format, quiet: *quiet, w: bufio.NewWriter(os.Stdout)}
+	v := &validator{formats: !*noFormat}
+	check := func(source string, inst interface{}) {
+		v.errs = v.errs[:0]
+		v.validate(schema, inst, "", "")
+		rep.report(source, v.errs)
+	}
+	for _, name := range files {
+		f, err := open(name)
+		if err != nil {
+			fatal("%v", err)
+		}
+		display := name
+		if name == "-" {
+			display = "<stdin>"
+		}
+		ext := strings.ToLower(filepath.Ext(name))
+		if *lines || ext == ".jsonl" || ext == ".ndjson" {
+			br := bufio.NewReaderSize(f, 64*1024)
+			for n := 1; ; n++ {
+				line, err := br.ReadBytes('\n')
+				if len(bytes.TrimSpace(line)) > 0 {
+					source := fmt.Sprintf("%s:%d", display, n)
+					if inst, perr := decodeJSON(line); perr != nil {
+						rep.report(source, []Error{{Message: "invalid JSON: " + perr.Error()}})
+					} else {
+						check(source, inst)
+					}
+				}
+				if err == io.EOF {
+					break
+				}
+				if err != nil {
+					fatal("%s: %v", name, err)
+				}
+			}
+		} else {
+			dec := json.NewDecoder(bufio.NewReader(f))
+			dec.UseNumber()
+			var values []interface{}
+			for {
+				var inst interface{}
+				if err := dec.Decode(&inst); err == io.EOF {
+					break
+				} else if err != nil {
+					rep.report(display, []Error{{Message: "invalid JSON: " + err.Error()}})
+					break
+				}
+				values = append(values, inst)
+			}
+			for i, inst := range values {
+				source := display
+				if len(values) > 1 {
+					source = fmt.Sprintf("%s[%d]", display, i+1)
+				}
+				check(source, inst)
+			}
+		}
+		f.Close()
+	}
+	rep.w.Flush()
+	if rep.invalid > 0 {
+		if !*quiet && *format == "text" && rep.total > 1 {
+			fmt.Fprintf(os.Stderr, "jsonschema: %d of %d values invalid\n", rep.invalid, rep.total)
+		}
+		os.Exit(1)
+	}
 }
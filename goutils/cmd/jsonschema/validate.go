@@ -0,0 +1,976 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// Supported drafts. Drafts 04 and 06 are read as draft-07; 2019-09 as
+// 2020-12.
+const (
+	draft7    = 7
+	draft2020 = 2020
+)
+
+func draftOf(v interface{}, def int) int {
+	m, ok := v.(map[string]interface{})
+	if !ok {
+		return def
+	}
+	s, _ := m["$schema"].(string)
+	switch {
+	case strings.Contains(s, "draft-07"), strings.Contains(s, "draft-06"), strings.Contains(s, "draft-04"):
+		return draft7
+	case strings.Contains(s, "2020-12"), strings.Contains(s, "2019-09"):
+		return draft2020
+	}
+	return def
+}
+
+// number is a numeric keyword value, kept exactly.
+type number struct {
+	r    *big.Rat
+	text string
+}
+
+type patternSchema struct {
+	re     *regexp.Regexp
+	text   string
+	schema *Schema
+}
+
+// Schema is a compiled schema. Unset integer limits are -1.
+type Schema struct {
+	always *bool // a boolean schema
+
+	ref       string // absolute URI of $ref, resolved after compiling
+	refTarget *Schema
+
+	types    []string
+	enum     []interface{}
+	hasConst bool
+	constVal interface{}
+
+	minimum, maximum, exclMin, exclMax, multipleOf *number
+
+	minLength, maxLength int
+	pattern              *regexp.Regexp
+	format               string
+
+	minItems, maxItems       int
+	minContains, maxContains int
+	uniqueItems              bool
+	prefixItems              []*Schema
+	items                    *Schema
+	itemsKw                  string // "items" or draft-07's "additionalItems"
+	contains                 *Schema
+	unevalItems              *Schema
+
+	minProps, maxProps int
+	required           []string
+	properties         map[string]*Schema
+	patternProps       []patternSchema
+	additionalProps    *Schema
+	propertyNames      *Schema
+	depRequired        map[string][]string
+	depSchemas         map[string]*Schema
+	depReqKw, depSchKw string
+	unevalProps        *Schema
+
+	allOf, anyOf, oneOf []*Schema
+	not, ifs, then, els *Schema
+}
+
+// compiler turns schema documents into Schemas, loading files that $ref
+// points at.
+type compiler struct {
+	draft  int
+	dir    string                 // where to look for schemas named by a remote URI
+	docs   map[string]interface{} // raw resources by URI without fragment
+	drafts map[string]int
+	index  map[string]*Schema // by "URI#pointer" and "URI#anchor"
+	refs   []*Schema
+}
+
+func newCompiler(draft int) *compiler {
+	return &compiler{
+		draft:  draft,
+		docs:   map[string]interface{}{},
+		drafts: map[string]int{},
+		index:  map[string]*Schema{},
+	}
+}
+
+// fileURI returns the file: URI of a path.
+func fileURI(path string) string {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		abs = path
+	}
+	return (&url.URL{Scheme: "file", Path: filepath.ToSlash(abs)}).String()
+}
+
+// splitRef resolves ref against base and splits off the fragment.
+func splitRef(base, ref string) (string, string, error) {
+	b, err := url.Parse(base)
+	if err != nil {
+		return "", "", err
+	}
+	r, err := url.Parse(ref)
+	if err != nil {
+		return "", "", fmt.Errorf("bad reference %q: %w", ref, err)
+	}
+	u := b.ResolveReference(r)
+	frag := u.Fragment
+	u.Fragment, u.RawFragment = "", ""
+	return u.String(), frag, nil
+}
+
+func decodeJSON(data []byte) (interface{}, error) {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	var v interface{}
+	if err := dec.Decode(&v); err != nil {
+		return nil, err
+	}
+	return v, nil
+}
+
+// load reads and compiles the schema document at uri.
+func (c *compiler) load(uri string) (*Schema, error) {
+	u, err := url.Parse(uri)
+	if err != nil {
+		return nil, err
+	}
+	path := filepath.FromSlash(u.Path)
+	if u.Scheme != "file" {
+		// There is no fetching; try a local copy of the same name.
+		path = filepath.Join(c.dir, filepath.Base(u.Path))
+		if _, err := os.Stat(path); u.Path == "" || err != nil {
+			return nil, fmt.Errorf("cannot load %s: only local files can be referenced", uri)
+		}
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	v, err := decodeJSON(data)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	c.docs[uri] = v
+	c.drafts[uri] = draftOf(v, c.draft)
+	return c.compile(v, uri, []string{uri + "#"}, c.drafts[uri])
+}
+
+// resolve links every $ref to its target, loading referenced files as
+// needed.
+func (c *compiler) resolve() error {
+	for i := 0; i < len(c.refs); i++ {
+		s := c.refs[i]
+		t, err := c.lookup(s.ref)
+		if err != nil {
+			return err
+		}
+		s.refTarget = t
+	}
+	return nil
+}
+
+func (c *compiler) lookup(ref string) (*Schema, error) {
+	uri, frag, _ := strings.Cut(ref, "#")
+	key := ref
+	if s, ok := c.index[key]; ok {
+		return s, nil
+	}
+	if _, ok := c.docs[uri]; !ok {
+		if _, err := c.load(uri); err != nil {
+			return nil, fmt.Errorf("$ref %s: %w", ref, err)
+		}
+		if s, ok := c.index[key]; ok {
+			return s, nil
+		}
+	}
+	if frag != "" && !strings.HasPrefix(frag, "/") {
+		return nil, fmt.Errorf("$ref %s: no such anchor", ref)
+	}
+	// A pointer to somewhere that is not a known subschema location.
+	v := c.docs[uri]
+	for _, tok := range strings.Split(frag, "/")[1:] {
+		tok = strings.ReplaceAll(strings.ReplaceAll(tok, "~1", "/"), "~0", "~")
+		switch t := v.(type) {
+		case map[string]interface{}:
+			v = t[tok]
+		case []interface{}:
+			i, err := strconv.Atoi(tok)
+			if err != nil || i < 0 || i >= len(t) {
+				return nil, fmt.Errorf("$ref %s: no such location", ref)
+			}
+			v = t[i]
+		default:
+			v = nil
+		}
+		if v == nil {
+			return nil, fmt.Errorf("$ref %s: no such location", ref)
+		}
+	}
+	draft := c.drafts[uri]
+	if draft == 0 {
+		draft = c.draft
+	}
+	return c.compile(v, uri, []string{key}, draft)
+}
+
+func escapePointer(s string) string {
+	return strings.ReplaceAll(strings.ReplaceAll(s, "~", "~0"), "/", "~1")
+}
+
+func toNumber(v interface{}) (*number, bool) {
+	n, ok := v.(json.Number)
+	if !ok {
+		return nil, false
+	}
+	r, ok := new(big.Rat).SetString(string(n))
+	if !ok {
+		return nil, false
+	}
+	return &number{r, string(n)}, true
+}
+
+func toInt(v interface{}) (int, bool) {
+	n, ok := toNumber(v)
+	if !ok || !n.r.IsInt() || !n.r.Num().IsInt64() {
+		return 0, false
+	}
+	return int(n.r.Num().Int64()), true
+}
+
+// compile compiles the schema v found at each of locs ("URI#pointer"
+// keys by which $ref may reach it), with base as the resolution scope.
+func (c *compiler) compile(v interface{}, base string, locs []string, draft int) (*Schema, error) {
+	s := &Schema{minLength: -1, maxLength: -1, minItems: -1, maxItems: -1,
+		minContains: -1, maxContains: -1, minProps: -1, maxProps: -1}
+	where := locs[0]
+	switch t := v.(type) {
+	case bool:
+		s.always = &t
+		c.register(locs, s)
+		return s, nil
+	case map[string]interface{}:
+	default:
+		return nil, fmt.Errorf("%s: a schema must be an object or a boolean", where)
+	}
+	m := v.(map[string]interface{})
+	if _, ok := m["$schema"]; ok {
+		draft = draftOf(m, draft)
+	}
+	if id, ok := m["$id"].(string); ok {
+		if draft == draft7 && strings.HasPrefix(id, "#") {
+			c.index[strings.SplitN(base, "#", 2)[0]+id] = s
+		} else {
+			uri, _, err := splitRef(base, id)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %w", where, err)
+			}
+			base = uri
+			if _, ok := c.docs[uri]; !ok {
+				c.docs[uri], c.drafts[uri] = m, draft
+			}
+			locs = append(locs, uri+"#")
+		}
+	}
+	c.register(locs, s)
+	for _, kw := range []string{"$anchor", "$dynamicAnchor"} {
+		if a, ok := m[kw].(string); ok {
+			c.index[base+"#"+a] = s
+		}
+	}
+
+	for _, kw := range []string{"$ref", "$dynamicRef", "$recursiveRef"} {
+		ref, ok := m[kw].(string)
+		if !ok {
+			continue
+		}
+		uri, frag, err := splitRef(base, ref)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", where, err)
+		}
+		s.ref = uri + "#" + frag
+		c.refs = append(c.refs, s)
+		if draft == draft7 {
+			// Draft-07 ignores the keywords beside $ref.
+			return s, nil
+		}
+		break
+	}
+
+	var err error
+	sub := func(val interface{}, path ...string) *Schema {
+		if err != nil {
+			return nil
+		}
+		child := make([]string, len(locs))
+		for i, l := range locs {
+			child[i] = l
+			for _, p := range path {
+				child[i] += "/" + escapePointer(p)
+			}
+		}
+		var cs *Schema
+		cs, err = c.compile(val, base, child, draft)
+		return cs
+	}
+	subList := func(kw string) []*Schema {
+		list, ok := m[kw].([]interface{})
+		if !ok {
+			return nil
+		}
+		out := make([]*Schema, len(list))
+		for i, v := range list {
+			out[i] = sub(v, kw, strconv.Itoa(i))
+		}
+		return out
+	}
+	subMap := func(kw string) map[string]*Schema {
+		obj, ok := m[kw].(map[string]interface{})
+		if !ok {
+			return nil
+		}
+		out := make(map[string]*Schema, len(obj))
+		for k, v := range obj {
+			out[k] = sub(v, kw, k)
+		}
+		return out
+	}
+	numKw := func(kw string) *number {
+		n, _ := toNumber(m[kw])
+		return n
+	}
+	intKw := func(kw string) int {
+		if n, ok := toInt(m[kw]); ok {
+			return n
+		}
+		return -1
+	}
+
+	switch t := m["type"].(type) {
+	case string:
+		s.types = []string{t}
+	case []interface{}:
+		for _, x := range t {
+			if name, ok := x.(string); ok {
+				s.types = append(s.types, name)
+			}
+		}
+	}
+	if e, ok := m["enum"].([]interface{}); ok {
+		s.enum = e
+	}
+	if cv, ok := m["const"]; ok {
+		s.hasConst, s.constVal = true, cv
+	}
+
+	s.minimum, s.maximum = numKw("minimum"), numKw("maximum")
+	s.exclMin, s.exclMax = numKw("exclusiveMinimum"), numKw("exclusiveMaximum")
+	// Draft-04 spelled exclusive bounds as booleans.
+	if b, _ := m["exclusiveMinimum"].(bool); b {
+		s.exclMin, s.minimum = s.minimum, nil
+	}
+	if b, _ := m["exclusiveMaximum"].(bool); b {
+		s.exclMax, s.maximum = s.maximum, nil
+	}
+	s.multipleOf = numKw("multipleOf")
+
+	s.minLength, s.maxLength = intKw("minLength"), intKw("maxLength")
+	if p, ok := m["pattern"].(string); ok {
+		if s.pattern, err = regexp.Compile(p); err != nil {
+			return nil, fmt.Errorf("%s: pattern %q: %w", where, p, err)
+		}
+	}
+	s.format, _ = m["format"].(string)
+
+	s.minItems, s.maxItems = intKw("minItems"), intKw("maxItems")
+	s.minContains, s.maxContains = intKw("minContains"), intKw("maxContains")
+	s.uniqueItems, _ = m["uniqueItems"].(bool)
+	s.prefixItems = subList("prefixItems")
+	s.itemsKw = "items"
+	switch it := m["items"].(type) {
+	case []interface{}:
+		// The tuple form of draft-07.
+		s.prefixItems = subList("items")
+		if ai, ok := m["additionalItems"]; ok {
+			s.items, s.itemsKw = sub(ai, "additionalItems"), "additionalItems"
+		}
+	case nil:
+	default:
+		s.items = sub(it, "items")
+	}
+	if ct, ok := m["contains"]; ok {
+		s.contains = sub(ct, "contains")
+	}
+	if ui, ok := m["unevaluatedItems"]; ok {
+		s.unevalItems = sub(ui, "unevaluatedItems")
+	}
+
+	s.minProps, s.maxProps = intKw("minProperties"), intKw("maxProperties")
+	if req, ok := m["required"].([]interface{}); ok {
+		for _, r := range req {
+			if name, ok := r.(string); ok {
+				s.required = append(s.required, name)
+			}
+		}
+	}
+	s.properties = subMap("properties")
+	if pp, ok := m["patternProperties"].(map[string]interface{}); ok {
+		keys := make([]string, 0, len(pp))
+		for k := range pp {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			re, rerr := regexp.Compile(k)
+			if rerr != nil {
+				return nil, fmt.Errorf("%s: patternProperties %q: %w", where, k, rerr)
+			}
+			s.patternProps = append(s.patternProps, patternSchema{re, k, sub(pp[k], "patternProperties", k)})
+		}
+	}
+	if ap, ok := m["additionalProperties"]; ok {
+		s.additionalProps = sub(ap, "additionalProperties")
+	}
+	if pn, ok := m["propertyNames"]; ok {
+		s.propertyNames = sub(pn, "propertyNames")
+	}
+	s.depReqKw, s.depSchKw = "dependentRequired", "dependentSchemas"
+	if dr, ok := m["dependentRequired"].(map[string]interface{}); ok {
+		s.depRequired = requiredMap(dr)
+	}
+	s.depSchemas = subMap("dependentSchemas")
+	if deps, ok := m["dependencies"].(map[string]interface{}); ok {
+		s.depReqKw, s.depSchKw = "dependencies", "dependencies"
+		s.depRequired, s.depSchemas = map[string][]string{}, map[string]*Schema{}
+		for k, v := range deps {
+			if list, ok := v.([]interface{}); ok {
+				s.depRequired[k] = requiredMap(map[string]interface{}{k: list})[k]
+			} else {
+				s.depSchemas[k] = sub(v, "dependencies", k)
+			}
+		}
+	}
+	if up, ok := m["unevaluatedProperties"]; ok {
+		s.unevalProps = sub(up, "unevaluatedProperties")
+	}
+
+	s.allOf, s.anyOf, s.oneOf = subList("allOf"), subList("anyOf"), subList("oneOf")
+	for kw, dst := range map[string]**Schema{"not": &s.not, "if": &s.ifs, "then": &s.then, "else": &s.els} {
+		if x, ok := m[kw]; ok {
+			*dst = sub(x, kw)
+		}
+	}
+
+	// Walk the definitions too, so $ref can find them by pointer and
+	// anchors inside them are registered.
+	for _, kw := range []string{"$defs", "definitions"} {
+		subMap(kw)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+func (c *compiler) register(locs []string, s *Schema) {
+	for _, l := range locs {
+		if _, ok := c.index[l]; !ok {
+			c.index[l] = s
+		}
+	}
+}
+
+func requiredMap(m map[string]interface{}) map[string][]string {
+	out := map[string][]string{}
+	for k, v := range m {
+		list, _ := v.([]interface{})
+		names := []string{}
+		for _, x := range list {
+			if name, ok := x.(string); ok {
+				names = append(names, name)
+			}
+		}
+		out[k] = names
+	}
+	return out
+}
+
+// Error is one validation failure, in the "basic" output format of the
+// specification.
+type Error struct {
+	InstanceLocation string `json:"instanceLocation"`
+	KeywordLocation  string `json:"keywordLocation"`
+	Message          string `json:"error"`
+}
+
+// evaluated records which properties and items a schema looked at, for
+// unevaluatedProperties and unevaluatedItems.
+type evaluated struct {
+	props map[string]bool
+	items int          // items before this index were evaluated
+	all   bool         // every item was evaluated
+	idx   map[int]bool // items matched by contains
+}
+
+func (e *evaluated) prop(k string) {
+	if e.props == nil {
+		e.props = map[string]bool{}
+	}
+	e.props[k] = true
+}
+
+func (e *evaluated) merge(o *evaluated) {
+	for k := range o.props {
+		e.prop(k)
+	}
+	if o.items > e.items {
+		e.items = o.items
+	}
+	e.all = e.all || o.all
+	for i := range o.idx {
+		if e.idx == nil {
+			e.idx = map[int]bool{}
+		}
+		e.idx[i] = true
+	}
+}
+
+type validator struct {
+	formats bool
+	errs    []Error
+}
+
+// try validates without reporting, returning the errors it would have
+// reported.
+func (v *validator) try(s *Schema, inst interface{}, ip, kp string) (bool, *evaluated, []Error) {
+	mark := len(v.errs)
+	ok, ev := v.validate(s, inst, ip, kp)
+	errs := append([]Error(nil), v.errs[mark:]...)
+	v.errs = v.errs[:mark]
+	return ok, ev, errs
+}
+
+// validate checks inst, found at instance pointer ip, against s, reached by
+// keyword path kp.
+func (v *validator) validate(s *Schema, inst interface{}, ip, kp string) (bool, *evaluated) {
+	ev := &evaluated{}
+	if s.always != nil {
+		if !*s.always {
+			v.errs = append(v.errs, Error{ip, kp, "no value is allowed here"})
+		}
+		return *s.always, ev
+	}
+	ok := true
+	fail := func(kw, format string, args ...interface{}) {
+		v.errs = append(v.errs, Error{ip, kp + "/" + kw, fmt.Sprintf(format, args...)})
+		ok = false
+	}
+	check := func(sub *Schema, x interface{}, xip, kw string) (bool, *evaluated) {
+		good, e := v.validate(sub, x, xip, kp+"/"+kw)
+		if !good {
+			ok = false
+		}
+		return good, e
+	}
+	apply := func(sub *Schema, kw string) {
+		if good, e := check(sub, inst, ip, kw); good {
+			ev.merge(e)
+		}
+	}
+
+	if s.refTarget != nil {
+		apply(s.refTarget, "$ref")
+	}
+	if s.types != nil && !typeMatches(s.types, inst) {
+		fail("type", "expected %s, got %s", strings.Join(s.types, " or "), typeName(inst))
+	}
+	if s.enum != nil {
+		found := false
+		for _, e := range s.enum {
+			if equal(inst, e) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			fail("enum", "must be one of %s", listValues(s.enum))
+		}
+	}
+	if s.hasConst && !equal(inst, s.constVal) {
+		fail("const", "must be %s", jsonText(s.constVal))
+	}
+
+	switch x := inst.(type) {
+	case json.Number:
+		n, _ := toNumber(x)
+		if n == nil {
+			break
+		}
+		if s.minimum != nil && n.r.Cmp(s.minimum.r) < 0 {
+			fail("minimum", "must be >= %s", s.minimum.text)
+		}
+		if s.maximum != nil && n.r.Cmp(s.maximum.r) > 0 {
+			fail("maximum", "must be <= %s", s.maximum.text)
+		}
+		if s.exclMin != nil && n.r.Cmp(s.exclMin.r) <= 0 {
+			fail("exclusiveMinimum", "must be > %s", s.exclMin.text)
+		}
+		if s.exclMax != nil && n.r.Cmp(s.exclMax.r) >= 0 {
+			fail("exclusiveMaximum", "must be < %s", s.exclMax.text)
+		}
+		if s.multipleOf != nil && s.multipleOf.r.Sign() > 0 && !new(big.Rat).Quo(n.r, s.multipleOf.r).IsInt() {
+			fail("multipleOf", "must be a multiple of %s", s.multipleOf.text)
+		}
+
+	case string:
+		n := utf8.RuneCountInString(x)
+		if s.minLength >= 0 && n < s.minLength {
+			fail("minLength", "must be at least %d characters long, is %d", s.minLength, n)
+		}
+		if s.maxLength >= 0 && n > s.maxLength {
+			fail("maxLength", "must be at most %d characters long, is %d", s.maxLength, n)
+		}
+		if s.pattern != nil && !s.pattern.MatchString(x) {
+			fail("pattern", "does not match pattern %s", s.pattern.String())
+		}
+		if v.formats && s.format != "" {
+			if f, known := formats[s.format]; known && !f(x) {
+				fail("format", "is not a valid %s", s.format)
+			}
+		}
+
+	case []interface{}:
+		if s.minItems >= 0 && len(x) < s.minItems {
+			fail("minItems", "must have at least %d items, has %d", s.minItems, len(x))
+		}
+		if s.maxItems >= 0 && len(x) > s.maxItems {
+			fail("maxItems", "must have at most %d items, has %d", s.maxItems, len(x))
+		}
+		if s.uniqueItems {
+			seen := map[string]int{}
+			for i, item := range x {
+				k := canonical(item)
+				if j, dup := seen[k]; dup {
+					fail("uniqueItems", "items %d and %d are equal", j, i)
+					break
+				}
+				seen[k] = i
+			}
+		}
+		for i, sub := range s.prefixItems {
+			if i >= len(x) {
+				break
+			}
+			check(sub, x[i], ip+"/"+strconv.Itoa(i), "prefixItems/"+strconv.Itoa(i))
+			ev.items = i + 1
+		}
+		if s.items != nil {
+			for i := len(s.prefixItems); i < len(x); i++ {
+				check(s.items, x[i], ip+"/"+strconv.Itoa(i), s.itemsKw)
+			}
+			ev.all = true
+		}
+		if s.contains != nil {
+			matched := 0
+			for i, item := range x {
+				if good, _, _ := v.try(s.contains, item, ip+"/"+strconv.Itoa(i), kp+"/contains"); good {
+					matched++
+					if ev.idx == nil {
+						ev.idx = map[int]bool{}
+					}
+					ev.idx[i] = true
+				}
+			}
+			min := 1
+			if s.minContains >= 0 {
+				min = s.minContains
+			}
+			if matched < min {
+				fail("contains", "must contain at least %d matching item(s), has %d", min, matched)
+			}
+			if s.maxContains >= 0 && matched > s.maxContains {
+				fail("maxContains", "must contain at most %d matching item(s), has %d", s.maxContains, matched)
+			}
+		}
+
+	case map[string]interface{}:
+		for _, r := range s.required {
+			if _, has := x[r]; !has {
+				fail("required", "missing required property %q", r)
+			}
+		}
+		if s.minProps >= 0 && len(x) < s.minProps {
+			fail("minProperties", "must have at least %d properties, has %d", s.minProps, len(x))
+		}
+		if s.maxProps >= 0 && len(x) > s.maxProps {
+			fail("maxProperties", "must have at most %d properties, has %d", s.maxProps, len(x))
+		}
+		keys := make([]string, 0, len(x))
+		for k := range x {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			kip := ip + "/" + escapePointer(k)
+			matched := false
+			if sub, has := s.properties[k]; has {
+				check(sub, x[k], kip, "properties/"+escapePointer(k))
+				matched = true
+			}
+			for _, pp := range s.patternProps {
+				if pp.re.MatchString(k) {
+					check(pp.schema, x[k], kip, "patternProperties/"+escapePointer(pp.text))
+					matched = true
+				}
+			}
+			if !matched && s.additionalProps != nil {
+				if good, _ := check(s.additionalProps, x[k], kip, "additionalProperties"); !good && s.additionalProps.always != nil {
+					// "no value is allowed" reads oddly for a property.
+					v.errs[len(v.errs)-1].Message = fmt.Sprintf("property %q is not allowed", k)
+				}
+				matched = true
+			}
+			if matched {
+				ev.prop(k)
+			}
+			if s.propertyNames != nil {
+				check(s.propertyNames, k, kip, "propertyNames")
+			}
+			if reqs, has := s.depRequired[k]; has {
+				for _, r := range reqs {
+					if _, present := x[r]; !present {
+						fail(s.depReqKw+"/"+escapePointer(k), "property %q requires property %q", k, r)
+					}
+				}
+			}
+			if sub, has := s.depSchemas[k]; has {
+				apply(sub, s.depSchKw+"/"+escapePointer(k))
+			}
+		}
+	}
+
+	for i, sub := range s.allOf {
+		apply(sub, "allOf/"+strconv.Itoa(i))
+	}
+	if s.anyOf != nil {
+		matched := false
+		var best []Error
+		for i, sub := range s.anyOf {
+			good, e, errs := v.try(sub, inst, ip, kp+"/anyOf/"+strconv.Itoa(i))
+			if good {
+				matched = true
+				ev.merge(e)
+			} else if best == nil || len(errs) < len(best) {
+				best = errs
+			}
+		}
+		if !matched {
+			fail("anyOf", "does not match any of the %d alternatives", len(s.anyOf))
+			v.errs = append(v.errs, best...)
+		}
+	}
+	if s.oneOf != nil {
+		var matches []string
+		var best []Error
+		var bestEv *evaluated
+		for i, sub := range s.oneOf {
+			good, e, errs := v.try(sub, inst, ip, kp+"/oneOf/"+strconv.Itoa(i))
+			if good {
+				matches = append(matches, strconv.Itoa(i))
+				bestEv = e
+			} else if best == nil || len(errs) < len(best) {
+				best = errs
+			}
+		}
+		switch len(matches) {
+		case 0:
+			fail("oneOf", "does not match any of the %d alternatives", len(s.oneOf))
+			v.errs = append(v.errs, best...)
+		case 1:
+			ev.merge(bestEv)
+		default:
+			fail("oneOf", "matches alternatives %s; want exactly one", strings.Join(matches, ", "))
+		}
+	}
+	if s.not != nil {
+		if good, _, _ := v.try(s.not, inst, ip, kp+"/not"); good {
+			fail("not", "must not match the schema")
+		}
+	}
+	if s.ifs != nil {
+		if good, e, _ := v.try(s.ifs, inst, ip, kp+"/if"); good {
+			ev.merge(e)
+			if s.then != nil {
+				apply(s.then, "then")
+			}
+		} else if s.els != nil {
+			apply(s.els, "else")
+		}
+	}
+
+	// unevaluated* see the annotations of everything above.
+	switch x := inst.(type) {
+	case map[string]interface{}:
+		if s.unevalProps == nil {
+			break
+		}
+		keys := make([]string, 0, len(x))
+		for k := range x {
+			if !ev.props[k] {
+				keys = append(keys, k)
+			}
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			if good, _ := check(s.unevalProps, x[k], ip+"/"+escapePointer(k), "unevaluatedProperties"); good {
+				ev.prop(k)
+			} else if s.unevalProps.always != nil {
+				v.errs[len(v.errs)-1].Message = fmt.Sprintf("property %q is not allowed", k)
+			}
+		}
+	case []interface{}:
+		if s.unevalItems == nil || ev.all {
+			break
+		}
+		for i := ev.items; i < len(x); i++ {
+			if !ev.idx[i] {
+				check(s.unevalItems, x[i], ip+"/"+strconv.Itoa(i), "unevaluatedItems")
+			}
+		}
+		ev.all = true
+	}
+	return ok, ev
+}
+
+func typeName(v interface{}) string {
+	switch x := v.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return "boolean"
+	case string:
+		return "string"
+	case []interface{}:
+		return "array"
+	case map[string]interface{}:
+		return "object"
+	case json.Number:
+		if n, ok := toNumber(x); ok && n.r.IsInt() {
+			return "integer"
+		}
+		return "number"
+	}
+	return fmt.Sprintf("%T", v)
+}
+
+func typeMatches(types []string, v interface{}) bool {
+	name := typeName(v)
+	for _, t := range types {
+		if t == name || (t == "number" && name == "integer") {
+			return true
+		}
+	}
+	return false
+}
+
+// equal compares JSON values; numbers are equal when their values are,
+// so 1 equals 1.0.
+func equal(a, b interface{}) bool {
+	switch x := a.(type) {
+	case json.Number:
+		y, ok := b.(json.Number)
+		if !ok {
+			return false
+		}
+		p, ok1 := toNumber(x)
+		q, ok2 := toNumber(y)
+		return ok1 && ok2 && p.r.Cmp(q.r) == 0
+	case []interface{}:
+		y, ok := b.([]interface{})
+		if !ok || len(x) != len(y) {
+			return false
+		}
+		for i := range x {
+			if !equal(x[i], y[i]) {
+				return false
+			}
+		}
+		return true
+	case map[string]interface{}:
+		y, ok := b.(map[string]interface{})
+		if !ok || len(x) != len(y) {
+			return false
+		}
+		for k, xv := range x {
+			yv, ok := y[k]
+			if !ok || !equal(xv, yv) {
+				return false
+			}
+		}
+		return true
+	}
+	return a == b
+}
+
+// canonical renders v so that equal values render the same.
+func canonical(v interface{}) string {
+	switch x := v.(type) {
+	case json.Number:
+		if n, ok := toNumber(x); ok {
+			return "n" + n.r.RatString()
+		}
+	case []interface{}:
+		parts := make([]string, len(x))
+		for i, e := range x {
+			parts[i] = canonical(e)
+		}
+		return "[" + strings.Join(parts, ",") + "]"
+	case map[string]interface{}:
+		keys := make([]string, 0, len(x))
+		for k := range x {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		parts := make([]string, len(keys))
+		for i, k := range keys {
+			parts[i] = strconv.Quote(k) + ":" + canonical(x[k])
+		}
+		return "{" + strings.Join(parts, ",") + "}"
+	}
+	return jsonText(v)
+}
+
+func jsonText(v interface{}) string {
+	b, _ := json.Marshal(v)
+	return string(b)
+}
+
+func listValues(vals []interface{}) string {
+	const max = 10
+	parts := make([]string, 0, max+1)
+	for i, v := range vals {
+		if i == max {
+			parts = append(parts, "...")
+			break
+		}
+		parts = append(parts, jsonText(v))
+	}
+	return strings.Join(parts, ", ")
+}
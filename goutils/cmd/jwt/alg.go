@@ -0,0 +1,224 @@
+package main
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	_ "crypto/sha256"
+	_ "crypto/sha512"
+	"errors"
+	"fmt"
+	"math/big"
+	"sort"
+	"strings"
+)
+
+type algKind int
+
+const (
+	kindHMAC algKind = iota
+	kindRSA
+	kindPSS
+	kindECDSA
+	kindEdDSA
+)
+
+// algorithm describes one JWS "alg" value (RFC 7518 section 3).
+type algorithm struct {
+	kind  algKind
+	hash  crypto.Hash
+	curve string // ECDSA curve the algorithm requires
+}
+
+var algorithms = map[string]algorithm{
+	"HS256": {kindHMAC, crypto.SHA256, ""},
+	"HS384": {kindHMAC, crypto.SHA384, ""},
+	"HS512": {kindHMAC, crypto.SHA512, ""},
+	"RS256": {kindRSA, crypto.SHA256, ""},
+	"RS384": {kindRSA, crypto.SHA384, ""},
+	"RS512": {kindRSA, crypto.SHA512, ""},
+	"PS256": {kindPSS, crypto.SHA256, ""},
+	"PS384": {kindPSS, crypto.SHA384, ""},
+	"PS512": {kindPSS, crypto.SHA512, ""},
+	"ES256": {kindECDSA, crypto.SHA256, "P-256"},
+	"ES384": {kindECDSA, crypto.SHA384, "P-384"},
+	"ES512": {kindECDSA, crypto.SHA512, "P-521"},
+	"EdDSA": {kindEdDSA, 0, ""},
+}
+
+func algorithmNames() []string {
+	var names []string
+	for n := range algorithms {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func lookupAlg(name string) (algorithm, error) {
+	a, ok := algorithms[name]
+	if !ok {
+		if name == "none" {
+			return a, errors.New(`unsigned tokens (alg "none") are not accepted`)
+		}
+		return a, fmt.Errorf("unsupported algorithm %q (want one of %s)", name, strings.Join(algorithmNames(), ", "))
+	}
+	return a, nil
+}
+
+// defaultAlg picks the usual algorithm for a signing key.
+func defaultAlg(key interface{}) string {
+	switch k := key.(type) {
+	case *rsa.PrivateKey:
+		return "RS256"
+	case *ecdsa.PrivateKey:
+		switch k.Curve.Params().Name {
+		case "P-384":
+			return "ES384"
+		case "P-521":
+			return "ES512"
+		}
+		return "ES256"
+	case ed25519.PrivateKey:
+		return "EdDSA"
+	}
+	return "HS256"
+}
+
+func digest(h crypto.Hash, data []byte) []byte {
+	d := h.New()
+	d.Write(data)
+	return d.Sum(nil)
+}
+
+// sign computes the JWS signature of input. key is a []byte secret for
+// HMAC and a crypto.Signer otherwise.
+func sign(name string, key interface{}, input []byte) ([]byte, error) {
+	a, err := lookupAlg(name)
+	if err != nil {
+		return nil, err
+	}
+	switch a.kind {
+	case kindHMAC:
+		secret, ok := key.([]byte)
+		if !ok {
+			return nil, fmt.Errorf("%s needs a secret, not a %s", name, keyDescription(key))
+		}
+		m := hmac.New(a.hash.New, secret)
+		m.Write(input)
+		return m.Sum(nil), nil
+	case kindRSA, kindPSS:
+		k, ok := key.(*rsa.PrivateKey)
+		if !ok {
+			return nil, fmt.Errorf("%s needs an RSA private key, not a %s", name, keyDescription(key))
+		}
+		if a.kind == kindPSS {
+			return rsa.SignPSS(rand.Reader, k, a.hash, digest(a.hash, input), &rsa.PSSOptions{SaltLength: rsa.PSSSaltLengthEqualsHash})
+		}
+		return rsa.SignPKCS1v15(rand.Reader, k, a.hash, digest(a.hash, input))
+	case kindECDSA:
+		k, ok := key.(*ecdsa.PrivateKey)
+		if !ok || k.Curve.Params().Name != a.curve {
+			return nil, fmt.Errorf("%s needs an ECDSA %s private key, not a %s", name, a.curve, keyDescription(key))
+		}
+		r, s, err := ecdsa.Sign(rand.Reader, k, digest(a.hash, input))
+		if err != nil {
+			return nil, err
+		}
+		size := (k.Curve.Params().BitSize + 7) / 8
+		sig := make([]byte, 2*size)
+		r.FillBytes(sig[:size])
+		s.FillBytes(sig[size:])
+		return sig, nil
+	default:
+		k, ok := key.(ed25519.PrivateKey)
+		if !ok {
+			return nil, fmt.Errorf("%s needs an Ed25519 private key, not a %s", name, keyDescription(key))
+		}
+		return ed25519.Sign(k, input), nil
+	}
+}
+
+// errKeyMismatch reports that a key cannot be used with the token's
+// algorithm at all, as opposed to a signature that does not verify.
+var errKeyMismatch = errors.New("key does not match the algorithm")
+
+// verifySignature checks sig over input. key is a []byte secret or a
+// public key; a private key is reduced to its public half.
+func verifySignature(name string, key interface{}, input, sig []byte) error {
+	a, err := lookupAlg(name)
+	if err != nil {
+		return err
+	}
+	if s, ok := key.(crypto.Signer); ok {
+		key = s.Public()
+	}
+	bad := errors.New("signature verification failed")
+	switch a.kind {
+	case kindHMAC:
+		secret, ok := key.([]byte)
+		if !ok {
+			return errKeyMismatch
+		}
+		m := hmac.New(a.hash.New, secret)
+		m.Write(input)
+		if !hmac.Equal(m.Sum(nil), sig) {
+			return bad
+		}
+	case kindRSA, kindPSS:
+		k, ok := key.(*rsa.PublicKey)
+		if !ok {
+			return errKeyMismatch
+		}
+		if a.kind == kindPSS {
+			err = rsa.VerifyPSS(k, a.hash, digest(a.hash, input), sig, &rsa.PSSOptions{SaltLength: rsa.PSSSaltLengthEqualsHash})
+		} else {
+			err = rsa.VerifyPKCS1v15(k, a.hash, digest(a.hash, input), sig)
+		}
+		if err != nil {
+			return bad
+		}
+	case kindECDSA:
+		k, ok := key.(*ecdsa.PublicKey)
+		if !ok || k.Curve.Params().Name != a.curve {
+			return errKeyMismatch
+		}
+		size := (k.Curve.Params().BitSize + 7) / 8
+		if len(sig) != 2*size {
+			return fmt.Errorf("%s signature must be %d bytes, got %d", name, 2*size, len(sig))
+		}
+		r := new(big.Int).SetBytes(sig[:size])
+		s := new(big.Int).SetBytes(sig[size:])
+		if !ecdsa.Verify(k, digest(a.hash, input), r, s) {
+			return bad
+		}
+	default:
+		k, ok := key.(ed25519.PublicKey)
+		if !ok {
+			return errKeyMismatch
+		}
+		if !ed25519.Verify(k, input, sig) {
+			return bad
+		}
+	}
+	return nil
+}
+
+func keyDescription(key interface{}) string {
+	switch k := key.(type) {
+	case []byte:
+		return "secret"
+	case *rsa.PrivateKey, *rsa.PublicKey:
+		return "RSA key"
+	case *ecdsa.PrivateKey:
+		return "ECDSA " + k.Curve.Params().Name + " key"
+	case *ecdsa.PublicKey:
+		return "ECDSA " + k.Curve.Params().Name + " key"
+	case ed25519.PrivateKey, ed25519.PublicKey:
+		return "Ed25519 key"
+	}
+	return fmt.Sprintf("%T", key)
+}
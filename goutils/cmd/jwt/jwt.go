@@ -0,0 +1,801 @@
+// jwt - decode, verify, sign and decrypt JSON Web Tokens
+//
+// Usage:
+//
+//	jwt decode  [-j] [TOKEN]
+//	jwt verify  [KEY] [-alg ALG] [-iss ISS] [-aud AUD] [-skew DUR] [-q] [-j] [TOKEN]
+//	jwt sign    [KEY] [-alg ALG] [-c NAME=VALUE]... [-exp DUR] [-kid KID] [CLAIMS.json]
+//	jwt decrypt [KEY] [TOKEN]
+//
+// TOKEN defaults to standard input; a leading "Bearer " is ignored.
+//
+// Key options:
+//
+//	-secret S       HMAC secret or JWE key; prefix "b64:" or "hex:" for binary
+//	-secret-file F  Read the secret from a file
+//	-key FILE       PEM key or certificate, or a JWK / JWK Set
+//	-jwks FILE      Same as -key; the token's kid selects the key
+//
+// verify checks the signature (HS256/384/512, RS*, PS*, ES* and EdDSA) and
+// the exp, nbf and iat claims, allowing -skew (default 60s) of clock drift.
+// It exits 1 if the token is not valid. decrypt handles compact JWE with
+// alg "dir" and A128GCM/A192GCM/A256GCM content encryption.
+//
+// Sign options:
+//
+//	-c NAME=VALUE   Set a claim; VALUE is parsed as JSON if possible (repeatable)
+//	-exp DUR        Set exp to now + DUR
+//	-iat            Set iat to now (default: true)
+//	-kid KID        Header kid (default: the JWK's kid)
+//
+// Examples:
+//
+//	jwt decode eyJhbGciOi...
+//	jwt verify -jwks keys.json -aud api -iss https://issuer.example < token
+//	jwt sign -secret "$SECRET" -c sub=alice -c admin=true -exp 1h
+//	keygen ed25519 -f jwk > k.jwk && jwt sign -key k.jwk claims.json
+//	jwt decrypt -secret b64:"$KEY" "$JWE"
+package main
+
+import (
+	"bytes"
+	"compress/flate"
+	"crypto"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"goutils/internal/cli"
+	"goutils/internal/jwk"
+	"goutils/internal/x509util"
+)
+
+const dateFormat = "2006-01-02 15:04:05 MST"
+
+func usage() {
+	fmt.Fprintln(os.Stderr, `usage: jwt decode  [-j] [TOKEN]
+       jwt verify  [KEY] [-alg ALG] [-iss ISS] [-aud AUD] [-skew DUR] [-q] [-j] [TOKEN]
+       jwt sign    [KEY] [-alg ALG] [-c NAME=VALUE]... [-exp DUR] [-kid KID] [CLAIMS.json]
+       jwt decrypt [KEY] [TOKEN]
+KEY is -secret S, -secret-file FILE, -key FILE or -jwks FILE`)
+	os.Exit(1)
+}
+
+func fatal(err error) {
+	fmt.Fprintln(os.Stderr, "jwt:", err)
+	os.Exit(1)
+}
+
+func main() {
+	if len(os.Args) < 2 {
+		usage()
+	}
+	args := os.Args[2:]
+	switch os.Args[1] {
+	case "decode":
+		decodeCmd(args)
+	case "verify":
+		verifyCmd(args)
+	case "sign":
+		signCmd(args)
+	case "decrypt":
+		decryptCmd(args)
+	default:
+		// A bare token is the most common thing to paste.
+		if strings.Count(os.Args[1], ".") >= 2 {
+			decodeCmd(os.Args[1:])
+			return
+		}
+		usage()
+	}
+}
+
+// token is a parsed compact JWS.
+type token struct {
+	parts  []string
+	header json.RawMessage
+	claims json.RawMessage
+	hdr    map[string]interface{}
+	cl     map[string]interface{}
+	sig    []byte
+}
+
+func (t *token) alg() string { s, _ := t.hdr["alg"].(string); return s }
+func (t *token) kid() string { s, _ := t.hdr["kid"].(string); return s }
+
+func decodeSegment(s string) ([]byte, error) {
+	return base64.RawURLEncoding.DecodeString(strings.TrimRight(s, "="))
+}
+
+func decodeJSON(data []byte, v interface{}) error {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	return dec.Decode(v)
+}
+
+func parseToken(raw string) (*token, error) {
+	t := &token{parts: strings.Split(raw, ".")}
+	if len(t.parts) == 5 {
+		return nil, errors.New("this is an encrypted token (JWE); use jwt decrypt")
+	}
+	if len(t.parts) != 3 {
+		return nil, fmt.Errorf("malformed token: %d segments, want 3", len(t.parts))
+	}
+	var err error
+	if t.header, err = decodeSegment(t.parts[0]); err != nil {
+		return nil, fmt.Errorf("header: %w", err)
+	}
+	if err := decodeJSON(t.header, &t.hdr); err != nil {
+		return nil, fmt.Errorf("header: %w", err)
+	}
+	if t.claims, err = decodeSegment(t.parts[1]); err != nil {
+		return nil, fmt.Errorf("claims: %w", err)
+	}
+	if err := decodeJSON(t.claims, &t.cl); err != nil {
+		return nil, fmt.Errorf("claims: %w", err)
+	}
+	if t.sig, err = decodeSegment(t.parts[2]); err != nil {
+		return nil, fmt.Errorf("signature: %w", err)
+	}
+	return t, nil
+}
+
+// readToken takes the token from args or standard input.
+func readToken(args []string) string {
+	var raw string
+	if len(args) > 0 && args[0] != "-" {
+		raw = args[0]
+	} else {
+		b, err := io.ReadAll(os.Stdin)
+		if err != nil {
+			fatal(err)
+		}
+		raw = string(b)
+	}
+	raw = strings.TrimSpace(raw)
+	if len(raw) > 7 && strings.EqualFold(raw[:7], "bearer ") {
+		raw = strings.TrimSpace(raw[7:])
+	}
+	if raw == "" {
+		fatal(errors.New("no token given"))
+	}
+	return raw
+}
+
+func pretty(raw json.RawMessage) string {
+	var buf bytes.Buffer
+	if err := json.Indent(&buf, raw, "", "  "); err != nil {
+		return string(raw)
+	}
+	return buf.String()
+}
+
+// numericDate reads a NumericDate claim (seconds since the epoch).
+func numericDate(v interface{}) (time.Time, bool) {
+	n, ok := v.(json.Number)
+	if !ok {
+		return time.Time{}, false
+	}
+	f, err := n.Float64()
+	if err != nil {
+		return time.Time{}, false
+	}
+	sec := int64(f)
+	return time.Unix(sec, int64((f-float64(sec))*1e9)), true
+}
+
+// relative describes t relative to now, e.g. "in 5m" or "3 days ago".
+func relative(t, now time.Time) string {
+	d := t.Sub(now)
+	future := d >= 0
+	if !future {
+		d = -d
+	}
+	var s string
+	switch {
+	case d < time.Minute:
+		s = fmt.Sprintf("%ds", int(d.Seconds()))
+	case d < time.Hour:
+		s = fmt.Sprintf("%dm", int(d.Minutes()))
+	case d < 48*time.Hour:
+		s = fmt.Sprintf("%dh%02dm", int(d.Hours()), int(d.Minutes())%60)
+	default:
+		s = fmt.Sprintf("%d days", int(d.Hours()/24))
+	}
+	if future {
+		return "in " + s
+	}
+	return s + " ago"
+}
+
+var timeClaims = []string{"iat", "nbf", "exp", "auth_time"}
+
+func printTimes(t *token, now time.Time) {
+	first := true
+	for _, name := range timeClaims {
+		ts, ok := numericDate(t.cl[name])
+		if !ok {
+			continue
+		}
+		if first {
+			fmt.Println("Times:")
+			first = false
+		}
+		note := relative(ts, now)
+		if name == "exp" && !now.Before(ts) {
+			note = "EXPIRED " + note
+		}
+		fmt.Printf("  %-9s : %s (%s)\n", name, ts.Local().Format(dateFormat), note)
+	}
+}
+
+type decoded struct {
+	Header    json.RawMessage `json:"header"`
+	Claims    json.RawMessage `json:"claims"`
+	Signature string          `json:"signature"`
+	Valid     *bool           `json:"valid,omitempty"`
+	Errors    []string        `json:"errors,omitempty"`
+}
+
+func decodeCmd(args []string) {
+	fs := flag.NewFlagSet("jwt decode", flag.ExitOnError)
+	asJSON := fs.Bool("j", false, "JSON output")
+	raw := readToken(cli.Parse(fs, args))
+	t, err := parseToken(raw)
+	if err != nil {
+		if strings.Count(raw, ".") == 4 {
+			describeJWE(raw)
+			return
+		}
+		fatal(err)
+	}
+	if *asJSON {
+		out, _ := json.MarshalIndent(decoded{Header: t.header, Claims: t.claims, Signature: t.parts[2]}, "", "  ")
+		fmt.Println(string(out))
+		return
+	}
+	fmt.Println("Header:")
+	fmt.Println(pretty(t.header))
+	fmt.Println("Claims:")
+	fmt.Println(pretty(t.claims))
+	printTimes(t, time.Now())
+	fmt.Printf("Signature: %s, %d bytes (not verified)\n", t.alg(), len(t.sig))
+}
+
+// keyOptions are the flags shared by verify, sign and decrypt.
+type keyOptions struct {
+	secret     *string
+	secretFile *string
+	keyFile    *string
+	jwksFile   *string
+}
+
+func addKeyFlags(fs *flag.FlagSet) *keyOptions {
+	return &keyOptions{
+		secret:     fs.String("secret", "", "HMAC secret or JWE key"),
+		secretFile: fs.String("secret-file", "", "file holding the secret"),
+		keyFile:    fs.String("key", "", "PEM or JWK key file"),
+		jwksFile:   fs.String("jwks", "", "JWK Set file"),
+	}
+}
+
+// candidate is a key that might have signed a token.
+type candidate struct {
+	key interface{} // []byte, crypto.Signer or crypto.PublicKey
+	kid string
+	alg string
+}
+
+func parseSecret(s string) ([]byte, error) {
+	switch {
+	case strings.HasPrefix(s, "b64:"):
+		s = strings.TrimRight(s[4:], "=")
+		if b, err := base64.RawURLEncoding.DecodeString(s); err == nil {
+			return b, nil
+		}
+		return base64.RawStdEncoding.DecodeString(s)
+	case strings.HasPrefix(s, "hex:"):
+		return hex.DecodeString(s[4:])
+	}
+	return []byte(s), nil
+}
+
+func (o *keyOptions) load() ([]candidate, error) {
+	var keys []candidate
+	if *o.secret != "" {
+		b, err := parseSecret(*o.secret)
+		if err != nil {
+			return nil, fmt.Errorf("-secret: %w", err)
+		}
+		keys = append(keys, candidate{key: b})
+	}
+	if *o.secretFile != "" {
+		data, err := os.ReadFile(*o.secretFile)
+		if err != nil {
+			return nil, err
+		}
+		b, err := parseSecret(strings.TrimRight(string(data), "\r\n"))
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", *o.secretFile, err)
+		}
+		keys = append(keys, candidate{key: b})
+	}
+	for _, f := range []string{*o.keyFile, *o.jwksFile} {
+		if f == "" {
+			continue
+		}
+		ks, err := loadKeyFile(f)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, ks...)
+	}
+	if len(keys) == 0 {
+		return nil, errors.New("no key given (use -secret, -secret-file, -key or -jwks)")
+	}
+	return keys, nil
+}
+
+// loadKeyFile reads a JWK, JWK Set or PEM file.
+func loadKeyFile(name string) ([]candidate, error) {
+	data, err := os.ReadFile(name)
+	if err != nil {
+		return nil, err
+	}
+	var keys []candidate
+	if bytes.HasPrefix(bytes.TrimSpace(data), []byte("{")) {
+		jwks, err := jwk.Parse(data)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", name, err)
+		}
+		for _, j := range jwks {
+			c := candidate{kid: j.Kid, alg: j.Alg}
+			if j.Kty == "oct" {
+				c.key, err = j.Secret()
+			} else {
+				var pub crypto.PublicKey
+				var priv crypto.Signer
+				pub, priv, err = j.Keys()
+				c.key = pub
+				if priv != nil {
+					c.key = priv
+				}
+			}
+			if err != nil {
+				return nil, fmt.Errorf("%s: %w", name, err)
+			}
+			keys = append(keys, c)
+		}
+		return keys, nil
+	}
+	for {
+		var block *pem.Block
+		block, data = pem.Decode(data)
+		if block == nil {
+			break
+		}
+		var key interface{}
+		switch block.Type {
+		case "PUBLIC KEY":
+			key, err = x509.ParsePKIXPublicKey(block.Bytes)
+		case "RSA PUBLIC KEY":
+			key, err = x509.ParsePKCS1PublicKey(block.Bytes)
+		case "CERTIFICATE":
+			var c *x509.Certificate
+			if c, err = x509.ParseCertificate(block.Bytes); err == nil {
+				key = c.PublicKey
+			}
+		case "PRIVATE KEY", "RSA PRIVATE KEY", "EC PRIVATE KEY":
+			key, err = x509util.ParsePrivateKey(block.Bytes)
+		default:
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%s: %s: %w", name, block.Type, err)
+		}
+		keys = append(keys, candidate{key: key})
+	}
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("%s: no keys found", name)
+	}
+	return keys, nil
+}
+
+type check struct {
+	name   string
+	ok     bool
+	detail string
+}
+
+func verifyCmd(args []string) {
+	fs := flag.NewFlagSet("jwt verify", flag.ExitOnError)
+	ko := addKeyFlags(fs)
+	wantAlg := fs.String("alg", "", "required algorithm")
+	iss := fs.String("iss", "", "required issuer")
+	aud := fs.String("aud", "", "required audience")
+	skew := fs.Duration("skew", 60*time.Second, "allowed clock skew")
+	quiet := fs.Bool("q", false, "quiet: exit status only")
+	asJSON := fs.Bool("j", false, "JSON output")
+	raw := readToken(cli.Parse(fs, args))
+
+	t, err := parseToken(raw)
+	if err != nil {
+		fatal(err)
+	}
+	keys, err := ko.load()
+	if err != nil {
+		fatal(err)
+	}
+	now := time.Now()
+	checks := []check{verifyTokenSignature(t, keys, *wantAlg)}
+	checks = append(checks, claimChecks(t, now, *skew, *iss, *aud)...)
+
+	valid := true
+	var problems []string
+	for _, c := range checks {
+		if !c.ok {
+			valid = false
+			problems = append(problems, c.name+": "+c.detail)
+		}
+	}
+	switch {
+	case *quiet:
+	case *asJSON:
+		out, _ := json.MarshalIndent(decoded{Header: t.header, Claims: t.claims, Signature: t.parts[2],
+			Valid: &valid, Errors: problems}, "", "  ")
+		fmt.Println(string(out))
+	default:
+		fmt.Println("Claims:")
+		fmt.Println(pretty(t.claims))
+		fmt.Println("Verification:")
+		for _, c := range checks {
+			status := "ok"
+			if !c.ok {
+				status = "FAILED"
+			}
+			fmt.Printf("  %-9s : %s, %s\n", c.name, status, c.detail)
+		}
+		if valid {
+			fmt.Println("Result: VALID")
+		} else {
+			fmt.Println("Result: INVALID")
+		}
+	}
+	if !valid {
+		os.Exit(1)
+	}
+}
+
+// verifyTokenSignature tries every candidate key that could have signed t,
+// preferring those whose kid matches the header.
+func verifyTokenSignature(t *token, keys []candidate, wantAlg string) check {
+	c := check{name: "signature"}
+	alg := t.alg()
+	if wantAlg != "" && alg != wantAlg {
+		c.detail = fmt.Sprintf("token uses %q, expected %q", alg, wantAlg)
+		return c
+	}
+	if _, err := lookupAlg(alg); err != nil {
+		c.detail = err.Error()
+		return c
+	}
+	input := []byte(t.parts[0] + "." + t.parts[1])
+	kid := t.kid()
+	var lastErr error
+	tried := 0
+	for _, k := range keys {
+		if kid != "" && k.kid != "" && k.kid != kid {
+			continue
+		}
+		if k.alg != "" && k.alg != alg && !(strings.HasPrefix(k.alg, "RS") && strings.HasPrefix(alg, "PS")) {
+			continue
+		}
+		err := verifySignature(alg, k.key, input, t.sig)
+		if err == errKeyMismatch {
+			continue
+		}
+		tried++
+		if err == nil {
+			c.ok = true
+			c.detail = alg + " with " + keyDescription(k.key)
+			if k.kid != "" {
+				c.detail += " " + k.kid
+			}
+			return c
+		}
+		lastErr = err
+	}
+	switch {
+	case tried == 0 && kid != "":
+		c.detail = fmt.Sprintf("no %s key with kid %q", alg, kid)
+	case tried == 0:
+		c.detail = "no key suitable for " + alg
+	default:
+		c.detail = lastErr.Error()
+	}
+	return c
+}
+
+func claimChecks(t *token, now time.Time, skew time.Duration, iss, aud string) []check {
+	var checks []check
+	if v, present := t.cl["exp"]; present {
+		c := check{name: "exp"}
+		if ts, ok := numericDate(v); !ok {
+			c.detail = "not a number"
+		} else {
+			c.ok = now.Before(ts.Add(skew))
+			c.detail = "expires " + ts.Local().Format(dateFormat) + " (" + relative(ts, now) + ")"
+			if !c.ok {
+				c.detail = "expired " + ts.Local().Format(dateFormat) + " (" + relative(ts, now) + ")"
+			}
+		}
+		checks = append(checks, c)
+	}
+	if v, present := t.cl["nbf"]; present {
+		c := check{name: "nbf"}
+		if ts, ok := numericDate(v); !ok {
+			c.detail = "not a number"
+		} else {
+			c.ok = !now.Before(ts.Add(-skew))
+			c.detail = "valid from " + ts.Local().Format(dateFormat) + " (" + relative(ts, now) + ")"
+		}
+		checks = append(checks, c)
+	}
+	if v, present := t.cl["iat"]; present {
+		c := check{name: "iat"}
+		if ts, ok := numericDate(v); !ok {
+			c.detail = "not a number"
+		} else {
+			c.ok = !now.Before(ts.Add(-skew))
+			c.detail = "issued " + ts.Local().Format(dateFormat) + " (" + relative(ts, now) + ")"
+			if !c.ok {
+				c.detail = "issued in the future " + ts.Local().Format(dateFormat)
+			}
+		}
+		checks = append(checks, c)
+	}
+	if iss != "" {
+		got, _ := t.cl["iss"].(string)
+		c := check{name: "iss", ok: got == iss, detail: fmt.Sprintf("%q", got)}
+		if !c.ok {
+			c.detail = fmt.Sprintf("%q, expected %q", got, iss)
+		}
+		checks = append(checks, c)
+	}
+	if aud != "" {
+		var auds []string
+		switch v := t.cl["aud"].(type) {
+		case string:
+			auds = []string{v}
+		case []interface{}:
+			for _, a := range v {
+				if s, ok := a.(string); ok {
+					auds = append(auds, s)
+				}
+			}
+		}
+		c := check{name: "aud", detail: fmt.Sprintf("%q", auds)}
+		for _, a := range auds {
+			if a == aud {
+				c.ok = true
+			}
+		}
+		if !c.ok {
+			c.detail = fmt.Sprintf("%q does not include %q", auds, aud)
+		}
+		checks = append(checks, c)
+	}
+	return checks
+}
+
+type claimFlags []string
+
+func (c *claimFlags) String() string     { return strings.Join(*c, ", ") }
+func (c *claimFlags) Set(v string) error { *c = append(*c, v); return nil }
+
+func signCmd(args []string) {
+	fs := flag.NewFlagSet("jwt sign", flag.ExitOnError)
+	ko := addKeyFlags(fs)
+	alg := fs.String("alg", "", "algorithm (default: from the key)")
+	exp := fs.Duration("exp", 0, "expire after")
+	iat := fs.Bool("iat", true, "set iat")
+	kid := fs.String("kid", "", "header kid")
+	var extra claimFlags
+	fs.Var(&extra, "c", "claim NAME=VALUE")
+	pos := cli.Parse(fs, args)
+
+	claims := map[string]interface{}{}
+	if len(pos) > 0 {
+		var data []byte
+		var err error
+		if pos[0] == "-" {
+			data, err = io.ReadAll(os.Stdin)
+		} else {
+			data, err = os.ReadFile(pos[0])
+		}
+		if err != nil {
+			fatal(err)
+		}
+		if err := decodeJSON(data, &claims); err != nil {
+			fatal(fmt.Errorf("claims: %w", err))
+		}
+	}
+	for _, c := range extra {
+		name, value, ok := strings.Cut(c, "=")
+		if !ok || name == "" {
+			fatal(fmt.Errorf("-c %q: want NAME=VALUE", c))
+		}
+		var v interface{}
+		if err := decodeJSON([]byte(value), &v); err != nil {
+			v = value
+		}
+		claims[name] = v
+	}
+	now := time.Now()
+	if *iat {
+		claims["iat"] = now.Unix()
+	}
+	if *exp != 0 {
+		claims["exp"] = now.Add(*exp).Unix()
+	}
+
+	keys, err := ko.load()
+	if err != nil {
+		fatal(err)
+	}
+	key := keys[0]
+	for _, k := range keys {
+		if *kid != "" && k.kid == *kid {
+			key = k
+			break
+		}
+	}
+	if _, priv := key.key.(crypto.Signer); !priv {
+		if _, secret := key.key.([]byte); !secret {
+			fatal(errors.New("signing needs a private key or a secret"))
+		}
+	}
+	name := *alg
+	if name == "" {
+		name = key.alg
+		if name == "" {
+			name = defaultAlg(key.key)
+		}
+	}
+	header := map[string]interface{}{"alg": name, "typ": "JWT"}
+	if *kid != "" {
+		header["kid"] = *kid
+	} else if key.kid != "" {
+		header["kid"] = key.kid
+	}
+
+	h, _ := json.Marshal(header)
+	cl, err := json.Marshal(claims)
+	if err != nil {
+		fatal(err)
+	}
+	input := base64.RawURLEncoding.EncodeToString(h) + "." + base64.RawURLEncoding.EncodeToString(cl)
+	sig, err := sign(name, key.key, []byte(input))
+	if err != nil {
+		fatal(err)
+	}
+	fmt.Println(input + "." + base64.RawURLEncoding.EncodeToString(sig))
+}
+
+// describeJWE prints the protected header of an encrypted token.
+func describeJWE(raw string) {
+	parts := strings.Split(raw, ".")
+	header, err := decodeSegment(parts[0])
+	if err != nil {
+		fatal(fmt.Errorf("header: %w", err))
+	}
+	fmt.Println("Header (JWE):")
+	fmt.Println(pretty(header))
+	ct, _ := decodeSegment(parts[3])
+	fmt.Printf("Encrypted payload: %d bytes (use jwt decrypt)\n", len(ct))
+}
+
+func decryptCmd(args []string) {
+	fs := flag.NewFlagSet("jwt decrypt", flag.ExitOnError)
+	ko := addKeyFlags(fs)
+	raw := readToken(cli.Parse(fs, args))
+	keys, err := ko.load()
+	if err != nil {
+		fatal(err)
+	}
+	var lastErr error
+	for _, k := range keys {
+		secret, ok := k.key.([]byte)
+		if !ok {
+			continue
+		}
+		plain, err := decryptJWE(raw, secret)
+		if err == nil {
+			os.Stdout.Write(plain)
+			if !bytes.HasSuffix(plain, []byte("\n")) {
+				fmt.Println()
+			}
+			return
+		}
+		lastErr = err
+	}
+	if lastErr == nil {
+		lastErr = errors.New("JWE decryption needs a symmetric key (-secret, -secret-file or an oct JWK)")
+	}
+	fatal(lastErr)
+}
+
+// decryptJWE decrypts a compact JWE that uses direct encryption with a
+// shared AES-GCM key (RFC 7516, RFC 7518 sections 4.5 and 5.3).
+func decryptJWE(raw string, key []byte) ([]byte, error) {
+	parts := strings.Split(raw, ".")
+	if len(parts) != 5 {
+		return nil, fmt.Errorf("malformed JWE: %d segments, want 5", len(parts))
+	}
+	headerJSON, err := decodeSegment(parts[0])
+	if err != nil {
+		return nil, fmt.Errorf("header: %w", err)
+	}
+	var header struct {
+		Alg string `json:"alg"`
+		Enc string `json:"enc"`
+		Zip string `json:"zip"`
+	}
+	if err := json.Unmarshal(headerJSON, &header); err != nil {
+		return nil, fmt.Errorf("header: %w", err)
+	}
+	if header.Alg != "dir" {
+		return nil, fmt.Errorf("unsupported key management algorithm %q (only \"dir\")", header.Alg)
+	}
+	var size int
+	switch header.Enc {
+	case "A128GCM":
+		size = 16
+	case "A192GCM":
+		size = 24
+	case "A256GCM":
+		size = 32
+	default:
+		return nil, fmt.Errorf("unsupported content encryption %q", header.Enc)
+	}
+	if len(key) != size {
+		return nil, fmt.Errorf("%s needs a %d-byte key, got %d bytes", header.Enc, size, len(key))
+	}
+	if parts[1] != "" {
+		return nil, errors.New(`"dir" tokens must have an empty encrypted key`)
+	}
+	var seg [3][]byte
+	for i := range seg {
+		if seg[i], err = decodeSegment(parts[i+2]); err != nil {
+			return nil, err
+		}
+	}
+	iv, ct, tag := seg[0], seg[1], seg[2]
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	gcm, err := cipher.NewGCMWithNonceSize(block, len(iv))
+	if err != nil {
+		return nil, err
+	}
+	plain, err := gcm.Open(nil, iv, append(ct, tag...), []byte(parts[0]))
+	if err != nil {
+		return nil, errors.New("decryption failed: wrong key or corrupted token")
+	}
+	if header.Zip == "DEF" {
+		return io.ReadAll(flate.NewReader(bytes.NewReader(plain)))
+	}
+	return plain, nil
+}
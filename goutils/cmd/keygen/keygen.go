@@ -1,15 +1,31 @@
 // keygen - generate cryptographic keys, tokens, and secrets
+//
+// Key pairs are written as PKCS#8/PKIX PEM, OpenSSH (private key and
+// authorized_keys line) or JWK/JWKS, and existing keys in any of those
+// encodings can be fingerprinted or converted:
+//
+//	keygen ed25519 -f openssh -C deploy@ci -o id_ed25519
+//	keygen ecdsa p384 -f jwks
+//	keygen rsa 4096 -f openssh -N "$PASS" -o deploy_key
+//	keygen fingerprint -E md5 ~/.ssh/id_ed25519.pub
+//	keygen convert -f jwk -pub server.pem
 package main
 
 import (
 	"crypto/rand"
 	"encoding/base64"
 	"encoding/hex"
+	"errors"
+	"flag"
 	"fmt"
+	"io"
 	"math/big"
 	"os"
+	"os/user"
 	"strconv"
 	"strings"
+
+	"goutils/internal/cli"
 )
 
 const (
@@ -42,7 +58,26 @@ func usage() {
   human    [chars=20]     human-readable (no ambiguous chars)
   uuid                    random UUIDv4
   pin      [digits=6]     numeric PIN
-  passphrase [words=4]    word-list passphrase (EFF-style subset)`)
+  passphrase [words=4]    word-list passphrase (EFF-style subset)
+
+key pairs:
+  rsa      [bits=3072]    RSA key pair
+  ecdsa    [curve=p256]   ECDSA key pair (p256, p384 or p521)
+  ed25519                 Ed25519 key pair
+  fingerprint FILE...     SHA256 (or -E md5) fingerprints, ssh-keygen -l style
+  convert  FILE...        re-encode existing keys (PEM, OpenSSH, JWK/JWKS)
+
+key pair options:
+  -f FORMAT   pem (PKCS#8 + PKIX, default), openssh, jwk or jwks
+  -o FILE     write the private key to FILE and the public key to FILE.pub;
+              FILE must not exist yet
+  -force      overwrite FILE if it exists
+  -C COMMENT  OpenSSH key comment (default: user@host)
+  -N PASS     encrypt the OpenSSH private key (bcrypt-pbkdf, aes256-ctr)
+  -a ROUNDS   bcrypt-pbkdf rounds (default: 16)
+  -P PASS     passphrase of an input key (or $KEYGEN_PASS)
+  -pub        output only the public key
+  -E HASH     fingerprint hash: sha256 or md5`)
 	os.Exit(1)
 }
 
@@ -62,6 +97,14 @@ var wordList = []string{
 func main() {
 	if len(os.Args) < 2 { usage() }
 	typ := os.Args[1]
+	switch typ {
+	case "rsa", "ecdsa", "ed25519":
+		keyPairCmd(typ, os.Args[2:]); return
+	case "fingerprint":
+		fingerprintCmd(os.Args[2:]); return
+	case "convert":
+		convertCmd(os.Args[2:]); return
+	}
 	n := 0
 	if len(os.Args) > 2 { n, _ = strconv.Atoi(os.Args[2]) }
 
@@ -101,3 +144,200 @@ func main() {
 		fmt.Fprintf(os.Stderr, "keygen: unknown type %q\n", typ); usage()
 	}
 }
+
+type keyOptions struct {
+	fs         *flag.FlagSet
+	format     *string
+	out        *string
+	comment    *string
+	passphrase *string
+	rounds     *int
+	inPass     *string
+	pubOnly    *bool
+	force      *bool
+}
+
+func newKeyOptions(name string) *keyOptions {
+	fs := flag.NewFlagSet("keygen "+name, flag.ExitOnError)
+	fs.Usage = usage
+	return &keyOptions{
+		fs:         fs,
+		format:     fs.String("f", "pem", "output format"),
+		out:        fs.String("o", "", "output file"),
+		comment:    fs.String("C", "", "key comment"),
+		passphrase: fs.String("N", "", "new passphrase"),
+		rounds:     fs.Int("a", defaultRounds, "bcrypt-pbkdf rounds"),
+		inPass:     fs.String("P", os.Getenv("KEYGEN_PASS"), "input passphrase"),
+		pubOnly:    fs.Bool("pub", false, "public key only"),
+		force:      fs.Bool("force", false, "overwrite an existing output file"),
+	}
+}
+
+// parse accepts flags before, between and after the positional arguments,
+// so that both "keygen rsa 4096 -f openssh" and "keygen rsa -f openssh 4096"
+// work.
+func (o *keyOptions) parse(args []string) []string {
+	return cli.Parse(o.fs, args)
+}
+
+func fatal(err error) {
+	fmt.Fprintln(os.Stderr, "keygen:", err)
+	os.Exit(1)
+}
+
+func defaultComment() string {
+	name := os.Getenv("USER")
+	if u, err := user.Current(); err == nil {
+		name = u.Username
+	}
+	host, _ := os.Hostname()
+	return name + "@" + host
+}
+
+func keyPairCmd(typ string, args []string) {
+	o := newKeyOptions(typ)
+	pos := o.parse(args)
+	param := ""
+	if len(pos) > 0 {
+		param = pos[0]
+	}
+	priv, err := generate(typ, param)
+	if err != nil {
+		fatal(err)
+	}
+	comment := *o.comment
+	if comment == "" {
+		comment = defaultComment()
+	}
+	writeKeys(o, []*keyPair{{priv: priv, pub: priv.Public(), comment: comment}})
+}
+
+func convertCmd(args []string) {
+	o := newKeyOptions("convert")
+	files := o.parse(args)
+	if len(files) == 0 {
+		files = []string{"-"}
+	}
+	var keys []*keyPair
+	for _, f := range files {
+		ks, err := loadKeys(f, *o.inPass)
+		if err != nil {
+			fatal(err)
+		}
+		keys = append(keys, ks...)
+	}
+	if *o.comment != "" {
+		for _, k := range keys {
+			k.comment = *o.comment
+		}
+	}
+	writeKeys(o, keys)
+}
+
+// writeKeys encodes keys and either prints them or, with -o, saves the
+// private half to FILE and the public half to FILE.pub.
+func writeKeys(o *keyOptions, keys []*keyPair) {
+	if *o.pubOnly {
+		for _, k := range keys {
+			k.priv = nil
+		}
+	}
+	priv, pub, err := encodeKeys(keys, *o.format, *o.passphrase, *o.rounds)
+	if err != nil {
+		fatal(err)
+	}
+	if *o.out == "" {
+		os.Stdout.Write(priv)
+		// A private JWK already carries the public members.
+		if len(priv) == 0 || !strings.HasPrefix(*o.format, "jwk") {
+			os.Stdout.Write(pub)
+		}
+		return
+	}
+	// FILE may hold a key already, so it is only replaced with -force;
+	// FILE.pub goes with it, as with ssh-keygen.
+	pubFile := *o.out
+	if len(priv) > 0 {
+		if err := createFile(*o.out, priv, 0o600, *o.force); err != nil {
+			fatal(err)
+		}
+		fmt.Printf("Private key saved in %s\n", *o.out)
+		pubFile += ".pub"
+		err = os.WriteFile(pubFile, pub, 0o644)
+	} else {
+		err = createFile(pubFile, pub, 0o644, *o.force)
+	}
+	if err != nil {
+		fatal(err)
+	}
+	fmt.Printf("Public key saved in %s\n", pubFile)
+	for _, k := range keys {
+		fp, _ := fingerprint(k.pub, "sha256")
+		fmt.Printf("Fingerprint: %d %s %s (%s)\n", keyBits(k.pub), fp, k.comment, keyName(k.pub))
+	}
+}
+
+// createFile writes data to a new file name, failing if it exists unless
+// overwrite is set.
+func createFile(name string, data []byte, perm os.FileMode, overwrite bool) error {
+	flags := os.O_WRONLY | os.O_CREATE | os.O_EXCL
+	if overwrite {
+		flags = os.O_WRONLY | os.O_CREATE | os.O_TRUNC
+	}
+	f, err := os.OpenFile(name, flags, perm)
+	if errors.Is(err, os.ErrExist) {
+		return fmt.Errorf("%s already exists (use -force to overwrite it)", name)
+	}
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+func fingerprintCmd(args []string) {
+	o := newKeyOptions("fingerprint")
+	hash := o.fs.String("E", "sha256", "fingerprint hash")
+	files := o.parse(args)
+	if len(files) == 0 {
+		files = []string{"-"}
+	}
+	for _, f := range files {
+		keys, err := loadKeys(f, *o.inPass)
+		if err != nil {
+			fatal(err)
+		}
+		for _, k := range keys {
+			fp, err := fingerprint(k.pub, *hash)
+			if err != nil {
+				fatal(err)
+			}
+			comment := k.comment
+			if comment == "" {
+				comment = "no comment"
+			}
+			fmt.Printf("%d %s %s (%s)\n", keyBits(k.pub), fp, comment, keyName(k.pub))
+		}
+	}
+}
+
+func loadKeys(name, passphrase string) ([]*keyPair, error) {
+	var data []byte
+	var err error
+	if name == "-" {
+		data, err = io.ReadAll(os.Stdin)
+	} else {
+		data, err = os.ReadFile(name)
+	}
+	if err != nil {
+		return nil, err
+	}
+	keys, err := readKeys(data, passphrase)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", name, err)
+	}
+	return keys, nil
+}
@@ -0,0 +1,294 @@
+package main
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/md5"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"strings"
+
+	"goutils/internal/jwk"
+	"goutils/internal/x509util"
+)
+
+// keyPair is a private key with its public half, or just a public key when
+// priv is nil.
+type keyPair struct {
+	priv    crypto.Signer
+	pub     crypto.PublicKey
+	comment string
+}
+
+func generate(typ, param string) (crypto.Signer, error) {
+	switch typ {
+	case "rsa":
+		bits := 3072
+		if param != "" {
+			fmt.Sscan(param, &bits)
+		}
+		if bits < 2048 {
+			return nil, fmt.Errorf("RSA keys must be at least 2048 bits")
+		}
+		return rsa.GenerateKey(rand.Reader, bits)
+	case "ecdsa":
+		var curve elliptic.Curve
+		switch strings.TrimPrefix(strings.ToLower(param), "nist") {
+		case "", "p256", "p-256", "256":
+			curve = elliptic.P256()
+		case "p384", "p-384", "384":
+			curve = elliptic.P384()
+		case "p521", "p-521", "521":
+			curve = elliptic.P521()
+		default:
+			return nil, fmt.Errorf("unknown curve %q (want p256, p384 or p521)", param)
+		}
+		return ecdsa.GenerateKey(curve, rand.Reader)
+	case "ed25519":
+		_, k, err := ed25519.GenerateKey(rand.Reader)
+		return k, err
+	}
+	return nil, fmt.Errorf("unknown key type %q", typ)
+}
+
+// keyBits and keyName give the size and label ssh-keygen -l prints.
+func keyBits(pub crypto.PublicKey) int {
+	switch k := pub.(type) {
+	case *rsa.PublicKey:
+		return k.N.BitLen()
+	case *ecdsa.PublicKey:
+		return k.Curve.Params().BitSize
+	case ed25519.PublicKey:
+		return 256
+	}
+	return 0
+}
+
+func keyName(pub crypto.PublicKey) string {
+	switch pub.(type) {
+	case *rsa.PublicKey:
+		return "RSA"
+	case *ecdsa.PublicKey:
+		return "ECDSA"
+	case ed25519.PublicKey:
+		return "ED25519"
+	}
+	return "UNKNOWN"
+}
+
+// fingerprint formats the SSH fingerprint of pub as ssh-keygen does:
+// "SHA256:" and unpadded base64, or "MD5:" and colon-separated hex.
+func fingerprint(pub crypto.PublicKey, hash string) (string, error) {
+	blob, err := marshalSSHPublic(pub)
+	if err != nil {
+		return "", err
+	}
+	switch strings.ToLower(hash) {
+	case "sha256":
+		sum := sha256.Sum256(blob)
+		return "SHA256:" + base64.RawStdEncoding.EncodeToString(sum[:]), nil
+	case "md5":
+		sum := md5.Sum(blob)
+		hex := make([]string, len(sum))
+		for i, b := range sum {
+			hex[i] = fmt.Sprintf("%02x", b)
+		}
+		return "MD5:" + strings.Join(hex, ":"), nil
+	}
+	return "", fmt.Errorf("unknown fingerprint hash %q (want sha256 or md5)", hash)
+}
+
+// readKeys detects the encoding of data and returns every key in it:
+// PEM (PKCS#8, PKCS#1, SEC 1, encrypted PKCS#8, PKIX public keys,
+// certificates and OpenSSH private keys), authorized_keys lines, or a JWK
+// or JWK Set.
+func readKeys(data []byte, passphrase string) ([]*keyPair, error) {
+	trimmed := bytes.TrimSpace(data)
+	switch {
+	case bytes.HasPrefix(trimmed, []byte("{")):
+		return readJWKs(trimmed)
+	case bytes.Contains(trimmed, []byte("-----BEGIN ")):
+		return readPEM(trimmed, passphrase)
+	case len(trimmed) > 0 && trimmed[0] == 0x30:
+		k, err := x509util.ParsePrivateKey(trimmed)
+		if err != nil {
+			pub, perr := x509.ParsePKIXPublicKey(trimmed)
+			if perr != nil {
+				return nil, err
+			}
+			return []*keyPair{{pub: pub}}, nil
+		}
+		return signerKeys(k)
+	}
+	return parseAuthorizedKeys(trimmed)
+}
+
+// readJWKs reads one or more JWKs or JWK Sets from data.
+func readJWKs(data []byte) ([]*keyPair, error) {
+	jwks, err := jwk.Parse(data)
+	if err != nil {
+		return nil, err
+	}
+	var keys []*keyPair
+	for _, j := range jwks {
+		pub, priv, err := j.Keys()
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, &keyPair{priv: priv, pub: pub, comment: j.Kid})
+	}
+	return keys, nil
+}
+
+func readPEM(data []byte, passphrase string) ([]*keyPair, error) {
+	var keys []*keyPair
+	for {
+		var block *pem.Block
+		block, data = pem.Decode(data)
+		if block == nil {
+			break
+		}
+		var k crypto.PrivateKey
+		var pub crypto.PublicKey
+		var err error
+		switch block.Type {
+		case "OPENSSH PRIVATE KEY":
+			var kp *keyPair
+			if kp, err = parseOpenSSHPrivate(block.Bytes, passphrase); err == nil {
+				keys = append(keys, kp)
+			}
+		case "PRIVATE KEY", "RSA PRIVATE KEY", "EC PRIVATE KEY":
+			if _, encrypted := block.Headers["DEK-Info"]; encrypted {
+				err = errors.New("legacy PEM encryption is not supported; convert with openssl pkcs8 first")
+			} else {
+				k, err = x509util.ParsePrivateKey(block.Bytes)
+			}
+		case "ENCRYPTED PRIVATE KEY":
+			if passphrase == "" {
+				err = errors.New("key is encrypted; a passphrase is required")
+			} else {
+				k, err = x509util.DecryptPKCS8(block.Bytes, passphrase)
+			}
+		case "PUBLIC KEY":
+			pub, err = x509.ParsePKIXPublicKey(block.Bytes)
+		case "RSA PUBLIC KEY":
+			pub, err = x509.ParsePKCS1PublicKey(block.Bytes)
+		case "CERTIFICATE":
+			var c *x509.Certificate
+			if c, err = x509.ParseCertificate(block.Bytes); err == nil {
+				pub = c.PublicKey
+			}
+		default:
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", block.Type, err)
+		}
+		if k != nil {
+			kps, err := signerKeys(k)
+			if err != nil {
+				return nil, err
+			}
+			keys = append(keys, kps...)
+		}
+		if pub != nil {
+			keys = append(keys, &keyPair{pub: pub})
+		}
+	}
+	if len(keys) == 0 {
+		return nil, errors.New("no keys found")
+	}
+	return keys, nil
+}
+
+func signerKeys(k crypto.PrivateKey) ([]*keyPair, error) {
+	s, ok := k.(crypto.Signer)
+	if !ok {
+		return nil, fmt.Errorf("unsupported private key %T", k)
+	}
+	return []*keyPair{{priv: s, pub: s.Public()}}, nil
+}
+
+// encodeKeys renders keys in format, returning the private and public
+// halves separately. private is empty when none of the keys has one.
+func encodeKeys(keys []*keyPair, format, passphrase string, rounds int) (private, public []byte, err error) {
+	var priv, pub bytes.Buffer
+	var privSet, pubSet jwk.Set
+	for _, k := range keys {
+		switch format {
+		case "pem":
+			if passphrase != "" && k.priv != nil {
+				return nil, nil, errors.New("passphrase encryption needs -f openssh")
+			}
+			if k.priv != nil {
+				der, err := x509.MarshalPKCS8PrivateKey(k.priv)
+				if err != nil {
+					return nil, nil, err
+				}
+				pem.Encode(&priv, &pem.Block{Type: "PRIVATE KEY", Bytes: der})
+			}
+			der, err := x509.MarshalPKIXPublicKey(k.pub)
+			if err != nil {
+				return nil, nil, err
+			}
+			pem.Encode(&pub, &pem.Block{Type: "PUBLIC KEY", Bytes: der})
+		case "openssh", "ssh":
+			if k.priv != nil {
+				b, err := marshalOpenSSHPrivate(k.priv, k.comment, passphrase, rounds)
+				if err != nil {
+					return nil, nil, err
+				}
+				priv.Write(b)
+			}
+			line, err := authorizedKey(k.pub, k.comment)
+			if err != nil {
+				return nil, nil, err
+			}
+			pub.WriteString(line + "\n")
+		case "jwk", "jwks":
+			if passphrase != "" && k.priv != nil {
+				return nil, nil, errors.New("passphrase encryption needs -f openssh")
+			}
+			if k.priv != nil {
+				j, err := jwk.New(k.pub, k.priv)
+				if err != nil {
+					return nil, nil, err
+				}
+				privSet.Keys = append(privSet.Keys, j)
+			}
+			j, err := jwk.New(k.pub, nil)
+			if err != nil {
+				return nil, nil, err
+			}
+			pubSet.Keys = append(pubSet.Keys, j)
+		default:
+			return nil, nil, fmt.Errorf("unknown format %q (want pem, openssh, jwk or jwks)", format)
+		}
+	}
+	if format == "jwk" || format == "jwks" {
+		enc := func(buf *bytes.Buffer, set jwk.Set) {
+			if len(set.Keys) == 0 {
+				return
+			}
+			var v interface{} = set
+			if format == "jwk" && len(set.Keys) == 1 {
+				v = set.Keys[0]
+			}
+			b, _ := json.MarshalIndent(v, "", "  ")
+			buf.Write(append(b, '\n'))
+		}
+		enc(&priv, privSet)
+		enc(&pub, pubSet)
+	}
+	return priv.Bytes(), pub.Bytes(), nil
+}
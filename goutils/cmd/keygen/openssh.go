@@ -0,0 +1,426 @@
+package main
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"strings"
+)
+
+const (
+	opensshMagic  = "openssh-key-v1\x00"
+	opensshCipher = "aes256-ctr"
+	defaultRounds = 16
+)
+
+var errPassphrase = errors.New("incorrect passphrase")
+
+// sshWriter builds SSH wire-format data (RFC 4251 section 5).
+type sshWriter struct{ bytes.Buffer }
+
+func (w *sshWriter) uint32(v uint32) {
+	var b [4]byte
+	binary.BigEndian.PutUint32(b[:], v)
+	w.Write(b[:])
+}
+
+func (w *sshWriter) string(s []byte) {
+	w.uint32(uint32(len(s)))
+	w.Write(s)
+}
+
+func (w *sshWriter) mpint(n *big.Int) {
+	b := n.Bytes()
+	if len(b) > 0 && b[0]&0x80 != 0 {
+		b = append([]byte{0}, b...)
+	}
+	w.string(b)
+}
+
+// sshReader consumes SSH wire-format data, remembering the first error.
+type sshReader struct {
+	b   []byte
+	err error
+}
+
+func (r *sshReader) uint32() uint32 {
+	if r.err != nil || len(r.b) < 4 {
+		r.fail()
+		return 0
+	}
+	v := binary.BigEndian.Uint32(r.b)
+	r.b = r.b[4:]
+	return v
+}
+
+func (r *sshReader) string() []byte {
+	n := r.uint32()
+	if r.err != nil || uint64(n) > uint64(len(r.b)) {
+		r.fail()
+		return nil
+	}
+	s := r.b[:n]
+	r.b = r.b[n:]
+	return s
+}
+
+func (r *sshReader) mpint() *big.Int {
+	return new(big.Int).SetBytes(r.string())
+}
+
+func (r *sshReader) fail() {
+	if r.err == nil {
+		r.err = errors.New("truncated SSH key data")
+	}
+}
+
+// sshCurve maps between Go curves and their OpenSSH names.
+func sshCurve(c elliptic.Curve) (string, error) {
+	switch c {
+	case elliptic.P256():
+		return "nistp256", nil
+	case elliptic.P384():
+		return "nistp384", nil
+	case elliptic.P521():
+		return "nistp521", nil
+	}
+	return "", fmt.Errorf("unsupported curve %s", c.Params().Name)
+}
+
+func curveBySSHName(name string) (elliptic.Curve, error) {
+	switch name {
+	case "nistp256":
+		return elliptic.P256(), nil
+	case "nistp384":
+		return elliptic.P384(), nil
+	case "nistp521":
+		return elliptic.P521(), nil
+	}
+	return nil, fmt.Errorf("unsupported curve %q", name)
+}
+
+// sshKeyType returns the OpenSSH algorithm name for pub.
+func sshKeyType(pub crypto.PublicKey) (string, error) {
+	switch k := pub.(type) {
+	case *rsa.PublicKey:
+		return "ssh-rsa", nil
+	case *ecdsa.PublicKey:
+		curve, err := sshCurve(k.Curve)
+		if err != nil {
+			return "", err
+		}
+		return "ecdsa-sha2-" + curve, nil
+	case ed25519.PublicKey:
+		return "ssh-ed25519", nil
+	}
+	return "", fmt.Errorf("unsupported key type %T", pub)
+}
+
+// marshalSSHPublic encodes pub as an SSH public key blob, the data that
+// authorized_keys lines carry and fingerprints are computed over.
+func marshalSSHPublic(pub crypto.PublicKey) ([]byte, error) {
+	typ, err := sshKeyType(pub)
+	if err != nil {
+		return nil, err
+	}
+	var w sshWriter
+	w.string([]byte(typ))
+	switch k := pub.(type) {
+	case *rsa.PublicKey:
+		w.mpint(big.NewInt(int64(k.E)))
+		w.mpint(k.N)
+	case *ecdsa.PublicKey:
+		curve, _ := sshCurve(k.Curve)
+		w.string([]byte(curve))
+		w.string(elliptic.Marshal(k.Curve, k.X, k.Y))
+	case ed25519.PublicKey:
+		w.string(k)
+	}
+	return w.Bytes(), nil
+}
+
+func parseSSHPublic(blob []byte) (crypto.PublicKey, error) {
+	r := &sshReader{b: blob}
+	typ := string(r.string())
+	var pub crypto.PublicKey
+	switch {
+	case typ == "ssh-rsa":
+		e, n := r.mpint(), r.mpint()
+		if !e.IsInt64() {
+			return nil, errors.New("RSA exponent too large")
+		}
+		pub = &rsa.PublicKey{N: n, E: int(e.Int64())}
+	case strings.HasPrefix(typ, "ecdsa-sha2-"):
+		curve, err := curveBySSHName(string(r.string()))
+		if err != nil {
+			return nil, err
+		}
+		x, y := elliptic.Unmarshal(curve, r.string())
+		if x == nil && r.err == nil {
+			return nil, errors.New("invalid ECDSA point")
+		}
+		pub = &ecdsa.PublicKey{Curve: curve, X: x, Y: y}
+	case typ == "ssh-ed25519":
+		k := r.string()
+		if len(k) != ed25519.PublicKeySize && r.err == nil {
+			return nil, errors.New("invalid Ed25519 key length")
+		}
+		pub = ed25519.PublicKey(k)
+	default:
+		if r.err == nil {
+			return nil, fmt.Errorf("unsupported key type %q", typ)
+		}
+	}
+	return pub, r.err
+}
+
+// authorizedKey formats pub as an authorized_keys line.
+func authorizedKey(pub crypto.PublicKey, comment string) (string, error) {
+	blob, err := marshalSSHPublic(pub)
+	if err != nil {
+		return "", err
+	}
+	typ, _ := sshKeyType(pub)
+	line := typ + " " + base64.StdEncoding.EncodeToString(blob)
+	if comment != "" {
+		line += " " + comment
+	}
+	return line, nil
+}
+
+// parseAuthorizedKeys reads every key in authorized_keys (or .pub) data,
+// skipping blank lines, comments and any leading options field.
+func parseAuthorizedKeys(data []byte) ([]*keyPair, error) {
+	var keys []*keyPair
+	for n, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.Fields(line)
+		i := 0
+		for i < len(fields) && !isSSHKeyType(fields[i]) {
+			i++
+		}
+		if i+1 >= len(fields) {
+			return nil, fmt.Errorf("line %d: no public key found", n+1)
+		}
+		blob, err := base64.StdEncoding.DecodeString(fields[i+1])
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", n+1, err)
+		}
+		pub, err := parseSSHPublic(blob)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", n+1, err)
+		}
+		keys = append(keys, &keyPair{pub: pub, comment: strings.Join(fields[i+2:], " ")})
+	}
+	return keys, nil
+}
+
+func isSSHKeyType(s string) bool {
+	return s == "ssh-rsa" || s == "ssh-ed25519" || strings.HasPrefix(s, "ecdsa-sha2-")
+}
+
+// marshalOpenSSHPrivate encodes priv in the "openssh-key-v1" format that
+// ssh-keygen has written by default since OpenSSH 7.8. A non-empty
+// passphrase encrypts it with aes256-ctr under a bcrypt-pbkdf key.
+func marshalOpenSSHPrivate(priv crypto.Signer, comment, passphrase string, rounds int) ([]byte, error) {
+	pubBlob, err := marshalSSHPublic(priv.Public())
+	if err != nil {
+		return nil, err
+	}
+	typ, _ := sshKeyType(priv.Public())
+
+	var body sshWriter
+	var check [4]byte
+	rand.Read(check[:])
+	body.Write(check[:])
+	body.Write(check[:])
+	body.string([]byte(typ))
+	switch k := priv.(type) {
+	case *rsa.PrivateKey:
+		if len(k.Primes) != 2 {
+			return nil, errors.New("multi-prime RSA keys are not supported by OpenSSH")
+		}
+		k.Precompute()
+		body.mpint(k.N)
+		body.mpint(big.NewInt(int64(k.E)))
+		body.mpint(k.D)
+		body.mpint(k.Precomputed.Qinv)
+		body.mpint(k.Primes[0])
+		body.mpint(k.Primes[1])
+	case *ecdsa.PrivateKey:
+		curve, _ := sshCurve(k.Curve)
+		body.string([]byte(curve))
+		body.string(elliptic.Marshal(k.Curve, k.X, k.Y))
+		body.mpint(k.D)
+	case ed25519.PrivateKey:
+		body.string(k.Public().(ed25519.PublicKey))
+		body.string(k)
+	default:
+		return nil, fmt.Errorf("unsupported key type %T", priv)
+	}
+	body.string([]byte(comment))
+
+	cipherName, kdfName, kdfOpts, blockSize := "none", "none", []byte(nil), 8
+	if passphrase != "" {
+		cipherName, kdfName, blockSize = opensshCipher, "bcrypt", aes.BlockSize
+		salt := make([]byte, 16)
+		rand.Read(salt)
+		var opts sshWriter
+		opts.string(salt)
+		opts.uint32(uint32(rounds))
+		kdfOpts = opts.Bytes()
+	}
+	for i := 1; body.Len()%blockSize != 0; i++ {
+		body.WriteByte(byte(i))
+	}
+	private := body.Bytes()
+	if passphrase != "" {
+		stream, err := opensshStream(cipherName, kdfOpts, passphrase)
+		if err != nil {
+			return nil, err
+		}
+		stream.XORKeyStream(private, private)
+	}
+
+	var w sshWriter
+	w.WriteString(opensshMagic)
+	w.string([]byte(cipherName))
+	w.string([]byte(kdfName))
+	w.string(kdfOpts)
+	w.uint32(1)
+	w.string(pubBlob)
+	w.string(private)
+	return pem.EncodeToMemory(&pem.Block{Type: "OPENSSH PRIVATE KEY", Bytes: w.Bytes()}), nil
+}
+
+// parseOpenSSHPrivate decodes the body of an OPENSSH PRIVATE KEY block.
+func parseOpenSSHPrivate(data []byte, passphrase string) (*keyPair, error) {
+	if !bytes.HasPrefix(data, []byte(opensshMagic)) {
+		return nil, errors.New("not an openssh-key-v1 private key")
+	}
+	r := &sshReader{b: data[len(opensshMagic):]}
+	cipherName := string(r.string())
+	kdfName := string(r.string())
+	kdfOpts := r.string()
+	if n := r.uint32(); n != 1 && r.err == nil {
+		return nil, fmt.Errorf("files with %d keys are not supported", n)
+	}
+	r.string() // public key, repeated in the private section
+	private := append([]byte(nil), r.string()...)
+	if r.err != nil {
+		return nil, r.err
+	}
+
+	switch {
+	case cipherName == "none" && kdfName == "none":
+	case kdfName != "bcrypt":
+		return nil, fmt.Errorf("unsupported KDF %q", kdfName)
+	case passphrase == "":
+		return nil, errors.New("key is encrypted; a passphrase is required")
+	default:
+		stream, err := opensshStream(cipherName, kdfOpts, passphrase)
+		if err != nil {
+			return nil, err
+		}
+		if len(private)%aes.BlockSize != 0 {
+			return nil, errors.New("invalid encrypted key length")
+		}
+		stream.XORKeyStream(private, private)
+	}
+
+	r = &sshReader{b: private}
+	if c1, c2 := r.uint32(), r.uint32(); c1 != c2 {
+		return nil, errPassphrase
+	}
+	typ := string(r.string())
+	var priv crypto.Signer
+	switch {
+	case typ == "ssh-rsa":
+		n, e, d, _, p, q := r.mpint(), r.mpint(), r.mpint(), r.mpint(), r.mpint(), r.mpint()
+		if r.err != nil {
+			return nil, r.err
+		}
+		k := &rsa.PrivateKey{PublicKey: rsa.PublicKey{N: n, E: int(e.Int64())}, D: d, Primes: []*big.Int{p, q}}
+		if err := k.Validate(); err != nil {
+			return nil, err
+		}
+		k.Precompute()
+		priv = k
+	case strings.HasPrefix(typ, "ecdsa-sha2-"):
+		curve, err := curveBySSHName(string(r.string()))
+		if err != nil {
+			return nil, err
+		}
+		x, y := elliptic.Unmarshal(curve, r.string())
+		d := r.mpint()
+		if r.err != nil {
+			return nil, r.err
+		}
+		if x == nil {
+			return nil, errors.New("invalid ECDSA point")
+		}
+		priv = &ecdsa.PrivateKey{PublicKey: ecdsa.PublicKey{Curve: curve, X: x, Y: y}, D: d}
+	case typ == "ssh-ed25519":
+		r.string()
+		k := r.string()
+		if r.err != nil {
+			return nil, r.err
+		}
+		if len(k) != ed25519.PrivateKeySize {
+			return nil, errors.New("invalid Ed25519 key length")
+		}
+		priv = ed25519.PrivateKey(append([]byte(nil), k...))
+	default:
+		if r.err != nil {
+			return nil, r.err
+		}
+		return nil, fmt.Errorf("unsupported key type %q", typ)
+	}
+	comment := string(r.string())
+	if r.err != nil {
+		return nil, r.err
+	}
+	return &keyPair{priv: priv, pub: priv.Public(), comment: comment}, nil
+}
+
+// opensshStream derives the cipher for an encrypted private key from the
+// bcrypt kdfoptions (salt and rounds).
+func opensshStream(cipherName string, kdfOpts []byte, passphrase string) (cipher.Stream, error) {
+	var keyLen int
+	switch cipherName {
+	case "aes128-ctr":
+		keyLen = 16
+	case "aes192-ctr":
+		keyLen = 24
+	case "aes256-ctr":
+		keyLen = 32
+	default:
+		return nil, fmt.Errorf("unsupported cipher %q", cipherName)
+	}
+	r := &sshReader{b: kdfOpts}
+	salt, rounds := r.string(), r.uint32()
+	if r.err != nil {
+		return nil, r.err
+	}
+	k := bcryptPBKDF([]byte(passphrase), salt, int(rounds), keyLen+aes.BlockSize)
+	block, err := aes.NewCipher(k[:keyLen])
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewCTR(block, k[keyLen:]), nil
+}
@@ -8,6 +8,8 @@ import (
 	"regexp"
 	"strconv"
 	"strings"
+
+	"goutils/lib/term"
 )
 
 func usage() {
@@ -17,7 +19,8 @@ func usage() {
   -A <n>    lines after
   -i        case-insensitive
   -n        show line numbers
-  -c        count matches only`)
+  -c        count matches only
+  --color=WHEN  highlight matches: auto (default), always or never`)
 	os.Exit(1)
 }
 
@@ -27,7 +30,9 @@ func main() {
 	var pattern string
 	var files []string
 
-	args := os.Args[1:]
+	mode, args, err := term.ColorArgs(os.Args[1:])
+	if err != nil { fmt.Fprintln(os.Stderr, "kwsearch:", err); os.Exit(2) }
+	pal := mode.Profile(os.Stdout)
 	for i := 0; i < len(args); i++ {
 		switch args[i] {
 		case "-C": i++; before, _ = strconv.Atoi(args[i]); after = before
@@ -48,12 +53,6 @@ func main() {
 	re, err := regexp.Compile(reStr)
 	if err != nil { fmt.Fprintln(os.Stderr, "kwsearch:", err); os.Exit(1) }
 
-	const (
-		matchColor = "\033[1;31m"
-		ctxColor   = "\033[2m"
-		reset      = "\033[0m"
-	)
-
 	search := func(r *os.File, name string) int {
 		sc := bufio.NewScanner(r)
 		var lines []string
@@ -74,7 +73,7 @@ func main() {
 				if j == i {
 					prefix = "> "
 					lineStr = re.ReplaceAllStringFunc(lineStr, func(m string) string {
-						return matchColor + m + reset
+						return pal.Paint(m, term.Bold, term.Red)
 					})
 				}
 				if showNums {
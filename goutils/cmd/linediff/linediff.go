@@ -1,4 +1,7 @@
 // linediff - show side-by-side diff of two files with colour highlighting
+//
+// The columns split the terminal width; colour follows --color=WHEN
+// (auto by default, off for pipes and when NO_COLOR is set).
 package main
 
 import (
@@ -6,13 +9,8 @@ import (
 	"fmt"
 	"os"
 	"strings"
-)
 
-const (
-	red   = "\033[31m"
-	green = "\033[32m"
-	reset = "\033[0m"
-	dim   = "\033[2m"
+	"goutils/lib/term"
 )
 
 func readLines(path string) ([]string, error) {
@@ -57,25 +55,27 @@ func diff(a, b []string) []change {
 }
 
 func main() {
-	if len(os.Args) != 3 {
-		fmt.Fprintln(os.Stderr, "usage: linediff <file1> <file2>"); os.Exit(1)
+	mode, args, err := term.ColorArgs(os.Args[1:])
+	if err != nil || len(args) != 2 {
+		fmt.Fprintln(os.Stderr, "usage: linediff [--color=WHEN] <file1> <file2>"); os.Exit(1)
 	}
-	a, err := readLines(os.Args[1])
+	a, err := readLines(args[0])
 	if err != nil { fmt.Fprintln(os.Stderr, err); os.Exit(1) }
-	b, err2 := readLines(os.Args[2])
+	b, err2 := readLines(args[1])
 	if err2 != nil { fmt.Fprintln(os.Stderr, err2); os.Exit(1) }
+	pal := mode.Profile(os.Stdout)
 
 	changes := diff(a, b)
-	w := 60
-	fmt.Printf("%-*s │ %s\n", w, os.Args[1], os.Args[2])
+	w := max((term.Width(os.Stdout)-3)/2, 10)
+	cut := func(s string) string { return term.PadRight(term.Truncate(s, w, "..."), w) }
+	fmt.Printf("%s │ %s\n", cut(args[0]), term.Truncate(args[1], w, "..."))
 	fmt.Println(strings.Repeat("─", w) + "─┼─" + strings.Repeat("─", w))
 	for _, ch := range changes {
-		line := ch.line
-		if len(line) > w-3 { line = line[:w-3] + "..." }
+		line := term.Truncate(ch.line, w, "...")
 		switch ch.op {
-		case '=': fmt.Printf("%-*s │ %s\n", w, line, line)
-		case '-': fmt.Printf("%s%-*s%s │ %s(removed)%s\n", red, w, line, reset, dim, reset)
-		case '+': fmt.Printf("%-*s │ %s%s%s\n", w, dim+"(added)"+reset, green, line, reset)
+		case '=': fmt.Printf("%s │ %s\n", cut(line), line)
+		case '-': fmt.Printf("%s │ %s\n", pal.Paint(cut(line), term.Red), pal.Paint("(removed)", term.Dim))
+		case '+': fmt.Printf("%s │ %s\n", term.PadRight(pal.Paint("(added)", term.Dim), w), pal.Paint(line, term.Green))
 		}
 	}
 }
@@ -9,6 +9,8 @@ import (
 	"sort"
 	"strings"
 	"time"
+
+	"goutils/lib/term"
 )
 
 func parseLogfmt(line string) map[string]string {
@@ -56,7 +58,9 @@ func formatLogfmt(m map[string]string) string {
 func main() {
 	format := "pretty"
 	filter := ""
-	args := os.Args[1:]
+	mode, args, err := term.ColorArgs(os.Args[1:])
+	if err != nil { fmt.Fprintln(os.Stderr, "logfmt:", err); os.Exit(2) }
+	pal := mode.Profile(os.Stdout)
 	for i := 0; i < len(args); i++ {
 		switch args[i] {
 		case "-j", "--json": format = "json"
@@ -70,11 +74,10 @@ func main() {
 		"warn":"WARN","warning":"WARN",
 		"info":"INFO","debug":"DEBUG","trace":"TRACE",
 	}
-	levelColor := map[string]string{
-		"ERROR":"\033[31m","FATAL":"\033[1;31m","WARN":"\033[33m",
-		"INFO":"\033[32m","DEBUG":"\033[36m","TRACE":"\033[2m",
+	levelColor := map[string][]string{
+		"ERROR": {term.Red}, "FATAL": {term.Bold, term.Red}, "WARN": {term.Yellow},
+		"INFO": {term.Green}, "DEBUG": {term.Cyan}, "TRACE": {term.Dim},
 	}
-	reset := "\033[0m"
 
 	sc := bufio.NewScanner(os.Stdin)
 	for sc.Scan() {
@@ -102,10 +105,10 @@ func main() {
 			if cl, ok := colorLevel[strings.ToLower(m["level"])]; ok { level = cl }
 			msg := m["msg"]; if msg == "" { msg = m["message"] }
 			col := levelColor[level]
-			fmt.Printf("%s%s %-5s%s %s", col, ts, level, reset, msg)
+			fmt.Printf("%s %s", pal.Paint(fmt.Sprintf("%s %-5s", ts, level), col...), msg)
 			for k, v := range m {
 				if k == "time" || k == "ts" || k == "level" || k == "msg" || k == "message" { continue }
-				fmt.Printf("  %s\033[2m=%s%s", k, v, reset)
+				fmt.Printf("  %s%s", k, pal.Paint("="+v, term.Dim))
 			}
 			fmt.Println()
 		}
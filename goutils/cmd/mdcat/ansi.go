@@ -0,0 +1,434 @@
+package main
+
+import (
+	"strconv"
+	"strings"
+
+	"goutils/internal/markdown"
+	"goutils/lib/term"
+)
+
+// SGR parameters of the styles used.
+const (
+	sgrBold      = term.Bold
+	sgrDim       = term.Dim
+	sgrItalic    = term.Italic
+	sgrUnderline = term.Underline
+	sgrStrike    = term.Strike
+	sgrCode      = term.Cyan
+	sgrLink      = term.Blue
+	sgrHeading   = term.Magenta
+)
+
+// span is a piece of text in one style; a span with text "\n" is a hard
+// line break.
+type span struct {
+	text, style string
+}
+
+// line is a rendered line with its width in columns.
+type line struct {
+	text  string
+	width int
+}
+
+type renderer struct {
+	pal   term.Profile
+	color bool // pal is not term.NoColor
+}
+
+func textWidth(s string) int { return term.StringWidth(s) }
+
+func (r *renderer) style(s, sgr string) string {
+	if sgr == "" {
+		return s
+	}
+	return r.pal.Paint(s, sgr)
+}
+
+func (r *renderer) styled(s, sgr string) line { return line{r.style(s, sgr), textWidth(s)} }
+
+// blocks renders the children of n, with blank lines between them
+// unless tight is set.
+func (r *renderer) blocks(n *markdown.Node, width int, tight bool) []line {
+	var out []line
+	for c := n.FirstChild; c != nil; c = c.Next {
+		if len(out) > 0 && !tight {
+			out = append(out, line{})
+		}
+		out = append(out, r.block(c, width)...)
+	}
+	return out
+}
+
+func (r *renderer) block(n *markdown.Node, width int) []line {
+	switch n.Kind {
+	case markdown.Paragraph:
+		return r.wrap(r.inlines(n, ""), width)
+	case markdown.Heading:
+		sgr := sgrBold
+		switch n.Level {
+		case 1:
+			sgr = sgrBold + ";" + sgrUnderline + ";" + sgrHeading
+		case 2:
+			sgr = sgrBold + ";" + sgrHeading
+		}
+		spans := append([]span{{strings.Repeat("#", n.Level) + " ", sgr}}, r.inlines(n, sgr)...)
+		return r.wrap(spans, width)
+	case markdown.ThematicBreak:
+		return []line{r.styled(strings.Repeat("─", max(width, 3)), sgrDim)}
+	case markdown.CodeBlock:
+		var out []line
+		for _, l := range strings.Split(strings.TrimSuffix(n.Literal, "\n"), "\n") {
+			l = "    " + expandTabs(l)
+			out = append(out, r.styled(l, sgrCode))
+		}
+		return out
+	case markdown.HTMLBlock:
+		var out []line
+		for _, l := range strings.Split(n.Literal, "\n") {
+			out = append(out, r.styled(expandTabs(l), sgrDim))
+		}
+		return out
+	case markdown.BlockQuote:
+		bar := "> "
+		if r.color {
+			bar = "│ "
+		}
+		out := r.blocks(n, width-2, false)
+		for i, l := range out {
+			if l.width == 0 {
+				out[i] = line{r.style(bar[:len(bar)-1], sgrDim), 1}
+			} else {
+				out[i] = line{r.style(bar, sgrDim) + l.text, l.width + 2}
+			}
+		}
+		return out
+	case markdown.List:
+		return r.list(n, width)
+	case markdown.Table:
+		return r.table(n, width)
+	}
+	return nil
+}
+
+var bullets = []string{"•", "◦", "▪"}
+
+func (r *renderer) list(n *markdown.Node, width int) []line {
+	depth := 0
+	for p := n.Parent; p != nil; p = p.Parent {
+		if p.Kind == markdown.List {
+			depth++
+		}
+	}
+	count := 0
+	for c := n.FirstChild; c != nil; c = c.Next {
+		count++
+	}
+	markWidth := 2
+	if n.List.Ordered {
+		markWidth = len(strconv.Itoa(n.List.Start+count-1)) + 2
+	}
+	var out []line
+	num := n.List.Start
+	for item := n.FirstChild; item != nil; item = item.Next {
+		if len(out) > 0 && !n.List.Tight {
+			out = append(out, line{})
+		}
+		mark := bullets[depth%len(bullets)]
+		if n.List.Ordered {
+			mark = strconv.Itoa(num) + string(n.List.Delim)
+			num++
+		}
+		mark += strings.Repeat(" ", markWidth-textWidth(mark))
+		body := r.blocks(item, width-markWidth, n.List.Tight)
+		if len(body) == 0 {
+			body = []line{{}}
+		}
+		for i, l := range body {
+			prefix := strings.Repeat(" ", markWidth)
+			if i == 0 {
+				prefix = r.style(mark, sgrBold)
+			}
+			if l.width == 0 {
+				prefix = strings.TrimRight(prefix, " ")
+			}
+			out = append(out, line{prefix + l.text, markWidth + l.width})
+		}
+	}
+	return out
+}
+
+var inlineStyles = map[markdown.Kind]string{
+	markdown.Emph:          sgrItalic,
+	markdown.Strong:        sgrBold,
+	markdown.Strikethrough: sgrStrike,
+	markdown.Link:          sgrUnderline + ";" + sgrLink,
+}
+
+// inlines returns the styled spans of the inline content of n. Styles
+// are SGR parameter lists; base is the style of the enclosing block.
+func (r *renderer) inlines(n *markdown.Node, base string) []span {
+	var spans []span
+	styles := []string{base}
+	push := func(sgr string) {
+		cur := styles[len(styles)-1]
+		if cur != "" {
+			sgr = cur + ";" + sgr
+		}
+		styles = append(styles, sgr)
+	}
+	cur := func() string { return styles[len(styles)-1] }
+	for c := n.FirstChild; c != nil; c = c.Next {
+		c.Walk(func(c *markdown.Node, entering bool) bool {
+			switch c.Kind {
+			case markdown.Emph, markdown.Strong, markdown.Strikethrough, markdown.Link:
+				if !entering {
+					styles = styles[:len(styles)-1]
+					if c.Kind == markdown.Link && c.Dest != c.Text() && c.Dest != "mailto:"+c.Text() {
+						spans = append(spans, span{" (" + c.Dest + ")", sgrDim})
+					}
+					return true
+				}
+				push(inlineStyles[c.Kind])
+			case markdown.Image:
+				spans = append(spans, span{"[image: " + c.Text() + "]", cur()})
+				if c.Dest != "" {
+					spans = append(spans, span{" (" + c.Dest + ")", sgrDim})
+				}
+				return false
+			case markdown.Text:
+				spans = append(spans, span{c.Literal, cur()})
+			case markdown.Code:
+				text := c.Literal
+				if !r.color {
+					text = "`" + text + "`"
+				}
+				spans = append(spans, span{text, sgrCode})
+			case markdown.HTMLInline:
+				spans = append(spans, span{c.Literal, sgrDim})
+			case markdown.SoftBreak:
+				spans = append(spans, span{" ", cur()})
+			case markdown.LineBreak:
+				spans = append(spans, span{"\n", ""})
+			}
+			return true
+		})
+	}
+	return spans
+}
+
+// word is a run of spans without spaces between them.
+type word []span
+
+func (w word) width() int {
+	n := 0
+	for _, s := range w {
+		n += textWidth(s.text)
+	}
+	return n
+}
+
+// words splits spans at spaces. A nil word stands for a hard break.
+func words(spans []span) []word {
+	var ws []word
+	var cur word
+	flush := func() {
+		if len(cur) > 0 {
+			ws = append(ws, cur)
+			cur = nil
+		}
+	}
+	for _, s := range spans {
+		if s.text == "\n" {
+			flush()
+			ws = append(ws, nil)
+			continue
+		}
+		for i, f := range strings.Split(s.text, " ") {
+			if i > 0 {
+				flush()
+			}
+			if f != "" {
+				cur = append(cur, span{f, s.style})
+			}
+		}
+	}
+	flush()
+	return ws
+}
+
+// wrap fills words into lines no wider than width where possible.
+func (r *renderer) wrap(spans []span, width int) []line {
+	var out []line
+	var cur []span
+	w := 0
+	emit := func() {
+		out = append(out, line{r.join(cur), w})
+		cur, w = nil, 0
+	}
+	for _, wd := range words(spans) {
+		if wd == nil {
+			emit()
+			continue
+		}
+		ww := wd.width()
+		if w > 0 && w+1+ww > width {
+			emit()
+		}
+		if w > 0 {
+			// A space inside a styled run keeps its style.
+			sgr := ""
+			if last := cur[len(cur)-1].style; last == wd[0].style {
+				sgr = last
+			}
+			cur = append(cur, span{" ", sgr})
+			w++
+		}
+		cur = append(cur, wd...)
+		w += ww
+	}
+	if w > 0 || len(out) == 0 {
+		emit()
+	}
+	return out
+}
+
+// join renders spans, merging neighbours in the same style.
+func (r *renderer) join(spans []span) string {
+	var b strings.Builder
+	for i := 0; i < len(spans); {
+		j := i + 1
+		text := spans[i].text
+		for j < len(spans) && spans[j].style == spans[i].style {
+			text += spans[j].text
+			j++
+		}
+		b.WriteString(r.style(text, spans[i].style))
+		i = j
+	}
+	return b.String()
+}
+
+func expandTabs(s string) string {
+	if !strings.Contains(s, "\t") {
+		return s
+	}
+	var b strings.Builder
+	col := 0
+	for _, c := range s {
+		if c == '\t' {
+			n := 4 - col%4
+			b.WriteString(strings.Repeat(" ", n))
+			col += n
+			continue
+		}
+		b.WriteRune(c)
+		col++
+	}
+	return b.String()
+}
+
+// table draws a table with box-drawing characters, wrapping the cells of
+// the widest columns when it does not fit.
+func (r *renderer) table(n *markdown.Node, width int) []line {
+	var rows [][][]span
+	for row := n.FirstChild; row != nil; row = row.Next {
+		var cells [][]span
+		for cell := row.FirstChild; cell != nil; cell = cell.Next {
+			sgr := ""
+			if row.Header {
+				sgr = sgrBold
+			}
+			cells = append(cells, r.inlines(cell, sgr))
+		}
+		rows = append(rows, cells)
+	}
+	cols := len(n.Align)
+	widths := make([]int, cols)
+	minWidths := make([]int, cols)
+	for _, cells := range rows {
+		for i, c := range cells {
+			ws := words(c)
+			total := 0
+			for j, wd := range ws {
+				total += wd.width()
+				if j > 0 {
+					total++
+				}
+				minWidths[i] = max(minWidths[i], min(wd.width(), 10))
+			}
+			widths[i] = max(widths[i], total)
+		}
+	}
+	// Each column takes its width plus " | ", and the table one more.
+	for {
+		total := 1
+		widest := -1
+		for i, w := range widths {
+			total += w + 3
+			if w > minWidths[i] && (widest < 0 || w > widths[widest]) {
+				widest = i
+			}
+		}
+		if total <= width || widest < 0 {
+			break
+		}
+		widths[widest]--
+	}
+
+	border := func(left, mid, right string) line {
+		var b strings.Builder
+		b.WriteString(left)
+		for i, w := range widths {
+			if i > 0 {
+				b.WriteString(mid)
+			}
+			b.WriteString(strings.Repeat("─", w+2))
+		}
+		b.WriteString(right)
+		return r.styled(b.String(), sgrDim)
+	}
+	tableWidth := 1
+	for _, w := range widths {
+		tableWidth += w + 3
+	}
+	bar := r.style("│", sgrDim)
+	out := []line{border("┌", "┬", "┐")}
+	for ri, cells := range rows {
+		if ri == 1 {
+			out = append(out, border("├", "┼", "┤"))
+		}
+		wrapped := make([][]line, cols)
+		height := 1
+		for i := range wrapped {
+			if i < len(cells) {
+				wrapped[i] = r.wrap(cells[i], widths[i])
+			}
+			height = max(height, len(wrapped[i]))
+		}
+		for h := 0; h < height; h++ {
+			var b strings.Builder
+			b.WriteString(bar)
+			for i, w := range widths {
+				var l line
+				if h < len(wrapped[i]) {
+					l = wrapped[i][h]
+				}
+				pad := max(w-l.width, 0)
+				left := 0
+				switch n.Align[i] {
+				case markdown.AlignRight:
+					left = pad
+				case markdown.AlignCenter:
+					left = pad / 2
+				}
+				b.WriteString(" " + strings.Repeat(" ", left) + l.text + strings.Repeat(" ", pad-left) + " ")
+				b.WriteString(bar)
+			}
+			out = append(out, line{b.String(), tableWidth})
+		}
+	}
+	out = append(out, border("└", "┴", "┘"))
+	return out
+}
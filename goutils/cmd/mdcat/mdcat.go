@@ -0,0 +1,108 @@
+// mdcat - Render Markdown in the terminal, or convert it to HTML.
+//
+// Usage:
+//
+//	mdcat [OPTIONS] [FILE...]
+//
+// Parses each FILE (or standard input) as CommonMark with GitHub tables
+// and strikethrough, and writes it for reading in a terminal: headings,
+// emphasis, code and links in ANSI styles, paragraphs wrapped to the
+// width of the terminal, lists with bullets and numbers, quotations with
+// a bar, code blocks indented and tables drawn with box characters.
+//
+// Options:
+//
+//	-w N            Wrap at N columns (default: the terminal width, or 80)
+//	--html          Write HTML instead, with GitHub-style heading ids
+//	--color=WHEN    Style the output: auto (default), always or never; auto
+//	                styles a terminal unless NO_COLOR is set
+//	--no-color      Same as --color=never
+//
+// Without styles, code spans keep their backticks and quotations are
+// marked with "> ". Link targets follow the link text in parentheses.
+//
+// Examples:
+//
+//	mdcat README.md
+//	mdcat -w 72 CHANGES.md | less -R
+//	mdcat --html doc.md > doc.html
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+
+	"goutils/internal/cli"
+	"goutils/internal/markdown"
+	"goutils/lib/term"
+)
+
+var (
+	width     = flag.Int("w", 0, "wrap at `N` columns")
+	html      = flag.Bool("html", false, "write HTML")
+	colorMode = term.ColorFlag()
+
+	out = bufio.NewWriter(os.Stdout)
+)
+
+func usage() {
+	fmt.Fprintln(os.Stderr, "usage: mdcat [OPTIONS] [FILE...]")
+	flag.PrintDefaults()
+	os.Exit(2)
+}
+
+func main() {
+	flag.Usage = usage
+	files := cli.Parse(flag.CommandLine, os.Args[1:])
+	if *width < 0 {
+		usage()
+	}
+	if *width == 0 {
+		*width = term.Width(os.Stdout)
+	}
+	r := &renderer{pal: colorMode.Profile(os.Stdout)}
+	r.color = r.pal != term.NoColor
+
+	status := 0
+	first := true
+	run := func(f io.Reader, name string) {
+		b, err := io.ReadAll(f)
+		if err != nil {
+			out.Flush()
+			fmt.Fprintf(os.Stderr, "mdcat: %s: %v\n", name, err)
+			status = 1
+			return
+		}
+		doc := markdown.Parse(string(b))
+		if *html {
+			markdown.RenderHTML(out, doc, markdown.HTMLOptions{HeadingIDs: true})
+			return
+		}
+		if !first {
+			out.WriteString("\n")
+		}
+		first = false
+		for _, l := range r.blocks(doc, *width, false) {
+			out.WriteString(l.text + "\n")
+		}
+	}
+	if len(files) == 0 {
+		run(os.Stdin, "<stdin>")
+	}
+	for _, f := range files {
+		fh, err := os.Open(f)
+		if err != nil {
+			out.Flush()
+			fmt.Fprintf(os.Stderr, "mdcat: %v\n", err)
+			status = 1
+			continue
+		}
+		run(fh, f)
+		fh.Close()
+	}
+	out.Flush()
+	os.Exit(status)
+}
@@ -1,78 +1,678 @@
-// nc - Netcat: read/write TCP or UDP connections
-// Usage: nc [-l] [-u] [-p port] [host] [port]
+// nc - Netcat: read/write TCP, UDP or Unix socket connections
+//
+// Usage:
+//
+//	nc [OPTIONS] HOST PORT
+//	nc -l [OPTIONS] [-p PORT] [HOST] [PORT]
+//	nc -z [OPTIONS] HOST PORT[-PORT]...
+//	nc -U [OPTIONS] PATH
+//
+// Options:
+//
+//	-l          Listen for an incoming connection
+//	-k          Keep listening after a client disconnects (clients are served concurrently)
+//	-u          Use UDP instead of TCP
+//	-U          Use Unix domain sockets (HOST is the socket path)
+//	-p PORT     Local port (listen mode)
+//	-e PROG     Run PROG with its stdin/stdout attached to each connection
+//	-c CMD      Like -e, but run CMD through /bin/sh -c
+//	-z          Zero-I/O mode: scan ports and report which are open
+//	-w SECS     Connect and idle timeout
+//	-N          Shut down the write side of the socket after EOF on stdin
+//	-x ADDR     Connect through the proxy at ADDR (host:port)
+//	-X PROTO    Proxy protocol: "connect" (HTTP CONNECT) or "5" (SOCKS5, default)
+//	--ssl       Wrap the connection in TLS (listeners use a self-signed certificate)
+//	-v          Verbose output
+//
+// Examples:
+//
+//	nc example.com 80
+//	nc -N host 9000 < file.tar
+//	nc -lk -p 8080 -c 'echo hello'
+//	nc -zv -w 1 localhost 20-25 80 443
+//	nc -x proxy:1080 internal.host 22
+//	nc -U /run/app.sock
+//	nc -l --ssl -p 8443
 package main
 
 import (
+	"bufio"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/binary"
+	"errors"
 	"flag"
 	"fmt"
 	"io"
+	"math/big"
 	"net"
+	"net/http"
 	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
 )
 
 var (
-	listen  = flag.Bool("l", false, "Listen mode")
-	udp     = flag.Bool("u", false, "Use UDP instead of TCP")
-	port    = flag.String("p", "", "Local port (listen mode)")
-	verbose = flag.Bool("v", false, "Verbose output")
+	listen     = flag.Bool("l", false, "Listen mode")
+	keepOpen   = flag.Bool("k", false, "Keep listening for new connections")
+	udp        = flag.Bool("u", false, "Use UDP instead of TCP")
+	unixSock   = flag.Bool("U", false, "Use Unix domain sockets")
+	port       = flag.String("p", "", "Local port (listen mode)")
+	execProg   = flag.String("e", "", "Program to exec for each connection")
+	execShell  = flag.String("c", "", "Shell command to run for each connection")
+	scan       = flag.Bool("z", false, "Zero-I/O mode (port scan)")
+	waitSecs   = flag.Float64("w", 0, "Connect and idle timeout in seconds")
+	halfClose  = flag.Bool("N", false, "Shutdown the socket after EOF on stdin")
+	proxyAddr  = flag.String("x", "", "Proxy address (host:port)")
+	proxyProto = flag.String("X", "5", "Proxy protocol: connect or 5")
+	useTLS     = flag.Bool("ssl", false, "Use TLS")
+	verbose    = flag.Bool("v", false, "Verbose output")
 )
 
 func main() {
 	flag.Usage = func() {
-		fmt.Fprintln(os.Stderr, "Usage: nc [-l] [-u] [-v] [-p port] [host] [port]")
+		fmt.Fprintln(os.Stderr, "Usage: nc [-lkuUzNv] [-p port] [-e prog | -c cmd] [-w secs] [-x proxy -X connect|5] [--ssl] [host] [port]")
 		flag.PrintDefaults()
 	}
+	os.Args = append(os.Args[:1], splitShortFlags(os.Args[1:])...)
 	flag.Parse()
 
-	proto := "tcp"
-	if *udp {
-		proto = "udp"
+	var err error
+	switch {
+	case *scan:
+		err = runScan(flag.Args())
+	case *listen:
+		err = runListen(flag.Args())
+	default:
+		err = runConnect(flag.Args())
 	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "nc:", err)
+		os.Exit(1)
+	}
+}
 
-	if *listen {
-		addr := ":" + *port
-		if flag.NArg() > 0 {
-			addr = flag.Arg(0)
-			if flag.NArg() > 1 {
-				addr = flag.Arg(0) + ":" + flag.Arg(1)
+// splitShortFlags expands bundled boolean flags such as -lk or -zv into
+// separate arguments, since the flag package only accepts one per argument.
+func splitShortFlags(args []string) []string {
+	var out []string
+	for i, a := range args {
+		if a == "--" {
+			return append(out, args[i:]...)
+		}
+		if len(a) > 2 && a[0] == '-' && a[1] != '-' && strings.Trim(a[1:], "lkuUzNv") == "" {
+			for _, c := range a[1:] {
+				out = append(out, "-"+string(c))
 			}
+			continue
+		}
+		out = append(out, a)
+	}
+	return out
+}
+
+func network() string {
+	switch {
+	case *unixSock && *udp:
+		return "unixgram"
+	case *unixSock:
+		return "unix"
+	case *udp:
+		return "udp"
+	}
+	return "tcp"
+}
+
+func timeout() time.Duration {
+	return time.Duration(*waitSecs * float64(time.Second))
+}
+
+func logf(format string, a ...interface{}) {
+	if *verbose {
+		fmt.Fprintf(os.Stderr, format+"\n", a...)
+	}
+}
+
+// ---- client ----
+
+func runConnect(args []string) error {
+	var addr string
+	switch {
+	case *unixSock && len(args) == 1:
+		addr = args[0]
+	case !*unixSock && len(args) == 2:
+		addr = net.JoinHostPort(args[0], args[1])
+	default:
+		flag.Usage()
+		os.Exit(1)
+	}
+	conn, err := dial(addr)
+	if err != nil {
+		return err
+	}
+	logf("Connected to %s (%s)", addr, network())
+	return serve(conn)
+}
+
+func dial(addr string) (net.Conn, error) {
+	var conn net.Conn
+	var err error
+	if *proxyAddr != "" {
+		conn, err = dialProxy(addr)
+	} else {
+		d := net.Dialer{Timeout: timeout()}
+		conn, err = d.Dial(network(), addr)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if *useTLS {
+		host := addr
+		if h, _, err := net.SplitHostPort(addr); err == nil {
+			host = h
+		}
+		tc := tls.Client(conn, &tls.Config{ServerName: host, InsecureSkipVerify: true})
+		if t := timeout(); t > 0 {
+			tc.SetDeadline(time.Now().Add(t))
+		}
+		if err := tc.Handshake(); err != nil {
+			conn.Close()
+			return nil, err
+		}
+		tc.SetDeadline(time.Time{})
+		conn = tc
+	}
+	return withIdleTimeout(conn), nil
+}
+
+// ---- proxies ----
+
+func dialProxy(target string) (net.Conn, error) {
+	if *udp || *unixSock {
+		return nil, errors.New("proxying is only supported for TCP")
+	}
+	d := net.Dialer{Timeout: timeout()}
+	conn, err := d.Dial("tcp", *proxyAddr)
+	if err != nil {
+		return nil, err
+	}
+	if t := timeout(); t > 0 {
+		conn.SetDeadline(time.Now().Add(t))
+	}
+	switch strings.ToLower(*proxyProto) {
+	case "connect":
+		conn, err = httpConnect(conn, target)
+	case "5", "socks5":
+		err = socks5Connect(conn, target)
+	default:
+		err = fmt.Errorf("unsupported proxy protocol %q", *proxyProto)
+	}
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	conn.SetDeadline(time.Time{})
+	return conn, nil
+}
+
+// bufferedConn serves reads from a bufio.Reader that may already hold bytes
+// read past the end of a proxy handshake.
+type bufferedConn struct {
+	net.Conn
+	r *bufio.Reader
+}
+
+func (c *bufferedConn) Read(p []byte) (int, error) { return c.r.Read(p) }
+
+func (c *bufferedConn) CloseWrite() error { return closeWrite(c.Conn) }
+
+func httpConnect(conn net.Conn, target string) (net.Conn, error) {
+	fmt.Fprintf(conn, "CONNECT %s HTTP/1.1\r\nHost: %s\r\n\r\n", target, target)
+	br := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(br, &http.Request{Method: http.MethodConnect})
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("proxy: %s", resp.Status)
+	}
+	return &bufferedConn{Conn: conn, r: br}, nil
+}
+
+func socks5Connect(conn net.Conn, target string) error {
+	host, portStr, err := net.SplitHostPort(target)
+	if err != nil {
+		return err
+	}
+	p, err := strconv.Atoi(portStr)
+	if err != nil || p < 0 || p > 65535 {
+		return fmt.Errorf("invalid port %q", portStr)
+	}
+	if _, err := conn.Write([]byte{5, 1, 0}); err != nil {
+		return err
+	}
+	reply := make([]byte, 2)
+	if _, err := io.ReadFull(conn, reply); err != nil {
+		return err
+	}
+	if reply[0] != 5 || reply[1] != 0 {
+		return errors.New("socks5: no acceptable authentication method")
+	}
+
+	req := []byte{5, 1, 0}
+	if ip := net.ParseIP(host); ip != nil && ip.To4() != nil {
+		req = append(req, 1)
+		req = append(req, ip.To4()...)
+	} else if ip != nil {
+		req = append(req, 4)
+		req = append(req, ip.To16()...)
+	} else {
+		if len(host) > 255 {
+			return errors.New("socks5: hostname too long")
+		}
+		req = append(req, 3, byte(len(host)))
+		req = append(req, host...)
+	}
+	req = binary.BigEndian.AppendUint16(req, uint16(p))
+	if _, err := conn.Write(req); err != nil {
+		return err
+	}
+
+	head := make([]byte, 4)
+	if _, err := io.ReadFull(conn, head); err != nil {
+		return err
+	}
+	if head[1] != 0 {
+		return fmt.Errorf("socks5: connect failed (code %d)", head[1])
+	}
+	var skip int
+	switch head[3] {
+	case 1:
+		skip = 4
+	case 4:
+		skip = 16
+	case 3:
+		l := make([]byte, 1)
+		if _, err := io.ReadFull(conn, l); err != nil {
+			return err
 		}
-		if *verbose {
-			fmt.Fprintf(os.Stderr, "Listening on %s (%s)\n", addr, proto)
+		skip = int(l[0])
+	default:
+		return errors.New("socks5: bad address type in reply")
+	}
+	_, err = io.ReadFull(conn, make([]byte, skip+2))
+	return err
+}
+
+// ---- listener ----
+
+func runListen(args []string) error {
+	addr := ":" + *port
+	switch {
+	case *unixSock:
+		if len(args) != 1 {
+			return errors.New("-U -l requires a socket path")
 		}
-		ln, err := net.Listen(proto, addr)
+		addr = args[0]
+	case len(args) == 1 && *port == "":
+		addr = ":" + args[0]
+	case len(args) == 1:
+		addr = net.JoinHostPort(args[0], *port)
+	case len(args) >= 2:
+		addr = net.JoinHostPort(args[0], args[1])
+	}
+
+	if network() == "udp" || network() == "unixgram" {
+		return listenPacket(addr)
+	}
+
+	ln, err := net.Listen(network(), addr)
+	if err != nil {
+		return err
+	}
+	defer ln.Close()
+	if *useTLS {
+		cfg, err := selfSignedConfig()
 		if err != nil {
-			fmt.Fprintln(os.Stderr, "nc:", err)
-			os.Exit(1)
+			return err
 		}
+		ln = tls.NewListener(ln, cfg)
+	}
+	logf("Listening on %s (%s)", ln.Addr(), network())
+
+	if !*keepOpen {
 		conn, err := ln.Accept()
 		if err != nil {
-			fmt.Fprintln(os.Stderr, "nc:", err)
-			os.Exit(1)
+			return err
 		}
-		if *verbose {
-			fmt.Fprintf(os.Stderr, "Connection from %s\n", conn.RemoteAddr())
+		logf("Connection from %s", conn.RemoteAddr())
+		return serve(withIdleTimeout(conn))
+	}
+
+	var b *broadcaster
+	if *execProg == "" && *execShell == "" {
+		b = newBroadcaster()
+		go b.run(os.Stdin)
+	}
+	// Errors such as EMFILE or ECONNABORTED pass, so they are reported
+	// and retried after a pause that grows, as net/http does; only a
+	// closed listener ends the loop.
+	var delay time.Duration
+	for {
+		conn, err := ln.Accept()
+		if errors.Is(err, net.ErrClosed) {
+			return nil
 		}
-		go io.Copy(conn, os.Stdin)
-		io.Copy(os.Stdout, conn)
-		conn.Close()
-	} else {
-		if flag.NArg() < 2 {
-			flag.Usage()
-			os.Exit(1)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "nc: accept: %v\n", err)
+			delay = min(max(2*delay, 5*time.Millisecond), time.Second)
+			time.Sleep(delay)
+			continue
+		}
+		delay = 0
+		logf("Connection from %s", conn.RemoteAddr())
+		conn = withIdleTimeout(conn)
+		go func() {
+			var err error
+			if b != nil {
+				b.add(conn)
+				_, err = io.Copy(os.Stdout, conn)
+				b.remove(conn)
+				conn.Close()
+			} else {
+				err = serve(conn)
+			}
+			if err != nil {
+				logf("nc: %s: %v", conn.RemoteAddr(), err)
+			}
+		}()
+	}
+}
+
+// listenPacket handles UDP and unixgram listeners: the first peer to send a
+// datagram becomes the destination for data read from stdin.
+func listenPacket(addr string) error {
+	pc, err := net.ListenPacket(network(), addr)
+	if err != nil {
+		return err
+	}
+	defer pc.Close()
+	logf("Listening on %s (%s)", pc.LocalAddr(), network())
+
+	var mu sync.Mutex
+	var peer net.Addr
+	go func() {
+		buf := make([]byte, 64*1024)
+		for {
+			n, err := os.Stdin.Read(buf)
+			mu.Lock()
+			to := peer
+			mu.Unlock()
+			if n > 0 && to != nil {
+				pc.WriteTo(buf[:n], to)
+			}
+			if err != nil {
+				return
+			}
+		}
+	}()
+	buf := make([]byte, 64*1024)
+	for {
+		if t := timeout(); t > 0 {
+			pc.SetReadDeadline(time.Now().Add(t))
+		}
+		n, from, err := pc.ReadFrom(buf)
+		if err != nil {
+			return err
+		}
+		mu.Lock()
+		if peer == nil {
+			logf("Connection from %s", from)
+		}
+		peer = from
+		mu.Unlock()
+		os.Stdout.Write(buf[:n])
+	}
+}
+
+// broadcaster fans stdin out to every client connected in -k mode.
+type broadcaster struct {
+	mu    sync.Mutex
+	conns map[net.Conn]bool
+}
+
+func newBroadcaster() *broadcaster {
+	return &broadcaster{conns: map[net.Conn]bool{}}
+}
+
+func (b *broadcaster) add(c net.Conn) {
+	b.mu.Lock()
+	b.conns[c] = true
+	b.mu.Unlock()
+}
+
+func (b *broadcaster) remove(c net.Conn) {
+	b.mu.Lock()
+	delete(b.conns, c)
+	b.mu.Unlock()
+}
+
+func (b *broadcaster) run(r io.Reader) {
+	buf := make([]byte, 32*1024)
+	for {
+		n, err := r.Read(buf)
+		if n > 0 {
+			b.mu.Lock()
+			for c := range b.conns {
+				c.Write(buf[:n])
+			}
+			b.mu.Unlock()
+		}
+		if err != nil {
+			return
+		}
+	}
+}
+
+func selfSignedConfig() (*tls.Config, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return nil, err
+	}
+	host, _ := os.Hostname()
+	tmpl := &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: "nc"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(24 * time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		DNSNames:     []string{"localhost", host},
+		IPAddresses:  []net.IP{net.IPv4(127, 0, 0, 1), net.IPv6loopback},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		return nil, err
+	}
+	cert := tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}
+	return &tls.Config{Certificates: []tls.Certificate{cert}}, nil
+}
+
+// ---- data transfer ----
+
+// serve connects conn to either the -e/-c program or stdin/stdout.
+func serve(conn net.Conn) error {
+	defer conn.Close()
+	if *execProg != "" || *execShell != "" {
+		var cmd *exec.Cmd
+		if *execShell != "" {
+			cmd = exec.Command("/bin/sh", "-c", *execShell)
+		} else {
+			f := strings.Fields(*execProg)
+			cmd = exec.Command(f[0], f[1:]...)
+		}
+		// Feed stdin through a pipe rather than handing conn to exec: Wait
+		// would otherwise block on the copy goroutine until the peer sends
+		// EOF, keeping the connection open after the program has exited.
+		stdin, err := cmd.StdinPipe()
+		if err != nil {
+			return err
+		}
+		cmd.Stdout = conn
+		cmd.Stderr = os.Stderr
+		if err := cmd.Start(); err != nil {
+			return err
 		}
-		host, p := flag.Arg(0), flag.Arg(1)
-		addr := host + ":" + p
-		if *verbose {
-			fmt.Fprintf(os.Stderr, "Connecting to %s (%s)\n", addr, proto)
+		go func() {
+			io.Copy(stdin, conn)
+			stdin.Close()
+		}()
+		return cmd.Wait()
+	}
+
+	done := make(chan error, 1)
+	go func() {
+		_, err := io.Copy(conn, os.Stdin)
+		if *halfClose {
+			closeWrite(conn)
+		}
+		if err != nil {
+			done <- err
+		}
+	}()
+	go func() {
+		_, err := io.Copy(os.Stdout, conn)
+		done <- err
+	}()
+	err := <-done
+	if isTimeout(err) {
+		logf("nc: idle timeout")
+		return nil
+	}
+	return err
+}
+
+func closeWrite(c net.Conn) error {
+	if cw, ok := c.(interface{ CloseWrite() error }); ok {
+		return cw.CloseWrite()
+	}
+	return nil
+}
+
+func isTimeout(err error) bool {
+	var ne net.Error
+	return errors.As(err, &ne) && ne.Timeout()
+}
+
+// idleConn pushes the deadline forward on every read and write so that -w
+// only fires after a period with no traffic in either direction.
+type idleConn struct {
+	net.Conn
+	idle time.Duration
+}
+
+func withIdleTimeout(c net.Conn) net.Conn {
+	if t := timeout(); t > 0 {
+		return &idleConn{Conn: c, idle: t}
+	}
+	return c
+}
+
+func (c *idleConn) Read(p []byte) (int, error) {
+	c.Conn.SetDeadline(time.Now().Add(c.idle))
+	return c.Conn.Read(p)
+}
+
+func (c *idleConn) Write(p []byte) (int, error) {
+	c.Conn.SetDeadline(time.Now().Add(c.idle))
+	return c.Conn.Write(p)
+}
+
+func (c *idleConn) CloseWrite() error { return closeWrite(c.Conn) }
+
+// ---- port scanning ----
+
+func runScan(args []string) error {
+	if len(args) < 2 {
+		flag.Usage()
+		os.Exit(1)
+	}
+	host := args[0]
+	var ports []int
+	for _, spec := range args[1:] {
+		p, err := parsePorts(spec)
+		if err != nil {
+			return err
+		}
+		ports = append(ports, p...)
+	}
+
+	t := timeout()
+	if t == 0 {
+		t = 5 * time.Second
+	}
+	anyOpen := false
+	for _, p := range ports {
+		addr := net.JoinHostPort(host, strconv.Itoa(p))
+		var conn net.Conn
+		var err error
+		if *proxyAddr != "" {
+			conn, err = dialProxy(addr)
+		} else {
+			conn, err = net.DialTimeout(network(), addr, t)
 		}
-		conn, err := net.Dial(proto, addr)
 		if err != nil {
-			fmt.Fprintln(os.Stderr, "nc:", err)
-			os.Exit(1)
+			logf("nc: connect to %s port %d (%s) failed: %v", host, p, network(), err)
+			continue
 		}
-		defer conn.Close()
-		go io.Copy(conn, os.Stdin)
-		io.Copy(os.Stdout, conn)
+		if *udp {
+			// UDP is connectionless; a probe only fails if an ICMP
+			// unreachable comes back before the timeout.
+			conn.SetDeadline(time.Now().Add(t))
+			conn.Write([]byte{})
+			if _, err := conn.Read(make([]byte, 1)); err != nil && !isTimeout(err) {
+				conn.Close()
+				logf("nc: connect to %s port %d (udp) failed: %v", host, p, err)
+				continue
+			}
+		}
+		conn.Close()
+		anyOpen = true
+		logf("Connection to %s %d port [%s/*] succeeded!", host, p, network())
+	}
+	if !anyOpen {
+		os.Exit(1)
+	}
+	return nil
+}
+
+func parsePorts(spec string) ([]int, error) {
+	lo, hi, isRange := strings.Cut(spec, "-")
+	a, err := strconv.Atoi(lo)
+	if err != nil || a < 1 || a > 65535 {
+		return nil, fmt.Errorf("invalid port %q", spec)
+	}
+	b := a
+	if isRange {
+		b, err = strconv.Atoi(hi)
+		if err != nil || b < a || b > 65535 {
+			return nil, fmt.Errorf("invalid port range %q", spec)
+		}
+	}
+	ports := make([]int, 0, b-a+1)
+	for p := a; p <= b; p++ {
+		ports = append(ports, p)
 	}
+	return ports, nil
 }
@@ -8,6 +8,8 @@ import (
 	"os/exec"
 	"os/signal"
 	"syscall"
+
+	"goutils/lib/term"
 )
 
 func main() {
@@ -21,7 +23,7 @@ func main() {
 
 	// Redirect stdout to nohup.out if it's a terminal
 	outFile := os.Stdout
-	if isTerminal(os.Stdout.Fd()) {
+	if term.IsTerminal(os.Stdout) {
 		f, err := os.OpenFile("nohup.out", os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
 		if err != nil {
 			// Try home directory
@@ -50,8 +52,3 @@ func main() {
 		os.Exit(1)
 	}
 }
-
-func isTerminal(fd uintptr) bool {
-	_, _, err := syscall.Syscall(syscall.SYS_IOCTL, fd, syscall.TIOCGWINSZ, 0)
-	return err == 0
-}
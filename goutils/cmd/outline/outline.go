@@ -1,68 +1,386 @@
-// outline - extract heading structure from Markdown or plain text (outline view)
+// outline - Show the heading structure of Markdown documents, write a
+// table of contents, or check links and headings.
+//
+// Usage:
+//
+//	outline [OPTIONS] [FILE...]
+//
+// By default each heading is printed with its section number, indented
+// by level ("1.2. Title"). Headings are found by a CommonMark parser, so
+// '#' lines in code blocks are not headings and setext headings are.
+//
+// Options:
+//
+//	-f, -flat       Print headings as "## Title" without numbers
+//	-h1, -h2, -h3   Only show headings down to this level
+//	-t, -toc        Print a Markdown table of contents linking to the headings
+//	-i              Update the table of contents of each FILE in place
+//	-l, -lint       Check links and headings instead
+//
+// The table of contents is a nested list of links to the anchors GitHub
+// gives the headings. With -i it replaces the lines between
+// "<!-- toc -->" and "<!-- /toc -->" (or "<!-- tocstop -->"); if there is
+// no end marker one is added. When a document has a start marker, the
+// table of contents lists only the headings after it, leaving out a
+// title above it.
+//
+// -lint reports, as FILE:LINE: MESSAGE,
+//
+//   - relative links and images whose file does not exist
+//   - links to anchors that no heading or id attribute defines, in the
+//     same document or in another Markdown file
+//   - headings that skip a level, such as an h4 directly under an h2
+//   - headings whose anchors collide, so that only the first is reachable
+//     by its plain anchor
+//
+// Relative links are resolved against the directory of the document; on
+// standard input, against the current directory. The exit status is 1 if
+// problems are found.
+//
+// Examples:
+//
+//	outline README.md
+//	outline -t -h2 docs/guide.md
+//	outline -i README.md CONTRIBUTING.md
+//	outline -lint docs/*.md
 package main
 
 import (
 	"bufio"
+	"flag"
 	"fmt"
+	"io"
+	"net/url"
 	"os"
+	"path/filepath"
 	"regexp"
+	"sort"
 	"strings"
+
+	"goutils/internal/cli"
+	"goutils/internal/markdown"
 )
 
-var headingRe = regexp.MustCompile(`^(#{1,6})\s+(.+)`)
-var numberedRe = regexp.MustCompile(`^(\s*)(\d+[.)]\s+|\*\s+|-\s+)(.+)`)
+var (
+	flat    = flag.Bool("f", false, "print headings without numbers")
+	h1      = flag.Bool("h1", false, "only show level 1 headings")
+	h2      = flag.Bool("h2", false, "only show headings down to level 2")
+	h3      = flag.Bool("h3", false, "only show headings down to level 3")
+	toc     = flag.Bool("t", false, "print a table of contents")
+	inPlace = flag.Bool("i", false, "update the table of contents of each FILE in place")
+	lint    = flag.Bool("l", false, "check links and headings")
 
-func main() {
-	flat := false
-	maxLevel := 6
-	var files []string
-	for _, arg := range os.Args[1:] {
-		switch arg {
-		case "-f", "--flat": flat = true
-		case "--h1": maxLevel = 1
-		case "--h2": maxLevel = 2
-		case "--h3": maxLevel = 3
-		default:
-			if !strings.HasPrefix(arg, "-") { files = append(files, arg) }
-		}
-	}
-
-	process := func(r *os.File) {
-		sc := bufio.NewScanner(r)
-		counters := make([]int, 7)
-		for sc.Scan() {
-			line := sc.Text()
-			m := headingRe.FindStringSubmatch(line)
-			if m == nil { continue }
-			level := len(m[1])
-			if level > maxLevel { continue }
-			text := strings.TrimSpace(m[2])
-			// strip markdown formatting from heading
-			text = regexp.MustCompile(`\*\*?|__?|~~|` + "`").ReplaceAllString(text, "")
-			text = regexp.MustCompile(`\[([^\]]+)\]\([^)]+\)`).ReplaceAllString(text, "$1")
-
-			if flat {
-				fmt.Printf("%s %s\n", strings.Repeat("#", level), text)
-				continue
+	maxLevel = 6
+	out      = bufio.NewWriter(os.Stdout)
+)
+
+func init() {
+	flag.BoolVar(flat, "flat", false, "same as -f")
+	flag.BoolVar(toc, "toc", false, "same as -t")
+	flag.BoolVar(lint, "lint", false, "same as -l")
+}
+
+func usage() {
+	fmt.Fprintln(os.Stderr, "usage: outline [OPTIONS] [FILE...]")
+	flag.PrintDefaults()
+	os.Exit(2)
+}
+
+var (
+	reTOCStart = regexp.MustCompile(`(?i)^<!--\s*toc\s*-->$`)
+	reTOCEnd   = regexp.MustCompile(`(?i)^<!--\s*(?:/toc|tocstop)\s*-->$`)
+	reHTMLID   = regexp.MustCompile(`(?i)\b(?:id|name)\s*=\s*["']?([^"'\s>]+)`)
+	reScheme   = regexp.MustCompile(`^[A-Za-z][A-Za-z0-9+.-]*:`)
+)
+
+// document is a parsed Markdown file.
+type document struct {
+	src     string
+	root    *markdown.Node
+	anchors []string // the anchor of each heading, in order
+}
+
+func parse(src string) *document {
+	d := &document{src: src, root: markdown.Parse(src)}
+	a := markdown.Anchors{}
+	for _, h := range markdown.Headings(d.root) {
+		d.anchors = append(d.anchors, a.Add(h.Text()))
+	}
+	return d
+}
+
+// outline prints the numbered or flat list of headings.
+func (d *document) outline() {
+	counters := make([]int, 7)
+	for _, h := range markdown.Headings(d.root) {
+		level, text := h.Level, h.Text()
+		if level > maxLevel {
+			continue
+		}
+		if *flat {
+			fmt.Fprintf(out, "%s %s\n", strings.Repeat("#", level), text)
+			continue
+		}
+		counters[level]++
+		for i := level + 1; i <= 6; i++ {
+			counters[i] = 0
+		}
+		var parts []string
+		for i := 1; i <= level; i++ {
+			if counters[i] > 0 {
+				parts = append(parts, fmt.Sprint(counters[i]))
 			}
+		}
+		fmt.Fprintf(out, "%s%s. %s\n", strings.Repeat("  ", level-1), strings.Join(parts, "."), text)
+	}
+}
+
+// tocMarkers returns the blocks holding the start and end markers of the
+// table of contents, or nil.
+func (d *document) tocMarkers() (start, end *markdown.Node) {
+	d.root.Walk(func(n *markdown.Node, entering bool) bool {
+		if !entering || n.Kind != markdown.HTMLBlock {
+			return entering
+		}
+		lit := strings.TrimSpace(n.Literal)
+		switch {
+		case start == nil && reTOCStart.MatchString(lit):
+			start = n
+		case start != nil && end == nil && reTOCEnd.MatchString(lit):
+			end = n
+		}
+		return true
+	})
+	return start, end
+}
 
-			// Update counters
-			counters[level]++
-			for i := level + 1; i <= 6; i++ { counters[i] = 0 }
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `[`, `\[`, `]`, `\]`)
 
-			// Build number
-			var parts []string
-			for i := 1; i <= level; i++ { if counters[i] > 0 { parts = append(parts, fmt.Sprintf("%d", counters[i])) } }
-			num := strings.Join(parts, ".")
+// tocLines returns the table of contents of the headings after line.
+func (d *document) tocLines(after int) []string {
+	hs := markdown.Headings(d.root)
+	minLevel := 7
+	for _, h := range hs {
+		if h.Line > after && h.Level <= maxLevel {
+			minLevel = min(minLevel, h.Level)
+		}
+	}
+	var lines []string
+	for i, h := range hs {
+		if h.Line <= after || h.Level > maxLevel {
+			continue
+		}
+		lines = append(lines, fmt.Sprintf("%s- [%s](#%s)", strings.Repeat("  ", h.Level-minLevel),
+			labelEscaper.Replace(h.Text()), d.anchors[i]))
+	}
+	return lines
+}
 
-			indent := strings.Repeat("  ", level-1)
-			fmt.Printf("%s%s. %s\n", indent, num, text)
+// updateTOC returns the document with its table of contents replaced.
+func (d *document) updateTOC() (string, error) {
+	start, end := d.tocMarkers()
+	if start == nil {
+		return "", fmt.Errorf("no <!-- toc --> marker")
+	}
+	eol := "\n"
+	if strings.Contains(d.src, "\r\n") {
+		eol = "\r\n"
+	}
+	lines := strings.Split(strings.ReplaceAll(d.src, "\r\n", "\n"), "\n")
+	after := start.EndLine
+	if end != nil {
+		after = end.EndLine
+	}
+	repl := append([]string{lines[start.Line-1], ""}, d.tocLines(after)...)
+	rest := lines[start.EndLine:]
+	if end != nil {
+		repl = append(repl, "", lines[end.Line-1])
+		rest = lines[end.EndLine:]
+	} else {
+		repl = append(repl, "", "<!-- /toc -->")
+		if len(rest) > 0 && strings.TrimSpace(rest[0]) != "" {
+			repl = append(repl, "")
 		}
 	}
+	lines = append(append(lines[:start.Line-1:start.Line-1], repl...), rest...)
+	return strings.Join(lines, eol), nil
+}
+
+// targets caches the anchors of the Markdown files links point to.
+var targets = map[string]map[string]bool{}
+
+// ids returns the anchors defined in d: those of its headings and the id
+// and name attributes in its HTML.
+func (d *document) ids() map[string]bool {
+	ids := map[string]bool{}
+	for _, a := range d.anchors {
+		ids[a] = true
+	}
+	d.root.Walk(func(n *markdown.Node, entering bool) bool {
+		if entering && (n.Kind == markdown.HTMLBlock || n.Kind == markdown.HTMLInline) {
+			for _, m := range reHTMLID.FindAllStringSubmatch(n.Literal, -1) {
+				ids[m[1]] = true
+			}
+		}
+		return true
+	})
+	return ids
+}
 
-	if len(files) == 0 { process(os.Stdin); return }
+func anchorsOf(path string) (map[string]bool, error) {
+	if ids, ok := targets[path]; ok {
+		return ids, nil
+	}
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	ids := parse(string(b)).ids()
+	targets[path] = ids
+	return ids, nil
+}
+
+type problem struct {
+	line int
+	msg  string
+}
+
+// lint returns the problems found in d.
+func (d *document) lint(dir string) []problem {
+	var probs []problem
+	report := func(line int, format string, args ...interface{}) {
+		probs = append(probs, problem{line, fmt.Sprintf(format, args...)})
+	}
+
+	seen := map[string]int{}
+	prev := 0
+	for _, h := range markdown.Headings(d.root) {
+		if prev > 0 && h.Level > prev+1 {
+			report(h.Line, "heading level jumps from h%d to h%d", prev, h.Level)
+		}
+		prev = h.Level
+		slug := markdown.Slug(h.Text())
+		if slug == "" {
+			continue
+		}
+		if first, ok := seen[slug]; ok {
+			report(h.Line, "duplicate anchor #%s (first used on line %d)", slug, first)
+		} else {
+			seen[slug] = h.Line
+		}
+	}
+
+	own := d.ids()
+	d.root.Walk(func(n *markdown.Node, entering bool) bool {
+		if !entering || n.Kind != markdown.Link && n.Kind != markdown.Image {
+			return true
+		}
+		dest := n.Dest
+		if dest == "" || reScheme.MatchString(dest) || strings.HasPrefix(dest, "//") || strings.HasPrefix(dest, "/") {
+			return true
+		}
+		path, frag, _ := strings.Cut(dest, "#")
+		path, _, _ = strings.Cut(path, "?")
+		if p, err := url.PathUnescape(path); err == nil {
+			path = p
+		}
+		if f, err := url.PathUnescape(frag); err == nil {
+			frag = f
+		}
+		if path == "" {
+			if frag != "" && !own[frag] {
+				report(n.Line, "link to missing anchor #%s", frag)
+			}
+			return true
+		}
+		target := filepath.Join(dir, filepath.FromSlash(path))
+		if _, err := os.Stat(target); err != nil {
+			report(n.Line, "broken link to %s", path)
+			return true
+		}
+		switch strings.ToLower(filepath.Ext(target)) {
+		case ".md", ".markdown":
+			if frag == "" {
+				break
+			}
+			if ids, err := anchorsOf(target); err == nil && !ids[frag] {
+				report(n.Line, "link to missing anchor %s#%s", path, frag)
+			}
+		}
+		return true
+	})
+	sort.SliceStable(probs, func(i, j int) bool { return probs[i].line < probs[j].line })
+	return probs
+}
+
+func main() {
+	flag.Usage = usage
+	files := cli.Parse(flag.CommandLine, os.Args[1:])
+	switch {
+	case *h1:
+		maxLevel = 1
+	case *h2:
+		maxLevel = 2
+	case *h3:
+		maxLevel = 3
+	}
+	if *inPlace && len(files) == 0 {
+		usage()
+	}
+
+	status := 0
+	run := func(r io.Reader, name, dir string) {
+		b, err := io.ReadAll(r)
+		if err != nil {
+			out.Flush()
+			fmt.Fprintf(os.Stderr, "outline: %s: %v\n", name, err)
+			status = 1
+			return
+		}
+		d := parse(string(b))
+		switch {
+		case *inPlace:
+			s, err := d.updateTOC()
+			if err == nil && s != d.src {
+				err = cli.WriteFile(name, []byte(s))
+			}
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "outline: %s: %v\n", name, err)
+				status = 1
+			}
+		case *lint:
+			for _, p := range d.lint(dir) {
+				fmt.Fprintf(out, "%s:%d: %s\n", name, p.line, p.msg)
+				status = 1
+			}
+		case *toc:
+			after := 0
+			if start, end := d.tocMarkers(); end != nil {
+				after = end.EndLine
+			} else if start != nil {
+				after = start.EndLine
+			}
+			for _, l := range d.tocLines(after) {
+				fmt.Fprintln(out, l)
+			}
+		default:
+			d.outline()
+		}
+	}
+	if len(files) == 0 {
+		run(os.Stdin, "<stdin>", ".")
+	}
 	for _, f := range files {
-		fh, err := os.Open(f); if err != nil { fmt.Fprintln(os.Stderr, err); continue }
-		process(fh); fh.Close()
+		fh, err := os.Open(f)
+		if err != nil {
+			out.Flush()
+			fmt.Fprintf(os.Stderr, "outline: %v\n", err)
+			status = 1
+			continue
+		}
+		run(fh, f, filepath.Dir(f))
+		fh.Close()
 	}
+	out.Flush()
+	os.Exit(status)
 }
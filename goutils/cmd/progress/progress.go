@@ -9,6 +9,8 @@ import (
 	"strconv"
 	"strings"
 	"time"
+
+	"goutils/lib/term"
 )
 
 func humanSize(b int64) string {
@@ -42,7 +44,7 @@ func drawBar(done, total int64, elapsed time.Duration, width int) string {
 func main() {
 	total := int64(-1)
 	lineMode := false
-	width := 40
+	width := -1
 	interval := 100 * time.Millisecond
 
 	args := os.Args[1:]
@@ -55,6 +57,12 @@ func main() {
 		}
 	}
 
+	// The bar goes to a terminal on stderr, sized to fit its width.
+	tty := term.IsTerminal(os.Stderr)
+	cols := term.Width(os.Stderr)
+	if width < 0 { width = min(40, max(10, cols-50)) }
+	draw := func(s string) { if tty { fmt.Fprint(os.Stderr, term.Truncate(s, cols-1, "")) } }
+
 	start := time.Now()
 	lastDraw := time.Now()
 	var count int64
@@ -67,8 +75,7 @@ func main() {
 			if time.Since(lastDraw) > interval {
 				lastDraw = time.Now()
 				elapsed := time.Since(start)
-				bar := drawBar(count, total, elapsed, width)
-				fmt.Fprint(os.Stderr, bar)
+				draw(drawBar(count, total, elapsed, width))
 			}
 		}
 	} else {
@@ -80,7 +87,7 @@ func main() {
 				count += int64(n)
 				if time.Since(lastDraw) > interval {
 					lastDraw = time.Now()
-					fmt.Fprint(os.Stderr, drawBar(count, total, time.Since(start), width))
+					draw(drawBar(count, total, time.Since(start), width))
 				}
 			}
 			if err == io.EOF { break }
@@ -88,7 +95,7 @@ func main() {
 	}
 
 	elapsed := time.Since(start)
-	fmt.Fprintf(os.Stderr, "\r\033[K") // clear line
+	if tty { fmt.Fprintf(os.Stderr, "\r\033[K") } // clear line
 	label := humanSize(count); if lineMode { label = fmt.Sprintf("%d lines", count) }
 	speed := float64(count) / elapsed.Seconds()
 	fmt.Fprintf(os.Stderr, "Done: %s in %s (%.0f/s)\n", label, elapsed.Round(time.Millisecond), speed)
@@ -1,108 +1,176 @@
 // split - Split a file into pieces
-// Usage: split [-l lines] [-b bytes] [-d] [file] [prefix]
+// Usage: split [-l lines | -b size | -C size | -n chunks] [-a len] [-d | -x] [-e] [-filter cmd] [-header] [file] [prefix]
 package main
 
 import (
-	"bufio"
 	"flag"
 	"fmt"
-	"io"
 	"os"
+	"strconv"
+	"strings"
+
+	"goutils/lib/split"
 )
 
 var (
-	lines  = flag.Int("l", 1000, "Lines per output file")
-	bytes  = flag.Int64("b", 0, "Bytes per output file (overrides -l)")
-	numeric = flag.Bool("d", false, "Use numeric suffixes instead of alphabetic")
+	lines      = flag.Int64("l", 1000, "Lines per output file")
+	byteSize   = flag.String("b", "", "Bytes per output file, such as 512, 10K or 1M")
+	lineBytes  = flag.String("C", "", "At most this many bytes of whole lines per output file")
+	number     = flag.String("n", "", "Split into N files: N, K/N, l/N, l/K/N, r/N or r/K/N")
+	suffixLen  = flag.Int("a", 0, "Suffix length (default 2, growing as needed)")
+	numeric    = flag.Bool("d", false, "Use numeric suffixes instead of alphabetic")
+	hexSuffix  = flag.Bool("x", false, "Use hexadecimal suffixes instead of alphabetic")
+	additional = flag.String("additional-suffix", "", "Append this suffix to file names")
+	filterCmd  = flag.String("filter", "", "Pipe each piece to this shell command, with $FILE set to its name")
+	elideEmpty = flag.Bool("e", false, "Do not create empty files with -n")
+	withHeader = flag.Bool("header", false, "Repeat the first line at the top of every file")
+	separator  = flag.String("t", "\n", "Record separator, a single character or \\0")
+	unbuf      = flag.Bool("u", false, "Write each record immediately")
+	verb       = flag.Bool("verbose", false, "Report each file as it is created")
 )
 
+func parseChunks(val string) split.Chunks {
+	c := split.Chunks{}
+	s := val
+	if strings.HasPrefix(s, "l/") || strings.HasPrefix(s, "r/") {
+		c.Kind, s = s[0], s[2:]
+	}
+	ks, ns, hasK := strings.Cut(s, "/")
+	if !hasK {
+		ks, ns = "", ks
+	}
+	n, err := strconv.ParseInt(ns, 10, 64)
+	if err != nil || n <= 0 {
+		fail("invalid number of chunks: %q", ns)
+	}
+	c.N = n
+	if hasK {
+		k, err := strconv.ParseInt(ks, 10, 64)
+		if err != nil || k <= 0 || k > n {
+			fail("invalid chunk number: %q", ks)
+		}
+		c.K = k
+	}
+	return c
+}
+
+// parseSize reads a byte count with an optional K, M, G or T suffix, in
+// powers of 1024, or KB, MB, GB in powers of 1000.
+func parseSize(s string) int64 {
+	mults := map[string]int64{"": 1, "K": 1 << 10, "M": 1 << 20, "G": 1 << 30, "T": 1 << 40,
+		"KB": 1e3, "MB": 1e6, "GB": 1e9, "TB": 1e12}
+	i := strings.IndexFunc(s, func(r rune) bool { return r < '0' || r > '9' })
+	if i < 0 {
+		i = len(s)
+	}
+	n, err := strconv.ParseInt(s[:i], 10, 64)
+	mult, ok := mults[strings.ToUpper(s[i:])]
+	if err != nil || !ok || n <= 0 {
+		fail("invalid number of bytes: %q", s)
+	}
+	return n * mult
+}
+
 func main() {
 	flag.Usage = func() {
-		fmt.Fprintln(os.Stderr, "Usage: split [-l lines] [-b bytes] [-d] [file] [prefix]")
+		fmt.Fprintln(os.Stderr, "Usage: split [-l lines | -b size | -C size | -n chunks] [-a len] [-d | -x] [-e] [-filter cmd] [-header] [file] [prefix]")
 		flag.PrintDefaults()
 	}
 	flag.Parse()
 
-	prefix := "x"
-	var r io.Reader = os.Stdin
+	modes := 0
+	flag.Visit(func(f *flag.Flag) {
+		switch f.Name {
+		case "l", "b", "C", "n":
+			modes++
+		}
+	})
+	if modes > 1 {
+		fail("cannot split in more than one way")
+	}
+	if *lines <= 0 {
+		fail("invalid number of lines: %d", *lines)
+	}
 
+	opt := split.Options{
+		Prefix:           "x",
+		AdditionalSuffix: *additional,
+		Elide:            *elideEmpty,
+		Filter:           *filterCmd,
+		Unbuffered:       *unbuf,
+		Verbose:          *verb,
+		Header:           *withHeader,
+	}
 	args := flag.Args()
-	if len(args) > 0 && args[0] != "-" {
-		f, err := os.Open(args[0])
-		if err != nil {
-			fmt.Fprintln(os.Stderr, "split:", err)
-			os.Exit(1)
-		}
-		defer f.Close()
-		r = f
+	if len(args) > 2 {
+		fail("extra operand %q", args[2])
 	}
 	if len(args) > 1 {
-		prefix = args[1]
+		opt.Prefix = args[1]
 	}
-
-	if *bytes > 0 {
-		splitBytes(r, prefix, *bytes)
-	} else {
-		splitLines(r, prefix, *lines)
+	if strings.Contains(opt.AdditionalSuffix, "/") {
+		fail("invalid suffix %q, contains directory separator", opt.AdditionalSuffix)
 	}
-}
-
-func suffix(n int, numeric bool) string {
-	if numeric {
-		return fmt.Sprintf("%02d", n)
+	switch {
+	case *numeric && *hexSuffix:
+		fail("-d and -x are mutually exclusive")
+	case *numeric:
+		opt.Alphabet = "0123456789"
+	case *hexSuffix:
+		opt.Alphabet = "0123456789abcdef"
+	default:
+		opt.Alphabet = "abcdefghijklmnopqrstuvwxyz"
 	}
-	a := n / 26
-	b := n % 26
-	if a > 25 {
-		return fmt.Sprintf("zz%02d", n)
+	switch s := *separator; {
+	case s == `\0`:
+		opt.Separator = 0
+	case len(s) == 1:
+		opt.Separator = s[0]
+	default:
+		fail("invalid separator %q", s)
 	}
-	return fmt.Sprintf("%c%c", 'a'+a, 'a'+b)
-}
 
-func splitLines(r io.Reader, prefix string, n int) {
-	sc := bufio.NewScanner(r)
-	fileNum := 0
-	count := 0
-	var f *os.File
-
-	for sc.Scan() {
-		if count%n == 0 {
-			if f != nil {
-				f.Close()
-			}
-			name := prefix + suffix(fileNum, *numeric)
-			var err error
-			f, err = os.Create(name)
-			if err != nil {
-				fmt.Fprintln(os.Stderr, "split:", err)
-				os.Exit(1)
-			}
-			fileNum++
+	switch {
+	case *byteSize != "":
+		opt.Bytes = parseSize(*byteSize)
+	case *lineBytes != "":
+		opt.LineBytes = parseSize(*lineBytes)
+	case *number != "":
+		opt.Chunks = parseChunks(*number)
+	default:
+		opt.Lines = *lines
+	}
+	// Suffixes grow on their own unless -a fixes their length or -n the
+	// number of files.
+	opt.SuffixAuto = *suffixLen == 0 && *number == ""
+	need := 2
+	if *number != "" {
+		need = split.SuffixDigits(opt.Alphabet, opt.Chunks.N-1)
+		if *suffixLen != 0 && *suffixLen < need {
+			fail("the suffix length needs to be at least %d", need)
 		}
-		fmt.Fprintln(f, sc.Text())
-		count++
 	}
-	if f != nil {
-		f.Close()
+	opt.SuffixLength = *suffixLen
+	if opt.SuffixLength == 0 {
+		opt.SuffixLength = max(2, need)
 	}
-}
 
-func splitBytes(r io.Reader, prefix string, n int64) {
-	buf := make([]byte, n)
-	fileNum := 0
-	for {
-		nr, err := io.ReadFull(r, buf)
-		if nr > 0 {
-			name := prefix + suffix(fileNum, *numeric)
-			os.WriteFile(name, buf[:nr], 0644)
-			fileNum++
-		}
-		if err == io.EOF || err == io.ErrUnexpectedEOF {
-			break
-		}
+	in := os.Stdin
+	if len(args) > 0 && args[0] != "-" {
+		f, err := os.Open(args[0])
 		if err != nil {
-			fmt.Fprintln(os.Stderr, "split:", err)
-			os.Exit(1)
+			fail("%v", err)
 		}
+		defer f.Close()
+		in = f
+	}
+	if err := split.Run(in, opt); err != nil {
+		fmt.Fprintf(os.Stderr, "split: %v\n", err)
+		os.Exit(err.(*split.Error).Status)
 	}
 }
+
+func fail(format string, a ...interface{}) {
+	fmt.Fprintf(os.Stderr, "split: "+format+"\n", a...)
+	os.Exit(1)
+}
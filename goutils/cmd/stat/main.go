@@ -1,57 +1,134 @@
 // stat - Display file or filesystem status
-// Usage: stat <file>...
+// Usage: stat [-L] [-f] [-t] [-c format | -printf format] <file>...
 package main
 
 import (
+	"bufio"
+	"flag"
 	"fmt"
 	"os"
-	"syscall"
+	"strconv"
+	"strings"
+
+	"goutils/lib/statfmt"
+)
+
+var (
+	format      = flag.String("c", "", "Use the given format, with a newline after each file")
+	printf      = flag.String("printf", "", "Like -c, but with backslash escapes and no trailing newline")
+	dereference = flag.Bool("L", false, "Follow symbolic links")
+	fileSystem  = flag.Bool("f", false, "Display file system status instead of file status")
+	terse       = flag.Bool("t", false, "Print the information in terse form")
 )
 
 func main() {
-	if len(os.Args) < 2 {
-		fmt.Fprintln(os.Stderr, "Usage: stat <file>...")
+	flag.Usage = func() {
+		fmt.Fprintln(os.Stderr, "Usage: stat [-L] [-f] [-t] [-c format | -printf format] <file>...")
+		os.Stderr.WriteString("File directives: %a %A %b %B %d %D %f %F %g %G %h %i %m %n %N %o %s %t %T %u %U %w %W %x %X %y %Y %z %Z\n")
+		os.Stderr.WriteString("File system directives (-f): %a %b %c %d %f %i %l %n %s %S %t %T\n")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if flag.NArg() == 0 {
+		flag.Usage()
 		os.Exit(1)
 	}
 
-	exitCode := 0
-	for _, path := range os.Args[1:] {
-		if err := statFile(path); err != nil {
-			fmt.Fprintln(os.Stderr, "stat:", err)
-			exitCode = 1
+	// An empty format is valid, so look at which flags were given.
+	layout, given, newline := "", false, false
+	flag.Visit(func(f *flag.Flag) {
+		switch f.Name {
+		case "c":
+			layout, given, newline = *format, true, true
+		case "printf":
+			layout, given, newline = unescape(*printf), true, false
 		}
-	}
-	os.Exit(exitCode)
-}
+	})
 
-func statFile(path string) error {
-	info, err := os.Lstat(path)
-	if err != nil {
-		return err
+	out := bufio.NewWriter(os.Stdout)
+	defer out.Flush()
+	exitCode := 0
+	warn := func(format string, a ...interface{}) {
+		out.Flush()
+		fmt.Fprintf(os.Stderr, "stat: "+format+"\n", a...)
+		exitCode = 1
 	}
 
-	fileType := "regular file"
-	if info.IsDir() {
-		fileType = "directory"
-	} else if info.Mode()&os.ModeSymlink != 0 {
-		fileType = "symbolic link"
-	} else if info.Mode()&os.ModeNamedPipe != 0 {
-		fileType = "fifo"
-	} else if info.Mode()&os.ModeDevice != 0 {
-		fileType = "device"
+	for _, name := range flag.Args() {
+		if *fileSystem {
+			f, err := statfmt.StatFS(name)
+			if err != nil {
+				warn("cannot read file system information for '%s': %v", name, err)
+				continue
+			}
+			l := layout
+			if !given {
+				l = f.Layout(*terse)
+			}
+			f.Expand(out, l)
+			if newline {
+				out.WriteByte('\n')
+			}
+			continue
+		}
+
+		f, err := statfmt.StatFile(name, *dereference)
+		if err != nil {
+			warn("cannot stat '%s': %v", name, err)
+			continue
+		}
+		l := layout
+		if !given {
+			l = f.Layout(*terse)
+		}
+		f.Expand(out, l)
+		if newline {
+			out.WriteByte('\n')
+		}
 	}
+	out.Flush()
+	os.Exit(exitCode)
+}
 
-	fmt.Printf("  File: %s\n", path)
-	fmt.Printf("  Size: %-15d FileType: %s\n", info.Size(), fileType)
-	fmt.Printf("  Mode: %s (%04o)\n", info.Mode(), info.Mode().Perm())
-	fmt.Printf("Modify: %s\n", info.ModTime().Format("2006-01-02 15:04:05.000000000 -0700"))
-
-	// Linux-specific: Inode, UID, GID via syscall
-	if stat, ok := info.Sys().(*syscall.Stat_t); ok {
-		fmt.Printf(" Inode: %-15d Links: %d\n", stat.Ino, stat.Nlink)
-		fmt.Printf("   UID: %-10d  GID: %d\n", stat.Uid, stat.Gid)
-		atime := stat.Atim
-		fmt.Printf("Access: %d.%09d\n", atime.Sec, atime.Nsec)
+// unescape expands the backslash escapes of a -printf format: \\ \a \b
+// \e \f \n \r \t \v \" \NNN octal and \xHH. Others stand for the
+// character escaped, with a warning.
+func unescape(s string) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] != '\\' || i+1 == len(s) {
+			b.WriteByte(s[i])
+			continue
+		}
+		i++
+		if c := strings.IndexByte(`abefnrtv"\`, s[i]); c >= 0 {
+			b.WriteByte("\a\b\x1b\f\n\r\t\v\"\\"[c])
+			continue
+		}
+		n, v, base, digits := 0, 0, 8, 3
+		if s[i] == 'x' {
+			i++
+			base, digits = 16, 2
+		}
+		for ; n < digits && i+n < len(s); n++ {
+			d, err := strconv.ParseUint(s[i+n:i+n+1], base, 8)
+			if err != nil {
+				break
+			}
+			v = v*base + int(d)
+		}
+		switch {
+		case n > 0:
+			b.WriteByte(byte(v))
+			i += n - 1
+		case base == 16:
+			fmt.Fprintln(os.Stderr, `stat: warning: unrecognized escape '\x'`)
+			b.WriteByte('x')
+			i--
+		default:
+			fmt.Fprintf(os.Stderr, "stat: warning: unrecognized escape '\\%c'\n", s[i])
+			b.WriteByte(s[i])
+		}
 	}
-	return nil
+	return b.String()
 }
@@ -1,4 +1,4 @@
-// tlsinfo - Show TLS certificate details for a host.
+// tlsinfo - Show and validate the TLS certificate chain of a server.
 //
 // Usage:
 //
@@ -6,21 +6,29 @@
 //
 // Options:
 //
-//	-p PORT   Port (default: 443)
-//	-t DUR    Timeout (default: 10s)
-//	-j        JSON output
-//	-k        Skip verification (show cert even if invalid)
-//	-c        Check only: exit 0 if valid, 1 if expired/invalid
-//	-w N      Warn if cert expires within N days (exit 2)
-//	-a        Show all certs in chain
+//	-p PORT         Port (default: 443, or the STARTTLS protocol's port)
+//	-t DUR          Timeout (default: 10s)
+//	-j              JSON output
+//	-k              Report an invalid chain but still exit 0
+//	-c              Check only: print OK or the reason, exit 1 if invalid/expired
+//	-w N            Warn if cert expires within N days (exit 2)
+//	-a              Show every certificate in the chain
+//	-v              Show extensions, fingerprints and SCTs
+//	-roots FILE     Trust these roots (PEM/DER file or directory) instead of the system pool
+//	-servername N   SNI and hostname to verify (default: HOST)
+//	-starttls PROTO Upgrade a plaintext connection first: smtp, imap, pop3, ftp, postgres
+//	-ocsp           Query the leaf's OCSP responder
+//	-crl            Download the leaf's CRL and look it up
 //
 // Examples:
 //
 //	tlsinfo google.com
 //	tlsinfo -p 8443 internal.example.com
 //	tlsinfo -w 30 api.example.com    # warn if <30 days left
-//	tlsinfo -j example.com | jq .expiry
-//	tlsinfo -a example.com           # show full chain
+//	tlsinfo -j example.com | jq .leaf.days_left
+//	tlsinfo -a -v example.com        # full chain with extensions
+//	tlsinfo -starttls smtp -ocsp mail.example.com
+//	tlsinfo -roots ca.pem -servername db.internal -starttls postgres 10.0.0.5
 package main
 
 import (
@@ -30,68 +38,42 @@ import (
 	"flag"
 	"fmt"
 	"net"
+	"net/http"
 	"os"
 	"strings"
 	"time"
+
+	"goutils/internal/x509util"
 )
 
 var (
-	port    = flag.String("p", "443", "port")
-	timeout = flag.Duration("t", 10*time.Second, "timeout")
-	asJSON  = flag.Bool("j", false, "JSON output")
-	insecure = flag.Bool("k", false, "skip verify")
-	check   = flag.Bool("c", false, "check mode")
-	warnDays = flag.Int("w", 0, "warn days")
-	chain   = flag.Bool("a", false, "show chain")
+	port       = flag.String("p", "", "port")
+	timeout    = flag.Duration("t", 10*time.Second, "timeout")
+	asJSON     = flag.Bool("j", false, "JSON output")
+	insecure   = flag.Bool("k", false, "don't fail on an invalid chain")
+	check      = flag.Bool("c", false, "check mode")
+	warnDays   = flag.Int("w", 0, "warn days")
+	chain      = flag.Bool("a", false, "show chain")
+	verbose    = flag.Bool("v", false, "show extensions")
+	rootsPath  = flag.String("roots", "", "trusted roots file or directory")
+	serverName = flag.String("servername", "", "SNI / hostname to verify")
+	starttls   = flag.String("starttls", "", "STARTTLS protocol")
+	doOCSP     = flag.Bool("ocsp", false, "check OCSP")
+	doCRL      = flag.Bool("crl", false, "check CRL")
 )
 
-type CertInfo struct {
-	Subject    string   `json:"subject"`
-	Issuer     string   `json:"issuer"`
-	SANs       []string `json:"sans"`
-	NotBefore  string   `json:"not_before"`
-	NotAfter   string   `json:"not_after"`
-	DaysLeft   int      `json:"days_left"`
-	IsCA       bool     `json:"is_ca"`
-	Serial     string   `json:"serial"`
-	Expired    bool     `json:"expired"`
-}
-
-func certInfo(cert *x509.Certificate) CertInfo {
-	now := time.Now()
-	daysLeft := int(cert.NotAfter.Sub(now).Hours() / 24)
-	var sans []string
-	sans = append(sans, cert.DNSNames...)
-	for _, ip := range cert.IPAddresses {
-		sans = append(sans, ip.String())
-	}
-	return CertInfo{
-		Subject:   cert.Subject.CommonName,
-		Issuer:    cert.Issuer.CommonName,
-		SANs:      sans,
-		NotBefore: cert.NotBefore.Format("2006-01-02"),
-		NotAfter:  cert.NotAfter.Format("2006-01-02"),
-		DaysLeft:  daysLeft,
-		IsCA:      cert.IsCA,
-		Serial:    cert.SerialNumber.String(),
-		Expired:   now.After(cert.NotAfter),
-	}
-}
-
-func printCert(c CertInfo, indent string) {
-	fmt.Printf("%sSubject:    %s\n", indent, c.Subject)
-	fmt.Printf("%sIssuer:     %s\n", indent, c.Issuer)
-	if len(c.SANs) > 0 {
-		fmt.Printf("%sSANs:       %s\n", indent, strings.Join(c.SANs, ", "))
-	}
-	fmt.Printf("%sNot Before: %s\n", indent, c.NotBefore)
-	fmt.Printf("%sNot After:  %s\n", indent, c.NotAfter)
-	status := fmt.Sprintf("%d days remaining", c.DaysLeft)
-	if c.Expired {
-		status = "EXPIRED"
-	}
-	fmt.Printf("%sExpiry:     %s\n", indent, status)
-	fmt.Printf("%sSerial:     %s\n", indent, c.Serial)
+type result struct {
+	Host       string                `json:"host"`
+	Version    string                `json:"tls_version"`
+	Cipher     string                `json:"cipher"`
+	ALPN       string                `json:"alpn,omitempty"`
+	Leaf       *x509util.Report      `json:"leaf"`
+	Chain      []*x509util.Report    `json:"chain,omitempty"`
+	Validation *x509util.ChainResult `json:"validation"`
+	Stapled    bool                  `json:"ocsp_stapled"`
+	OCSP       *x509util.OCSPStatus  `json:"ocsp,omitempty"`
+	CRL        *x509util.CRLStatus   `json:"crl,omitempty"`
+	Errors     []string              `json:"errors,omitempty"`
 }
 
 func main() {
@@ -107,69 +89,150 @@ func main() {
 	if h, po, err := net.SplitHostPort(host); err == nil {
 		host, p = h, po
 	}
+	if p == "" {
+		p = x509util.DefaultPort(*starttls)
+	}
 	addr := net.JoinHostPort(host, p)
+	name := host
+	if *serverName != "" {
+		name = *serverName
+	}
+
+	var roots *x509.CertPool
+	if *rootsPath != "" {
+		var err error
+		if roots, err = x509util.LoadRoots(*rootsPath); err != nil {
+			fmt.Fprintf(os.Stderr, "tlsinfo: %v\n", err)
+			os.Exit(1)
+		}
+	}
 
-	dialer := &net.Dialer{Timeout: *timeout}
-	conn, err := tls.DialWithDialer(dialer, "tcp", addr, &tls.Config{
-		InsecureSkipVerify: *insecure,
-		ServerName:         host,
-	})
+	// Verification is done by x509util so that a broken chain is reported
+	// in detail instead of aborting the handshake.
+	conn, err := x509util.Dial(addr, *starttls, &tls.Config{
+		InsecureSkipVerify: true,
+		ServerName:         name,
+		NextProtos:         []string{"h2", "http/1.1"},
+	}, *timeout)
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "tlsinfo: %v\n", err)
 		os.Exit(1)
 	}
-	defer conn.Close()
+	state := conn.ConnectionState()
+	conn.Close()
 
-	certs := conn.ConnectionState().PeerCertificates
+	certs := state.PeerCertificates
 	if len(certs) == 0 {
 		fmt.Fprintln(os.Stderr, "tlsinfo: no certificates")
 		os.Exit(1)
 	}
 
-	target := certs[0]
-	info := certInfo(target)
+	res := &result{
+		Host:    addr,
+		Version: tls.VersionName(state.Version),
+		Cipher:  tls.CipherSuiteName(state.CipherSuite),
+		ALPN:    state.NegotiatedProtocol,
+		Leaf:    x509util.Inspect(certs[0]),
+		Stapled: len(state.OCSPResponse) > 0,
+	}
+	if *chain {
+		for _, c := range certs[1:] {
+			res.Chain = append(res.Chain, x509util.Inspect(c))
+		}
+	}
+	res.Validation = x509util.VerifyChain(certs, x509util.VerifyOptions{Roots: roots, Hostname: name})
+
+	client := &http.Client{Timeout: *timeout}
+	issuer := x509util.Issuer(certs[0], certs[1:], res.Validation)
+	if *doOCSP {
+		if st, err := x509util.CheckOCSP(client, certs[0], issuer); err != nil {
+			res.Errors = append(res.Errors, "ocsp: "+err.Error())
+		} else {
+			res.OCSP = st
+		}
+	}
+	if *doCRL {
+		if st, err := x509util.CheckCRL(client, certs[0], issuer); err != nil {
+			res.Errors = append(res.Errors, "crl: "+err.Error())
+		} else {
+			res.CRL = st
+		}
+	}
 
+	problem := failure(res)
 	if *check {
-		if info.Expired {
-			fmt.Println("EXPIRED")
+		if problem != "" {
+			fmt.Println(problem)
 			os.Exit(1)
 		}
 		fmt.Println("OK")
 		os.Exit(0)
 	}
 
-	if *warnDays > 0 && info.DaysLeft <= *warnDays && !info.Expired {
-		fmt.Fprintf(os.Stderr, "WARNING: certificate expires in %d days\n", info.DaysLeft)
-		defer os.Exit(2)
-	}
-
 	if *asJSON {
-		var infos []CertInfo
-		showCerts := certs
-		if !*chain {
-			showCerts = certs[:1]
-		}
-		for _, c := range showCerts {
-			infos = append(infos, certInfo(c))
-		}
-		var out interface{} = infos[0]
-		if *chain {
-			out = infos
-		}
-		b, _ := json.MarshalIndent(out, "", "  ")
+		b, _ := json.MarshalIndent(res, "", "  ")
 		fmt.Println(string(b))
-		return
+	} else {
+		printResult(res)
 	}
 
-	fmt.Printf("Host: %s\n", addr)
+	switch {
+	case problem != "" && !*insecure:
+		os.Exit(1)
+	case *warnDays > 0 && res.Leaf.DaysLeft <= *warnDays && !res.Leaf.Expired:
+		fmt.Fprintf(os.Stderr, "WARNING: certificate expires in %d days\n", res.Leaf.DaysLeft)
+		os.Exit(2)
+	}
+}
+
+// failure returns why the server's certificate should not be trusted, or
+// "" if it should.
+func failure(res *result) string {
+	switch {
+	case res.Leaf.Expired:
+		return "EXPIRED"
+	case !res.Validation.Valid:
+		return "INVALID: " + res.Validation.Error
+	case !res.Validation.HostnameOK:
+		return "HOSTNAME MISMATCH: " + res.Validation.HostnameError
+	case res.OCSP != nil && res.OCSP.Status == "revoked":
+		return "REVOKED (OCSP)"
+	case res.CRL != nil && res.CRL.Revoked:
+		return "REVOKED (CRL)"
+	}
+	return ""
+}
+
+func printResult(res *result) {
+	fmt.Printf("Host: %s\n", res.Host)
+	proto := res.Version + ", " + res.Cipher
+	if res.ALPN != "" {
+		proto += ", ALPN " + res.ALPN
+	}
+	fmt.Printf("TLS:  %s\n", proto)
 	fmt.Println()
 	fmt.Println("Leaf Certificate:")
-	printCert(info, "  ")
-
-	if *chain && len(certs) > 1 {
-		for i, c := range certs[1:] {
-			fmt.Printf("\nChain[%d]:\n", i+1)
-			printCert(certInfo(c), "  ")
-		}
+	res.Leaf.Print(os.Stdout, "  ", *verbose)
+	for i, r := range res.Chain {
+		fmt.Printf("\nChain[%d]:\n", i+1)
+		r.Print(os.Stdout, "  ", *verbose)
+	}
+	fmt.Println()
+	fmt.Println("Validation:")
+	res.Validation.Print(os.Stdout, "  ")
+	if res.Stapled {
+		fmt.Println("  OCSP stapled  : yes")
+	}
+	if res.OCSP != nil {
+		res.OCSP.Print(os.Stdout, "  ")
+	}
+	if res.CRL != nil {
+		res.CRL.Print(os.Stdout, "  ")
+	}
+	for _, e := range res.Errors {
+		fmt.Printf("  Error         : %s\n", e)
+	}
+	if p := failure(res); p != "" {
+		fmt.Printf("\nResult: %s\n", strings.SplitN(p, ":", 2)[0])
 	}
 }
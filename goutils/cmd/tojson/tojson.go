@@ -29,13 +29,15 @@ package main
 
 import (
 	"bufio"
-	"encoding/csv"
 	"encoding/json"
 	"flag"
 	"fmt"
+	"io"
 	"os"
 	"strconv"
 	"strings"
+
+	"goutils/internal/tabular"
 )
 
 var (
@@ -105,37 +107,32 @@ func processLines(lines []string) {
 	marshalOut(result)
 }
 
-func processDelimited(lines []string, delim rune) {
-	if len(lines) == 0 {
-		marshalOut([]interface{}{})
-		return
-	}
-
+func processDelimited(lines []string, format tabular.Format) {
 	fieldKeys := []string{}
 	if *keys != "" {
 		fieldKeys = strings.Split(*keys, ",")
 	}
 
-	startRow := 0
+	// With -k the first line is data; otherwise it is the header.
+	r := tabular.NewReader(strings.NewReader(strings.Join(lines, "\n")), tabular.Options{
+		Format:   format,
+		NoHeader: len(fieldKeys) > 0,
+	})
 	if len(fieldKeys) == 0 {
-		// First line is header
-		r := csv.NewReader(strings.NewReader(lines[0]))
-		r.Comma = delim
-		rec, err := r.Read()
-		if err == nil {
-			fieldKeys = rec
+		header, err := r.Header()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "tojson: %v\n", err)
+			os.Exit(1)
 		}
-		startRow = 1
+		fieldKeys = header
 	}
 
-	var result []interface{}
-	for _, l := range lines[startRow:] {
-		if strings.TrimSpace(l) == "" {
-			continue
-		}
-		r := csv.NewReader(strings.NewReader(l))
-		r.Comma = delim
+	result := []interface{}{}
+	for {
 		rec, err := r.Read()
+		if err == io.EOF {
+			break
+		}
 		if err != nil {
 			continue
 		}
@@ -210,9 +207,9 @@ func main() {
 	case "env", "kv":
 		processKV(allLines)
 	case "tsv":
-		processDelimited(allLines, '\t')
+		processDelimited(allLines, tabular.FormatTSV)
 	case "csv":
-		processDelimited(allLines, ',')
+		processDelimited(allLines, tabular.FormatCSV)
 	case "lines":
 		processLines(allLines)
 	default:
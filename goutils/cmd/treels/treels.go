@@ -16,6 +16,8 @@
 //	-j        JSON output
 //	-I PAT    Ignore files matching pattern (glob)
 //	--noreport  Omit summary line
+//	--color=WHEN  Colour names by type: auto (default), always or never;
+//	          auto colours a terminal unless NO_COLOR is set
 //
 // Examples:
 //
@@ -33,6 +35,8 @@ import (
 	"path/filepath"
 	"sort"
 	"strings"
+
+	"goutils/lib/term"
 )
 
 var (
@@ -46,8 +50,25 @@ var (
 	asJSON    = flag.Bool("j", false, "JSON output")
 	ignores   = flag.String("I", "", "ignore pattern")
 	noReport  = flag.Bool("noreport", false, "no summary")
+	colorMode = term.ColorFlag()
 )
 
+// pal colours names as ls does: directories bold blue, symlinks cyan and
+// executables green.
+var pal term.Profile
+
+func paintName(name string, mode os.FileMode) string {
+	switch {
+	case mode.IsDir():
+		return pal.Paint(name, term.Bold, term.Blue)
+	case mode&os.ModeSymlink != 0:
+		return pal.Paint(name, term.Bold, term.Cyan)
+	case mode.IsRegular() && mode&0o111 != 0:
+		return pal.Paint(name, term.Bold, term.Green)
+	}
+	return name
+}
+
 type Node struct {
 	Name     string  `json:"name"`
 	Type     string  `json:"type"`
@@ -133,7 +154,10 @@ func printTree(path, prefix string, depth int) {
 		}
 
 		info, _ := e.Info()
-		label := e.Name()
+		label := paintName(e.Name(), e.Type())
+		if info != nil {
+			label = paintName(e.Name(), info.Mode())
+		}
 
 		extras := ""
 		if *showPerms && info != nil {
@@ -183,6 +207,7 @@ func buildTree(path string, depth int) *Node {
 
 func main() {
 	flag.Parse()
+	pal = colorMode.Profile(os.Stdout)
 	dirs := flag.Args()
 	if len(dirs) == 0 {
 		dirs = []string{"."}
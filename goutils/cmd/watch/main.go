@@ -1,70 +1,561 @@
-// watch - Execute a program periodically, showing output
-// Usage: watch [-n seconds] [-d] <command>
+// watch - Execute a program periodically, showing output fullscreen
+//
+// Usage:
+//
+//	watch [OPTIONS] COMMAND [ARGS...]
+//
+// Options:
+//
+//	-n SECS          Seconds between updates (default: 2)
+//	-d[=permanent]   Highlight characters that changed since the previous run
+//	                 (permanent: since the first run)
+//	-g, -chgexit     Exit when the output of COMMAND changes
+//	-e, -errexit     Stop when COMMAND exits with a non-zero status
+//	-p, -precise     Run COMMAND every SECS, rather than SECS after it finished
+//	-t, -no-title    Don't show the header line
+//	-x, -exec        Run COMMAND directly instead of via sh -c, even when
+//	                 it is a single argument
+//	-c, -color       Interpret ANSI colour and style sequences
+//	-H N             Number of snapshots kept in the history (default: 500)
+//
+// Keys (when stdin is a terminal):
+//
+//	Left/Up      Step back to the previous snapshot
+//	Right/Down   Step forward to the next snapshot
+//	End, Esc     Return to live output
+//	q            Quit
+//
+// Examples:
+//
+//	watch -n 1 -d 'ls -l /tmp'
+//	watch -g -n 5 curl -s http://localhost/health
+//	watch -x -c -- git -c color.status=always status -s
 package main
 
 import (
+	"bytes"
 	"flag"
 	"fmt"
 	"os"
 	"os/exec"
+	"os/signal"
 	"strings"
+	"sync"
+	"syscall"
 	"time"
+	"unicode/utf8"
+	"unsafe"
+
+	"goutils/lib/term"
 )
 
+// diffMode implements -d and -d=permanent.
+type diffMode string
+
+func (d *diffMode) String() string   { return string(*d) }
+func (d *diffMode) IsBoolFlag() bool { return true }
+func (d *diffMode) Set(s string) error {
+	switch s {
+	case "true", "1":
+		*d = "on"
+	case "false", "0":
+		*d = ""
+	case "permanent":
+		*d = "permanent"
+	default:
+		return fmt.Errorf("invalid value %q (want permanent)", s)
+	}
+	return nil
+}
+
 var (
-	interval = flag.Float64("n", 2.0, "Seconds between updates")
-	diff     = flag.Bool("d", false, "Highlight differences between updates")
+	interval    = flag.Float64("n", 2.0, "Seconds between updates")
+	differences diffMode
+	chgExit     bool
+	errExit     bool
+	precise     bool
+	noTitle     bool
+	execDirect  bool
+	color       bool
+	histSize    = flag.Int("H", 500, "Number of snapshots kept in the history")
 )
 
+func init() {
+	flag.Var(&differences, "d", "Highlight differences between updates (-d=permanent to keep them)")
+	flag.Var(&differences, "differences", "Same as -d")
+	flag.BoolVar(&chgExit, "g", false, "Exit when output changes")
+	flag.BoolVar(&chgExit, "chgexit", false, "Same as -g")
+	flag.BoolVar(&errExit, "e", false, "Stop on a non-zero exit status")
+	flag.BoolVar(&errExit, "errexit", false, "Same as -e")
+	flag.BoolVar(&precise, "p", false, "Precise intervals")
+	flag.BoolVar(&precise, "precise", false, "Same as -p")
+	flag.BoolVar(&noTitle, "t", false, "Don't show the header")
+	flag.BoolVar(&noTitle, "no-title", false, "Same as -t")
+	flag.BoolVar(&execDirect, "x", false, "Exec the command without a shell")
+	flag.BoolVar(&execDirect, "exec", false, "Same as -x")
+	flag.BoolVar(&color, "c", false, "Interpret ANSI colour sequences")
+	flag.BoolVar(&color, "color", false, "Same as -c")
+}
+
+// snapshot is one run of the command.
+type snapshot struct {
+	at     time.Time
+	output string
+	status int
+}
+
+// cell is one character on screen together with the SGR attributes that
+// were active when it was printed.
+type cell struct {
+	r    rune
+	attr string
+}
+
+type screen [][]cell
+
 func main() {
 	flag.Usage = func() {
-		fmt.Fprintln(os.Stderr, "Usage: watch [-n seconds] [-d] <command>")
+		fmt.Fprintln(os.Stderr, "Usage: watch [-n secs] [-d[=permanent]] [-g] [-e] [-p] [-t] [-x] [-c] <command>")
 		flag.PrintDefaults()
 	}
 	flag.Parse()
-
 	if flag.NArg() == 0 {
 		flag.Usage()
 		os.Exit(1)
 	}
 
-	cmd := strings.Join(flag.Args(), " ")
-	prev := ""
-	iteration := 0
+	w := &watcher{args: flag.Args(), title: strings.Join(flag.Args(), " ")}
+	os.Exit(w.run())
+}
+
+type watcher struct {
+	args  []string
+	title string
+
+	mu      sync.Mutex
+	history []snapshot
+	view    int // index into history being shown, -1 for live
+	changed [][]bool
+	keys    chan byte
+	isTTY   bool
+}
+
+// command returns the command for the next run. A single argument is a
+// shell command line, as in watch 'ls | wc -l'; several are run as they
+// are, so that arguments with spaces or shell characters stay whole.
+func (w *watcher) command() *exec.Cmd {
+	if execDirect || len(w.args) > 1 {
+		return exec.Command(w.args[0], w.args[1:]...)
+	}
+	return exec.Command("sh", "-c", w.args[0])
+}
+
+func (w *watcher) run() int {
+	w.view = -1
+	restore, isTTY := enterRawMode()
+	defer restore()
+	w.isTTY = isTTY
+	fmt.Print(term.HideCursor)
+	defer fmt.Print(term.ShowCursor)
+
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, os.Interrupt, syscall.SIGTERM)
+	w.keys = make(chan byte, 16)
+	if isTTY {
+		go readKeys(w.keys)
+	}
+	resized, stopResize := term.WatchSize()
+	defer stopResize()
 
+	period := time.Duration(*interval * float64(time.Second))
+	if period < 100*time.Millisecond {
+		period = 100 * time.Millisecond
+	}
+	next := time.Now()
 	for {
-		// Clear screen (ANSI escape)
-		if iteration > 0 {
-			fmt.Print("\033[H\033[2J")
+		snap := w.execute()
+		w.mu.Lock()
+		var prev *snapshot
+		if n := len(w.history); n > 0 {
+			prev = &w.history[n-1]
 		}
+		changed := prev != nil && prev.output != snap.output
+		w.history = append(w.history, snap)
+		// The snapshot being looked at stays, even past -H, until the
+		// view returns to live output.
+		if drop := len(w.history) - *histSize; drop > 0 {
+			if w.view >= 0 {
+				drop = min(drop, w.view)
+				w.view -= drop
+			}
+			w.history = w.history[drop:]
+		}
+		w.mu.Unlock()
+		w.redraw()
+
+		if chgExit && changed {
+			return 0
+		}
+		if errExit && snap.status != 0 {
+			fmt.Printf("\ncommand exit with a non-zero status (%d), press a key to exit", snap.status)
+			w.waitKey(sigs)
+			return 8
+		}
+
+		if precise {
+			next = next.Add(period)
+			if next.Before(time.Now()) {
+				next = time.Now()
+			}
+		} else {
+			next = time.Now().Add(period)
+		}
+		timer := time.NewTimer(time.Until(next))
+	wait:
+		for {
+			select {
+			case <-sigs:
+				timer.Stop()
+				return 0
+			case k := <-w.keys:
+				if w.handleKey(k) {
+					timer.Stop()
+					return 0
+				}
+			case <-resized:
+				w.redraw()
+			case <-timer.C:
+				break wait
+			}
+		}
+	}
+}
+
+func (w *watcher) execute() snapshot {
+	cmd := w.command()
+	var buf bytes.Buffer
+	cmd.Stdout = &buf
+	cmd.Stderr = &buf
+	err := cmd.Run()
+	status := 0
+	if err != nil {
+		if ee, ok := err.(*exec.ExitError); ok {
+			status = ee.ExitCode()
+		} else {
+			fmt.Fprintf(&buf, "watch: %v\n", err)
+			status = 127
+		}
+	}
+	return snapshot{at: time.Now(), output: buf.String(), status: status}
+}
+
+func (w *watcher) waitKey(sigs chan os.Signal) {
+	if !w.isTTY {
+		return
+	}
+	select {
+	case <-w.keys:
+	case <-sigs:
+	}
+}
+
+// handleKey processes one byte of keyboard input and reports whether the
+// user asked to quit. Arrow keys arrive as ESC [ A..D.
+func (w *watcher) handleKey(k byte) bool {
+	switch k {
+	case 'q', 'Q':
+		return true
+	case 0x1b:
+		seq := readEscape(w.keys)
+		w.mu.Lock()
+		switch seq {
+		case "[A", "[D": // up, left
+			if w.view == -1 {
+				w.view = len(w.history) - 1
+			}
+			if w.view > 0 {
+				w.view--
+			}
+		case "[B", "[C": // down, right
+			if w.view >= 0 {
+				w.view++
+				if w.view >= len(w.history)-1 {
+					w.view = -1
+				}
+			}
+		case "", "[F", "[4~", "OF": // bare Esc or End
+			w.view = -1
+		}
+		w.mu.Unlock()
+		w.redraw()
+	}
+	return false
+}
+
+func readEscape(keys chan byte) string {
+	var seq []byte
+	for {
+		select {
+		case b := <-keys:
+			seq = append(seq, b)
+			if (b >= 'A' && b <= 'Z') || b == '~' {
+				return string(seq)
+			}
+		case <-time.After(50 * time.Millisecond):
+			return string(seq)
+		}
+	}
+}
+
+func (w *watcher) redraw() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if len(w.history) == 0 {
+		return
+	}
+	idx := w.view
+	if idx < 0 {
+		idx = len(w.history) - 1
+	}
+	snap := w.history[idx]
+	cols, rows := term.Width(os.Stdout), term.Height(os.Stdout)
 
-		now := time.Now().Format("Mon Jan  2 15:04:05 2006")
-		fmt.Printf("Every %.1fs: %-40s  %s\n\n", *interval, cmd, now)
+	var out strings.Builder
+	out.WriteString(term.ClearScreen)
+	if !noTitle {
+		left := fmt.Sprintf("Every %.1fs: %s", *interval, w.title)
+		if w.view >= 0 {
+			left = fmt.Sprintf("[history %d/%d] %s", idx+1, len(w.history), left)
+		}
+		host, _ := os.Hostname()
+		right := fmt.Sprintf("%s: %s", host, snap.at.Format("Mon Jan _2 15:04:05 2006"))
+		pad := cols - term.StringWidth(left) - term.StringWidth(right)
+		if pad < 1 {
+			left = term.Truncate(left, max(cols-term.StringWidth(right)-1, 0), "")
+			pad = max(cols-term.StringWidth(left)-term.StringWidth(right), 1)
+		}
+		out.WriteString(left + strings.Repeat(" ", pad) + right + "\r\n\r\n")
+		rows -= 2
+	}
 
-		out, err := exec.Command("sh", "-c", cmd).CombinedOutput()
-		result := string(out)
+	cur := parseScreen(snap.output, cols, rows)
+	var highlight [][]bool
+	if differences != "" && idx > 0 {
+		prev := parseScreen(w.history[idx-1].output, cols, rows)
+		highlight = diffScreens(prev, cur)
+		if differences == "permanent" {
+			if w.view < 0 {
+				w.changed = mergeChanged(w.changed, highlight)
+			}
+			highlight = mergeChanged(highlight, w.changed)
+		}
+	}
+	renderScreen(&out, cur, highlight)
+	os.Stdout.WriteString(out.String())
+}
 
-		if *diff && iteration > 0 {
-			// Highlight lines that changed
-			prevLines := strings.Split(prev, "\n")
-			currLines := strings.Split(result, "\n")
-			for i, line := range currLines {
-				if i < len(prevLines) && line != prevLines[i] {
-					fmt.Printf("\033[7m%s\033[m\n", line) // reverse video
+// parseScreen lays command output onto a cols x rows grid, expanding tabs
+// and tracking SGR attributes when -c is set. Other escape sequences are
+// dropped so that they can't move the cursor.
+func parseScreen(s string, cols, rows int) screen {
+	var scr screen
+	var line []cell
+	attr := ""
+	flush := func() {
+		if len(scr) < rows {
+			scr = append(scr, line)
+		}
+		line = nil
+	}
+	for i := 0; i < len(s); {
+		if s[i] == 0x1b {
+			seq, n := scanEscape(s[i:])
+			i += n
+			if color && strings.HasSuffix(seq, "m") && strings.HasPrefix(seq, "\x1b[") {
+				if seq == "\x1b[m" || seq == "\x1b[0m" {
+					attr = ""
 				} else {
-					fmt.Println(line)
+					attr += seq
+				}
+			}
+			continue
+		}
+		r, size := utf8.DecodeRuneInString(s[i:])
+		i += size
+		switch r {
+		case '\n':
+			flush()
+			if len(scr) >= rows {
+				return scr
+			}
+		case '\r':
+		case '\t':
+			for {
+				if len(line) < cols {
+					line = append(line, cell{' ', attr})
+				}
+				if len(line)%8 == 0 || len(line) >= cols {
+					break
+				}
+			}
+		default:
+			if r < 0x20 {
+				continue
+			}
+			// A wide character takes two cells, the second one empty.
+			rw := max(term.RuneWidth(r), 1)
+			if len(line)+rw <= cols {
+				line = append(line, cell{r, attr})
+				if rw == 2 {
+					line = append(line, cell{0, attr})
 				}
 			}
-		} else {
-			fmt.Print(result)
 		}
+	}
+	if len(line) > 0 {
+		flush()
+	}
+	return scr
+}
 
-		if err != nil {
-			fmt.Fprintf(os.Stderr, "\nCommand exited with: %v\n", err)
+// scanEscape returns the escape sequence at the start of s and its length.
+func scanEscape(s string) (string, int) {
+	if len(s) < 2 {
+		return s, len(s)
+	}
+	switch s[1] {
+	case '[':
+		for j := 2; j < len(s); j++ {
+			if s[j] >= 0x40 && s[j] <= 0x7e {
+				return s[:j+1], j + 1
+			}
+		}
+		return s, len(s)
+	case ']':
+		for j := 2; j < len(s); j++ {
+			if s[j] == 0x07 {
+				return s[:j+1], j + 1
+			}
+			if s[j] == 0x1b && j+1 < len(s) && s[j+1] == '\\' {
+				return s[:j+2], j + 2
+			}
 		}
+		return s, len(s)
+	}
+	return s[:2], 2
+}
 
-		prev = result
-		iteration++
-		time.Sleep(time.Duration(*interval * float64(time.Second)))
+func cellAt(s screen, row, col int) (cell, bool) {
+	if row >= len(s) || col >= len(s[row]) {
+		return cell{' ', ""}, false
+	}
+	return s[row][col], true
+}
+
+// diffScreens marks every cell whose character differs between prev and
+// cur, including cells that held text in prev and are now past the end
+// of their line or of the output.
+func diffScreens(prev, cur screen) [][]bool {
+	out := make([][]bool, max(len(prev), len(cur)))
+	for r := range out {
+		cols := 0
+		if r < len(prev) {
+			cols = len(prev[r])
+		}
+		if r < len(cur) {
+			cols = max(cols, len(cur[r]))
+		}
+		out[r] = make([]bool, cols)
+		for c := range out[r] {
+			p, _ := cellAt(prev, r, c)
+			n, _ := cellAt(cur, r, c)
+			out[r][c] = p.r != n.r
+		}
+	}
+	return out
+}
+
+func mergeChanged(acc, add [][]bool) [][]bool {
+	for r := range add {
+		for len(acc) <= r {
+			acc = append(acc, nil)
+		}
+		for c := range add[r] {
+			for len(acc[r]) <= c {
+				acc[r] = append(acc[r], false)
+			}
+			acc[r][c] = acc[r][c] || add[r][c]
+		}
+	}
+	return acc
+}
+
+func renderScreen(out *strings.Builder, s screen, highlight [][]bool) {
+	// The screen is always a terminal, so -d highlights regardless of
+	// NO_COLOR, as procps watch does.
+	reverse, reset := term.ANSI.Seq(term.Reverse), term.ANSI.ResetSeq()
+	// Highlighted cells past the output are deleted text, drawn as
+	// blanks.
+	for r := 0; r < max(len(s), len(highlight)); r++ {
+		width := 0
+		if r < len(s) {
+			width = len(s[r])
+		}
+		if r < len(highlight) {
+			width = max(width, len(highlight[r]))
+		}
+		cur := ""
+		for c := 0; c < width; c++ {
+			ce, _ := cellAt(s, r, c)
+			attr := ce.attr
+			if r < len(highlight) && c < len(highlight[r]) && highlight[r][c] {
+				attr += reverse
+			}
+			if attr != cur {
+				out.WriteString(reset + attr)
+				cur = attr
+			}
+			if ce.r != 0 {
+				out.WriteRune(ce.r)
+			}
+		}
+		if cur != "" {
+			out.WriteString(reset)
+		}
+		out.WriteString("\r\n")
+	}
+}
+
+// ---- terminal handling ----
+
+// enterRawMode switches stdin to non-canonical, no-echo mode so arrow keys
+// can be read one byte at a time. It returns a function that restores the
+// previous settings and whether stdin is a terminal at all.
+func enterRawMode() (func(), bool) {
+	var old syscall.Termios
+	if _, _, err := syscall.Syscall(syscall.SYS_IOCTL, os.Stdin.Fd(),
+		syscall.TCGETS, uintptr(unsafe.Pointer(&old))); err != 0 {
+		return func() {}, false
+	}
+	raw := old
+	raw.Lflag &^= syscall.ICANON | syscall.ECHO
+	raw.Cc[syscall.VMIN] = 1
+	raw.Cc[syscall.VTIME] = 0
+	syscall.Syscall(syscall.SYS_IOCTL, os.Stdin.Fd(), syscall.TCSETS, uintptr(unsafe.Pointer(&raw)))
+	return func() {
+		syscall.Syscall(syscall.SYS_IOCTL, os.Stdin.Fd(), syscall.TCSETS, uintptr(unsafe.Pointer(&old)))
+	}, true
+}
+
+func readKeys(keys chan<- byte) {
+	buf := make([]byte, 1)
+	for {
+		n, err := os.Stdin.Read(buf)
+		if err != nil {
+			return
+		}
+		if n == 1 {
+			keys <- buf[0]
+		}
 	}
 }
@@ -1,74 +1,304 @@
-// xmlfmt - pretty-print or minify XML
+// xmlfmt - Pretty-print, check, query and canonicalize XML.
+//
+// Usage:
+//
+//	xmlfmt [OPTIONS] [FILE...]
+//
+// Reads each FILE (or standard input) and writes it indented, one element
+// per line. Documents are processed as a stream of tokens, so files larger
+// than memory can be formatted, checked and canonicalized. Errors give the
+// line and column, and cover what a well-formedness check needs:
+// mismatched tags, several root elements, duplicate attributes and
+// undeclared namespace prefixes. Entities declared in the internal DTD
+// subset are expanded; external entities and entities holding markup are
+// reported at the DOCTYPE, before the root element is written.
+//
+// Options:
+//
+//	-m, -minify     Write without indentation or whitespace between elements
+//	-i STRING       Indent with STRING (default: two spaces)
+//	-c              Only check that the input is well formed
+//	-x EXPR         Print the result of the XPath 1.0 expression EXPR
+//	-N PREFIX=URI   Bind a namespace prefix for -x (repeatable)
+//	-j              Convert XML to JSON
+//	-J              Convert JSON to XML
+//	-C              Write Canonical XML 1.0
+//	-E              Write Exclusive XML Canonicalization
+//	-comments       Keep comments in canonical XML
+//
+// -x prints each selected element as XML (or JSON with -j, canonical with
+// -C or -E) and any other node or result as text. It exits 1 if a node-set
+// result is empty. An unprefixed element name matches that local name in
+// any namespace, so default-namespaced documents such as Maven POMs need
+// no -N; "-N =URI" restricts unprefixed names to URI. A prefix not given
+// with -N means what the document binds it to. Location paths that only
+// look down from the elements they select, such as
+// //dependency[scope='test']/artifactId, are evaluated one such element
+// at a time; others load the whole document.
+//
+// -j maps attributes to "@name" members, text to "#text" and repeated
+// child elements to arrays, like confconv; -J reverses it. Both read the
+// whole document.
+//
+// Canonical output has no final newline, so it can be piped straight to a
+// digest. Comments are dropped unless -comments is given. With -x, each
+// selected element is canonicalized as a document subset, with the
+// namespaces (and, for -C, the xml: attributes) it inherits.
+//
+// Examples:
+//
+//	xmlfmt pom.xml
+//	xmlfmt -c *.xml
+//	xmlfmt -x '//dependency/artifactId/text()' pom.xml
+//	xmlfmt -x '//soap:Body/*' -N soap=http://schemas.xmlsoap.org/soap/envelope/ msg.xml
+//	xmlfmt -x "//*[@Id='body']" -E msg.xml | openssl dgst -sha256 -binary | base64
+//	xmlfmt -j feed.xml
 package main
 
 import (
+	"bufio"
 	"bytes"
 	"encoding/xml"
+	"errors"
+	"flag"
 	"fmt"
 	"io"
 	"os"
 	"strings"
+
+	"goutils/internal/cli"
+	"goutils/internal/config"
+	"goutils/internal/xmlutil"
 )
 
-func prettyPrint(data []byte, indent string) (string, error) {
-	var buf bytes.Buffer
-	decoder := xml.NewDecoder(bytes.NewReader(data))
-	encoder := xml.NewEncoder(&buf)
-	encoder.Indent("", indent)
+// nsList collects -N bindings.
+type nsList map[string]string
+
+func (l nsList) String() string { return "" }
+
+func (l nsList) Set(s string) error {
+	prefix, uri, ok := strings.Cut(s, "=")
+	if !ok || uri == "" {
+		return errors.New("want PREFIX=URI")
+	}
+	l[prefix] = uri
+	return nil
+}
+
+var (
+	minify     = flag.Bool("m", false, "write without indentation")
+	indent     = flag.String("i", "  ", "indent with `STRING`")
+	check      = flag.Bool("c", false, "only check that the input is well formed")
+	xpathExpr  = flag.String("x", "", "print the result of XPath `EXPR`")
+	toJSON     = flag.Bool("j", false, "convert XML to JSON")
+	fromJSON   = flag.Bool("J", false, "convert JSON to XML")
+	canonical  = flag.Bool("C", false, "write Canonical XML 1.0")
+	exclusive  = flag.Bool("E", false, "write Exclusive XML Canonicalization")
+	comments   = flag.Bool("comments", false, "keep comments in canonical XML")
+	namespaces = nsList{}
+
+	expr *xmlutil.Expr
+	out  = bufio.NewWriter(os.Stdout)
+)
+
+func init() {
+	flag.BoolVar(minify, "minify", false, "same as -m")
+	flag.Var(namespaces, "N", "bind `PREFIX=URI` for -x (repeatable)")
+}
+
+func usage() {
+	fmt.Fprintln(os.Stderr, "usage: xmlfmt [OPTIONS] [FILE...]")
+	flag.PrintDefaults()
+	os.Exit(2)
+}
+
+func indentString() string {
+	if *minify {
+		return ""
+	}
+	return *indent
+}
+
+// jsonOptions indents JSON by the width of -i.
+func jsonOptions() config.Options {
+	if *minify {
+		return config.Options{}
+	}
+	return config.Options{Indent: len(strings.ReplaceAll(*indent, "\t", "    "))}
+}
+
+// process handles one input; matched reports whether -x selected
+// anything.
+func process(r io.Reader) (matched bool, err error) {
+	switch {
+	case *fromJSON:
+		data, err := io.ReadAll(r)
+		if err != nil {
+			return false, err
+		}
+		v, err := config.Lookup("json").Decode(data)
+		if err != nil {
+			return false, err
+		}
+		return false, config.Lookup("xml").Encode(out, v, jsonOptions())
+	case *xpathExpr != "":
+		err := expr.Stream(xmlutil.NewReader(r), func(v interface{}) error {
+			m, err := printResult(v)
+			matched = matched || m
+			return err
+		})
+		return matched, err
+	case *toJSON:
+		data, err := io.ReadAll(r)
+		if err != nil {
+			return false, err
+		}
+		if err := checkAll(bytes.NewReader(data)); err != nil {
+			return false, err
+		}
+		v, err := config.Lookup("xml").Decode(data)
+		if err != nil {
+			return false, err
+		}
+		return false, config.Lookup("json").Encode(out, v, jsonOptions())
+	case *check:
+		return false, checkAll(r)
+	}
+	xr := xmlutil.NewReader(r)
+	if *canonical || *exclusive {
+		c := xmlutil.NewCanonicalizer(out, *exclusive, *comments)
+		return false, copyTokens(xr, c.Token, c.Flush)
+	}
+	w := xmlutil.NewWriter(out, indentString())
+	return false, copyTokens(xr, w.Token, w.Flush)
+}
+
+// copyTokens passes every token of a document to write. Output already
+// written is flushed when the input turns out to be malformed.
+func copyTokens(r *xmlutil.Reader, write func(xml.Token) error, flush func() error) error {
 	for {
-		token, err := decoder.Token()
-		if err == io.EOF { break }
-		if err != nil { return "", err }
-		if err := encoder.EncodeToken(token); err != nil { return "", err }
+		tok, err := r.Token()
+		if err == io.EOF {
+			return flush()
+		}
+		if err != nil {
+			flush()
+			return err
+		}
+		if err := write(tok); err != nil {
+			return err
+		}
 	}
-	if err := encoder.Flush(); err != nil { return "", err }
-	return buf.String(), nil
 }
 
-func minify(data []byte) (string, error) {
-	var buf bytes.Buffer
-	decoder := xml.NewDecoder(bytes.NewReader(data))
-	encoder := xml.NewEncoder(&buf)
+func checkAll(r io.Reader) error {
+	xr := xmlutil.NewReader(r)
 	for {
-		token, err := decoder.Token()
-		if err == io.EOF { break }
-		if err != nil { return "", err }
-		if cd, ok := token.(xml.CharData); ok {
-			trimmed := strings.TrimSpace(string(cd))
-			if trimmed == "" { continue }
-			token = xml.CharData(trimmed)
-		}
-		if err := encoder.EncodeToken(token); err != nil { return "", err }
-	}
-	encoder.Flush()
-	return buf.String(), nil
+		if _, err := xr.Token(); err != nil {
+			if err == io.EOF {
+				return nil
+			}
+			return err
+		}
+	}
 }
 
-func main() {
-	doMinify := false
-	indent := "  "
-	files := []string{}
-	for i := 1; i < len(os.Args); i++ {
-		switch os.Args[i] {
-		case "-m", "--minify": doMinify = true
-		case "-i": i++; indent = os.Args[i]
-		default: files = append(files, os.Args[i])
+// printResult prints a result of -x.
+func printResult(v interface{}) (matched bool, err error) {
+	nodes, ok := v.([]*xmlutil.Node)
+	if !ok {
+		fmt.Fprintln(out, xmlutil.String(v))
+		return true, nil
+	}
+	for _, n := range nodes {
+		switch n.Type {
+		case xmlutil.RootNode, xmlutil.ElementNode:
+			if err := writeNode(n); err != nil {
+				return false, err
+			}
+		default:
+			fmt.Fprintln(out, n.Value())
 		}
 	}
+	return len(nodes) > 0, nil
+}
 
-	process := func(r io.Reader, name string) {
-		data, err := io.ReadAll(r)
-		if err != nil { fmt.Fprintln(os.Stderr, err); return }
-		var out string
-		if doMinify { out, err = minify(data) } else { out, err = prettyPrint(data, indent) }
-		if err != nil { fmt.Fprintf(os.Stderr, "xmlfmt: %s: %v\n", name, err); return }
-		fmt.Println(out)
+func writeNode(n *xmlutil.Node) error {
+	switch {
+	case *toJSON:
+		var buf bytes.Buffer
+		w := xmlutil.NewWriter(&buf, "")
+		if err := n.Tokens(w.Token); err != nil {
+			return err
+		}
+		w.Flush()
+		v, err := config.Lookup("xml").Decode(buf.Bytes())
+		if err != nil {
+			return err
+		}
+		return config.Lookup("json").Encode(out, v, jsonOptions())
+	case *canonical || *exclusive:
+		c := xmlutil.NewCanonicalizer(out, *exclusive, *comments)
+		if n.Type == xmlutil.ElementNode {
+			c.SetContext(xmlutil.Context(n))
+		}
+		if err := n.Tokens(c.Token); err != nil {
+			return err
+		}
+		return c.Flush()
+	}
+	w := xmlutil.NewWriter(out, indentString())
+	if err := n.Tokens(w.Token); err != nil {
+		return err
+	}
+	return w.Flush()
+}
+
+func main() {
+	flag.Usage = usage
+	files := cli.Parse(flag.CommandLine, os.Args[1:])
+	modes := 0
+	for _, on := range []bool{*check, *fromJSON, *canonical || *exclusive, *toJSON && *xpathExpr == ""} {
+		if on {
+			modes++
+		}
+	}
+	if modes > 1 || *canonical && *exclusive || *check && *xpathExpr != "" || *fromJSON && *xpathExpr != "" {
+		usage()
+	}
+	if *xpathExpr != "" {
+		var err error
+		if expr, err = xmlutil.Compile(*xpathExpr, namespaces); err != nil {
+			fmt.Fprintf(os.Stderr, "xmlfmt: %v\n", err)
+			os.Exit(2)
+		}
 	}
 
-	if len(files) == 0 { process(os.Stdin, "<stdin>"); return }
+	status, matched := 0, false
+	run := func(r io.Reader, name string) {
+		m, err := process(r)
+		matched = matched || m
+		out.Flush()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "xmlfmt: %s: %v\n", name, err)
+			status = 1
+		}
+	}
+	if len(files) == 0 {
+		run(os.Stdin, "<stdin>")
+	}
 	for _, f := range files {
 		fh, err := os.Open(f)
-		if err != nil { fmt.Fprintln(os.Stderr, err); continue }
-		process(fh, f); fh.Close()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "xmlfmt: %v\n", err)
+			status = 1
+			continue
+		}
+		run(fh, f)
+		fh.Close()
+	}
+	if *xpathExpr != "" && !matched && status == 0 {
+		status = 1
 	}
+	os.Exit(status)
 }
@@ -2,7 +2,6 @@ package main
 
 import (
 	"fmt"
-	"io"
 	"os"
 	"path/filepath"
 	"strings"
@@ -10,90 +9,224 @@ import (
 
 func main() {
 	args := os.Args[1:]
-	recursive := false
-	force := false
-	preserve := false
-	interactive := false
-	verbose := false
+	opt := copyOptions{prog: "cp", deref: -1, reflink: "auto", sparse: "auto", suffix: "~"}
+	if s := os.Getenv("SIMPLE_BACKUP_SUFFIX"); s != "" {
+		opt.suffix = s
+	}
+	targetDir := ""
+	noTargetDir := false
 	files := []string{}
+
+	fail := func(format string, a ...interface{}) {
+		fmt.Fprintf(os.Stderr, "cp: "+format+"\n", a...)
+		os.Exit(1)
+	}
+	setPreserve := func(list string, on bool) {
+		for _, attr := range strings.Split(list, ",") {
+			switch attr {
+			case "mode":
+				opt.preserveMode = on
+			case "ownership":
+				opt.preserveOwner = on
+			case "timestamps":
+				opt.preserveTimes = on
+			case "links":
+				opt.preserveLinks = on
+			case "xattr":
+				opt.preserveXattrs = on
+			case "all":
+				opt.preserveMode, opt.preserveOwner, opt.preserveTimes = on, on, on
+				opt.preserveLinks, opt.preserveXattrs = on, on
+			case "context":
+			default:
+				fail("invalid argument '%s' for '--preserve'", attr)
+			}
+		}
+	}
+	setBackup := func(s string) {
+		if s == "" {
+			s = os.Getenv("VERSION_CONTROL")
+		}
+		b, err := parseBackup(s)
+		if err != nil {
+			fail("%v", err)
+		}
+		opt.backup = b
+	}
+	archive := func() {
+		opt.recursive = true
+		opt.deref = derefNever
+		setPreserve("all", true)
+	}
+
 	for i := 0; i < len(args); i++ {
 		a := args[i]
+		next := func() string {
+			if i+1 >= len(args) {
+				fail("option requires an argument -- '%s'", strings.TrimLeft(a, "-"))
+			}
+			i++
+			return args[i]
+		}
 		switch {
-		case a == "-r" || a == "-R" || a == "--recursive":
-			recursive = true
-		case a == "-f" || a == "--force":
-			force = true
-		case a == "-p" || a == "--preserve":
-			preserve = true
-		case a == "-i" || a == "--interactive":
-			interactive = true
-		case a == "-v" || a == "--verbose":
-			verbose = true
-		case a == "-a" || a == "--archive":
-			recursive, preserve = true, true
-		case !strings.HasPrefix(a, "-"):
+		case a == "--":
+			files = append(files, args[i+1:]...)
+			i = len(args)
+		case strings.HasPrefix(a, "--"):
+			name, val, hasVal := strings.Cut(a[2:], "=")
+			switch name {
+			case "archive":
+				archive()
+			case "recursive":
+				opt.recursive = true
+			case "force":
+				opt.force, opt.overwrite = true, overwriteYes
+			case "interactive":
+				opt.overwrite = overwriteAsk
+			case "no-clobber":
+				opt.overwrite = overwriteNo
+			case "update":
+				opt.update = true
+			case "verbose":
+				opt.verbose = true
+			case "no-dereference":
+				opt.deref = derefNever
+			case "dereference":
+				opt.deref = derefAlways
+			case "preserve":
+				if !hasVal {
+					val = "mode,ownership,timestamps"
+				}
+				setPreserve(val, true)
+			case "no-preserve":
+				setPreserve(val, false)
+			case "backup":
+				setBackup(val)
+			case "suffix":
+				if !hasVal {
+					val = next()
+				}
+				opt.suffix = val
+			case "target-directory":
+				if !hasVal {
+					val = next()
+				}
+				targetDir = val
+			case "no-target-directory":
+				noTargetDir = true
+			case "reflink":
+				switch val {
+				case "", "always":
+					opt.reflink = "always"
+				case "auto", "never":
+					opt.reflink = val
+				default:
+					fail("invalid argument '%s' for '--reflink'", val)
+				}
+			case "sparse":
+				switch val {
+				case "auto", "always", "never":
+					opt.sparse = val
+				default:
+					fail("invalid argument '%s' for '--sparse'", val)
+				}
+			default:
+				fail("unrecognized option '%s'", a)
+			}
+		case strings.HasPrefix(a, "-") && len(a) > 1:
+			for j := 1; j < len(a); j++ {
+				switch a[j] {
+				case 'a':
+					archive()
+				case 'r', 'R':
+					opt.recursive = true
+				case 'f':
+					opt.force, opt.overwrite = true, overwriteYes
+				case 'i':
+					opt.overwrite = overwriteAsk
+				case 'n':
+					opt.overwrite = overwriteNo
+				case 'u':
+					opt.update = true
+				case 'v':
+					opt.verbose = true
+				case 'p':
+					setPreserve("mode,ownership,timestamps", true)
+				case 'd':
+					opt.deref = derefNever
+					opt.preserveLinks = true
+				case 'P':
+					opt.deref = derefNever
+				case 'L':
+					opt.deref = derefAlways
+				case 'H':
+					opt.deref = derefArgs
+				case 'b':
+					setBackup("")
+				case 'T':
+					noTargetDir = true
+				case 'S', 't':
+					val := a[j+1:]
+					if val == "" {
+						val = next()
+					}
+					if a[j] == 'S' {
+						opt.suffix = val
+					} else {
+						targetDir = val
+					}
+					j = len(a)
+				default:
+					fail("invalid option -- '%c'", a[j])
+				}
+			}
+		default:
 			files = append(files, a)
 		}
 	}
-	_ = force
-	_ = interactive
-	if len(files) < 2 {
-		fmt.Fprintln(os.Stderr, "cp: missing destination")
-		os.Exit(1)
+	if opt.deref < 0 {
+		// Symlinks are followed unless copying recursively.
+		opt.deref = derefAlways
+		if opt.recursive {
+			opt.deref = derefNever
+		}
 	}
-	dest := files[len(files)-1]
-	srcs := files[:len(files)-1]
 
-	copyFile := func(src, dst string) error {
-		srcInfo, err := os.Stat(src)
-		if err != nil {
-			return err
+	if targetDir != "" && noTargetDir {
+		fail("cannot combine --target-directory (-t) and --no-target-directory (-T)")
+	}
+	if len(files) == 0 {
+		fail("missing file operand")
+	}
+	if targetDir == "" {
+		if len(files) == 1 {
+			fail("missing destination file operand after '%s'", files[0])
 		}
-		if srcInfo.IsDir() {
-			if !recursive {
-				return fmt.Errorf("omitting directory '%s'", src)
+		targetDir = files[len(files)-1]
+		files = files[:len(files)-1]
+		if noTargetDir {
+			if len(files) > 1 {
+				fail("extra operand '%s'", files[1])
 			}
-			os.MkdirAll(dst, srcInfo.Mode())
-			entries, _ := os.ReadDir(src)
-			for _, e := range entries {
-				copyFile(filepath.Join(src, e.Name()), filepath.Join(dst, e.Name()))
+		} else if info, err := os.Stat(targetDir); err != nil || !info.IsDir() {
+			if len(files) > 1 {
+				fail("target '%s' is not a directory", targetDir)
 			}
-			return nil
-		}
-		in, err := os.Open(src)
-		if err != nil {
-			return err
+			noTargetDir = true
 		}
-		defer in.Close()
-		mode := srcInfo.Mode()
-		out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, mode)
-		if err != nil {
-			return err
-		}
-		defer out.Close()
-		if _, err := io.Copy(out, in); err != nil {
-			return err
-		}
-		if preserve {
-			os.Chtimes(dst, srcInfo.ModTime(), srcInfo.ModTime())
-		}
-		return nil
+	} else if info, err := os.Stat(targetDir); err != nil || !info.IsDir() {
+		fail("target '%s' is not a directory", targetDir)
 	}
 
-	destInfo, destErr := os.Stat(dest)
-	exitCode := 0
-	for _, src := range srcs {
-		dst := dest
-		if destErr == nil && destInfo.IsDir() {
-			dst = filepath.Join(dest, filepath.Base(src))
-		}
-		if verbose {
-			fmt.Printf("'%s' -> '%s'\n", src, dst)
-		}
-		if err := copyFile(src, dst); err != nil {
-			fmt.Fprintf(os.Stderr, "cp: %v\n", err)
-			exitCode = 1
+	c := newCopier(opt)
+	for _, src := range files {
+		dst := targetDir
+		if !noTargetDir {
+			dst = filepath.Join(targetDir, filepath.Base(src))
 		}
+		c.copy(src, dst, true)
+	}
+	if c.failed {
+		os.Exit(1)
 	}
-	os.Exit(exitCode)
 }
@@ -1,104 +1,154 @@
 package main
 
 import (
-	"bufio"
 	"fmt"
 	"os"
-	"regexp"
 	"strconv"
 	"strings"
+
+	"goutils/lib/split"
+)
+
+var (
+	prefix = "xx"
+	suffix = "%02d"
+	keep   bool
+	quiet  bool
+	elide  bool
 )
 
+func fail(format string, a ...interface{}) {
+	fmt.Fprintf(os.Stderr, "csplit: "+format+"\n", a...)
+	os.Exit(1)
+}
+
+// checkSuffix checks a -b format and returns it in Go's terms.
+func checkSuffix(f string) string {
+	s, err := split.CheckSuffix(f)
+	if err != nil {
+		fail("%v", err)
+	}
+	return s
+}
+
 func main() {
 	args := os.Args[1:]
-	prefix := "xx"
-	silent := false
-	keepFiles := false
-	files := []string{}
-	patterns := []string{}
+	digits, suffixGiven := -1, false
+	operands := []string{}
 
-	i := 0
-	for i < len(args) {
+	for i := 0; i < len(args); i++ {
 		a := args[i]
-		switch {
-		case a == "-f" && i+1 < len(args):
+		next := func() string {
+			if i+1 >= len(args) {
+				fail("option requires an argument -- '%s'", strings.TrimLeft(a, "-"))
+			}
 			i++
-			prefix = args[i]
-		case strings.HasPrefix(a, "-f"):
-			prefix = a[2:]
-		case a == "-s" || a == "--silent" || a == "--quiet":
-			silent = true
-		case a == "-k" || a == "--keep-files":
-			keepFiles = true
-		case !strings.HasPrefix(a, "-"):
-			if len(files) == 0 {
-				files = append(files, a)
-			} else {
-				patterns = append(patterns, a)
+			return args[i]
+		}
+		switch {
+		case a == "--":
+			operands = append(operands, args[i+1:]...)
+			i = len(args)
+		case strings.HasPrefix(a, "--"):
+			name, val, hasVal := strings.Cut(a[2:], "=")
+			needVal := func() string {
+				if !hasVal {
+					val = next()
+				}
+				return val
+			}
+			switch name {
+			case "suffix-format":
+				suffix, suffixGiven = checkSuffix(needVal()), true
+			case "prefix":
+				prefix = needVal()
+			case "keep-files":
+				keep = true
+			case "digits":
+				n, err := strconv.Atoi(needVal())
+				if err != nil || n < 0 {
+					fail("invalid number: '%s'", val)
+				}
+				digits = n
+			case "quiet", "silent":
+				quiet = true
+			case "elide-empty-files":
+				elide = true
+			default:
+				fail("unrecognized option '%s'", a)
 			}
+		case strings.HasPrefix(a, "-") && len(a) > 1:
+			for j := 1; j < len(a); j++ {
+				c := a[j]
+				arg := func() string {
+					val := a[j+1:]
+					if val == "" {
+						val = next()
+					}
+					j = len(a)
+					return val
+				}
+				switch c {
+				case 'b':
+					suffix, suffixGiven = checkSuffix(arg()), true
+				case 'f':
+					prefix = arg()
+				case 'k':
+					keep = true
+				case 'n':
+					val := arg()
+					n, err := strconv.Atoi(val)
+					if err != nil || n < 0 {
+						fail("invalid number: '%s'", val)
+					}
+					digits = n
+				case 's', 'q':
+					quiet = true
+				case 'z':
+					elide = true
+				default:
+					fail("invalid option -- '%c'", c)
+				}
+			}
+		default:
+			operands = append(operands, a)
 		}
-		i++
 	}
-	_ = keepFiles
-
-	if len(files) == 0 {
-		fmt.Fprintln(os.Stderr, "csplit: missing operand")
-		os.Exit(1)
+	switch len(operands) {
+	case 0:
+		fail("missing operand")
+	case 1:
+		fail("missing operand after '%s'", operands[0])
+	}
+	if digits >= 0 && !suffixGiven {
+		suffix = "%0" + strconv.Itoa(digits) + "d"
 	}
 
-	var r *os.File
-	if files[0] == "-" {
-		r = os.Stdin
-	} else {
-		var err error
-		r, err = os.Open(files[0])
+	in := os.Stdin
+	if operands[0] != "-" {
+		f, err := os.Open(operands[0])
 		if err != nil {
-			fmt.Fprintf(os.Stderr, "csplit: %v\n", err)
-			os.Exit(1)
+			fail("cannot open '%s' for reading: %s", operands[0], errText(err))
 		}
-		defer r.Close()
+		defer f.Close()
+		in = f
 	}
-
-	var lines []string
-	sc := bufio.NewScanner(r)
-	for sc.Scan() {
-		lines = append(lines, sc.Text())
-	}
-
-	// Split points: line numbers or regex
-	splits := []int{0}
-	for _, p := range patterns {
-		if strings.HasPrefix(p, "/") && strings.HasSuffix(p, "/") {
-			re := regexp.MustCompile(p[1 : len(p)-1])
-			for idx, l := range lines {
-				if re.MatchString(l) {
-					splits = append(splits, idx)
-					break
-				}
+	if err := split.Context(in, operands[1:], split.ContextOptions{
+		Prefix: prefix,
+		Suffix: suffix,
+		Keep:   keep,
+		Quiet:  quiet,
+		Elide:  elide,
+		Compile: func(pat string) (split.Matcher, error) {
+			re, err := compileBRE(pat)
+			if err != nil {
+				return nil, err
 			}
-		} else if n, err := strconv.Atoi(p); err == nil {
-			splits = append(splits, n-1)
-		}
-	}
-	splits = append(splits, len(lines))
-
-	fileNum := 0
-	for i := 0; i < len(splits)-1; i++ {
-		start, end := splits[i], splits[i+1]
-		if end > len(lines) {
-			end = len(lines)
-		}
-		chunk := strings.Join(lines[start:end], "\n")
-		if end > start {
-			chunk += "\n"
-		}
-		name := fmt.Sprintf("%s%02d", prefix, fileNum)
-		if err := os.WriteFile(name, []byte(chunk), 0644); err != nil {
-			fmt.Fprintf(os.Stderr, "csplit: %v\n", err)
-		}
-		if !silent {
-			fmt.Println(len(chunk))
-		}
-		fileNum++
+			return re, nil
+		},
+		ErrText: errText,
+	}); err != nil {
+		fmt.Fprintf(os.Stderr, "csplit: %v\n", err)
+		os.Exit(err.(*split.Error).Status)
 	}
 }
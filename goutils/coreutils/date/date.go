@@ -1,137 +1,227 @@
 package main
 
 import (
+	"bufio"
 	"fmt"
 	"os"
 	"strings"
 	"time"
+
+	"goutils/lib/datetime"
+)
+
+// isoFormats are the -I precisions, and rfc3339Formats the --rfc-3339
+// ones.
+var (
+	isoFormats = map[string]string{
+		"date":    "%Y-%m-%d",
+		"hours":   "%Y-%m-%dT%H%:z",
+		"minutes": "%Y-%m-%dT%H:%M%:z",
+		"seconds": "%Y-%m-%dT%H:%M:%S%:z",
+		"ns":      "%Y-%m-%dT%H:%M:%S,%N%:z",
+	}
+	rfc3339Formats = map[string]string{
+		"date":    "%Y-%m-%d",
+		"seconds": "%Y-%m-%d %H:%M:%S%:z",
+		"ns":      "%Y-%m-%d %H:%M:%S.%N%:z",
+	}
 )
 
+func fail(format string, a ...interface{}) {
+	fmt.Fprintf(os.Stderr, "date: "+format+"\n", a...)
+	os.Exit(1)
+}
+
+// precision looks up the argument of --iso-8601 or --rfc-3339, which may
+// be abbreviated.
+func precision(option, val string, formats map[string]string) string {
+	if f, ok := formats[val]; ok {
+		return f
+	}
+	match := ""
+	for name, f := range formats {
+		if val != "" && strings.HasPrefix(name, val) {
+			if match != "" {
+				fail("ambiguous argument '%s' for '--%s'", val, option)
+			}
+			match = f
+		}
+	}
+	if match == "" {
+		fail("invalid argument '%s' for '--%s'", val, option)
+	}
+	return match
+}
+
 func main() {
 	args := os.Args[1:]
-	format := ""
-	utc := false
-	setDate := ""
+	var (
+		format    string
+		formats   int // output formats given, of which only one is allowed
+		dateStr   string
+		dateFile  string
+		reference string
+		sources   = map[byte]bool{} // which of -d, -f and -r were given; only one may be
+		operands  []string
+	)
+	setFormat := func(f string) {
+		format = f
+		formats++
+	}
 
 	for i := 0; i < len(args); i++ {
 		a := args[i]
-		switch {
-		case a == "-u" || a == "--utc" || a == "--universal":
-			utc = true
-		case a == "-d" && i+1 < len(args):
+		next := func() string {
+			if i+1 >= len(args) {
+				fail("option requires an argument -- '%s'", strings.TrimLeft(a, "-"))
+			}
 			i++
-			setDate = args[i]
-		case strings.HasPrefix(a, "-d"):
-			setDate = a[2:]
-		case strings.HasPrefix(a, "+"):
-			format = a[1:]
-		}
-	}
-
-	var t time.Time
-	if setDate != "" {
-		var err error
-		layouts := []string{
-			"2006-01-02 15:04:05",
-			"2006-01-02T15:04:05",
-			"Mon Jan 2 15:04:05 MST 2006",
-			"January 2, 2006",
-			"2006-01-02",
+			return args[i]
 		}
-		for _, l := range layouts {
-			t, err = time.Parse(l, setDate)
-			if err == nil {
-				break
+		switch {
+		case a == "--":
+			operands = append(operands, args[i+1:]...)
+			i = len(args)
+		case strings.HasPrefix(a, "--"):
+			name, val, hasVal := strings.Cut(a[2:], "=")
+			switch name {
+			case "date", "file", "reference":
+				if !hasVal {
+					val = next()
+				}
+				sources[name[0]] = true
+				switch name {
+				case "date":
+					dateStr = val
+				case "file":
+					dateFile = val
+				default:
+					reference = val
+				}
+			case "utc", "universal":
+				os.Setenv("TZ", "UTC0")
+			case "iso-8601":
+				if !hasVal {
+					val = "date"
+				}
+				setFormat(precision(name, val, isoFormats))
+			case "rfc-3339":
+				if !hasVal {
+					fail("option '--rfc-3339' requires an argument")
+				}
+				setFormat(precision(name, val, rfc3339Formats))
+			case "rfc-email", "rfc-2822", "rfc-822":
+				setFormat("%a, %d %b %Y %H:%M:%S %z")
+			default:
+				fail("unrecognized option '%s'", a)
 			}
+		case strings.HasPrefix(a, "-") && len(a) > 1:
+			for j := 1; j < len(a); j++ {
+				switch c := a[j]; c {
+				case 'u':
+					os.Setenv("TZ", "UTC0")
+				case 'R':
+					setFormat("%a, %d %b %Y %H:%M:%S %z")
+				case 'I':
+					// -I takes its precision only when attached.
+					val := a[j+1:]
+					if val == "" {
+						val = "date"
+					}
+					setFormat(precision("iso-8601", val, isoFormats))
+					j = len(a)
+				case 'd', 'f', 'r':
+					val := a[j+1:]
+					if val == "" {
+						val = next()
+					}
+					sources[c] = true
+					switch c {
+					case 'd':
+						dateStr = val
+					case 'f':
+						dateFile = val
+					default:
+						reference = val
+					}
+					j = len(a)
+				default:
+					fail("invalid option -- '%c'", c)
+				}
+			}
+		default:
+			operands = append(operands, a)
 		}
-		if err != nil {
-			fmt.Fprintf(os.Stderr, "date: invalid date '%s'\n", setDate)
-			os.Exit(1)
-		}
-	} else {
-		t = time.Now()
 	}
 
-	if utc {
-		t = t.UTC()
+	if len(sources) > 1 {
+		fail("the options to specify dates for printing are mutually exclusive")
 	}
-
-	if format == "" {
-		// Default format: Mon Jan  2 15:04:05 MST 2006
-		fmt.Println(t.Format("Mon Jan  2 15:04:05 MST 2006"))
-		return
+	if len(operands) > 1 {
+		fail("extra operand '%s'", operands[1])
+	}
+	if len(operands) == 1 {
+		if !strings.HasPrefix(operands[0], "+") {
+			fail("invalid date '%s'", operands[0])
+		}
+		setFormat(operands[0][1:])
+	}
+	if formats > 1 {
+		fail("multiple output formats specified")
+	}
+	if formats == 0 {
+		format = "%a %b %e %H:%M:%S %Z %Y"
 	}
 
-	// Convert strftime format to Go format
-	result := strftimeFormat(format, t)
-	fmt.Println(result)
-}
+	loc := datetime.Local()
+	now := time.Now().In(loc)
+	out := bufio.NewWriter(os.Stdout)
+	defer out.Flush()
+	show := func(t time.Time) {
+		out.WriteString(datetime.Strftime(format, t.In(loc)))
+		out.WriteByte('\n')
+	}
 
-func strftimeFormat(format string, t time.Time) string {
-	var sb strings.Builder
-	for i := 0; i < len(format); i++ {
-		if format[i] != '%' || i+1 >= len(format) {
-			sb.WriteByte(format[i])
-			continue
+	switch {
+	case dateFile != "":
+		f := os.Stdin
+		if dateFile != "-" {
+			var err error
+			if f, err = os.Open(dateFile); err != nil {
+				fail("%s: %s", dateFile, errText(err))
+			}
+			defer f.Close()
 		}
-		i++
-		switch format[i] {
-		case 'Y':
-			sb.WriteString(t.Format("2006"))
-		case 'y':
-			sb.WriteString(t.Format("06"))
-		case 'm':
-			sb.WriteString(t.Format("01"))
-		case 'd':
-			sb.WriteString(t.Format("02"))
-		case 'H':
-			sb.WriteString(t.Format("15"))
-		case 'M':
-			sb.WriteString(t.Format("04"))
-		case 'S':
-			sb.WriteString(t.Format("05"))
-		case 'A':
-			sb.WriteString(t.Format("Monday"))
-		case 'a':
-			sb.WriteString(t.Format("Mon"))
-		case 'B':
-			sb.WriteString(t.Format("January"))
-		case 'b', 'h':
-			sb.WriteString(t.Format("Jan"))
-		case 'I':
-			sb.WriteString(t.Format("03"))
-		case 'p':
-			sb.WriteString(t.Format("PM"))
-		case 'P':
-			sb.WriteString(strings.ToLower(t.Format("PM")))
-		case 'Z':
-			sb.WriteString(t.Format("MST"))
-		case 'z':
-			sb.WriteString(t.Format("-0700"))
-		case 'j':
-			sb.WriteString(fmt.Sprintf("%03d", t.YearDay()))
-		case 'u':
-			d := int(t.Weekday())
-			if d == 0 {
-				d = 7
+		status := 0
+		sc := bufio.NewScanner(f)
+		for sc.Scan() {
+			t, err := datetime.Parse(sc.Text(), now)
+			if err != nil {
+				out.Flush()
+				fmt.Fprintf(os.Stderr, "date: %v\n", err)
+				status = 1
+				continue
 			}
-			sb.WriteString(fmt.Sprintf("%d", d))
-		case 'w':
-			sb.WriteString(fmt.Sprintf("%d", int(t.Weekday())))
-		case 'e':
-			sb.WriteString(fmt.Sprintf("%2d", t.Day()))
-		case 'n':
-			sb.WriteByte('\n')
-		case 't':
-			sb.WriteByte('\t')
-		case '%':
-			sb.WriteByte('%')
-		case 's':
-			sb.WriteString(fmt.Sprintf("%d", t.Unix()))
-		default:
-			sb.WriteByte('%')
-			sb.WriteByte(format[i])
+			show(t)
+		}
+		out.Flush()
+		if err := sc.Err(); err != nil {
+			fail("%s: %s", dateFile, errText(err))
+		}
+		os.Exit(status)
+	case reference != "":
+		info, err := os.Stat(reference)
+		if err != nil {
+			fail("%s: %s", reference, errText(err))
+		}
+		show(info.ModTime())
+	case sources['d']:
+		t, err := datetime.Parse(dateStr, now)
+		if err != nil {
+			fail("%v", err)
 		}
+		show(t)
+	default:
+		show(now)
 	}
-	return sb.String()
 }
@@ -4,113 +4,626 @@ import (
 	"fmt"
 	"io"
 	"os"
+	"os/signal"
 	"strconv"
 	"strings"
+	"sync"
+	"syscall"
+	"time"
+	"unsafe"
 )
 
+// openFlags are the iflag= and oflag= values that are open(2) flags.
+var openFlags = map[string]int{
+	"append":   syscall.O_APPEND,
+	"direct":   syscall.O_DIRECT,
+	"dsync":    syscall.O_DSYNC,
+	"sync":     syscall.O_SYNC,
+	"nonblock": syscall.O_NONBLOCK,
+	"noatime":  syscall.O_NOATIME,
+	"noctty":   syscall.O_NOCTTY,
+	"nofollow": syscall.O_NOFOLLOW,
+}
+
+// ddFlags are the flags that change how dd counts and seeks.
+var ddFlags = []string{"fullblock", "count_bytes", "skip_bytes", "seek_bytes"}
+
+var convNames = []string{"ascii", "ebcdic", "ibm", "block", "unblock", "lcase", "ucase",
+	"sparse", "swab", "sync", "excl", "nocreat", "notrunc", "noerror", "fdatasync", "fsync"}
+
+// stats are the transfer counts, shared with the signal handler.
+type stats struct {
+	sync.Mutex
+	start               time.Time
+	inFull, inPartial   int64
+	outFull, outPartial int64
+	bytes               int64
+	progressLen         int
+}
+
+var (
+	st      stats
+	status  = ""
+	inName  = "standard input"
+	outName = "standard output"
+)
+
+func fatalf(format string, a ...interface{}) {
+	fmt.Fprintf(os.Stderr, "dd: "+format+"\n", a...)
+	os.Exit(1)
+}
+
+// sizeSuffixes are the multipliers dd accepts after a number.
+var sizeSuffixes = map[string]int64{
+	"": 1, "c": 1, "w": 2, "b": 512,
+	"kB": 1e3, "K": 1 << 10, "k": 1 << 10, "KiB": 1 << 10,
+	"MB": 1e6, "M": 1 << 20, "MiB": 1 << 20,
+	"GB": 1e9, "G": 1 << 30, "GiB": 1 << 30,
+	"TB": 1e12, "T": 1 << 40, "TiB": 1 << 40,
+	"PB": 1e15, "P": 1 << 50, "PiB": 1 << 50,
+}
+
+// parseNumber parses a dd operand value: a number with an optional
+// suffix, or a product of them such as 2x512.
+func parseNumber(v string) int64 {
+	n := int64(1)
+	for _, f := range strings.Split(v, "x") {
+		end := 0
+		for end < len(f) && f[end] >= '0' && f[end] <= '9' {
+			end++
+		}
+		m, ok := sizeSuffixes[f[end:]]
+		d, err := strconv.ParseInt(f[:end], 10, 64)
+		if !ok || err != nil {
+			fatalf("invalid number: '%s'", v)
+		}
+		n *= d * m
+	}
+	return n
+}
+
+// parseBlockSize parses a block size, which must be positive.
+func parseBlockSize(v string) int64 {
+	n := parseNumber(v)
+	if n <= 0 {
+		fatalf("invalid number: '%s'", v)
+	}
+	return n
+}
+
+func parseList(operand, v string, names map[string]bool) map[string]bool {
+	set := map[string]bool{}
+	for _, f := range strings.Split(v, ",") {
+		if !names[f] {
+			fatalf("invalid %s: '%s'", operand, f)
+		}
+		set[f] = true
+	}
+	return set
+}
+
 func main() {
-	args := os.Args[1:]
-	inFile := ""
-	outFile := ""
-	bs := int64(512)
-	ibs := int64(512)
-	obs := int64(512)
-	count := int64(-1)
-	skip := int64(0)
-	seek := int64(0)
-	conv := ""
-
-	for _, a := range args {
-		kv := strings.SplitN(a, "=", 2)
-		if len(kv) != 2 {
-			continue
-		}
-		k, v := kv[0], kv[1]
+	inFile, outFile := "", ""
+	ibs, obs := int64(512), int64(512)
+	bs := int64(0)
+	count, skip, seek := int64(-1), int64(0), int64(0)
+	conv := map[string]bool{}
+	iflag, oflag := map[string]bool{}, map[string]bool{}
+
+	flagNames := map[string]bool{}
+	for k := range openFlags {
+		flagNames[k] = true
+	}
+	for _, k := range ddFlags {
+		flagNames[k] = true
+	}
+	convSet := map[string]bool{}
+	for _, k := range convNames {
+		convSet[k] = true
+	}
+
+	for _, a := range os.Args[1:] {
+		k, v, ok := strings.Cut(a, "=")
+		if !ok {
+			fatalf("unrecognized operand '%s'", a)
+		}
 		switch k {
 		case "if":
 			inFile = v
 		case "of":
 			outFile = v
 		case "bs":
-			bs = parseSize(v)
-			ibs = bs
-			obs = bs
+			bs = parseBlockSize(v)
 		case "ibs":
-			ibs = parseSize(v)
+			ibs = parseBlockSize(v)
 		case "obs":
-			obs = parseSize(v)
+			obs = parseBlockSize(v)
+		case "cbs":
+			parseBlockSize(v)
 		case "count":
-			count, _ = strconv.ParseInt(v, 10, 64)
-		case "skip":
-			skip = parseSize(v)
-		case "seek":
-			seek = parseSize(v)
+			count = parseNumber(v)
+		case "skip", "iseek":
+			skip = parseNumber(v)
+		case "seek", "oseek":
+			seek = parseNumber(v)
 		case "conv":
-			conv = v
+			for c := range parseList("conversion", v, convSet) {
+				conv[c] = true
+			}
+		case "iflag":
+			for f := range parseList("input flag", v, flagNames) {
+				iflag[f] = true
+			}
+		case "oflag":
+			for f := range parseList("output flag", v, flagNames) {
+				oflag[f] = true
+			}
+		case "status":
+			switch v {
+			case "none", "noxfer", "progress":
+				status = v
+			default:
+				fatalf("invalid status level: '%s'", v)
+			}
+		default:
+			fatalf("unrecognized operand '%s'", a)
 		}
 	}
-	_ = bs
-	_ = obs
-	_ = conv
-
-	var in io.Reader = os.Stdin
-	var out io.Writer = os.Stdout
+	switch {
+	case conv["lcase"] && conv["ucase"]:
+		fatalf("cannot combine lcase and ucase")
+	case conv["excl"] && conv["nocreat"]:
+		fatalf("cannot combine excl and nocreat")
+	case conv["ascii"] || conv["ebcdic"] || conv["ibm"] || conv["block"] || conv["unblock"]:
+		fatalf("conversion not supported")
+	}
+	for f := range oflag {
+		if f == "fullblock" || f == "count_bytes" || f == "skip_bytes" {
+			fatalf("invalid output flag: '%s'", f)
+		}
+	}
+	if iflag["seek_bytes"] {
+		fatalf("invalid input flag: 'seek_bytes'")
+	}
+	if bs > 0 {
+		ibs, obs = bs, bs
+	}
 
+	in, out := os.Stdin, os.Stdout
 	if inFile != "" {
-		f, err := os.Open(inFile)
+		inName = "'" + inFile + "'"
+		f, err := os.OpenFile(inFile, os.O_RDONLY|openMode(iflag), 0)
 		if err != nil {
-			fmt.Fprintf(os.Stderr, "dd: %v\n", err)
-			os.Exit(1)
-		}
-		defer f.Close()
-		if skip > 0 {
-			f.Seek(skip*ibs, io.SeekStart)
+			fatalf("failed to open %s: %s", inName, errText(err))
 		}
 		in = f
+	} else if mode := openMode(iflag); mode != 0 {
+		setFlags(in, mode)
 	}
-
 	if outFile != "" {
-		f, err := os.OpenFile(outFile, os.O_WRONLY|os.O_CREATE, 0644)
-		if err != nil {
-			fmt.Fprintf(os.Stderr, "dd: %v\n", err)
-			os.Exit(1)
+		outName = "'" + outFile + "'"
+		mode := os.O_WRONLY | openMode(oflag)
+		if !conv["nocreat"] {
+			mode |= os.O_CREATE
+		}
+		if conv["excl"] {
+			mode |= os.O_EXCL
 		}
-		defer f.Close()
-		if seek > 0 {
-			f.Seek(seek*obs, io.SeekStart)
+		f, err := os.OpenFile(outFile, mode, 0666)
+		if err != nil {
+			fatalf("failed to open %s: %s", outName, errText(err))
 		}
 		out = f
+	} else if mode := openMode(oflag); mode != 0 {
+		setFlags(out, mode)
 	}
 
-	buf := make([]byte, ibs)
-	var blocks, bytes int64
-	for count < 0 || blocks < count {
-		n, err := in.Read(buf)
-		if n > 0 {
-			if strings.Contains(conv, "ucase") {
-				for i := range buf[:n] {
-					if buf[i] >= 'a' && buf[i] <= 'z' {
-						buf[i] -= 32
-					}
+	st.start = time.Now()
+	handleSignals()
+
+	// Skip input blocks, by seeking if possible and by reading otherwise.
+	if skip > 0 {
+		n := skip
+		if !iflag["skip_bytes"] {
+			n *= ibs
+		}
+		if _, err := in.Seek(n, io.SeekCurrent); err != nil {
+			if skipped, _ := io.CopyN(io.Discard, in, n); skipped < n && status != "none" {
+				fmt.Fprintf(os.Stderr, "dd: %s: cannot skip to specified offset\n", inName)
+			}
+		}
+	}
+	offset := int64(0)
+	if seek > 0 {
+		offset = seek
+		if !oflag["seek_bytes"] {
+			offset *= obs
+		}
+		if _, err := out.Seek(offset, io.SeekCurrent); err != nil {
+			fatalf("%s: cannot seek: %s", outName, errText(err))
+		}
+	}
+	if fi, err := out.Stat(); err == nil && fi.Mode().IsRegular() && !conv["notrunc"] && !oflag["append"] {
+		if err := out.Truncate(offset); err != nil {
+			fatalf("failed to truncate to %d bytes in output file %s: %s", offset, outName, errText(err))
+		}
+	}
+
+	w := &writer{f: out, obs: obs, sparse: conv["sparse"], direct: oflag["direct"], offset: offset}
+	if fi, err := out.Stat(); err == nil && fi.Mode().IsRegular() {
+		w.seekable = true
+		w.size = fi.Size()
+	}
+	// With bs= each input block is written as it is; otherwise the
+	// output is reblocked into obs-sized writes.
+	reblock := bs == 0
+	buf := alignedBuffer(int(ibs))
+	var obuf []byte
+	if reblock {
+		obuf = alignedBuffer(int(obs))[:0]
+	}
+	exit := 0
+	var lastProgress time.Time
+	// count is in input blocks, or in bytes with count_bytes.
+	remaining := count
+	for remaining != 0 {
+		want := ibs
+		if iflag["count_bytes"] && remaining > 0 && remaining < want {
+			want = remaining
+		}
+		n, err := readBlock(in, buf[:want], iflag["fullblock"])
+		if err != nil {
+			if status != "none" {
+				fmt.Fprintf(os.Stderr, "dd: error reading %s: %s\n", inName, errText(err))
+			}
+			exit = 1
+			if !conv["noerror"] {
+				break
+			}
+			// Skip the rest of the bad block.
+			in.Seek(want-int64(n), io.SeekCurrent)
+			printStats()
+			if n == 0 && !conv["sync"] {
+				if remaining > 0 && !iflag["count_bytes"] {
+					remaining--
 				}
-			} else if strings.Contains(conv, "lcase") {
-				for i := range buf[:n] {
-					if buf[i] >= 'A' && buf[i] <= 'Z' {
-						buf[i] += 32
+				continue
+			}
+		} else if n == 0 {
+			break
+		}
+		if remaining > 0 {
+			if iflag["count_bytes"] {
+				remaining -= int64(n)
+			} else {
+				remaining--
+			}
+		}
+		st.Lock()
+		if int64(n) == ibs {
+			st.inFull++
+		} else {
+			st.inPartial++
+		}
+		st.Unlock()
+		block := buf[:n]
+		if conv["sync"] && int64(n) < ibs {
+			block = buf[:ibs]
+			clear(block[n:])
+		}
+		if conv["swab"] {
+			for i := 0; i+1 < len(block); i += 2 {
+				block[i], block[i+1] = block[i+1], block[i]
+			}
+		}
+		switch {
+		case conv["ucase"]:
+			for i, c := range block {
+				if c >= 'a' && c <= 'z' {
+					block[i] = c - 'a' + 'A'
+				}
+			}
+		case conv["lcase"]:
+			for i, c := range block {
+				if c >= 'A' && c <= 'Z' {
+					block[i] = c - 'A' + 'a'
+				}
+			}
+		}
+		if reblock {
+			for len(block) > 0 {
+				k := min(len(block), int(obs)-len(obuf))
+				obuf = append(obuf, block[:k]...)
+				block = block[k:]
+				if int64(len(obuf)) == obs {
+					if err := w.write(obuf); err != nil {
+						fatalWrite(err)
 					}
+					obuf = obuf[:0]
 				}
 			}
-			out.Write(buf[:n])
-			bytes += int64(n)
-			blocks++
+		} else if err := w.write(block); err != nil {
+			fatalWrite(err)
 		}
+		if status == "progress" && time.Since(lastProgress) >= time.Second {
+			if !lastProgress.IsZero() {
+				printProgress()
+			}
+			lastProgress = time.Now()
+		}
+	}
+	if len(obuf) > 0 {
+		if err := w.write(obuf); err != nil {
+			fatalWrite(err)
+		}
+	}
+	if err := w.finish(); err != nil {
+		fatalWrite(err)
+	}
+	switch {
+	case conv["fsync"]:
+		if err := out.Sync(); err != nil {
+			fatalf("fsync failed for %s: %s", outName, errText(err))
+		}
+	case conv["fdatasync"]:
+		if err := syscall.Fdatasync(int(out.Fd())); err != nil {
+			fatalf("fdatasync failed for %s: %s", outName, errText(err))
+		}
+	}
+	if err := out.Close(); err != nil && outFile != "" {
+		fatalf("closing output file %s: %s", outName, errText(err))
+	}
+	printStats()
+	os.Exit(exit)
+}
+
+func openMode(flags map[string]bool) int {
+	mode := 0
+	for f := range flags {
+		mode |= openFlags[f]
+	}
+	return mode
+}
+
+// setFlags adds open flags to standard input or output.
+func setFlags(f *os.File, mode int) {
+	fl, _, errno := syscall.Syscall(syscall.SYS_FCNTL, f.Fd(), syscall.F_GETFL, 0)
+	if errno == 0 {
+		_, _, errno = syscall.Syscall(syscall.SYS_FCNTL, f.Fd(), syscall.F_SETFL, fl|uintptr(mode))
+	}
+	if errno != 0 {
+		fatalf("setting flags for %s: %s", f.Name(), errText(errno))
+	}
+}
+
+func fatalWrite(err error) {
+	fmt.Fprintf(os.Stderr, "dd: error writing %s: %s\n", outName, errText(err))
+	printStats()
+	os.Exit(1)
+}
+
+// alignedBuffer returns a buffer aligned for O_DIRECT transfers.
+func alignedBuffer(n int) []byte {
+	const align = 4096
+	b := make([]byte, n+align)
+	off := 0
+	if r := int(uintptr(unsafe.Pointer(&b[0])) & (align - 1)); r != 0 {
+		off = align - r
+	}
+	return b[off : off+n : off+n]
+}
+
+// readBlock reads one input block; with fullblock it keeps reading until
+// the block is full or the input ends.
+func readBlock(r io.Reader, b []byte, full bool) (int, error) {
+	n := 0
+	for n < len(b) {
+		m, err := r.Read(b[n:])
+		n += m
 		if err == io.EOF {
+			return n, nil
+		}
+		if err != nil {
+			if err == syscall.EINTR {
+				continue
+			}
+			return n, err
+		}
+		if !full || m == 0 {
 			break
 		}
+	}
+	return n, nil
+}
+
+// writer writes output blocks, skipping over blocks of zeros with
+// conv=sparse.
+type writer struct {
+	f        *os.File
+	obs      int64
+	sparse   bool
+	direct   bool
+	seekable bool
+	offset   int64 // current output position
+	size     int64 // size of the output file
+	seeked   bool  // whether the last block was skipped
+}
+
+func (w *writer) write(b []byte) error {
+	if w.sparse && w.seekable && isZero(b) {
+		if err := w.hole(int64(len(b))); err == nil {
+			w.count(len(b))
+			return nil
+		}
+	}
+	if w.direct && int64(len(b))%512 != 0 {
+		// O_DIRECT needs aligned sizes; write the final partial block
+		// without it.
+		fl, _, _ := syscall.Syscall(syscall.SYS_FCNTL, w.f.Fd(), syscall.F_GETFL, 0)
+		syscall.Syscall(syscall.SYS_FCNTL, w.f.Fd(), syscall.F_SETFL, fl&^syscall.O_DIRECT)
+		w.direct = false
+	}
+	for written := 0; written < len(b); {
+		n, err := w.f.Write(b[written:])
+		written += n
 		if err != nil {
-			fmt.Fprintf(os.Stderr, "dd: %v\n", err)
-			os.Exit(1)
+			if err == syscall.EINTR {
+				continue
+			}
+			return err
+		}
+	}
+	w.offset += int64(len(b))
+	w.seeked = false
+	w.count(len(b))
+	return nil
+}
+
+// hole skips n bytes of output. Beyond the end of the file a seek leaves
+// a hole; over existing data (conv=notrunc) the range is deallocated so
+// that it reads back as zeros.
+func (w *writer) hole(n int64) error {
+	if w.offset < w.size {
+		const punchHole = 0x02 | 0x01 // FALLOC_FL_PUNCH_HOLE | FALLOC_FL_KEEP_SIZE
+		if err := syscall.Fallocate(int(w.f.Fd()), punchHole, w.offset, min(n, w.size-w.offset)); err != nil {
+			return err
+		}
+	}
+	if _, err := w.f.Seek(n, io.SeekCurrent); err != nil {
+		return err
+	}
+	w.offset += n
+	w.seeked = true
+	return nil
+}
+
+func (w *writer) count(n int) {
+	st.Lock()
+	if int64(n) == w.obs {
+		st.outFull++
+	} else {
+		st.outPartial++
+	}
+	st.bytes += int64(n)
+	st.Unlock()
+}
+
+// finish extends the file when the output ended in a hole.
+func (w *writer) finish() error {
+	if w.seeked && w.offset > w.size {
+		return w.f.Truncate(w.offset)
+	}
+	return nil
+}
+
+func isZero(b []byte) bool {
+	for _, c := range b {
+		if c != 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// handleSignals prints the statistics on SIGUSR1, and before dying of
+// SIGINT.
+func handleSignals() {
+	c := make(chan os.Signal, 1)
+	signal.Notify(c, syscall.SIGUSR1)
+	if !signal.Ignored(syscall.SIGINT) {
+		signal.Notify(c, syscall.SIGINT)
+	}
+	go func() {
+		for sig := range c {
+			if sig == syscall.SIGINT {
+				printStats()
+				signal.Reset(syscall.SIGINT)
+				syscall.Kill(os.Getpid(), syscall.SIGINT)
+				return
+			}
+			printStats()
+		}
+	}()
+}
+
+// printStats prints the record counts and the transfer line as GNU dd
+// does at the end of a copy.
+func printStats() {
+	if status == "none" {
+		return
+	}
+	st.Lock()
+	defer st.Unlock()
+	if st.progressLen > 0 {
+		fmt.Fprintln(os.Stderr)
+		st.progressLen = 0
+	}
+	fmt.Fprintf(os.Stderr, "%d+%d records in\n%d+%d records out\n", st.inFull, st.inPartial, st.outFull, st.outPartial)
+	if status == "noxfer" {
+		return
+	}
+	fmt.Fprintln(os.Stderr, transferLine(time.Since(st.start), false))
+}
+
+// printProgress overwrites the status=progress line.
+func printProgress() {
+	st.Lock()
+	defer st.Unlock()
+	s := transferLine(time.Since(st.start), true)
+	pad := max(st.progressLen-len(s), 0)
+	fmt.Fprintf(os.Stderr, "\r%s%s", s, strings.Repeat(" ", pad))
+	st.progressLen = len(s)
+}
+
+// transferLine formats "N bytes (SI, IEC) copied, T s, RATE" with the
+// lock held.
+func transferLine(d time.Duration, progress bool) string {
+	secs := d.Seconds()
+	elapsed := strconv.FormatFloat(secs, 'g', 6, 64) + " s"
+	if progress {
+		elapsed = strconv.FormatFloat(secs, 'f', 0, 64) + " s"
+	}
+	rate := "Infinity B/s"
+	if d > 0 {
+		rate = humanUnits(float64(st.bytes)/secs, 1000, "B") + "/s"
+	}
+	n := st.bytes
+	si := humanUnits(float64(n), 1000, "B")
+	iec := humanUnits(float64(n), 1024, "iB")
+	switch {
+	case n < 1000:
+		noun := "bytes"
+		if n == 1 {
+			noun = "byte"
+		}
+		return fmt.Sprintf("%d %s copied, %s, %s", n, noun, elapsed, rate)
+	case n < 1024:
+		return fmt.Sprintf("%d bytes (%s) copied, %s, %s", n, si, elapsed, rate)
+	}
+	return fmt.Sprintf("%d bytes (%s, %s) copied, %s, %s", n, si, iec, elapsed, rate)
+}
+
+// humanUnits formats v with an SI (base 1000) or IEC (base 1024) prefix,
+// one decimal below 10: "1.0 MB", "977 KiB", "12 B".
+func humanUnits(v, base float64, unit string) string {
+	prefixes := "kMGTPEZY"
+	if base == 1024 {
+		prefixes = "KMGTPEZY"
+	}
+	if v < base {
+		return fmt.Sprintf("%.0f B", v)
+	}
+	i := -1
+	for v >= base && i < len(prefixes)-1 {
+		v /= base
+		i++
+	}
+	s := fmt.Sprintf("%.1f", v)
+	if v >= 9.95 {
+		s = fmt.Sprintf("%.0f", v)
+		if s == strconv.Itoa(int(base)) && i < len(prefixes)-1 {
+			s, i = "1.0", i+1
 		}
 	}
-	fmt.Fprintf(os.Stderr, "%d+0 records in\n%d+0 records out\n%d bytes transferred\n", blocks, blocks, bytes)
+	return s + " " + prefixes[i:i+1] + unit
 }
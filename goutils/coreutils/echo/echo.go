@@ -1,38 +1,68 @@
 package main
 
 import (
-	"fmt"
+	"bufio"
 	"os"
 	"strings"
+
+	"goutils/lib/escape"
 )
 
 func main() {
 	args := os.Args[1:]
 	noNewline := false
-	interpretEscapes := false
+	// With POSIXLY_CORRECT escapes are always expanded and only a first
+	// argument of exactly -n is an option.
+	_, posix := os.LookupEnv("POSIXLY_CORRECT")
+	interpretEscapes := posix
+	allowOptions := !posix || len(args) > 0 && args[0] == "-n"
+
+	// Leading arguments made only of -n, -e and -E are options; anything
+	// else, such as -x or -, starts the text.
 	i := 0
-	for i < len(args) {
+	for allowOptions && i < len(args) {
 		a := args[i]
-		if a == "-n" {
-			noNewline = true
-			i++
-		} else if a == "-e" {
-			interpretEscapes = true
-			i++
-		} else if a == "-E" {
-			interpretEscapes = false
-			i++
-		} else {
+		if len(a) < 2 || a[0] != '-' || strings.Trim(a[1:], "neE") != "" {
 			break
 		}
+		for _, c := range a[1:] {
+			switch c {
+			case 'n':
+				noNewline = true
+			case 'e':
+				interpretEscapes = true
+			case 'E':
+				interpretEscapes = posix
+			}
+		}
+		i++
 	}
-	out := strings.Join(args[i:], " ")
-	if interpretEscapes {
-		out = echoUnescape(out)
+
+	out := bufio.NewWriter(os.Stdout)
+	defer out.Flush()
+	for j, a := range args[i:] {
+		if j > 0 {
+			out.WriteByte(' ')
+		}
+		if !interpretEscapes {
+			out.WriteString(a)
+			continue
+		}
+		for k := 0; k < len(a); {
+			if a[k] != '\\' {
+				out.WriteByte(a[k])
+				k++
+				continue
+			}
+			s, n, stop := escape.Expand(a[k:], true)
+			if stop {
+				return
+			}
+			out.WriteString(s)
+			k += n
+		}
 	}
-	if noNewline {
-		fmt.Print(out)
-	} else {
-		fmt.Println(out)
+	if !noNewline {
+		out.WriteByte('\n')
 	}
 }
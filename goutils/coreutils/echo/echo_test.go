@@ -0,0 +1,74 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"os/exec"
+	"testing"
+)
+
+// TestMain runs echo itself when the test binary is re-executed by
+// runEcho, so that the cases see its real output and exit status.
+func TestMain(m *testing.M) {
+	if os.Getenv("GOUTILS_TEST_MAIN") == "1" {
+		main()
+		os.Exit(0)
+	}
+	os.Exit(m.Run())
+}
+
+func runEcho(t *testing.T, args []string) (stdout, stderr string, status int) {
+	var out, errOut bytes.Buffer
+	cmd := exec.Command(os.Args[0], args...)
+	cmd.Env = append(os.Environ(), "GOUTILS_TEST_MAIN=1", "TZ=UTC")
+	cmd.Stdout, cmd.Stderr = &out, &errOut
+	var exit *exec.ExitError
+	if err := cmd.Run(); errors.As(err, &exit) {
+		status = exit.ExitCode()
+	} else if err != nil {
+		t.Fatal(err)
+	}
+	return out.String(), errOut.String(), status
+}
+
+// conformance holds output captured from GNU echo. The "Try --help"
+// line GNU adds after usage errors is left out, as echo here does not
+// print it.
+var conformance = []struct {
+	args           []string
+	stdout, stderr string
+	status         int
+}{
+	// GNU coreutils 9.1, LC_ALL=C.
+	{[]string{"-e", "a\\cb", "c"}, "a", "", 0},
+	{[]string{"-e", "\\x41\\x42\\x4"}, "AB\x04\n", "", 0},
+	{[]string{"-e", "\\x"}, "\\x\n", "", 0},
+	{[]string{"-e", "\\0101|\\101|\\01017"}, "A|A|A7\n", "", 0},
+	{[]string{"-e", "a\\tb\\\\c\\a\\e|"}, "a\tb\\c\a\x1b|\n", "", 0},
+	{[]string{"-n", "x"}, "x", "", 0},
+	{[]string{"-E", "a\\nb"}, "a\\nb\n", "", 0},
+	{[]string{"-eE", "a\\nb"}, "a\\nb\n", "", 0},
+	{[]string{"-ne", "a\\nb"}, "a\nb", "", 0},
+	{[]string{"-x", "y"}, "-x y\n", "", 0},
+	{[]string{"--", "-n"}, "-- -n\n", "", 0},
+	{[]string{"-"}, "-\n", "", 0},
+	{[]string{"a", "-n"}, "a -n\n", "", 0},
+	{[]string{"-e", "\\c"}, "", "", 0},
+	{[]string{"-e", "\\"}, "\\\n", "", 0},
+	{[]string{}, "\n", "", 0},
+
+	// bash 5.2 builtin, LC_ALL=C.UTF-8: coreutils 9.1 echo has no \u escapes.
+	{[]string{"-e", "\\u00e9\\U0001F600"}, "\u00e9\U0001f600\n", "", 0},
+	{[]string{"-e", "\\u12x\\u"}, "\x12x\\u\n", "", 0},
+}
+
+func TestConformance(t *testing.T) {
+	for _, c := range conformance {
+		stdout, stderr, status := runEcho(t, c.args)
+		if stdout != c.stdout || stderr != c.stderr || status != c.status {
+			t.Errorf("echo %q:\n got %q, %q, exit %d\nwant %q, %q, exit %d",
+				c.args, stdout, stderr, status, c.stdout, c.stderr, c.status)
+		}
+	}
+}
@@ -2,217 +2,348 @@ package main
 
 import (
 	"fmt"
+	"math/big"
 	"os"
-	"regexp"
-	"strconv"
 	"strings"
+	"unicode/utf8"
 )
 
+// value is an expression result: an integer when n is set, else the
+// string s.
+type value struct {
+	s string
+	n *big.Int
+}
+
+func intValue(n *big.Int) value { return value{n: n} }
+
+func boolValue(b bool) value { return intValue(big.NewInt(int64(btoi(b)))) }
+
+func (v value) String() string {
+	if v.n != nil {
+		return v.n.String()
+	}
+	return v.s
+}
+
+// null reports whether v is false to | and & and the exit status: the
+// empty string or a zero, however written.
+func (v value) null() bool {
+	if v.n != nil {
+		return v.n.Sign() == 0
+	}
+	s := strings.TrimPrefix(v.s, "-")
+	return v.s == "" || s != "" && strings.Trim(s, "0") == ""
+}
+
+// toInt converts v to an integer: strings must be an optional minus sign
+// and decimal digits.
+func (v value) toInt() (*big.Int, bool) {
+	if v.n != nil {
+		return v.n, true
+	}
+	digits := strings.TrimPrefix(v.s, "-")
+	if digits == "" || strings.Trim(digits, "0123456789") != "" {
+		return nil, false
+	}
+	n, ok := new(big.Int).SetString(v.s, 10)
+	return n, ok
+}
+
+// argv holds the tokens and pos the next one to parse.
+var (
+	argv []string
+	pos  int
+)
+
+func fatal(status int, format string, a ...interface{}) {
+	fmt.Fprintf(os.Stderr, "expr: "+format+"\n", a...)
+	os.Exit(status)
+}
+
+func syntaxError(format string, a ...interface{}) {
+	fatal(2, "syntax error: "+format, a...)
+}
+
+// nextArg consumes the next token when it is s.
+func nextArg(s string) bool {
+	if pos < len(argv) && argv[pos] == s {
+		pos++
+		return true
+	}
+	return false
+}
+
+// requireArg reports a syntax error if the tokens have run out.
+func requireArg() {
+	if pos == len(argv) {
+		syntaxError("missing argument after '%s'", argv[pos-1])
+	}
+}
+
 func main() {
-	args := os.Args[1:]
-	if len(args) == 0 {
-		fmt.Fprintln(os.Stderr, "expr: missing operand")
-		os.Exit(1)
+	argv = os.Args[1:]
+	if len(argv) > 0 && argv[0] == "--" {
+		argv = argv[1:]
+	}
+	if len(argv) == 0 {
+		fatal(2, "missing operand")
 	}
-	result, _ := exprEval(args)
-	fmt.Println(result)
-	if result == "0" || result == "" {
+	v := evalOr(true)
+	if pos < len(argv) {
+		syntaxError("unexpected argument '%s'", argv[pos])
+	}
+	fmt.Println(v)
+	if v.null() {
 		os.Exit(1)
 	}
 }
 
-func exprEval(tokens []string) (string, []string) {
-	return exprOr(tokens)
-}
+// Each level of the grammar takes evaluate, which is false in the
+// operand that | or & short-circuits: it is parsed but errors such as
+// division by zero are not reported.
 
-func exprOr(tokens []string) (string, []string) {
-	left, tokens := exprAnd(tokens)
-	for len(tokens) > 0 && tokens[0] == "|" {
-		tokens = tokens[1:]
-		right, rest := exprAnd(tokens)
-		tokens = rest
-		if left == "0" || left == "" {
-			left = right
+// evalOr: ARG1 | ARG2 is ARG1 if it is not null, else ARG2 if that is
+// not null, else 0.
+func evalOr(evaluate bool) value {
+	l := evalAnd(evaluate)
+	for nextArg("|") {
+		r := evalAnd(evaluate && l.null())
+		if l.null() {
+			l = r
+			if l.null() {
+				l = boolValue(false)
+			}
 		}
 	}
-	return left, tokens
+	return l
 }
 
-func exprAnd(tokens []string) (string, []string) {
-	left, tokens := exprCmp(tokens)
-	for len(tokens) > 0 && tokens[0] == "&" {
-		tokens = tokens[1:]
-		right, rest := exprCmp(tokens)
-		tokens = rest
-		if left == "0" || left == "" || right == "0" || right == "" {
-			left = "0"
+// evalAnd: ARG1 & ARG2 is ARG1 if neither is null, else 0.
+func evalAnd(evaluate bool) value {
+	l := evalCompare(evaluate)
+	for nextArg("&") {
+		r := evalCompare(evaluate && !l.null())
+		if l.null() || r.null() {
+			l = boolValue(false)
 		}
 	}
-	return left, tokens
+	return l
 }
 
-func exprCmp(tokens []string) (string, []string) {
-	left, tokens := exprAdd(tokens)
-	ops := []string{"<=", ">=", "!=", "=", "<", ">"}
-	for len(tokens) > 0 {
-		op := ""
-		for _, o := range ops {
-			if tokens[0] == o {
+// evalCompare compares as integers when both operands are integers,
+// else as strings.
+func evalCompare(evaluate bool) value {
+	l := evalAdd(evaluate)
+	for {
+		var op string
+		for _, o := range []string{"<", "<=", "=", "==", "!=", ">=", ">"} {
+			if nextArg(o) {
 				op = o
 				break
 			}
 		}
 		if op == "" {
-			break
-		}
-		tokens = tokens[1:]
-		right, rest := exprAdd(tokens)
-		tokens = rest
-		ln, lerr := strconv.ParseInt(left, 10, 64)
-		rn, rerr := strconv.ParseInt(right, 10, 64)
-		var result bool
-		if lerr == nil && rerr == nil {
-			switch op {
-			case "<":
-				result = ln < rn
-			case "<=":
-				result = ln <= rn
-			case ">":
-				result = ln > rn
-			case ">=":
-				result = ln >= rn
-			case "=":
-				result = ln == rn
-			case "!=":
-				result = ln != rn
-			}
-		} else {
-			switch op {
-			case "<":
-				result = left < right
-			case "<=":
-				result = left <= right
-			case ">":
-				result = left > right
-			case ">=":
-				result = left >= right
-			case "=":
-				result = left == right
-			case "!=":
-				result = left != right
-			}
+			return l
 		}
-		if result {
-			left = "1"
+		r := evalAdd(evaluate)
+		if !evaluate {
+			continue
+		}
+		var c int
+		ln, lok := l.toInt()
+		rn, rok := r.toInt()
+		if lok && rok {
+			c = ln.Cmp(rn)
 		} else {
-			left = "0"
+			c = strings.Compare(l.String(), r.String())
+		}
+		switch op {
+		case "<":
+			l = boolValue(c < 0)
+		case "<=":
+			l = boolValue(c <= 0)
+		case "=", "==":
+			l = boolValue(c == 0)
+		case "!=":
+			l = boolValue(c != 0)
+		case ">=":
+			l = boolValue(c >= 0)
+		case ">":
+			l = boolValue(c > 0)
 		}
 	}
-	return left, tokens
 }
 
-func exprAdd(tokens []string) (string, []string) {
-	left, tokens := exprMul(tokens)
-	for len(tokens) > 0 && (tokens[0] == "+" || tokens[0] == "-") {
-		op := tokens[0]
-		tokens = tokens[1:]
-		right, rest := exprMul(tokens)
-		tokens = rest
-		ln, _ := strconv.ParseInt(left, 10, 64)
-		rn, _ := strconv.ParseInt(right, 10, 64)
+// operands returns l and r as integers when evaluating.
+func operands(evaluate bool, l, r value) (*big.Int, *big.Int, bool) {
+	if !evaluate {
+		return nil, nil, false
+	}
+	ln, lok := l.toInt()
+	rn, rok := r.toInt()
+	if !lok || !rok {
+		fatal(2, "non-integer argument")
+	}
+	return ln, rn, true
+}
+
+func evalAdd(evaluate bool) value {
+	l := evalMul(evaluate)
+	for {
+		var op string
+		switch {
+		case nextArg("+"):
+			op = "+"
+		case nextArg("-"):
+			op = "-"
+		default:
+			return l
+		}
+		r := evalMul(evaluate)
+		ln, rn, ok := operands(evaluate, l, r)
+		if !ok {
+			continue
+		}
 		if op == "+" {
-			left = strconv.FormatInt(ln+rn, 10)
+			l = intValue(new(big.Int).Add(ln, rn))
 		} else {
-			left = strconv.FormatInt(ln-rn, 10)
+			l = intValue(new(big.Int).Sub(ln, rn))
 		}
 	}
-	return left, tokens
 }
 
-func exprMul(tokens []string) (string, []string) {
-	left, tokens := exprUnary(tokens)
-	for len(tokens) > 0 && (tokens[0] == "*" || tokens[0] == "/" || tokens[0] == "%") {
-		op := tokens[0]
-		tokens = tokens[1:]
-		right, rest := exprUnary(tokens)
-		tokens = rest
-		ln, _ := strconv.ParseInt(left, 10, 64)
-		rn, _ := strconv.ParseInt(right, 10, 64)
+func evalMul(evaluate bool) value {
+	l := evalMatch(evaluate)
+	for {
+		var op string
+		switch {
+		case nextArg("*"):
+			op = "*"
+		case nextArg("/"):
+			op = "/"
+		case nextArg("%"):
+			op = "%"
+		default:
+			return l
+		}
+		r := evalMatch(evaluate)
+		ln, rn, ok := operands(evaluate, l, r)
+		if !ok {
+			continue
+		}
+		if op != "*" && rn.Sign() == 0 {
+			fatal(2, "division by zero")
+		}
 		switch op {
 		case "*":
-			left = strconv.FormatInt(ln*rn, 10)
+			l = intValue(new(big.Int).Mul(ln, rn))
 		case "/":
-			if rn == 0 {
-				fmt.Fprintln(os.Stderr, "expr: division by zero")
-				os.Exit(2)
-			}
-			left = strconv.FormatInt(ln/rn, 10)
-		case "%":
-			if rn == 0 {
-				fmt.Fprintln(os.Stderr, "expr: division by zero")
-				os.Exit(2)
-			}
-			left = strconv.FormatInt(ln%rn, 10)
+			l = intValue(new(big.Int).Quo(ln, rn))
+		default:
+			l = intValue(new(big.Int).Rem(ln, rn))
 		}
 	}
-	return left, tokens
 }
 
-func exprUnary(tokens []string) (string, []string) {
-	if len(tokens) == 0 {
-		return "", tokens
-	}
-	if tokens[0] == "match" {
-		tokens = tokens[1:]
-		s, tokens := exprUnary(tokens)
-		pat, tokens := exprUnary(tokens)
-		re := regexp.MustCompile("^(?:" + pat + ")")
-		m := re.FindStringSubmatch(s)
-		if m == nil {
-			return "0", tokens
+func evalMatch(evaluate bool) value {
+	l := evalPrimary(evaluate)
+	for nextArg(":") {
+		r := evalPrimary(evaluate)
+		if evaluate {
+			l = match(l.String(), r.String())
 		}
-		if len(m) > 1 {
-			return m[1], tokens
+	}
+	return l
+}
+
+// match anchors the basic regular expression pattern at the start of s.
+// With a \( \) group it returns what the first group matched, else the
+// number of characters matched.
+func match(s, pattern string) value {
+	re, err := compileBRE(pattern)
+	if err != nil {
+		fatal(2, "%v", err)
+	}
+	m := re.FindStringSubmatchIndex(s)
+	if m != nil && m[0] != 0 {
+		m = nil
+	}
+	if re.NumSubexp() > 0 {
+		if m == nil || m[2] < 0 {
+			return value{}
 		}
-		return strconv.Itoa(len(m[0])), tokens
+		return value{s: s[m[2]:m[3]]}
 	}
-	if tokens[0] == "length" {
-		tokens = tokens[1:]
-		s, tokens := exprUnary(tokens)
-		return strconv.Itoa(len(s)), tokens
+	if m == nil {
+		return boolValue(false)
 	}
-	if tokens[0] == "substr" {
-		tokens = tokens[1:]
-		s, tokens := exprUnary(tokens)
-		posStr, tokens := exprUnary(tokens)
-		lenStr, tokens := exprUnary(tokens)
-		pos, _ := strconv.Atoi(posStr)
-		length, _ := strconv.Atoi(lenStr)
-		if pos < 1 {
-			pos = 1
+	return intValue(big.NewInt(int64(utf8.RuneCountInString(s[:m[1]]))))
+}
+
+func evalPrimary(evaluate bool) value {
+	switch {
+	case nextArg("+"):
+		// "+ TOKEN" takes TOKEN as a string even when it is a keyword or
+		// an operator.
+		requireArg()
+		pos++
+		return value{s: argv[pos-1]}
+	case nextArg("length"):
+		s := evalPrimary(evaluate).String()
+		return intValue(big.NewInt(int64(utf8.RuneCountInString(s))))
+	case nextArg("match"):
+		l := evalPrimary(evaluate)
+		r := evalPrimary(evaluate)
+		if !evaluate {
+			return value{}
 		}
-		pos--
-		runes := []rune(s)
-		if pos >= len(runes) {
-			return "", tokens
+		return match(l.String(), r.String())
+	case nextArg("index"):
+		s := evalPrimary(evaluate).String()
+		chars := evalPrimary(evaluate).String()
+		at := 0
+		for i, r := range []rune(s) {
+			if strings.ContainsRune(chars, r) {
+				at = i + 1
+				break
+			}
 		}
-		end := pos + length
-		if end > len(runes) {
-			end = len(runes)
+		return intValue(big.NewInt(int64(at)))
+	case nextArg("substr"):
+		s := []rune(evalPrimary(evaluate).String())
+		p, pok := evalPrimary(evaluate).toInt()
+		n, nok := evalPrimary(evaluate).toInt()
+		// A position or length that is not a positive integer, or a
+		// position past the end, gives the empty string.
+		if !pok || !nok || p.Sign() <= 0 || n.Sign() <= 0 || p.Cmp(big.NewInt(int64(len(s)))) > 0 {
+			return value{}
 		}
-		return string(runes[pos:end]), tokens
+		start := int(p.Int64()) - 1
+		end := len(s)
+		if n.IsInt64() && n.Int64() < int64(len(s)-start) {
+			end = start + int(n.Int64())
+		}
+		return value{s: string(s[start:end])}
 	}
-	if tokens[0] == "index" {
-		tokens = tokens[1:]
-		s, tokens := exprUnary(tokens)
-		chars, tokens := exprUnary(tokens)
-		idx := strings.IndexAny(s, chars)
-		return strconv.Itoa(idx + 1), tokens
+	return evalParen(evaluate)
+}
+
+func evalParen(evaluate bool) value {
+	requireArg()
+	if nextArg("(") {
+		v := evalOr(evaluate)
+		if pos == len(argv) {
+			syntaxError("expecting ')' after '%s'", argv[pos-1])
+		}
+		if !nextArg(")") {
+			syntaxError("expecting ')' instead of '%s'", argv[pos])
+		}
+		return v
 	}
-	if tokens[0] == ":" {
-		// match operator
-		return "", tokens[1:]
+	if nextArg(")") {
+		syntaxError("unexpected ')'")
 	}
-	val := tokens[0]
-	return val, tokens[1:]
+	pos++
+	return value{s: argv[pos-1]}
 }
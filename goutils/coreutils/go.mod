@@ -1,3 +1,7 @@
 module coreutils
 
 go 1.21
+
+require goutils/lib v0.0.0
+
+replace goutils/lib => ../lib
@@ -1,41 +1,206 @@
 package main
 
 import (
+	"bufio"
+	"bytes"
 	"fmt"
 	"io/fs"
 	"os"
 	"os/user"
-	"path/filepath"
 	"sort"
 	"strconv"
 	"strings"
 	"syscall"
 	"time"
+	"unicode"
+	"unicode/utf8"
+
+	"goutils/lib/datetime"
+	"goutils/lib/term"
+)
+
+// Output formats.
+const (
+	formatOnePerLine = iota
+	formatColumns    // -C: sorted down the columns
+	formatAcross     // -x: sorted across the rows
+	formatCommas     // -m
+	formatLong
+)
+
+// Sort orders.
+const (
+	sortName = iota
+	sortNone
+	sortTime
+	sortSize
+	sortVersion
+	sortExtension
 )
 
+var (
+	format         = -1
+	all            bool
+	almostAll      bool
+	humanReadable  bool
+	reverse        bool
+	sortBy         = sortName
+	recursive      bool
+	inode          bool
+	classify       bool
+	slashDirs      bool // -p
+	blockSizes     bool // -s
+	quoteNames     bool // -Q
+	dirsAsFiles    bool // -d
+	numericIDs     bool // -n
+	showOwner      = true
+	showGroup      = true
+	escape         bool // -b
+	groupDirsFirst bool
+	timeStyle      string
+	width          int
+	tabSize        = 8
+
+	colors   *lsColors
+	exitCode int
+)
+
+// entry is a file to be listed.
+type entry struct {
+	name string // as displayed
+	path string
+	info fs.FileInfo
+	stat *syscall.Stat_t
+
+	target     string      // for symlinks, the link's contents
+	targetInfo fs.FileInfo // the file it points to, or nil if missing
+}
+
 func main() {
 	args := os.Args[1:]
-	long := false
-	all := false
-	almostAll := false
-	humanReadable := false
-	reverse := false
-	sortByTime := false
-	sortBySize := false
-	noSort := false
-	recursive := false
-	inode := false
-	classify := false
-	colorize := false
-	onePerLine := false
-	dirs := []string{}
-
-	for _, a := range args {
-		if strings.HasPrefix(a, "-") && len(a) > 1 && a[1] != '-' {
-			for _, c := range a[1:] {
-				switch c {
+	colorWhen := "never"
+	var operands []string
+	noMoreOpts := false
+
+	for i := 0; i < len(args); i++ {
+		a := args[i]
+		switch {
+		case noMoreOpts || a == "-" || !strings.HasPrefix(a, "-"):
+			operands = append(operands, a)
+		case a == "--":
+			noMoreOpts = true
+		case strings.HasPrefix(a, "--"):
+			name, val, hasVal := strings.Cut(a[2:], "=")
+			needVal := func() string {
+				if hasVal {
+					return val
+				}
+				if i+1 < len(args) {
+					i++
+					return args[i]
+				}
+				fmt.Fprintf(os.Stderr, "ls: option '--%s' requires an argument\n", name)
+				os.Exit(2)
+				return ""
+			}
+			switch name {
+			case "all":
+				all = true
+			case "almost-all":
+				almostAll = true
+			case "human-readable":
+				humanReadable = true
+			case "recursive":
+				recursive = true
+			case "inode":
+				inode = true
+			case "classify":
+				classify = true
+			case "size":
+				blockSizes = true
+			case "quote-name":
+				quoteNames = true
+			case "reverse":
+				reverse = true
+			case "directory":
+				dirsAsFiles = true
+			case "numeric-uid-gid":
+				numericIDs = true
+				format = formatLong
+			case "no-group":
+				showGroup = false
+			case "escape":
+				escape = true
+			case "group-directories-first":
+				groupDirsFirst = true
+			case "full-time":
+				format = formatLong
+				timeStyle = "full-iso"
+			case "time-style":
+				timeStyle = needVal()
+			case "width":
+				w, err := strconv.Atoi(needVal())
+				if err != nil || w < 0 {
+					fmt.Fprintf(os.Stderr, "ls: invalid line width: %s\n", val)
+					os.Exit(2)
+				}
+				width = w
+			case "tabsize":
+				v := needVal()
+				t, err := strconv.Atoi(v)
+				if err != nil || t < 0 {
+					fmt.Fprintf(os.Stderr, "ls: invalid tab size: %s\n", v)
+					os.Exit(2)
+				}
+				tabSize = t
+			case "color", "colour":
+				colorWhen = "always"
+				if hasVal {
+					colorWhen = val
+				}
+			case "sort":
+				switch needVal() {
+				case "none":
+					sortBy = sortNone
+				case "time":
+					sortBy = sortTime
+				case "size":
+					sortBy = sortSize
+				case "version":
+					sortBy = sortVersion
+				case "extension":
+					sortBy = sortExtension
+				case "name":
+					sortBy = sortName
+				default:
+					fmt.Fprintf(os.Stderr, "ls: invalid argument for '--sort'\n")
+					os.Exit(2)
+				}
+			case "format":
+				switch needVal() {
+				case "long", "verbose":
+					format = formatLong
+				case "single-column":
+					format = formatOnePerLine
+				case "vertical":
+					format = formatColumns
+				case "across", "horizontal":
+					format = formatAcross
+				case "commas":
+					format = formatCommas
+				default:
+					fmt.Fprintf(os.Stderr, "ls: invalid argument for '--format'\n")
+					os.Exit(2)
+				}
+			default:
+				fmt.Fprintf(os.Stderr, "ls: unrecognized option '%s'\n", a)
+				os.Exit(2)
+			}
+		default:
+			for j := 1; j < len(a); j++ {
+				switch a[j] {
 				case 'l':
-					long = true
+					format = formatLong
 				case 'a':
 					all = true
 				case 'A':
@@ -45,252 +210,1023 @@ func main() {
 				case 'r':
 					reverse = true
 				case 't':
-					sortByTime = true
+					sortBy = sortTime
 				case 'S':
-					sortBySize = true
+					sortBy = sortSize
 				case 'U':
-					noSort = true
+					sortBy = sortNone
+				case 'v':
+					sortBy = sortVersion
+				case 'X':
+					sortBy = sortExtension
 				case 'R':
 					recursive = true
 				case 'i':
 					inode = true
 				case 'F':
 					classify = true
+				case 'p':
+					slashDirs = true
+				case 's':
+					blockSizes = true
+				case 'Q':
+					quoteNames = true
+				case 'm':
+					format = formatCommas
 				case '1':
-					onePerLine = true
+					format = formatOnePerLine
 				case 'C':
-					// columns - default
+					format = formatColumns
+				case 'x':
+					format = formatAcross
+				case 'd':
+					dirsAsFiles = true
+				case 'n':
+					numericIDs = true
+					format = formatLong
+				case 'g':
+					showOwner = false
+					format = formatLong
+				case 'o':
+					showGroup = false
+					format = formatLong
+				case 'G':
+					showGroup = false
 				case 'b':
-					// escape - default
+					escape = true
+				case 'w', 'T':
+					v := a[j+1:]
+					if v == "" && i+1 < len(args) {
+						i++
+						v = args[i]
+					}
+					n, err := strconv.Atoi(v)
+					switch {
+					case (err != nil || n < 0) && a[j] == 'w':
+						fmt.Fprintf(os.Stderr, "ls: invalid line width: %s\n", v)
+						os.Exit(2)
+					case err != nil || n < 0:
+						fmt.Fprintf(os.Stderr, "ls: invalid tab size: %s\n", v)
+						os.Exit(2)
+					case a[j] == 'w':
+						width = n
+					default:
+						tabSize = n
+					}
+					j = len(a)
+				default:
+					fmt.Fprintf(os.Stderr, "ls: invalid option -- '%c'\n", a[j])
+					os.Exit(2)
 				}
 			}
-		} else if a == "--color" || a == "--color=always" || a == "--color=auto" {
-			colorize = true
-		} else if a == "--all" {
-			all = true
-		} else if a == "--almost-all" {
-			almostAll = true
-		} else if a == "--human-readable" {
-			humanReadable = true
-		} else if a == "--recursive" {
-			recursive = true
-		} else if a == "--inode" {
-			inode = true
-		} else if a == "--classify" {
-			classify = true
-		} else if a == "--reverse" {
-			reverse = true
-		} else if !strings.HasPrefix(a, "-") {
-			dirs = append(dirs, a)
-		}
-	}
-
-	if len(dirs) == 0 {
-		dirs = []string{"."}
-	}
-
-	// ANSI color codes
-	colors := map[string]string{
-		"dir":   "\033[01;34m",
-		"link":  "\033[01;36m",
-		"exec":  "\033[01;32m",
-		"reset": "\033[0m",
-	}
-	if !colorize {
-		for k := range colors {
-			colors[k] = ""
-		}
-	}
-
-	lookupName := func(uid uint32) string {
-		u, err := user.LookupId(strconv.Itoa(int(uid)))
+		}
+	}
+
+	tty := term.IsTerminal(os.Stdout)
+	if format < 0 {
+		format = formatOnePerLine
+		if tty {
+			format = formatColumns
+		}
+	}
+	if width == 0 {
+		width = term.Width(os.Stdout)
+	}
+	if mode, err := term.ParseColorMode(colorWhen); err != nil {
+		fmt.Fprintf(os.Stderr, "ls: invalid argument '%s' for '--color'\n", colorWhen)
+		os.Exit(2)
+	} else if mode.Enabled(os.Stdout) {
+		colors = parseLSColors(os.Getenv("LS_COLORS"))
+	}
+	if timeStyle == "" {
+		timeStyle = os.Getenv("TIME_STYLE")
+	}
+	timeStyle = strings.TrimPrefix(timeStyle, "posix-")
+	switch {
+	case timeStyle == "", timeStyle == "locale", timeStyle == "full-iso",
+		timeStyle == "long-iso", timeStyle == "iso", strings.HasPrefix(timeStyle, "+"):
+	default:
+		fmt.Fprintf(os.Stderr, "ls: invalid argument '%s' for 'time style'\n", timeStyle)
+		os.Exit(2)
+	}
+
+	if len(operands) == 0 {
+		operands = []string{"."}
+	}
+	var files, dirs []*entry
+	for _, op := range operands {
+		e, err := newEntry(op, op, !dirsAsFiles && !classify && format != formatLong)
 		if err != nil {
-			return strconv.Itoa(int(uid))
+			fmt.Fprintf(os.Stderr, "ls: cannot access '%s': %v\n", op, errText(err))
+			exitCode = 2
+			continue
+		}
+		if !dirsAsFiles && e.isDir() {
+			dirs = append(dirs, e)
+		} else {
+			files = append(files, e)
+		}
+	}
+	sortEntries(files)
+	sortEntries(dirs)
+
+	out := bufio.NewWriter(os.Stdout)
+	if len(files) > 0 {
+		list(out, files, false)
+	}
+	for i, d := range dirs {
+		if len(files) > 0 || i > 0 {
+			out.WriteString("\n")
+		}
+		listDir(out, d.path, len(operands) > 1 || recursive)
+	}
+	out.Flush()
+	os.Exit(exitCode)
+}
+
+// newEntry stats path. Command-line symlinks to directories are followed
+// unless the listing shows the links themselves.
+func newEntry(name, path string, follow bool) (*entry, error) {
+	info, err := os.Lstat(path)
+	if err != nil {
+		return nil, err
+	}
+	e := &entry{name: name, path: path, info: info}
+	if info.Mode()&os.ModeSymlink != 0 {
+		e.target, _ = os.Readlink(path)
+		e.targetInfo, _ = os.Stat(path)
+		if follow && e.targetInfo != nil && e.targetInfo.IsDir() {
+			e.info = e.targetInfo
+			e.target, e.targetInfo = "", nil
+		}
+	}
+	e.stat, _ = e.info.Sys().(*syscall.Stat_t)
+	return e, nil
+}
+
+func (e *entry) isDir() bool { return e.info.IsDir() }
+
+// isDirLike reports whether e is a directory or a link to one, for
+// --group-directories-first.
+func (e *entry) isDirLike() bool {
+	return e.info.IsDir() || e.targetInfo != nil && e.targetInfo.IsDir()
+}
+
+func listDir(out *bufio.Writer, dir string, header bool) {
+	if header {
+		out.WriteString(quoteName(dir) + ":\n")
+	}
+	des, err := os.ReadDir(dir)
+	if err != nil {
+		out.Flush()
+		fmt.Fprintf(os.Stderr, "ls: cannot open directory '%s': %v\n", dir, errText(err))
+		if exitCode == 0 {
+			exitCode = 1
 		}
-		return u.Username
+		return
 	}
-	lookupGroup := func(gid uint32) string {
-		g, err := user.LookupGroupId(strconv.Itoa(int(gid)))
+	var items []*entry
+	if all {
+		for _, n := range []string{".", ".."} {
+			if e, err := newEntry(n, childPath(dir, n), false); err == nil {
+				items = append(items, e)
+			}
+		}
+	}
+	for _, de := range des {
+		name := de.Name()
+		if !all && !almostAll && strings.HasPrefix(name, ".") {
+			continue
+		}
+		e, err := newEntry(name, childPath(dir, name), false)
 		if err != nil {
-			return strconv.Itoa(int(gid))
-		}
-		return g.Name
-	}
-
-	printEntry := func(path string, info fs.FileInfo) {
-		name := info.Name()
-		suffix := ""
-		if classify {
-			switch {
-			case info.Mode()&os.ModeSymlink != 0:
-				suffix = "@"
-			case info.IsDir():
-				suffix = "/"
-			case info.Mode()&0111 != 0:
-				suffix = "*"
+			continue
+		}
+		items = append(items, e)
+	}
+	sortEntries(items)
+	list(out, items, true)
+
+	if recursive {
+		for _, e := range items {
+			if e.info.IsDir() && e.name != "." && e.name != ".." {
+				out.WriteString("\n")
+				listDir(out, e.path, true)
 			}
 		}
-		if long {
-			mode := info.Mode().String()
-			nlink := uint64(1)
-			uid := uint32(0)
-			gid := uint32(0)
-			ino := uint64(0)
-			if stat, ok := info.Sys().(*syscall.Stat_t); ok {
-				nlink = uint64(stat.Nlink)
-				uid = stat.Uid
-				gid = stat.Gid
-				ino = stat.Ino
+	}
+}
+
+// childPath names a file in dir the way GNU ls prints it: ./sub for a
+// subdirectory of ".", and dir/sub without doubling a trailing slash.
+func childPath(dir, name string) string {
+	if strings.HasSuffix(dir, "/") {
+		return dir + name
+	}
+	return dir + "/" + name
+}
+
+func sortEntries(items []*entry) {
+	if sortBy == sortNone {
+		return
+	}
+	less := func(a, b *entry) bool {
+		switch sortBy {
+		case sortTime:
+			if ta, tb := a.info.ModTime(), b.info.ModTime(); !ta.Equal(tb) {
+				return ta.After(tb)
 			}
-			size := info.Size()
-			sizeStr := strconv.FormatInt(size, 10)
-			if humanReadable {
-				sizeStr = humanSize(size)
+		case sortSize:
+			if a.info.Size() != b.info.Size() {
+				return a.info.Size() > b.info.Size()
 			}
-			modtime := info.ModTime().Format(time.Stamp)
-			uname := lookupName(uid)
-			gname := lookupGroup(gid)
-			if inode {
-				fmt.Printf("%8d ", ino)
+		case sortVersion:
+			if c := versionCompare(a.name, b.name); c != 0 {
+				return c < 0
 			}
-			colorStart, colorEnd := "", ""
-			if colorize {
-				if info.IsDir() {
-					colorStart, colorEnd = colors["dir"], colors["reset"]
-				} else if info.Mode()&os.ModeSymlink != 0 {
-					colorStart, colorEnd = colors["link"], colors["reset"]
-				} else if info.Mode()&0111 != 0 {
-					colorStart, colorEnd = colors["exec"], colors["reset"]
-				}
+			return a.name < b.name
+		case sortExtension:
+			if ea, eb := extension(a.name), extension(b.name); ea != eb {
+				return ea < eb
 			}
-			linkStr := ""
-			if info.Mode()&os.ModeSymlink != 0 {
-				target, _ := os.Readlink(path)
-				linkStr = " -> " + target
+		}
+		la, lb := strings.ToLower(a.name), strings.ToLower(b.name)
+		if la != lb {
+			return la < lb
+		}
+		return a.name < b.name
+	}
+	sort.SliceStable(items, func(i, j int) bool {
+		a, b := items[i], items[j]
+		if groupDirsFirst && a.isDirLike() != b.isDirLike() {
+			return a.isDirLike()
+		}
+		if reverse {
+			return less(b, a)
+		}
+		return less(a, b)
+	})
+}
+
+func extension(name string) string {
+	if i := strings.LastIndexByte(name, '.'); i > 0 {
+		return name[i+1:]
+	}
+	return ""
+}
+
+// versionCompare compares names with runs of digits as numbers, so that
+// file9 sorts before file10.
+func versionCompare(a, b string) int {
+	for a != "" && b != "" {
+		da, db := isDigit(a[0]), isDigit(b[0])
+		if da && db {
+			na, nb := digitRun(a), digitRun(b)
+			ta, tb := strings.TrimLeft(na, "0"), strings.TrimLeft(nb, "0")
+			if len(ta) != len(tb) {
+				return len(ta) - len(tb)
 			}
-			fmt.Printf("%s %3d %-8s %-8s %8s %s %s%s%s%s%s\n",
-				mode, nlink, uname, gname, sizeStr, modtime,
-				colorStart, name+suffix, colorEnd, linkStr, "")
-		} else {
-			colorStart, colorEnd := "", ""
-			if colorize {
-				if info.IsDir() {
-					colorStart, colorEnd = colors["dir"], colors["reset"]
-				} else if info.Mode()&os.ModeSymlink != 0 {
-					colorStart, colorEnd = colors["link"], colors["reset"]
-				} else if info.Mode()&0111 != 0 {
-					colorStart, colorEnd = colors["exec"], colors["reset"]
-				}
+			if ta != tb {
+				return strings.Compare(ta, tb)
 			}
-			if onePerLine || long {
-				if inode {
-					ino := uint64(0)
-					if stat, ok := info.Sys().(*syscall.Stat_t); ok {
-						ino = stat.Ino
-					}
-					fmt.Printf("%8d ", ino)
+			a, b = a[len(na):], b[len(nb):]
+			continue
+		}
+		if a[0] != b[0] {
+			// Digits sort before anything else.
+			if da != db {
+				if da {
+					return -1
 				}
-				fmt.Printf("%s%s%s%s\n", colorStart, name, suffix, colorEnd)
-			} else {
-				fmt.Printf("%s%s%s%s  ", colorStart, name, suffix, colorEnd)
+				return 1
 			}
+			return int(a[0]) - int(b[0])
 		}
+		a, b = a[1:], b[1:]
 	}
+	return len(a) - len(b)
+}
 
-	lsDir := func(dir string, header bool) {
-		entries, err := os.ReadDir(dir)
-		if err != nil {
-			fmt.Fprintf(os.Stderr, "ls: %s: %v\n", dir, err)
-			return
+func isDigit(c byte) bool { return c >= '0' && c <= '9' }
+
+func digitRun(s string) string {
+	i := 0
+	for i < len(s) && isDigit(s[i]) {
+		i++
+	}
+	return s[:i]
+}
+
+// list prints entries in the chosen format; inDir adds the total line
+// of a long directory listing.
+func list(out *bufio.Writer, items []*entry, inDir bool) {
+	if format == formatLong {
+		listLong(out, items, inDir)
+		return
+	}
+	if inDir && blockSizes {
+		var blocks int64
+		for _, e := range items {
+			if e.stat != nil {
+				blocks += int64(e.stat.Blocks)
+			}
+		}
+		out.WriteString("total " + blockString(blocks) + "\n")
+	}
+	switch format {
+	case formatColumns, formatAcross:
+		listColumns(out, items)
+	case formatCommas:
+		listCommas(out, items)
+	default:
+		ino, blk := prefixWidths(items)
+		for _, e := range items {
+			out.WriteString(inodeField(e, ino) + blocksField(e, blk) + displayName(e) + "\n")
 		}
-		if header {
-			fmt.Printf("%s:\n", dir)
+	}
+}
+
+func inodeField(e *entry, w int) string {
+	if !inode {
+		return ""
+	}
+	ino := "?"
+	if e.stat != nil {
+		ino = strconv.FormatUint(uint64(e.stat.Ino), 10)
+	}
+	return fmt.Sprintf("%*s ", w, ino)
+}
+
+// blocksField is the -s column: the space allocated to e.
+func blocksField(e *entry, w int) string {
+	if !blockSizes {
+		return ""
+	}
+	n := "?"
+	if e.stat != nil {
+		n = blockString(int64(e.stat.Blocks))
+	}
+	return fmt.Sprintf("%*s ", w, n)
+}
+
+// blockString formats a count of 512-byte blocks in 1K units, or with -h
+// in bytes.
+func blockString(blocks int64) string {
+	if humanReadable {
+		return humanSizeLS(blocks * 512)
+	}
+	return strconv.FormatInt((blocks+1)/2, 10)
+}
+
+// prefixWidths returns the widths of the -i and -s columns for items.
+func prefixWidths(items []*entry) (ino, blk int) {
+	for _, e := range items {
+		if inode {
+			ino = max(ino, len(inodeField(e, 0))-1)
+		}
+		if blockSizes {
+			blk = max(blk, len(blocksField(e, 0))-1)
 		}
+	}
+	return ino, blk
+}
 
-		type entry struct {
-			name string
-			info fs.FileInfo
-			path string
+// displayName returns the coloured name with its -F indicator.
+func displayName(e *entry) string {
+	return colors.paint(quoteName(e.name), e) + indicator(e.info)
+}
+
+func nameWidth(e *entry) int {
+	return term.StringWidth(quoteName(e.name)) + len(indicator(e.info))
+}
+
+func indicator(info fs.FileInfo) string {
+	if info == nil {
+		return ""
+	}
+	if !classify {
+		if slashDirs && info.IsDir() {
+			return "/"
 		}
-		var items []entry
-		for _, e := range entries {
-			name := e.Name()
-			if !all && !almostAll && strings.HasPrefix(name, ".") {
-				continue
+		return ""
+	}
+	m := info.Mode()
+	switch {
+	case m.IsDir():
+		return "/"
+	case m&os.ModeSymlink != 0:
+		if format == formatLong {
+			return ""
+		}
+		return "@"
+	case m&os.ModeNamedPipe != 0:
+		return "|"
+	case m&os.ModeSocket != 0:
+		return "="
+	case m.IsRegular() && m&0111 != 0:
+		return "*"
+	}
+	return ""
+}
+
+// quoteName escapes unprintable characters with -b, and also puts the
+// name in double quotes with -Q.
+func quoteName(s string) string {
+	if !escape && !quoteNames {
+		return s
+	}
+	var b strings.Builder
+	if quoteNames {
+		b.WriteByte('"')
+	}
+	for i := 0; i < len(s); {
+		r, size := utf8.DecodeRuneInString(s[i:])
+		switch {
+		case r == '\\':
+			b.WriteString(`\\`)
+		case r == '"' && quoteNames:
+			b.WriteString(`\"`)
+		case r == ' ' && !quoteNames:
+			b.WriteString(`\ `)
+		case r < 0x20 && strings.ContainsRune("\a\b\f\n\r\t\v", r):
+			b.WriteString(`\` + string("abtnvfr"[r-'\a']))
+		case r == utf8.RuneError && size == 1 || !unicode.IsPrint(r):
+			for _, c := range []byte(s[i : i+size]) {
+				fmt.Fprintf(&b, "\\%03o", c)
 			}
-			if almostAll && (name == "." || name == "..") {
+		default:
+			b.WriteString(s[i : i+size])
+		}
+		i += size
+	}
+	if quoteNames {
+		b.WriteByte('"')
+	}
+	return b.String()
+}
+
+// listColumns lays names out in as many columns as fit the line width,
+// like GNU ls.
+func listColumns(out *bufio.Writer, items []*entry) {
+	n := len(items)
+	if n == 0 {
+		return
+	}
+	inoWidth, blkWidth := prefixWidths(items)
+	widths := make([]int, n)
+	for i, e := range items {
+		widths[i] = nameWidth(e) + len(inodeField(e, inoWidth)) + len(blocksField(e, blkWidth))
+	}
+	const sep = 2
+	cols, rows := 1, n
+	var colWidths []int
+	for c := min(n, max(width/(1+sep), 1)); c >= 1; c-- {
+		r := (n + c - 1) / c
+		if format == formatColumns {
+			// Use no more columns than the rows need.
+			if (n+r-1)/r != c {
 				continue
 			}
-			info, err := e.Info()
-			if err != nil {
-				continue
+		}
+		cw := make([]int, c)
+		for i, w := range widths {
+			col := i / r
+			if format == formatAcross {
+				col = i % c
 			}
-			items = append(items, entry{name, info, filepath.Join(dir, name)})
-		}
-		if all {
-			// Add . and ..
-			di, _ := os.Lstat(dir)
-			pi, _ := os.Lstat(filepath.Dir(dir))
-			if di != nil {
-				items = append([]entry{{".", di, dir}}, items...)
+			cw[col] = max(cw[col], w)
+		}
+		total := (c - 1) * sep
+		for _, w := range cw {
+			total += w
+		}
+		if total < width || c == 1 {
+			cols, rows, colWidths = c, r, cw
+			break
+		}
+	}
+	for r := 0; r < rows; r++ {
+		var b strings.Builder
+		pos, start := 0, 0
+		for c := 0; c < cols; c++ {
+			i := c*rows + r
+			if format == formatAcross {
+				i = r*cols + c
 			}
-			if pi != nil {
-				items = append([]entry{{".", di, dir}, {"..", pi, filepath.Dir(dir)}}, items[1:]...)
+			if i >= n {
+				break
+			}
+			e := items[i]
+			b.WriteString(inodeField(e, inoWidth) + blocksField(e, blkWidth))
+			b.WriteString(displayName(e))
+			pos += widths[i]
+			start += colWidths[c] + sep
+			next := (c+1)*rows + r
+			if format == formatAcross {
+				next = i + 1
+			}
+			if c < cols-1 && next < n {
+				pos = indent(&b, pos, start)
 			}
 		}
+		out.WriteString(b.String() + "\n")
+	}
+}
 
-		if !noSort {
-			sort.SliceStable(items, func(i, j int) bool {
-				a, b := items[i], items[j]
-				var less bool
-				switch {
-				case sortByTime:
-					less = a.info.ModTime().After(b.info.ModTime())
-				case sortBySize:
-					less = a.info.Size() > b.info.Size()
-				default:
-					less = strings.ToLower(a.name) < strings.ToLower(b.name)
-				}
-				if reverse {
-					return !less
-				}
-				return less
-			})
+// indent pads from column pos to column to as GNU ls does, with tabs
+// where a tab stop (every tabSize columns, unless it is 0) is passed.
+func indent(b *strings.Builder, pos, to int) int {
+	for pos < to {
+		if tabSize > 0 && to/tabSize > (pos+1)/tabSize {
+			b.WriteByte('\t')
+			pos += tabSize - pos%tabSize
+		} else {
+			b.WriteByte(' ')
+			pos++
 		}
+	}
+	return pos
+}
+
+// listCommas writes names separated by ", ", filling lines up to the
+// width, for -m.
+func listCommas(out *bufio.Writer, items []*entry) {
+	pos := 0
+	for i, e := range items {
+		w := nameWidth(e) + len(inodeField(e, 0)) + len(blocksField(e, 0))
+		if i > 0 {
+			if width == 0 || pos+w+2 < width {
+				out.WriteString(", ")
+				pos += 2
+			} else {
+				out.WriteString(",\n")
+				pos = 0
+			}
+		}
+		out.WriteString(inodeField(e, 0) + blocksField(e, 0) + displayName(e))
+		pos += w
+	}
+	if len(items) > 0 {
+		out.WriteString("\n")
+	}
+}
+
+var (
+	userNames  = map[uint32]string{}
+	groupNames = map[uint32]string{}
+)
+
+func lookupName(uid uint32) string {
+	if numericIDs {
+		return strconv.Itoa(int(uid))
+	}
+	if n, ok := userNames[uid]; ok {
+		return n
+	}
+	n := strconv.Itoa(int(uid))
+	if u, err := user.LookupId(n); err == nil {
+		n = u.Username
+	}
+	userNames[uid] = n
+	return n
+}
+
+func lookupGroup(gid uint32) string {
+	if numericIDs {
+		return strconv.Itoa(int(gid))
+	}
+	if n, ok := groupNames[gid]; ok {
+		return n
+	}
+	n := strconv.Itoa(int(gid))
+	if g, err := user.LookupGroupId(n); err == nil {
+		n = g.Name
+	}
+	groupNames[gid] = n
+	return n
+}
 
-		for _, item := range items {
-			printEntry(item.path, item.info)
+func listLong(out *bufio.Writer, items []*entry, inDir bool) {
+	type row struct {
+		inode, blocks, mode, links, owner, group, size, time string
+	}
+	rows := make([]row, len(items))
+	var w row
+	widthOf := func(cur *string, s string) {
+		if len(*cur) < len(s) {
+			*cur = strings.Repeat(" ", term.StringWidth(s))
+		}
+	}
+	var blocks int64
+	now := time.Now()
+	for i, e := range items {
+		r := &rows[i]
+		r.inode = strings.TrimSpace(inodeField(e, 0))
+		r.blocks = strings.TrimSpace(blocksField(e, 0))
+		r.mode = modeString(e.info.Mode()) + attrIndicator(e)
+		r.size = strconv.FormatInt(e.info.Size(), 10)
+		if humanReadable {
+			r.size = humanSizeLS(e.info.Size())
+		}
+		r.links, r.owner, r.group = "?", "?", "?"
+		if st := e.stat; st != nil {
+			r.links = strconv.FormatUint(uint64(st.Nlink), 10)
+			r.owner = lookupName(st.Uid)
+			r.group = lookupGroup(st.Gid)
+			blocks += int64(st.Blocks)
+			if m := e.info.Mode(); m&os.ModeDevice != 0 || m&os.ModeCharDevice != 0 {
+				rdev := uint64(st.Rdev)
+				r.size = fmt.Sprintf("%d, %d", (rdev>>8)&0xfff|(rdev>>32)&^0xfff, rdev&0xff|(rdev>>12)&^0xff)
+			}
+		}
+		r.time = formatTime(e.info.ModTime(), now)
+		widthOf(&w.inode, r.inode)
+		widthOf(&w.blocks, r.blocks)
+		widthOf(&w.mode, r.mode)
+		widthOf(&w.links, r.links)
+		widthOf(&w.owner, r.owner)
+		widthOf(&w.group, r.group)
+		widthOf(&w.size, r.size)
+	}
+	if inDir {
+		out.WriteString("total " + blockString(blocks) + "\n")
+	}
+	pad := func(s, w string, left bool) string {
+		n := len(w) - term.StringWidth(s)
+		if n <= 0 {
+			return s
+		}
+		if left {
+			return s + strings.Repeat(" ", n)
+		}
+		return strings.Repeat(" ", n) + s
+	}
+	for i, e := range items {
+		r := rows[i]
+		var b strings.Builder
+		if inode {
+			b.WriteString(pad(r.inode, w.inode, false) + " ")
+		}
+		if blockSizes {
+			b.WriteString(pad(r.blocks, w.blocks, false) + " ")
+		}
+		b.WriteString(pad(r.mode, w.mode, true) + " ")
+		b.WriteString(pad(r.links, w.links, false) + " ")
+		if showOwner {
+			b.WriteString(pad(r.owner, w.owner, true) + " ")
 		}
-		if !long && !onePerLine {
-			fmt.Println()
+		if showGroup {
+			b.WriteString(pad(r.group, w.group, true) + " ")
 		}
+		b.WriteString(pad(r.size, w.size, false) + " ")
+		b.WriteString(r.time + " ")
+		b.WriteString(displayName(e))
+		if e.info.Mode()&os.ModeSymlink != 0 {
+			b.WriteString(" -> ")
+			t := &entry{name: e.target, info: e.targetInfo}
+			if e.targetInfo == nil {
+				b.WriteString(colors.paintMissing(quoteName(e.target)))
+			} else {
+				b.WriteString(colors.paint(quoteName(e.target), t) + indicator(e.targetInfo))
+			}
+		}
+		b.WriteString("\n")
+		out.WriteString(b.String())
+	}
+}
 
-		if recursive {
-			for _, item := range items {
-				if item.info.IsDir() && item.name != "." && item.name != ".." {
-					fmt.Println()
-					lsDir(item.path, true)
-				}
+// humanSizeLS formats a size for -h as GNU ls does: powers of 1024,
+// rounded up, with one decimal below 10 and no "B" or "iB".
+func humanSizeLS(n int64) string {
+	if n < 1024 {
+		return strconv.FormatInt(n, 10)
+	}
+	const units = "KMGTPE"
+	u, p := uint64(n), uint64(1024)
+	i := 0
+	for u/p >= 1024 && i < len(units)-1 {
+		p *= 1024
+		i++
+	}
+	q, r := u/p, u%p
+	if tenths := q*10 + (r*10+p-1)/p; tenths < 100 {
+		return fmt.Sprintf("%d.%d%c", tenths/10, tenths%10, units[i])
+	}
+	if r > 0 {
+		q++
+	}
+	if q >= 1024 && i < len(units)-1 {
+		return fmt.Sprintf("1.0%c", units[i+1])
+	}
+	return fmt.Sprintf("%d%c", q, units[i])
+}
+
+// modeString formats permissions as ls does: setuid, setgid and sticky
+// bits show in the execute positions.
+func modeString(m fs.FileMode) string {
+	b := []byte("----------")
+	switch {
+	case m.IsDir():
+		b[0] = 'd'
+	case m&os.ModeSymlink != 0:
+		b[0] = 'l'
+	case m&os.ModeNamedPipe != 0:
+		b[0] = 'p'
+	case m&os.ModeSocket != 0:
+		b[0] = 's'
+	case m&os.ModeCharDevice != 0:
+		b[0] = 'c'
+	case m&os.ModeDevice != 0:
+		b[0] = 'b'
+	}
+	const rwx = "rwxrwxrwx"
+	for i := 0; i < 9; i++ {
+		if m&(1<<uint(8-i)) != 0 {
+			b[i+1] = rwx[i]
+		}
+	}
+	special := func(pos int, set bool, c byte) {
+		if !set {
+			return
+		}
+		if b[pos] == 'x' {
+			b[pos] = c
+		} else {
+			b[pos] = c - 'a' + 'A'
+		}
+	}
+	special(3, m&os.ModeSetuid != 0, 's')
+	special(6, m&os.ModeSetgid != 0, 's')
+	special(9, m&os.ModeSticky != 0, 't')
+	return string(b)
+}
+
+// attrIndicator returns "+" for a file with an access control list, "."
+// for one with only an SELinux context, "@" for one with other extended
+// attributes, and "" otherwise.
+func attrIndicator(e *entry) string {
+	if e.info.Mode()&os.ModeSymlink != 0 {
+		return ""
+	}
+	buf := make([]byte, 4096)
+	n, err := syscall.Listxattr(e.path, buf)
+	if err != nil || n <= 0 {
+		return ""
+	}
+	ind := ""
+	for _, name := range bytes.Split(buf[:n], []byte{0}) {
+		switch s := string(name); {
+		case s == "":
+		case s == "system.posix_acl_access" || s == "system.posix_acl_default":
+			return "+"
+		case s == "security.selinux":
+			if ind == "" {
+				ind = "."
 			}
+		default:
+			ind = "@"
 		}
 	}
+	return ind
+}
 
-	for i, d := range dirs {
-		info, err := os.Lstat(d)
-		if err != nil {
-			fmt.Fprintf(os.Stderr, "ls: %s: %v\n", d, err)
+// formatTime formats a modification time in the --time-style.
+func formatTime(t, now time.Time) string {
+	recent := t.After(now.AddDate(0, -6, 0)) && !t.After(now.Add(time.Hour))
+	switch timeStyle {
+	case "full-iso":
+		return t.Format("2006-01-02 15:04:05.000000000 -0700")
+	case "long-iso":
+		return t.Format("2006-01-02 15:04")
+	case "iso":
+		if recent {
+			return t.Format("01-02 15:04")
+		}
+		return t.Format("2006-01-02 ")
+	}
+	if strings.HasPrefix(timeStyle, "+") {
+		// +FORMAT, or +RECENT_FORMAT<newline>OLD_FORMAT.
+		f := timeStyle[1:]
+		if old, newer, ok := strings.Cut(f, "\n"); ok {
+			f = old
+			if recent {
+				f = newer
+			}
+		}
+		return datetime.Strftime(f, t)
+	}
+	if recent {
+		return t.Format("Jan _2 15:04")
+	}
+	return t.Format("Jan _2  2006")
+}
+
+// lsColors holds the colours from LS_COLORS: SGR sequences for file
+// types (di, ln, ex, ...) and for name suffixes (*.tar).
+type lsColors struct {
+	types        map[string]string
+	suffixes     []suffixColor
+	linkAsTarget bool // ln=target
+}
+
+type suffixColor struct {
+	suffix, seq string
+}
+
+// defaultLSColors are GNU ls's built-in colours. LS_COLORS is applied on
+// top of them, so it need only name the types it changes.
+const defaultLSColors = "rs=0:di=01;34:ln=01;36:pi=33:so=01;35:bd=01;33:cd=01;33:ex=01;32:do=01;35:su=37;41:sg=30;43:st=37;44:ow=34;42:tw=30;42"
+
+func parseLSColors(s string) *lsColors {
+	c := &lsColors{types: map[string]string{"lc": "\033[", "rc": "m"}}
+	c.parse(defaultLSColors)
+	c.parse(s)
+	return c
+}
+
+// parse applies the key=value entries of an LS_COLORS value.
+func (c *lsColors) parse(s string) {
+	for _, item := range strings.Split(s, ":") {
+		key, val, ok := strings.Cut(item, "=")
+		if !ok || key == "" {
 			continue
 		}
-		if info.IsDir() {
-			if i > 0 {
-				fmt.Println()
+		val = unescapeColor(val)
+		if strings.HasPrefix(key, "*") {
+			c.suffixes = append(c.suffixes, suffixColor{key[1:], val})
+			continue
+		}
+		if key == "ln" {
+			c.linkAsTarget = val == "target"
+			if c.linkAsTarget {
+				continue
 			}
-			lsDir(d, len(dirs) > 1)
-		} else {
-			printEntry(d, info)
-			if !long && !onePerLine {
-				fmt.Println()
+		}
+		c.types[key] = val
+	}
+}
+
+// unescapeColor decodes the escapes dircolors allows in LS_COLORS values:
+// \e, \a, \n, octal \NNN, ^X and the like.
+func unescapeColor(s string) string {
+	if !strings.ContainsAny(s, `\^`) {
+		return s
+	}
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		switch {
+		case s[i] == '^' && i+1 < len(s):
+			i++
+			if s[i] == '?' {
+				b.WriteByte(0x7f)
+			} else {
+				b.WriteByte(s[i] & 0x1f)
 			}
+		case s[i] == '\\' && i+1 < len(s):
+			i++
+			switch c := s[i]; c {
+			case 'e':
+				b.WriteByte(0x1b)
+			case 'a':
+				b.WriteByte('\a')
+			case 'b':
+				b.WriteByte('\b')
+			case 'f':
+				b.WriteByte('\f')
+			case 'n':
+				b.WriteByte('\n')
+			case 'r':
+				b.WriteByte('\r')
+			case 't':
+				b.WriteByte('\t')
+			case 'v':
+				b.WriteByte('\v')
+			case '_':
+				b.WriteByte(' ')
+			case '0', '1', '2', '3', '4', '5', '6', '7':
+				n := 0
+				for j := 0; j < 3 && i < len(s) && s[i] >= '0' && s[i] <= '7'; j++ {
+					n = n*8 + int(s[i]-'0')
+					i++
+				}
+				i--
+				b.WriteByte(byte(n))
+			default:
+				b.WriteByte(c)
+			}
+		default:
+			b.WriteByte(s[i])
+		}
+	}
+	return b.String()
+}
+
+// key returns the LS_COLORS key for the type of file e.
+func (c *lsColors) key(e *entry) string {
+	info := e.info
+	if info == nil {
+		return "mi"
+	}
+	m := info.Mode()
+	switch {
+	case m.IsDir():
+		switch {
+		case m&os.ModeSticky != 0 && m&0002 != 0:
+			return "tw"
+		case m&0002 != 0:
+			return "ow"
+		case m&os.ModeSticky != 0:
+			return "st"
+		}
+		return "di"
+	case m&os.ModeSymlink != 0:
+		if e.targetInfo == nil && c.types["or"] != "" {
+			return "or"
+		}
+		return "ln"
+	case m&os.ModeNamedPipe != 0:
+		return "pi"
+	case m&os.ModeSocket != 0:
+		return "so"
+	case m&os.ModeCharDevice != 0:
+		return "cd"
+	case m&os.ModeDevice != 0:
+		return "bd"
+	case m&os.ModeIrregular != 0:
+		return "do"
+	}
+	switch {
+	case m&os.ModeSetuid != 0 && c.set("su"):
+		return "su"
+	case m&os.ModeSetgid != 0 && c.set("sg"):
+		return "sg"
+	case m&0111 != 0 && c.set("ex"):
+		return "ex"
+	case e.stat != nil && e.stat.Nlink > 1 && c.set("mh"):
+		return "mh"
+	}
+	return "fi"
+}
+
+// set reports whether a type has a colour other than the default.
+func (c *lsColors) set(key string) bool {
+	v := c.types[key]
+	return v != "" && v != "0" && v != "00"
+}
+
+// sequence returns the SGR parameters for e, or "".
+func (c *lsColors) sequence(e *entry) string {
+	if e.info != nil && e.info.Mode()&os.ModeSymlink != 0 && c.linkAsTarget {
+		if e.targetInfo == nil {
+			return c.sequence(&entry{name: e.name})
 		}
+		return c.sequence(&entry{name: e.name, info: e.targetInfo})
+	}
+	k := c.key(e)
+	if k == "fi" {
+		// Later patterns take precedence; matching ignores case unless
+		// two patterns differ only in case.
+		for i := len(c.suffixes) - 1; i >= 0; i-- {
+			if strings.HasSuffix(e.name, c.suffixes[i].suffix) {
+				return c.suffixes[i].seq
+			}
+		}
+		lower := strings.ToLower(e.name)
+		for i := len(c.suffixes) - 1; i >= 0; i-- {
+			if strings.HasSuffix(lower, strings.ToLower(c.suffixes[i].suffix)) {
+				return c.suffixes[i].seq
+			}
+		}
+	}
+	if k == "mi" && !c.set("mi") {
+		k = "or"
+	}
+	return c.types[k]
+}
+
+func (c *lsColors) wrap(s, seq string) string {
+	if seq == "" {
+		return s
+	}
+	end := c.types["ec"]
+	if end == "" {
+		end = c.types["lc"] + c.types["rs"] + c.types["rc"]
+	}
+	return c.types["lc"] + seq + c.types["rc"] + s + end
+}
+
+// paint colours the name s of e; a nil *lsColors leaves it plain.
+func (c *lsColors) paint(s string, e *entry) string {
+	if c == nil {
+		return s
+	}
+	return c.wrap(s, c.sequence(e))
+}
+
+// paintMissing colours the target of a dangling symlink.
+func (c *lsColors) paintMissing(s string) string {
+	if c == nil {
+		return s
 	}
+	return c.wrap(s, c.sequence(&entry{name: s}))
 }
@@ -1,109 +1,253 @@
 package main
 
 import (
+	"errors"
 	"fmt"
-	"io"
 	"os"
 	"path/filepath"
 	"strings"
+	"syscall"
 )
 
 func main() {
 	args := os.Args[1:]
-	force := false
-	interactive := false
+	overwrite := overwriteYes
+	update := false
 	verbose := false
-	noOverwrite := false
-	backup := false
+	backup := ""
+	suffix := "~"
+	if s := os.Getenv("SIMPLE_BACKUP_SUFFIX"); s != "" {
+		suffix = s
+	}
+	targetDir := ""
+	noTargetDir := false
 	files := []string{}
 
-	for _, a := range args {
-		switch a {
-		case "-f", "--force":
-			force = true
-		case "-i", "--interactive":
-			interactive = true
-		case "-v", "--verbose":
-			verbose = true
-		case "-n", "--no-clobber":
-			noOverwrite = true
-		case "-b", "--backup":
-			backup = true
-		default:
-			if !strings.HasPrefix(a, "-") {
-				files = append(files, a)
+	fail := func(format string, a ...interface{}) {
+		fmt.Fprintf(os.Stderr, "mv: "+format+"\n", a...)
+		os.Exit(1)
+	}
+	setBackup := func(s string) {
+		if s == "" {
+			s = os.Getenv("VERSION_CONTROL")
+		}
+		b, err := parseBackup(s)
+		if err != nil {
+			fail("%v", err)
+		}
+		backup = b
+	}
+
+	for i := 0; i < len(args); i++ {
+		a := args[i]
+		next := func() string {
+			if i+1 >= len(args) {
+				fail("option requires an argument -- '%s'", strings.TrimLeft(a, "-"))
 			}
+			i++
+			return args[i]
+		}
+		switch {
+		case a == "--":
+			files = append(files, args[i+1:]...)
+			i = len(args)
+		case strings.HasPrefix(a, "--"):
+			name, val, hasVal := strings.Cut(a[2:], "=")
+			switch name {
+			case "force":
+				overwrite = overwriteYes
+			case "interactive":
+				overwrite = overwriteAsk
+			case "no-clobber":
+				overwrite = overwriteNo
+			case "update":
+				update = true
+			case "verbose":
+				verbose = true
+			case "backup":
+				setBackup(val)
+			case "suffix":
+				if !hasVal {
+					val = next()
+				}
+				suffix = val
+			case "target-directory":
+				if !hasVal {
+					val = next()
+				}
+				targetDir = val
+			case "no-target-directory":
+				noTargetDir = true
+			default:
+				fail("unrecognized option '%s'", a)
+			}
+		case strings.HasPrefix(a, "-") && len(a) > 1:
+			for j := 1; j < len(a); j++ {
+				switch a[j] {
+				case 'f':
+					overwrite = overwriteYes
+				case 'i':
+					overwrite = overwriteAsk
+				case 'n':
+					overwrite = overwriteNo
+				case 'u':
+					update = true
+				case 'v':
+					verbose = true
+				case 'b':
+					setBackup("")
+				case 'T':
+					noTargetDir = true
+				case 'S', 't':
+					val := a[j+1:]
+					if val == "" {
+						val = next()
+					}
+					if a[j] == 'S' {
+						suffix = val
+					} else {
+						targetDir = val
+					}
+					j = len(a)
+				default:
+					fail("invalid option -- '%c'", a[j])
+				}
+			}
+		default:
+			files = append(files, a)
 		}
 	}
-	_ = force
-	_ = interactive
 
-	if len(files) < 2 {
-		fmt.Fprintln(os.Stderr, "mv: missing destination")
-		os.Exit(1)
+	if targetDir != "" && noTargetDir {
+		fail("cannot combine --target-directory (-t) and --no-target-directory (-T)")
+	}
+	if len(files) == 0 {
+		fail("missing file operand")
+	}
+	if targetDir == "" {
+		if len(files) == 1 {
+			fail("missing destination file operand after '%s'", files[0])
+		}
+		targetDir = files[len(files)-1]
+		files = files[:len(files)-1]
+		if noTargetDir {
+			if len(files) > 1 {
+				fail("extra operand '%s'", files[1])
+			}
+		} else if info, err := os.Stat(targetDir); err != nil || !info.IsDir() {
+			if len(files) > 1 {
+				fail("target '%s' is not a directory", targetDir)
+			}
+			noTargetDir = true
+		}
+	} else if info, err := os.Stat(targetDir); err != nil || !info.IsDir() {
+		fail("target '%s' is not a directory", targetDir)
 	}
 
-	dest := files[len(files)-1]
-	srcs := files[:len(files)-1]
+	// The copier moves files across file systems, keeping everything
+	// about them.
+	c := newCopier(copyOptions{
+		prog: "mv", recursive: true, deref: derefNever, reflink: "auto", sparse: "auto",
+		preserveMode: true, preserveOwner: true, preserveTimes: true,
+		preserveLinks: true, preserveXattrs: true,
+		backup: backup, suffix: suffix,
+	})
 
 	exitCode := 0
-	for _, src := range srcs {
-		dst := dest
-		if info, err := os.Stat(dest); err == nil && info.IsDir() {
-			dst = filepath.Join(dest, filepath.Base(src))
+	for _, src := range files {
+		dst := targetDir
+		if !noTargetDir {
+			dst = filepath.Join(targetDir, filepath.Base(src))
 		}
-		if noOverwrite {
-			if _, err := os.Stat(dst); err == nil {
+		info, err := os.Lstat(src)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "mv: cannot stat '%s': %s\n", src, errText(err))
+			exitCode = 1
+			continue
+		}
+		backupName := ""
+		if dinfo, err := os.Lstat(dst); err == nil {
+			if os.SameFile(info, dinfo) {
+				fmt.Fprintf(os.Stderr, "mv: '%s' and '%s' are the same file\n", src, dst)
+				exitCode = 1
 				continue
 			}
-		}
-		if backup {
-			if _, err := os.Stat(dst); err == nil {
-				os.Rename(dst, dst+"~")
+			if update && !info.ModTime().After(dinfo.ModTime()) {
+				continue
 			}
-		}
-		err := os.Rename(src, dst)
-		if err != nil {
-			// Cross-device: copy then delete
-			if copyErr := crossDeviceCopy(src, dst); copyErr != nil {
-				fmt.Fprintf(os.Stderr, "mv: %v\n", copyErr)
+			if overwrite == overwriteNo {
+				continue
+			}
+			if overwrite == overwriteAsk && !askYes("mv: overwrite '%s'? ", dst) {
+				continue
+			}
+			if dinfo.IsDir() && !info.IsDir() {
+				fmt.Fprintf(os.Stderr, "mv: cannot overwrite directory '%s' with non-directory\n", dst)
+				exitCode = 1
+				continue
+			}
+			if info.IsDir() && !dinfo.IsDir() {
+				fmt.Fprintf(os.Stderr, "mv: cannot overwrite non-directory '%s' with directory '%s'\n", dst, src)
 				exitCode = 1
 				continue
 			}
-			os.RemoveAll(src)
+			if backup != "" {
+				backupName = c.backupName(dst)
+				if err := os.Rename(dst, backupName); err != nil {
+					fmt.Fprintf(os.Stderr, "mv: cannot backup '%s': %s\n", dst, errText(err))
+					exitCode = 1
+					continue
+				}
+			}
 		}
-		if verbose {
-			fmt.Printf("'%s' -> '%s'\n", src, dst)
+		if info.IsDir() && isWithin(dst, src) {
+			fmt.Fprintf(os.Stderr, "mv: cannot move '%s' to a subdirectory of itself, '%s'\n", src, dst)
+			exitCode = 1
+			continue
+		}
+		err = os.Rename(src, dst)
+		if errors.Is(err, syscall.EXDEV) {
+			err = moveAcross(c, src, dst)
+		}
+		if err != nil {
+			if err != errCopyFailed {
+				fmt.Fprintf(os.Stderr, "mv: cannot move '%s' to '%s': %s\n", src, dst, errText(err))
+			}
+			exitCode = 1
+			continue
+		}
+		switch {
+		case verbose && backupName != "":
+			fmt.Printf("renamed '%s' -> '%s' (backup: '%s')\n", src, dst, backupName)
+		case verbose:
+			fmt.Printf("renamed '%s' -> '%s'\n", src, dst)
 		}
 	}
 	os.Exit(exitCode)
 }
 
-func crossDeviceCopy(src, dst string) error {
-	srcInfo, err := os.Stat(src)
-	if err != nil {
-		return err
-	}
-	if srcInfo.IsDir() {
-		if err := os.MkdirAll(dst, srcInfo.Mode()); err != nil {
-			return err
-		}
-		entries, _ := os.ReadDir(src)
-		for _, e := range entries {
-			crossDeviceCopy(filepath.Join(src, e.Name()), filepath.Join(dst, e.Name()))
-		}
-		return nil
+// errCopyFailed reports a failed move whose errors the copier has
+// already printed.
+var errCopyFailed = errors.New("copy failed")
+
+// moveAcross moves src to another file system: it copies src next to
+// dst under a temporary name, renames the copy into place and only then
+// removes src, so dst is never left half-written.
+func moveAcross(c *copier, src, dst string) error {
+	tmp := filepath.Join(filepath.Dir(dst), fmt.Sprintf(".%s.mv%d", filepath.Base(dst), os.Getpid()))
+	c.failed = false
+	c.copy(src, tmp, true)
+	if c.failed {
+		os.RemoveAll(tmp)
+		return errCopyFailed
 	}
-	in, err := os.Open(src)
-	if err != nil {
+	if err := os.Rename(tmp, dst); err != nil {
+		os.RemoveAll(tmp)
 		return err
 	}
-	defer in.Close()
-	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, srcInfo.Mode())
-	if err != nil {
-		return err
+	if err := os.RemoveAll(src); err != nil {
+		fmt.Fprintf(os.Stderr, "mv: cannot remove '%s': %s\n", src, errText(err))
+		return errCopyFailed
 	}
-	defer out.Close()
-	_, err = io.Copy(out, in)
-	return err
+	return nil
 }
@@ -1,124 +1,680 @@
 package main
 
 import (
+	"bufio"
 	"fmt"
+	"math"
 	"os"
 	"strconv"
 	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+
+	"goutils/lib/datetime"
+	"goutils/lib/escape"
+)
+
+var (
+	out      = bufio.NewWriter(os.Stdout)
+	exitCode = 0
 )
 
+// errorf reports a bad argument; printf carries on but exits with 1.
+func errorf(format string, a ...interface{}) {
+	out.Flush()
+	fmt.Fprintf(os.Stderr, "printf: "+format+"\n", a...)
+	exitCode = 1
+}
+
+// warnf prints a warning, which does not change the exit status.
+func warnf(format string, a ...interface{}) {
+	out.Flush()
+	fmt.Fprintf(os.Stderr, "printf: warning: "+format+"\n", a...)
+}
+
+func fatalf(format string, a ...interface{}) {
+	errorf(format, a...)
+	os.Exit(1)
+}
+
 func main() {
 	args := os.Args[1:]
+	if len(args) > 0 && args[0] == "--" {
+		args = args[1:]
+	}
 	if len(args) == 0 {
-		fmt.Fprintln(os.Stderr, "printf: missing operand")
-		os.Exit(1)
+		fatalf("missing operand")
 	}
-	format := args[0]
-	argList := args[1:]
+	format, args := args[0], args[1:]
 
-	// Process format string with shell-style format specs
-	result := sprintfShell(format, argList)
-	fmt.Print(result)
+	// The format is reused until the arguments run out.
+	for {
+		used, stop := printFormat(format, args)
+		args = args[used:]
+		if stop || len(args) == 0 {
+			break
+		}
+		if used == 0 {
+			warnf("ignoring excess arguments, starting with %s", quote(args[0]))
+			break
+		}
+	}
+	out.Flush()
+	os.Exit(exitCode)
 }
 
-func sprintfShell(format string, args []string) string {
-	var sb strings.Builder
-	argIdx := 0
-	i := 0
-	for i < len(format) {
-		if format[i] == '\\' && i+1 < len(format) {
-			i++
-			switch format[i] {
-			case 'n':
-				sb.WriteByte('\n')
-			case 't':
-				sb.WriteByte('\t')
-			case 'r':
-				sb.WriteByte('\r')
-			case '\\':
-				sb.WriteByte('\\')
-			case 'a':
-				sb.WriteByte('\a')
-			case 'b':
-				sb.WriteByte('\b')
-			case 'f':
-				sb.WriteByte('\f')
-			case 'v':
-				sb.WriteByte('\v')
-			default:
-				sb.WriteByte('\\')
-				sb.WriteByte(format[i])
+// printFormat prints format once, taking conversion arguments from args.
+// It returns the number of arguments used and whether \c stopped the
+// output.
+func printFormat(format string, args []string) (int, bool) {
+	used := 0
+	next := func() (string, bool) {
+		if used == len(args) {
+			return "", false
+		}
+		used++
+		return args[used-1], true
+	}
+
+	for i := 0; i < len(format); {
+		switch format[i] {
+		case '\\':
+			s, n, stop := formatEscape(format[i:], false)
+			if stop {
+				return used, true
 			}
+			out.WriteString(s)
+			i += n
+			continue
+		case '%':
+		default:
+			out.WriteByte(format[i])
 			i++
 			continue
 		}
-		if format[i] == '%' && i+1 < len(format) {
+
+		start := i
+		i++
+		if i < len(format) && format[i] == '%' {
+			out.WriteByte('%')
 			i++
-			// Parse flags/width/precision
-			start := i - 1
-			for i < len(format) && (format[i] == '-' || format[i] == '+' || format[i] == ' ' || format[i] == '#' || format[i] == '0') {
-				i++
+			continue
+		}
+		var d directive
+		for ; i < len(format) && strings.IndexByte("-+ #0'", format[i]) >= 0; i++ {
+			d.flags += format[i : i+1]
+		}
+		d.width, d.prec = -1, -1
+		if i < len(format) && format[i] == '*' {
+			arg, _ := next()
+			n := intArg(arg)
+			if n < -math.MaxInt32 || n > math.MaxInt32 {
+				fatalf("invalid field width: %s", quote(arg))
 			}
-			for i < len(format) && format[i] >= '0' && format[i] <= '9' {
+			if n < 0 {
+				d.flags += "-"
+				n = -n
+			}
+			d.width = int(n)
+			i++
+		} else {
+			i, d.width = digits(format, i)
+		}
+		if i < len(format) && format[i] == '.' {
+			i++
+			if i < len(format) && format[i] == '*' {
+				arg, _ := next()
+				n := intArg(arg)
+				if n > math.MaxInt32 {
+					fatalf("invalid precision: %s", quote(arg))
+				}
+				d.prec = max(int(n), -1)
 				i++
+			} else {
+				i, d.prec = digits(format, i)
+				d.prec = max(d.prec, 0)
 			}
-			if i < len(format) && format[i] == '.' {
+		}
+		for i < len(format) && strings.IndexByte("hlLjzt", format[i]) >= 0 {
+			i++
+		}
+		if i == len(format) {
+			fatalf("%s: invalid conversion specification", format[start:])
+		}
+		d.conv = format[i]
+		i++
+		if d.conv == '(' {
+			// %(FMT)T formats a time, as bash does.
+			end := strings.IndexByte(format[i:], ')')
+			if end < 0 || i+end+1 >= len(format) || format[i+end+1] != 'T' {
+				fatalf("%s: invalid conversion specification", format[start:])
+			}
+			d.timeFormat = format[i : i+end]
+			d.conv = 'T'
+			i += end + 2
+		}
+		if strings.IndexByte("diouxXfFeEgGaAcsbqT", d.conv) < 0 {
+			fatalf("%s: invalid conversion specification", format[start:i])
+		}
+		arg, _ := next()
+		if d.print(arg) {
+			return used, true
+		}
+	}
+	return used, false
+}
+
+// digits reads a decimal number at s[i:], returning the index after it
+// and the number, or -1 when there is none.
+func digits(s string, i int) (int, int) {
+	n := -1
+	for ; i < len(s) && s[i] >= '0' && s[i] <= '9'; i++ {
+		n = max(n, 0)*10 + int(s[i]-'0')
+		if n > math.MaxInt32 {
+			fatalf("invalid conversion specification")
+		}
+	}
+	return i, n
+}
+
+// formatEscape expands the escape at the start of s in a format or a %b
+// argument, where unlike in echo \" is a quote and \x, \u and \U must
+// have their digits.
+func formatEscape(s string, zeroOctal bool) (string, int, bool) {
+	if len(s) < 2 {
+		return s, len(s), false
+	}
+	switch s[1] {
+	case '"':
+		return `"`, 2, false
+	case 'x', 'u', 'U':
+		need := 1
+		if s[1] == 'u' {
+			need = 4
+		} else if s[1] == 'U' {
+			need = 8
+		}
+		n := 0
+		for n < need && 2+n < len(s) && escape.HexDigit(s[2+n]) >= 0 {
+			n++
+		}
+		if n < need {
+			fatalf("missing hexadecimal number in escape")
+		}
+	}
+	return escape.Expand(s, zeroOctal)
+}
+
+// directive is a parsed conversion specification.
+type directive struct {
+	flags      string
+	width      int // -1 when absent
+	prec       int // -1 when absent
+	conv       byte
+	timeFormat string
+}
+
+func (d *directive) has(flag byte) bool { return strings.IndexByte(d.flags, flag) >= 0 }
+
+// print formats arg and reports whether a \c in a %b argument stopped the
+// output.
+func (d *directive) print(arg string) bool {
+	switch d.conv {
+	case 'd', 'i':
+		d.integer(intArg(arg), false)
+	case 'o', 'u', 'x', 'X':
+		d.integer(int64(uintArg(arg)), true)
+	case 'f', 'F', 'e', 'E', 'g', 'G', 'a', 'A':
+		d.float(floatArg(arg))
+	case 'c':
+		if arg == "" {
+			d.text("\x00")
+		} else {
+			d.text(arg[:1])
+		}
+	case 's':
+		d.text(d.truncate(arg))
+	case 'q':
+		d.text(d.truncate(shellQuote(arg)))
+	case 'T':
+		t := time.Now()
+		if n := intArg(arg); arg != "" && n != -1 && n != -2 {
+			t = time.Unix(n, 0)
+		}
+		f := d.timeFormat
+		if f == "" {
+			f = "%X"
+		}
+		d.text(d.truncate(datetime.Strftime(f, t)))
+	case 'b':
+		var b strings.Builder
+		stop := false
+		for i := 0; i < len(arg); {
+			if arg[i] != '\\' {
+				b.WriteByte(arg[i])
 				i++
-				for i < len(format) && format[i] >= '0' && format[i] <= '9' {
-					i++
-				}
+				continue
 			}
-			if i >= len(format) {
+			s, n, c := formatEscape(arg[i:], true)
+			if c {
+				stop = true
 				break
 			}
-			spec := format[start : i+1]
-			conv := format[i]
-			i++
-			arg := ""
-			if argIdx < len(args) {
-				arg = args[argIdx]
-				argIdx++
+			b.WriteString(s)
+			i += n
+		}
+		d.text(d.truncate(b.String()))
+		return stop
+	}
+	return false
+}
+
+// truncate applies the precision of %s and the like, in bytes.
+func (d *directive) truncate(s string) string {
+	if d.prec >= 0 && d.prec < len(s) {
+		return s[:d.prec]
+	}
+	return s
+}
+
+// text writes s padded with spaces to the field width.
+func (d *directive) text(s string) {
+	pad := strings.Repeat(" ", max(d.width-len(s), 0))
+	if d.has('-') {
+		out.WriteString(s + pad)
+	} else {
+		out.WriteString(pad + s)
+	}
+}
+
+// goFormat builds the fmt verb for the directive, keeping only the flags
+// in keep.
+func (d *directive) goFormat(keep string, verb byte) string {
+	f := "%"
+	for i := 0; i < len(d.flags); i++ {
+		if strings.IndexByte(keep, d.flags[i]) >= 0 && !strings.Contains(f, d.flags[i:i+1]) {
+			f += d.flags[i : i+1]
+		}
+	}
+	if d.width >= 0 {
+		f += strconv.Itoa(d.width)
+	}
+	if d.prec >= 0 {
+		f += "." + strconv.Itoa(d.prec)
+	}
+	return f + string(verb)
+}
+
+func (d *directive) integer(n int64, unsigned bool) {
+	if !unsigned {
+		keep := "-+ 0"
+		fmt.Fprintf(out, d.goFormat(keep, 'd'), n)
+		return
+	}
+	keep := "-0#"
+	if n == 0 && d.conv != 'o' {
+		keep = "-0"
+	}
+	verb := d.conv
+	if verb == 'u' {
+		verb = 'd'
+	}
+	fmt.Fprintf(out, d.goFormat(keep, verb), uint64(n))
+}
+
+func (d *directive) float(v float64) {
+	upper := d.conv == 'F' || d.conv == 'E' || d.conv == 'G' || d.conv == 'A'
+	if math.IsInf(v, 0) || math.IsNaN(v) {
+		s := "inf"
+		if math.IsNaN(v) {
+			s = "nan"
+		}
+		switch {
+		case math.Signbit(v):
+			s = "-" + s
+		case d.has('+'):
+			s = "+" + s
+		case d.has(' '):
+			s = " " + s
+		}
+		if upper {
+			s = strings.ToUpper(s)
+		}
+		d.text(s)
+		return
+	}
+	if d.conv == 'a' || d.conv == 'A' {
+		d.hexFloat(v, upper)
+		return
+	}
+	if d.prec < 0 {
+		d.prec = 6
+	}
+	verb := unicode.ToLower(rune(d.conv))
+	if upper {
+		verb = unicode.ToUpper(verb)
+	}
+	if verb == 'F' {
+		verb = 'f'
+	}
+	fmt.Fprintf(out, d.goFormat("-+ #0", byte(verb)), v)
+}
+
+// hexFloat formats %a: 0x1.hhhp+d, with a one-digit exponent where C
+// has one.
+func (d *directive) hexFloat(v float64, upper bool) {
+	s := strconv.FormatFloat(math.Abs(v), 'x', d.prec, 64)
+	if p := strings.IndexByte(s, 'p'); p >= 0 {
+		exp := strings.TrimLeft(s[p+2:], "0")
+		if exp == "" {
+			exp = "0"
+		}
+		s = s[:p+2] + exp
+		if d.has('#') && !strings.Contains(s[:p], ".") {
+			s = s[:p] + "." + s[p:]
+		}
+	}
+	sign := ""
+	switch {
+	case math.Signbit(v):
+		sign = "-"
+	case d.has('+'):
+		sign = "+"
+	case d.has(' '):
+		sign = " "
+	}
+	if d.has('0') && !d.has('-') {
+		if n := d.width - len(sign) - len(s); n > 0 {
+			s = s[:2] + strings.Repeat("0", n) + s[2:]
+		}
+	}
+	s = sign + s
+	if upper {
+		s = strings.ToUpper(s)
+	}
+	d.text(s)
+}
+
+// quote quotes s for a message, as GNU tools do: in single quotes, with
+// C escapes for backslashes, quotes, control characters and invalid
+// bytes.
+func quote(s string) string {
+	var b strings.Builder
+	b.WriteByte('\'')
+	for i := 0; i < len(s); {
+		r, size := utf8.DecodeRuneInString(s[i:])
+		switch {
+		case r == '\\' || r == '\'':
+			b.WriteByte('\\')
+			b.WriteRune(r)
+		case r == utf8.RuneError && size == 1 || !unicode.IsPrint(r) && r != ' ':
+			if j := strings.IndexByte("\a\b\f\n\r\t\v", s[i]); j >= 0 && size == 1 {
+				b.WriteByte('\\')
+				b.WriteByte("abfnrtv"[j])
+			} else {
+				for _, c := range []byte(s[i : i+size]) {
+					fmt.Fprintf(&b, "\\%03o", c)
+				}
+			}
+		default:
+			b.WriteRune(r)
+		}
+		i += size
+	}
+	b.WriteByte('\'')
+	return b.String()
+}
+
+// shellQuote quotes s for reuse as shell input, as %q does: unquoted when
+// it is safe, else in single quotes (double quotes when that avoids
+// escaping a single quote), with control characters and invalid bytes
+// written as $'...' escapes outside them.
+func shellQuote(s string) string {
+	if s == "" {
+		return "''"
+	}
+	safe, control := true, false
+	for i := 0; i < len(s); {
+		r, size := utf8.DecodeRuneInString(s[i:])
+		switch {
+		case r < 0x80 && (unicode.IsLetter(r) || unicode.IsDigit(r) || strings.ContainsRune("%+,-./:@_", r)):
+		case (r == '~' || r == '#') && i > 0:
+		case r == utf8.RuneError && size == 1, !unicode.IsPrint(r):
+			safe, control = false, true
+		default:
+			safe = false
+		}
+		i += size
+	}
+	if safe {
+		return s
+	}
+	if !control && strings.Contains(s, "'") && !strings.ContainsAny(s, "\"$`\\!") {
+		return `"` + s + `"`
+	}
+
+	var b strings.Builder
+	b.WriteByte('\'')
+	open := true
+	for i := 0; i < len(s); {
+		r, size := utf8.DecodeRuneInString(s[i:])
+		if r == utf8.RuneError && size == 1 || !unicode.IsPrint(r) {
+			if open {
+				b.WriteByte('\'')
+				open = false
 			}
-			switch conv {
-			case 's':
-				// replace %...s with proper format
-				goFmt := strings.Replace(spec, "%", "", 1)
-				goFmt = "%" + goFmt
-				sb.WriteString(fmt.Sprintf(goFmt, arg))
-			case 'd', 'i':
-				n, _ := strconv.ParseInt(arg, 0, 64)
-				goFmt := strings.Replace(spec, string(conv), "d", 1)
-				sb.WriteString(fmt.Sprintf(goFmt, n))
-			case 'u':
-				n, _ := strconv.ParseUint(arg, 0, 64)
-				goFmt := strings.Replace(spec, "u", "d", 1)
-				sb.WriteString(fmt.Sprintf(goFmt, n))
-			case 'o':
-				n, _ := strconv.ParseInt(arg, 0, 64)
-				sb.WriteString(fmt.Sprintf(spec, n))
-			case 'x':
-				n, _ := strconv.ParseInt(arg, 0, 64)
-				sb.WriteString(fmt.Sprintf(spec, n))
-			case 'X':
-				n, _ := strconv.ParseInt(arg, 0, 64)
-				sb.WriteString(fmt.Sprintf(spec, n))
-			case 'f', 'e', 'E', 'g', 'G':
-				n, _ := strconv.ParseFloat(arg, 64)
-				sb.WriteString(fmt.Sprintf(spec, n))
-			case 'c':
-				if len(arg) > 0 {
-					sb.WriteByte(arg[0])
+			b.WriteString("$'")
+			for ; i < len(s); i += size {
+				r, size = utf8.DecodeRuneInString(s[i:])
+				if !(r == utf8.RuneError && size == 1 || !unicode.IsPrint(r)) {
+					break
+				}
+				for _, c := range []byte(s[i : i+size]) {
+					if e := strings.IndexByte("\a\b\t\n\v\f\r", c); e >= 0 {
+						b.WriteString(`\` + "abtnvfr"[e:e+1])
+					} else {
+						fmt.Fprintf(&b, `\%03o`, c)
+					}
 				}
-			case '%':
-				sb.WriteByte('%')
-				argIdx-- // don't consume arg
-			default:
-				sb.WriteString(spec)
 			}
+			b.WriteByte('\'')
 			continue
 		}
-		sb.WriteByte(format[i])
+		if !open {
+			b.WriteByte('\'')
+			open = true
+		}
+		if r == '\'' {
+			b.WriteString(`'\''`)
+		} else {
+			b.WriteString(s[i : i+size])
+		}
+		i += size
+	}
+	if open {
+		b.WriteByte('\'')
+	}
+	return b.String()
+}
+
+// charConstant returns the value of an argument such as 'A or "A, the
+// code of the character after the quote.
+func charConstant(s string) (int64, bool) {
+	if len(s) < 2 || s[0] != '\'' && s[0] != '"' {
+		return 0, false
+	}
+	r, size := utf8.DecodeRuneInString(s[1:])
+	if r == utf8.RuneError && size == 1 {
+		r = rune(s[1])
+	}
+	if rest := s[1+size:]; rest != "" {
+		warnf("%s: character(s) following character constant have been ignored", rest)
+	}
+	return int64(r), true
+}
+
+// checkNumber reports an argument that is not entirely a number: with no
+// number at all (n is 0) or with something after it.
+func checkNumber(s string, n int) {
+	switch {
+	case n == 0:
+		errorf("%s: expected a numeric value", quote(s))
+	case n < len(s):
+		errorf("%s: value not completely converted", quote(s))
+	}
+}
+
+// scanInt returns the length of the integer at the start of s, as strtol
+// with base 0 reads it, and where its digits start and their base.
+func scanInt(s string) (end, digitsAt, base int) {
+	i := len(s) - len(strings.TrimLeft(s, " \t\n\v\f\r"))
+	if i < len(s) && (s[i] == '+' || s[i] == '-') {
+		i++
+	}
+	base = 10
+	if strings.HasPrefix(s[i:], "0x") || strings.HasPrefix(s[i:], "0X") {
+		if i+2 < len(s) && escape.HexDigit(s[i+2]) >= 0 {
+			base = 16
+			i += 2
+		}
+	} else if strings.HasPrefix(s[i:], "0") {
+		base = 8
+	}
+	digitsAt = i
+	for i < len(s) && escape.HexDigit(s[i]) >= 0 && escape.HexDigit(s[i]) < base {
+		i++
+	}
+	if i == digitsAt {
+		return 0, i, base
+	}
+	return i, digitsAt, base
+}
+
+// intArg converts an argument of %d and %i.
+func intArg(s string) int64 {
+	if s == "" {
+		return 0
+	}
+	if n, ok := charConstant(s); ok {
+		return n
+	}
+	end, at, base := scanInt(s)
+	checkNumber(s, end)
+	if end == 0 {
+		return 0
+	}
+	neg := strings.Contains(s[:at], "-")
+	u, err := strconv.ParseUint(s[at:end], base, 64)
+	switch {
+	case err != nil || !neg && u > math.MaxInt64:
+		errorf("%s: Numerical result out of range", quote(s))
+		if neg {
+			return math.MinInt64
+		}
+		return math.MaxInt64
+	case neg && u > 1<<63:
+		errorf("%s: Numerical result out of range", quote(s))
+		return math.MinInt64
+	case neg:
+		return -int64(u)
+	}
+	return int64(u)
+}
+
+// uintArg converts an argument of the unsigned conversions, where a
+// negative number wraps around as with strtoumax.
+func uintArg(s string) uint64 {
+	if s == "" {
+		return 0
+	}
+	if n, ok := charConstant(s); ok {
+		return uint64(n)
+	}
+	end, at, base := scanInt(s)
+	checkNumber(s, end)
+	if end == 0 {
+		return 0
+	}
+	u, err := strconv.ParseUint(s[at:end], base, 64)
+	if err != nil {
+		errorf("%s: Numerical result out of range", quote(s))
+		return math.MaxUint64
+	}
+	if strings.Contains(s[:at], "-") {
+		return -u
+	}
+	return u
+}
+
+// floatArg converts an argument of the floating-point conversions, as
+// strtold reads it: decimal or hexadecimal, inf, infinity or nan.
+func floatArg(s string) float64 {
+	if s == "" {
+		return 0
+	}
+	if n, ok := charConstant(s); ok {
+		return float64(n)
+	}
+	i := len(s) - len(strings.TrimLeft(s, " \t\n\v\f\r"))
+	start := i
+	if i < len(s) && (s[i] == '+' || s[i] == '-') {
 		i++
 	}
-	return sb.String()
+	lower := strings.ToLower(s[i:])
+	end := 0
+	hex := false
+	switch {
+	case strings.HasPrefix(lower, "infinity"):
+		end = i + 8
+	case strings.HasPrefix(lower, "inf"), strings.HasPrefix(lower, "nan"):
+		end = i + 3
+	default:
+		isDigit := func(c byte) bool { return c >= '0' && c <= '9' }
+		exp := byte('e')
+		if strings.HasPrefix(lower, "0x") && len(lower) > 2 && (escape.HexDigit(lower[2]) >= 0 || lower[2] == '.' && len(lower) > 3 && escape.HexDigit(lower[3]) >= 0) {
+			hex, exp = true, 'p'
+			isDigit = func(c byte) bool { return escape.HexDigit(c) >= 0 }
+			i += 2
+		}
+		mant := i
+		for i < len(s) && isDigit(s[i]) {
+			i++
+		}
+		if i < len(s) && s[i] == '.' {
+			i++
+			for i < len(s) && isDigit(s[i]) {
+				i++
+			}
+		}
+		if i == mant || i == mant+1 && s[mant] == '.' {
+			break
+		}
+		end = i
+		if i < len(s) && (s[i]|0x20) == exp {
+			j := i + 1
+			if j < len(s) && (s[j] == '+' || s[j] == '-') {
+				j++
+			}
+			if j < len(s) && s[j] >= '0' && s[j] <= '9' {
+				for j < len(s) && s[j] >= '0' && s[j] <= '9' {
+					j++
+				}
+				end = j
+			}
+		}
+	}
+	checkNumber(s, end)
+	if end == 0 {
+		return 0
+	}
+	num := s[start:end]
+	if hex && !strings.ContainsAny(num, "pP") {
+		num += "p0"
+	}
+	v, err := strconv.ParseFloat(num, 64)
+	if err != nil {
+		errorf("%s: Numerical result out of range", quote(s))
+	}
+	return v
 }
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"os/exec"
+	"testing"
+)
+
+// TestMain runs printf itself when the test binary is re-executed by
+// runPrintf, so that the cases see its real output and exit status.
+func TestMain(m *testing.M) {
+	if os.Getenv("GOUTILS_TEST_MAIN") == "1" {
+		main()
+		os.Exit(0)
+	}
+	os.Exit(m.Run())
+}
+
+func runPrintf(t *testing.T, args []string) (stdout, stderr string, status int) {
+	var out, errOut bytes.Buffer
+	cmd := exec.Command(os.Args[0], args...)
+	cmd.Env = append(os.Environ(), "GOUTILS_TEST_MAIN=1", "TZ=UTC")
+	cmd.Stdout, cmd.Stderr = &out, &errOut
+	var exit *exec.ExitError
+	if err := cmd.Run(); errors.As(err, &exit) {
+		status = exit.ExitCode()
+	} else if err != nil {
+		t.Fatal(err)
+	}
+	return out.String(), errOut.String(), status
+}
+
+// conformance holds output captured from GNU printf. The "Try --help"
+// line GNU adds after usage errors is left out, as printf here does not
+// print it.
+var conformance = []struct {
+	args           []string
+	stdout, stderr string
+	status         int
+}{
+	// GNU coreutils 9.1, LC_ALL=C.
+	{[]string{"%s\n", "a", "b", "c"}, "a\nb\nc\n", "", 0},
+	{[]string{"%s-%s\n", "a", "b", "c"}, "a-b\nc-\n", "", 0},
+	{[]string{"%d:%d\n", "1", "2", "3"}, "1:2\n3:0\n", "", 0},
+	{[]string{"x%sy\n"}, "xy\n", "", 0},
+	{[]string{"a\\cb"}, "a", "", 0},
+	{[]string{"%s\\c%s\n", "a", "b", "c"}, "a", "", 0},
+	{[]string{"%b|%s\n", "x\\cy", "z"}, "x", "", 0},
+	{[]string{"%b\n", "a", "b\\cc", "d"}, "a\nb", "", 0},
+	{[]string{"\\x41\\x4a\\x7e\n"}, "AJ~\n", "", 0},
+	{[]string{"\\x4g\n"}, "\x04g\n", "", 0},
+	{[]string{"\\xg\n"}, "", "printf: missing hexadecimal number in escape\n", 1},
+	{[]string{"\\u12\n"}, "", "printf: missing hexadecimal number in escape\n", 1},
+	{[]string{"\\u\n"}, "", "printf: missing hexadecimal number in escape\n", 1},
+	{[]string{"\\101\\0101\n"}, "A\x081\n", "", 0},
+	{[]string{"%b\n", "\\0101\\101\\01017"}, "AAA7\n", "", 0},
+	{[]string{"\\q\n"}, "\\q\n", "", 0},
+	{[]string{"%q\n", "a b"}, "'a b'\n", "", 0},
+	{[]string{"%q\n", "it's"}, "\"it's\"\n", "", 0},
+	{[]string{"%q\n", ""}, "''\n", "", 0},
+	{[]string{"%q\n", "a\tb"}, "'a'$'\\t''b'\n", "", 0},
+	{[]string{"%q\n", "~x"}, "'~x'\n", "", 0},
+	{[]string{"%q\n", "plain-word_1.2"}, "plain-word_1.2\n", "", 0},
+	{[]string{"%*.*d|\n", "8", "4", "42"}, "    0042|\n", "", 0},
+	{[]string{"%-*d|\n", "5", "3"}, "3    |\n", "", 0},
+	{[]string{"%.*s|\n", "2", "abcdef"}, "ab|\n", "", 0},
+	{[]string{"%*d|\n", "-5", "3"}, "3    |\n", "", 0},
+	{[]string{"%*d|\n", "x", "3"}, "3|\n", "printf: 'x': expected a numeric value\n", 1},
+	{[]string{"%d %d\n", "'A", "\"B"}, "65 66\n", "", 0},
+	{[]string{"%d\n", "'"}, "0\n", "printf: '\\'': expected a numeric value\n", 1},
+	{[]string{"%d\n", "'ab"}, "97\n", "printf: warning: b: character(s) following character constant have been ignored\n", 0},
+	{[]string{"%d\n", "abc"}, "0\n", "printf: 'abc': expected a numeric value\n", 1},
+	{[]string{"%d\n", "12abc"}, "12\n", "printf: '12abc': value not completely converted\n", 1},
+	{[]string{"%d\n", "99999999999999999999"}, "9223372036854775807\n", "printf: '99999999999999999999': Numerical result out of range\n", 1},
+	{[]string{"%d\n", "-99999999999999999999"}, "-9223372036854775808\n", "printf: '-99999999999999999999': Numerical result out of range\n", 1},
+	{[]string{"%u\n", "-1"}, "18446744073709551615\n", "", 0},
+	{[]string{"%f\n", "1.5x"}, "1.500000\n", "printf: '1.5x': value not completely converted\n", 1},
+	{[]string{"%d %d %d\n", "1", "x", "3"}, "1 0 3\n", "printf: 'x': expected a numeric value\n", 1},
+	{[]string{"%d\n", ""}, "0\n", "", 0},
+	{[]string{"%d\n", " 42"}, "42\n", "", 0},
+	{[]string{"%i %i %i\n", "0x1F", "017", "-8"}, "31 15 -8\n", "", 0},
+	{[]string{"%o %X %#x %#o\n", "8", "255", "255", "8"}, "10 FF 0xff 010\n", "", 0},
+	{[]string{"%e %g %g\n", "1234.5", "0.0001", "123456789"}, "1.234500e+03 0.0001 1.23457e+08\n", "", 0},
+	{[]string{"%5.2f|%-8.3e|\n", "3.14159", "2.5"}, " 3.14|2.500e+00|\n", "", 0},
+	{[]string{"%+d % d %05d\n", "5", "5", "-42"}, "+5  5 -0042\n", "", 0},
+	{[]string{"%c%c\n", "hello", ""}, "h\x00\n", "", 0},
+	{[]string{"%%|%5%|\n"}, "%|", "printf: %5%: invalid conversion specification\n", 1},
+	{[]string{"%z\n", "1"}, "", "printf: %z\n: invalid conversion specification\n", 1},
+	{[]string{"%\n"}, "", "printf: %\n: invalid conversion specification\n", 1},
+	{[]string{"%5s|%-5s|%.3s|\n", "ab", "cd", "abcdef"}, "   ab|cd   |abc|\n", "", 0},
+	{[]string{"%s %s\n"}, " \n", "", 0},
+	{[]string{}, "", "printf: missing operand\n", 1},
+	{[]string{"--", "%s\n", "x"}, "x\n", "", 0},
+	{[]string{"%.0f %.1f\n", "2.5", "0.05"}, "2 0.1\n", "", 0},
+	{[]string{"%ld %hd %lld\n", "1", "2", "3"}, "1 2 3\n", "", 0},
+
+	// GNU coreutils 9.1, LC_ALL=C.UTF-8: \u escapes and multibyte
+	// character constants.
+	{[]string{"\\u00e9\\U0001F600\n"}, "\u00e9\U0001f600\n", "", 0},
+	{[]string{"%b\n", "\\x41\\u00e9\\t|"}, "A\u00e9\t|\n", "", 0},
+	{[]string{"%x %d\n", "'\u00e9", "'\u20ac"}, "e9 8364\n", "", 0},
+
+	// bash 5.2 builtin, TZ=UTC: coreutils printf has no %(fmt)T.
+	{[]string{"%(%Y-%m-%d %H:%M:%S)T\n", "0"}, "1970-01-01 00:00:00\n", "", 0},
+	{[]string{"%(%s)T|%(%j)T\n", "1700000000", "86400"}, "1700000000|002\n", "", 0},
+	{[]string{"%12(%H:%M)T|\n", "3600"}, "       01:00|\n", "", 0},
+	{[]string{"%-12(%H:%M)T|\n", "3600"}, "01:00       |\n", "", 0},
+	{[]string{"%.4(%Y-%m)T|\n", "0"}, "1970|\n", "", 0},
+	{[]string{"%()T\n", "0"}, "00:00:00\n", "", 0},
+}
+
+func TestConformance(t *testing.T) {
+	for _, c := range conformance {
+		stdout, stderr, status := runPrintf(t, c.args)
+		if stdout != c.stdout || stderr != c.stderr || status != c.status {
+			t.Errorf("printf %q:\n got %q, %q, exit %d\nwant %q, %q, exit %d",
+				c.args, stdout, stderr, status, c.stdout, c.stderr, c.status)
+		}
+	}
+}
@@ -1,137 +1,271 @@
 package main
 
 import (
-	"bufio"
 	"fmt"
-	"io"
 	"os"
 	"strconv"
 	"strings"
+
+	"goutils/lib/split"
 )
 
-func main() {
-	args := os.Args[1:]
-	linesPerFile := 1000
-	bytesPerFile := int64(-1)
-	prefix := "x"
-	suffix := "aa"
-	numericSuffix := false
-	verbose := false
-	files := []string{}
+func fail(format string, a ...interface{}) {
+	fmt.Fprintf(os.Stderr, "split: "+format+"\n", a...)
+	os.Exit(1)
+}
 
-	for i := 0; i < len(args); i++ {
-		a := args[i]
-		switch {
-		case a == "-l" && i+1 < len(args):
-			i++
-			linesPerFile, _ = strconv.Atoi(args[i])
-		case strings.HasPrefix(a, "-l"):
-			linesPerFile, _ = strconv.Atoi(a[2:])
-		case a == "-b" && i+1 < len(args):
-			i++
-			bytesPerFile = parseSize(args[i])
-		case strings.HasPrefix(a, "-b"):
-			bytesPerFile = parseSize(a[2:])
-		case a == "-d" || a == "--numeric-suffixes":
-			numericSuffix = true
-		case a == "-v" || a == "--verbose":
-			verbose = true
-		case a == "--suffix-length" && i+1 < len(args):
-			i++
-			// ignored for simplicity
-		case !strings.HasPrefix(a, "-"):
-			if len(files) == 0 {
-				files = append(files, a)
-			} else {
-				prefix = a
-			}
-		}
+// count parses a positive number with an optional size suffix such as K,
+// KB or KiB.
+func count(val, what string) int64 {
+	i := 0
+	for i < len(val) && val[i] >= '0' && val[i] <= '9' {
+		i++
+	}
+	suffixes := map[string]bool{"": true, "b": true, "c": true, "w": true, "k": true, "kb": true,
+		"kib": true, "m": true, "mb": true, "mib": true, "g": true, "gb": true, "gib": true, "t": true}
+	if i == 0 || !suffixes[strings.ToLower(val[i:])] {
+		fail("invalid number of %s: '%s'", what, val)
+	}
+	n := parseSize(val)
+	if n <= 0 {
+		fail("invalid number of %s: '%s': Numerical result out of range", what, val)
 	}
-	_ = suffix
+	return n
+}
 
-	var r io.Reader = os.Stdin
-	if len(files) > 0 {
-		fh, err := os.Open(files[0])
+func parseChunks(val string) split.Chunks {
+	c := split.Chunks{}
+	s := val
+	if strings.HasPrefix(s, "l/") || strings.HasPrefix(s, "r/") {
+		c.Kind, s = s[0], s[2:]
+	}
+	ks, ns, hasK := strings.Cut(s, "/")
+	if !hasK {
+		ks, ns = "", ks
+	}
+	n, err := strconv.ParseInt(ns, 10, 64)
+	if err != nil || n <= 0 {
+		if err == nil {
+			fail("invalid number of chunks: '%s': Numerical result out of range", ns)
+		}
+		fail("invalid number of chunks: '%s'", ns)
+	}
+	c.N = n
+	if hasK {
+		k, err := strconv.ParseInt(ks, 10, 64)
 		if err != nil {
-			fmt.Fprintf(os.Stderr, "split: %v\n", err)
-			os.Exit(1)
+			fail("invalid chunk number: '%s'", ks)
+		}
+		if k <= 0 || k > n {
+			fail("invalid chunk number: '%s': Numerical result out of range", ks)
 		}
-		defer fh.Close()
-		r = fh
+		c.K = k
 	}
+	return c
+}
 
-	getFilename := func(n int) string {
-		if numericSuffix {
-			return fmt.Sprintf("%s%02d", prefix, n)
+func main() {
+	args := os.Args[1:]
+	opt := split.Options{
+		Prefix:    "x",
+		Alphabet:  "abcdefghijklmnopqrstuvwxyz",
+		Separator: '\n',
+		ErrText:   errText,
+	}
+	var (
+		mode        byte // 'l', 'b', 'C' or 'n'
+		amount      int64
+		suffixGiven bool
+		startDigits int // of FROM, which sets the least suffix length
+		operands    []string
+	)
+	setMode := func(m byte) {
+		if mode != 0 && mode != m {
+			fail("cannot split in more than one way")
 		}
-		// aa, ab, ac, ...
-		letters := "abcdefghijklmnopqrstuvwxyz"
-		suf := string([]byte{letters[n/26%26], letters[n%26]})
-		return prefix + suf
+		mode = m
 	}
-
-	fileNum := 0
-	var curFile *os.File
-	var curSize int64
-	var curLines int
-
-	newFile := func() {
-		if curFile != nil {
-			curFile.Close()
+	setSuffixes := func(digits string, val string, hasVal bool) {
+		opt.Alphabet = digits
+		if !hasVal {
+			return
 		}
-		name := getFilename(fileNum)
-		if verbose {
-			fmt.Fprintf(os.Stderr, "creating file '%s'\n", name)
+		n, err := strconv.ParseInt(val, len(digits), 64)
+		if err != nil || n < 0 {
+			fail("invalid number: '%s'", val)
 		}
-		var err error
-		curFile, err = os.Create(name)
-		if err != nil {
-			fmt.Fprintln(os.Stderr, "split:", err)
-			os.Exit(1)
+		opt.SuffixStart, startDigits = n, len(val)
+	}
+	setSeparator := func(val string) {
+		switch {
+		case val == "":
+			fail("empty record separator")
+		case val == `\0`:
+			val = "\x00"
+		case len(val) > 1:
+			fail("multi-character separator '%s'", val)
 		}
-		fileNum++
-		curSize = 0
-		curLines = 0
+		opt.Separator = val[0]
 	}
 
-	newFile()
-	if bytesPerFile > 0 {
-		buf := make([]byte, 4096)
-		for {
-			n, err := r.Read(buf)
-			if n > 0 {
-				remaining := n
-				for remaining > 0 {
-					canWrite := int(bytesPerFile - curSize)
-					if canWrite <= 0 {
-						newFile()
-						canWrite = int(bytesPerFile)
+	for i := 0; i < len(args); i++ {
+		a := args[i]
+		next := func() string {
+			if i+1 >= len(args) {
+				fail("option requires an argument -- '%s'", strings.TrimLeft(a, "-"))
+			}
+			i++
+			return args[i]
+		}
+		switch {
+		case a == "--":
+			operands = append(operands, args[i+1:]...)
+			i = len(args)
+		case strings.HasPrefix(a, "--"):
+			name, val, hasVal := strings.Cut(a[2:], "=")
+			needVal := func() string {
+				if !hasVal {
+					val = next()
+				}
+				return val
+			}
+			switch name {
+			case "suffix-length":
+				needVal()
+				n, err := strconv.Atoi(val)
+				if err != nil || n < 0 {
+					fail("invalid suffix length: '%s'", val)
+				}
+				opt.SuffixLength, suffixGiven = n, n > 0
+			case "additional-suffix":
+				opt.AdditionalSuffix = needVal()
+				if strings.Contains(opt.AdditionalSuffix, "/") {
+					fail("invalid suffix '%s', contains directory separator", opt.AdditionalSuffix)
+				}
+			case "bytes":
+				setMode('b')
+				amount = count(needVal(), "bytes")
+			case "line-bytes":
+				setMode('C')
+				amount = count(needVal(), "bytes")
+			case "lines":
+				setMode('l')
+				amount = count(needVal(), "lines")
+			case "number":
+				setMode('n')
+				opt.Chunks = parseChunks(needVal())
+			case "numeric-suffixes":
+				setSuffixes("0123456789", val, hasVal)
+			case "hex-suffixes":
+				setSuffixes("0123456789abcdef", val, hasVal)
+			case "elide-empty-files":
+				opt.Elide = true
+			case "filter":
+				opt.Filter = needVal()
+			case "separator":
+				setSeparator(needVal())
+			case "unbuffered":
+				opt.Unbuffered = true
+			case "verbose":
+				opt.Verbose = true
+			case "header":
+				opt.Header = true
+			default:
+				fail("unrecognized option '%s'", a)
+			}
+		case strings.HasPrefix(a, "-") && len(a) > 1:
+			for j := 1; j < len(a); j++ {
+				c := a[j]
+				arg := func() string {
+					val := a[j+1:]
+					if val == "" {
+						val = next()
 					}
-					toWrite := remaining
-					if toWrite > canWrite {
-						toWrite = canWrite
+					j = len(a)
+					return val
+				}
+				switch c {
+				case 'a':
+					val := arg()
+					n, err := strconv.Atoi(val)
+					if err != nil || n < 0 {
+						fail("invalid suffix length: '%s'", val)
 					}
-					curFile.Write(buf[:toWrite])
-					curSize += int64(toWrite)
-					remaining -= toWrite
+					opt.SuffixLength, suffixGiven = n, n > 0
+				case 'b':
+					setMode('b')
+					amount = count(arg(), "bytes")
+				case 'C':
+					setMode('C')
+					amount = count(arg(), "bytes")
+				case 'l':
+					setMode('l')
+					amount = count(arg(), "lines")
+				case 'n':
+					setMode('n')
+					opt.Chunks = parseChunks(arg())
+				case 'd':
+					setSuffixes("0123456789", "", false)
+				case 'x':
+					setSuffixes("0123456789abcdef", "", false)
+				case 'e':
+					opt.Elide = true
+				case 't':
+					setSeparator(arg())
+				case 'u':
+					opt.Unbuffered = true
+				default:
+					fail("invalid option -- '%c'", c)
 				}
 			}
-			if err != nil {
-				break
-			}
+		default:
+			operands = append(operands, a)
 		}
-	} else {
-		sc := bufio.NewScanner(r)
-		sc.Buffer(make([]byte, 1<<20), 1<<20)
-		for sc.Scan() {
-			if curLines >= linesPerFile {
-				newFile()
-			}
-			curFile.WriteString(sc.Text() + "\n")
-			curLines++
+	}
+	if len(operands) > 2 {
+		fail("extra operand '%s'", operands[2])
+	}
+	if len(operands) == 2 {
+		opt.Prefix = operands[1]
+	}
+	if mode == 0 {
+		mode, amount = 'l', 1000
+	}
+
+	switch mode {
+	case 'l':
+		opt.Lines = amount
+	case 'b':
+		opt.Bytes = amount
+	case 'C':
+		opt.LineBytes = amount
+	}
+
+	// Suffixes widen on their own unless their length or start was
+	// given, or -n fixes the number of files.
+	opt.SuffixAuto = !suffixGiven && startDigits == 0 && mode != 'n'
+	need := 2
+	if mode == 'n' {
+		need = split.SuffixDigits(opt.Alphabet, opt.SuffixStart+opt.Chunks.N-1)
+		if suffixGiven && opt.SuffixLength < need {
+			fail("the suffix length needs to be at least %d", need)
+		}
+	}
+	if !suffixGiven {
+		opt.SuffixLength = max(2, need, startDigits)
+	}
+
+	in := os.Stdin
+	if len(operands) > 0 && operands[0] != "-" {
+		f, err := os.Open(operands[0])
+		if err != nil {
+			fail("cannot open '%s' for reading: %s", operands[0], errText(err))
 		}
+		defer f.Close()
+		in = f
 	}
-	if curFile != nil {
-		curFile.Close()
+	if err := split.Run(in, opt); err != nil {
+		fmt.Fprintf(os.Stderr, "split: %v\n", err)
+		os.Exit(err.(*split.Error).Status)
 	}
 }
@@ -1,134 +1,163 @@
 package main
 
 import (
+	"bufio"
 	"fmt"
 	"os"
 	"strings"
-	"syscall"
-	"time"
+
+	"goutils/lib/escape"
+	"goutils/lib/statfmt"
 )
 
+// printfEscapes expands the backslash escapes of a --printf format,
+// warning about unknown ones, which stand for the character escaped.
+func printfEscapes(s string) string {
+	var b strings.Builder
+	for i := 0; i < len(s); {
+		if s[i] != '\\' || i+1 == len(s) {
+			b.WriteByte(s[i])
+			i++
+			continue
+		}
+		e, n, _ := escape.Expand(s[i:], false)
+		if e == s[i:i+2] && s[i+1] != '\\' {
+			fmt.Fprintf(os.Stderr, "stat: warning: unrecognized escape '%s'\n", e)
+			e = e[1:]
+		}
+		b.WriteString(e)
+		i += n
+	}
+	return b.String()
+}
+
 func main() {
 	args := os.Args[1:]
-	format := ""
-	dereference := false
-	fileSystem := false
-	files := []string{}
+	var (
+		format      string
+		hasFormat   bool
+		newline     bool // after each -c format
+		dereference bool
+		fileSystem  bool
+		terse       bool
+		files       []string
+	)
+
+	fail := func(format string, a ...interface{}) {
+		fmt.Fprintf(os.Stderr, "stat: "+format+"\n", a...)
+		os.Exit(1)
+	}
 
 	for i := 0; i < len(args); i++ {
 		a := args[i]
-		switch {
-		case a == "-L" || a == "--dereference":
-			dereference = true
-		case a == "-f" || a == "--file-system":
-			fileSystem = true
-		case a == "--format" && i+1 < len(args):
+		next := func() string {
+			if i+1 >= len(args) {
+				fail("option requires an argument -- '%s'", strings.TrimLeft(a, "-"))
+			}
 			i++
-			format = args[i]
-		case strings.HasPrefix(a, "--format="):
-			format = a[9:]
-		case a == "-c" && i+1 < len(args):
-			i++
-			format = args[i]
-		case strings.HasPrefix(a, "-c"):
-			format = a[2:]
-		case !strings.HasPrefix(a, "-"):
+			return args[i]
+		}
+		switch {
+		case a == "--":
+			files = append(files, args[i+1:]...)
+			i = len(args)
+		case strings.HasPrefix(a, "--"):
+			name, val, hasVal := strings.Cut(a[2:], "=")
+			switch name {
+			case "dereference":
+				dereference = true
+			case "file-system":
+				fileSystem = true
+			case "terse":
+				terse = true
+			case "format", "printf":
+				if !hasVal {
+					val = next()
+				}
+				if name == "format" {
+					format, newline = val, true
+				} else {
+					format, newline = printfEscapes(val), false
+				}
+				hasFormat = true
+			case "cached":
+			default:
+				fail("unrecognized option '%s'", a)
+			}
+		case strings.HasPrefix(a, "-") && len(a) > 1:
+			for j := 1; j < len(a); j++ {
+				switch c := a[j]; c {
+				case 'L':
+					dereference = true
+				case 'f':
+					fileSystem = true
+				case 't':
+					terse = true
+				case 'c':
+					val := a[j+1:]
+					if val == "" {
+						val = next()
+					}
+					format, newline = val, true
+					hasFormat = true
+					j = len(a)
+				default:
+					fail("invalid option -- '%c'", c)
+				}
+			}
+		default:
 			files = append(files, a)
 		}
 	}
-	_ = fileSystem
+	if len(files) == 0 {
+		fail("missing operand\nTry 'stat --help' for more information.")
+	}
 
-	statFn := os.Lstat
-	if dereference {
-		statFn = os.Stat
+	out := bufio.NewWriter(os.Stdout)
+	defer out.Flush()
+	status := 0
+	warn := func(format string, a ...interface{}) {
+		out.Flush()
+		fmt.Fprintf(os.Stderr, "stat: "+format+"\n", a...)
+		status = 1
 	}
 
-	exitCode := 0
-	for _, f := range files {
-		info, err := statFn(f)
-		if err != nil {
-			fmt.Fprintf(os.Stderr, "stat: %s: %v\n", f, err)
-			exitCode = 1
+	for _, name := range files {
+		if fileSystem {
+			if name == "-" {
+				warn("using '-' to denote standard input does not work in file system mode")
+				continue
+			}
+			f, err := statfmt.StatFS(name)
+			if err != nil {
+				warn("cannot read file system information for '%s': %s", name, errText(err))
+				continue
+			}
+			layout := format
+			if !hasFormat {
+				layout = f.Layout(terse)
+			}
+			f.Expand(out, layout)
+			if newline {
+				out.WriteByte('\n')
+			}
 			continue
 		}
-		stat := info.Sys().(*syscall.Stat_t)
-		if format != "" {
-			result := formatStat(format, f, info, stat)
-			fmt.Println(result)
-		} else {
-			printStat(f, info, stat)
-		}
-	}
-	os.Exit(exitCode)
-}
-
-func printStat(name string, info os.FileInfo, stat *syscall.Stat_t) {
-	fmt.Printf("  File: %s\n", name)
-	fmt.Printf("  Size: %-10d\tBlocks: %-10d IO Block: %-6d %s\n",
-		info.Size(), stat.Blocks, stat.Blksize, fileTypeStr(info.Mode()))
-	fmt.Printf("Device: %xh/%dd\tInode: %-10d  Links: %d\n",
-		stat.Dev, stat.Dev, stat.Ino, stat.Nlink)
-	fmt.Printf("Access: (%04o/%s)  Uid: (%5d/%8s)   Gid: (%5d/%8s)\n",
-		uint32(info.Mode()), info.Mode().String(), stat.Uid, "?", stat.Gid, "?")
-	fmt.Printf("Access: %s\n", time.Unix(stat.Atim.Sec, stat.Atim.Nsec).Format("2006-01-02 15:04:05.000000000 -0700"))
-	fmt.Printf("Modify: %s\n", info.ModTime().Format("2006-01-02 15:04:05.000000000 -0700"))
-	fmt.Printf("Change: %s\n", time.Unix(stat.Ctim.Sec, stat.Ctim.Nsec).Format("2006-01-02 15:04:05.000000000 -0700"))
-}
-
-func fileTypeStr(m os.FileMode) string {
-	switch {
-	case m.IsDir():
-		return "directory"
-	case m&os.ModeSymlink != 0:
-		return "symbolic link"
-	case m&os.ModeNamedPipe != 0:
-		return "fifo"
-	case m&os.ModeSocket != 0:
-		return "socket"
-	case m&os.ModeDevice != 0:
-		return "block special file"
-	case m&os.ModeCharDevice != 0:
-		return "character special file"
-	default:
-		return "regular file"
-	}
-}
 
-func formatStat(format string, name string, info os.FileInfo, stat *syscall.Stat_t) string {
-	var sb strings.Builder
-	for i := 0; i < len(format); i++ {
-		if format[i] != '%' || i+1 >= len(format) {
-			sb.WriteByte(format[i])
+		f, err := statfmt.StatFile(name, dereference)
+		if err != nil {
+			warn("cannot stat '%s': %s", name, errText(err))
 			continue
 		}
-		i++
-		switch format[i] {
-		case 'n':
-			sb.WriteString(name)
-		case 's':
-			sb.WriteString(fmt.Sprintf("%d", info.Size()))
-		case 'i':
-			sb.WriteString(fmt.Sprintf("%d", stat.Ino))
-		case 'a':
-			sb.WriteString(fmt.Sprintf("%o", uint32(info.Mode())&07777))
-		case 'A':
-			sb.WriteString(info.Mode().String())
-		case 'u':
-			sb.WriteString(fmt.Sprintf("%d", stat.Uid))
-		case 'g':
-			sb.WriteString(fmt.Sprintf("%d", stat.Gid))
-		case 'h':
-			sb.WriteString(fmt.Sprintf("%d", stat.Nlink))
-		case 'Y':
-			sb.WriteString(fmt.Sprintf("%d", info.ModTime().Unix()))
-		case 'y':
-			sb.WriteString(info.ModTime().Format("2006-01-02 15:04:05"))
-		case 'F':
-			sb.WriteString(fileTypeStr(info.Mode()))
-		default:
-			sb.WriteByte('%')
-			sb.WriteByte(format[i])
+		layout := format
+		if !hasFormat {
+			layout = f.Layout(terse)
+		}
+		f.Expand(out, layout)
+		if newline {
+			out.WriteByte('\n')
 		}
 	}
-	return sb.String()
+	out.Flush()
+	os.Exit(status)
 }
@@ -2,127 +2,399 @@ package main
 
 import (
 	"fmt"
+	"math/big"
 	"os"
-	"strconv"
+	"path/filepath"
+	"strings"
+	"syscall"
+
+	tty "goutils/lib/term"
 )
 
-func init() {
+// prog is the name test was run as: "[" requires a closing "]".
+var prog = "test"
 
-}
+// The expression being evaluated and the position of the next argument.
+var (
+	argv []string
+	pos  int
+)
 
 func main() {
 	args := os.Args[1:]
-	if len(args) > 0 && args[len(args)-1] == "]" {
+	if filepath.Base(os.Args[0]) == "[" {
+		prog = "["
+		if len(args) == 0 || args[len(args)-1] != "]" {
+			syntaxError("missing ']'")
+		}
 		args = args[:len(args)-1]
 	}
-	os.Args = append([]string{"test"}, args...)
-	runTest()
-}
-
-func main() {
-	args := os.Args[1:]
-	if evalTest(args) {
+	argv = args
+	if len(argv) == 0 {
+		os.Exit(1)
+	}
+	ok := posixTest(len(argv))
+	if pos != len(argv) {
+		syntaxError("extra argument '%s'", argv[pos])
+	}
+	if ok {
 		os.Exit(0)
 	}
 	os.Exit(1)
 }
 
-func evalTest(args []string) bool {
-	if len(args) == 0 {
-		return false
+// syntaxError reports a malformed expression, which exits with 2 so that
+// scripts can tell it apart from a false one.
+func syntaxError(format string, a ...interface{}) {
+	fmt.Fprintf(os.Stderr, prog+": "+format+"\n", a...)
+	os.Exit(2)
+}
+
+// advance moves to the next argument; when more are needed it is an
+// error to run out.
+func advance(more bool) {
+	pos++
+	if more && pos >= len(argv) {
+		beyond()
 	}
-	if args[0] == "!" {
-		return !evalTest(args[1:])
+}
+
+func beyond() {
+	syntaxError("missing argument after '%s'", argv[len(argv)-1])
+}
+
+// posixTest evaluates the next n arguments with the POSIX rules, which
+// decide by the argument count how the words group: that is what makes
+// "test -f = -f" or "test ! -n" unambiguous. Longer expressions are
+// parsed with -o binding looser than -a.
+func posixTest(n int) bool {
+	switch n {
+	case 1:
+		return oneArgument()
+	case 2:
+		return twoArguments()
+	case 3:
+		return threeArguments()
+	case 4:
+		if argv[pos] == "!" {
+			advance(true)
+			return !threeArguments()
+		}
+		if argv[pos] == "(" && argv[pos+3] == ")" {
+			advance(false)
+			v := twoArguments()
+			advance(false)
+			return v
+		}
 	}
-	for i, a := range args {
-		if a == "-a" && i > 0 {
-			return evalTest(args[:i]) && evalTest(args[i+1:])
+	return or()
+}
+
+func oneArgument() bool {
+	pos++
+	return argv[pos-1] != ""
+}
+
+func twoArguments() bool {
+	switch a := argv[pos]; {
+	case a == "!":
+		advance(false)
+		return !oneArgument()
+	case isOption(a):
+		if !isUnary(a) {
+			syntaxError("'%s': unary operator expected", a)
 		}
-		if a == "-o" && i > 0 {
-			return evalTest(args[:i]) || evalTest(args[i+1:])
+		return unaryOperator()
+	}
+	beyond()
+	return false
+}
+
+func threeArguments() bool {
+	switch {
+	case isBinary(argv[pos+1]):
+		return binaryOperator(false)
+	case argv[pos] == "!":
+		advance(true)
+		return !twoArguments()
+	case argv[pos] == "(" && argv[pos+2] == ")":
+		advance(false)
+		v := oneArgument()
+		advance(false)
+		return v
+	case argv[pos+1] == "-a" || argv[pos+1] == "-o":
+		return or()
+	}
+	syntaxError("'%s': binary operator expected", argv[pos+1])
+	return false
+}
+
+func or() bool {
+	v := false
+	for {
+		v = and() || v
+		if pos >= len(argv) || argv[pos] != "-o" {
+			return v
 		}
+		advance(false)
 	}
-	if len(args) == 1 {
-		return args[0] != ""
-	}
-	if len(args) == 2 {
-		op, val := args[0], args[1]
-		switch op {
-		case "-z":
-			return val == ""
-		case "-n":
-			return val != ""
-		case "-e":
-			_, err := os.Stat(val)
-			return err == nil
-		case "-f":
-			info, err := os.Stat(val)
-			return err == nil && !info.IsDir()
-		case "-d":
-			info, err := os.Stat(val)
-			return err == nil && info.IsDir()
-		case "-r":
-			f, err := os.Open(val)
-			if err == nil {
-				f.Close()
-			}
-			return err == nil
-		case "-w":
-			f, err := os.OpenFile(val, os.O_WRONLY, 0)
-			if err == nil {
-				f.Close()
+}
+
+func and() bool {
+	v := true
+	for {
+		v = term() && v
+		if pos >= len(argv) || argv[pos] != "-a" {
+			return v
+		}
+		advance(false)
+	}
+}
+
+// term evaluates a primary, with any leading "!"s.
+func term() bool {
+	negated := false
+	for pos < len(argv) && argv[pos] == "!" {
+		advance(true)
+		negated = !negated
+	}
+	if pos >= len(argv) {
+		beyond()
+	}
+
+	var v bool
+	switch a := argv[pos]; {
+	case a == "(":
+		advance(true)
+		// The POSIX rules apply to what is in the parentheses when it
+		// is short enough for them.
+		n := 1
+		for ; pos+n < len(argv) && argv[pos+n] != ")"; n++ {
+			if n == 4 {
+				n = len(argv) - pos
+				break
 			}
-			return err == nil
-		case "-x":
-			info, err := os.Stat(val)
-			return err == nil && info.Mode()&0111 != 0
-		case "-s":
-			info, err := os.Stat(val)
-			return err == nil && info.Size() > 0
-		case "-L", "-h":
-			_, err := os.Lstat(val)
-			return err == nil
-		case "-p":
-			info, err := os.Lstat(val)
-			return err == nil && info.Mode()&os.ModeNamedPipe != 0
-		case "-S":
-			info, err := os.Lstat(val)
-			return err == nil && info.Mode()&os.ModeSocket != 0
 		}
-		return false
+		v = posixTest(n)
+		if pos >= len(argv) {
+			syntaxError("')' expected")
+		}
+		if argv[pos] != ")" {
+			syntaxError("')' expected, found '%s'", argv[pos])
+		}
+		advance(false)
+	case len(argv)-pos >= 4 && a == "-l" && isBinary(argv[pos+2]):
+		v = binaryOperator(true)
+	case len(argv)-pos >= 3 && isBinary(argv[pos+1]):
+		v = binaryOperator(false)
+	case isOption(a):
+		if !isUnary(a) {
+			syntaxError("'%s': unary operator expected", a)
+		}
+		v = unaryOperator()
+	default:
+		v = a != ""
+		advance(false)
 	}
-	if len(args) == 3 {
-		left, op, right := args[0], args[1], args[2]
-		switch op {
-		case "=", "==":
-			return left == right
-		case "!=":
-			return left != right
-		case "<":
-			return left < right
-		case ">":
-			return left > right
+	return v != negated
+}
+
+// isOption reports whether s looks like a unary operator: a dash and one
+// character.
+func isOption(s string) bool {
+	return len(s) == 2 && s[0] == '-'
+}
+
+func isUnary(s string) bool {
+	return isOption(s) && strings.IndexByte("bcdefghkLnOprsStuwxzG", s[1]) >= 0
+}
+
+func isBinary(s string) bool {
+	switch s {
+	case "=", "==", "!=",
+		"-eq", "-ne", "-lt", "-le", "-gt", "-ge", "-nt", "-ot", "-ef":
+		return true
+	}
+	return false
+}
+
+// binaryOperator evaluates the comparison at pos. With leftLen the left
+// operand is "-l STRING", which compares the length of STRING; so does a
+// right operand of "-l STRING".
+func binaryOperator(leftLen bool) bool {
+	if leftLen {
+		advance(false)
+	}
+	op := pos + 1
+	left, right := argv[op-1], argv[op+1]
+	rightLen := op+2 < len(argv) && right == "-l"
+	if rightLen {
+		right = argv[op+2]
+		pos++
+	}
+	pos += 3
+
+	switch argv[op] {
+	case "=", "==":
+		return left == right
+	case "!=":
+		return left != right
+	case "-nt", "-ot", "-ef":
+		if leftLen || rightLen {
+			syntaxError("-%s does not accept -l", argv[op][1:])
 		}
-		a2, aerr := strconv.ParseInt(left, 10, 64)
-		b2, berr := strconv.ParseInt(right, 10, 64)
-		if aerr != nil || berr != nil {
-			fmt.Fprintln(os.Stderr, "test: integer expression expected")
+		return compareFiles(argv[op], left, right)
+	}
+
+	var l, r *big.Int
+	if leftLen {
+		l = big.NewInt(int64(len(left)))
+	} else {
+		l = parseInt(left)
+	}
+	if rightLen {
+		r = big.NewInt(int64(len(right)))
+	} else {
+		r = parseInt(right)
+	}
+	c := l.Cmp(r)
+	switch argv[op] {
+	case "-eq":
+		return c == 0
+	case "-ne":
+		return c != 0
+	case "-lt":
+		return c < 0
+	case "-le":
+		return c <= 0
+	case "-gt":
+		return c > 0
+	}
+	return c >= 0
+}
+
+// parseInt reads an integer operand, which may be surrounded by blanks,
+// have a sign and be of any size.
+func parseInt(s string) *big.Int {
+	t := strings.Trim(s, " \t")
+	digits := t
+	if t != "" && (t[0] == '+' || t[0] == '-') {
+		digits = t[1:]
+	}
+	n, ok := new(big.Int).SetString(digits, 10)
+	if !ok || strings.Trim(digits, "0123456789") != "" {
+		syntaxError("invalid integer '%s'", s)
+	}
+	if t[0] == '-' {
+		n.Neg(n)
+	}
+	return n
+}
+
+// compareFiles implements -nt and -ot, which compare modification times
+// and count a missing file as older than any other, and -ef.
+func compareFiles(op, a, b string) bool {
+	var sa, sb syscall.Stat_t
+	errA := syscall.Stat(a, &sa)
+	errB := syscall.Stat(b, &sb)
+	switch op {
+	case "-nt":
+		return errA == nil && (errB != nil || cmpTime(sa.Mtim, sb.Mtim) > 0)
+	case "-ot":
+		return errB == nil && (errA != nil || cmpTime(sa.Mtim, sb.Mtim) < 0)
+	}
+	return errA == nil && errB == nil && sa.Dev == sb.Dev && sa.Ino == sb.Ino
+}
+
+func cmpTime(a, b syscall.Timespec) int {
+	if a.Sec != b.Sec {
+		return cmpInt(a.Sec, b.Sec)
+	}
+	return cmpInt(a.Nsec, b.Nsec)
+}
+
+func cmpInt(a, b int64) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	}
+	return 0
+}
+
+// unaryOperator evaluates the file or string test at pos.
+func unaryOperator() bool {
+	op := argv[pos][1]
+	advance(true)
+	arg := argv[pos]
+	advance(false)
+
+	switch op {
+	case 'n':
+		return arg != ""
+	case 'z':
+		return arg == ""
+	case 't':
+		n := parseInt(arg)
+		if !n.IsInt64() || n.Int64() < 0 || n.Int64() > 1<<31-1 {
 			return false
 		}
-		switch op {
-		case "-eq":
-			return a2 == b2
-		case "-ne":
-			return a2 != b2
-		case "-lt":
-			return a2 < b2
-		case "-le":
-			return a2 <= b2
-		case "-gt":
-			return a2 > b2
-		case "-ge":
-			return a2 >= b2
-		}
+		return tty.IsTerminal(os.NewFile(uintptr(n.Int64()), ""))
+	case 'r':
+		return access(arg, 4)
+	case 'w':
+		return access(arg, 2)
+	case 'x':
+		return access(arg, 1)
+	}
+
+	var st syscall.Stat_t
+	if op == 'h' || op == 'L' {
+		return syscall.Lstat(arg, &st) == nil && st.Mode&syscall.S_IFMT == syscall.S_IFLNK
+	}
+	if syscall.Stat(arg, &st) != nil {
+		return false
+	}
+	typ := st.Mode & syscall.S_IFMT
+	switch op {
+	case 'e':
+		return true
+	case 'f':
+		return typ == syscall.S_IFREG
+	case 'd':
+		return typ == syscall.S_IFDIR
+	case 'b':
+		return typ == syscall.S_IFBLK
+	case 'c':
+		return typ == syscall.S_IFCHR
+	case 'p':
+		return typ == syscall.S_IFIFO
+	case 'S':
+		return typ == syscall.S_IFSOCK
+	case 's':
+		return st.Size > 0
+	case 'u':
+		return st.Mode&syscall.S_ISUID != 0
+	case 'g':
+		return st.Mode&syscall.S_ISGID != 0
+	case 'k':
+		return st.Mode&syscall.S_ISVTX != 0
+	case 'O':
+		return int(st.Uid) == os.Geteuid()
+	case 'G':
+		return int(st.Gid) == os.Getegid()
 	}
 	return false
 }
+
+// Flags for faccessat(2), which the syscall package does not name.
+const (
+	atFdcwd   = -0x64
+	atEaccess = 0x200
+)
+
+// access checks permission with the effective IDs, as the shell does.
+func access(path string, mode uint32) bool {
+	return syscall.Faccessat(atFdcwd, path, mode, atEaccess) == nil
+}
@@ -1,135 +1,723 @@
 package main
 
 import (
+	"bufio"
+	"bytes"
 	"fmt"
 	"io"
 	"os"
+	"strconv"
 	"strings"
+	"unicode"
+	"unicode/utf8"
 )
 
-func expandTrSet(s string) []rune {
-	var result []rune
-	runes := []rune(s)
-	for i := 0; i < len(runes); i++ {
-		if i+2 < len(runes) && runes[i+1] == '-' {
-			for c := runes[i]; c <= runes[i+2]; c++ {
-				result = append(result, c)
-			}
-			i += 2
-		} else if runes[i] == '\\' && i+1 < len(runes) {
-			i++
-			switch runes[i] {
-			case 'n':
-				result = append(result, '\n')
-			case 't':
-				result = append(result, '\t')
-			case 'r':
-				result = append(result, '\r')
-			case '\\':
-				result = append(result, '\\')
-			default:
-				result = append(result, runes[i])
+var (
+	complement bool
+	deleting   bool
+	squeezing  bool
+	truncate   bool
+	byteMode   bool
+)
+
+// item is one element of a SET: a character or a range lo-hi, a
+// [:class:], or a [c*n] repeat of lo.
+type item struct {
+	lo, hi rune
+	class  string
+	repeat int // n of [c*n]; -1 for [c*], which pads SET2 to the length of SET1
+}
+
+var classNames = map[string]bool{
+	"alnum": true, "alpha": true, "blank": true, "cntrl": true, "digit": true, "graph": true,
+	"lower": true, "print": true, "punct": true, "space": true, "upper": true, "xdigit": true,
+}
+
+func fatalf(format string, a ...interface{}) {
+	fmt.Fprintf(os.Stderr, "tr: "+format+"\n", a...)
+	os.Exit(1)
+}
+
+// classMember reports whether r is in the character class. ASCII follows
+// the C locale; beyond it, in UTF-8 mode, the Unicode categories decide,
+// while digit and xdigit stay ASCII as POSIX defines them. Invalid input
+// bytes (negative r) belong to no class.
+func classMember(class string, r rune) bool {
+	if r < 0 || r >= 0x80 && byteMode {
+		return false
+	}
+	if r >= 0x80 {
+		switch class {
+		case "alnum":
+			return unicode.IsLetter(r) || unicode.IsDigit(r)
+		case "alpha":
+			return unicode.IsLetter(r)
+		case "blank":
+			return unicode.Is(unicode.Zs, r)
+		case "cntrl":
+			return unicode.IsControl(r)
+		case "graph":
+			return unicode.IsGraphic(r) && !unicode.IsSpace(r)
+		case "lower":
+			return unicode.IsLower(r)
+		case "print":
+			return unicode.IsGraphic(r)
+		case "punct":
+			return unicode.IsPunct(r) || unicode.IsSymbol(r)
+		case "space":
+			return unicode.IsSpace(r)
+		case "upper":
+			return unicode.IsUpper(r)
+		}
+		return false
+	}
+	upper := r >= 'A' && r <= 'Z'
+	lower := r >= 'a' && r <= 'z'
+	digit := r >= '0' && r <= '9'
+	graph := r > ' ' && r < 0x7f
+	switch class {
+	case "alnum":
+		return upper || lower || digit
+	case "alpha":
+		return upper || lower
+	case "blank":
+		return r == ' ' || r == '\t'
+	case "cntrl":
+		return r < ' ' || r == 0x7f
+	case "digit":
+		return digit
+	case "graph":
+		return graph
+	case "lower":
+		return lower
+	case "print":
+		return graph || r == ' '
+	case "punct":
+		return graph && !upper && !lower && !digit
+	case "space":
+		return r == ' ' || r >= '\t' && r <= '\r'
+	case "upper":
+		return upper
+	case "xdigit":
+		return digit || r >= 'a' && r <= 'f' || r >= 'A' && r <= 'F'
+	}
+	return false
+}
+
+// parseSet parses a SET operand. In byte mode every byte of s is one
+// character; otherwise s is UTF-8 and \ooo names a code point.
+func parseSet(s string) []item {
+	var chars []rune
+	if byteMode {
+		for i := 0; i < len(s); i++ {
+			chars = append(chars, rune(s[i]))
+		}
+	} else {
+		chars = []rune(s)
+	}
+	text := func(rs []rune) string {
+		if !byteMode {
+			return string(rs)
+		}
+		b := make([]byte, len(rs))
+		for i, r := range rs {
+			b[i] = byte(r)
+		}
+		return string(b)
+	}
+
+	// char returns the character at chars[i], resolving a backslash
+	// escape, and the index after it.
+	char := func(i int) (rune, int) {
+		if chars[i] != '\\' {
+			return chars[i], i + 1
+		}
+		if i+1 == len(chars) {
+			fmt.Fprintln(os.Stderr, "tr: warning: an unescaped backslash at end of string is not portable")
+			return '\\', i + 1
+		}
+		c := chars[i+1]
+		i += 2
+		switch c {
+		case 'a':
+			return '\a', i
+		case 'b':
+			return '\b', i
+		case 'f':
+			return '\f', i
+		case 'n':
+			return '\n', i
+		case 'r':
+			return '\r', i
+		case 't':
+			return '\t', i
+		case 'v':
+			return '\v', i
+		}
+		if c >= '0' && c <= '7' {
+			v := c - '0'
+			for n := 1; n < 3 && i < len(chars) && chars[i] >= '0' && chars[i] <= '7' && v*8+chars[i]-'0' <= 0377; n++ {
+				v = v*8 + chars[i] - '0'
+				i++
+			}
+			return v, i
+		}
+		return c, i
+	}
+	// closing returns the index of the sequence end in chars at or after i.
+	closing := func(i int, end string) int {
+		for ; i+len(end) <= len(chars); i++ {
+			if text(chars[i:i+len(end)]) == end {
+				return i
 			}
-		} else {
-			result = append(result, runes[i])
 		}
+		return -1
 	}
-	return result
+
+	var items []item
+	for i := 0; i < len(chars); {
+		if chars[i] == '[' && i+1 < len(chars) {
+			switch chars[i+1] {
+			case ':':
+				if j := closing(i+2, ":]"); j >= 0 {
+					name := text(chars[i+2 : j])
+					if !classNames[name] {
+						fatalf("invalid character class '%s'", name)
+					}
+					items = append(items, item{class: name})
+					i = j + 2
+					continue
+				}
+			case '=':
+				if i+4 < len(chars) && chars[i+3] == '=' && chars[i+4] == ']' {
+					items = append(items, item{lo: chars[i+2], hi: chars[i+2]})
+					i += 5
+					continue
+				}
+			}
+			if c, j := char(i + 1); j < len(chars) && chars[j] == '*' {
+				if k := closing(j+1, "]"); k >= 0 {
+					digits := text(chars[j+1 : k])
+					n := -1
+					if digits != "" {
+						base := 10
+						if digits[0] == '0' {
+							base = 8
+						}
+						v, err := strconv.ParseUint(digits, base, 31)
+						if err != nil {
+							fatalf("invalid repeat count '%s' in [c*n] construct", digits)
+						}
+						if v > 0 {
+							n = int(v)
+						}
+					}
+					items = append(items, item{lo: c, hi: c, repeat: n})
+					i = k + 1
+					continue
+				}
+			}
+		}
+		c, j := char(i)
+		if j+1 < len(chars) && chars[j] == '-' {
+			hi, k := char(j + 1)
+			if hi < c {
+				fatalf("range-endpoints of '%s' are in reverse collating sequence order", text(chars[i:k]))
+			}
+			items = append(items, item{lo: c, hi: hi})
+			i = k
+			continue
+		}
+		items = append(items, item{lo: c, hi: c})
+		i = j
+	}
+	return items
 }
 
-func main() {
-	args := os.Args[1:]
-	deleteMode := false
-	squeezeMode := false
-	complement := false
-	sets := []string{}
+// contains reports whether r is in the SET.
+func contains(items []item, r rune) bool {
+	for _, it := range items {
+		if it.class != "" {
+			if classMember(it.class, r) {
+				return true
+			}
+		} else if r >= it.lo && r <= it.hi {
+			return true
+		}
+	}
+	return false
+}
 
-	for i := 0; i < len(args); i++ {
-		a := args[i]
+// hasClass reports whether the SET contains a [:class:].
+func hasClass(items []item) bool {
+	for _, it := range items {
+		if it.class != "" {
+			return true
+		}
+	}
+	return false
+}
+
+// span records where a class lies in an expanded SET.
+type span struct {
+	class      string
+	start, end int
+}
+
+// expand lists the characters of a SET in order, with the ASCII members
+// of each class. A [c*] repeat is as long as it takes to make the SET
+// size characters long.
+func expand(items []item, size int) ([]rune, []span) {
+	fixed, fills := 0, 0
+	for _, it := range items {
 		switch {
-		case a == "-d" || a == "--delete":
-			deleteMode = true
-		case a == "-s" || a == "--squeeze-repeats":
-			squeezeMode = true
-		case a == "-c" || a == "-C" || a == "--complement":
-			complement = true
-		case !strings.HasPrefix(a, "-"):
-			sets = append(sets, a)
+		case it.class != "":
+			for r := rune(0); r < 0x80; r++ {
+				if classMember(it.class, r) {
+					fixed++
+				}
+			}
+		case it.repeat > 0:
+			fixed += it.repeat
+		case it.repeat < 0:
+			fills++
+		default:
+			fixed += int(it.hi-it.lo) + 1
+		}
+	}
+	if fills > 1 {
+		fatalf("only one [c*] repeat construct may appear in string2")
+	}
+	var out []rune
+	var spans []span
+	for _, it := range items {
+		switch {
+		case it.class != "":
+			s := span{class: it.class, start: len(out)}
+			for r := rune(0); r < 0x80; r++ {
+				if classMember(it.class, r) {
+					out = append(out, r)
+				}
+			}
+			s.end = len(out)
+			spans = append(spans, s)
+		case it.repeat != 0:
+			n := it.repeat
+			if n < 0 {
+				n = max(size-fixed, 0)
+			}
+			for ; n > 0; n-- {
+				out = append(out, it.lo)
+			}
+		default:
+			for r := it.lo; r <= it.hi; r++ {
+				out = append(out, r)
+			}
+		}
+	}
+	return out, spans
+}
+
+// isASCII reports whether the SET names only ASCII characters, so that
+// UTF-8 input can be handled a byte at a time.
+func isASCII(items []item) bool {
+	for _, it := range items {
+		if it.class != "" || it.hi >= 0x80 {
+			return false
+		}
+	}
+	return true
+}
+
+// filter is what tr does to each character; a nil field does nothing.
+type filter struct {
+	del     func(rune) bool
+	xlat    func(rune) rune
+	squeeze func(rune) bool
+}
+
+// translation builds the SET1 to SET2 mapping. Characters beyond the
+// ASCII members of a class in SET1 map as the last of those members do,
+// except that [:lower:] to [:upper:] and back change case.
+func translation(items1, items2 []item) func(rune) rune {
+	exp1, spans1 := expand(items1, 0)
+	if complement {
+		// The complement is in code point order; whatever lies beyond
+		// Latin-1 maps to the last character of SET2.
+		exp1, spans1 = nil, nil
+		for r := rune(0); r <= 0xff; r++ {
+			if !contains(items1, r) {
+				exp1 = append(exp1, r)
+			}
+		}
+	}
+	for _, it := range items2 {
+		if it.class != "" && it.class != "upper" && it.class != "lower" {
+			fatalf("when translating, the only character classes that may appear in string2 are 'upper' and 'lower'")
+		}
+	}
+	exp2, spans2 := expand(items2, len(exp1))
+
+	// Each [:upper:] or [:lower:] in SET2 must start where an upper or
+	// lower class starts in SET1; the pair then converts case, or keeps
+	// it when both name the same class. A complemented SET1 is mapped
+	// character by character instead.
+	caseMap := map[int]func(rune) rune{}
+	if !complement {
+		for _, s2 := range spans2 {
+			if s2.start > len(exp1) {
+				continue
+			}
+			aligned := false
+			for k, s1 := range spans1 {
+				if s1.start != s2.start {
+					continue
+				}
+				switch {
+				case s1.class == s2.class:
+					caseMap[k], aligned = func(r rune) rune { return r }, true
+				case s1.class == "lower" && s2.class == "upper":
+					caseMap[k], aligned = unicode.ToUpper, true
+				case s1.class == "upper" && s2.class == "lower":
+					caseMap[k], aligned = unicode.ToLower, true
+				}
+			}
+			if !aligned {
+				fatalf("misaligned [:upper:] and/or [:lower:] construct")
+			}
 		}
 	}
 
-	set1 := []rune{}
-	set2 := []rune{}
-	if len(sets) > 0 {
-		set1 = expandTrSet(sets[0])
+	if truncate && len(exp1) > len(exp2) {
+		exp1 = exp1[:len(exp2)]
 	}
-	if len(sets) > 1 {
-		set2 = expandTrSet(sets[1])
+	if len(exp1) > len(exp2) {
+		if len(exp2) == 0 {
+			fatalf("when not truncating set1, string2 must be non-empty")
+		}
+		if items2[len(items2)-1].class != "" {
+			fatalf("when translating with string1 longer than string2,\nthe latter string must not end with a character class")
+		}
+	}
+	for len(exp2) < len(exp1) {
+		exp2 = append(exp2, exp2[len(exp2)-1])
+	}
+	if complement && hasClass(items1) {
+		one := len(exp2) == len(exp1)
+		for _, r := range exp2 {
+			one = one && r == exp2[0]
+		}
+		if !one {
+			fatalf("when translating with complemented character classes,\nstring2 must map all characters in the domain to one")
+		}
 	}
 
-	// Build complement of set1
-	if complement && !deleteMode {
-		allChars := make([]rune, 256)
-		for i := range allChars {
-			allChars[i] = rune(i)
+	var ascii [0x80]rune
+	for r := range ascii {
+		ascii[r] = rune(r)
+	}
+	table := map[rune]rune{}
+	for i, r := range exp1 {
+		if r < 0x80 {
+			ascii[r] = exp2[i]
+		} else {
+			table[r] = exp2[i]
+		}
+	}
+	last := rune(0)
+	if len(exp2) > 0 {
+		last = exp2[len(exp2)-1]
+	}
+	return func(r rune) rune {
+		if r >= 0 && r < 0x80 {
+			return ascii[r]
 		}
-		set1Map := map[rune]bool{}
-		for _, c := range set1 {
-			set1Map[c] = true
+		if m, ok := table[r]; ok {
+			return m
 		}
-		var comp []rune
-		for _, c := range allChars {
-			if !set1Map[c] {
-				comp = append(comp, c)
+		if complement {
+			if !truncate && !contains(items1, r) {
+				return last
 			}
+			return r
 		}
-		set1 = comp
+		for k, s := range spans1 {
+			if s.end <= len(exp1) && classMember(s.class, r) {
+				if f := caseMap[k]; f != nil {
+					return f(r)
+				}
+				return exp2[s.end-1]
+			}
+		}
+		return r
 	}
+}
 
-	inSet1 := func(c rune) (int, bool) {
-		for i, r := range set1 {
-			if r == c {
-				return i, true
+// runBytes filters the input a byte at a time through lookup tables
+// covering bytes below top; the others pass through.
+func (f *filter) runBytes(in io.Reader, out *bufio.Writer, top int) error {
+	var del, sq [256]bool
+	var xl [256]byte
+	for b := 0; b < 256; b++ {
+		xl[b] = byte(b)
+		if b >= top {
+			continue
+		}
+		if f.del != nil {
+			del[b] = f.del(rune(b))
+		}
+		if f.xlat != nil {
+			xl[b] = byte(f.xlat(rune(b)))
+		}
+		if f.squeeze != nil {
+			sq[b] = f.squeeze(rune(b))
+		}
+	}
+
+	// Deleting a single byte, as in tr -d '\r', copies the runs between
+	// its occurrences.
+	only := -1
+	if f.xlat == nil && f.squeeze == nil {
+		for b := range del {
+			if del[b] {
+				if only >= 0 {
+					only = -1
+					break
+				}
+				only = b
 			}
 		}
-		return -1, false
 	}
 
-	data, _ := io.ReadAll(os.Stdin)
-	var out strings.Builder
-	prev := rune(-1)
+	buf := make([]byte, 64*1024)
+	res := make([]byte, 0, len(buf))
+	prev := -1
+	for {
+		n, err := in.Read(buf)
+		chunk := buf[:n]
+		switch {
+		case only >= 0:
+			for len(chunk) > 0 {
+				i := bytes.IndexByte(chunk, byte(only))
+				if i < 0 {
+					out.Write(chunk)
+					break
+				}
+				out.Write(chunk[:i])
+				chunk = chunk[i+1:]
+			}
+		default:
+			res = res[:0]
+			for _, b := range chunk {
+				if del[b] {
+					continue
+				}
+				c := xl[b]
+				if sq[c] && int(c) == prev {
+					continue
+				}
+				prev = int(c)
+				res = append(res, c)
+			}
+			out.Write(res)
+		}
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
 
-	for _, c := range string(data) {
-		if deleteMode {
-			idx, found := inSet1(c)
-			_ = idx
-			if complement {
-				if !found {
+// runRunes filters UTF-8 input a character at a time. An invalid byte b
+// is passed to the filter as -1-b, which is in no SET.
+func (f *filter) runRunes(in io.Reader, out *bufio.Writer) error {
+	var delA, sqA [0x80]bool
+	var xlA [0x80]rune
+	for r := range xlA {
+		xlA[r] = rune(r)
+		if f.del != nil {
+			delA[r] = f.del(rune(r))
+		}
+		if f.xlat != nil {
+			xlA[r] = f.xlat(rune(r))
+		}
+		if f.squeeze != nil {
+			sqA[r] = f.squeeze(rune(r))
+		}
+	}
+
+	buf := make([]byte, 64*1024)
+	carry := 0
+	prev := rune(-1 << 20)
+	for {
+		n, err := in.Read(buf[carry:])
+		n += carry
+		i := 0
+		for i < n {
+			var r rune
+			var del, sq bool
+			if b := buf[i]; b < 0x80 {
+				r = rune(b)
+				i++
+				if delA[r] {
 					continue
 				}
+				r = xlA[r]
+				sq = r >= 0 && r < 0x80 && sqA[r]
 			} else {
-				if found {
+				if err == nil && !utf8.FullRune(buf[i:n]) {
+					break
+				}
+				c, size := utf8.DecodeRune(buf[i:n])
+				if c == utf8.RuneError && size == 1 {
+					c = -1 - rune(b)
+				}
+				i += size
+				r = c
+				del = f.del != nil && f.del(r)
+				if del {
 					continue
 				}
+				if f.xlat != nil {
+					r = f.xlat(r)
+				}
 			}
-			out.WriteRune(c)
-			continue
+			if r >= 0x80 || r < 0 {
+				sq = f.squeeze != nil && f.squeeze(r)
+			}
+			if sq && r == prev {
+				continue
+			}
+			prev = r
+			switch {
+			case r < 0:
+				out.WriteByte(byte(-1 - r))
+			case r < 0x80:
+				out.WriteByte(byte(r))
+			default:
+				out.WriteRune(r)
+			}
+		}
+		carry = copy(buf, buf[i:n])
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+func main() {
+	args := os.Args[1:]
+	sets := []string{}
+	for i := 0; i < len(args); i++ {
+		a := args[i]
+		switch {
+		case a == "--":
+			sets = append(sets, args[i+1:]...)
+			i = len(args)
+		case strings.HasPrefix(a, "--"):
+			switch a {
+			case "--complement":
+				complement = true
+			case "--delete":
+				deleting = true
+			case "--squeeze-repeats":
+				squeezing = true
+			case "--truncate-set1":
+				truncate = true
+			case "--bytes":
+				byteMode = true
+			default:
+				fatalf("unrecognized option '%s'", a)
+			}
+		case strings.HasPrefix(a, "-") && len(a) > 1:
+			for _, c := range a[1:] {
+				switch c {
+				case 'c', 'C':
+					complement = true
+				case 'd':
+					deleting = true
+				case 's':
+					squeezing = true
+				case 't':
+					truncate = true
+				default:
+					fatalf("invalid option -- '%c'", c)
+				}
+			}
+		default:
+			sets = append(sets, a)
 		}
+	}
+
+	translating := len(sets) == 2 && !deleting
+	switch {
+	case len(sets) == 0:
+		fatalf("missing operand")
+	case deleting && squeezing && len(sets) == 1:
+		fatalf("missing operand after '%s'\nTwo strings must be given when both deleting and squeezing repetitions.", sets[0])
+	case deleting && !squeezing && len(sets) == 2:
+		fatalf("extra operand '%s'\nOnly one string may be given when deleting without squeezing repeats.", sets[1])
+	case !deleting && !squeezing && len(sets) == 1:
+		fatalf("missing operand after '%s'\nTwo strings must be given when translating.", sets[0])
+	case len(sets) > 2:
+		fatalf("extra operand '%s'", sets[2])
+	}
 
-		replacement := c
-		if idx, found := inSet1(c); found && len(set2) > 0 {
-			si := idx
-			if si >= len(set2) {
-				si = len(set2) - 1
+	items1 := parseSet(sets[0])
+	var items2 []item
+	if len(sets) == 2 {
+		items2 = parseSet(sets[1])
+	}
+	for _, it := range items1 {
+		if it.repeat < 0 {
+			fatalf("the [c*] repeat construct may not appear in string1")
+		}
+	}
+	if !translating {
+		for _, it := range items2 {
+			if it.repeat != 0 {
+				fatalf("the [c*] construct may appear in string2 only when translating")
 			}
-			replacement = set2[si]
 		}
+	}
 
-		if squeezeMode && replacement == prev {
-			continue
+	in1 := func(r rune) bool { return contains(items1, r) != complement }
+	var f filter
+	switch {
+	case deleting:
+		f.del = in1
+		if squeezing {
+			f.squeeze = func(r rune) bool { return contains(items2, r) }
 		}
-		out.WriteRune(replacement)
-		prev = replacement
+	case translating:
+		f.xlat = translation(items1, items2)
+		if squeezing {
+			f.squeeze = func(r rune) bool { return contains(items2, r) }
+		}
+	default:
+		f.squeeze = in1
+	}
+
+	out := bufio.NewWriterSize(os.Stdout, 64*1024)
+	var err error
+	switch {
+	case byteMode:
+		err = f.runBytes(os.Stdin, out, 256)
+	case !complement && isASCII(items1) && isASCII(items2):
+		err = f.runBytes(os.Stdin, out, 0x80)
+	default:
+		err = f.runRunes(os.Stdin, out)
+	}
+	if err != nil {
+		out.Flush()
+		fatalf("read error: %s", errText(err))
+	}
+	if err := out.Flush(); err != nil {
+		fatalf("write error: %s", errText(err))
 	}
-	fmt.Print(out.String())
 }
@@ -0,0 +1,376 @@
+package main
+
+import (
+	"errors"
+	"regexp"
+	"regexp/syntax"
+	"strings"
+	"unicode/utf8"
+)
+
+// bre is a compiled basic regular expression. Go's regexp package runs
+// those without back-references; the others are matched by backtracking
+// over the parsed Go form, in which \1 to \9 are the runes breRef+1 to
+// breRef+9.
+type bre struct {
+	re   *regexp.Regexp
+	prog *syntax.Regexp
+	ncap int
+}
+
+const breRef = 0xF8F0 // private use; patterns with back-references may not contain breRef+1 to breRef+9
+
+// compileBRE compiles a POSIX basic regular expression, with the GNU
+// extensions \+, \?, \| and the \w \W \s \S \b \B \< \> \` \' operators,
+// by rewriting it in Go syntax. Matching is leftmost-longest, as POSIX
+// requires.
+func compileBRE(pat string) (*bre, error) {
+	var b strings.Builder
+	b.WriteString("(?s)")
+	depth := 0
+	// open holds the numbers of the groups not yet closed; a
+	// back-reference may only name a closed one.
+	var open []int
+	groups, closed, backrefs := 0, 0, false
+	// start is set where * is literal and ^ is an anchor: at the start
+	// of the expression or of a group or alternative.
+	start := true
+	for i := 0; i < len(pat); i++ {
+		c := pat[i]
+		wasStart := start
+		start = false
+		switch c {
+		case '\\':
+			i++
+			if i == len(pat) {
+				return nil, errors.New("Trailing backslash")
+			}
+			switch c = pat[i]; c {
+			case '(':
+				b.WriteByte('(')
+				depth++
+				groups++
+				open = append(open, groups)
+				start = true
+			case ')':
+				if depth == 0 {
+					return nil, errors.New("Unmatched ) or \\)")
+				}
+				b.WriteByte(')')
+				depth--
+				closed |= 1 << open[len(open)-1]
+				open = open[:len(open)-1]
+			case '|':
+				b.WriteByte('|')
+				start = true
+			case '{':
+				if wasStart {
+					b.WriteString(`\{`)
+					continue
+				}
+				end := strings.Index(pat[i:], `\}`)
+				if end < 0 {
+					return nil, errors.New("Unmatched \\{")
+				}
+				bounds := pat[i+1 : i+end]
+				if bounds == "" || strings.Trim(bounds, "0123456789,") != "" || strings.Count(bounds, ",") > 1 {
+					return nil, errors.New("Invalid content of \\{\\}")
+				}
+				if bounds[0] == ',' {
+					bounds = "0" + bounds
+				}
+				b.WriteString("{" + bounds + "}")
+				i += end + 1
+			case '+', '?':
+				if wasStart {
+					b.WriteString(`\` + string(c))
+				} else {
+					b.WriteByte(c)
+				}
+			case '<', '>':
+				b.WriteString(`\b`)
+			case '`':
+				b.WriteString(`\A`)
+			case '\'':
+				b.WriteString(`\z`)
+			case 'w', 'W', 's', 'S', 'b', 'B':
+				b.WriteString(`\` + string(c))
+			default:
+				if c >= '1' && c <= '9' {
+					if closed&(1<<(c-'0')) == 0 {
+						return nil, errors.New("Invalid back reference")
+					}
+					b.WriteRune(breRef + rune(c-'0'))
+					backrefs = true
+					continue
+				}
+				b.WriteString(regexp.QuoteMeta(string(c)))
+			}
+		case '*':
+			if wasStart {
+				b.WriteString(`\*`)
+			} else {
+				b.WriteByte('*')
+			}
+		case '^':
+			if wasStart {
+				b.WriteByte('^')
+				start = true
+			} else {
+				b.WriteString(`\^`)
+			}
+		case '$':
+			rest := pat[i+1:]
+			if rest == "" || strings.HasPrefix(rest, `\)`) || strings.HasPrefix(rest, `\|`) {
+				b.WriteByte('$')
+			} else {
+				b.WriteString(`\$`)
+			}
+		case '.':
+			b.WriteByte('.')
+		case '[':
+			n, class, err := breBracket(pat[i:])
+			if err != nil {
+				return nil, err
+			}
+			b.WriteString(class)
+			i += n - 1
+		default:
+			b.WriteString(regexp.QuoteMeta(string(c)))
+		}
+	}
+	if depth > 0 {
+		return nil, errors.New("Unmatched ( or \\(")
+	}
+	if !backrefs {
+		re, err := regexp.Compile(b.String())
+		if err != nil {
+			return nil, errors.New("Invalid regular expression")
+		}
+		re.Longest()
+		return &bre{re: re, ncap: re.NumSubexp()}, nil
+	}
+	if strings.IndexFunc(pat, func(r rune) bool { return r > breRef && r <= breRef+9 }) >= 0 {
+		return nil, errors.New("Invalid regular expression")
+	}
+	prog, err := syntax.Parse(b.String(), syntax.Perl)
+	if err != nil {
+		return nil, errors.New("Invalid regular expression")
+	}
+	return &bre{prog: prog, ncap: groups}, nil
+}
+
+// NumSubexp returns the number of \( \) groups.
+func (x *bre) NumSubexp() int { return x.ncap }
+
+// Match reports whether b contains a match.
+func (x *bre) Match(b []byte) bool {
+	if x.re != nil {
+		return x.re.Match(b)
+	}
+	return x.FindStringSubmatchIndex(string(b)) != nil
+}
+
+// FindStringSubmatchIndex returns the positions of the leftmost-longest
+// match in s and of its groups, as regexp's method of that name does.
+func (x *bre) FindStringSubmatchIndex(s string) []int {
+	if x.re != nil {
+		return x.re.FindStringSubmatchIndex(s)
+	}
+	m := &breMatcher{s: s, caps: make([]int, 2*x.ncap+2)}
+	for start := 0; start <= len(s); start++ {
+		for i := range m.caps {
+			m.caps[i] = -1
+		}
+		m.best = nil
+		m.match(x.prog, start, func(end int) bool {
+			if m.best == nil || end > m.best[1] {
+				m.best = append(append(m.best[:0], start, end), m.caps[2:]...)
+			}
+			return end == len(s) // nothing can be longer
+		})
+		if m.best != nil {
+			return m.best
+		}
+	}
+	return nil
+}
+
+// breMatcher tries every way a parsed expression can match s, passing
+// each end position to a continuation that stops the search by
+// returning true.
+type breMatcher struct {
+	s    string
+	caps []int // group starts and ends while matching
+	best []int
+}
+
+func (m *breMatcher) match(re *syntax.Regexp, i int, k func(int) bool) bool {
+	s := m.s
+	switch re.Op {
+	case syntax.OpEmptyMatch:
+		return k(i)
+	case syntax.OpLiteral:
+		return m.literal(re.Rune, i, k)
+	case syntax.OpCharClass, syntax.OpAnyChar, syntax.OpAnyCharNotNL:
+		if i == len(s) {
+			return false
+		}
+		r, size := utf8.DecodeRuneInString(s[i:])
+		switch re.Op {
+		case syntax.OpAnyCharNotNL:
+			if r == '\n' {
+				return false
+			}
+		case syntax.OpCharClass:
+			in := false
+			for j := 0; j < len(re.Rune) && !in; j += 2 {
+				in = re.Rune[j] <= r && r <= re.Rune[j+1]
+			}
+			if !in {
+				return false
+			}
+		}
+		return k(i + size)
+	case syntax.OpBeginLine:
+		return (i == 0 || s[i-1] == '\n') && k(i)
+	case syntax.OpEndLine:
+		return (i == len(s) || s[i] == '\n') && k(i)
+	case syntax.OpBeginText:
+		return i == 0 && k(i)
+	case syntax.OpEndText:
+		return i == len(s) && k(i)
+	case syntax.OpWordBoundary, syntax.OpNoWordBoundary:
+		before := i > 0 && syntax.IsWordChar(rune(s[i-1]))
+		after := i < len(s) && syntax.IsWordChar(rune(s[i]))
+		return (before != after) == (re.Op == syntax.OpWordBoundary) && k(i)
+	case syntax.OpCapture:
+		n := re.Cap
+		oldStart, oldEnd := m.caps[2*n], m.caps[2*n+1]
+		if m.match(re.Sub[0], i, func(j int) bool {
+			saveStart, saveEnd := m.caps[2*n], m.caps[2*n+1]
+			m.caps[2*n], m.caps[2*n+1] = i, j
+			if k(j) {
+				return true
+			}
+			m.caps[2*n], m.caps[2*n+1] = saveStart, saveEnd
+			return false
+		}) {
+			return true
+		}
+		m.caps[2*n], m.caps[2*n+1] = oldStart, oldEnd
+		return false
+	case syntax.OpConcat:
+		return m.concat(re.Sub, i, k)
+	case syntax.OpAlternate:
+		for _, sub := range re.Sub {
+			if m.match(sub, i, k) {
+				return true
+			}
+		}
+		return false
+	case syntax.OpQuest:
+		return m.repeat(re.Sub[0], i, 0, 0, 1, k)
+	case syntax.OpStar:
+		return m.repeat(re.Sub[0], i, 0, 0, -1, k)
+	case syntax.OpPlus:
+		return m.repeat(re.Sub[0], i, 0, 1, -1, k)
+	case syntax.OpRepeat:
+		return m.repeat(re.Sub[0], i, 0, re.Min, re.Max, k)
+	}
+	return false
+}
+
+// literal matches a run of runes, any of which may be a back-reference.
+func (m *breMatcher) literal(runes []rune, i int, k func(int) bool) bool {
+	for _, want := range runes {
+		if want > breRef && want <= breRef+9 {
+			n := int(want - breRef)
+			if m.caps[2*n] < 0 {
+				return false
+			}
+			ref := m.s[m.caps[2*n]:m.caps[2*n+1]]
+			if !strings.HasPrefix(m.s[i:], ref) {
+				return false
+			}
+			i += len(ref)
+			continue
+		}
+		r, size := utf8.DecodeRuneInString(m.s[i:])
+		if size == 0 || r != want {
+			return false
+		}
+		i += size
+	}
+	return k(i)
+}
+
+func (m *breMatcher) concat(subs []*syntax.Regexp, i int, k func(int) bool) bool {
+	if len(subs) == 0 {
+		return k(i)
+	}
+	return m.match(subs[0], i, func(j int) bool { return m.concat(subs[1:], j, k) })
+}
+
+// repeat matches sub greedily, n times so far, between min and max (-1
+// for no limit) times in all. An iteration past min must consume input.
+func (m *breMatcher) repeat(sub *syntax.Regexp, i, n, min, max int, k func(int) bool) bool {
+	if max < 0 || n < max {
+		if m.match(sub, i, func(j int) bool {
+			if j == i && n >= min {
+				return false
+			}
+			return m.repeat(sub, j, n+1, min, max, k)
+		}) {
+			return true
+		}
+	}
+	return n >= min && k(i)
+}
+
+// breBracket translates the bracket expression at the start of s,
+// returning its length and Go form. A backslash is an ordinary character
+// in it, and ] is one when it comes first.
+func breBracket(s string) (int, string, error) {
+	var b strings.Builder
+	b.WriteByte('[')
+	i := 1
+	if i < len(s) && s[i] == '^' {
+		b.WriteByte('^')
+		i++
+	}
+	for first := true; i < len(s); first = false {
+		c := s[i]
+		if c == ']' && !first {
+			b.WriteByte(']')
+			return i + 1, b.String(), nil
+		}
+		if c == '[' && i+1 < len(s) && strings.IndexByte(":=.", s[i+1]) >= 0 {
+			delim := s[i+1]
+			end := strings.Index(s[i+2:], string(delim)+"]")
+			if end < 0 {
+				break
+			}
+			name := s[i+2 : i+2+end]
+			if delim == ':' {
+				if _, err := regexp.Compile("[[:" + name + ":]]"); err != nil {
+					return 0, "", errors.New("Invalid character class name")
+				}
+				b.WriteString("[:" + name + ":]")
+			} else {
+				// Equivalence classes and collating symbols are single
+				// characters in the C locale.
+				b.WriteString(regexp.QuoteMeta(name))
+			}
+			i += end + 4
+			continue
+		}
+		switch c {
+		case '\\', '[', ']':
+			b.WriteByte('\\')
+		}
+		b.WriteByte(c)
+		i++
+	}
+	return 0, "", errors.New("Unmatched [, [^, [:, [., or [=")
+}
@@ -0,0 +1,541 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"syscall"
+	"unsafe"
+)
+
+// Symlink handling of the copy engine (-P, -H, -L).
+const (
+	derefNever  = iota // copy symlinks as symlinks
+	derefArgs          // follow symlinks named on the command line
+	derefAlways        // follow all symlinks
+)
+
+// What to do when the destination exists (-f, -n, -i).
+const (
+	overwriteYes = iota
+	overwriteNo
+	overwriteAsk
+)
+
+// copyOptions controls the copy engine shared by cp and by mv across
+// file systems.
+type copyOptions struct {
+	prog      string // command name for messages
+	recursive bool
+	deref     int
+	reflink   string // "auto", "always" or "never"
+	sparse    string // "auto", "always" or "never"
+
+	preserveMode   bool
+	preserveOwner  bool
+	preserveTimes  bool
+	preserveLinks  bool
+	preserveXattrs bool
+
+	overwrite int
+	update    bool // only replace older destinations
+	force     bool // remove destinations that cannot be opened
+	backup    string
+	suffix    string
+	verbose   bool
+}
+
+type fileID struct {
+	dev, ino uint64
+}
+
+// copier copies files and trees, reporting errors as it goes.
+type copier struct {
+	copyOptions
+	links  map[fileID]string // first copy of each multiply-linked file
+	umask  os.FileMode
+	failed bool
+}
+
+func newCopier(opt copyOptions) *copier {
+	mask := syscall.Umask(0)
+	syscall.Umask(mask)
+	return &copier{copyOptions: opt, links: map[fileID]string{}, umask: os.FileMode(mask)}
+}
+
+func (c *copier) errorf(format string, a ...interface{}) {
+	fmt.Fprintf(os.Stderr, c.prog+": "+format+"\n", a...)
+	c.failed = true
+}
+
+// askYes prints a question and reports whether the answer is yes
+func askYes(format string, a ...interface{}) bool {
+	fmt.Fprintf(os.Stderr, format, a...)
+	line, _ := bufio.NewReader(os.Stdin).ReadString('\n')
+	resp := strings.ToLower(strings.TrimSpace(line))
+	return resp == "y" || resp == "yes"
+}
+
+// parseBackup returns the backup method for a --backup argument or the
+// VERSION_CONTROL variable.
+func parseBackup(s string) (string, error) {
+	switch s {
+	case "", "existing", "nil":
+		return "existing", nil
+	case "none", "off":
+		return "", nil
+	case "simple", "never":
+		return "simple", nil
+	case "numbered", "t":
+		return "numbered", nil
+	}
+	return "", fmt.Errorf("invalid argument '%s' for 'backup type'", s)
+}
+
+// backupName returns the name to rename dst to before overwriting it:
+// dst~ or dst.~N~.
+func (c *copier) backupName(dst string) string {
+	if c.backup == "simple" {
+		return dst + c.suffix
+	}
+	highest := 0
+	prefix := filepath.Base(dst) + ".~"
+	entries, _ := os.ReadDir(filepath.Dir(dst))
+	for _, e := range entries {
+		name := e.Name()
+		if !strings.HasPrefix(name, prefix) || !strings.HasSuffix(name, "~") {
+			continue
+		}
+		if n, err := strconv.Atoi(name[len(prefix) : len(name)-1]); err == nil && n > highest {
+			highest = n
+		}
+	}
+	if c.backup == "existing" && highest == 0 {
+		return dst + c.suffix
+	}
+	return fmt.Sprintf("%s.~%d~", dst, highest+1)
+}
+
+func statID(info os.FileInfo) (fileID, *syscall.Stat_t) {
+	st, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return fileID{}, nil
+	}
+	return fileID{uint64(st.Dev), st.Ino}, st
+}
+
+// isWithin reports whether path is dir or lies below it.
+func isWithin(path, dir string) bool {
+	p, err1 := filepath.Abs(path)
+	d, err2 := filepath.Abs(dir)
+	if err1 != nil || err2 != nil {
+		return false
+	}
+	return p == d || strings.HasPrefix(p, d+string(filepath.Separator))
+}
+
+// copy copies src to dst. top is set for operands named on the command
+// line; errors are reported and recorded in c.failed.
+func (c *copier) copy(src, dst string, top bool) {
+	var info os.FileInfo
+	var err error
+	if c.deref == derefAlways || c.deref == derefArgs && top {
+		info, err = os.Stat(src)
+	} else {
+		info, err = os.Lstat(src)
+	}
+	if err != nil {
+		c.errorf("cannot stat '%s': %s", src, errText(err))
+		return
+	}
+	id, st := statID(info)
+
+	dinfo, derr := os.Lstat(dst)
+	exists := derr == nil
+	if exists {
+		if did, _ := statID(dinfo); did == id && st != nil {
+			c.errorf("'%s' and '%s' are the same file", src, dst)
+			return
+		}
+		if info.IsDir() && !dinfo.IsDir() {
+			c.errorf("cannot overwrite non-directory '%s' with directory '%s'", dst, src)
+			return
+		}
+		if !info.IsDir() {
+			if dinfo.IsDir() {
+				c.errorf("cannot overwrite directory '%s' with non-directory", dst)
+				return
+			}
+			if c.update && !info.ModTime().After(dinfo.ModTime()) {
+				return
+			}
+			switch c.overwrite {
+			case overwriteNo:
+				return
+			case overwriteAsk:
+				if !askYes("%s: overwrite '%s'? ", c.prog, dst) {
+					return
+				}
+			}
+		}
+	}
+	backup := ""
+	if exists && !info.IsDir() && c.backup != "" {
+		backup = c.backupName(dst)
+		if err := os.Rename(dst, backup); err != nil {
+			c.errorf("cannot backup '%s': %s", dst, errText(err))
+			return
+		}
+		exists = false
+	}
+	if c.verbose {
+		if backup != "" {
+			fmt.Printf("'%s' -> '%s' (backup: '%s')\n", src, dst, backup)
+		} else {
+			fmt.Printf("'%s' -> '%s'\n", src, dst)
+		}
+	}
+
+	mode := info.Mode()
+	if c.preserveLinks && st != nil && st.Nlink > 1 && !mode.IsDir() {
+		if first, ok := c.links[id]; ok {
+			if exists {
+				os.Remove(dst)
+			}
+			if err := os.Link(first, dst); err != nil {
+				c.errorf("cannot create hard link '%s' to '%s': %s", dst, first, errText(err))
+			}
+			return
+		}
+		c.links[id] = dst
+	}
+
+	switch {
+	case mode.IsDir():
+		if !c.recursive {
+			c.errorf("-r not specified; omitting directory '%s'", src)
+			return
+		}
+		if isWithin(dst, src) {
+			c.errorf("cannot copy a directory, '%s', into itself, '%s'", src, dst)
+			return
+		}
+		if !exists {
+			if err := os.Mkdir(dst, mode.Perm()|0700); err != nil {
+				c.errorf("cannot create directory '%s': %s", dst, errText(err))
+				return
+			}
+		}
+		entries, err := os.ReadDir(src)
+		if err != nil {
+			c.errorf("cannot access '%s': %s", src, errText(err))
+		}
+		for _, e := range entries {
+			c.copy(filepath.Join(src, e.Name()), filepath.Join(dst, e.Name()), false)
+		}
+		if !exists && !c.preserveMode {
+			os.Chmod(dst, mode.Perm()&^c.umask)
+		}
+	case mode&os.ModeSymlink != 0:
+		target, err := os.Readlink(src)
+		if err != nil {
+			c.errorf("cannot read symbolic link '%s': %s", src, errText(err))
+			return
+		}
+		if exists {
+			os.Remove(dst)
+		}
+		if err := os.Symlink(target, dst); err != nil {
+			c.errorf("cannot create symbolic link '%s': %s", dst, errText(err))
+			return
+		}
+	case mode.IsRegular():
+		if err := c.copyRegular(src, dst, info, exists); err != nil {
+			c.errorf("%s", err)
+			return
+		}
+	default:
+		// FIFOs, sockets and devices are recreated, not read.
+		if exists {
+			os.Remove(dst)
+		}
+		if st == nil {
+			c.errorf("cannot create special file '%s'", dst)
+			return
+		}
+		if err := syscall.Mknod(dst, st.Mode, int(st.Rdev)); err != nil {
+			c.errorf("cannot create special file '%s': %s", dst, errText(err))
+			return
+		}
+	}
+	c.preserve(src, dst, info, st)
+}
+
+// preserve copies the attributes selected by --preserve from the source
+// to dst.
+func (c *copier) preserve(src, dst string, info os.FileInfo, st *syscall.Stat_t) {
+	link := info.Mode()&os.ModeSymlink != 0
+	if st != nil && c.preserveOwner {
+		// Without privileges the group may still be settable.
+		if os.Lchown(dst, int(st.Uid), int(st.Gid)) != nil {
+			os.Lchown(dst, -1, int(st.Gid))
+		}
+	}
+	if c.preserveMode && !link {
+		if err := os.Chmod(dst, info.Mode()&(os.ModePerm|os.ModeSetuid|os.ModeSetgid|os.ModeSticky)); err != nil {
+			c.errorf("preserving permissions for '%s': %s", dst, errText(err))
+		}
+	}
+	if c.preserveXattrs && !link {
+		if err := copyXattrs(src, dst); err != nil {
+			c.errorf("preserving extended attributes for '%s': %s", dst, errText(err))
+		}
+	}
+	if st != nil && c.preserveTimes {
+		if err := setTimes(dst, st.Atim, st.Mtim, link); err != nil {
+			c.errorf("preserving times for '%s': %s", dst, errText(err))
+		}
+	}
+}
+
+// copyXattrs copies the extended attributes of src to dst.
+func copyXattrs(src, dst string) error {
+	size, err := syscall.Listxattr(src, nil)
+	if err != nil || size == 0 {
+		if err == syscall.ENOTSUP {
+			err = nil
+		}
+		return err
+	}
+	names := make([]byte, size)
+	size, err = syscall.Listxattr(src, names)
+	if err != nil {
+		return err
+	}
+	for _, name := range strings.Split(string(names[:size]), "\x00") {
+		if name == "" {
+			continue
+		}
+		n, err := syscall.Getxattr(src, name, nil)
+		if err != nil {
+			return err
+		}
+		val := make([]byte, n)
+		if n, err = syscall.Getxattr(src, name, val); err != nil {
+			return err
+		}
+		if err := syscall.Setxattr(dst, name, val[:n], 0); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// setTimes sets the access and modification times of path, of the link
+// itself if nofollow is set.
+func setTimes(path string, atime, mtime syscall.Timespec, nofollow bool) error {
+	p, err := syscall.BytePtrFromString(path)
+	if err != nil {
+		return err
+	}
+	atFDCWD := -100
+	flags := 0
+	if nofollow {
+		flags = 0x100 // AT_SYMLINK_NOFOLLOW
+	}
+	ts := [2]syscall.Timespec{atime, mtime}
+	_, _, errno := syscall.Syscall6(syscall.SYS_UTIMENSAT, uintptr(atFDCWD), uintptr(unsafe.Pointer(p)),
+		uintptr(unsafe.Pointer(&ts[0])), uintptr(flags), 0, 0)
+	if errno != 0 {
+		return errno
+	}
+	return nil
+}
+
+// copyRegular copies the contents of a regular file, cloning it when the
+// file system can share extents and keeping holes in sparse files.
+func (c *copier) copyRegular(src, dst string, info os.FileInfo, exists bool) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return fmt.Errorf("cannot open '%s' for reading: %s", src, errText(err))
+	}
+	defer in.Close()
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, info.Mode().Perm())
+	if err != nil && exists && c.force {
+		os.Remove(dst)
+		out, err = os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, info.Mode().Perm())
+	}
+	if err != nil {
+		return fmt.Errorf("cannot create regular file '%s': %s", dst, errText(err))
+	}
+	if c.reflink != "never" {
+		// Share the extents of the source if the file system can.
+		_, _, errno := syscall.Syscall(syscall.SYS_IOCTL, out.Fd(), ficlone, in.Fd())
+		if errno == 0 {
+			return out.Close()
+		}
+		if c.reflink == "always" {
+			out.Close()
+			return fmt.Errorf("failed to clone '%s' from '%s': %s", dst, src, errText(errno))
+		}
+	}
+	err = c.copyData(in, out, info)
+	if cerr := out.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return fmt.Errorf("error copying '%s' to '%s': %s", src, dst, errText(err))
+	}
+	return nil
+}
+
+const (
+	ficlone  = 0x40049409 // FICLONE ioctl
+	seekData = 3          // SEEK_DATA
+	seekHole = 4          // SEEK_HOLE
+)
+
+// copyFileRangeTrap is the copy_file_range(2) system call number, which
+// package syscall does not define; 0 if unknown.
+var copyFileRangeTrap = map[string]uintptr{
+	"386": 377, "amd64": 326, "arm": 391, "arm64": 285, "loong64": 285,
+	"mips64": 5320, "mips64le": 5320, "ppc64": 379, "ppc64le": 379,
+	"riscv64": 285, "s390x": 375,
+}[runtime.GOARCH]
+
+func (c *copier) copyData(in, out *os.File, info os.FileInfo) error {
+	size := info.Size()
+	_, st := statID(info)
+	holes := st != nil && st.Blocks*512 < size
+	switch {
+	case c.sparse == "always":
+		return copyZeroesAsHoles(in, out, size)
+	case c.sparse == "auto" && holes:
+		if err := copyExtents(in, out, size); !errors.Is(err, syscall.EINVAL) {
+			return err
+		}
+	}
+	return copyRange(in, out, 0, -1)
+}
+
+// copyExtents copies only the data regions of in, found with SEEK_DATA
+// and SEEK_HOLE, so the holes stay holes. It fails with EINVAL when the
+// file system cannot report them.
+func copyExtents(in, out *os.File, size int64) error {
+	for off := int64(0); off < size; {
+		data, err := in.Seek(off, seekData)
+		if errors.Is(err, syscall.ENXIO) {
+			break // only a hole remains
+		}
+		if err != nil {
+			return syscall.EINVAL
+		}
+		hole, err := in.Seek(data, seekHole)
+		if err != nil {
+			return syscall.EINVAL
+		}
+		if err := copyRange(in, out, data, hole-data); err != nil {
+			return err
+		}
+		off = hole
+	}
+	return out.Truncate(size)
+}
+
+// copyZeroesAsHoles copies in to out, seeking over blocks of zeros
+// instead of writing them (--sparse=always).
+func copyZeroesAsHoles(in, out *os.File, size int64) error {
+	const block = 4096
+	buf := make([]byte, 32*block)
+	var off int64
+	for {
+		n, err := in.Read(buf)
+		for i := 0; i < n; i += block {
+			b := buf[i:min(i+block, n)]
+			if !isZeroBlock(b) {
+				if _, err := out.WriteAt(b, off+int64(i)); err != nil {
+					return err
+				}
+			}
+		}
+		off += int64(n)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return out.Truncate(max(off, size))
+}
+
+func isZeroBlock(b []byte) bool {
+	for _, c := range b {
+		if c != 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// copyRange copies n bytes at off from in to out at the same offset, or
+// everything from off when n < 0. It uses copy_file_range(2) where the
+// kernel supports it and reads and writes otherwise.
+func copyRange(in, out *os.File, off, n int64) error {
+	if copyFileRangeTrap != 0 {
+		inOff, outOff := off, off
+		for n != 0 {
+			chunk := int64(1 << 30)
+			if n > 0 && n < chunk {
+				chunk = n
+			}
+			r, _, errno := syscall.Syscall6(copyFileRangeTrap, in.Fd(), uintptr(unsafe.Pointer(&inOff)),
+				out.Fd(), uintptr(unsafe.Pointer(&outOff)), uintptr(chunk), 0)
+			if errno != 0 {
+				switch errno {
+				case syscall.ENOSYS, syscall.EXDEV, syscall.EINVAL, syscall.EOPNOTSUPP, syscall.EPERM:
+					// Finish the copy the slow way.
+					return copyRangeSlow(in, out, inOff, n)
+				}
+				return errno
+			}
+			if r == 0 {
+				return nil
+			}
+			if n > 0 {
+				n -= int64(r)
+			}
+		}
+		return nil
+	}
+	return copyRangeSlow(in, out, off, n)
+}
+
+func copyRangeSlow(in, out *os.File, off, n int64) error {
+	var r io.Reader = io.NewSectionReader(in, off, 1<<62)
+	if n >= 0 {
+		r = io.NewSectionReader(in, off, n)
+	}
+	buf := make([]byte, 128*1024)
+	for {
+		m, err := r.Read(buf)
+		if m > 0 {
+			if _, werr := out.WriteAt(buf[:m], off); werr != nil {
+				return werr
+			}
+			off += int64(m)
+		}
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
@@ -0,0 +1,704 @@
+package datetime
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Parse reads a date as GNU date -d does, relative to now and in its
+// zone. The string is a sequence of items in any order:
+//
+//	calendar dates   2024-01-15, 1/15/2024, 15 Jan 2024, Jan 15, 20240115
+//	times of day     14:30, 2:30:05.5pm, 9am, 10:00+05:30, 1430
+//	time zones       UTC, Z, EST, UTC+3, +0530 after a time
+//	days of the week friday, next fri, last monday, third sunday
+//	relative items   3 days, -2 weeks, 1 hour ago, next month, tomorrow
+//
+// "@SECONDS" is a time since the epoch and a leading TZ="ZONE" sets the
+// zone the rest is read in. A date with no time of day is at midnight;
+// with only relative items the time is kept. ISO 8601 and RFC 2822
+// dates, and the default output of date, are covered by these items.
+func Parse(s string, now time.Time) (time.Time, error) {
+	loc := now.Location()
+	rest := strings.TrimSpace(s)
+	if strings.HasPrefix(rest, `TZ="`) {
+		end := strings.IndexByte(rest[4:], '"')
+		if end < 0 {
+			return time.Time{}, invalid(s)
+		}
+		zone, err := LoadLocation(rest[4 : 4+end])
+		if err != nil {
+			return time.Time{}, invalid(s)
+		}
+		t, err := Parse(rest[5+end:], now.In(zone))
+		if err != nil {
+			return time.Time{}, invalid(s)
+		}
+		return t.In(loc), nil
+	}
+	if strings.HasPrefix(rest, "@") {
+		t, ok := parseEpoch(strings.TrimSpace(rest[1:]))
+		if !ok {
+			return time.Time{}, invalid(s)
+		}
+		return t.In(loc), nil
+	}
+
+	toks, ok := lex(rest)
+	if !ok {
+		return time.Time{}, invalid(s)
+	}
+	p := &parser{toks: toks, now: now, loc: loc}
+	for p.i < len(p.toks) {
+		if !p.item() {
+			return time.Time{}, invalid(s)
+		}
+	}
+	t, ok := p.build()
+	if !ok {
+		return time.Time{}, invalid(s)
+	}
+	return t, nil
+}
+
+func invalid(s string) error {
+	return fmt.Errorf("invalid date '%s'", s)
+}
+
+// parseEpoch reads [+-]SECONDS[.FRACTION].
+func parseEpoch(s string) (time.Time, bool) {
+	toks, ok := lex(s)
+	if !ok || len(toks) != 1 || toks[0].kind != 'n' {
+		return time.Time{}, false
+	}
+	t := toks[0]
+	sec, nsec := t.val, t.frac
+	if t.neg && nsec > 0 {
+		sec, nsec = sec-1, 1e9-nsec
+	}
+	return time.Unix(sec, nsec), true
+}
+
+// token is a number, a word or a punctuation character.
+type token struct {
+	kind   byte // 'n' for numbers, 'w' for words, else the character
+	word   string
+	val    int64
+	digits int
+	signed bool // written with + or -
+	neg    bool
+	frac   int64 // nanoseconds after a decimal point
+	isFrac bool
+}
+
+func isDigit(c byte) bool { return c >= '0' && c <= '9' }
+
+func isSpace(c byte) bool { return c == ' ' || c >= '\t' && c <= '\r' }
+
+// lex splits s into tokens. Commas only separate items and parenthesised
+// text is a comment. A sign applies to the number after it, even across
+// spaces; a sign with no number is ignored.
+func lex(s string) ([]token, bool) {
+	var toks []token
+	for i := 0; i < len(s); {
+		c := s[i]
+		switch {
+		case isSpace(c) || c == ',':
+			i++
+		case c == '(':
+			depth := 0
+			for ; i < len(s); i++ {
+				if s[i] == '(' {
+					depth++
+				} else if s[i] == ')' {
+					depth--
+					if depth == 0 {
+						break
+					}
+				}
+			}
+			if depth > 0 {
+				return nil, false
+			}
+			i++
+		case isDigit(c) || c == '+' || c == '-':
+			var t token
+			if c == '+' || c == '-' {
+				t.signed, t.neg = true, c == '-'
+				for i++; i < len(s) && isSpace(s[i]); i++ {
+				}
+				if i == len(s) || !isDigit(s[i]) {
+					continue
+				}
+			}
+			j := i
+			for j < len(s) && isDigit(s[j]) {
+				j++
+			}
+			v, err := strconv.ParseInt(s[i:j], 10, 64)
+			if err != nil {
+				return nil, false
+			}
+			t.kind, t.val, t.digits = 'n', v, j-i
+			if j+1 < len(s) && (s[j] == '.' || s[j] == ',') && isDigit(s[j+1]) {
+				k := j + 1
+				for k < len(s) && isDigit(s[k]) {
+					k++
+				}
+				frac := (s[j+1:k] + "000000000")[:9]
+				t.frac, _ = strconv.ParseInt(frac, 10, 64)
+				t.isFrac = true
+				j = k
+			}
+			if t.neg {
+				t.val = -t.val
+			}
+			toks = append(toks, t)
+			i = j
+		case c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z':
+			j := i
+			for j < len(s) && (s[j] >= 'a' && s[j] <= 'z' || s[j] >= 'A' && s[j] <= 'Z' || s[j] == '.') {
+				j++
+			}
+			w := strings.ToLower(strings.ReplaceAll(s[i:j], ".", ""))
+			toks = append(toks, token{kind: 'w', word: w})
+			i = j
+		default:
+			toks = append(toks, token{kind: c})
+			i++
+		}
+	}
+	return toks, true
+}
+
+var (
+	monthWords = map[string]int{
+		"january": 1, "february": 2, "march": 3, "april": 4, "may": 5, "june": 6, "july": 7,
+		"august": 8, "september": 9, "sept": 9, "october": 10, "november": 11, "december": 12,
+	}
+	dayWords = map[string]int{
+		"sunday": 0, "monday": 1, "tuesday": 2, "tues": 2, "wednesday": 3, "wednes": 3,
+		"thursday": 4, "thur": 4, "thurs": 4, "friday": 5, "saturday": 6,
+	}
+	ordinalWords = map[string]int{
+		"last": -1, "this": 0, "next": 1, "first": 1, "third": 3, "fourth": 4, "fifth": 5,
+		"sixth": 6, "seventh": 7, "eighth": 8, "ninth": 9, "tenth": 10, "eleventh": 11, "twelfth": 12,
+	}
+	// zoneWords gives the UTC offset of zone abbreviations in hours.
+	zoneWords = map[string]float64{
+		"gmt": 0, "ut": 0, "utc": 0, "z": 0, "wet": 0, "west": 1, "bst": 1,
+		"art": -3, "brt": -3, "brst": -2, "nst": -3.5, "ndt": -2.5, "ast": -4, "adt": -3,
+		"est": -5, "edt": -4, "cst": -6, "cdt": -5, "mst": -7, "mdt": -6, "pst": -8, "pdt": -7,
+		"akst": -9, "akdt": -8, "hst": -10, "hast": -10, "hadt": -9, "sst": -11,
+		"wat": 1, "cet": 1, "cest": 2, "met": 1, "mez": 1, "mest": 2, "mesz": 2,
+		"eet": 2, "eest": 3, "cat": 2, "sast": 2, "eat": 3, "msk": 3, "msd": 4,
+		"ist": 5.5, "sgt": 8, "kst": 9, "jst": 9, "gst": 10, "nzst": 12, "nzdt": 13,
+		// Military zones.
+		"a": 1, "b": 2, "c": 3, "d": 4, "e": 5, "f": 6, "g": 7, "h": 8, "i": 9, "k": 10, "l": 11, "m": 12,
+		"n": -1, "o": -2, "p": -3, "q": -4, "r": -5, "s": -6, "t": -7, "u": -8, "v": -9, "w": -10, "x": -11, "y": -12,
+	}
+)
+
+// lookupName finds w in a table of names, which also match by their
+// first three letters.
+func lookupName(table map[string]int, w string) (int, bool) {
+	if n, ok := table[w]; ok {
+		return n, true
+	}
+	if len(w) == 3 {
+		for name, n := range table {
+			if strings.HasPrefix(name, w) {
+				return n, true
+			}
+		}
+	}
+	return 0, false
+}
+
+// unitWord returns the relative unit a word names, in days for the
+// calendar units and in seconds for the others, with plural forms.
+func unitWord(w string) (unit string, n int64, ok bool) {
+	switch strings.TrimSuffix(w, "s") {
+	case "year":
+		return "year", 1, true
+	case "month":
+		return "month", 1, true
+	case "fortnight":
+		return "day", 14, true
+	case "week":
+		return "day", 7, true
+	case "day":
+		return "day", 1, true
+	case "hour":
+		return "second", 3600, true
+	case "minute", "min":
+		return "second", 60, true
+	case "second", "sec":
+		return "second", 1, true
+	}
+	return "", 0, false
+}
+
+type parser struct {
+	toks []token
+	i    int
+	now  time.Time
+	loc  *time.Location
+
+	year, month, day     int
+	yearDigits           int // 0 when no year was given
+	hour, minute, second int
+	nsec                 int
+	meridian             byte // 'a' or 'p', or 0 for a 24-hour clock
+	zone                 int  // seconds east of UTC
+	dayOrdinal, weekday  int
+	relYear, relMonth    int
+	relDay               int
+	relSeconds           int64
+	localDST             int // 1 after the local standard zone's name, 2 after its daylight one
+
+	dates, times, days, zones, rels int
+}
+
+func (p *parser) peek(k int) token {
+	if p.i+k < len(p.toks) {
+		return p.toks[p.i+k]
+	}
+	return token{}
+}
+
+// unsigned reports whether token k is a whole number written without a
+// sign.
+func (p *parser) unsigned(k int) bool {
+	t := p.peek(k)
+	return t.kind == 'n' && !t.signed && !t.isFrac
+}
+
+// minus reports whether token k is a whole number written with a minus.
+func (p *parser) minus(k int) bool {
+	t := p.peek(k)
+	return t.kind == 'n' && t.neg && !t.isFrac
+}
+
+func (p *parser) punct(k int, c byte) bool { return p.peek(k).kind == c }
+
+func (p *parser) word(k int) string {
+	if t := p.peek(k); t.kind == 'w' {
+		return t.word
+	}
+	return ""
+}
+
+// item parses the next item, reporting false if it is not one.
+func (p *parser) item() bool {
+	for _, f := range []func() (bool, bool){
+		p.timeOfDay, p.slashDate, p.isoDate, p.dayMonth, p.monthDay,
+		p.dayOfWeek, p.relative, p.zoneName, p.number,
+	} {
+		if matched, ok := f(); matched {
+			return ok
+		}
+	}
+	return false
+}
+
+// Each item parser reports whether the tokens start its item and, if so,
+// whether the item is valid.
+
+// timeOfDay parses HH:MM[:SS[.FRAC]] and HH, each with an optional am or
+// pm, and the first with an optional UTC offset.
+func (p *parser) timeOfDay() (bool, bool) {
+	if p.unsigned(0) && p.meridianWord(1) != 0 {
+		p.setTime(int(p.peek(0).val), 0, 0, 0, p.meridianWord(1))
+		p.i += 2
+		return true, true
+	}
+	if !p.unsigned(0) || !p.punct(1, ':') || p.peek(2).kind != 'n' || p.peek(2).signed {
+		return false, false
+	}
+	h, m := p.peek(0), p.peek(2)
+	if m.isFrac {
+		return true, false
+	}
+	n := 3
+	var sec token
+	if p.punct(3, ':') && p.peek(4).kind == 'n' && !p.peek(4).signed {
+		sec = p.peek(4)
+		n = 5
+	}
+	merid := p.meridianWord(n)
+	if merid != 0 {
+		n++
+	}
+	p.i += n
+	p.setTime(int(h.val), int(m.val), int(sec.val), int(sec.frac), merid)
+	if t := p.peek(0); t.kind == 'n' && t.signed && !t.isFrac {
+		off, ok := p.offset()
+		if !ok {
+			return true, false
+		}
+		p.zone = off
+		p.zones++
+	}
+	return true, true
+}
+
+func (p *parser) setTime(h, m, s, ns int, merid byte) {
+	p.hour, p.minute, p.second, p.nsec, p.meridian = h, m, s, ns, merid
+	p.times++
+}
+
+func (p *parser) meridianWord(k int) byte {
+	switch p.word(k) {
+	case "am":
+		return 'a'
+	case "pm":
+		return 'p'
+	}
+	return 0
+}
+
+// offset reads a signed UTC offset: hours, hours:minutes or hhmm.
+func (p *parser) offset() (int, bool) {
+	t := p.peek(0)
+	p.i++
+	h, m := t.val, int64(0)
+	if t.neg {
+		h = -h
+	}
+	switch {
+	case t.digits <= 2 && p.punct(0, ':') && p.unsigned(1):
+		m = p.peek(1).val
+		p.i += 2
+	case t.digits <= 2:
+	case t.digits <= 4:
+		h, m = h/100, h%100
+	default:
+		return 0, false
+	}
+	if h > 24 || m > 59 {
+		return 0, false
+	}
+	off := int(h*3600 + m*60)
+	if t.neg {
+		off = -off
+	}
+	return off, true
+}
+
+// slashDate parses MM/DD, MM/DD/YY[YY] and YYYY/MM/DD.
+func (p *parser) slashDate() (bool, bool) {
+	if !p.unsigned(0) || !p.punct(1, '/') || !p.unsigned(2) {
+		return false, false
+	}
+	a, b := p.peek(0), p.peek(2)
+	p.i += 3
+	if p.punct(0, '/') && p.unsigned(1) {
+		c := p.peek(1)
+		p.i += 2
+		if a.digits >= 3 {
+			p.setDate(a, int(b.val), int(c.val))
+		} else {
+			p.setDate(c, int(a.val), int(b.val))
+		}
+		return true, true
+	}
+	p.setDate(token{}, int(a.val), int(b.val))
+	return true, true
+}
+
+// setDate records a date; year is the zero token when there is none.
+func (p *parser) setDate(year token, month, day int) {
+	p.year, p.yearDigits = int(year.val), year.digits
+	if year.neg {
+		p.year = -p.year
+	}
+	p.month, p.day = month, day
+	p.dates++
+}
+
+// isoDate parses YYYY-MM-DD, and a T that follows it before a time.
+func (p *parser) isoDate() (bool, bool) {
+	if !p.unsigned(0) || !p.minus(1) || !p.minus(2) {
+		return false, false
+	}
+	p.setDate(p.peek(0), int(-p.peek(1).val), int(-p.peek(2).val))
+	p.i += 3
+	if p.word(0) == "t" && p.unsigned(1) {
+		p.i++
+	}
+	return true, true
+}
+
+// yearAfter reports whether token k is a year after a month and day:
+// a number that does not start a time.
+func (p *parser) yearAfter(k int) bool {
+	return p.unsigned(k) && !p.punct(k+1, ':') && p.meridianWord(k+1) == 0
+}
+
+// dayMonth parses DD MONTH [YYYY] and DD-MON-YYYY.
+func (p *parser) dayMonth() (bool, bool) {
+	if !p.unsigned(0) {
+		return false, false
+	}
+	month, ok := lookupName(monthWords, p.word(1))
+	if !ok {
+		return false, false
+	}
+	day := int(p.peek(0).val)
+	p.i += 2
+	switch {
+	case p.minus(0):
+		p.setDate(p.peek(0), month, day)
+		p.i++
+	case p.yearAfter(0):
+		p.setDate(p.peek(0), month, day)
+		p.i++
+	default:
+		p.setDate(token{}, month, day)
+	}
+	return true, true
+}
+
+// monthDay parses MONTH DD [YYYY] and MON-DD-YYYY.
+func (p *parser) monthDay() (bool, bool) {
+	month, ok := lookupName(monthWords, p.word(0))
+	if !ok {
+		return false, false
+	}
+	switch {
+	case p.minus(1) && p.minus(2):
+		p.setDate(p.peek(2), month, int(-p.peek(1).val))
+		p.i += 3
+	case p.unsigned(1) && p.yearAfter(2):
+		p.setDate(p.peek(2), month, int(p.peek(1).val))
+		p.i += 3
+	case p.unsigned(1):
+		p.setDate(token{}, month, int(p.peek(1).val))
+		p.i += 2
+	default:
+		return true, false
+	}
+	return true, true
+}
+
+// dayOfWeek parses a day name, optionally after an ordinal such as next
+// or last or a number.
+func (p *parser) dayOfWeek() (bool, bool) {
+	ord, n := 0, 0
+	if o, ok := ordinalWords[p.word(0)]; ok {
+		ord, n = o, 1
+	} else if t := p.peek(0); t.kind == 'n' && !t.isFrac {
+		ord, n = int(t.val), 1
+	}
+	day, ok := lookupName(dayWords, p.word(n))
+	if !ok {
+		return false, false
+	}
+	p.dayOrdinal, p.weekday = ord, day
+	p.days++
+	p.i += n + 1
+	return true, true
+}
+
+// relative parses [N|ORDINAL] UNIT [ago], and tomorrow, yesterday,
+// today and now. Ago applies to the item before it only.
+func (p *parser) relative() (bool, bool) {
+	switch p.word(0) {
+	case "tomorrow", "yesterday", "today", "now":
+		p.relDay += map[string]int{"tomorrow": 1, "yesterday": -1}[p.word(0)]
+		p.rels++
+		p.i++
+		return true, true
+	}
+	count, n := int64(1), 0
+	if o, ok := ordinalWords[p.word(0)]; ok {
+		count, n = int64(o), 1
+	} else if t := p.peek(0); t.kind == 'n' {
+		if t.isFrac {
+			return false, false
+		}
+		count, n = t.val, 1
+	}
+	unit, size, ok := unitWord(p.word(n))
+	if !ok {
+		return false, false
+	}
+	p.i += n + 1
+	if p.word(0) == "ago" {
+		count = -count
+		p.i++
+	}
+	switch unit {
+	case "year":
+		p.relYear += int(count)
+	case "month":
+		p.relMonth += int(count)
+	case "day":
+		p.relDay += int(count * size)
+	default:
+		p.relSeconds += count * size
+	}
+	p.rels++
+	return true, true
+}
+
+// zoneName parses a zone abbreviation, with an optional offset from it
+// as in UTC+3, or a T between a date and a time.
+func (p *parser) zoneName() (bool, bool) {
+	w := p.word(0)
+	if w == "t" && p.unsigned(1) {
+		p.i++
+		return true, true
+	}
+	hours, known := zoneWords[w]
+	if next := p.peek(1); next.kind == 'n' && next.signed {
+		// An offset from a zone, as in UTC+3, is never local.
+	} else if dst, off, ok := p.localZone(w); ok {
+		p.localDST = 1 + btoi(dst)
+		p.zone = off
+		p.zones++
+		p.i++
+		return true, true
+	}
+	if !known {
+		return false, false
+	}
+	p.i++
+	p.zone = int(hours * 3600)
+	if t := p.peek(0); t.kind == 'n' && t.signed && !t.isFrac {
+		off, ok := p.offset()
+		if !ok {
+			return true, false
+		}
+		p.zone += off
+	}
+	if p.word(0) == "dst" {
+		p.zone += 3600
+		p.i++
+	}
+	p.zones++
+	return true, true
+}
+
+// localZone reports whether w names the local zone at some time of
+// year, and if so whether it is the daylight saving one and its offset.
+// The time must then fall when that name is in use.
+func (p *parser) localZone(w string) (dst bool, off int, ok bool) {
+	for _, month := range []time.Month{time.January, time.July} {
+		t := time.Date(p.now.Year(), month, 1, 0, 0, 0, 0, p.loc)
+		name, off := t.Zone()
+		if w != "" && strings.ToLower(name) == w && isAlpha(rune(name[0])) {
+			return t.IsDST(), off, true
+		}
+	}
+	return false, 0, false
+}
+
+// number parses a number on its own: a year after a date, a date as
+// YYYYMMDD, or a time as HH or HHMM.
+func (p *parser) number() (bool, bool) {
+	if !p.unsigned(0) {
+		return false, false
+	}
+	t := p.peek(0)
+	p.i++
+	switch {
+	case p.dates > 0 && p.yearDigits == 0 && p.rels == 0 && (p.times > 0 || t.digits > 2):
+		p.year, p.yearDigits = int(t.val), t.digits
+	case t.digits > 4:
+		p.setDate(token{kind: 'n', val: t.val / 10000, digits: t.digits - 4}, int(t.val/100%100), int(t.val%100))
+	case t.digits <= 2:
+		p.setTime(int(t.val), 0, 0, 0, 0)
+	default:
+		p.setTime(int(t.val/100), int(t.val%100), 0, 0, 0)
+	}
+	return true, true
+}
+
+// build works out the time the items describe: the date and time given,
+// or today and now, moved to the day of the week, then by the relative
+// items.
+func (p *parser) build() (time.Time, bool) {
+	if p.dates > 1 || p.times > 1 || p.days > 1 || p.zones > 1 {
+		return time.Time{}, false
+	}
+	loc := p.loc
+	if p.zones > 0 {
+		loc = time.FixedZone("", p.zone)
+	}
+	now := p.now.In(p.loc)
+	year, month, day := now.Date()
+	hour, min, sec, nsec := now.Hour(), now.Minute(), now.Second(), now.Nanosecond()
+
+	if p.dates > 0 {
+		if p.yearDigits > 0 {
+			year = p.year
+			if p.yearDigits == 2 {
+				if year < 69 {
+					year += 2000
+				} else {
+					year += 1900
+				}
+			}
+		}
+		month, day = time.Month(p.month), p.day
+		if month < 1 || month > 12 || day < 1 || day > time.Date(year, month+1, 0, 0, 0, 0, 0, time.UTC).Day() {
+			return time.Time{}, false
+		}
+	}
+	switch {
+	case p.times > 0:
+		hour, min, sec, nsec = p.hour, p.minute, p.second, p.nsec
+		if p.meridian != 0 {
+			if hour < 1 || hour > 12 {
+				return time.Time{}, false
+			}
+			hour %= 12
+			if p.meridian == 'p' {
+				hour += 12
+			}
+		}
+		if hour > 23 || min > 59 || sec > 59 {
+			return time.Time{}, false
+		}
+	case p.rels == 0 || p.dates > 0 || p.days > 0:
+		hour, min, sec, nsec = 0, 0, 0, 0
+	}
+
+	t := time.Date(year, month, day, hour, min, sec, nsec, loc)
+	// time.Date moves a clock time the zone skips, such as 02:30 on the
+	// day daylight saving starts; GNU date rejects it as mktime cannot
+	// give it back.
+	if p.times > 0 && (t.Hour() != hour || t.Minute() != min) {
+		return time.Time{}, false
+	}
+	if p.days > 0 && p.dates == 0 {
+		wd := int(t.Weekday())
+		shift := (p.weekday - wd + 7) % 7
+		ord := p.dayOrdinal
+		if ord > 0 && wd != p.weekday {
+			ord--
+		}
+		t = time.Date(year, month, day+shift+7*ord, hour, min, sec, nsec, loc)
+	}
+	if p.localDST != 0 && t.In(p.loc).IsDST() != (p.localDST == 2) {
+		return time.Time{}, false
+	}
+	if p.relYear != 0 || p.relMonth != 0 || p.relDay != 0 {
+		y, m, d := t.Date()
+		moved := time.Date(y+p.relYear, m+time.Month(p.relMonth), d+p.relDay, hour, min, sec, nsec, loc)
+		// With nothing but relative items the clock time is read with
+		// the UTC offset in force now, as mktime does when told whether
+		// daylight saving applies: across a change it moves an hour.
+		if p.dates+p.days+p.times+p.zones == 0 {
+			_, off := t.Zone()
+			moved = time.Date(moved.Year(), moved.Month(), moved.Day(), hour, min, sec, nsec, time.FixedZone("", off))
+		}
+		t = moved
+	}
+	t = t.Add(time.Duration(p.relSeconds) * time.Second)
+	return t.In(p.loc), true
+}
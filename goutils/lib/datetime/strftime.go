@@ -0,0 +1,270 @@
+package datetime
+
+import (
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	weekdayNames = []string{"Sunday", "Monday", "Tuesday", "Wednesday", "Thursday", "Friday", "Saturday"}
+	monthNames   = []string{"January", "February", "March", "April", "May", "June",
+		"July", "August", "September", "October", "November", "December"}
+)
+
+// Strftime formats t as GNU strftime does in the C locale. A conversion
+// may carry the GNU flags - (no padding), _ (pad with spaces), 0 (pad
+// with zeros), ^ (upper case) and # (swap case), a field width, and the
+// ignored E and O modifiers; %N takes its width as the number of digits.
+// Unknown conversions are copied through.
+func Strftime(format string, t time.Time) string {
+	var b strings.Builder
+	for i := 0; i < len(format); i++ {
+		if format[i] != '%' || i+1 == len(format) {
+			b.WriteByte(format[i])
+			continue
+		}
+		start := i
+		i++
+		var pad byte
+		upper, swap := false, false
+		for ; i < len(format); i++ {
+			switch c := format[i]; c {
+			case '-', '_', '0':
+				pad = c
+				continue
+			case '^':
+				upper = true
+				continue
+			case '#':
+				swap = true
+				continue
+			}
+			break
+		}
+		width := -1
+		for i < len(format) && format[i] >= '0' && format[i] <= '9' {
+			width = max(width, 0)*10 + int(format[i]-'0')
+			i++
+		}
+		colons := 0
+		for i < len(format) && format[i] == ':' {
+			colons++
+			i++
+		}
+		for i < len(format) && (format[i] == 'E' || format[i] == 'O') {
+			i++
+		}
+		if i == len(format) {
+			b.WriteString(format[start:])
+			break
+		}
+
+		// num formats a number, zero-padded to digits by default.
+		num := func(n, digits int, defPad byte) string {
+			p := defPad
+			if pad != 0 {
+				p = pad
+			}
+			if width >= 0 {
+				digits = width
+			}
+			s := strconv.Itoa(n)
+			neg := n < 0
+			if neg {
+				s = s[1:]
+			}
+			if p != '-' && len(s) < digits {
+				fill := "0"
+				if p == '_' {
+					fill = " "
+				}
+				s = strings.Repeat(fill, digits-len(s)-btoi(neg)) + s
+			}
+			if neg {
+				s = "-" + s
+			}
+			return s
+		}
+		// str formats text, which swap turns to upper case unless lower
+		// is set, when it turns it to lower case.
+		str := func(s string, lower bool) string {
+			switch {
+			case swap && lower:
+				s = strings.ToLower(s)
+			case swap, upper:
+				s = strings.ToUpper(s)
+			}
+			if width > len(s) && pad != '-' {
+				fill := " "
+				if pad == '0' {
+					fill = "0"
+				}
+				s = strings.Repeat(fill, width-len(s)) + s
+			}
+			return s
+		}
+		// sub formats a composite conversion, such as %D.
+		sub := func(f string) string {
+			return str(Strftime(f, t), false)
+		}
+
+		hour12 := t.Hour() % 12
+		if hour12 == 0 {
+			hour12 = 12
+		}
+		isoYear, isoWeek := t.ISOWeek()
+		switch c := format[i]; c {
+		case 'a':
+			b.WriteString(str(weekdayNames[t.Weekday()][:3], false))
+		case 'A':
+			b.WriteString(str(weekdayNames[t.Weekday()], false))
+		case 'b', 'h':
+			b.WriteString(str(monthNames[t.Month()-1][:3], false))
+		case 'B':
+			b.WriteString(str(monthNames[t.Month()-1], false))
+		case 'c':
+			b.WriteString(sub("%a %b %e %H:%M:%S %Y"))
+		case 'C':
+			b.WriteString(num(floorDiv(t.Year(), 100), 2, '0'))
+		case 'd':
+			b.WriteString(num(t.Day(), 2, '0'))
+		case 'D', 'x':
+			b.WriteString(sub("%m/%d/%y"))
+		case 'e':
+			b.WriteString(num(t.Day(), 2, '_'))
+		case 'F':
+			b.WriteString(sub("%Y-%m-%d"))
+		case 'g':
+			b.WriteString(num((isoYear%100+100)%100, 2, '0'))
+		case 'G':
+			b.WriteString(num(isoYear, 4, '0'))
+		case 'H':
+			b.WriteString(num(t.Hour(), 2, '0'))
+		case 'I':
+			b.WriteString(num(hour12, 2, '0'))
+		case 'j':
+			b.WriteString(num(t.YearDay(), 3, '0'))
+		case 'k':
+			b.WriteString(num(t.Hour(), 2, '_'))
+		case 'l':
+			b.WriteString(num(hour12, 2, '_'))
+		case 'm':
+			b.WriteString(num(int(t.Month()), 2, '0'))
+		case 'M':
+			b.WriteString(num(t.Minute(), 2, '0'))
+		case 'n':
+			b.WriteByte('\n')
+		case 'N':
+			digits := 9
+			if width > 0 {
+				digits = width
+			}
+			ns := strconv.Itoa(t.Nanosecond())
+			ns = strings.Repeat("0", 9-len(ns)) + ns
+			if digits <= 9 {
+				ns = ns[:digits]
+			} else {
+				ns += strings.Repeat("0", digits-9)
+			}
+			b.WriteString(ns)
+		case 'p', 'P':
+			ampm := "AM"
+			if t.Hour() >= 12 {
+				ampm = "PM"
+			}
+			if c == 'P' {
+				ampm = strings.ToLower(ampm)
+			}
+			b.WriteString(str(ampm, c == 'p'))
+		case 'q':
+			b.WriteString(num((int(t.Month())+2)/3, 1, '0'))
+		case 'r':
+			b.WriteString(sub("%I:%M:%S %p"))
+		case 'R':
+			b.WriteString(sub("%H:%M"))
+		case 's':
+			b.WriteString(num(int(t.Unix()), 1, '0'))
+		case 'S':
+			b.WriteString(num(t.Second(), 2, '0'))
+		case 't':
+			b.WriteByte('\t')
+		case 'T', 'X':
+			b.WriteString(sub("%H:%M:%S"))
+		case 'u':
+			b.WriteString(num((int(t.Weekday())+6)%7+1, 1, '0'))
+		case 'U':
+			b.WriteString(num((t.YearDay()+6-int(t.Weekday()))/7, 2, '0'))
+		case 'V':
+			b.WriteString(num(isoWeek, 2, '0'))
+		case 'w':
+			b.WriteString(num(int(t.Weekday()), 1, '0'))
+		case 'W':
+			b.WriteString(num((t.YearDay()+6-(int(t.Weekday())+6)%7)/7, 2, '0'))
+		case 'y':
+			b.WriteString(num((t.Year()%100+100)%100, 2, '0'))
+		case 'Y':
+			b.WriteString(num(t.Year(), 4, '0'))
+		case 'z':
+			b.WriteString(numericZone(t, colons))
+		case 'Z':
+			name, _ := t.Zone()
+			b.WriteString(str(name, true))
+		case '%':
+			b.WriteString(str("%", false))
+		default:
+			b.WriteString(format[start : i+1])
+		}
+	}
+	return b.String()
+}
+
+// numericZone formats the UTC offset of t as %z (+hhmm), %:z (+hh:mm),
+// %::z (+hh:mm:ss) or %:::z (+hh, with :mm or :mm:ss only as needed).
+func numericZone(t time.Time, colons int) string {
+	_, off := t.Zone()
+	sign := byte('+')
+	if off < 0 {
+		sign, off = '-', -off
+	}
+	h, m, s := off/3600, off/60%60, off%60
+	two := func(n int) string { return string([]byte{byte('0' + n/10), byte('0' + n%10)}) }
+	b := []byte{sign}
+	b = append(b, two(h)...)
+	switch colons {
+	case 0:
+		b = append(b, two(m)...)
+	case 1:
+		b = append(b, ':')
+		b = append(b, two(m)...)
+	case 2:
+		b = append(b, ':')
+		b = append(b, two(m)...)
+		b = append(b, ':')
+		b = append(b, two(s)...)
+	default:
+		if m != 0 || s != 0 {
+			b = append(b, ':')
+			b = append(b, two(m)...)
+		}
+		if s != 0 {
+			b = append(b, ':')
+			b = append(b, two(s)...)
+		}
+	}
+	return string(b)
+}
+
+func floorDiv(a, b int) int {
+	if a < 0 && a%b != 0 {
+		return a/b - 1
+	}
+	return a / b
+}
+
+func btoi(b bool) int {
+	if b {
+		return 1
+	}
+	return 0
+}
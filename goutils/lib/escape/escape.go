@@ -0,0 +1,85 @@
+// Package escape expands the backslash escapes of printf formats and
+// echo -e arguments, for the coreutils that take them.
+package escape
+
+// Expand expands the backslash escape at the start of s as printf
+// does in its format and echo -e and printf %b do in arguments: \\ \a \b
+// \c \e \f \n \r \t \v, \NNN octal, \xHH, \uHHHH and \UHHHHHHHH. With
+// zeroOctal, as in arguments, \0 is followed by up to three more octal
+// digits. Unknown escapes and ones missing their digits are kept as they
+// are. It returns the expansion, the length of the escape and whether it
+// was \c, which ends all output.
+func Expand(s string, zeroOctal bool) (string, int, bool) {
+	if len(s) < 2 {
+		return s, len(s), false
+	}
+	switch s[1] {
+	case '\\':
+		return "\\", 2, false
+	case 'a':
+		return "\a", 2, false
+	case 'b':
+		return "\b", 2, false
+	case 'c':
+		return "", 2, true
+	case 'e', 'E':
+		return "\x1b", 2, false
+	case 'f':
+		return "\f", 2, false
+	case 'n':
+		return "\n", 2, false
+	case 'r':
+		return "\r", 2, false
+	case 't':
+		return "\t", 2, false
+	case 'v':
+		return "\v", 2, false
+	case 'x', 'u', 'U':
+		digits := 2
+		if s[1] == 'u' {
+			digits = 4
+		} else if s[1] == 'U' {
+			digits = 8
+		}
+		n, v := 2, rune(0)
+		for ; n < len(s) && n-2 < digits; n++ {
+			d := HexDigit(s[n])
+			if d < 0 {
+				break
+			}
+			v = v<<4 | rune(d)
+		}
+		switch {
+		case n == 2:
+			return s[:2], 2, false
+		case s[1] == 'x':
+			return string([]byte{byte(v)}), n, false
+		}
+		return string(v), n, false
+	}
+	if s[1] < '0' || s[1] > '7' {
+		return s[:2], 2, false
+	}
+	n, max := 1, 3
+	if zeroOctal && s[1] == '0' {
+		n, max = 2, 3
+	}
+	v := 0
+	for start := n; n < len(s) && n-start < max && s[n] >= '0' && s[n] <= '7'; n++ {
+		v = v*8 + int(s[n]-'0')
+	}
+	return string([]byte{byte(v)}), n, false
+}
+
+// HexDigit returns the value of the hexadecimal digit c, or -1.
+func HexDigit(c byte) int {
+	switch {
+	case c >= '0' && c <= '9':
+		return int(c - '0')
+	case c >= 'a' && c <= 'f':
+		return int(c-'a') + 10
+	case c >= 'A' && c <= 'F':
+		return int(c-'A') + 10
+	}
+	return -1
+}
@@ -0,0 +1,3 @@
+module goutils/lib
+
+go 1.21
@@ -0,0 +1,314 @@
+package split
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// ContextOptions says how to split by context, as csplit does.
+type ContextOptions struct {
+	Prefix string
+	Suffix string // the file number's format, from CheckSuffix
+	Keep   bool   // keep the files made so far when splitting fails
+	Quiet  bool   // do not print the size of each file
+	Elide  bool   // make no empty files
+
+	// Compile compiles the REGEX of /REGEX/ and %REGEX% patterns, so
+	// that each command can take its own syntax.
+	Compile func(string) (Matcher, error)
+	// ErrText formats system errors in messages; by default they are
+	// given as they are.
+	ErrText func(error) string
+	// Stdout gets the file sizes and Stderr warnings; by default they
+	// are the process's own.
+	Stdout, Stderr io.Writer
+}
+
+// Matcher is a compiled regular expression.
+type Matcher interface {
+	Match(line []byte) bool
+}
+
+// pattern is one split point: a line number, or a /regex/ to split at or
+// a %regex% to skip to, with an offset, repeated repeat more times or,
+// when repeat is -1, until the input runs out.
+type pattern struct {
+	arg    string
+	line   int
+	re     Matcher
+	skip   bool
+	offset int
+	repeat int
+}
+
+// contextSplitter is the state of one Context run.
+type contextSplitter struct {
+	ContextOptions
+	lines   [][]byte
+	created []string
+}
+
+// Context cuts in into files at the points patterns give, printing the
+// size of each file. When a pattern cannot be satisfied, what is left of
+// the input is written as a last file and the files are then removed,
+// unless Keep is set. Errors are *Error values.
+func Context(in io.Reader, patterns []string, opt ContextOptions) (err error) {
+	if opt.Suffix == "" {
+		opt.Suffix = "%02d"
+	}
+	if opt.ErrText == nil {
+		opt.ErrText = func(err error) string { return err.Error() }
+	}
+	if opt.Stdout == nil {
+		opt.Stdout = os.Stdout
+	}
+	if opt.Stderr == nil {
+		opt.Stderr = os.Stderr
+	}
+	s := &contextSplitter{ContextOptions: opt}
+	// fail and cleanup unwind to here.
+	defer func() {
+		if r := recover(); r != nil {
+			e, ok := r.(*Error)
+			if !ok {
+				panic(r)
+			}
+			err = e
+		}
+	}()
+
+	pats := s.parsePatterns(patterns)
+	r := bufio.NewReaderSize(in, 64*1024)
+	for {
+		l, err := r.ReadBytes('\n')
+		if len(l) > 0 {
+			s.lines = append(s.lines, l)
+		}
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			s.fail("read error: %s", s.ErrText(err))
+		}
+	}
+	s.split(pats)
+	return nil
+}
+
+func (s *contextSplitter) fail(format string, a ...interface{}) {
+	panic(&Error{Msg: fmt.Sprintf(format, a...), Status: 1})
+}
+
+// cleanup fails after removing the files made so far, unless Keep is
+// set.
+func (s *contextSplitter) cleanup(format string, a ...interface{}) {
+	if !s.Keep {
+		for _, name := range s.created {
+			os.Remove(name)
+		}
+	}
+	s.fail(format, a...)
+}
+
+// CheckSuffix makes sure a -b format has exactly one integer conversion
+// and returns it in Go's terms.
+func CheckSuffix(f string) (string, error) {
+	var b strings.Builder
+	conversions := 0
+	for i := 0; i < len(f); i++ {
+		b.WriteByte(f[i])
+		if f[i] != '%' {
+			continue
+		}
+		if i+1 < len(f) && f[i+1] == '%' {
+			b.WriteByte('%')
+			i++
+			continue
+		}
+		j := i + 1
+		for j < len(f) && strings.IndexByte("-+ #0'", f[j]) >= 0 {
+			j++
+		}
+		for j < len(f) && (f[j] >= '0' && f[j] <= '9' || f[j] == '.') {
+			j++
+		}
+		if j == len(f) {
+			return "", fmt.Errorf("missing conversion specifier in suffix")
+		}
+		switch c := f[j]; c {
+		case 'd', 'i', 'u', 'o', 'x', 'X':
+			if c == 'i' || c == 'u' {
+				c = 'd'
+			}
+			b.WriteString(strings.ReplaceAll(f[i+1:j], "'", ""))
+			b.WriteByte(c)
+		default:
+			return "", fmt.Errorf("invalid conversion specifier in suffix: %c", c)
+		}
+		if conversions++; conversions > 1 {
+			return "", fmt.Errorf("too many %% conversion specifications in suffix")
+		}
+		i = j
+	}
+	if conversions == 0 {
+		return "", fmt.Errorf("missing %% conversion specification in suffix")
+	}
+	return b.String(), nil
+}
+
+// parsePatterns reads the split points. A {N} or {*} right after one
+// sets how often it repeats.
+func (s *contextSplitter) parsePatterns(args []string) []*pattern {
+	var pats []*pattern
+	lastLine := 0
+	for i := 0; i < len(args); i++ {
+		a := args[i]
+		var p *pattern
+		switch {
+		case a != "" && (a[0] == '/' || a[0] == '%'):
+			end := strings.LastIndexByte(a, a[0])
+			if end == 0 {
+				s.fail("%s: closing delimiter '%c' missing", a, a[0])
+			}
+			re, err := s.Compile(a[1:end])
+			if err != nil {
+				s.fail("'%s': invalid regular expression: %v", a, err)
+			}
+			p = &pattern{arg: a, re: re, skip: a[0] == '%'}
+			if off := a[end+1:]; off != "" {
+				n, err := strconv.Atoi(off)
+				if err != nil {
+					s.fail("'%s': integer expected after delimiter", a)
+				}
+				p.offset = n
+			}
+		default:
+			n, err := strconv.Atoi(a)
+			if err != nil || a == "" || strings.IndexFunc(a, func(r rune) bool { return r < '0' || r > '9' }) >= 0 {
+				s.fail("'%s': invalid pattern", a)
+			}
+			if n == 0 {
+				s.fail("%s: line number must be greater than zero", a)
+			}
+			if n < lastLine {
+				s.fail("line number '%s' is smaller than preceding line number, %d", a, lastLine)
+			}
+			if n == lastLine {
+				fmt.Fprintf(s.Stderr, "csplit: warning: line number '%s' is the same as preceding line number\n", a)
+			}
+			lastLine = n
+			p = &pattern{arg: a, line: n}
+		}
+		if i+1 < len(args) && strings.HasPrefix(args[i+1], "{") {
+			i++
+			a := args[i]
+			if !strings.HasSuffix(a, "}") {
+				s.fail("'%s': '}' is required in repeat count", a)
+			}
+			if n := a[1 : len(a)-1]; n == "*" {
+				p.repeat = -1
+			} else if r, err := strconv.Atoi(n); err == nil && n[0] >= '0' && n[0] <= '9' {
+				p.repeat = r
+			} else {
+				s.fail("'%s'}: integer required between '{' and '}'", a[:len(a)-1])
+			}
+		}
+		pats = append(pats, p)
+	}
+	return pats
+}
+
+// emit writes lines[start:end] to the next output file and prints its
+// size.
+func (s *contextSplitter) emit(start, end int) {
+	size := 0
+	for _, l := range s.lines[start:end] {
+		size += len(l)
+	}
+	if s.Elide && size == 0 {
+		return
+	}
+	name := s.Prefix + fmt.Sprintf(s.Suffix, len(s.created))
+	s.created = append(s.created, name)
+	f, err := os.Create(name)
+	if err != nil {
+		s.cleanup("%s: %s", name, s.ErrText(err))
+	}
+	w := bufio.NewWriter(f)
+	for _, l := range s.lines[start:end] {
+		w.Write(l)
+	}
+	if err := w.Flush(); err != nil {
+		s.cleanup("%s: %s", name, s.ErrText(err))
+	}
+	if err := f.Close(); err != nil {
+		s.cleanup("%s: %s", name, s.ErrText(err))
+	}
+	if !s.Quiet {
+		fmt.Fprintln(s.Stdout, size)
+	}
+}
+
+// split applies the patterns in turn. start is the first line of the
+// section being built and from the line the next regex search begins
+// at. A failed pattern writes what is left as its section before the
+// error; what a %regex% skips is never written.
+func (s *contextSplitter) split(pats []*pattern) {
+	start, from := 0, 0
+	for _, p := range pats {
+		for rep := 0; p.repeat < 0 || rep <= p.repeat; rep++ {
+			where := ""
+			if rep > 0 {
+				where = fmt.Sprintf(" on repetition %d", rep)
+			}
+			if p.re == nil {
+				brk := p.line * (rep + 1)
+				if brk > len(s.lines) {
+					s.emit(start, len(s.lines))
+					s.cleanup("'%s': line number out of range%s", p.arg, where)
+				}
+				if brk-1 <= start {
+					s.emit(start, start)
+					continue
+				}
+				s.emit(start, brk-1)
+				start, from = brk-1, brk-1
+				continue
+			}
+			match := -1
+			for i := from; i < len(s.lines); i++ {
+				if p.re.Match(bytes.TrimSuffix(s.lines[i], []byte("\n"))) {
+					match = i
+					break
+				}
+			}
+			if match < 0 {
+				if !p.skip {
+					s.emit(start, len(s.lines))
+				}
+				if p.repeat < 0 {
+					// {*} ends here, with the patterns after it unused.
+					return
+				}
+				s.cleanup("'%s': match not found%s", p.arg, where)
+			}
+			brk := match + p.offset
+			if brk < start || brk > len(s.lines) {
+				if !p.skip {
+					s.emit(start, max(start, min(brk, len(s.lines))))
+				}
+				s.cleanup("'%s': line number out of range%s", p.arg, where)
+			}
+			if !p.skip {
+				s.emit(start, brk)
+			}
+			start, from = brk, max(match, brk)+1
+		}
+	}
+	s.emit(start, len(s.lines))
+}
@@ -0,0 +1,141 @@
+package split
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+// contextInput is the file the conformance cases split.
+const contextInput = "1\nb\n2\nb\n3\n4\n"
+
+// contextConformance holds output captured from GNU csplit on
+// contextInput: the sizes printed, the files left behind and the error
+// message without its "csplit: " prefix.
+var contextConformance = []struct {
+	args         []string
+	keep, elide  bool
+	stdout, warn string
+	files        []string
+	err          string
+}{
+	// GNU coreutils 9.1, LC_ALL=C.
+	{args: []string{"%b%"}, stdout: "10\n", files: []string{"b\n2\nb\n3\n4\n"}},
+	{args: []string{"%b%", "{*}"}},
+	{args: []string{"%b%1", "{*}"}},
+	{args: []string{"%zz%", "{*}"}},
+	{args: []string{"%3%"}, stdout: "4\n", files: []string{"3\n4\n"}},
+	{args: []string{"%b%", "{1}"}, stdout: "6\n", files: []string{"b\n3\n4\n"}},
+	{args: []string{"%b%", "5"}, stdout: "6\n4\n", files: []string{"b\n2\nb\n", "3\n4\n"}},
+	{args: []string{"%b%", "/b/"}, stdout: "4\n6\n", files: []string{"b\n2\n", "b\n3\n4\n"}},
+	{args: []string{"/b/", "%b%"}, stdout: "2\n6\n", files: []string{"1\n", "b\n3\n4\n"}},
+	{args: []string{"/b/", "%4%"}, stdout: "2\n2\n", files: []string{"1\n", "4\n"}},
+	{args: []string{"2", "%b%"}, stdout: "2\n10\n", files: []string{"1\n", "b\n2\nb\n3\n4\n"}},
+	{args: []string{"/b/", "{*}"}, stdout: "2\n4\n6\n", files: []string{"1\n", "b\n2\n", "b\n3\n4\n"}},
+	{args: []string{"/b/+1", "{*}"}, stdout: "4\n4\n4\n", files: []string{"1\nb\n", "2\nb\n", "3\n4\n"}},
+	{args: []string{"/b/", "1"}, stdout: "2\n0\n10\n", files: []string{"1\n", "", "b\n2\nb\n3\n4\n"}},
+	{args: []string{"3", "3"}, stdout: "4\n0\n8\n", files: []string{"1\nb\n", "", "2\nb\n3\n4\n"},
+		warn: "csplit: warning: line number '3' is the same as preceding line number\n"},
+	{args: []string{"3", "3"}, elide: true, stdout: "4\n8\n", files: []string{"1\nb\n", "2\nb\n3\n4\n"},
+		warn: "csplit: warning: line number '3' is the same as preceding line number\n"},
+
+	{args: []string{"/zz/"}, stdout: "12\n", err: "'/zz/': match not found"},
+	{args: []string{"/zz/"}, keep: true, stdout: "12\n", files: []string{contextInput}, err: "'/zz/': match not found"},
+	{args: []string{"%zz%"}, err: "'%zz%': match not found"},
+	{args: []string{"/b/", "{5}"}, stdout: "2\n4\n6\n", err: "'/b/': match not found on repetition 2"},
+	{args: []string{"9"}, stdout: "12\n", err: "'9': line number out of range"},
+	{args: []string{"2", "{5}"}, stdout: "2\n4\n4\n2\n", err: "'2': line number out of range on repetition 3"},
+	{args: []string{"1", "{*}"}, stdout: "0\n2\n2\n2\n2\n2\n2\n", err: "'1': line number out of range on repetition 6"},
+	{args: []string{"/b/-3"}, stdout: "0\n", err: "'/b/-3': line number out of range"},
+	{args: []string{"%b%-3"}, err: "'%b%-3': line number out of range"},
+	{args: []string{"/b/x"}, err: "'/b/x': integer expected after delimiter"},
+	{args: []string{"/b/", "{3"}, err: "'{3': '}' is required in repeat count"},
+	{args: []string{"{-1}"}, err: "'{-1}': invalid pattern"},
+	{args: []string{"{2}"}, err: "'{2}': invalid pattern"},
+	{args: []string{"x"}, err: "'x': invalid pattern"},
+	{args: []string{"0"}, err: "0: line number must be greater than zero"},
+	{args: []string{"3", "2"}, err: "line number '2' is smaller than preceding line number, 3"},
+}
+
+// compileRE compiles with Go's syntax; the patterns above mean the same
+// in it as in the BREs GNU csplit takes.
+func compileRE(pat string) (Matcher, error) {
+	re, err := regexp.Compile(pat)
+	if err != nil {
+		return nil, err
+	}
+	return re, nil
+}
+
+func TestContextConformance(t *testing.T) {
+	for _, c := range contextConformance {
+		name := strings.Join(c.args, " ")
+		if c.keep {
+			name += " (keep)"
+		}
+		if c.elide {
+			name += " (elide)"
+		}
+		t.Run(name, func(t *testing.T) {
+			dir := t.TempDir()
+			var stdout, stderr bytes.Buffer
+			err := Context(strings.NewReader(contextInput), c.args, ContextOptions{
+				Prefix:  filepath.Join(dir, "xx"),
+				Keep:    c.keep,
+				Elide:   c.elide,
+				Compile: compileRE,
+				Stdout:  &stdout,
+				Stderr:  &stderr,
+			})
+			msg := ""
+			if err != nil {
+				msg = err.Error()
+			}
+			if msg != c.err {
+				t.Errorf("error %q, want %q", msg, c.err)
+			}
+			if stdout.String() != c.stdout {
+				t.Errorf("stdout %q, want %q", stdout.String(), c.stdout)
+			}
+			if stderr.String() != c.warn {
+				t.Errorf("stderr %q, want %q", stderr.String(), c.warn)
+			}
+			entries, _ := os.ReadDir(dir)
+			var files []string
+			for i, e := range entries {
+				if want := fmt.Sprintf("xx%02d", i); e.Name() != want {
+					t.Fatalf("file %s, want %s", e.Name(), want)
+				}
+				data, _ := os.ReadFile(filepath.Join(dir, e.Name()))
+				files = append(files, string(data))
+			}
+			if fmt.Sprintf("%q", files) != fmt.Sprintf("%q", c.files) {
+				t.Errorf("files %q, want %q", files, c.files)
+			}
+		})
+	}
+}
+
+func TestCheckSuffix(t *testing.T) {
+	for _, c := range []struct{ in, out, err string }{
+		{"%02d", "%02d", ""},
+		{"%03u.txt", "%03d.txt", ""},
+		{"%x%%", "%x%%", ""},
+		{"%d%d", "", "too many % conversion specifications in suffix"},
+		{"abc", "", "missing % conversion specification in suffix"},
+		{"%s", "", "invalid conversion specifier in suffix: s"},
+	} {
+		out, err := CheckSuffix(c.in)
+		msg := ""
+		if err != nil {
+			msg = err.Error()
+		}
+		if out != c.out || msg != c.err {
+			t.Errorf("CheckSuffix(%q) = %q, %q; want %q, %q", c.in, out, msg, c.out, c.err)
+		}
+	}
+}
@@ -0,0 +1,512 @@
+// Package split is the engine of split and csplit, shared by the cmd
+// tools and coreutils: it cuts its input into files of so many records
+// or bytes, or into a number of chunks, names the files from a prefix and
+// a growing suffix, and can pipe each one to a filter command instead;
+// Context cuts it at line numbers and at lines matching patterns.
+// Parsing the command line is left to the commands.
+package split
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strings"
+	"syscall"
+)
+
+// Options says how to split. Exactly one of Lines, Bytes, LineBytes and
+// Chunks.N is set.
+type Options struct {
+	Lines     int64  // records per file
+	Bytes     int64  // bytes per file, header included
+	LineBytes int64  // at most this many bytes of whole records per file, header included
+	Chunks    Chunks // -n
+
+	Prefix           string
+	AdditionalSuffix string
+	Alphabet         string // the suffix digits, lower case letters by default
+	SuffixLength     int
+	SuffixStart      int64 // the first suffix, as a number in Alphabet
+	// SuffixAuto lets suffixes grow, so that yz is followed by zaaa
+	// and, with decimal digits, 89 by 9000.
+	SuffixAuto bool
+
+	Separator  byte // ends records
+	Header     bool // repeat the first record at the top of every file
+	Elide      bool // make no empty files with Chunks
+	Filter     string
+	Unbuffered bool
+	Verbose    bool
+
+	// ErrText formats system errors in messages; by default they are
+	// given as they are.
+	ErrText func(error) string
+}
+
+// Chunks is a parsed -n argument: N chunks, split by bytes, by bytes
+// without breaking records (Kind 'l') or by dealing out records in turn
+// (Kind 'r'), and the chunk K to print instead of making files, or 0.
+type Chunks struct {
+	Kind byte
+	K, N int64
+}
+
+// Error is why splitting stopped: a message for the command to print
+// after its name, and the exit status, which for a failed filter is the
+// command's own.
+type Error struct {
+	Msg    string
+	Status int
+}
+
+func (e *Error) Error() string { return e.Msg }
+
+// SuffixDigits returns how many digits of alphabet it takes to write
+// last, and at least one.
+func SuffixDigits(alphabet string, last int64) int {
+	n := 1
+	for v := last / int64(len(alphabet)); v > 0; v /= int64(len(alphabet)) {
+		n++
+	}
+	return n
+}
+
+// splitter is the state of one run: the options, the file names handed
+// out so far and what is known of the input.
+type splitter struct {
+	Options
+	names     namer
+	header    []byte
+	inputInfo os.FileInfo
+}
+
+// Run splits in as opt says, leaving the files in the current directory
+// and chunks picked with Chunks.K on standard output. Errors are
+// *Error values.
+func Run(in *os.File, opt Options) (err error) {
+	if opt.Alphabet == "" {
+		opt.Alphabet = "abcdefghijklmnopqrstuvwxyz"
+	}
+	if opt.ErrText == nil {
+		opt.ErrText = func(err error) string { return err.Error() }
+	}
+	s := &splitter{Options: opt}
+	// fail unwinds to here.
+	defer func() {
+		if r := recover(); r != nil {
+			e, ok := r.(*Error)
+			if !ok {
+				panic(r)
+			}
+			err = e
+		}
+	}()
+
+	s.inputInfo, _ = in.Stat()
+	var offset int64
+	if s.inputInfo != nil && s.inputInfo.Mode().IsRegular() {
+		offset, _ = in.Seek(0, io.SeekCurrent)
+	}
+	r := bufio.NewReaderSize(in, 64*1024)
+	if s.Header {
+		s.header, _ = s.readRecord(r)
+		if n := max(s.Bytes, s.LineBytes); n > 0 && n <= int64(len(s.header)) {
+			s.fail("the header does not fit in %d bytes", n)
+		}
+	}
+
+	switch c := s.Chunks; {
+	case s.Bytes > 0:
+		s.splitBytes(r, s.Bytes-int64(len(s.header)))
+	case s.LineBytes > 0:
+		s.splitLineBytes(r, s.LineBytes-int64(len(s.header)))
+	case c.N > 0 && c.Kind == 'r':
+		s.splitRoundRobin(r, c)
+	case c.N > 0:
+		// Chunks need the size of what is left of the input, which for a
+		// pipe means reading it all.
+		var size int64
+		if s.inputInfo != nil && s.inputInfo.Mode().IsRegular() {
+			size = s.inputInfo.Size() - offset - int64(len(s.header))
+		} else {
+			data, err := io.ReadAll(r)
+			if err != nil {
+				s.fail("read error: %s", s.ErrText(err))
+			}
+			size = int64(len(data))
+			r = bufio.NewReader(bytes.NewReader(data))
+		}
+		if c.Kind == 'l' {
+			s.splitLineChunks(r, size, c)
+		} else {
+			s.splitChunks(r, size, c)
+		}
+	default:
+		s.splitLines(r, s.Lines)
+	}
+	return nil
+}
+
+func (s *splitter) fail(format string, a ...interface{}) {
+	panic(&Error{Msg: fmt.Sprintf(format, a...), Status: 1})
+}
+
+// namer hands out the output file names: the prefix, a suffix counting in
+// the alphabet and the additional suffix. While SuffixAuto is set, a
+// first suffix character that reaches the end of the alphabet moves into
+// the prefix and the suffix grows by one.
+type namer struct {
+	fixed   string
+	digits  []int
+	started bool
+}
+
+func (s *splitter) nextName() string {
+	n, alphabet := &s.names, s.Alphabet
+	if !n.started {
+		n.started = true
+		n.digits = make([]int, s.SuffixLength)
+		v := s.SuffixStart
+		for i := len(n.digits) - 1; i >= 0; i-- {
+			n.digits[i] = int(v % int64(len(alphabet)))
+			v /= int64(len(alphabet))
+		}
+		if v > 0 {
+			s.fail("numerical suffix start value is too large for the suffix length")
+		}
+	} else {
+		i := len(n.digits) - 1
+		for ; i >= 0; i-- {
+			if n.digits[i]++; n.digits[i] < len(alphabet) {
+				break
+			}
+			n.digits[i] = 0
+		}
+		if i < 0 {
+			s.fail("output file suffixes exhausted")
+		}
+		if s.SuffixAuto && n.digits[0] == len(alphabet)-1 {
+			n.fixed += alphabet[len(alphabet)-1:]
+			n.digits = make([]int, len(n.digits)+1)
+		}
+	}
+	var b strings.Builder
+	b.WriteString(s.Prefix + n.fixed)
+	for _, d := range n.digits {
+		b.WriteByte(alphabet[d])
+	}
+	b.WriteString(s.AdditionalSuffix)
+	return b.String()
+}
+
+// output is an output file, or the standard input of a filter command
+// run with FILE set to the file's name.
+type output struct {
+	s    *splitter
+	name string
+	w    *bufio.Writer
+	f    *os.File
+	pipe io.WriteCloser
+	cmd  *exec.Cmd
+}
+
+// create starts the next output file and writes the header to it.
+func (s *splitter) create() *output {
+	o := &output{s: s, name: s.nextName()}
+	if s.Filter != "" {
+		if s.Verbose {
+			fmt.Printf("executing with FILE=%s\n", o.name)
+		}
+		shell := os.Getenv("SHELL")
+		if shell == "" {
+			shell = "/bin/sh"
+		}
+		o.cmd = exec.Command(shell, "-c", s.Filter)
+		o.cmd.Env = append(os.Environ(), "FILE="+o.name)
+		o.cmd.Stdout, o.cmd.Stderr = os.Stdout, os.Stderr
+		var err error
+		if o.pipe, err = o.cmd.StdinPipe(); err == nil {
+			err = o.cmd.Start()
+		}
+		if err != nil {
+			s.fail("failed to run command: \"%s -c %s\": %s", shell, s.Filter, s.ErrText(err))
+		}
+		o.w = bufio.NewWriter(o.pipe)
+	} else {
+		if s.Verbose {
+			fmt.Printf("creating file '%s'\n", o.name)
+		}
+		if info, err := os.Stat(o.name); err == nil && s.inputInfo != nil && os.SameFile(info, s.inputInfo) {
+			s.fail("'%s' would overwrite input; aborting", o.name)
+		}
+		f, err := os.Create(o.name)
+		if err != nil {
+			s.fail("%s: %s", o.name, s.ErrText(err))
+		}
+		o.f = f
+		o.w = bufio.NewWriter(f)
+	}
+	o.write(s.header)
+	return o
+}
+
+func (o *output) write(b []byte) {
+	_, err := o.w.Write(b)
+	if err == nil && o.s.Unbuffered {
+		err = o.w.Flush()
+	}
+	o.check(err)
+}
+
+// check reports a write error, except that a filter may stop reading
+// early.
+func (o *output) check(err error) {
+	if err != nil && !(o.cmd != nil && errors.Is(err, syscall.EPIPE)) {
+		o.s.fail("%s: %s", o.name, o.s.ErrText(err))
+	}
+}
+
+func (o *output) close() {
+	if o == nil {
+		return
+	}
+	s := o.s
+	o.check(o.w.Flush())
+	if o.cmd == nil {
+		if err := o.f.Close(); err != nil {
+			s.fail("%s: %s", o.name, s.ErrText(err))
+		}
+		return
+	}
+	o.pipe.Close()
+	if err := o.cmd.Wait(); err != nil {
+		var exit *exec.ExitError
+		if !errors.As(err, &exit) {
+			s.fail("%s", s.ErrText(err))
+		}
+		if ws, ok := exit.Sys().(syscall.WaitStatus); ok && ws.Signaled() {
+			if ws.Signal() == syscall.SIGPIPE {
+				return
+			}
+			s.fail("with FILE=%s, signal %s from command: %s", o.name, ws.Signal(), s.Filter)
+		}
+		panic(&Error{
+			Msg:    fmt.Sprintf("with FILE=%s, exit %d from command: %s", o.name, exit.ExitCode(), s.Filter),
+			Status: exit.ExitCode(),
+		})
+	}
+}
+
+// readRecord returns the next record, with its separator if it has one.
+func (s *splitter) readRecord(r *bufio.Reader) ([]byte, bool) {
+	rec, err := r.ReadBytes(s.Separator)
+	if err != nil && err != io.EOF {
+		s.fail("read error: %s", s.ErrText(err))
+	}
+	return rec, len(rec) > 0
+}
+
+// splitLines puts n records in each file.
+func (s *splitter) splitLines(r *bufio.Reader, n int64) {
+	var o *output
+	count := int64(0)
+	for {
+		rec, ok := s.readRecord(r)
+		if !ok {
+			break
+		}
+		if o == nil || count == n {
+			o.close()
+			o, count = s.create(), 0
+		}
+		o.write(rec)
+		count++
+	}
+	o.close()
+}
+
+// splitBytes puts n bytes in each file.
+func (s *splitter) splitBytes(r *bufio.Reader, n int64) {
+	var o *output
+	left := int64(0)
+	buf := make([]byte, 64*1024)
+	for {
+		m, err := r.Read(buf)
+		for data := buf[:m]; len(data) > 0; {
+			if o == nil || left == 0 {
+				o.close()
+				o, left = s.create(), n
+			}
+			k := min(left, int64(len(data)))
+			o.write(data[:k])
+			data, left = data[k:], left-k
+		}
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			s.fail("read error: %s", s.ErrText(err))
+		}
+	}
+	o.close()
+}
+
+// splitLineBytes puts as many whole records as fit in n bytes in each
+// file. Records longer than that are split into n-byte pieces, and the
+// records after the last piece may share its file.
+func (s *splitter) splitLineBytes(r *bufio.Reader, n int64) {
+	var pending []byte
+	buf := make([]byte, 64*1024)
+	eof := false
+	for {
+		for !eof && int64(len(pending)) <= n {
+			m, err := r.Read(buf)
+			pending = append(pending, buf[:m]...)
+			if err == io.EOF {
+				eof = true
+			} else if err != nil {
+				s.fail("read error: %s", s.ErrText(err))
+			}
+		}
+		if len(pending) == 0 {
+			return
+		}
+		k := len(pending)
+		if int64(k) > n {
+			k = int(n)
+			if i := bytes.LastIndexByte(pending[:k], s.Separator); i >= 0 {
+				k = i + 1
+			}
+		}
+		o := s.create()
+		o.write(pending[:k])
+		o.close()
+		pending = append(pending[:0], pending[k:]...)
+	}
+}
+
+// splitChunks makes n files of about equal size, the last one taking what
+// is left over.
+func (s *splitter) splitChunks(r *bufio.Reader, size int64, c Chunks) {
+	cs := max(1, size/c.N)
+	stdout := bufio.NewWriter(os.Stdout)
+	defer stdout.Flush()
+	for i := int64(1); i <= c.N; i++ {
+		start, end := min((i-1)*cs, size), min(i*cs, size)
+		if i == c.N {
+			end = size
+		}
+		switch {
+		case c.K == 0:
+			if end > start || !s.Elide {
+				o := s.create()
+				if _, err := io.CopyN(o.w, r, end-start); err != io.EOF {
+					o.check(err)
+				}
+				o.close()
+			}
+		case i == c.K:
+			stdout.Write(s.header)
+			io.CopyN(stdout, r, end-start)
+			return
+		default:
+			io.CopyN(io.Discard, r, end-start)
+		}
+	}
+}
+
+// splitLineChunks makes n files as splitChunks does, but ends each one at
+// the end of the record holding its last byte. Records can be long enough
+// to leave later chunks empty.
+func (s *splitter) splitLineChunks(r *bufio.Reader, size int64, c Chunks) {
+	cs := max(1, size/c.N)
+	stdout := bufio.NewWriter(os.Stdout)
+	defer stdout.Flush()
+	if c.K == 1 {
+		stdout.Write(s.header)
+	}
+	var o *output
+	start := func() {
+		if c.K == 0 && !s.Elide {
+			o = s.create()
+		}
+	}
+	cur, chunkEnd, written := int64(1), cs, int64(0)
+	if c.N == 1 {
+		chunkEnd = size
+	}
+	start()
+	for {
+		rec, ok := s.readRecord(r)
+		if !ok {
+			break
+		}
+		switch {
+		case c.K == 0:
+			if o == nil {
+				o = s.create()
+			}
+			o.write(rec)
+		case cur == c.K:
+			stdout.Write(rec)
+		}
+		written += int64(len(rec))
+		for cur < c.N && written >= chunkEnd {
+			o.close()
+			o = nil
+			if cur++; cur == c.N {
+				chunkEnd = size
+			} else {
+				chunkEnd += cs
+			}
+			if c.K != 0 && cur > c.K {
+				return
+			}
+			if cur == c.K {
+				stdout.Write(s.header)
+			}
+			start()
+		}
+	}
+	o.close()
+	for ; cur < c.N && c.K == 0 && !s.Elide; cur++ {
+		s.create().close()
+	}
+}
+
+// splitRoundRobin deals the records out to n files in turn.
+func (s *splitter) splitRoundRobin(r *bufio.Reader, c Chunks) {
+	outs := make([]*output, c.N)
+	if c.K == 0 && !s.Elide {
+		for i := range outs {
+			outs[i] = s.create()
+		}
+	}
+	stdout := bufio.NewWriter(os.Stdout)
+	defer stdout.Flush()
+	if c.K != 0 {
+		stdout.Write(s.header)
+	}
+	for i := int64(0); ; i = (i + 1) % c.N {
+		rec, ok := s.readRecord(r)
+		if !ok {
+			break
+		}
+		switch {
+		case c.K == 0:
+			if outs[i] == nil {
+				outs[i] = s.create()
+			}
+			outs[i].write(rec)
+		case i == c.K-1:
+			stdout.Write(rec)
+		}
+	}
+	for _, o := range outs {
+		o.close()
+	}
+}
@@ -0,0 +1,80 @@
+package statfmt
+
+import (
+	"runtime"
+	"syscall"
+	"time"
+	"unsafe"
+)
+
+// atFdcwd, atSymlinkNofollow, atEmptyPath and statxBtime are the Linux
+// AT_FDCWD, AT_SYMLINK_NOFOLLOW, AT_EMPTY_PATH and STATX_BTIME, which the
+// syscall package lacks.
+const (
+	atFdcwd           = -0x64
+	atSymlinkNofollow = 0x100
+	atEmptyPath       = 0x1000
+	statxBtime        = 0x800
+)
+
+// statxCall is the number of the statx system call on each architecture
+// that has it. Elsewhere birth times are unknown.
+var statxCall = map[string]uintptr{
+	"amd64": 332, "386": 383, "arm64": 291, "arm": 397, "riscv64": 291,
+	"loong64": 291, "ppc64": 383, "ppc64le": 383, "s390x": 379,
+}
+
+// statxTimestamp and statxData lay out struct statx_timestamp and the
+// start of struct statx, padded to the kernel's 256 bytes.
+type statxTimestamp struct {
+	Sec  int64
+	Nsec uint32
+	_    int32
+}
+
+type statxData struct {
+	Mask           uint32
+	Blksize        uint32
+	Attributes     uint64
+	Nlink          uint32
+	Uid            uint32
+	Gid            uint32
+	Mode           uint16
+	_              uint16
+	Ino            uint64
+	Size           uint64
+	Blocks         uint64
+	AttributesMask uint64
+	Atime          statxTimestamp
+	Btime          statxTimestamp
+	Ctime          statxTimestamp
+	Mtime          statxTimestamp
+	_              [16]uint64
+}
+
+// birthTime returns when name, or standard input for "-", was created, if
+// the kernel and file system record it.
+func birthTime(name string, follow bool) (time.Time, bool) {
+	nr, ok := statxCall[runtime.GOARCH]
+	if !ok {
+		return time.Time{}, false
+	}
+	fd, flags := atFdcwd, atSymlinkNofollow
+	if follow {
+		flags = 0
+	}
+	if name == "-" {
+		fd, flags, name = 0, atEmptyPath, ""
+	}
+	p, err := syscall.BytePtrFromString(name)
+	if err != nil {
+		return time.Time{}, false
+	}
+	var stx statxData
+	_, _, errno := syscall.Syscall6(nr, uintptr(fd), uintptr(unsafe.Pointer(p)), uintptr(flags),
+		statxBtime, uintptr(unsafe.Pointer(&stx)), 0)
+	if errno != 0 || stx.Mask&statxBtime == 0 {
+		return time.Time{}, false
+	}
+	return time.Unix(stx.Btime.Sec, int64(stx.Btime.Nsec)), true
+}
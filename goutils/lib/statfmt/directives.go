@@ -0,0 +1,359 @@
+package statfmt
+
+import (
+	"fmt"
+	"os/user"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"goutils/lib/datetime"
+)
+
+// fsTypes names file system magic numbers as GNU stat does.
+var fsTypes = map[int64]string{
+	0xadf5: "adfs", 0xadff: "affs", 0x5346414f: "afs", 0x0187: "autofs",
+	0x62646576: "bdevfs", 0x42465331: "befs", 0x1badface: "bfs",
+	0xcafe4a11: "bpf_fs", 0x9123683e: "btrfs", 0x27e0eb: "cgroupfs",
+	0x63677270: "cgroup2fs", 0xff534d42: "cifs", 0x73757245: "coda",
+	0x28cd3d45: "cramfs", 0x64626720: "debugfs", 0x1373: "devfs",
+	0x1cd1: "devpts", 0xf15f: "ecryptfs", 0xde5e81e4: "efivarfs",
+	0x137d: "ext", 0xef51: "ext2", 0xef53: "ext2/ext3", 0x2011bab0: "exfat",
+	0xf2f52010: "f2fs", 0x4006: "fat", 0x65735546: "fuseblk",
+	0x65735543: "fusectl", 0x01161970: "gfs/gfs2", 0x4244: "hfs",
+	0x482b: "hfs+", 0x958458f6: "hugetlbfs", 0x9660: "isofs",
+	0x72b6: "jffs2", 0x3153464a: "jfs", 0x137f: "minix", 0x138f: "minix (30 char.)",
+	0x2468: "minix v2", 0x2478: "minix v2 (30 char.)", 0x4d5a: "minix3",
+	0x19800202: "mqueue", 0x4d44: "msdos", 0x6969: "nfs", 0x6e667364: "nfsd",
+	0x3434: "nilfs", 0x6e736673: "nsfs", 0x5346544e: "ntfs",
+	0x7461636f: "ocfs2", 0x794c7630: "overlayfs", 0x50495045: "pipefs",
+	0x9fa0: "proc", 0x6165676c: "pstorefs", 0x002f: "qnx4", 0x68191122: "qnx6",
+	0x858458f6: "ramfs", 0x52654973: "reiserfs", 0x73636673: "securityfs",
+	0xf97cff8c: "selinux", 0x43415d53: "smackfs", 0xfe534d42: "smb2",
+	0x517b: "smb", 0x534f434b: "sockfs", 0x73717368: "squashfs",
+	0x62656572: "sysfs", 0x012ff7b6: "sysv2", 0x012ff7b5: "sysv4",
+	0x01021994: "tmpfs", 0x74726163: "tracefs", 0x15013346: "udf",
+	0x00011954: "ufs", 0x9fa2: "usbdevfs", 0x01021997: "v9fs",
+	0xa501fcf5: "vxfs", 0xabba1974: "xenfs", 0x012ff7b4: "xenix",
+	0x58465342: "xfs", 0x012fd16d: "xia", 0x2fc12fc1: "zfs",
+}
+
+var (
+	userNames  = map[uint32]string{}
+	groupNames = map[uint32]string{}
+)
+
+func userName(uid uint32) string {
+	if n, ok := userNames[uid]; ok {
+		return n
+	}
+	n := "UNKNOWN"
+	if u, err := user.LookupId(strconv.Itoa(int(uid))); err == nil {
+		n = u.Username
+	}
+	userNames[uid] = n
+	return n
+}
+
+func groupName(gid uint32) string {
+	if n, ok := groupNames[gid]; ok {
+		return n
+	}
+	n := "UNKNOWN"
+	if g, err := user.LookupGroupId(strconv.Itoa(int(gid))); err == nil {
+		n = g.Name
+	}
+	groupNames[gid] = n
+	return n
+}
+
+// major and minor split a Linux device number.
+func major(dev uint64) uint64 { return dev>>8&0xfff | dev>>32&^0xfff }
+
+func minor(dev uint64) uint64 { return dev&0xff | dev>>12&^0xff }
+
+func fileType(st *syscall.Stat_t) string {
+	switch st.Mode & syscall.S_IFMT {
+	case syscall.S_IFREG:
+		if st.Size == 0 {
+			return "regular empty file"
+		}
+		return "regular file"
+	case syscall.S_IFDIR:
+		return "directory"
+	case syscall.S_IFLNK:
+		return "symbolic link"
+	case syscall.S_IFIFO:
+		return "fifo"
+	case syscall.S_IFSOCK:
+		return "socket"
+	case syscall.S_IFBLK:
+		return "block special file"
+	case syscall.S_IFCHR:
+		return "character special file"
+	}
+	return "weird file"
+}
+
+// modeString formats st_mode as ls -l does.
+func modeString(mode uint32) string {
+	b := []byte("?---------")
+	switch mode & syscall.S_IFMT {
+	case syscall.S_IFREG:
+		b[0] = '-'
+	case syscall.S_IFDIR:
+		b[0] = 'd'
+	case syscall.S_IFLNK:
+		b[0] = 'l'
+	case syscall.S_IFIFO:
+		b[0] = 'p'
+	case syscall.S_IFSOCK:
+		b[0] = 's'
+	case syscall.S_IFBLK:
+		b[0] = 'b'
+	case syscall.S_IFCHR:
+		b[0] = 'c'
+	}
+	const rwx = "rwxrwxrwx"
+	for i := 0; i < 9; i++ {
+		if mode&(1<<uint(8-i)) != 0 {
+			b[i+1] = rwx[i]
+		}
+	}
+	special := func(pos int, set bool, c byte) {
+		if !set {
+			return
+		}
+		if b[pos] == 'x' {
+			b[pos] = c
+		} else {
+			b[pos] = c - 'a' + 'A'
+		}
+	}
+	special(3, mode&syscall.S_ISUID != 0, 's')
+	special(6, mode&syscall.S_ISGID != 0, 's')
+	special(9, mode&syscall.S_ISVTX != 0, 't')
+	return string(b)
+}
+
+// shellQuote quotes s for the shell as %N does, with control characters
+// in $'...' escapes.
+func shellQuote(s string) string {
+	var b strings.Builder
+	b.WriteByte('\'')
+	for i := 0; i < len(s); i++ {
+		switch c := s[i]; {
+		case c == '\'':
+			b.WriteString(`'\''`)
+		case c < ' ' || c == 0x7f:
+			fmt.Fprintf(&b, `'$'\%03o''`, c)
+		default:
+			b.WriteByte(c)
+		}
+	}
+	b.WriteByte('\'')
+	return b.String()
+}
+
+// mountPoint walks up from name while the directories are on dev.
+func mountPoint(name string, dev uint64) string {
+	path, err := filepath.Abs(name)
+	if err != nil {
+		return "?"
+	}
+	if dir, err := filepath.EvalSymlinks(filepath.Dir(path)); err == nil {
+		path = filepath.Join(dir, filepath.Base(path))
+	}
+	for path != "/" {
+		parent := filepath.Dir(path)
+		var st syscall.Stat_t
+		if syscall.Stat(parent, &st) != nil || uint64(st.Dev) != dev {
+			break
+		}
+		path = parent
+	}
+	return path
+}
+
+func humanTime(t time.Time) string {
+	return t.In(datetime.Local()).Format("2006-01-02 15:04:05.000000000 -0700")
+}
+
+// seconds formats a time as seconds since the epoch with prec digits of
+// fraction, or none when prec is negative.
+func seconds(sec, nsec int64, prec int) string {
+	if prec < 0 {
+		return strconv.FormatInt(sec, 10)
+	}
+	sign := ""
+	if sec < 0 && nsec > 0 {
+		sec, nsec = -(sec + 1), 1e9-nsec
+		sign = "-"
+	}
+	frac := fmt.Sprintf("%09d", nsec) + strings.Repeat("0", max(prec-9, 0))
+	s := sign + strconv.FormatInt(sec, 10)
+	if prec > 0 {
+		s += "." + frac[:prec]
+	}
+	return s
+}
+
+// field is the expansion of a directive: text, or a number to be printed
+// in verb d, o or x.
+type field struct {
+	text string
+	num  uint64
+	neg  bool
+	verb byte
+}
+
+func text(s string) field { return field{text: s} }
+
+func num(n uint64) field { return field{num: n, verb: 'd'} }
+
+func signed(n int64) field {
+	if n < 0 {
+		return field{num: uint64(-n), neg: true, verb: 'd'}
+	}
+	return num(uint64(n))
+}
+
+func hex(n uint64) field { return field{num: n, verb: 'x'} }
+
+func timespec(ts syscall.Timespec, prec int) field {
+	if prec < 0 {
+		return signed(int64(ts.Sec))
+	}
+	return text(seconds(int64(ts.Sec), int64(ts.Nsec), prec))
+}
+
+// fileField expands the file directive c, which is "Hd", "Ld", "Hr" or
+// "Lr" for device numbers.
+func fileField(f *File, c string, prec int) field {
+	st := &f.Stat
+	switch c {
+	case "a":
+		return field{num: uint64(st.Mode & 07777), verb: 'o'}
+	case "A":
+		return text(modeString(st.Mode))
+	case "b":
+		return signed(int64(st.Blocks))
+	case "B":
+		return num(512)
+	case "d":
+		return num(uint64(st.Dev))
+	case "D":
+		return hex(uint64(st.Dev))
+	case "Hd":
+		return num(major(uint64(st.Dev)))
+	case "Ld":
+		return num(minor(uint64(st.Dev)))
+	case "f":
+		return hex(uint64(st.Mode))
+	case "F":
+		return text(fileType(st))
+	case "g":
+		return num(uint64(st.Gid))
+	case "G":
+		return text(groupName(st.Gid))
+	case "h":
+		return num(uint64(st.Nlink))
+	case "i":
+		return num(uint64(st.Ino))
+	case "m":
+		return text(mountPoint(f.Name, uint64(st.Dev)))
+	case "n":
+		return text(f.Name)
+	case "N":
+		if f.Target != "" {
+			return text(shellQuote(f.Name) + " -> " + shellQuote(f.Target))
+		}
+		return text(shellQuote(f.Name))
+	case "o":
+		return signed(int64(st.Blksize))
+	case "r":
+		return num(uint64(st.Rdev))
+	case "R":
+		return hex(uint64(st.Rdev))
+	case "Hr":
+		return num(major(uint64(st.Rdev)))
+	case "Lr":
+		return num(minor(uint64(st.Rdev)))
+	case "s":
+		return signed(int64(st.Size))
+	case "t":
+		return hex(major(uint64(st.Rdev)))
+	case "T":
+		return hex(minor(uint64(st.Rdev)))
+	case "u":
+		return num(uint64(st.Uid))
+	case "U":
+		return text(userName(st.Uid))
+	case "w":
+		if f.Birth.IsZero() {
+			return text("-")
+		}
+		return text(humanTime(f.Birth))
+	case "W":
+		if f.Birth.IsZero() {
+			return text("0")
+		}
+		if prec < 0 {
+			return signed(f.Birth.Unix())
+		}
+		return text(seconds(f.Birth.Unix(), int64(f.Birth.Nanosecond()), prec))
+	case "x":
+		return text(humanTime(time.Unix(int64(st.Atim.Sec), int64(st.Atim.Nsec))))
+	case "X":
+		return timespec(st.Atim, prec)
+	case "y":
+		return text(humanTime(time.Unix(int64(st.Mtim.Sec), int64(st.Mtim.Nsec))))
+	case "Y":
+		return timespec(st.Mtim, prec)
+	case "z":
+		return text(humanTime(time.Unix(int64(st.Ctim.Sec), int64(st.Ctim.Nsec))))
+	case "Z":
+		return timespec(st.Ctim, prec)
+	}
+	return text("?")
+}
+
+// fsField expands the file system directive c.
+func fsField(f *FS, c string) field {
+	st := &f.Stat
+	switch c {
+	case "a":
+		return num(uint64(st.Bavail))
+	case "b":
+		return num(uint64(st.Blocks))
+	case "c":
+		return num(uint64(st.Files))
+	case "d":
+		return num(uint64(st.Ffree))
+	case "f":
+		return num(uint64(st.Bfree))
+	case "i":
+		return hex(uint64(uint32(st.Fsid.X__val[0]))<<32 | uint64(uint32(st.Fsid.X__val[1])))
+	case "l":
+		return signed(int64(st.Namelen))
+	case "n":
+		return text(f.Name)
+	case "s":
+		return signed(int64(st.Bsize))
+	case "S":
+		if st.Frsize == 0 {
+			return signed(int64(st.Bsize))
+		}
+		return signed(int64(st.Frsize))
+	case "t":
+		return hex(uint64(uint32(st.Type)))
+	case "T":
+		if name, ok := fsTypes[int64(uint32(st.Type))]; ok {
+			return text(name)
+		}
+		return text(fmt.Sprintf("UNKNOWN (0x%x)", uint32(st.Type)))
+	}
+	return text("?")
+}
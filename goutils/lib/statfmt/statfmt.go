@@ -0,0 +1,185 @@
+// Package statfmt is the directive engine of stat, shared by the cmd tool
+// and coreutils: it reads the status of files and file systems, including
+// birth times through statx, and expands GNU stat's %-directives and
+// default layouts from it.
+package statfmt
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// File is what the file directives are expanded from.
+type File struct {
+	Name   string
+	Stat   syscall.Stat_t
+	Birth  time.Time // zero when unknown
+	Target string    // for symbolic links
+}
+
+// FS is what the file system directives are expanded from.
+type FS struct {
+	Name string
+	Stat syscall.Statfs_t
+}
+
+// StatFile reads the status of name, or of standard input for "-",
+// following a symbolic link only when follow is set.
+func StatFile(name string, follow bool) (*File, error) {
+	f := &File{Name: name}
+	var err error
+	switch {
+	case name == "-":
+		err = syscall.Fstat(0, &f.Stat)
+	case follow:
+		err = syscall.Stat(name, &f.Stat)
+	default:
+		err = syscall.Lstat(name, &f.Stat)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if f.Stat.Mode&syscall.S_IFMT == syscall.S_IFLNK {
+		f.Target, _ = os.Readlink(name)
+	}
+	f.Birth, _ = birthTime(name, follow)
+	return f, nil
+}
+
+// StatFS reads the status of the file system name is on.
+func StatFS(name string) (*FS, error) {
+	f := &FS{Name: name}
+	if err := syscall.Statfs(name, &f.Stat); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+// The default layouts, as GNU stat prints them.
+const (
+	fileFormat = "  Size: %-10s\tBlocks: %-10b IO Block: %-6o %F\n" +
+		"Device: %Hd,%Ld\tInode: %-11i Links: %h\n" +
+		"Access: (%04a/%10.10A)  Uid: (%5u/%8U)   Gid: (%5g/%8G)\n" +
+		"Access: %x\nModify: %y\nChange: %z\n Birth: %w\n"
+	deviceFormat = "  Size: %-10s\tBlocks: %-10b IO Block: %-6o %F\n" +
+		"Device: %Hd,%Ld\tInode: %-11i Links: %-5h Device type: %Hr,%Lr\n" +
+		"Access: (%04a/%10.10A)  Uid: (%5u/%8U)   Gid: (%5g/%8G)\n" +
+		"Access: %x\nModify: %y\nChange: %z\n Birth: %w\n"
+	fsFormat = "  File: \"%n\"\n" +
+		"    ID: %-8i Namelen: %-7l Type: %T\n" +
+		"Block size: %-10s Fundamental block size: %S\n" +
+		"Blocks: Total: %-10b Free: %-10f Available: %a\n" +
+		"Inodes: Total: %-10c Free: %d\n"
+	terseFormat   = "%n %s %b %f %u %g %D %i %h %t %T %X %Y %Z %W %o\n"
+	terseFsFormat = "%n %i %l %t %s %S %b %f %a %c %d\n"
+)
+
+// Layout returns the format stat uses for f when none is given: the
+// terse one, or the long one, whose first line shows f's name and link
+// target unquoted.
+func (f *File) Layout(terse bool) string {
+	if terse {
+		return terseFormat
+	}
+	first := "  File: " + f.Name
+	if f.Target != "" {
+		first += " -> " + f.Target
+	}
+	layout := fileFormat
+	if t := f.Stat.Mode & syscall.S_IFMT; t == syscall.S_IFCHR || t == syscall.S_IFBLK {
+		layout = deviceFormat
+	}
+	return strings.ReplaceAll(first, "%", "%%") + "\n" + layout
+}
+
+// Layout returns the format stat -f uses when none is given.
+func (f *FS) Layout(terse bool) string {
+	if terse {
+		return terseFsFormat
+	}
+	return fsFormat
+}
+
+// Expand writes format with the file directives expanded for f.
+func (f *File) Expand(out *bufio.Writer, format string) {
+	expand(out, format, func(c string, prec int) field { return fileField(f, c, prec) })
+}
+
+// Expand writes format with the file system directives expanded for f.
+func (f *FS) Expand(out *bufio.Writer, format string) {
+	expand(out, format, func(c string, prec int) field { return fsField(f, c) })
+}
+
+// expand writes format with each directive replaced by what lookup
+// returns for it. Directives take printf's flags, width and precision;
+// the precision of the seconds directives is the number of fraction
+// digits, 9 when given as just a dot.
+func expand(out *bufio.Writer, format string, lookup func(c string, prec int) field) {
+	for i := 0; i < len(format); i++ {
+		if format[i] != '%' {
+			out.WriteByte(format[i])
+			continue
+		}
+		j := i + 1
+		for j < len(format) && strings.IndexByte("-+ #0'", format[j]) >= 0 {
+			j++
+		}
+		flags := strings.ReplaceAll(format[i+1:j], "'", "")
+		w := j
+		for j < len(format) && isDigit(format[j]) {
+			j++
+		}
+		width := format[w:j]
+		prec := -1
+		if j < len(format) && format[j] == '.' {
+			j++
+			p := j
+			for j < len(format) && isDigit(format[j]) {
+				j++
+			}
+			prec = 9
+			if j > p {
+				prec, _ = strconv.Atoi(format[p:j])
+			}
+		}
+		if j == len(format) {
+			out.WriteString(format[i:])
+			return
+		}
+		c := format[j : j+1]
+		if (c == "H" || c == "L") && j+1 < len(format) && (format[j+1] == 'd' || format[j+1] == 'r') {
+			j++
+			c += format[j : j+1]
+		}
+		i = j
+		if c == "%" {
+			out.WriteByte('%')
+			continue
+		}
+		f := lookup(c, prec)
+		if f.verb == 0 {
+			spec := "%" + strings.ReplaceAll(flags, "0", "") + width
+			if prec >= 0 && !strings.Contains("XYZW", c) {
+				spec += "." + strconv.Itoa(prec)
+			}
+			fmt.Fprintf(out, spec+"s", f.text)
+			continue
+		}
+		spec := "%" + flags + width
+		if prec >= 0 {
+			spec += "." + strconv.Itoa(prec)
+		}
+		if f.neg {
+			fmt.Fprintf(out, spec+string(f.verb), -int64(f.num))
+		} else {
+			fmt.Fprintf(out, spec+string(f.verb), f.num)
+		}
+	}
+}
+
+func isDigit(c byte) bool { return c >= '0' && c <= '9' }
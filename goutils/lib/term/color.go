@@ -0,0 +1,337 @@
+package term
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// ColorMode is the WHEN of a --color[=WHEN] option.
+type ColorMode int
+
+const (
+	ColorAuto ColorMode = iota
+	ColorAlways
+	ColorNever
+)
+
+// ParseColorMode parses the GNU spellings of WHEN: auto, tty or if-tty;
+// always, yes or force; never, no or none.
+func ParseColorMode(s string) (ColorMode, error) {
+	switch s {
+	case "auto", "tty", "if-tty":
+		return ColorAuto, nil
+	case "always", "yes", "force", "true":
+		return ColorAlways, nil
+	case "never", "no", "none", "false":
+		return ColorNever, nil
+	}
+	return ColorAuto, fmt.Errorf("invalid argument %q for --color (want auto, always or never)", s)
+}
+
+func (m ColorMode) String() string {
+	switch m {
+	case ColorAlways:
+		return "always"
+	case ColorNever:
+		return "never"
+	}
+	return "auto"
+}
+
+// Set implements flag.Value. A bare --color means always.
+func (m *ColorMode) Set(s string) error {
+	v, err := ParseColorMode(s)
+	if err == nil {
+		*m = v
+	}
+	return err
+}
+
+// IsBoolFlag lets --color appear without a value.
+func (m *ColorMode) IsBoolFlag() bool { return true }
+
+type noColorFlag struct{ m *ColorMode }
+
+func (f noColorFlag) String() string { return "false" }
+
+func (f noColorFlag) Set(s string) error {
+	on, err := strconv.ParseBool(s)
+	if err == nil && on {
+		*f.m = ColorNever
+	}
+	return err
+}
+
+func (f noColorFlag) IsBoolFlag() bool { return true }
+
+// ColorFlag defines --color[=WHEN] and --no-color on the command line
+// and returns the mode they set, ColorAuto by default.
+func ColorFlag() *ColorMode {
+	m := new(ColorMode)
+	flag.Var(m, "color", "colour the output: `WHEN` is auto, always or never")
+	flag.Var(noColorFlag{m}, "no-color", "same as --color=never")
+	return m
+}
+
+// ColorArgs removes --color[=WHEN] and --no-color from the arguments of
+// a command that parses its own, returning the mode they set and the
+// remaining arguments.
+func ColorArgs(args []string) (ColorMode, []string, error) {
+	m := ColorAuto
+	var rest []string
+	for i, a := range args {
+		switch {
+		case a == "--":
+			return m, append(rest, args[i:]...), nil
+		case a == "--no-color":
+			m = ColorNever
+		case a == "--color":
+			m = ColorAlways
+		case strings.HasPrefix(a, "--color="):
+			v, err := ParseColorMode(a[len("--color="):])
+			if err != nil {
+				return m, nil, err
+			}
+			m = v
+		default:
+			rest = append(rest, a)
+		}
+	}
+	return m, rest, nil
+}
+
+// Enabled reports whether output to f should be coloured. In auto mode
+// colour is off when NO_COLOR is set or CLICOLOR is 0, forced on when
+// CLICOLOR_FORCE is set, and otherwise on for terminals other than dumb
+// ones. An explicit always or never wins over the environment.
+func (m ColorMode) Enabled(f *os.File) bool {
+	switch m {
+	case ColorAlways:
+		return true
+	case ColorNever:
+		return false
+	}
+	if os.Getenv("NO_COLOR") != "" {
+		return false
+	}
+	if v := os.Getenv("CLICOLOR_FORCE"); v != "" && v != "0" {
+		return true
+	}
+	if os.Getenv("CLICOLOR") == "0" {
+		return false
+	}
+	return IsTerminal(f) && os.Getenv("TERM") != "dumb"
+}
+
+// Profile returns the colours to use for output to f: NoColor when
+// colour is disabled, else what the terminal supports.
+func (m ColorMode) Profile(f *os.File) Profile {
+	if !m.Enabled(f) {
+		return NoColor
+	}
+	return EnvProfile()
+}
+
+// Profile is a level of colour support.
+type Profile int
+
+const (
+	NoColor   Profile = iota
+	ANSI              // the 16 standard colours
+	ANSI256           // the xterm 256-colour palette
+	TrueColor         // 24-bit RGB
+)
+
+// EnvProfile guesses the colour support of the terminal from COLORTERM
+// and TERM.
+func EnvProfile() Profile {
+	switch strings.ToLower(os.Getenv("COLORTERM")) {
+	case "truecolor", "24bit":
+		return TrueColor
+	}
+	t := os.Getenv("TERM")
+	switch {
+	case strings.Contains(t, "truecolor"), strings.Contains(t, "24bit"), strings.HasSuffix(t, "-direct"):
+		return TrueColor
+	case strings.Contains(t, "256color"):
+		return ANSI256
+	}
+	return ANSI
+}
+
+// SGR parameters for Paint.
+const (
+	Bold      = "1"
+	Dim       = "2"
+	Italic    = "3"
+	Underline = "4"
+	Reverse   = "7"
+	Strike    = "9"
+
+	Black   = "30"
+	Red     = "31"
+	Green   = "32"
+	Yellow  = "33"
+	Blue    = "34"
+	Magenta = "35"
+	Cyan    = "36"
+	White   = "37"
+	Gray    = "90"
+)
+
+// Seq returns the escape sequence that selects the SGR parameters, or ""
+// without colour.
+func (p Profile) Seq(sgr ...string) string {
+	if p == NoColor || len(sgr) == 0 {
+		return ""
+	}
+	return "\033[" + strings.Join(sgr, ";") + "m"
+}
+
+// ResetSeq returns the sequence that ends a style, or "" without colour.
+func (p Profile) ResetSeq() string {
+	if p == NoColor {
+		return ""
+	}
+	return "\033[0m"
+}
+
+// Paint wraps s in the style given by the SGR parameters.
+func (p Profile) Paint(s string, sgr ...string) string {
+	if p == NoColor || s == "" || len(sgr) == 0 {
+		return s
+	}
+	return p.Seq(sgr...) + s + "\033[0m"
+}
+
+// Color is an xterm palette index or an RGB colour.
+type Color struct {
+	rgb     bool
+	index   uint8
+	r, g, b uint8
+}
+
+// Index returns colour n of the 256-colour palette; 0-15 are the
+// standard colours.
+func Index(n uint8) Color { return Color{index: n} }
+
+// RGB returns a 24-bit colour.
+func RGB(r, g, b uint8) Color { return Color{rgb: true, r: r, g: g, b: b} }
+
+// ParseColor parses "#rrggbb", "rrggbb" or a palette index 0-255.
+func ParseColor(s string) (Color, error) {
+	if n, err := strconv.ParseUint(s, 10, 8); err == nil {
+		return Index(uint8(n)), nil
+	}
+	h := strings.TrimPrefix(s, "#")
+	if v, err := strconv.ParseUint(h, 16, 32); err == nil && len(h) == 6 {
+		return RGB(uint8(v>>16), uint8(v>>8), uint8(v)), nil
+	}
+	return Color{}, fmt.Errorf("invalid colour %q", s)
+}
+
+// FG returns the SGR parameters that set c as the foreground colour,
+// downgraded to the profile.
+func (p Profile) FG(c Color) string { return p.color(c, 30) }
+
+// BG returns the SGR parameters that set c as the background colour.
+func (p Profile) BG(c Color) string { return p.color(c, 40) }
+
+func (p Profile) color(c Color, base int) string {
+	switch p {
+	case NoColor:
+		return ""
+	case TrueColor:
+		if c.rgb {
+			return fmt.Sprintf("%d;2;%d;%d;%d", base+8, c.r, c.g, c.b)
+		}
+	case ANSI256:
+		if c.rgb {
+			c = Index(to256(c.r, c.g, c.b))
+		}
+	case ANSI:
+		if c.rgb || c.index >= 16 {
+			r, g, b := c.rgbValue()
+			c = Index(nearest(r, g, b, 16))
+		}
+	}
+	switch {
+	case c.index < 8:
+		return strconv.Itoa(base + int(c.index))
+	case c.index < 16:
+		return strconv.Itoa(base + 60 + int(c.index) - 8)
+	}
+	return fmt.Sprintf("%d;5;%d", base+8, c.index)
+}
+
+// ansi16 is the xterm palette of the standard colours.
+var ansi16 = [16][3]uint8{
+	{0, 0, 0}, {205, 0, 0}, {0, 205, 0}, {205, 205, 0},
+	{0, 0, 238}, {205, 0, 205}, {0, 205, 205}, {229, 229, 229},
+	{127, 127, 127}, {255, 0, 0}, {0, 255, 0}, {255, 255, 0},
+	{92, 92, 255}, {255, 0, 255}, {0, 255, 255}, {255, 255, 255},
+}
+
+var cubeLevels = [6]uint8{0, 95, 135, 175, 215, 255}
+
+func (c Color) rgbValue() (r, g, b uint8) {
+	switch {
+	case c.rgb:
+		return c.r, c.g, c.b
+	case c.index < 16:
+		v := ansi16[c.index]
+		return v[0], v[1], v[2]
+	case c.index < 232:
+		i := c.index - 16
+		return cubeLevels[i/36], cubeLevels[i/6%6], cubeLevels[i%6]
+	}
+	v := 8 + 10*(c.index-232)
+	return v, v, v
+}
+
+// to256 maps an RGB colour to the nearer of its closest colour-cube
+// entry and its closest grey.
+func to256(r, g, b uint8) uint8 {
+	level := func(v uint8) int {
+		switch {
+		case v < 48:
+			return 0
+		case v < 115:
+			return 1
+		}
+		return (int(v) - 35) / 40
+	}
+	cube := uint8(16 + 36*level(r) + 6*level(g) + level(b))
+	avg := (int(r) + int(g) + int(b)) / 3
+	gi := 23
+	if avg < 238 {
+		gi = max((avg-3)/10, 0)
+	}
+	grey := uint8(232 + gi)
+	cr, cg, cb := Index(cube).rgbValue()
+	gr, gg, gb := Index(grey).rgbValue()
+	if dist(r, g, b, gr, gg, gb) < dist(r, g, b, cr, cg, cb) {
+		return grey
+	}
+	return cube
+}
+
+// nearest returns the palette index below n closest to the colour.
+func nearest(r, g, b uint8, n int) uint8 {
+	best, bestDist := 0, -1
+	for i := 0; i < n; i++ {
+		pr, pg, pb := Index(uint8(i)).rgbValue()
+		if d := dist(r, g, b, pr, pg, pb); bestDist < 0 || d < bestDist {
+			best, bestDist = i, d
+		}
+	}
+	return uint8(best)
+}
+
+func dist(r1, g1, b1, r2, g2, b2 uint8) int {
+	dr, dg, db := int(r1)-int(r2), int(g1)-int(g2), int(b1)-int(b2)
+	return dr*dr + dg*dg + db*db
+}
@@ -0,0 +1,92 @@
+// Package term is the terminal layer shared by the cmd tools and
+// coreutils: it tells whether a file is a terminal and how large it is,
+// decides whether to colour output from --color, NO_COLOR and
+// CLICOLOR_FORCE, converts colours to what the terminal supports, and
+// measures and truncates strings by display columns, skipping ANSI escape
+// sequences.
+package term
+
+import (
+	"os"
+	"os/signal"
+	"strconv"
+	"syscall"
+	"unsafe"
+)
+
+// Control sequences for commands that redraw the whole screen.
+const (
+	ClearScreen = "\033[H\033[2J"
+	HideCursor  = "\033[?25l"
+	ShowCursor  = "\033[?25h"
+)
+
+// IsTerminal reports whether f is a terminal.
+func IsTerminal(f *os.File) bool {
+	var t syscall.Termios
+	_, _, errno := syscall.Syscall(syscall.SYS_IOCTL, f.Fd(), syscall.TCGETS, uintptr(unsafe.Pointer(&t)))
+	return errno == 0
+}
+
+type winsize struct {
+	Row, Col, Xpixel, Ypixel uint16
+}
+
+// Size returns the number of columns and rows of the terminal f.
+func Size(f *os.File) (cols, rows int, err error) {
+	var ws winsize
+	_, _, errno := syscall.Syscall(syscall.SYS_IOCTL, f.Fd(), syscall.TIOCGWINSZ, uintptr(unsafe.Pointer(&ws)))
+	if errno != 0 {
+		return 0, 0, errno
+	}
+	return int(ws.Col), int(ws.Row), nil
+}
+
+// Width returns the width of the terminal f, else $COLUMNS, else 80.
+func Width(f *os.File) int {
+	if cols, _, err := Size(f); err == nil && cols > 0 {
+		return cols
+	}
+	if n, err := strconv.Atoi(os.Getenv("COLUMNS")); err == nil && n > 0 {
+		return n
+	}
+	return 80
+}
+
+// Height returns the height of the terminal f, else $LINES, else 24.
+func Height(f *os.File) int {
+	if _, rows, err := Size(f); err == nil && rows > 0 {
+		return rows
+	}
+	if n, err := strconv.Atoi(os.Getenv("LINES")); err == nil && n > 0 {
+		return n
+	}
+	return 24
+}
+
+// WatchSize returns a channel that receives a value whenever the
+// terminal is resized (SIGWINCH), and a function that stops the
+// notifications. Call Size or Width for the new dimensions.
+func WatchSize() (<-chan struct{}, func()) {
+	sig := make(chan os.Signal, 1)
+	c := make(chan struct{}, 1)
+	done := make(chan struct{})
+	signal.Notify(sig, syscall.SIGWINCH)
+	go func() {
+		for {
+			select {
+			case <-sig:
+				select {
+				case c <- struct{}{}:
+				default:
+				}
+			case <-done:
+				return
+			}
+		}
+	}()
+	return c, func() {
+		signal.Stop(sig)
+		close(done)
+	}
+}